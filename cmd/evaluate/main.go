@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+)
+
+func main() {
+	modelPath := flag.String("model", "./models/bot_detection_model", "model file to evaluate")
+	maxFPR := flag.Float64("max-fpr", 0.01, "false positive rate the recommended threshold may not exceed")
+	points := flag.Bool("points", false, "print every point of the threshold sweep")
+	flag.Parse()
+
+	snapshot, err := ml.ReadModelFile(*modelPath)
+	if err != nil {
+		log.Fatalf("Failed to read model: %v", err)
+	}
+	curves, err := snapshot.Curves()
+	if err != nil {
+		log.Fatalf("Failed to evaluate model: %v", err)
+	}
+
+	fmt.Printf("Model:             %s (%s, trained %s)\n", *modelPath, snapshot.ModelType, snapshot.CreatedAt.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Held-out flows:    %d\n", curves.Samples)
+	fmt.Printf("ROC AUC:           %.4f\n", curves.ROCAUC)
+	fmt.Printf("Average precision: %.4f\n", curves.AveragePrecision)
+	fmt.Printf("Brier score:       %.4f\n", curves.BrierScore)
+	if snapshot.Calibration != nil {
+		fmt.Printf("Calibration:       %s\n", snapshot.Calibration.Method)
+	}
+
+	if *points {
+		fmt.Printf("\n%10s %10s %10s %10s\n", "threshold", "tpr", "fpr", "precision")
+		for _, p := range curves.Points {
+			fmt.Printf("%10.4f %10.4f %10.4f %10.4f\n", p.Threshold, p.TruePositiveRate, p.FalsePositiveRate, p.Precision)
+		}
+		fmt.Printf("\n%12s %8s %16s %10s\n", "confidence", "flows", "mean confidence", "bot rate")
+		for _, b := range curves.Calibration {
+			fmt.Printf("%5.1f - %4.1f %8d %16.4f %10.4f\n", b.Lower, b.Upper, b.Samples, b.MeanConfidence, b.BotRate)
+		}
+		fmt.Println()
+	}
+
+	point, err := curves.RecommendThreshold(*maxFPR)
+	if err != nil {
+		log.Fatalf("No recommendation: %v", err)
+	}
+	fmt.Printf("Recommended detection_threshold for a false positive rate of at most %g: %.4f\n", *maxFPR, point.Threshold)
+	fmt.Printf("  detects %.1f%% of bots at a false positive rate of %.2f%% and precision %.1f%%\n",
+		100*point.TruePositiveRate, 100*point.FalsePositiveRate, 100*point.Precision)
+}
@@ -45,6 +45,9 @@ func main() {
 		FeatureSize:        mlConfig.FeatureSize,
 		GenerateFakeData:   mlConfig.GenerateFakeData,
 		FakeDataSize:       mlConfig.FakeDataSize,
+		ModelPath:          mlConfig.ModelPath,
+		SaveModel:          mlConfig.SaveModel,
+		LoadModel:          mlConfig.LoadModel,
 	})
 	if err != nil {
 		log.Fatalf("Failed to initialize ML engine: %v", err)
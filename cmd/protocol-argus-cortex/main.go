@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/api"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/grpcapi"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/reload"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/retention"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/shutdown"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/store"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/argus"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+func main() {
+	configPath := flag.String("config", "config.yml", "configuration file")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	var logLevel slog.LevelVar
+	if err := logLevel.UnmarshalText([]byte(cfg.Logging.Level)); err != nil {
+		log.Fatalf("Invalid log level: %v", err)
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: &logLevel})))
+
+	if err := run(*configPath, cfg, &logLevel); err != nil {
+		slog.Error("Shutdown incomplete", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run starts the engines and API servers of cfg, and shuts them down on
+// SIGINT or SIGTERM
+func run(configPath string, cfg *config.Config, logLevel *slog.LevelVar) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cortexEngine, err := cortex.NewEngine(cfg.Cortex)
+	if err != nil {
+		return err
+	}
+	argusEngine, err := argus.NewEngine(cfg.Capture, cortexEngine)
+	if err != nil {
+		cortexEngine.Close()
+		return err
+	}
+	components := shutdown.Components{Argus: argusEngine, Cortex: cortexEngine}
+	timeout := time.Duration(cfg.Server.ShutdownTimeout) * time.Second
+
+	labels, err := addSinks(cfg, argusEngine)
+	if err != nil {
+		shutdown.Shutdown(components, timeout)
+		return err
+	}
+
+	// The detection store is closed with the engine's other sinks, after
+	// the retention janitor purging it has stopped
+	var detections *store.Store
+	var rules []retention.Rule
+	if cfg.Store.Driver != "" {
+		detections, err = store.Open(cfg.Store)
+		if err != nil {
+			shutdown.Shutdown(components, timeout)
+			return err
+		}
+		argusEngine.AddSink(detections)
+		rules = retention.DetectionRules(cfg.Retention, detections)
+	}
+	if cfg.Capture.ExportDir != "" {
+		rules = append(rules, retention.ExportRule(cfg.Capture.ExportRetention, argusEngine))
+	}
+	components.Janitor, err = retention.NewJanitor(cfg.Retention, rules...)
+	if err != nil {
+		shutdown.Shutdown(components, timeout)
+		return err
+	}
+
+	// The engines keep running through the signal until the shutdown
+	// drains them
+	engineCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := argusEngine.Start(engineCtx); err != nil {
+		shutdown.Shutdown(components, timeout)
+		return err
+	}
+
+	reloader := reload.New(configPath, cfg, cortexEngine, argusEngine, logLevel)
+	go reloader.HandleSignals(ctx)
+
+	// A server failing to start shuts the daemon down
+	failed := make(chan error, 2)
+	components.API = api.NewServer(cfg.Server, cortexEngine, argusEngine)
+	components.API.SetReloader(reloader)
+	if detections != nil {
+		components.API.SetDetectionStore(detections)
+	}
+	if labels != nil {
+		components.API.SetLabelSink(labels)
+	}
+	go func() {
+		if err := components.API.Start(); !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+	if cfg.Server.GRPCPort != 0 {
+		components.GRPC = grpcapi.NewServer(cfg.Server, cortexEngine, argusEngine)
+		go func() {
+			if err := components.GRPC.Start(); err != nil {
+				failed <- err
+			}
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		slog.Info("Received shutdown signal")
+	case err := <-failed:
+		slog.Error("API server failed", "error", err)
+	}
+	return shutdown.Shutdown(components, timeout)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/argus"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink/archive"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink/clickhouse"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink/collector"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink/elasticsearch"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink/kafka"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink/nats"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink/syslog"
+)
+
+// addSinks registers the sinks configured in cfg with the engine, which
+// closes them on shutdown. Sinks without a destination are disabled, and
+// those with an aggregation window roll up their detections. The
+// training data collector is returned for the analyst labels, nil when it
+// is disabled.
+func addSinks(cfg *config.Config, engine *argus.Engine) (*collector.Collector, error) {
+	sinks := cfg.Sinks
+
+	if len(sinks.Kafka.Brokers) > 0 {
+		producer, err := kafka.NewProducer(sinks.Kafka)
+		if err != nil {
+			return nil, err
+		}
+		engine.AddSink(argus.Aggregate(producer, sinks.Kafka.Aggregation))
+	}
+	if sinks.NATS.URL != "" {
+		publisher, err := nats.NewPublisher(sinks.NATS)
+		if err != nil {
+			return nil, err
+		}
+		engine.AddSink(argus.Aggregate(publisher, sinks.NATS.Aggregation))
+	}
+	if sinks.Syslog.Address != "" {
+		writer, err := syslog.NewWriter(sinks.Syslog)
+		if err != nil {
+			return nil, err
+		}
+		engine.AddSink(argus.Aggregate(writer, sinks.Syslog.Aggregation))
+	}
+	if len(sinks.Elasticsearch.URLs) > 0 {
+		indexer, err := elasticsearch.NewIndexer(sinks.Elasticsearch)
+		if err != nil {
+			return nil, err
+		}
+		engine.AddSink(argus.Aggregate(indexer, sinks.Elasticsearch.Aggregation))
+	}
+	if sinks.ClickHouse.URL != "" {
+		writer, err := clickhouse.NewWriter(sinks.ClickHouse)
+		if err != nil {
+			return nil, err
+		}
+		engine.AddSink(writer)
+	}
+	if sinks.Archive.Bucket != "" {
+		archiver, err := archive.NewArchiver(sinks.Archive, cfg.Capture.ExportDir)
+		if err != nil {
+			return nil, err
+		}
+		engine.AddSink(archiver)
+	}
+	var labels *collector.Collector
+	if sinks.Collector.Dir != "" {
+		var err error
+		labels, err = collector.NewCollector(sinks.Collector)
+		if err != nil {
+			return nil, err
+		}
+		engine.AddSink(labels)
+	}
+	return labels, nil
+}
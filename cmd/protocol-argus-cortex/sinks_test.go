@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/argus"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink/archive"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink/clickhouse"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink/collector"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink/elasticsearch"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink/kafka"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink/nats"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink/syslog"
+)
+
+func TestAddSinks(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+capture:
+  simulation: true
+sinks:
+  kafka:
+    brokers: ["127.0.0.1:1"]
+    aggregation:
+      window: 60
+  nats:
+    url: nats://127.0.0.1:1
+  syslog:
+    address: 127.0.0.1:514
+  elasticsearch:
+    urls: ["http://127.0.0.1:1"]
+  clickhouse:
+    url: http://127.0.0.1:1
+  archive:
+    bucket: detections
+    endpoint: http://127.0.0.1:1
+    access_key: key
+    secret_key: secret
+    spool_dir: `+filepath.Join(dir, "spool")+`
+  collector:
+    dir: `+filepath.Join(dir, "datasets")+`
+`), 0o600))
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	cortexEngine, err := cortex.NewEngine(cfg.Cortex)
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+	argusEngine, err := argus.NewEngine(cfg.Capture, cortexEngine)
+	require.NoError(t, err)
+	defer argusEngine.Close()
+
+	labels, err := addSinks(cfg, argusEngine)
+	require.NoError(t, err)
+	assert.NotNil(t, labels)
+	sinks := argusEngine.SinkHealth()
+	assert.Len(t, sinks, 7)
+	assert.Contains(t, sinks, kafka.Name)
+	assert.Contains(t, sinks, nats.Name)
+	assert.Contains(t, sinks, syslog.Name)
+	assert.Contains(t, sinks, elasticsearch.Name)
+	assert.Contains(t, sinks, clickhouse.Name)
+	assert.Contains(t, sinks, archive.Name)
+	assert.Contains(t, sinks, collector.Name)
+}
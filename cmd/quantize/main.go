@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+)
+
+func main() {
+	modelPath := flag.String("model", "./models/bot_detection_model", "model file to quantize")
+	precision := flag.String("precision", ml.PrecisionInt8, "precision of the neural network and SVM parameters: int8 or float16")
+	output := flag.String("output", "", "file the quantized model is written to, the model file with the precision appended if empty")
+	threshold := flag.Float64("threshold", 0.5, "detection threshold the verdicts are compared at")
+	knnNeighbors := flag.Int("knn-neighbors", 0, "k of knn ensemble members, the default if zero")
+	knnDistance := flag.String("knn-distance", "", "distance of knn ensemble members, euclidean if empty")
+	flag.Parse()
+
+	snapshot, err := ml.ReadModelFile(*modelPath)
+	if err != nil {
+		log.Fatalf("Failed to read model: %v", err)
+	}
+
+	config := ml.MLConfig{
+		ModelType:          snapshot.ModelType,
+		DetectionThreshold: *threshold,
+		FeatureSize:        snapshot.FeatureSize,
+		FeatureSchema:      snapshot.FeatureSchema,
+		KNNNeighbors:       *knnNeighbors,
+		KNNDistance:        *knnDistance,
+	}
+	if snapshot.SVMOneClass {
+		config.SVMMode = ml.SVMModeOneClass
+	}
+	if snapshot.ModelType == "ensemble" {
+		for _, member := range []struct {
+			model   string
+			trained bool
+		}{
+			{"neural_network", snapshot.NNTrained},
+			{"svm", snapshot.SVMTrained},
+			{"gbdt", snapshot.GBDTTrained},
+			{"knn", snapshot.KNNTrained},
+		} {
+			if member.trained {
+				config.EnsembleModels = append(config.EnsembleModels, member.model)
+			}
+		}
+	}
+
+	quantized, report, err := ml.QuantizeModel(snapshot, config, *precision)
+	if err != nil {
+		log.Fatalf("Failed to quantize model: %v", err)
+	}
+	if *output == "" {
+		*output = *modelPath + "." + *precision
+	}
+	if err := ml.WriteModelFile(*output, quantized); err != nil {
+		log.Fatalf("Failed to write model: %v", err)
+	}
+
+	fmt.Printf("Model:             %s (%s, trained %s)\n", *modelPath, snapshot.ModelType, snapshot.CreatedAt.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Precision:         %s for %v\n", report.Precision, report.Models)
+	fmt.Printf("Held-out flows:    %d\n", report.Samples)
+	fmt.Printf("Accuracy:          %.4f -> %.4f (%+.4f)\n", report.Accuracy, report.QuantizedAccuracy, report.AccuracyDelta)
+	fmt.Printf("F1:                %.4f -> %.4f\n", report.F1, report.QuantizedF1)
+	fmt.Printf("Same verdict:      %.2f%%\n", 100*report.Agreement)
+	fmt.Printf("Confidence change: mean %.4f, max %.4f\n", report.MeanScoreDelta, report.MaxScoreDelta)
+	fmt.Printf("Parameters:        %d -> %d bytes\n", report.ParameterBytes, report.QuantizedParameterBytes)
+	fmt.Printf("Quantized model written to %s\n", *output)
+}
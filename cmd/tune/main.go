@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/dataset"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+)
+
+func main() {
+	modelType := flag.String("model-type", "gbdt", "model to tune: gbdt, knn, svm (one-class) or ensemble")
+	ensemble := flag.String("ensemble-models", "gbdt,knn", "comma-separated ensemble members when tuning an ensemble")
+	featureSize := flag.Int("feature-size", 128, "number of features per flow")
+	samples := flag.Int("samples", 1000, "number of synthetic flows to tune on")
+	data := flag.String("data", "", "labelled CSV or Parquet dataset to tune on instead of synthetic flows")
+	labelColumn := flag.String("label-column", dataset.DefaultLabelColumn, "label column of the dataset")
+	strategy := flag.String("strategy", ml.TuningGrid, "search strategy: grid or random")
+	objective := flag.String("objective", ml.ObjectiveF1, "cross-validated score to maximise: f1, accuracy or roc_auc")
+	trials := flag.Int("trials", 20, "maximum number of trials")
+	duration := flag.Duration("duration", 0, "time budget, unlimited if zero")
+	folds := flag.Int("folds", 3, "cross-validation folds")
+	seed := flag.Int64("seed", 1, "seed of the random strategy and the folds")
+	output := flag.String("output", "tuned.yml", "file the best parameters are written to")
+	flag.Parse()
+
+	config := ml.MLConfig{
+		ModelType:          *modelType,
+		EnsembleModels:     strings.Split(*ensemble, ","),
+		DetectionThreshold: 0.5,
+		FeatureSize:        *featureSize,
+		FakeDataSize:       *samples,
+		GBDTTrees:          100,
+		GBDTMaxDepth:       4,
+		GBDTLearningRate:   0.1,
+		GBDTBins:           32,
+		GBDTMinSamplesLeaf: 10,
+		KNNNeighbors:       5,
+		SVMNu:              0.05,
+	}
+	if *modelType == "svm" {
+		config.SVMMode = "one_class"
+	}
+	engine, err := ml.NewMLEngine(config)
+	if err != nil {
+		log.Fatalf("Failed to initialize ML engine: %v", err)
+	}
+	defer engine.Close()
+
+	var features [][]float64
+	var labels []int
+	if *data != "" {
+		ds, err := dataset.Load(*data, dataset.Options{FeatureSize: *featureSize, LabelColumn: *labelColumn})
+		if err != nil {
+			log.Fatalf("Failed to load dataset: %v", err)
+		}
+		features, labels = ds.Features, ds.Labels
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	result, err := engine.Tune(ctx, features, labels, ml.TuningOptions{
+		Strategy:    *strategy,
+		Objective:   *objective,
+		MaxTrials:   *trials,
+		MaxDuration: *duration,
+		Folds:       *folds,
+		Seed:        *seed,
+	})
+	if err != nil {
+		log.Fatalf("Tuning failed: %v", err)
+	}
+
+	fmt.Printf("%s search of %d of %d combinations in %s\n\n", result.Strategy, len(result.Trials), result.Combinations, result.Elapsed.Round(time.Millisecond))
+	fmt.Printf("%10s %10s %10s  %s\n", result.Objective, "accuracy", "seconds", "parameters")
+	for _, trial := range result.Trials {
+		if trial.Error != "" {
+			fmt.Printf("%10s %10s %10.2f  %s: %s\n", "-", "-", trial.Duration.Seconds(), formatParams(trial.Params), trial.Error)
+			continue
+		}
+		fmt.Printf("%10.4f %10.4f %10.2f  %s\n", trial.Score, trial.Accuracy, trial.Duration.Seconds(), formatParams(trial.Params))
+	}
+
+	if err := result.WriteConfig(*output); err != nil {
+		log.Fatalf("Failed to save configuration: %v", err)
+	}
+	fmt.Printf("\nBest %s %.4f with %s, written to %s\n", result.Objective, result.Best.Score, formatParams(result.Best.Params), *output)
+}
+
+// formatParams formats parameters in key order
+func formatParams(params map[string]any) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s=%v", key, params[key])
+	}
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/reload"
+)
+
+// Reloader re-reads the configuration file, as implemented by
+// reload.Reloader
+type Reloader interface {
+	Reload() (*reload.Result, error)
+}
+
+// SetReloader enables the configuration reload endpoint. It responds with
+// 503 until a reloader is set.
+func (s *Server) SetReloader(reloader Reloader) {
+	s.reloader = reloader
+}
+
+// handleReload re-reads the configuration file and reports which changed
+// settings were applied and which need a restart
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if s.reloader == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "Configuration reload not available")
+		return
+	}
+
+	result, err := s.reloader.Reload()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to reload configuration: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/datasets"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/dataset"
+	"github.com/gorilla/mux"
+)
+
+// defaultTestShare is the share of samples split off for testing when the
+// request does not name one
+const defaultTestShare = 0.2
+
+// DatasetStore manages versioned labelled datasets, as implemented by
+// datasets.Store
+type DatasetStore interface {
+	List() []datasets.Dataset
+	Get(name string) (*datasets.Dataset, error)
+	Create(name, description string, samples datasets.Samples) (*datasets.Version, error)
+	Append(name string, samples datasets.Samples) (*datasets.Version, error)
+	Tag(name, tag string, version int) error
+	Split(ref string, testShare float64, seed int64) (train, test *datasets.Version, err error)
+	ImportFile(name string) (datasets.Samples, error)
+}
+
+// SetDatasetStore enables the dataset endpoints. They respond with 503
+// until a store is set.
+func (s *Server) SetDatasetStore(store DatasetStore) {
+	s.datasets = store
+}
+
+// sampleRequest is a labelled sample in a request
+type sampleRequest struct {
+	FlowID      string    `json:"flow_id"`
+	Features    []float64 `json:"features"`
+	Verdict     string    `json:"verdict"`
+	LabelSource string    `json:"label_source"`
+}
+
+// samplesRequest adds samples to a dataset, given in the request or read
+// from a file in the import directory of the dataset store
+type samplesRequest struct {
+	Features    []string        `json:"features"`
+	Samples     []sampleRequest `json:"samples"`
+	File        string          `json:"file"`
+	LabelSource string          `json:"label_source"`
+}
+
+// samples returns the samples of the request
+func (s *Server) samples(request samplesRequest) (datasets.Samples, error) {
+	if (request.File == "") == (len(request.Samples) == 0) {
+		return datasets.Samples{}, fmt.Errorf("%w: either samples or a file is required", datasets.ErrInvalid)
+	}
+	if request.File != "" {
+		samples, err := s.datasets.ImportFile(request.File)
+		if err != nil {
+			return datasets.Samples{}, err
+		}
+		if request.LabelSource != "" {
+			samples.LabelSource = request.LabelSource
+		}
+		return samples, nil
+	}
+
+	samples := datasets.Samples{
+		Features:    request.Features,
+		Source:      "api",
+		LabelSource: request.LabelSource,
+	}
+	if samples.LabelSource == "" {
+		samples.LabelSource = "api"
+	}
+	for i, sample := range request.Samples {
+		var label int
+		switch sample.Verdict {
+		case "bot":
+			label = 1
+		case "human":
+		default:
+			return datasets.Samples{}, fmt.Errorf("%w: sample %d: verdict must be bot or human", datasets.ErrInvalid, i+1)
+		}
+		samples.Records = append(samples.Records, dataset.Record{
+			FlowID:      sample.FlowID,
+			Features:    sample.Features,
+			Label:       &label,
+			LabelSource: sample.LabelSource,
+		})
+	}
+	return samples, nil
+}
+
+// handleListDatasets lists the datasets and their versions
+func (s *Server) handleListDatasets(w http.ResponseWriter, r *http.Request) {
+	if !s.requireDatasetStore(w) {
+		return
+	}
+
+	list := s.datasets.List()
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"datasets": list,
+		"count":    len(list),
+	})
+}
+
+// handleDataset describes a dataset and its versions
+func (s *Server) handleDataset(w http.ResponseWriter, r *http.Request) {
+	if !s.requireDatasetStore(w) {
+		return
+	}
+
+	d, err := s.datasets.Get(mux.Vars(r)["name"])
+	if err != nil {
+		s.writeDatasetError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, d)
+}
+
+// handleCreateDataset creates a dataset from labelled samples
+func (s *Server) handleCreateDataset(w http.ResponseWriter, r *http.Request) {
+	if !s.requireDatasetStore(w) {
+		return
+	}
+
+	var request struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		samplesRequest
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	samples, err := s.samples(request.samplesRequest)
+	if err != nil {
+		s.writeDatasetError(w, err)
+		return
+	}
+
+	version, err := s.datasets.Create(request.Name, request.Description, samples)
+	if err != nil {
+		s.writeDatasetError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"dataset": request.Name,
+		"version": version,
+	})
+}
+
+// handleAppendSamples adds labelled samples to a dataset as a new version
+func (s *Server) handleAppendSamples(w http.ResponseWriter, r *http.Request) {
+	if !s.requireDatasetStore(w) {
+		return
+	}
+
+	var request samplesRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	samples, err := s.samples(request)
+	if err != nil {
+		s.writeDatasetError(w, err)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	version, err := s.datasets.Append(name, samples)
+	if err != nil {
+		s.writeDatasetError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"dataset": name,
+		"version": version,
+	})
+}
+
+// handleTagDataset names a dataset version
+func (s *Server) handleTagDataset(w http.ResponseWriter, r *http.Request) {
+	if !s.requireDatasetStore(w) {
+		return
+	}
+
+	var request struct {
+		Tag     string `json:"tag"`
+		Version int    `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if err := s.datasets.Tag(name, request.Tag, request.Version); err != nil {
+		s.writeDatasetError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"dataset": name,
+		"tag":     request.Tag,
+		"version": request.Version,
+	})
+}
+
+// handleSplitDataset splits a dataset version into stratified training
+// and test datasets
+func (s *Server) handleSplitDataset(w http.ResponseWriter, r *http.Request) {
+	if !s.requireDatasetStore(w) {
+		return
+	}
+
+	var request struct {
+		Version   string  `json:"version"` // number or tag, the latest when empty
+		TestShare float64 `json:"test_share"`
+		Seed      int64   `json:"seed"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if request.TestShare == 0 {
+		request.TestShare = defaultTestShare
+	}
+
+	name := mux.Vars(r)["name"]
+	ref := name
+	if request.Version != "" {
+		ref += "@" + request.Version
+	}
+	train, test, err := s.datasets.Split(ref, request.TestShare, request.Seed)
+	if err != nil {
+		s.writeDatasetError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"train": map[string]interface{}{"dataset": name + "-train", "version": train},
+		"test":  map[string]interface{}{"dataset": name + "-test", "version": test},
+	})
+}
+
+// requireDatasetStore writes an error when dataset management is
+// unavailable
+func (s *Server) requireDatasetStore(w http.ResponseWriter) bool {
+	if s.datasets == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "Dataset management not available")
+		return false
+	}
+	return true
+}
+
+// writeDatasetError maps dataset errors to status codes
+func (s *Server) writeDatasetError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, datasets.ErrDatasetNotFound), errors.Is(err, datasets.ErrVersionNotFound):
+		s.writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, datasets.ErrDatasetExists):
+		s.writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, datasets.ErrInvalid):
+		s.writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
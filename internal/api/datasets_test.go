@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/datasets"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatasetEndpoints(t *testing.T) {
+	s := &Server{}
+	call := func(handler http.HandlerFunc, method, name, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/datasets/"+name, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler(rec, mux.SetURLVars(req, map[string]string{"name": name}))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, call(s.handleListDatasets, http.MethodGet, "", "").Code)
+
+	dir := t.TempDir()
+	store, err := datasets.Open(dir)
+	require.NoError(t, err)
+	s.SetDatasetStore(store)
+
+	var samples []string
+	for i := 0; i < 10; i++ {
+		verdict := "human"
+		if i%2 == 0 {
+			verdict = "bot"
+		}
+		samples = append(samples, `{"features":[1,2],"verdict":"`+verdict+`"}`)
+	}
+	rec := call(s.handleCreateDataset, http.MethodPost, "", `{"name":"flows","features":["a","b"],"samples":[`+strings.Join(samples, ",")+`]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var created struct {
+		Version datasets.Version `json:"version"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, 10, created.Version.Samples)
+	assert.Equal(t, map[string]int{"api": 10}, created.Version.LabelSources)
+
+	// Samples from an import file
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, datasets.ImportDir), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, datasets.ImportDir, "more.csv"), []byte("a,b,label\n3,4,1\n"), 0o644))
+	rec = call(s.handleAppendSamples, http.MethodPost, "flows", `{"file":"more.csv","label_source":"honeypot"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, 2, created.Version.Version)
+	assert.Equal(t, "file:more.csv", created.Version.Source)
+	assert.Equal(t, 1, created.Version.LabelSources["honeypot"])
+
+	assert.Equal(t, http.StatusOK, call(s.handleTagDataset, http.MethodPost, "flows", `{"tag":"golden","version":1}`).Code)
+	rec = call(s.handleSplitDataset, http.MethodPost, "flows", `{"version":"golden","test_share":0.4,"seed":7}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = call(s.handleListDatasets, http.MethodGet, "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list struct {
+		Count int `json:"count"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Equal(t, 3, list.Count)
+
+	rec = call(s.handleDataset, http.MethodGet, "flows-test", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var d datasets.Dataset
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &d))
+	assert.Equal(t, "flows@v1", d.Versions[0].Source)
+	assert.Equal(t, 4, d.Versions[0].Samples)
+
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"unknown dataset": call(s.handleDataset, http.MethodGet, "missing", ""),
+		"unknown version": call(s.handleTagDataset, http.MethodPost, "flows", `{"tag":"golden","version":9}`),
+		"unknown tag":     call(s.handleSplitDataset, http.MethodPost, "flows", `{"version":"nope"}`),
+		"missing file":    call(s.handleAppendSamples, http.MethodPost, "flows", `{"file":"none.csv"}`),
+	} {
+		assert.Equal(t, http.StatusNotFound, rec.Code, name)
+	}
+	assert.Equal(t, http.StatusConflict,
+		call(s.handleCreateDataset, http.MethodPost, "", `{"name":"flows","features":["a","b"],"samples":[`+samples[0]+`]}`).Code)
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"not json":      call(s.handleCreateDataset, http.MethodPost, "", `not json`),
+		"no samples":    call(s.handleCreateDataset, http.MethodPost, "", `{"name":"empty"}`),
+		"verdict":       call(s.handleAppendSamples, http.MethodPost, "flows", `{"samples":[{"features":[1,2],"verdict":"maybe"}]}`),
+		"feature count": call(s.handleAppendSamples, http.MethodPost, "flows", `{"samples":[{"features":[1],"verdict":"bot"}]}`),
+		"tag":           call(s.handleTagDataset, http.MethodPost, "flows", `{"tag":"latest","version":1}`),
+		"test share":    call(s.handleSplitDataset, http.MethodPost, "flows", `{"test_share":1.5}`),
+	} {
+		assert.Equal(t, http.StatusBadRequest, rec.Code, name)
+	}
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/store"
+	"github.com/gorilla/mux"
+)
+
+// DetectionStore queries persisted detection results and records analyst
+// feedback on them, as implemented by store.Store
+type DetectionStore interface {
+	QueryDetections(ctx context.Context, q store.Query) ([]*cortex.DetectionResult, error)
+	SubmitFeedback(ctx context.Context, id int64, isBot bool, note string) (*store.Feedback, error)
+	FeedbackStats(ctx context.Context) (*store.FeedbackStats, error)
+	LiveAccuracy(ctx context.Context) ([]store.WindowFeedbackStats, error)
+}
+
+// LabelSink receives detections labelled by analyst feedback, as
+// implemented by the training data collector
+type LabelSink interface {
+	WriteLabel(result *cortex.DetectionResult, isBot bool)
+}
+
+// maxFeedbackNote bounds the length of feedback notes in bytes
+const maxFeedbackNote = 1024
+
+// SetDetectionStore enables the detection history endpoint. It responds
+// with 503 until a store is set.
+func (s *Server) SetDetectionStore(detections DetectionStore) {
+	s.detections = detections
+}
+
+// SetLabelSink passes the detections labelled by feedback to labels
+func (s *Server) SetLabelSink(labels LabelSink) {
+	s.labels = labels
+}
+
+// handleDetections lists persisted detections, most recent first. Query
+// parameters filter the listing (since/until as RFC 3339 times, verdict as
+// bot or human, ip matching either endpoint) and bound it (limit).
+func (s *Server) handleDetections(w http.ResponseWriter, r *http.Request) {
+	if s.detections == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "Detection store not available")
+		return
+	}
+
+	query, err := parseDetectionQuery(r.URL.Query())
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	detections, err := s.detections.QueryDetections(r.Context(), query)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to query detections: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"detections": detections,
+		"count":      len(detections),
+	})
+}
+
+// handleDetectionFeedback records an analyst's verdict (bot or human, with
+// an optional note) on a persisted detection
+func (s *Server) handleDetectionFeedback(w http.ResponseWriter, r *http.Request) {
+	if s.detections == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "Detection store not available")
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id < 1 {
+		s.writeError(w, http.StatusBadRequest, "Invalid detection ID")
+		return
+	}
+
+	var request struct {
+		Verdict string `json:"verdict"`
+		Note    string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if request.Verdict != "bot" && request.Verdict != "human" {
+		s.writeError(w, http.StatusBadRequest, "Verdict must be bot or human")
+		return
+	}
+	if len(request.Note) > maxFeedbackNote {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Note exceeds %d bytes", maxFeedbackNote))
+		return
+	}
+
+	feedback, err := s.detections.SubmitFeedback(r.Context(), id, request.Verdict == "bot", request.Note)
+	if err != nil {
+		if errors.Is(err, store.ErrDetectionNotFound) {
+			s.writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to store feedback: %v", err))
+		return
+	}
+	if s.labels != nil && feedback.Detection != nil {
+		s.labels.WriteLabel(feedback.Detection, feedback.IsBot)
+	}
+
+	s.writeJSON(w, http.StatusOK, feedback)
+}
+
+// parseDetectionQuery builds a detection query from request parameters
+func parseDetectionQuery(values url.Values) (store.Query, error) {
+	var query store.Query
+
+	var err error
+	for name, dst := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if v := values.Get(name); v != "" {
+			if *dst, err = time.Parse(time.RFC3339, v); err != nil {
+				return query, fmt.Errorf("invalid %s: %q", name, v)
+			}
+		}
+	}
+	switch v := values.Get("verdict"); v {
+	case "":
+	case "bot", "human":
+		isBot := v == "bot"
+		query.IsBot = &isBot
+	default:
+		return query, fmt.Errorf("invalid verdict: %q", v)
+	}
+	if v := values.Get("ip"); v != "" {
+		if query.IP = net.ParseIP(v); query.IP == nil {
+			return query, fmt.Errorf("invalid ip: %q", v)
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil || query.Limit < 1 {
+			return query, fmt.Errorf("invalid limit: %q", v)
+		}
+	}
+
+	return query, nil
+}
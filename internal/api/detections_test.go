@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/store"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDetectionStore records the last query and feedback and returns
+// results
+type fakeDetectionStore struct {
+	query    store.Query
+	results  []*cortex.DetectionResult
+	feedback *store.Feedback
+}
+
+// fakeLabelSink records the labelled detections
+type fakeLabelSink struct {
+	flows  []string
+	labels []bool
+}
+
+func (f *fakeLabelSink) WriteLabel(result *cortex.DetectionResult, isBot bool) {
+	f.flows = append(f.flows, result.FlowID)
+	f.labels = append(f.labels, isBot)
+}
+
+func (f *fakeDetectionStore) QueryDetections(_ context.Context, q store.Query) ([]*cortex.DetectionResult, error) {
+	f.query = q
+	return f.results, nil
+}
+
+func (f *fakeDetectionStore) SubmitFeedback(_ context.Context, id int64, isBot bool, note string) (*store.Feedback, error) {
+	if id != 1 {
+		return nil, fmt.Errorf("%w: %d", store.ErrDetectionNotFound, id)
+	}
+	f.feedback = &store.Feedback{
+		DetectionID: id, FlowID: "a-b", PredictedBot: true, IsBot: isBot, Note: note,
+		Detection: &cortex.DetectionResult{FlowID: "a-b", IsBot: true},
+	}
+	return f.feedback, nil
+}
+
+func (f *fakeDetectionStore) FeedbackStats(context.Context) (*store.FeedbackStats, error) {
+	return &store.FeedbackStats{}, nil
+}
+
+func (f *fakeDetectionStore) LiveAccuracy(context.Context) ([]store.WindowFeedbackStats, error) {
+	return nil, nil
+}
+
+func TestHandleDetections(t *testing.T) {
+	s := &Server{}
+
+	rec := httptest.NewRecorder()
+	s.handleDetections(rec, httptest.NewRequest(http.MethodGet, "/api/v1/detections", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	fake := &fakeDetectionStore{results: []*cortex.DetectionResult{{FlowID: "a-b", IsBot: true}}}
+	s.SetDetectionStore(fake)
+
+	rec = httptest.NewRecorder()
+	s.handleDetections(rec, httptest.NewRequest(http.MethodGet,
+		"/api/v1/detections?since=2024-03-01T00:00:00Z&verdict=bot&ip=10.0.0.5&limit=20", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var response struct {
+		Detections []cortex.DetectionResult `json:"detections"`
+		Count      int                      `json:"count"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, 1, response.Count)
+	assert.Equal(t, "a-b", response.Detections[0].FlowID)
+
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), fake.query.Since)
+	assert.True(t, fake.query.Until.IsZero())
+	require.NotNil(t, fake.query.IsBot)
+	assert.True(t, *fake.query.IsBot)
+	assert.True(t, fake.query.IP.Equal(net.ParseIP("10.0.0.5")))
+	assert.Equal(t, 20, fake.query.Limit)
+
+	for _, query := range []string{"verdict=maybe", "ip=10.0.0.0/8", "since=yesterday", "limit=0"} {
+		rec = httptest.NewRecorder()
+		s.handleDetections(rec, httptest.NewRequest(http.MethodGet, "/api/v1/detections?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
+
+func TestHandleDetectionFeedback(t *testing.T) {
+	s := &Server{}
+	feedback := func(id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/detections/"+id+"/feedback", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		s.handleDetectionFeedback(rec, mux.SetURLVars(req, map[string]string{"id": id}))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, feedback("1", `{"verdict":"human"}`).Code)
+
+	fake := &fakeDetectionStore{}
+	s.SetDetectionStore(fake)
+	labels := &fakeLabelSink{}
+	s.SetLabelSink(labels)
+
+	rec := feedback("1", `{"verdict":"human","note":"known crawler allowlist"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var response store.Feedback
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, int64(1), response.DetectionID)
+	assert.True(t, response.PredictedBot)
+	assert.False(t, response.IsBot)
+	assert.Equal(t, "known crawler allowlist", fake.feedback.Note)
+	assert.Equal(t, []string{"a-b"}, labels.flows)
+	assert.Equal(t, []bool{false}, labels.labels)
+
+	assert.Equal(t, http.StatusNotFound, feedback("2", `{"verdict":"bot"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, feedback("0", `{"verdict":"bot"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, feedback("x", `{"verdict":"bot"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, feedback("1", `{"verdict":"maybe"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, feedback("1", `not json`).Code)
+	assert.Equal(t, http.StatusBadRequest, feedback("1", `{"verdict":"bot","note":"`+strings.Repeat("x", maxFeedbackNote+1)+`"}`).Code)
+}
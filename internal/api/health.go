@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// componentStatus is the state of one component checked by /readyz
+type componentStatus struct {
+	Status string `json:"status"` // "up" or "down"
+	Error  string `json:"error,omitempty"`
+}
+
+// newComponentStatus describes a component from its check result
+func newComponentStatus(err error) componentStatus {
+	if err != nil {
+		return componentStatus{Status: "down", Error: err.Error()}
+	}
+	return componentStatus{Status: "up"}
+}
+
+// handleHealth handles health check requests. It reports healthy whenever
+// the process serves requests; see /healthz and /readyz for probes.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{
+		"status":    "healthy",
+		"ready":     s.cortexEngine.IsReady(),
+		"timestamp": time.Now().UTC(),
+		"uptime":    time.Since(s.started).String(),
+	}
+
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// handleLiveness reports that the process is alive, for liveness probes
+func (s *Server) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":    "alive",
+		"timestamp": time.Now().UTC(),
+		"uptime":    time.Since(s.started).String(),
+	})
+}
+
+// handleReadiness reports whether capture is running, the model is loaded
+// and the flow sinks are reachable, for readiness probes. It responds with
+// 503 while any component is down.
+func (s *Server) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	components := map[string]componentStatus{
+		"capture": newComponentStatus(s.argusEngine.CaptureHealth()),
+		"model":   newComponentStatus(s.cortexEngine.HealthCheck()),
+	}
+	for sink, err := range s.argusEngine.SinkHealth() {
+		components["sink_"+sink] = newComponentStatus(err)
+	}
+
+	status, code := "ready", http.StatusOK
+	for _, component := range components {
+		if component.Status != "up" {
+			status, code = "not_ready", http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	s.writeJSON(w, code, map[string]interface{}{
+		"status":     status,
+		"components": components,
+		"timestamp":  time.Now().UTC(),
+	})
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/argus"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbes(t *testing.T) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.8})
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+
+	argusEngine, err := argus.NewEngine(config.CaptureConfig{Simulation: true}, cortexEngine)
+	require.NoError(t, err)
+	defer argusEngine.Close()
+
+	s := &Server{cortexEngine: cortexEngine, argusEngine: argusEngine, started: time.Now()}
+
+	rec := httptest.NewRecorder()
+	s.handleLiveness(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Capture has not been started yet
+	rec = httptest.NewRecorder()
+	s.handleReadiness(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var readiness struct {
+		Status     string                     `json:"status"`
+		Components map[string]componentStatus `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &readiness))
+	assert.Equal(t, "not_ready", readiness.Status)
+	assert.Equal(t, "down", readiness.Components["capture"].Status)
+	assert.NotEmpty(t, readiness.Components["capture"].Error)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, argusEngine.Start(ctx))
+	require.NoError(t, cortexEngine.WaitReady(ctx))
+	assert.Eventually(t, func() bool {
+		rec := httptest.NewRecorder()
+		s.handleReadiness(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/lists"
+	"github.com/gorilla/mux"
+)
+
+// handleListEntries lists the allowlist and denylist entries, of the list
+// named by the list parameter only when given
+func (s *Server) handleListEntries(w http.ResponseWriter, r *http.Request) {
+	list := r.URL.Query().Get("list")
+	if list != "" && list != lists.Allow && list != lists.Deny {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid list: %q", list))
+		return
+	}
+
+	entries := s.argusEngine.ListEntries(list)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"entries": entries,
+		"count":   len(entries),
+	})
+}
+
+// handleAddListEntry adds an address, CIDR prefix or TLS server name to the
+// allowlist or denylist
+func (s *Server) handleAddListEntry(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		List    string `json:"list"`
+		Type    string `json:"type"`
+		Value   string `json:"value"`
+		Comment string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	entry, created, err := s.argusEngine.AddListEntry(r.Context(), request.List, request.Type, request.Value, request.Comment)
+	if err != nil {
+		s.writeListError(w, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	s.writeJSON(w, status, entry)
+}
+
+// handleRemoveListEntry removes an entry from its list
+func (s *Server) handleRemoveListEntry(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.argusEngine.RemoveListEntry(mux.Vars(r)["id"]); err != nil {
+		s.writeListError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeListError writes the status matching a list error
+func (s *Server) writeListError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, lists.ErrEntryNotFound):
+		s.writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, lists.ErrInvalid):
+		s.writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		slog.Error("Failed to update lists", "error", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to update lists")
+	}
+}
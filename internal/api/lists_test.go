@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/argus"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/lists"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListEndpoints(t *testing.T) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.8})
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+
+	argusEngine, err := argus.NewEngine(config.CaptureConfig{Simulation: true}, cortexEngine)
+	require.NoError(t, err)
+	defer argusEngine.Close()
+
+	s := &Server{argusEngine: argusEngine}
+	call := func(handler http.HandlerFunc, method, target, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler(rec, mux.SetURLVars(req, map[string]string{"id": id}))
+		return rec
+	}
+
+	rec := call(s.handleAddListEntry, http.MethodPost, "/api/v1/lists", "", `{"list":"allow","value":"192.0.2.0/24","comment":"office"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var entry lists.Entry
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entry))
+	assert.Equal(t, lists.TypeIP, entry.Type)
+
+	rec = call(s.handleAddListEntry, http.MethodPost, "/api/v1/lists", "", `{"list":"allow","value":"192.0.2.0/24"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = call(s.handleAddListEntry, http.MethodPost, "/api/v1/lists", "", `{"list":"deny","type":"sni","value":"evil.example"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, http.StatusBadRequest, call(s.handleAddListEntry, http.MethodPost, "/api/v1/lists", "", `{"list":"grey","value":"192.0.2.1"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(s.handleAddListEntry, http.MethodPost, "/api/v1/lists", "", `{`).Code)
+
+	var list struct {
+		Entries []lists.Entry `json:"entries"`
+		Count   int           `json:"count"`
+	}
+	rec = call(s.handleListEntries, http.MethodGet, "/api/v1/lists", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Equal(t, 2, list.Count)
+
+	rec = call(s.handleListEntries, http.MethodGet, "/api/v1/lists?list=deny", "", "")
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Equal(t, 1, list.Count)
+	assert.Equal(t, "evil.example", list.Entries[0].Value)
+	assert.Equal(t, http.StatusBadRequest, call(s.handleListEntries, http.MethodGet, "/api/v1/lists?list=grey", "", "").Code)
+
+	assert.Equal(t, http.StatusNoContent, call(s.handleRemoveListEntry, http.MethodDelete, "/api/v1/lists/"+entry.ID, entry.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, call(s.handleRemoveListEntry, http.MethodDelete, "/api/v1/lists/"+entry.ID, entry.ID, "").Code)
+}
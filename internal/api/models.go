@@ -0,0 +1,288 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/registry"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+	"github.com/gorilla/mux"
+)
+
+// defaultMaxFalsePositiveRate is the false positive rate thresholds are
+// recommended for when the request does not name one
+const defaultMaxFalsePositiveRate = 0.01
+
+// ModelManager manages the model files of an ML engine, as implemented by
+// cortex.MLCortexEngine
+type ModelManager interface {
+	ListModels() ([]cortex.ModelInfo, error)
+	LoadModel(name string) (*cortex.ModelInfo, error)
+	ActivateModel(name string) error
+	RollbackModel() (string, error)
+	ActiveModel() string
+	ModelVersions() []registry.Version
+	ModelVersion(id string) (*registry.Version, error)
+	ActiveModelVersion() string
+	ShadowStats() (*cortex.ShadowStats, error)
+	DriftReport() (*cortex.DriftReport, error)
+	EvaluationCurves() (*ml.Curves, error)
+	FeatureImportance() ([]ml.FeatureImportance, error)
+	ReviewQueue() ([]cortex.ReviewItem, error)
+	LabelReviewItem(id string, isBot bool) (*cortex.ReviewItem, error)
+}
+
+// SetModelManager enables the model management endpoints. They respond
+// with 503 until a manager is set.
+func (s *Server) SetModelManager(models ModelManager) {
+	s.models = models
+}
+
+// handleListModels lists the model files available on disk
+func (s *Server) handleListModels(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModelManager(w) {
+		return
+	}
+
+	models, err := s.models.ListModels()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to list models: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"models":         models,
+		"active":         s.models.ActiveModel(),
+		"active_version": s.models.ActiveModelVersion(),
+	})
+}
+
+// handleListModelVersions lists the registered model versions
+func (s *Server) handleListModelVersions(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModelManager(w) {
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"versions": s.models.ModelVersions(),
+		"active":   s.models.ActiveModelVersion(),
+	})
+}
+
+// handleModelEvaluation returns the ROC and precision-recall curves of the
+// active model on held-out flows, with the threshold detecting the most bots
+// at a false positive rate of at most max_fpr
+func (s *Server) handleModelEvaluation(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModelManager(w) {
+		return
+	}
+
+	maxFPR := defaultMaxFalsePositiveRate
+	if v := r.URL.Query().Get("max_fpr"); v != "" {
+		var err error
+		if maxFPR, err = strconv.ParseFloat(v, 64); err != nil || maxFPR < 0 || maxFPR > 1 {
+			s.writeError(w, http.StatusBadRequest, "Invalid max_fpr, expected a rate between 0 and 1")
+			return
+		}
+	}
+
+	curves, err := s.models.EvaluationCurves()
+	if err != nil {
+		s.writeModelError(w, err)
+		return
+	}
+
+	response := struct {
+		*ml.Curves
+		MaxFalsePositiveRate float64        `json:"max_false_positive_rate"`
+		Recommendation       *ml.CurvePoint `json:"recommendation,omitempty"`
+	}{Curves: curves, MaxFalsePositiveRate: maxFPR}
+	if point, err := curves.RecommendThreshold(maxFPR); err == nil {
+		response.Recommendation = &point
+	}
+
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// namedFeatureImportance is the importance of a named feature
+type namedFeatureImportance struct {
+	Name string `json:"name"`
+	ml.FeatureImportance
+}
+
+// handleFeatureImportance returns the importance of each feature of the
+// active model, most important first. top limits the number of features.
+func (s *Server) handleFeatureImportance(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModelManager(w) {
+		return
+	}
+
+	top := 0
+	if v := r.URL.Query().Get("top"); v != "" {
+		var err error
+		if top, err = strconv.Atoi(v); err != nil || top <= 0 {
+			s.writeError(w, http.StatusBadRequest, "Invalid top, expected a positive number")
+			return
+		}
+	}
+
+	importance, err := s.models.FeatureImportance()
+	if err != nil {
+		s.writeModelError(w, err)
+		return
+	}
+
+	features := make([]namedFeatureImportance, len(importance))
+	for i, f := range importance {
+		features[i] = namedFeatureImportance{Name: ml.FeatureName(f.Index), FeatureImportance: f}
+	}
+	// Order by permutation importance, then weight; features without a
+	// measure come last
+	value := func(p *float64) float64 {
+		if p == nil {
+			return math.Inf(-1)
+		}
+		return *p
+	}
+	sort.SliceStable(features, func(i, j int) bool {
+		a, b := features[i], features[j]
+		if pa, pb := value(a.Permutation), value(b.Permutation); pa != pb {
+			return pa > pb
+		}
+		return value(a.Weight) > value(b.Weight)
+	})
+	if top > 0 && top < len(features) {
+		features = features[:top]
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"features": features,
+		"count":    len(features),
+	})
+}
+
+// handleShadowStats compares the shadow model's verdicts with the active
+// model's
+func (s *Server) handleShadowStats(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModelManager(w) {
+		return
+	}
+
+	stats, err := s.models.ShadowStats()
+	if err != nil {
+		s.writeModelError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, stats)
+}
+
+// handleDriftReport compares the last window of flows with the
+// distribution the active model was trained on
+func (s *Server) handleDriftReport(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModelManager(w) {
+		return
+	}
+
+	report, err := s.models.DriftReport()
+	if err != nil {
+		s.writeModelError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, report)
+}
+
+// handleModelVersion describes a registered model version
+func (s *Server) handleModelVersion(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModelManager(w) {
+		return
+	}
+
+	version, err := s.models.ModelVersion(mux.Vars(r)["id"])
+	if err != nil {
+		s.writeModelError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, version)
+}
+
+// handleLoadModel loads a model file so it can be activated
+func (s *Server) handleLoadModel(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModelManager(w) {
+		return
+	}
+
+	info, err := s.models.LoadModel(mux.Vars(r)["name"])
+	if err != nil {
+		s.writeModelError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, info)
+}
+
+// handleActivateModel switches inference to a loaded model
+func (s *Server) handleActivateModel(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModelManager(w) {
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if err := s.models.ActivateModel(name); err != nil {
+		s.writeModelError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"active": name,
+	})
+}
+
+// handleRollbackModel switches inference back to the previously active model
+func (s *Server) handleRollbackModel(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModelManager(w) {
+		return
+	}
+
+	name, err := s.models.RollbackModel()
+	if err != nil {
+		s.writeModelError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"active": name,
+	})
+}
+
+// requireModelManager writes an error when model management is unavailable
+func (s *Server) requireModelManager(w http.ResponseWriter) bool {
+	if s.models == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "Model management not available")
+		return false
+	}
+	return true
+}
+
+// writeModelError maps model management errors to status codes
+func (s *Server) writeModelError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, cortex.ErrModelNotFound), errors.Is(err, registry.ErrVersionNotFound),
+		errors.Is(err, cortex.ErrNoShadowModel), errors.Is(err, cortex.ErrNoReviewQueue), errors.Is(err, cortex.ErrNoDriftReport),
+		errors.Is(err, cortex.ErrReviewItemNotFound), errors.Is(err, ml.ErrNotEvaluated):
+		s.writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, cortex.ErrIncompatibleModel):
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, cortex.ErrModelNotLoaded), errors.Is(err, cortex.ErrNoPreviousModel):
+		s.writeError(w, http.StatusConflict, err.Error())
+	default:
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
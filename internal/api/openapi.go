@@ -0,0 +1,29 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec documents every REST endpoint. Keep it in sync with
+// setupRoutes; TestOpenAPISpec checks that each route is described.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// swaggerUI renders the spec with Swagger UI, loading its assets from a CDN
+//
+//go:embed swagger.html
+var swaggerUI []byte
+
+// handleOpenAPI serves the OpenAPI document
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// handleDocs serves the interactive API documentation
+func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(swaggerUI)
+}
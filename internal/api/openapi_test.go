@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(openAPISpec, &spec))
+	assert.True(t, strings.HasPrefix(spec.OpenAPI, "3."))
+
+	s := &Server{config: config.ServerConfig{OpenAPIUI: true}, router: mux.NewRouter()}
+	s.setupRoutes()
+
+	// Route variables with patterns, such as {id:.+}, are plain {id} in the spec
+	pattern := regexp.MustCompile(`\{(\w+):[^}]+\}`)
+	var routes int
+	err := s.router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		require.NoError(t, err)
+		methods, err := route.GetMethods()
+		require.NoError(t, err)
+
+		path = pattern.ReplaceAllString(path, "{$1}")
+		for _, method := range methods {
+			_, documented := spec.Paths[path][strings.ToLower(method)]
+			assert.True(t, documented, "%s %s is missing from openapi.json", method, path)
+			routes++
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Greater(t, routes, 10)
+
+	// Every documented operation exists
+	variable := regexp.MustCompile(`\{\w+\}`)
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			var match mux.RouteMatch
+			req, err := http.NewRequest(strings.ToUpper(method), variable.ReplaceAllString(path, "x-1"), nil)
+			require.NoError(t, err)
+			assert.True(t, s.router.Match(req, &match), "%s %s is not routed", method, path)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+// rateLimitSweepInterval is how often buckets of idle clients are dropped
+const rateLimitSweepInterval = time.Minute
+
+// tokenBucket holds the request allowance of one client
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per client. Requests carrying a valid
+// bearer token are limited per API key, others per client IP.
+type rateLimiter struct {
+	config config.RateLimitConfig
+	now    func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter creates a rate limiter, or returns nil when rate limiting
+// is disabled
+func newRateLimiter(cfg config.RateLimitConfig) *rateLimiter {
+	if cfg.RPS <= 0 {
+		return nil
+	}
+	if cfg.KeyRPS <= 0 {
+		cfg.KeyRPS, cfg.KeyBurst = cfg.RPS, cfg.Burst
+	}
+	return &rateLimiter{
+		config:  cfg,
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token from the client's bucket. When the bucket is empty it
+// returns how long until the next request would be allowed.
+func (l *rateLimiter) allow(client string, rps float64, burst int) (bool, time.Duration) {
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	b, exists := l.buckets[client]
+	if !exists {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rps)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rps * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely, since a new bucket
+// would start out identical
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		rps, burst := l.config.RPS, l.config.Burst
+		if strings.HasPrefix(client, "key:") {
+			rps, burst = l.config.KeyRPS, l.config.KeyBurst
+		}
+		if b.tokens+now.Sub(b.last).Seconds()*rps >= float64(burst) {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// clientKey identifies the client of a request and returns its limits.
+// Bearer tokens are only used as the key once valid reports them as
+// authentic, so clients cannot get a fresh bucket by making up tokens.
+func (l *rateLimiter) clientKey(r *http.Request, valid func(token string) bool) (string, float64, int) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" && valid(token) {
+		// Hash so bucket keys never hold credentials
+		sum := sha256.Sum256([]byte(token))
+		return "key:" + hex.EncodeToString(sum[:8]), l.config.KeyRPS, l.config.KeyBurst
+	}
+	return "ip:" + l.clientIP(r), l.config.RPS, l.config.Burst
+}
+
+// clientIP returns the address of the client, honouring X-Forwarded-For
+// only when the API runs behind a trusted proxy. The rightmost entry is the
+// one the proxy appended; entries left of it are supplied by the client.
+func (l *rateLimiter) clientIP(r *http.Request) string {
+	if l.config.TrustForwardedFor {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			last := forwarded[len(forwarded)-1]
+			if i := strings.LastIndexByte(last, ','); i >= 0 {
+				last = last[i+1:]
+			}
+			if client := strings.TrimSpace(last); client != "" {
+				return client
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// unlimitedPaths are the probe and scrape endpoints exempt from rate limiting
+var unlimitedPaths = map[string]bool{
+	"/health":  true,
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// rateLimitMiddleware rejects clients exceeding their request rate with
+// 429 Too Many Requests. Health checks and metrics scrapes are not limited.
+func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.rateLimiter == nil || unlimitedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client, rps, burst := s.rateLimiter.clientKey(r, func(token string) bool {
+			return s.validToken(r.Context(), token)
+		})
+		if ok, wait := s.rateLimiter.allow(client, rps, burst); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			s.writeError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
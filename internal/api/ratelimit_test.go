@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert.Nil(t, newRateLimiter(config.RateLimitConfig{}))
+
+	limiter := newRateLimiter(config.RateLimitConfig{RPS: 2, Burst: 3})
+	now := time.Unix(1000, 0)
+	limiter.now = func() time.Time { return now }
+
+	// The burst is spent, then one request every half second is allowed
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.allow("ip:10.0.0.1", 2, 3)
+		assert.True(t, ok)
+	}
+	ok, wait := limiter.allow("ip:10.0.0.1", 2, 3)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// Other clients have their own bucket
+	ok, _ = limiter.allow("ip:10.0.0.2", 2, 3)
+	assert.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = limiter.allow("ip:10.0.0.1", 2, 3)
+	assert.True(t, ok)
+
+	// Refilled buckets are dropped by the sweep
+	now = now.Add(time.Hour)
+	limiter.allow("ip:10.0.0.3", 2, 3)
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestRateLimiterClientKey(t *testing.T) {
+	limiter := newRateLimiter(config.RateLimitConfig{RPS: 1, Burst: 1, KeyRPS: 10, KeyBurst: 20})
+
+	valid := func(token string) bool { return token == "secret" }
+
+	r := httptest.NewRequest("POST", "/api/v1/analyze", nil)
+	r.RemoteAddr = "192.0.2.1:50000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+	client, rps, burst := limiter.clientKey(r, valid)
+	assert.Equal(t, "ip:192.0.2.1", client)
+	assert.Equal(t, 1.0, rps)
+	assert.Equal(t, 1, burst)
+
+	// The proxy appends the address it saw; the client chose the others
+	limiter.config.TrustForwardedFor = true
+	client, _, _ = limiter.clientKey(r, valid)
+	assert.Equal(t, "ip:198.51.100.7", client)
+
+	// Made-up tokens are limited per client IP
+	r.Header.Set("Authorization", "Bearer made-up")
+	client, _, _ = limiter.clientKey(r, valid)
+	assert.Equal(t, "ip:198.51.100.7", client)
+
+	r.Header.Set("Authorization", "Bearer secret")
+	client, rps, burst = limiter.clientKey(r, valid)
+	assert.Regexp(t, "^key:[0-9a-f]{16}$", client)
+	assert.NotContains(t, client, "secret")
+	assert.Equal(t, 10.0, rps)
+	assert.Equal(t, 20, burst)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// handleReviewQueue lists the uncertain predictions waiting for review,
+// oldest first
+func (s *Server) handleReviewQueue(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModelManager(w) {
+		return
+	}
+
+	items, err := s.models.ReviewQueue()
+	if err != nil {
+		s.writeModelError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"items": items,
+		"count": len(items),
+	})
+}
+
+// handleLabelReviewItem records an analyst's verdict on a queued
+// prediction, which the model then learns
+func (s *Server) handleLabelReviewItem(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModelManager(w) {
+		return
+	}
+
+	var request struct {
+		Verdict string `json:"verdict"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if request.Verdict != "bot" && request.Verdict != "human" {
+		s.writeError(w, http.StatusBadRequest, "Verdict must be bot or human")
+		return
+	}
+
+	item, err := s.models.LabelReviewItem(mux.Vars(r)["id"], request.Verdict == "bot")
+	if err != nil {
+		s.writeModelError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"item":    item,
+		"verdict": request.Verdict,
+	})
+}
@@ -2,12 +2,20 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/auth"
 	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
 	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/argus"
 	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
@@ -24,6 +32,18 @@ type Server struct {
 	router       *mux.Router
 	server       *http.Server
 	metrics      *Metrics
+	verifier     *auth.Verifier // nil without OIDC
+	rateLimiter  *rateLimiter   // nil when rate limiting is disabled
+	started      time.Time
+	models       ModelManager   // nil until SetModelManager
+	reloader     Reloader       // nil until SetReloader
+	detections   DetectionStore // nil until SetDetectionStore
+	labels       LabelSink      // nil until SetLabelSink
+	datasets     DatasetStore   // nil until SetDatasetStore
+
+	// closed by Shutdown to end detection streams, which never go idle
+	stopping chan struct{}
+	stopOnce sync.Once
 }
 
 // Metrics holds Prometheus metrics
@@ -34,6 +54,7 @@ type Metrics struct {
 	humanDetections prometheus.Counter
 	activeFlows     prometheus.Gauge
 	totalPackets    prometheus.Counter
+	flowEvictions   prometheus.CounterFunc
 }
 
 // NewServer creates a new API server
@@ -45,7 +66,14 @@ func NewServer(cfg config.ServerConfig, cortexEngine *cortex.Engine, argusEngine
 		cortexEngine: cortexEngine,
 		argusEngine:  argusEngine,
 		router:       router,
-		metrics:      newMetrics(),
+		metrics:      newMetrics(argusEngine),
+		rateLimiter:  newRateLimiter(cfg.RateLimit),
+		started:      time.Now(),
+		stopping:     make(chan struct{}),
+	}
+	if cfg.OIDC.Issuer != "" {
+		server.verifier = auth.NewVerifier(cfg.OIDC)
+		slog.Info("Accepting OIDC bearer tokens", "issuer", cfg.OIDC.Issuer, "audience", cfg.OIDC.Audience)
 	}
 
 	server.setupRoutes()
@@ -55,7 +83,7 @@ func NewServer(cfg config.ServerConfig, cortexEngine *cortex.Engine, argusEngine
 }
 
 // newMetrics creates and registers Prometheus metrics
-func newMetrics() *Metrics {
+func newMetrics(argusEngine *argus.Engine) *Metrics {
 	metrics := &Metrics{
 		requestsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -96,6 +124,15 @@ func newMetrics() *Metrics {
 				Help: "Total number of packets captured",
 			},
 		),
+		flowEvictions: prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "argus_cortex_flow_evictions_total",
+				Help: "Total number of flows evicted from the full flow table",
+			},
+			func() float64 {
+				return float64(argusEngine.GetStatistics().EvictedFlows)
+			},
+		),
 	}
 
 	// Register metrics
@@ -106,6 +143,7 @@ func newMetrics() *Metrics {
 		metrics.humanDetections,
 		metrics.activeFlows,
 		metrics.totalPackets,
+		metrics.flowEvictions,
 	)
 
 	return metrics
@@ -113,18 +151,62 @@ func newMetrics() *Metrics {
 
 // setupRoutes configures the API routes
 func (s *Server) setupRoutes() {
-	// Health check
+	// Health checks
 	s.router.HandleFunc("/health", s.handleHealth).Methods("GET")
+	s.router.HandleFunc("/healthz", s.handleLiveness).Methods("GET")
+	s.router.HandleFunc("/readyz", s.handleReadiness).Methods("GET")
 
 	// API endpoints
 	s.router.HandleFunc("/api/v1/status", s.handleStatus).Methods("GET")
 	s.router.HandleFunc("/api/v1/statistics", s.handleStatistics).Methods("GET")
-	s.router.HandleFunc("/api/v1/flows", s.handleFlows).Methods("GET")
-	s.router.HandleFunc("/api/v1/analyze", s.handleAnalyze).Methods("POST")
+
+	// Data and mutating endpoints, which require a client certificate when
+	// a client CA is configured. Reading data requires the read role once
+	// OIDC is configured.
+	s.router.Handle("/api/v1/flows", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleFlows)))).Methods("GET")
+	s.router.Handle("/api/v1/flows/{id:.+}", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleFlow)))).Methods("GET")
+	s.router.Handle("/api/v1/reputation/{ip}", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleReputation)))).Methods("GET")
+	s.router.Handle("/api/v1/blocks", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleBlocks)))).Methods("GET")
+	s.router.Handle("/api/v1/blocks/{ip}", s.requireClientCert(s.requireAuth(http.HandlerFunc(s.handleUnblock)))).Methods("DELETE")
+	s.router.Handle("/api/v1/lists", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleListEntries)))).Methods("GET")
+	s.router.Handle("/api/v1/lists", s.requireClientCert(s.requireAuth(http.HandlerFunc(s.handleAddListEntry)))).Methods("POST")
+	s.router.Handle("/api/v1/lists/{id}", s.requireClientCert(s.requireAuth(http.HandlerFunc(s.handleRemoveListEntry)))).Methods("DELETE")
+	s.router.Handle("/api/v1/analyze", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleAnalyze)))).Methods("POST")
+	s.router.Handle("/api/v1/analyze/batch", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleAnalyzeBatch)))).Methods("POST")
+	s.router.Handle("/api/v1/detections", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleDetections)))).Methods("GET")
+	s.router.Handle("/api/v1/detections/stream", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleDetectionStream)))).Methods("GET")
+	s.router.Handle("/api/v1/detections/{id}/feedback", s.requireClientCert(s.requireAuth(http.HandlerFunc(s.handleDetectionFeedback)))).Methods("POST")
+	s.router.Handle("/api/v1/capture/filter", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleGetFilter)))).Methods("GET")
+	s.router.Handle("/api/v1/capture/filter", s.requireClientCert(s.requireAuth(http.HandlerFunc(s.handleSetFilter)))).Methods("PUT")
+	s.router.Handle("/api/v1/models", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleListModels)))).Methods("GET")
+	s.router.Handle("/api/v1/models/versions", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleListModelVersions)))).Methods("GET")
+	s.router.Handle("/api/v1/models/versions/{id}", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleModelVersion)))).Methods("GET")
+	s.router.Handle("/api/v1/models/shadow", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleShadowStats)))).Methods("GET")
+	s.router.Handle("/api/v1/models/drift", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleDriftReport)))).Methods("GET")
+	s.router.Handle("/api/v1/models/evaluation", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleModelEvaluation)))).Methods("GET")
+	s.router.Handle("/api/v1/model/feature-importance", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleFeatureImportance)))).Methods("GET")
+	s.router.Handle("/api/v1/models/rollback", s.requireClientCert(s.requireAuth(http.HandlerFunc(s.handleRollbackModel)))).Methods("POST")
+	s.router.Handle("/api/v1/models/{name}/load", s.requireClientCert(s.requireAuth(http.HandlerFunc(s.handleLoadModel)))).Methods("POST")
+	s.router.Handle("/api/v1/models/{name}/activate", s.requireClientCert(s.requireAuth(http.HandlerFunc(s.handleActivateModel)))).Methods("POST")
+	s.router.Handle("/api/v1/review", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleReviewQueue)))).Methods("GET")
+	s.router.Handle("/api/v1/review/{id}/label", s.requireClientCert(s.requireAuth(http.HandlerFunc(s.handleLabelReviewItem)))).Methods("POST")
+	s.router.Handle("/api/v1/datasets", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleListDatasets)))).Methods("GET")
+	s.router.Handle("/api/v1/datasets", s.requireClientCert(s.requireAuth(http.HandlerFunc(s.handleCreateDataset)))).Methods("POST")
+	s.router.Handle("/api/v1/datasets/{name}", s.requireClientCert(s.requireRead(http.HandlerFunc(s.handleDataset)))).Methods("GET")
+	s.router.Handle("/api/v1/datasets/{name}/samples", s.requireClientCert(s.requireAuth(http.HandlerFunc(s.handleAppendSamples)))).Methods("POST")
+	s.router.Handle("/api/v1/datasets/{name}/tags", s.requireClientCert(s.requireAuth(http.HandlerFunc(s.handleTagDataset)))).Methods("POST")
+	s.router.Handle("/api/v1/datasets/{name}/split", s.requireClientCert(s.requireAuth(http.HandlerFunc(s.handleSplitDataset)))).Methods("POST")
+	s.router.Handle("/api/v1/admin/reload", s.requireClientCert(s.requireAuth(http.HandlerFunc(s.handleReload)))).Methods("POST")
 
 	// Prometheus metrics
 	s.router.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
+	// API documentation
+	s.router.HandleFunc("/api/v1/openapi.json", s.handleOpenAPI).Methods("GET")
+	if s.config.OpenAPIUI {
+		s.router.HandleFunc("/api/docs", s.handleDocs).Methods("GET")
+	}
+
 	// Root endpoint
 	s.router.HandleFunc("/", s.handleRoot).Methods("GET")
 }
@@ -133,9 +215,10 @@ func (s *Server) setupRoutes() {
 func (s *Server) setupMiddleware() {
 	s.router.Use(s.loggingMiddleware)
 	s.router.Use(s.metricsMiddleware)
+	s.router.Use(s.rateLimitMiddleware)
 }
 
-// Start starts the HTTP server
+// Start starts the HTTP server, with TLS when a certificate is configured
 func (s *Server) Start() error {
 	s.server = &http.Server{
 		Addr:         fmt.Sprintf(":%d", s.config.APIPort),
@@ -145,12 +228,28 @@ func (s *Server) Start() error {
 		IdleTimeout:  60 * time.Second,
 	}
 
-	slog.Info("Starting API server", "port", s.config.APIPort)
-	return s.server.ListenAndServe()
+	if s.config.TLSCert == "" {
+		if s.config.ClientCA != "" {
+			return fmt.Errorf("client_ca requires tls_cert and tls_key")
+		}
+		slog.Info("Starting API server", "port", s.config.APIPort)
+		return s.server.ListenAndServe()
+	}
+
+	tlsConfig, err := auth.ServerTLSConfig(s.config.ClientCA, false)
+	if err != nil {
+		return err
+	}
+	s.server.TLSConfig = tlsConfig
+
+	slog.Info("Starting API server", "port", s.config.APIPort, "tls", true, "client_certs", s.config.ClientCA != "")
+	return s.server.ListenAndServeTLS(s.config.TLSCert, s.config.TLSKey)
 }
 
-// Shutdown gracefully shuts down the server
+// Shutdown gracefully shuts down the server, ending detection streams and
+// waiting for other in-flight requests to complete
 func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stopping) })
 	if s.server != nil {
 		return s.server.Shutdown(ctx)
 	}
@@ -165,35 +264,47 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 		"description": "Advanced network traffic analysis engine for bot detection",
 		"endpoints": map[string]string{
 			"health":     "/health",
+			"liveness":   "/healthz",
+			"readiness":  "/readyz",
 			"status":     "/api/v1/status",
 			"statistics": "/api/v1/statistics",
 			"flows":      "/api/v1/flows",
+			"flow":       "/api/v1/flows/{id}",
 			"analyze":    "/api/v1/analyze",
+			"batch":      "/api/v1/analyze/batch",
+			"detections": "/api/v1/detections",
+			"stream":     "/api/v1/detections/stream",
+			"feedback":   "/api/v1/detections/{id}/feedback",
+			"filter":     "/api/v1/capture/filter",
+			"models":     "/api/v1/models",
+			"versions":   "/api/v1/models/versions",
+			"shadow":     "/api/v1/models/shadow",
+			"drift":      "/api/v1/models/drift",
+			"evaluation": "/api/v1/models/evaluation",
+			"importance": "/api/v1/model/feature-importance",
+			"review":     "/api/v1/review",
+			"datasets":   "/api/v1/datasets",
+			"reload":     "/api/v1/admin/reload",
 			"metrics":    "/metrics",
+			"openapi":    "/api/v1/openapi.json",
 		},
 	}
 
 	s.writeJSON(w, http.StatusOK, response)
 }
 
-// handleHealth handles health check requests
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	response := map[string]interface{}{
-		"status":    "healthy",
-		"timestamp": time.Now().UTC(),
-		"uptime":    time.Since(time.Now()).String(), // Simplified
-	}
-
-	s.writeJSON(w, http.StatusOK, response)
-}
-
 // handleStatus handles status requests
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	cortexStats := s.cortexEngine.GetStatistics()
 	argusStats := s.argusEngine.GetStatistics()
 
+	status := "operational"
+	if !s.cortexEngine.IsReady() {
+		status = "warming_up"
+	}
+
 	response := map[string]interface{}{
-		"status": "operational",
+		"status": status,
 		"cortex": map[string]interface{}{
 			"total_inferences":   cortexStats.TotalInferences,
 			"bot_detections":     cortexStats.BotDetections,
@@ -229,37 +340,151 @@ func (s *Server) handleStatistics(w http.ResponseWriter, r *http.Request) {
 		"argus":  argusStats,
 	}
 
+	// Error rates observed through analyst feedback
+	if s.detections != nil {
+		if feedback, err := s.detections.FeedbackStats(r.Context()); err != nil {
+			slog.Warn("Failed to aggregate feedback", "error", err)
+		} else {
+			response["feedback"] = feedback
+		}
+		if windows, err := s.detections.LiveAccuracy(r.Context()); err != nil {
+			slog.Warn("Failed to aggregate live accuracy", "error", err)
+		} else {
+			response["live_accuracy"] = windows
+		}
+	}
+
 	s.writeJSON(w, http.StatusOK, response)
 }
 
-// handleFlows handles flow listing requests
+// handleFlows lists tracked flows. Query parameters filter the listing
+// (src_ip, dst_ip as address or CIDR, protocol, min_packets, is_bot, and
+// since/until as RFC 3339 times) and page through it (limit, cursor).
 func (s *Server) handleFlows(w http.ResponseWriter, r *http.Request) {
-	// In a real implementation, this would return actual flow data
-	response := map[string]interface{}{
-		"flows": []map[string]interface{}{
-			{
-				"id":         "192.168.1.100:54321-8.8.8.8:443",
-				"src_ip":     "192.168.1.100",
-				"dst_ip":     "8.8.8.8",
-				"protocol":   "TCP",
-				"packets":    15,
-				"start_time": time.Now().Add(-5 * time.Minute),
-				"last_seen":  time.Now(),
-			},
-			{
-				"id":         "10.0.0.50:12345-1.1.1.1:80",
-				"src_ip":     "10.0.0.50",
-				"dst_ip":     "1.1.1.1",
-				"protocol":   "TCP",
-				"packets":    8,
-				"start_time": time.Now().Add(-2 * time.Minute),
-				"last_seen":  time.Now(),
-			},
-		},
-		"total": 2,
+	query, err := parseFlowQuery(r.URL.Query())
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	s.writeJSON(w, http.StatusOK, response)
+	page, err := s.argusEngine.Flows(query)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, page)
+}
+
+// handleFlow handles requests for the full state of a single flow. Flow IDs
+// contain reserved characters, so clients should percent-encode them.
+func (s *Server) handleFlow(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	detail, exists := s.argusEngine.Flow(id)
+	if !exists {
+		s.writeError(w, http.StatusNotFound, fmt.Sprintf("flow %q not found", id))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, detail)
+}
+
+// handleReputation handles requests for the reputation of a source address
+func (s *Server) handleReputation(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)["ip"]
+	ip := net.ParseIP(v)
+	if ip == nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid ip: %q", v))
+		return
+	}
+
+	reputation, ok := s.argusEngine.Reputation(ip)
+	if !ok {
+		s.writeError(w, http.StatusServiceUnavailable, "IP reputation not available")
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, reputation)
+}
+
+// handleBlocks lists the sources blocked by enforcement
+func (s *Server) handleBlocks(w http.ResponseWriter, r *http.Request) {
+	blocks, ok := s.argusEngine.Blocks()
+	if !ok {
+		s.writeError(w, http.StatusServiceUnavailable, "Enforcement not enabled")
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"blocks": blocks,
+		"count":  len(blocks),
+	})
+}
+
+// handleUnblock lifts the block of a source ahead of its expiry
+func (s *Server) handleUnblock(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)["ip"]
+	ip := net.ParseIP(v)
+	if ip == nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid ip: %q", v))
+		return
+	}
+
+	blocked, err := s.argusEngine.Unblock(r.Context(), ip)
+	switch {
+	case errors.Is(err, argus.ErrEnforcementDisabled):
+		s.writeError(w, http.StatusServiceUnavailable, "Enforcement not enabled")
+	case err != nil:
+		slog.Error("Failed to lift block", "ip", ip, "error", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to lift block")
+	case !blocked:
+		s.writeError(w, http.StatusNotFound, fmt.Sprintf("%s is not blocked", ip))
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// parseFlowQuery builds a flow query from request parameters
+func parseFlowQuery(values url.Values) (argus.FlowQuery, error) {
+	query := argus.FlowQuery{
+		Protocol: values.Get("protocol"),
+		Cursor:   values.Get("cursor"),
+	}
+
+	var err error
+	for name, dst := range map[string]**net.IPNet{"src_ip": &query.SrcIP, "dst_ip": &query.DstIP} {
+		if v := values.Get(name); v != "" {
+			if *dst, err = argus.ParseIPFilter(v); err != nil {
+				return query, fmt.Errorf("invalid %s: %q", name, v)
+			}
+		}
+	}
+	for name, dst := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if v := values.Get(name); v != "" {
+			if *dst, err = time.Parse(time.RFC3339, v); err != nil {
+				return query, fmt.Errorf("invalid %s: %q", name, v)
+			}
+		}
+	}
+	if v := values.Get("min_packets"); v != "" {
+		if query.MinPackets, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return query, fmt.Errorf("invalid min_packets: %q", v)
+		}
+	}
+	if v := values.Get("is_bot"); v != "" {
+		isBot, err := strconv.ParseBool(v)
+		if err != nil {
+			return query, fmt.Errorf("invalid is_bot: %q", v)
+		}
+		query.IsBot = &isBot
+	}
+	if v := values.Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil || query.Limit < 1 {
+			return query, fmt.Errorf("invalid limit: %q", v)
+		}
+	}
+
+	return query, nil
 }
 
 // handleAnalyze handles manual analysis requests
@@ -300,6 +525,94 @@ func (s *Server) handleAnalyze(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, http.StatusOK, result)
 }
 
+// handleAnalyzeBatch handles analysis requests for several feature vectors.
+// Items are analyzed concurrently and fail individually.
+func (s *Server) handleAnalyzeBatch(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Items []cortex.BatchItem `json:"items"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if len(request.Items) == 0 {
+		s.writeError(w, http.StatusBadRequest, "Items array is required")
+		return
+	}
+	if len(request.Items) > cortex.MaxBatchItems {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Too many items: at most %d per batch", cortex.MaxBatchItems))
+		return
+	}
+
+	now := time.Now().Unix()
+	for i := range request.Items {
+		if request.Items[i].FlowID == "" {
+			request.Items[i].FlowID = fmt.Sprintf("manual_%d_%d", now, i)
+		}
+	}
+
+	results := s.cortexEngine.AnalyzeBatch(r.Context(), request.Items)
+
+	var failed int
+	for _, item := range results {
+		switch {
+		case item.Result == nil:
+			failed++
+		case item.Result.IsBot:
+			s.metrics.botDetections.Inc()
+		default:
+			s.metrics.humanDetections.Inc()
+		}
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results": results,
+		"failed":  failed,
+	})
+}
+
+// handleGetFilter returns the active capture filter
+func (s *Server) handleGetFilter(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"filter": s.argusEngine.BPFFilter(),
+	})
+}
+
+// handleSetFilter replaces the capture filter at runtime
+func (s *Server) handleSetFilter(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Filter *string `json:"filter"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if request.Filter == nil {
+		s.writeError(w, http.StatusBadRequest, "Filter is required")
+		return
+	}
+
+	if err := s.argusEngine.SetBPFFilter(*request.Filter); err != nil {
+		switch {
+		case errors.Is(err, argus.ErrInvalidFilter):
+			s.writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, argus.ErrFilterUnsupported):
+			s.writeError(w, http.StatusConflict, err.Error())
+		default:
+			s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to update filter: %v", err))
+		}
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"filter": *request.Filter,
+	})
+}
+
 // writeJSON writes a JSON response
 func (s *Server) writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -321,6 +634,84 @@ func (s *Server) writeError(w http.ResponseWriter, status int, message string) {
 	s.writeJSON(w, status, response)
 }
 
+// requireAuth rejects requests without the configured bearer token or an
+// OIDC token granting the admin role. Endpoints wrapped with it stay
+// disabled until an api_token or OIDC issuer is configured.
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+	return s.requireRole(auth.RoleAdmin, next)
+}
+
+// requireRead rejects requests without the configured bearer token or an
+// OIDC token granting the read or admin role. Without OIDC, data endpoints
+// wrapped with it are open as before.
+func (s *Server) requireRead(next http.Handler) http.Handler {
+	if s.verifier == nil {
+		return next
+	}
+	return s.requireRole(auth.RoleRead, next)
+}
+
+// requireRole rejects requests without the configured bearer token or an
+// OIDC token granting role; admins are granted every role
+func (s *Server) requireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.APIToken == "" && s.verifier == nil {
+			s.writeError(w, http.StatusForbidden, "Endpoint disabled: no API token configured")
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && s.config.APIToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.APIToken)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if ok && s.verifier != nil {
+			claims, err := s.verifier.Verify(r.Context(), token)
+			if err == nil {
+				if !claims.HasRole(role) && !claims.HasRole(auth.RoleAdmin) {
+					s.writeError(w, http.StatusForbidden, fmt.Sprintf("Forbidden: %s role required", role))
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			slog.Debug("Rejected bearer token", "error", err)
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="argus-cortex"`)
+		s.writeError(w, http.StatusUnauthorized, "Unauthorized")
+	})
+}
+
+// validToken reports whether token is the configured API token or a JWT
+// the OIDC verifier accepts
+func (s *Server) validToken(ctx context.Context, token string) bool {
+	if s.config.APIToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.APIToken)) == 1 {
+		return true
+	}
+	if s.verifier != nil {
+		_, err := s.verifier.Verify(ctx, token)
+		return err == nil
+	}
+	return false
+}
+
+// requireClientCert rejects requests without a verified client certificate
+// when a client CA bundle is configured
+func (s *Server) requireClientCert(next http.Handler) http.Handler {
+	if s.config.ClientCA == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.HasClientCert(r.TLS) {
+			s.writeError(w, http.StatusUnauthorized, "Client certificate required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // loggingMiddleware logs HTTP requests
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -376,3 +767,9 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap exposes the underlying writer to http.ResponseController, so
+// streaming handlers can flush through the middleware
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/auth"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireRole(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	call := func(handler http.Handler, token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/flows", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Without OIDC, data endpoints are open and mutating ones need the token
+	s := &Server{config: config.ServerConfig{APIToken: "secret"}}
+	assert.Equal(t, http.StatusOK, call(s.requireRead(ok), ""))
+	assert.Equal(t, http.StatusUnauthorized, call(s.requireAuth(ok), ""))
+	assert.Equal(t, http.StatusOK, call(s.requireAuth(ok), "secret"))
+
+	// With OIDC, data endpoints need the token or a verified OIDC token
+	s.verifier = auth.NewVerifier(config.OIDCConfig{Issuer: "https://issuer.invalid"})
+	assert.Equal(t, http.StatusUnauthorized, call(s.requireRead(ok), ""))
+	assert.Equal(t, http.StatusUnauthorized, call(s.requireRead(ok), "not-a-token"))
+	assert.Equal(t, http.StatusOK, call(s.requireRead(ok), "secret"))
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// sseHeartbeatInterval is how often an idle stream sends a comment so
+	// proxies do not close it
+	sseHeartbeatInterval = 15 * time.Second
+	// sseWriteTimeout bounds each write to a stream client
+	sseWriteTimeout = 10 * time.Second
+)
+
+// handleDetectionStream streams detection events as Server-Sent Events.
+// Clients resume after a reconnect with the standard Last-Event-ID header;
+// IDs from before a server restart start the stream afresh.
+func (s *Server) handleDetectionStream(w http.ResponseWriter, r *http.Request) {
+	missed, events, cancel := s.argusEngine.SubscribeDetections(r.Header.Get("Last-Event-ID"))
+	defer cancel()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable nginx response buffering
+	w.WriteHeader(http.StatusOK)
+
+	// send writes one SSE frame, bounded by its own deadline since the
+	// server-wide write timeout would end the stream
+	send := func(frame string) bool {
+		if err := rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return false
+		}
+		if _, err := fmt.Fprint(w, frame); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	if !send(": connected\n\n") {
+		return
+	}
+	for _, event := range missed {
+		if !send(sseEvent(event.ID, event.Result)) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.stopping:
+			return
+		case event, ok := <-events:
+			if !ok || !send(sseEvent(event.ID, event.Result)) {
+				return
+			}
+		case <-heartbeat.C:
+			if !send(": heartbeat\n\n") {
+				return
+			}
+		}
+	}
+}
+
+// sseEvent formats a detection as an SSE frame
+func sseEvent(id string, data interface{}) string {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		slog.Error("Failed to encode detection event", "error", err)
+		payload = []byte("null")
+	}
+	return fmt.Sprintf("id: %s\nevent: detection\ndata: %s\n\n", id, payload)
+}
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minRefreshInterval limits refetches of the key set, whether triggered by
+// tokens signed with unknown keys or by retries while the issuer is down
+const minRefreshInterval = time.Minute
+
+// ErrUnknownKey is returned when a token is signed by a key the issuer
+// does not publish
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// KeySet fetches and caches the JSON Web Key Set of an OIDC issuer. Keys
+// are refetched when the cache expires or a token names an unknown key, so
+// issuer key rotation is picked up without a restart. Fetches run outside
+// the lock, one at a time, and at most once per minRefreshInterval.
+type KeySet struct {
+	issuer string
+	ttl    time.Duration
+	client *http.Client
+
+	mu        sync.Mutex
+	jwksURL   string
+	keys      map[string]crypto.PublicKey
+	fetched   time.Time     // last successful fetch
+	attempted time.Time     // last fetch, successful or not
+	lastErr   error         // error of the last fetch
+	inflight  chan struct{} // closed when the running fetch completes
+}
+
+// NewKeySet creates a key set for an issuer. The key set URL is discovered
+// from the issuer's OpenID configuration when jwksURL is empty. Nothing is
+// fetched until the first key lookup.
+func NewKeySet(issuer, jwksURL string, ttl time.Duration) *KeySet {
+	return &KeySet{
+		issuer:  strings.TrimSuffix(issuer, "/"),
+		jwksURL: jwksURL,
+		ttl:     ttl,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Key returns the public key with the given key ID. Tokens without a key ID
+// are accepted when the issuer publishes a single key. Expired cached keys
+// keep being served while the key set is refetched in the background.
+func (k *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	key, ok := k.lookup(kid)
+	if ok && time.Since(k.fetched) < k.ttl {
+		k.mu.Unlock()
+		return key, nil
+	}
+	if !k.attempted.IsZero() && time.Since(k.attempted) < minRefreshInterval {
+		err := k.lastErr
+		k.mu.Unlock()
+		switch {
+		case ok:
+			return key, nil
+		case err != nil:
+			return nil, err
+		}
+		return nil, ErrUnknownKey
+	}
+
+	done := k.inflight
+	if done == nil {
+		done = k.startRefresh()
+	}
+	k.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok = k.lookup(kid); ok {
+		return key, nil
+	}
+	if k.lastErr != nil {
+		return nil, k.lastErr
+	}
+	return nil, ErrUnknownKey
+}
+
+// lookup finds a cached key
+func (k *KeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// startRefresh fetches the key set in the background and returns a channel
+// closed once it completes. The caller holds k.mu.
+func (k *KeySet) startRefresh() chan struct{} {
+	done := make(chan struct{})
+	k.inflight = done
+	k.attempted = time.Now()
+	jwksURL := k.jwksURL
+
+	go func() {
+		// Callers only wait for the fetch; it is bounded by the client timeout
+		jwksURL, keys, err := k.fetch(context.Background(), jwksURL)
+
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		k.lastErr = err
+		if err != nil {
+			if len(k.keys) > 0 {
+				slog.Warn("Failed to refresh JWKS, using cached keys", "issuer", k.issuer, "error", err)
+			}
+		} else {
+			k.jwksURL = jwksURL
+			k.keys = keys
+			k.fetched = time.Now()
+			slog.Debug("Fetched JWKS", "url", jwksURL, "keys", len(keys))
+		}
+		k.inflight = nil
+		close(done)
+	}()
+
+	return done
+}
+
+// fetch fetches the key set, discovering its URL first if needed
+func (k *KeySet) fetch(ctx context.Context, jwksURL string) (string, map[string]crypto.PublicKey, error) {
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := k.getJSON(ctx, k.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return "", nil, fmt.Errorf("failed to discover OIDC configuration: %w", err)
+		}
+		if discovery.JWKSURI == "" {
+			return "", nil, fmt.Errorf("OIDC configuration of %s has no jwks_uri", k.issuer)
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := k.getJSON(ctx, jwksURL, &set); err != nil {
+		return "", nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			slog.Warn("Skipping unusable JWK", "kid", jwk.Kid, "error", err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return jwksURL, keys, nil
+}
+
+// getJSON fetches and decodes a JSON document
+func (k *KeySet) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// jsonWebKey is a public key of a JWKS document (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes the key material
+func (j *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("RSA exponent out of range")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", j.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+}
+
+// decodeBigInt decodes a base64url-encoded big-endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter %q", s)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
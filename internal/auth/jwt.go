@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+// Application roles. RoleRead is required by the data endpoints once OIDC
+// is configured, RoleAdmin by mutating endpoints such as the capture filter.
+// Admins may also read.
+const (
+	RoleRead  = "read"
+	RoleAdmin = "admin"
+)
+
+// clockSkew is the leeway allowed when checking token lifetimes
+const clockSkew = time.Minute
+
+// ErrInvalidToken is returned for tokens that fail verification
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the verified claims of a token
+type Claims struct {
+	Subject string
+	Roles   []string // mapped application roles
+	Raw     map[string]interface{}
+}
+
+// HasRole reports whether the token grants role
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Verifier validates JWTs issued by an OIDC provider and maps their claims
+// to application roles
+type Verifier struct {
+	config config.OIDCConfig
+	keys   *KeySet
+	now    func() time.Time
+}
+
+// NewVerifier creates a verifier for the configured issuer
+func NewVerifier(cfg config.OIDCConfig) *Verifier {
+	return &Verifier{
+		config: cfg,
+		keys:   NewKeySet(cfg.Issuer, cfg.JWKSURL, time.Duration(cfg.JWKSCacheTTL)*time.Second),
+		now:    time.Now,
+	}
+}
+
+// Verify checks the signature, issuer, audience and lifetime of a compact
+// JWS token and returns its claims
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	key, err := v.keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var raw map[string]interface{}
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	if err := v.validate(raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	claims := &Claims{Raw: raw}
+	claims.Subject, _ = raw["sub"].(string)
+	for _, value := range claimStrings(raw, v.config.RolesClaim) {
+		if len(v.config.RoleMapping) == 0 {
+			claims.Roles = append(claims.Roles, value)
+		} else if role, ok := v.config.RoleMapping[strings.ToLower(value)]; ok {
+			claims.Roles = append(claims.Roles, role)
+		}
+	}
+	return claims, nil
+}
+
+// validate checks the registered claims of a token
+func (v *Verifier) validate(raw map[string]interface{}) error {
+	if iss, _ := raw["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(v.config.Issuer, "/") {
+		return fmt.Errorf("unexpected issuer %q", iss)
+	}
+
+	if v.config.Audience != "" {
+		var audiences []string
+		switch aud := raw["aud"].(type) {
+		case string:
+			audiences = []string{aud}
+		case []interface{}:
+			for _, a := range aud {
+				if s, ok := a.(string); ok {
+					audiences = append(audiences, s)
+				}
+			}
+		}
+		found := false
+		for _, aud := range audiences {
+			found = found || aud == v.config.Audience
+		}
+		if !found {
+			return fmt.Errorf("token not issued for audience %q", v.config.Audience)
+		}
+	}
+
+	now := v.now()
+	exp, ok := raw["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("missing exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, ok := raw["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token not valid yet")
+	}
+	return nil
+}
+
+// verifySignature checks a JWS signature. Only asymmetric algorithms are
+// accepted, so a token can never be verified with a shared secret.
+func verifySignature(alg string, key crypto.PublicKey, input string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s does not match key type", alg)
+		}
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(pub, hash, digest, signature, nil)
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+	case strings.HasPrefix(alg, "ES"):
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s does not match key type", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("malformed ECDSA signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("signature verification failed")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+// decodeSegment decodes a base64url-encoded JSON token segment
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// claimStrings returns the string values of a claim addressed by a dotted
+// path. A space-separated string is split, as used by the scope claim.
+func claimStrings(raw map[string]interface{}, path string) []string {
+	var value interface{} = raw
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = obj[name]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testIssuer serves OIDC discovery and a JWKS document
+type testIssuer struct {
+	*httptest.Server
+	keys       atomic.Value // []map[string]string
+	jwksWanted atomic.Int32
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	issuer := &testIssuer{}
+	issuer.keys.Store([]map[string]string{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": issuer.URL, "jwks_uri": issuer.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.jwksWanted.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": issuer.keys.Load()})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig",
+		"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": b64(key.X.FillBytes(make([]byte, 32))), "y": b64(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// sign creates a compact JWS with an RS256 or ES256 signature
+func sign(t *testing.T, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	input := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + b64(signature)
+}
+
+func TestVerifier(t *testing.T) {
+	issuer := newTestIssuer(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	issuer.keys.Store([]map[string]string{rsaJWK("rsa-1", rsaKey)})
+
+	verifier := NewVerifier(config.OIDCConfig{
+		Issuer:       issuer.URL,
+		Audience:     "argus",
+		JWKSCacheTTL: 3600,
+		RolesClaim:   "realm_access.roles",
+		RoleMapping:  map[string]string{"argus-admins": RoleAdmin},
+	})
+	ctx := context.Background()
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":          issuer.URL,
+			"aud":          []string{"other", "argus"},
+			"sub":          "analyst",
+			"exp":          time.Now().Add(time.Hour).Unix(),
+			"realm_access": map[string]interface{}{"roles": []string{"argus-admins", "unmapped"}},
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	verified, err := verifier.Verify(ctx, sign(t, "rsa-1", rsaKey, claims(nil)))
+	require.NoError(t, err)
+	assert.Equal(t, "analyst", verified.Subject)
+	assert.Equal(t, []string{RoleAdmin}, verified.Roles)
+	assert.True(t, verified.HasRole(RoleAdmin))
+
+	for name, token := range map[string]string{
+		"expired":        sign(t, "rsa-1", rsaKey, claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"wrong issuer":   sign(t, "rsa-1", rsaKey, claims(map[string]interface{}{"iss": "https://evil.example"})),
+		"wrong audience": sign(t, "rsa-1", rsaKey, claims(map[string]interface{}{"aud": "other"})),
+		"not yet valid":  sign(t, "rsa-1", rsaKey, claims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()})),
+		"alg none":       b64([]byte(`{"alg":"none","kid":"rsa-1"}`)) + "." + b64([]byte(`{}`)) + ".",
+		"malformed":      "not-a-token",
+	} {
+		_, err := verifier.Verify(ctx, token)
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+
+	// Tampered payloads fail the signature check
+	original := sign(t, "rsa-1", rsaKey, claims(nil))
+	forged := sign(t, "rsa-1", rsaKey, claims(map[string]interface{}{"sub": "mallory"}))
+	tampered := forged[:strings.LastIndexByte(forged, '.')] + original[strings.LastIndexByte(original, '.'):]
+	_, err = verifier.Verify(ctx, tampered)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// A rotated-in key is fetched when a token names it
+	fetches := issuer.jwksWanted.Load()
+	issuer.keys.Store([]map[string]string{rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey)})
+	verifier.keys.attempted = time.Now().Add(-2 * minRefreshInterval)
+	verified, err = verifier.Verify(ctx, sign(t, "ec-1", ecKey, claims(nil)))
+	require.NoError(t, err)
+	assert.True(t, verified.HasRole(RoleAdmin))
+	assert.Equal(t, fetches+1, issuer.jwksWanted.Load())
+
+	// Unknown keys do not trigger a refetch on every request
+	_, err = verifier.Verify(ctx, sign(t, "unknown", ecKey, claims(nil)))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, fetches+1, issuer.jwksWanted.Load())
+}
+
+func TestKeySetIssuerDown(t *testing.T) {
+	issuer := newTestIssuer(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	issuer.keys.Store([]map[string]string{rsaJWK("rsa-1", rsaKey)})
+
+	keys := NewKeySet(issuer.URL, issuer.URL+"/jwks", time.Hour)
+	ctx := context.Background()
+	_, err = keys.Key(ctx, "rsa-1")
+	require.NoError(t, err)
+
+	// Once the cache expires with the issuer down, cached keys keep being
+	// served and the failed fetch is not retried on every lookup
+	issuer.Close()
+	keys.mu.Lock()
+	keys.fetched = time.Now().Add(-2 * time.Hour)
+	keys.attempted = keys.fetched
+	keys.mu.Unlock()
+
+	for i := 0; i < 10; i++ {
+		key, err := keys.Key(ctx, "rsa-1")
+		require.NoError(t, err)
+		assert.NotNil(t, key)
+	}
+	keys.mu.Lock()
+	done := keys.inflight
+	keys.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	assert.Error(t, keys.lastErr)
+	assert.WithinDuration(t, time.Now(), keys.attempted, minRefreshInterval)
+	assert.Equal(t, int32(1), issuer.jwksWanted.Load())
+}
+
+func TestClaimStrings(t *testing.T) {
+	raw := map[string]interface{}{
+		"scope":  "read write",
+		"groups": []interface{}{"a", 1, "b"},
+		"nested": map[string]interface{}{"roles": []interface{}{"x"}},
+	}
+	assert.Equal(t, []string{"read", "write"}, claimStrings(raw, "scope"))
+	assert.Equal(t, []string{"a", "b"}, claimStrings(raw, "groups"))
+	assert.Equal(t, []string{"x"}, claimStrings(raw, "nested.roles"))
+	assert.Nil(t, claimStrings(raw, "missing.roles"))
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues client certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue creates a client certificate signed by the CA
+func (ca *testCA) issue(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	ca, rogue := newTestCA(t), newTestCA(t)
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(bundle, ca.pem, 0o600))
+
+	cfg, err := ServerTLSConfig("", true)
+	require.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+
+	_, err = ServerTLSConfig(filepath.Join(t.TempDir(), "missing.pem"), false)
+	assert.Error(t, err)
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, os.WriteFile(empty, []byte("not a certificate"), 0o600))
+	_, err = ServerTLSConfig(empty, false)
+	assert.Error(t, err)
+
+	for _, required := range []bool{false, true} {
+		t.Run(fmt.Sprintf("required=%v", required), func(t *testing.T) {
+			cfg, err := ServerTLSConfig(bundle, required)
+			require.NoError(t, err)
+
+			ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, HasClientCert(r.TLS))
+			}))
+			ts.TLS = cfg
+			ts.StartTLS()
+			defer ts.Close()
+
+			get := func(certs ...tls.Certificate) (string, error) {
+				roots := x509.NewCertPool()
+				roots.AddCert(ts.Certificate())
+				// A new transport per request, so every request handshakes
+				client := &http.Client{Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+				}}
+				resp, err := client.Get(ts.URL)
+				if err != nil {
+					return "", err
+				}
+				defer resp.Body.Close()
+				var body [8]byte
+				n, _ := resp.Body.Read(body[:])
+				return string(body[:n]), nil
+			}
+
+			body, err := get(ca.issue(t, "sensor-1"))
+			require.NoError(t, err)
+			assert.Equal(t, "true", body)
+
+			// Certificates from other CAs never verify
+			_, err = get(rogue.issue(t, "intruder"))
+			assert.Error(t, err)
+
+			body, err = get()
+			if required {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "false", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package cortex
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// MaxBatchItems bounds the number of feature vectors in one batch analysis
+const MaxBatchItems = 1000
+
+// BatchItem is one feature vector of a batch analysis
+type BatchItem struct {
+	FlowID   string    `json:"flow_id"`
+	Features []float64 `json:"features"`
+}
+
+// BatchResult is the outcome of one batch item. Exactly one of Result and
+// Error is set.
+type BatchResult struct {
+	FlowID string           `json:"flow_id"`
+	Result *DetectionResult `json:"result,omitempty"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// analyzeFunc runs a single analysis
+type analyzeFunc func(ctx context.Context, features []float64, flowID string) (*DetectionResult, error)
+
+// analyzeBatch runs items through analyze on the given number of workers.
+// Results are in the order of items; items not started before ctx is done
+// fail with its error.
+func analyzeBatch(ctx context.Context, items []BatchItem, workers int, analyze analyzeFunc) []BatchResult {
+	results := make([]BatchResult, len(items))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, len(items)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = BatchResult{FlowID: items[i].FlowID}
+				if err := ctx.Err(); err != nil {
+					results[i].Error = err.Error()
+					continue
+				}
+				result, err := analyze(ctx, items[i].Features, items[i].FlowID)
+				if err != nil {
+					results[i].Error = err.Error()
+					continue
+				}
+				results[i].Result = result
+			}
+		}()
+	}
+
+	for i := range items {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results
+}
+
+// AnalyzeBatch analyzes several feature vectors concurrently. A failing
+// item does not affect the others.
+func (e *Engine) AnalyzeBatch(ctx context.Context, items []BatchItem) []BatchResult {
+	return analyzeBatch(ctx, items, runtime.GOMAXPROCS(0), e.Analyze)
+}
+
+// AnalyzeBatch analyzes several feature vectors concurrently, as many at
+// a time as the configured maximum concurrency. A failing item does not
+// affect the others.
+func (e *MLCortexEngine) AnalyzeBatch(ctx context.Context, items []BatchItem) []BatchResult {
+	return analyzeBatch(ctx, items, max(e.GetConfig().MaxConcurrency, 1), e.Analyze)
+}
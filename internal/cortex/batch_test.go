@@ -0,0 +1,56 @@
+package cortex
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+func TestAnalyzeBatch(t *testing.T) {
+	engine, err := NewEngine(config.CortexConfig{DetectionThreshold: 0.85})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	items := make([]BatchItem, 50)
+	for i := range items {
+		items[i] = BatchItem{FlowID: fmt.Sprintf("flow-%d", i), Features: make([]float64, 128)}
+	}
+	// A malformed vector fails on its own
+	items[7].Features = make([]float64, 3)
+
+	results := engine.AnalyzeBatch(context.Background(), items)
+	if len(results) != len(items) {
+		t.Fatalf("Expected %d results, got %d", len(items), len(results))
+	}
+
+	for i, result := range results {
+		if result.FlowID != items[i].FlowID {
+			t.Errorf("Result %d: expected flow ID %s, got %s", i, items[i].FlowID, result.FlowID)
+		}
+		if i == 7 {
+			if result.Error == "" || result.Result != nil {
+				t.Errorf("Expected item 7 to fail, got %+v", result)
+			}
+			continue
+		}
+		if result.Error != "" || result.Result == nil {
+			t.Errorf("Result %d: unexpected failure %q", i, result.Error)
+		}
+	}
+
+	if stats := engine.GetStatistics(); stats.TotalInferences < 49 {
+		t.Errorf("Expected at least 49 inferences, got %d", stats.TotalInferences)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, result := range engine.AnalyzeBatch(ctx, items[:3]) {
+		if result.Error != context.Canceled.Error() {
+			t.Errorf("Expected cancelled item, got %+v", result)
+		}
+	}
+}
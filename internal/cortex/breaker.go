@@ -0,0 +1,191 @@
+package cortex
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// LatencyBreaker tracks inference latency against an SLO and opens when the
+// ML path persistently exceeds it, so callers can fall back to a cheaper path
+type LatencyBreaker struct {
+	slo       time.Duration
+	tripRatio float64
+	cooldown  time.Duration
+
+	state       string
+	probing     bool   // a half-open probe is in flight
+	samples     []bool // true when the sample exceeded the SLO
+	next        int
+	filled      int
+	openedAt    time.Time
+	violations  int64
+	transitions map[string]int64
+	mu          sync.Mutex
+}
+
+// BreakerStats holds latency SLO and circuit breaker statistics
+type BreakerStats struct {
+	State         string           `json:"state"`
+	SLO           time.Duration    `json:"slo"`
+	SLOViolations int64            `json:"slo_violations"`
+	Transitions   map[string]int64 `json:"transitions"`
+}
+
+// NewLatencyBreaker creates a breaker that opens when at least tripRatio of
+// the last window samples exceeded slo, and probes again after cooldown. A
+// window below one sample is treated as one.
+func NewLatencyBreaker(slo time.Duration, window int, tripRatio float64, cooldown time.Duration) *LatencyBreaker {
+	if window < 1 {
+		window = 1
+	}
+	return &LatencyBreaker{
+		slo:         slo,
+		tripRatio:   tripRatio,
+		cooldown:    cooldown,
+		state:       BreakerClosed,
+		samples:     make([]bool, window),
+		transitions: make(map[string]int64),
+	}
+}
+
+// Allow reports whether a request may take the ML path. While half-open only
+// the single probe admitted after the cooldown is allowed through, until its
+// latency is recorded.
+func (b *LatencyBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.transition(BreakerHalfOpen)
+		b.probing = true
+		return true
+
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+
+	return true
+}
+
+// Record records the latency of a completed ML inference
+func (b *LatencyBreaker) Record(latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	exceeded := latency > b.slo
+	if exceeded {
+		b.violations++
+	}
+
+	switch b.state {
+	case BreakerHalfOpen:
+		// A single probe decides whether latency has recovered
+		b.probing = false
+		if exceeded {
+			b.open()
+		} else {
+			b.reset()
+			b.transition(BreakerClosed)
+		}
+
+	case BreakerClosed:
+		b.samples[b.next] = exceeded
+		b.next = (b.next + 1) % len(b.samples)
+		if b.filled < len(b.samples) {
+			b.filled++
+		}
+
+		if b.filled == len(b.samples) && b.exceededRatio() >= b.tripRatio {
+			b.open()
+		}
+	}
+}
+
+// Release returns an admission that ran no ML inference, such as a cache
+// hit, without recording a sample, so a half-open breaker admits the next
+// probe
+func (b *LatencyBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// State returns the current breaker state
+func (b *LatencyBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Stats returns a snapshot of the breaker statistics
+func (b *LatencyBreaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	transitions := make(map[string]int64, len(b.transitions))
+	for k, v := range b.transitions {
+		transitions[k] = v
+	}
+
+	return BreakerStats{
+		State:         b.state,
+		SLO:           b.slo,
+		SLOViolations: b.violations,
+		Transitions:   transitions,
+	}
+}
+
+// exceededRatio returns the fraction of window samples above the SLO
+func (b *LatencyBreaker) exceededRatio() float64 {
+	var exceeded int
+	for _, s := range b.samples[:b.filled] {
+		if s {
+			exceeded++
+		}
+	}
+	return float64(exceeded) / float64(b.filled)
+}
+
+// open moves the breaker to the open state and starts the cooldown
+func (b *LatencyBreaker) open() {
+	b.openedAt = time.Now()
+	b.reset()
+	b.transition(BreakerOpen)
+}
+
+// reset clears the sample window
+func (b *LatencyBreaker) reset() {
+	for i := range b.samples {
+		b.samples[i] = false
+	}
+	b.next = 0
+	b.filled = 0
+}
+
+// transition records and logs a state change
+func (b *LatencyBreaker) transition(to string) {
+	from := b.state
+	b.state = to
+	b.transitions[from+"->"+to]++
+
+	slog.Warn("Inference circuit breaker state changed",
+		"from", from,
+		"to", to,
+		"slo", b.slo)
+}
@@ -0,0 +1,114 @@
+package cortex
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyBreakerTripsAndRecovers(t *testing.T) {
+	breaker := NewLatencyBreaker(10*time.Millisecond, 4, 0.5, 20*time.Millisecond)
+
+	// Fast inferences keep the breaker closed
+	for i := 0; i < 4; i++ {
+		breaker.Record(time.Millisecond)
+	}
+	if breaker.State() != BreakerClosed {
+		t.Fatalf("Expected closed breaker, got %s", breaker.State())
+	}
+
+	// Half of the window exceeding the SLO trips it
+	breaker.Record(50 * time.Millisecond)
+	breaker.Record(50 * time.Millisecond)
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("Expected open breaker, got %s", breaker.State())
+	}
+	if breaker.Allow() {
+		t.Error("Open breaker should not allow the ML path during cooldown")
+	}
+
+	// After the cooldown a probe is allowed and a fast result closes it
+	time.Sleep(25 * time.Millisecond)
+	if !breaker.Allow() {
+		t.Fatal("Breaker should allow a probe after cooldown")
+	}
+	if breaker.State() != BreakerHalfOpen {
+		t.Fatalf("Expected half-open breaker, got %s", breaker.State())
+	}
+	breaker.Record(time.Millisecond)
+	if breaker.State() != BreakerClosed {
+		t.Fatalf("Expected closed breaker after recovery, got %s", breaker.State())
+	}
+
+	stats := breaker.Stats()
+	if stats.SLOViolations != 2 {
+		t.Errorf("Expected 2 SLO violations, got %d", stats.SLOViolations)
+	}
+	for _, transition := range []string{"closed->open", "open->half_open", "half_open->closed"} {
+		if stats.Transitions[transition] != 1 {
+			t.Errorf("Expected 1 %s transition, got %d", transition, stats.Transitions[transition])
+		}
+	}
+}
+
+func TestLatencyBreakerReopensOnSlowProbe(t *testing.T) {
+	breaker := NewLatencyBreaker(10*time.Millisecond, 1, 1.0, time.Millisecond)
+
+	breaker.Record(50 * time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	breaker.Allow()
+	breaker.Record(50 * time.Millisecond)
+
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("Expected breaker to reopen after slow probe, got %s", breaker.State())
+	}
+	if n := breaker.Stats().Transitions["half_open->open"]; n != 1 {
+		t.Errorf("Expected 1 half_open->open transition, got %d", n)
+	}
+}
+
+func TestLatencyBreakerAdmitsSingleProbe(t *testing.T) {
+	breaker := NewLatencyBreaker(10*time.Millisecond, 1, 1.0, time.Millisecond)
+
+	breaker.Record(50 * time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	if !breaker.Allow() {
+		t.Fatal("Breaker should allow a probe after cooldown")
+	}
+	if breaker.Allow() {
+		t.Error("Half-open breaker should not admit a second caller while the probe is in flight")
+	}
+
+	breaker.Record(time.Millisecond)
+	if !breaker.Allow() {
+		t.Error("Closed breaker should allow the ML path")
+	}
+}
+
+func TestLatencyBreakerReleasesProbe(t *testing.T) {
+	breaker := NewLatencyBreaker(10*time.Millisecond, 1, 1.0, time.Millisecond)
+
+	breaker.Record(50 * time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	// A released probe leaves the breaker half-open for the next caller
+	if !breaker.Allow() {
+		t.Fatal("Breaker should allow a probe after cooldown")
+	}
+	breaker.Release()
+	if breaker.State() != BreakerHalfOpen {
+		t.Fatalf("Expected half-open breaker, got %s", breaker.State())
+	}
+	if !breaker.Allow() {
+		t.Error("Half-open breaker should admit a probe after a release")
+	}
+}
+
+func TestLatencyBreakerWithoutWindow(t *testing.T) {
+	breaker := NewLatencyBreaker(10*time.Millisecond, 0, 1.0, time.Second)
+
+	breaker.Record(50 * time.Millisecond)
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("Expected open breaker, got %s", breaker.State())
+	}
+}
@@ -0,0 +1,31 @@
+package cortex
+
+import (
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var predictionCacheLookups = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "argus_cortex_prediction_cache_lookups_total",
+		Help: "Total number of predictions looked up in the prediction cache, by hit or miss",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(predictionCacheLookups)
+}
+
+// observeCache counts the prediction cache lookup behind a model result
+// when caching is enabled. The caller holds e.mu.
+func (e *MLCortexEngine) observeCache(result *ml.DetectionResult) {
+	if e.config.CacheSize <= 0 {
+		return
+	}
+	if result.Cached {
+		predictionCacheLookups.WithLabelValues("hit").Inc()
+	} else {
+		predictionCacheLookups.WithLabelValues("miss").Inc()
+	}
+}
@@ -0,0 +1,51 @@
+package cortex
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEnginePredictionCache(t *testing.T) {
+	cfg := config.DefaultMLConfig()
+	cfg.ModelType = "svm"
+	cfg.FeatureSize = 8
+	cfg.FakeDataSize = 50
+	cfg.ModelDir = t.TempDir()
+	cfg.ModelPath = filepath.Join(t.TempDir(), "model")
+	cfg.CacheSize = 10
+
+	engine, err := NewMLCortexEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+	// Every recorded inference violates a negative SLO
+	engine.breaker = NewLatencyBreaker(-1, 100, 1.0, time.Second)
+
+	hits := testutil.ToFloat64(predictionCacheLookups.WithLabelValues("hit"))
+	misses := testutil.ToFloat64(predictionCacheLookups.WithLabelValues("miss"))
+	features := make([]float64, cfg.FeatureSize)
+	for i := 0; i < 3; i++ {
+		if _, err := engine.Analyze(context.Background(), features, "flow"); err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+	}
+
+	if got := testutil.ToFloat64(predictionCacheLookups.WithLabelValues("hit")) - hits; got != 2 {
+		t.Errorf("Expected 2 cache hits, got %v", got)
+	}
+	if got := testutil.ToFloat64(predictionCacheLookups.WithLabelValues("miss")) - misses; got != 1 {
+		t.Errorf("Expected 1 cache miss, got %v", got)
+	}
+	if stats := engine.GetStatistics(); stats.CacheHits != 2 || stats.CacheEntries != 1 {
+		t.Errorf("Expected 2 hits and 1 entry in statistics, got %d and %d", stats.CacheHits, stats.CacheEntries)
+	}
+	if n := engine.GetStatistics().Breaker.SLOViolations; n != 1 {
+		t.Errorf("Expected only the cache miss recorded by the breaker, got %d violations", n)
+	}
+}
@@ -0,0 +1,45 @@
+package cortex
+
+import (
+	"fmt"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/datasets"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+// trainingDatasets is the training and evaluation dataset versions
+// resolved from the configured references
+type trainingDatasets struct {
+	path           string
+	source         string // provenance of trained models, e.g. "dataset:flows@v3"
+	evaluationPath string
+}
+
+// resolveDatasets resolves the configured training and evaluation datasets
+// to their current versions. It returns nil when training does not use a
+// dataset from the store.
+func resolveDatasets(store *datasets.Store, cfg config.MLConfig) (*trainingDatasets, error) {
+	if store == nil || cfg.TrainingDataset == "" {
+		return nil, nil
+	}
+	train, err := store.Resolve(cfg.TrainingDataset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve training dataset: %w", err)
+	}
+	resolved := &trainingDatasets{path: train.Path, source: "dataset:" + train.String()}
+	if cfg.EvaluationDataset != "" {
+		evaluation, err := store.Resolve(cfg.EvaluationDataset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve evaluation dataset: %w", err)
+		}
+		resolved.evaluationPath = evaluation.Path
+		resolved.source += " eval:" + evaluation.String()
+	}
+	return resolved, nil
+}
+
+// Datasets returns the store of labelled datasets, nil when no dataset
+// directory is configured
+func (e *MLCortexEngine) Datasets() *datasets.Store {
+	return e.datasets
+}
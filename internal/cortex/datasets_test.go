@@ -0,0 +1,88 @@
+package cortex
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/datasets"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/dataset"
+)
+
+// separableSamples returns n samples of two features, bots above 0.5
+func separableSamples(n int) datasets.Samples {
+	s := datasets.Samples{Features: []string{"rate", "jitter"}, Source: "test", LabelSource: "import"}
+	for i := 0; i < n; i++ {
+		label := i % 2
+		x := 0.1 + float64(i%10)/30 + 0.5*float64(label)
+		s.Records = append(s.Records, dataset.Record{Features: []float64{x, x}, Label: &label})
+	}
+	return s
+}
+
+func TestEngineTrainsOnDatasets(t *testing.T) {
+	dir := t.TempDir()
+	store, err := datasets.Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open dataset store: %v", err)
+	}
+	if _, err := store.Create("flows", "", separableSamples(60)); err != nil {
+		t.Fatalf("Failed to create dataset: %v", err)
+	}
+	if _, err := store.Create("holdout", "", separableSamples(20)); err != nil {
+		t.Fatalf("Failed to create dataset: %v", err)
+	}
+
+	cfg := config.DefaultMLConfig()
+	cfg.ModelType = "svm"
+	cfg.FeatureSize = 2
+	cfg.ModelDir = t.TempDir()
+	cfg.ModelPath = filepath.Join(t.TempDir(), "model")
+	cfg.DatasetDir = dir
+	cfg.TrainingDataset = "flows"
+	cfg.EvaluationDataset = "holdout@v1"
+	cfg.DriftWindow = 2
+
+	engine, err := NewMLCortexEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	const source = "dataset:flows@v1 eval:holdout@v1"
+	versions := engine.ModelVersions()
+	if got := versions[len(versions)-1].TrainingData; got.Source != source || got.Samples != 60 {
+		t.Errorf("Expected training on 60 samples of %s, got %+v", source, got)
+	}
+
+	for i := 0; i < cfg.DriftWindow; i++ {
+		if _, err := engine.Analyze(context.Background(), []float64{0.2, 0.2}, "flow"); err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+	}
+	report, err := engine.DriftReport()
+	if err != nil {
+		t.Fatalf("DriftReport failed: %v", err)
+	}
+	if report.Reference != source {
+		t.Errorf("Expected the drift reference %s, got %s", source, report.Reference)
+	}
+
+	// Retraining picks up the latest version
+	if _, err := engine.Datasets().Append("flows", separableSamples(20)); err != nil {
+		t.Fatalf("Failed to append to dataset: %v", err)
+	}
+	if err := engine.RetrainModel(context.Background()); err != nil {
+		t.Fatalf("RetrainModel failed: %v", err)
+	}
+	versions = engine.ModelVersions()
+	if got := versions[len(versions)-1].TrainingData; got.Source != "dataset:flows@v2 eval:holdout@v1" || got.Samples != 80 {
+		t.Errorf("Expected training on 80 samples of flows@v2, got %+v", got)
+	}
+
+	cfg.TrainingDataset = "flows@missing"
+	if _, err := NewMLCortexEngine(cfg); err == nil {
+		t.Error("Expected an error for an unknown dataset version")
+	}
+}
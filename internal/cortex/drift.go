@@ -0,0 +1,281 @@
+package cortex
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// driftReportFeatures is the number of features listed in drift reports
+const driftReportFeatures = 10
+
+var (
+	driftScorePSI = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "argus_cortex_drift_score_psi",
+			Help: "Population stability index of the confidence scores of the last drift window against held-out training scores",
+		},
+	)
+	driftFeaturePSIMax = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "argus_cortex_drift_feature_psi_max",
+			Help: "Highest population stability index of a feature in the last drift window against the training samples",
+		},
+	)
+	driftFeatures = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "argus_cortex_drift_features",
+			Help: "Number of features whose population stability index exceeded the drift threshold in the last drift window",
+		},
+	)
+	driftAlerts = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_drift_alerts_total",
+			Help: "Total number of drift windows whose population stability index exceeded the drift threshold",
+		},
+	)
+	driftRetrains = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_drift_retrains_total",
+			Help: "Total number of retrainings triggered by drift",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(driftScorePSI, driftFeaturePSIMax, driftFeatures, driftAlerts, driftRetrains)
+}
+
+// ErrNoDriftReport is returned for drift reports when drift monitoring is
+// disabled or no window has completed since the model was activated
+var ErrNoDriftReport = errors.New("no drift report")
+
+// FeatureDrift is the shift of one feature's distribution
+type FeatureDrift struct {
+	Index int     `json:"index"`
+	Name  string  `json:"name"`
+	PSI   float64 `json:"psi"`
+}
+
+// DriftReport compares a window of flows with the distribution the active
+// model was trained on, by population stability index (PSI)
+type DriftReport struct {
+	Flows     int     `json:"flows"`
+	Threshold float64 `json:"threshold"`
+
+	// Training data of the model the flows are compared with, e.g.
+	// "dataset:flows@v3"
+	Reference string `json:"reference,omitempty"`
+
+	// PSI of the confidence scores against those on held-out training
+	// samples; nil for models without held-out samples
+	ScorePSI *float64 `json:"score_psi,omitempty"`
+
+	// Highest feature PSI and the number of features above the threshold
+	MaxFeaturePSI   float64 `json:"max_feature_psi"`
+	DriftedFeatures int     `json:"drifted_features"`
+
+	Drifted     bool           `json:"drifted"`
+	Features    []FeatureDrift `json:"features"` // highest PSI first
+	CompletedAt time.Time      `json:"completed_at"`
+}
+
+// driftMonitor bins the features and scores of incoming flows in tumbling
+// windows and compares each completed window with the model's drift
+// reference
+type driftMonitor struct {
+	window    int
+	threshold float64
+	retrain   bool
+	cooldown  time.Duration
+
+	mu            sync.Mutex
+	reference     *ml.DriftReference
+	featureCounts [][]int64
+	scoreCounts   []int64
+	flows         int
+	last          *DriftReport
+	retraining    bool
+	lastRetrain   time.Time
+}
+
+func newDriftMonitor(window int, threshold float64, retrain bool, cooldown time.Duration) *driftMonitor {
+	return &driftMonitor{
+		window:    window,
+		threshold: threshold,
+		retrain:   retrain,
+		cooldown:  cooldown,
+	}
+}
+
+// reset starts comparing flows with a new reference, nil to stop
+func (m *driftMonitor) reset(reference *ml.DriftReference) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reference = reference
+	m.featureCounts, m.scoreCounts = nil, nil
+	m.flows = 0
+	m.last = nil
+	if reference == nil {
+		return
+	}
+	m.featureCounts = make([][]int64, len(reference.Features))
+	for i, h := range reference.Features {
+		m.featureCounts[i] = make([]int64, len(h.Shares))
+	}
+	if reference.Scores != nil {
+		m.scoreCounts = make([]int64, len(reference.Scores.Shares))
+	}
+}
+
+// observe adds a scored flow to the current window. When the flow completes
+// the window, its report is returned along with whether a retraining should
+// start.
+func (m *driftMonitor) observe(features []float64, score float64) (*DriftReport, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.reference == nil || len(features) != len(m.featureCounts) {
+		return nil, false
+	}
+	for i, x := range features {
+		m.featureCounts[i][m.reference.Features[i].Bin(x)]++
+	}
+	if m.scoreCounts != nil {
+		m.scoreCounts[m.reference.Scores.Bin(score)]++
+	}
+	m.flows++
+	if m.flows < m.window {
+		return nil, false
+	}
+
+	report := m.complete()
+	m.last = report
+
+	retrain := false
+	if report.Drifted && m.retrain && !m.retraining && time.Since(m.lastRetrain) >= m.cooldown {
+		m.retraining = true
+		m.lastRetrain = time.Now()
+		retrain = true
+	}
+	return report, retrain
+}
+
+// complete reports on the current window and starts the next one. The
+// caller holds m.mu.
+func (m *driftMonitor) complete() *DriftReport {
+	report := &DriftReport{
+		Flows:       m.flows,
+		Threshold:   m.threshold,
+		Reference:   m.reference.Source,
+		Features:    make([]FeatureDrift, len(m.featureCounts)),
+		CompletedAt: time.Now(),
+	}
+	for i, counts := range m.featureCounts {
+		psi := m.reference.Features[i].PSI(counts)
+		report.Features[i] = FeatureDrift{Index: i, Name: ml.FeatureName(i), PSI: psi}
+		if psi > report.MaxFeaturePSI {
+			report.MaxFeaturePSI = psi
+		}
+		if psi > m.threshold {
+			report.DriftedFeatures++
+		}
+		clear(counts)
+	}
+	sort.SliceStable(report.Features, func(a, b int) bool { return report.Features[a].PSI > report.Features[b].PSI })
+	if len(report.Features) > driftReportFeatures {
+		report.Features = report.Features[:driftReportFeatures]
+	}
+	report.Drifted = report.DriftedFeatures > 0
+	if m.scoreCounts != nil {
+		psi := m.reference.Scores.PSI(m.scoreCounts)
+		report.ScorePSI = &psi
+		report.Drifted = report.Drifted || psi > m.threshold
+		clear(m.scoreCounts)
+	}
+	m.flows = 0
+	return report
+}
+
+// report returns the report on the last completed window
+func (m *driftMonitor) report() (*DriftReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.last == nil {
+		return nil, ErrNoDriftReport
+	}
+	report := *m.last
+	report.Features = append([]FeatureDrift(nil), m.last.Features...)
+	return &report, nil
+}
+
+// retrained marks a drift-triggered retraining as finished
+func (m *driftMonitor) retrained() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retraining = false
+}
+
+// observeDrift adds a flow scored by the model to the drift window and acts
+// on the report when the window completes
+func (e *MLCortexEngine) observeDrift(features []float64, score float64) {
+	report, retrain := e.drift.observe(features, score)
+	if report == nil {
+		return
+	}
+
+	if report.ScorePSI != nil {
+		driftScorePSI.Set(*report.ScorePSI)
+	}
+	driftFeaturePSIMax.Set(report.MaxFeaturePSI)
+	driftFeatures.Set(float64(report.DriftedFeatures))
+	if !report.Drifted {
+		return
+	}
+
+	driftAlerts.Inc()
+	attrs := []any{
+		"flows", report.Flows,
+		"threshold", report.Threshold,
+		"max_feature_psi", report.MaxFeaturePSI,
+		"drifted_features", report.DriftedFeatures,
+	}
+	if report.ScorePSI != nil {
+		attrs = append(attrs, "score_psi", *report.ScorePSI)
+	}
+	if len(report.Features) > 0 {
+		attrs = append(attrs, "top_feature", report.Features[0].Name)
+	}
+	slog.Warn("Feature drift detected", attrs...)
+
+	if retrain {
+		driftRetrains.Inc()
+		go e.retrainForDrift()
+	}
+}
+
+// retrainForDrift retrains the model after drift was detected
+func (e *MLCortexEngine) retrainForDrift() {
+	defer e.drift.retrained()
+
+	slog.Info("Retraining ML model after drift")
+	if err := e.RetrainModel(e.ctx); err != nil {
+		slog.Error("Drift-triggered retraining failed", "error", err)
+	}
+}
+
+// DriftReport returns the comparison of the last completed window of flows
+// with the distribution the active model was trained on
+func (e *MLCortexEngine) DriftReport() (*DriftReport, error) {
+	if e.drift == nil {
+		return nil, ErrNoDriftReport
+	}
+	return e.drift.report()
+}
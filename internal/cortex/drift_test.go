@@ -0,0 +1,135 @@
+package cortex
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+)
+
+// testDriftReference expects feature values and scores spread evenly over
+// [0, 1)
+func testDriftReference() *ml.DriftReference {
+	uniform := ml.Histogram{Edges: []float64{0.25, 0.5, 0.75}, Shares: []float64{0.25, 0.25, 0.25, 0.25}}
+	return &ml.DriftReference{
+		Features: []ml.Histogram{uniform, uniform},
+		Scores:   &uniform,
+	}
+}
+
+func TestDriftMonitor(t *testing.T) {
+	m := newDriftMonitor(4, 0.25, true, time.Hour)
+	if _, err := m.report(); !errors.Is(err, ErrNoDriftReport) {
+		t.Errorf("Expected ErrNoDriftReport before the first window, got %v", err)
+	}
+
+	// Without a reference flows are ignored
+	if report, _ := m.observe([]float64{0, 0}, 0); report != nil {
+		t.Error("Expected no report without a reference")
+	}
+
+	m.reset(testDriftReference())
+	var report *DriftReport
+	for _, x := range []float64{0.1, 0.3, 0.6, 0.9} {
+		var retrain bool
+		report, retrain = m.observe([]float64{x, x}, x)
+		if retrain {
+			t.Error("Expected no retraining without drift")
+		}
+	}
+	if report == nil {
+		t.Fatal("Expected a report after a full window")
+	}
+	if report.Drifted || report.MaxFeaturePSI != 0 || report.ScorePSI == nil || *report.ScorePSI != 0 {
+		t.Errorf("Expected no drift for matching flows, got %+v", report)
+	}
+
+	// Feature 1 moves to the top quarter
+	var retrain bool
+	for i := 0; i < 4; i++ {
+		report, retrain = m.observe([]float64{float64(i)/4 + 0.1, 0.9}, float64(i)/4+0.1)
+	}
+	if report == nil || !report.Drifted || report.DriftedFeatures != 1 {
+		t.Fatalf("Expected feature drift, got %+v", report)
+	}
+	if !retrain {
+		t.Error("Expected drift to trigger a retraining")
+	}
+	if report.Features[0].Index != 1 || report.Features[0].Name != ml.FeatureName(1) {
+		t.Errorf("Expected feature 1 to drift most, got %+v", report.Features[0])
+	}
+	if *report.ScorePSI != 0 {
+		t.Errorf("Expected no score drift, got %v", *report.ScorePSI)
+	}
+
+	// No further retraining within the cooldown
+	m.retrained()
+	retrains := 0
+	for i := 0; i < 12; i++ {
+		if _, retrain := m.observe([]float64{0.9, 0.9}, 0.9); retrain {
+			retrains++
+		}
+	}
+	if retrains != 0 {
+		t.Errorf("Expected no retraining within the cooldown, got %d", retrains)
+	}
+
+	got, err := m.report()
+	if err != nil || got.ScorePSI == nil || *got.ScorePSI <= 0.25 {
+		t.Errorf("Expected the last report to show score drift, got %+v, %v", got, err)
+	}
+
+	// A new reference discards the window and the report
+	m.reset(testDriftReference())
+	if _, err := m.report(); !errors.Is(err, ErrNoDriftReport) {
+		t.Errorf("Expected ErrNoDriftReport after a reset, got %v", err)
+	}
+}
+
+func TestEngineDriftReport(t *testing.T) {
+	cfg := config.DefaultMLConfig()
+	cfg.ModelType = "svm"
+	cfg.FeatureSize = 8
+	cfg.FakeDataSize = 50
+	cfg.ModelDir = t.TempDir()
+	cfg.ModelPath = filepath.Join(t.TempDir(), "model")
+	cfg.DriftWindow = 5
+
+	engine, err := NewMLCortexEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	features := make([]float64, cfg.FeatureSize)
+	for i := range features {
+		features[i] = 100
+	}
+	for i := 0; i < cfg.DriftWindow; i++ {
+		if _, err := engine.Analyze(context.Background(), features, "flow"); err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+	}
+
+	report, err := engine.DriftReport()
+	if err != nil {
+		t.Fatalf("DriftReport failed: %v", err)
+	}
+	if report.Flows != cfg.DriftWindow || !report.Drifted {
+		t.Errorf("Expected a drifted window of %d flows, got %+v", cfg.DriftWindow, report)
+	}
+
+	cfg.DriftWindow = 0
+	disabled, err := NewMLCortexEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer disabled.Close()
+	if _, err := disabled.DriftReport(); !errors.Is(err, ErrNoDriftReport) {
+		t.Errorf("Expected ErrNoDriftReport when disabled, got %v", err)
+	}
+}
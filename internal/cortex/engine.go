@@ -4,20 +4,79 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"sync"
 	"time"
 
 	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/enrich"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
 )
 
 // DetectionResult represents the result of a bot detection analysis
 type DetectionResult struct {
+	// ID identifies the result in the detection store, set on results
+	// read from it
+	ID int64 `json:"id,omitempty"`
+
 	IsBot      bool      `json:"is_bot"`
 	Confidence float64   `json:"confidence"`
 	Features   []float64 `json:"features"`
 	Reasoning  string    `json:"reasoning"`
 	Timestamp  time.Time `json:"timestamp"`
 	FlowID     string    `json:"flow_id"`
+	ModelUsed  string    `json:"model_used"`
+
+	// Features contributing most to the confidence, set by the ML engine
+	Explanations []ml.Explanation `json:"explanations,omitempty"`
+
+	// ModelVersion is the registry ID of the model version that produced
+	// the result, empty for heuristic and unversioned models
+	ModelVersion string `json:"model_version,omitempty"`
+
+	// Flow endpoints, set for results of captured flows
+	SrcIP    net.IP `json:"src_ip,omitempty"`
+	DstIP    net.IP `json:"dst_ip,omitempty"`
+	SrcPort  uint16 `json:"src_port,omitempty"`
+	DstPort  uint16 `json:"dst_port,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+
+	// Endpoint enrichment, set for flows captured with GeoIP enabled
+	SrcGeo *enrich.GeoInfo `json:"src_geo,omitempty"`
+	DstGeo *enrich.GeoInfo `json:"dst_geo,omitempty"`
+
+	// Aggregate counts the detections an aggregating sink rolled up into
+	// this one, nil for single detections
+	Aggregate *AlertAggregate `json:"aggregate,omitempty"`
+}
+
+// AlertAggregate summarizes the detections of one source, destination and
+// verdict within an aggregation window
+type AlertAggregate struct {
+	Count     int       `json:"count"` // detections rolled up
+	Flows     int       `json:"flows"` // distinct flows among them
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Event converts the result into its transport representation
+func (r *DetectionResult) Event() *events.Detection {
+	event := &events.Detection{
+		FlowID:       r.FlowID,
+		IsBot:        r.IsBot,
+		Confidence:   r.Confidence,
+		Reasoning:    r.Reasoning,
+		ModelUsed:    r.ModelUsed,
+		ModelVersion: r.ModelVersion,
+		Timestamp:    r.Timestamp,
+		Features:     r.Features,
+	}
+	if a := r.Aggregate; a != nil {
+		event.AlertCount, event.AlertFlows = uint64(a.Count), uint64(a.Flows)
+		event.FirstSeen, event.LastSeen = a.FirstSeen, a.LastSeen
+	}
+	return event
 }
 
 // Engine represents the neural network inference engine
@@ -28,6 +87,7 @@ type Engine struct {
 	stats  *Statistics
 	ctx    context.Context
 	cancel context.CancelFunc
+	ready  chan struct{}
 }
 
 // Statistics holds inference statistics
@@ -46,7 +106,10 @@ type Model struct {
 	Version    string
 	InputSize  int
 	OutputSize int
-	// In a real implementation, this would hold the actual model
+	Format     string
+	// tflite runs the model when the tflite format is configured; other
+	// formats are simulated
+	tflite *ml.TFLiteModel
 	loaded bool
 }
 
@@ -59,6 +122,7 @@ func NewEngine(cfg config.CortexConfig) (*Engine, error) {
 		stats:  &Statistics{},
 		ctx:    ctx,
 		cancel: cancel,
+		ready:  make(chan struct{}),
 	}
 
 	// Load the neural network model
@@ -67,6 +131,9 @@ func NewEngine(cfg config.CortexConfig) (*Engine, error) {
 		return nil, fmt.Errorf("failed to load model: %w", err)
 	}
 
+	// Warm up in the background; IsReady reports false until this completes
+	go engine.warmup()
+
 	slog.Info("Cortex engine initialized",
 		"model_path", cfg.ModelPath,
 		"threshold", cfg.DetectionThreshold,
@@ -80,17 +147,35 @@ func (e *Engine) loadModel() error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	// In a real implementation, this would load an actual ONNX/TensorFlow model
-	e.model = &Model{
-		Path:       e.config.ModelPath,
-		Version:    "1.0.0",
-		InputSize:  128, // Feature vector size
-		OutputSize: 2,   // Binary classification (human/bot)
-		loaded:     true,
+	if e.config.ModelFormat == ml.ModelFormatTFLite {
+		model, err := ml.OpenTFLiteModel(e.config.ModelPath, e.config.TFLiteThreads)
+		if err != nil {
+			return err
+		}
+		e.model = &Model{
+			Path:       e.config.ModelPath,
+			Version:    "1.0.0",
+			InputSize:  model.InputSize(),
+			OutputSize: 2,
+			Format:     ml.ModelFormatTFLite,
+			tflite:     model,
+			loaded:     true,
+		}
+	} else {
+		// In a real implementation, this would load an actual ONNX/TensorFlow model
+		e.model = &Model{
+			Path:       e.config.ModelPath,
+			Version:    "1.0.0",
+			InputSize:  128, // Feature vector size
+			OutputSize: 2,   // Binary classification (human/bot)
+			Format:     ml.ModelFormatNative,
+			loaded:     true,
+		}
 	}
 
 	slog.Info("Neural network model loaded",
 		"path", e.model.Path,
+		"format", e.model.Format,
 		"version", e.model.Version,
 		"input_size", e.model.InputSize,
 		"output_size", e.model.OutputSize)
@@ -98,6 +183,67 @@ func (e *Engine) loadModel() error {
 	return nil
 }
 
+// warmup runs dummy inferences so the first real flows don't pay the
+// cold-start cost, then marks the engine as ready
+func (e *Engine) warmup() {
+	start := time.Now()
+
+	e.mu.RLock()
+	features := make([]float64, e.model.InputSize)
+	for i := 0; i < e.config.WarmupInferences; i++ {
+		if e.ctx.Err() != nil {
+			e.mu.RUnlock()
+			return
+		}
+		if _, _, _, err := e.infer(features); err != nil {
+			slog.Warn("Cortex engine warm-up inference failed", "error", err)
+			break
+		}
+	}
+	e.mu.RUnlock()
+
+	close(e.ready)
+
+	slog.Info("Cortex engine warm-up completed",
+		"inferences", e.config.WarmupInferences,
+		"duration", time.Since(start))
+}
+
+// IsReady reports whether the engine has finished warming up
+func (e *Engine) IsReady() bool {
+	select {
+	case <-e.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitReady blocks until the engine has finished warming up or ctx is done
+func (e *Engine) WaitReady(ctx context.Context) error {
+	select {
+	case <-e.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetDetectionThreshold changes the confidence at which flows are
+// classified as bots
+func (e *Engine) SetDetectionThreshold(threshold float64) error {
+	if threshold < 0 || threshold > 1 {
+		return fmt.Errorf("detection threshold must be between 0 and 1, got %v", threshold)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.config.DetectionThreshold = threshold
+	slog.Info("Detection threshold updated", "threshold", threshold)
+	return nil
+}
+
 // Analyze performs bot detection analysis on extracted features
 func (e *Engine) Analyze(ctx context.Context, features []float64, flowID string) (*DetectionResult, error) {
 	e.mu.RLock()
@@ -113,9 +259,10 @@ func (e *Engine) Analyze(ctx context.Context, features []float64, flowID string)
 			len(features), e.model.InputSize)
 	}
 
-	// Simulate neural network inference
-	// In a real implementation, this would run actual model inference
-	confidence, reasoning := e.simulateInference(features)
+	confidence, reasoning, modelUsed, err := e.infer(features)
+	if err != nil {
+		return nil, err
+	}
 	isBot := confidence >= e.config.DetectionThreshold
 
 	result := &DetectionResult{
@@ -125,6 +272,7 @@ func (e *Engine) Analyze(ctx context.Context, features []float64, flowID string)
 		Reasoning:  reasoning,
 		Timestamp:  time.Now(),
 		FlowID:     flowID,
+		ModelUsed:  modelUsed,
 	}
 
 	// Update statistics
@@ -139,6 +287,23 @@ func (e *Engine) Analyze(ctx context.Context, features []float64, flowID string)
 	return result, nil
 }
 
+// infer scores a feature vector with the loaded model, returning the
+// confidence, its reasoning and the model used
+func (e *Engine) infer(features []float64) (float64, string, string, error) {
+	if e.model.tflite != nil {
+		score, err := e.model.tflite.Predict(features)
+		if err != nil {
+			return 0, "", "", err
+		}
+		return score, describeScore(score), ml.ModelFormatTFLite, nil
+	}
+
+	// Simulate neural network inference
+	// In a real implementation, this would run actual model inference
+	score, reasoning := e.simulateInference(features)
+	return score, reasoning, "neural_network", nil
+}
+
 // simulateInference simulates neural network inference
 // In a real implementation, this would use actual model inference
 func (e *Engine) simulateInference(features []float64) (float64, string) {
@@ -176,16 +341,17 @@ func (e *Engine) simulateInference(features []float64) (float64, string) {
 		score = 1.0
 	}
 
-	reasoning := "Analysis based on packet size, timing patterns, and protocol behavior"
+	return score, describeScore(score)
+}
+
+// describeScore explains a bot confidence score
+func describeScore(score float64) string {
 	if score > 0.7 {
-		reasoning = "High confidence bot detection based on automated behavior patterns"
+		return "High confidence bot detection based on automated behavior patterns"
 	} else if score > 0.4 {
-		reasoning = "Suspicious activity detected, moderate confidence"
-	} else {
-		reasoning = "Appears to be human traffic based on behavioral analysis"
+		return "Suspicious activity detected, moderate confidence"
 	}
-
-	return score, reasoning
+	return "Appears to be human traffic based on behavioral analysis"
 }
 
 // updateStats updates inference statistics
@@ -223,9 +389,30 @@ func (e *Engine) GetStatistics() *Statistics {
 	return &stats
 }
 
+// HealthCheck returns nil once the model is loaded and warmed up
+func (e *Engine) HealthCheck() error {
+	if !e.IsReady() {
+		return fmt.Errorf("model warming up")
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.model == nil || !e.model.loaded {
+		return fmt.Errorf("model not loaded")
+	}
+	return nil
+}
+
 // Close shuts down the Cortex engine
 func (e *Engine) Close() error {
 	e.cancel()
+
+	e.mu.Lock()
+	if e.model != nil && e.model.tflite != nil {
+		e.model.tflite.Close()
+	}
+	e.mu.Unlock()
 	slog.Info("Cortex engine shutdown complete")
 	return nil
 }
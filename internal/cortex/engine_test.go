@@ -233,3 +233,35 @@ func TestUpdateStats(t *testing.T) {
 		t.Errorf("Expected average confidence %f, got %f", expectedAvg, stats.AverageConfidence)
 	}
 }
+
+func TestWarmup(t *testing.T) {
+	cfg := config.CortexConfig{
+		ModelPath:          "./test_model.onnx",
+		DetectionThreshold: 0.85,
+		BatchSize:          32,
+		InferenceTimeout:   1000,
+		WarmupInferences:   3,
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := engine.WaitReady(ctx); err != nil {
+		t.Fatalf("Engine did not become ready: %v", err)
+	}
+
+	if !engine.IsReady() {
+		t.Error("Engine should report ready after warm-up")
+	}
+
+	// Warm-up inferences must not show up in statistics
+	if stats := engine.GetStatistics(); stats.TotalInferences != 0 {
+		t.Errorf("Expected 0 total inferences after warm-up, got %d", stats.TotalInferences)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/datasets"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/registry"
 	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
 	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
 )
@@ -22,23 +25,70 @@ type MLCortexEngine struct {
 	// Statistics
 	stats *MLCortexStatistics
 
+	// Latency SLO tracking
+	breaker *LatencyBreaker
+
+	// Model management: models loaded from the model directory, least
+	// recently loaded first, the name of the active one and the previously
+	// active models
+	loadedModels map[string]*ml.ModelSnapshot
+	loadOrder    []string
+	activeModel  string
+	modelHistory []modelVersion
+
+	// Model versions, and the ID of the version producing results. tflite
+	// models are not versioned.
+	registry      *registry.Registry
+	activeVersion string
+
+	// Challenger scoring flows alongside the active model, nil unless
+	// configured
+	shadow *shadowModel
+
+	// Uncertain predictions waiting for an analyst's verdict, nil when
+	// disabled
+	review *reviewQueue
+
+	// Comparison of incoming flows with the training distribution, nil
+	// when disabled
+	drift *driftMonitor
+
+	// Labelled datasets trained and evaluated on, nil unless a dataset
+	// directory is configured
+	datasets *datasets.Store
+
 	// State management
-	mu     sync.RWMutex
-	ctx    context.Context
-	cancel context.CancelFunc
+	mu       sync.RWMutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	ready    chan struct{}
+	watching sync.WaitGroup // model file watcher, stopped by Close
 }
 
 // MLCortexStatistics holds enhanced statistics for the ML cortex engine
 type MLCortexStatistics struct {
-	TotalInferences   int64         `json:"total_inferences"`
-	BotDetections     int64         `json:"bot_detections"`
-	HumanDetections   int64         `json:"human_detections"`
-	AverageConfidence float64       `json:"average_confidence"`
-	ModelAccuracy     float64       `json:"model_accuracy"`
-	TrainingTime      time.Duration `json:"training_time"`
-	LastInference     time.Time     `json:"last_inference"`
-	ModelType         string        `json:"model_type"`
-	mu                sync.RWMutex
+	TotalInferences    int64         `json:"total_inferences"`
+	BotDetections      int64         `json:"bot_detections"`
+	HumanDetections    int64         `json:"human_detections"`
+	AverageConfidence  float64       `json:"average_confidence"`
+	ModelAccuracy      float64       `json:"model_accuracy"`
+	TrainingTime       time.Duration `json:"training_time"`
+	LastInference      time.Time     `json:"last_inference"`
+	ModelType          string        `json:"model_type"`
+	FallbackInferences int64         `json:"fallback_inferences"`
+	Breaker            BreakerStats  `json:"breaker"`
+	mu                 sync.RWMutex
+
+	// Held-out accuracy of each trained model and the confusion matrix on
+	// the held-out flows
+	ModelAccuracies map[string]float64  `json:"model_accuracies,omitempty"`
+	Confusion       *ml.ConfusionMatrix `json:"confusion,omitempty"`
+
+	// Predictions served from and missing in the prediction cache, and
+	// those it holds
+	CacheHits    int64 `json:"cache_hits,omitempty"`
+	CacheMisses  int64 `json:"cache_misses,omitempty"`
+	CacheEntries int   `json:"cache_entries,omitempty"`
 }
 
 // NewMLCortexEngine creates a new ML-enhanced cortex engine
@@ -53,8 +103,63 @@ func NewMLCortexEngine(cfg config.MLConfig) (*MLCortexEngine, error) {
 		TrainingEpochs:     cfg.TrainingEpochs,
 		LearningRate:       cfg.LearningRate,
 		FeatureSize:        cfg.FeatureSize,
+		GBDTTrees:          cfg.GBDTTrees,
+		GBDTMaxDepth:       cfg.GBDTMaxDepth,
+		GBDTLearningRate:   cfg.GBDTLearningRate,
+		GBDTBins:           cfg.GBDTBins,
+		GBDTMinSamplesLeaf: cfg.GBDTMinSamplesLeaf,
+		KNNNeighbors:       cfg.KNNNeighbors,
+		KNNDistance:        cfg.KNNDistance,
+		SVMMode:            cfg.SVMMode,
+		SVMNu:              cfg.SVMNu,
+		SVMGamma:           cfg.SVMGamma,
+		OnlineBufferSize:   cfg.OnlineBufferSize,
+		OnlineRefitSamples: cfg.OnlineRefitSamples,
+		EvaluationFolds:    cfg.EvaluationFolds,
+		EvaluationHoldout:  cfg.EvaluationHoldout,
+		Calibration:        cfg.Calibration,
+		CalibrationHoldout: cfg.CalibrationHoldout,
+		Scaling:            cfg.Scaling,
+		Explanations:       cfg.Explanations,
+		EnsembleModels:     cfg.EnsembleModels,
 		GenerateFakeData:   cfg.GenerateFakeData,
 		FakeDataSize:       cfg.FakeDataSize,
+		ModelFormat:        cfg.ModelFormat,
+		ModelPath:          cfg.ModelPath,
+		SaveModel:          cfg.SaveModel,
+		LoadModel:          cfg.LoadModel,
+		TFLiteThreads:      cfg.TFLiteThreads,
+
+		TrainingDataPath:       cfg.TrainingDataPath,
+		TrainingLabelColumn:    cfg.TrainingLabelColumn,
+		TrainingFeatureColumns: cfg.TrainingFeatureColumns,
+
+		EnableGPU:      cfg.EnableGPU,
+		MaxConcurrency: cfg.MaxConcurrency,
+
+		CacheSize:         cfg.CacheSize,
+		CacheTTL:          time.Duration(cfg.CacheTTL) * time.Second,
+		CacheQuantization: cfg.CacheQuantization,
+	}
+
+	// Train on the current versions of the configured datasets
+	var store *datasets.Store
+	if cfg.DatasetDir != "" {
+		var err error
+		if store, err = datasets.Open(cfg.DatasetDir); err != nil {
+			cancel()
+			return nil, err
+		}
+		resolved, err := resolveDatasets(store, cfg)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if resolved != nil {
+			mlConfig.TrainingDataPath = resolved.path
+			mlConfig.TrainingDataSource = resolved.source
+			mlConfig.EvaluationDataPath = resolved.evaluationPath
+		}
 	}
 
 	// Initialize ML engine
@@ -64,17 +169,74 @@ func NewMLCortexEngine(cfg config.MLConfig) (*MLCortexEngine, error) {
 		return nil, fmt.Errorf("failed to initialize ML engine: %w", err)
 	}
 
+	var registryPath string
+	if cfg.ModelDir != "" {
+		registryPath = filepath.Join(cfg.ModelDir, registry.FileName)
+	}
+	models, err := registry.Open(registryPath)
+	if err != nil {
+		mlEngine.Close()
+		cancel()
+		return nil, err
+	}
+
 	engine := &MLCortexEngine{
 		mlEngine: mlEngine,
 		config:   cfg,
 		stats:    &MLCortexStatistics{},
-		ctx:      ctx,
-		cancel:   cancel,
+		breaker: NewLatencyBreaker(
+			time.Duration(cfg.LatencySLO)*time.Millisecond,
+			cfg.BreakerWindow,
+			cfg.BreakerTripRatio,
+			time.Duration(cfg.BreakerCooldown)*time.Second,
+		),
+		loadedModels: make(map[string]*ml.ModelSnapshot),
+		registry:     models,
+		datasets:     store,
+		ctx:          ctx,
+		cancel:       cancel,
+		ready:        make(chan struct{}),
 	}
 
 	// Initialize statistics
 	engine.stats.ModelType = cfg.ModelType
 
+	if cfg.ReviewQueueSize > 0 {
+		engine.review = newReviewQueue(cfg.ReviewQueueSize, cfg.ReviewMinConfidence, cfg.ReviewMaxConfidence)
+	}
+
+	if cfg.DriftWindow > 0 {
+		engine.drift = newDriftMonitor(cfg.DriftWindow, cfg.DriftThreshold, cfg.DriftRetrain,
+			time.Duration(cfg.DriftRetrainCooldown)*time.Second)
+	}
+
+	if err := engine.activateVersion("startup"); err != nil {
+		mlEngine.Close()
+		cancel()
+		return nil, err
+	}
+
+	if cfg.ShadowModelPath != "" {
+		if engine.shadow, err = newShadowModel(ctx, cfg); err != nil {
+			mlEngine.Close()
+			cancel()
+			return nil, err
+		}
+	}
+
+	if cfg.WatchModel {
+		engine.watching.Add(1)
+		go func() {
+			defer engine.watching.Done()
+			if err := engine.WatchModelFile(ctx); err != nil {
+				slog.Error("Failed to watch model file", "path", cfg.ModelPath, "error", err)
+			}
+		}()
+	}
+
+	// Warm up in the background; IsReady reports false until this completes
+	go engine.warmup()
+
 	slog.Info("ML Cortex engine initialized",
 		"model_type", cfg.ModelType,
 		"threshold", cfg.DetectionThreshold,
@@ -84,6 +246,43 @@ func NewMLCortexEngine(cfg config.MLConfig) (*MLCortexEngine, error) {
 	return engine, nil
 }
 
+// warmup runs dummy inferences through the ML engine, then marks the engine
+// as ready. A failed warm-up only costs the first flows the cold start, so
+// the engine is marked as ready regardless.
+func (e *MLCortexEngine) warmup() {
+	start := time.Now()
+	defer close(e.ready)
+
+	if err := e.mlEngine.Warmup(e.config.WarmupInferences); err != nil {
+		slog.Error("ML Cortex engine warm-up failed", "error", err)
+		return
+	}
+
+	slog.Info("ML Cortex engine warm-up completed",
+		"inferences", e.config.WarmupInferences,
+		"duration", time.Since(start))
+}
+
+// IsReady reports whether the engine has finished warming up
+func (e *MLCortexEngine) IsReady() bool {
+	select {
+	case <-e.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitReady blocks until the engine has finished warming up or ctx is done
+func (e *MLCortexEngine) WaitReady(ctx context.Context) error {
+	select {
+	case <-e.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Analyze performs bot detection analysis using the ML engine
 func (e *MLCortexEngine) Analyze(ctx context.Context, features []float64, flowID string) (*DetectionResult, error) {
 	e.mu.RLock()
@@ -95,8 +294,27 @@ func (e *MLCortexEngine) Analyze(ctx context.Context, features []float64, flowID
 			len(features), e.config.FeatureSize)
 	}
 
-	// Perform ML-based prediction
-	mlResult, err := e.mlEngine.Predict(ctx, features, flowID)
+	// Route to the heuristic path while the breaker is open
+	var mlResult *ml.DetectionResult
+	var err error
+	if e.breaker.Allow() {
+		start := time.Now()
+		mlResult, err = e.mlEngine.Predict(ctx, features, flowID)
+		// Cache hits say nothing about inference latency
+		if err == nil && mlResult.Cached {
+			e.breaker.Release()
+		} else {
+			e.breaker.Record(time.Since(start))
+		}
+		if err == nil {
+			e.observeCache(mlResult)
+		}
+	} else {
+		mlResult, err = e.mlEngine.PredictHeuristic(ctx, features, flowID)
+		e.stats.mu.Lock()
+		e.stats.FallbackInferences++
+		e.stats.mu.Unlock()
+	}
 	if err != nil {
 		return nil, fmt.Errorf("ML prediction failed: %w", err)
 	}
@@ -109,6 +327,21 @@ func (e *MLCortexEngine) Analyze(ctx context.Context, features []float64, flowID
 		Reasoning:  mlResult.Reasoning,
 		Timestamp:  mlResult.Timestamp,
 		FlowID:     mlResult.FlowID,
+		ModelUsed:  mlResult.ModelUsed,
+
+		Explanations: mlResult.Explanations,
+	}
+	if mlResult.ModelUsed != "heuristic" {
+		result.ModelVersion = e.activeVersion
+		if e.shadow != nil {
+			e.shadow.enqueue(shadowItem{features: features, flowID: flowID, isBot: result.IsBot, confidence: result.Confidence})
+		}
+		if e.review != nil {
+			e.review.offer(result, features)
+		}
+		if e.drift != nil {
+			e.observeDrift(features, result.Confidence)
+		}
 	}
 
 	// Update statistics
@@ -118,7 +351,7 @@ func (e *MLCortexEngine) Analyze(ctx context.Context, features []float64, flowID
 		"flow_id", flowID,
 		"is_bot", result.IsBot,
 		"confidence", result.Confidence,
-		"model_used", mlResult.ModelUsed,
+		"model_used", result.ModelUsed,
 		"reasoning", result.Reasoning)
 
 	return result, nil
@@ -126,33 +359,40 @@ func (e *MLCortexEngine) Analyze(ctx context.Context, features []float64, flowID
 
 // GetStatistics returns the current ML cortex engine statistics
 func (e *MLCortexEngine) GetStatistics() *MLCortexStatistics {
-	e.stats.mu.RLock()
-	defer e.stats.mu.RUnlock()
-
 	// Get ML engine statistics
 	mlStats := e.mlEngine.GetStatistics()
 
-	// Update our statistics with ML engine data
 	e.stats.mu.Lock()
+	defer e.stats.mu.Unlock()
+
+	// Update our statistics with ML engine data
 	e.stats.TotalInferences = mlStats.TotalPredictions
 	e.stats.BotDetections = mlStats.BotDetections
 	e.stats.HumanDetections = mlStats.HumanDetections
 	e.stats.AverageConfidence = mlStats.AverageConfidence
 	e.stats.ModelAccuracy = mlStats.ModelAccuracy
+	e.stats.ModelAccuracies = mlStats.ModelAccuracies
+	e.stats.Confusion = mlStats.Confusion
 	e.stats.TrainingTime = mlStats.TrainingTime
 	e.stats.LastInference = mlStats.LastPrediction
-	e.stats.mu.Unlock()
 
 	// Create a copy without the mutex to avoid copying lock value
 	stats := MLCortexStatistics{
-		TotalInferences:   e.stats.TotalInferences,
-		BotDetections:     e.stats.BotDetections,
-		HumanDetections:   e.stats.HumanDetections,
-		AverageConfidence: e.stats.AverageConfidence,
-		ModelAccuracy:     e.stats.ModelAccuracy,
-		TrainingTime:      e.stats.TrainingTime,
-		LastInference:     e.stats.LastInference,
-		ModelType:         e.stats.ModelType,
+		TotalInferences:    e.stats.TotalInferences,
+		BotDetections:      e.stats.BotDetections,
+		HumanDetections:    e.stats.HumanDetections,
+		AverageConfidence:  e.stats.AverageConfidence,
+		ModelAccuracy:      e.stats.ModelAccuracy,
+		ModelAccuracies:    e.stats.ModelAccuracies,
+		Confusion:          e.stats.Confusion,
+		TrainingTime:       e.stats.TrainingTime,
+		LastInference:      e.stats.LastInference,
+		ModelType:          e.stats.ModelType,
+		FallbackInferences: e.stats.FallbackInferences,
+		Breaker:            e.breaker.Stats(),
+		CacheHits:          mlStats.CacheHits,
+		CacheMisses:        mlStats.CacheMisses,
+		CacheEntries:       mlStats.CacheEntries,
 	}
 	return &stats
 }
@@ -169,15 +409,42 @@ func (e *MLCortexEngine) RetrainModel(ctx context.Context) error {
 
 	slog.Info("Retraining ML model")
 
-	// Generate new fake data and retrain
-	if err := e.mlEngine.TrainOnFakeData(); err != nil {
+	// Retrain on the latest versions of the configured datasets, the
+	// dataset file, or new fake data
+	resolved, err := resolveDatasets(e.datasets, e.config)
+	if err != nil {
+		return err
+	}
+	if resolved != nil {
+		err = e.mlEngine.TrainOnDatasetFile(resolved.path, resolved.source, resolved.evaluationPath)
+	} else {
+		err = e.mlEngine.Retrain()
+	}
+	if err != nil {
 		return fmt.Errorf("failed to retrain model: %w", err)
 	}
+	if err := e.activateVersion("trained"); err != nil {
+		return err
+	}
 
 	slog.Info("ML model retraining completed")
 	return nil
 }
 
+// TrainBaseline trains the one-class SVM on baseline traffic assumed to be
+// human and activates the resulting model version
+func (e *MLCortexEngine) TrainBaseline(ctx context.Context, features [][]float64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	slog.Info("Training ML model on baseline traffic", "samples", len(features))
+
+	if err := e.mlEngine.TrainBaseline(features); err != nil {
+		return fmt.Errorf("failed to train baseline model: %w", err)
+	}
+	return e.activateVersion("baseline")
+}
+
 // UpdateConfig updates the ML engine configuration
 func (e *MLCortexEngine) UpdateConfig(newConfig config.MLConfig) error {
 	e.mu.Lock()
@@ -223,6 +490,13 @@ func (e *MLCortexEngine) updateStats(result *DetectionResult) {
 // Close cleans up resources
 func (e *MLCortexEngine) Close() error {
 	e.cancel()
+	e.watching.Wait()
+
+	if e.shadow != nil {
+		if err := e.shadow.close(); err != nil {
+			slog.Warn("Failed to close shadow model", "error", err)
+		}
+	}
 
 	if e.mlEngine != nil {
 		return e.mlEngine.Close()
@@ -259,16 +533,25 @@ func (e *MLCortexEngine) GetModelInfo() map[string]interface{} {
 		"model_type":          e.config.ModelType,
 		"detection_threshold": e.config.DetectionThreshold,
 		"feature_size":        e.config.FeatureSize,
+		"feature_schema":      e.mlEngine.FeatureSchema().Fingerprint(),
 		"batch_size":          e.config.BatchSize,
 		"learning_rate":       e.config.LearningRate,
 		"training_epochs":     e.config.TrainingEpochs,
 		"generate_fake_data":  e.config.GenerateFakeData,
 		"fake_data_size":      e.config.FakeDataSize,
 		"model_path":          e.config.ModelPath,
+		"model_dir":           e.config.ModelDir,
+		"active_model":        e.activeModel,
 		"save_model":          e.config.SaveModel,
 		"load_model":          e.config.LoadModel,
 		"enable_gpu":          e.config.EnableGPU,
+		"device":              e.mlEngine.Device(),
+		"precision":           e.mlEngine.Precision(),
 		"max_concurrency":     e.config.MaxConcurrency,
+		"cache_size":          e.config.CacheSize,
+		"warmup_inferences":   e.config.WarmupInferences,
+		"latency_slo":         e.config.LatencySLO,
+		"breaker_state":       e.breaker.State(),
 		"enable_metrics":      e.config.EnableMetrics,
 		"log_predictions":     e.config.LogPredictions,
 	}
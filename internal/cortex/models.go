@@ -0,0 +1,351 @@
+package cortex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/registry"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+)
+
+// maxModelHistory bounds how many previously active models can be rolled
+// back to
+const maxModelHistory = 10
+
+// maxLoadedModels bounds the models held for activation; beyond it the least
+// recently loaded ones, except the active model, are unloaded
+const maxLoadedModels = 5
+
+var (
+	// ErrModelNotFound is returned for model names with no file in the
+	// model directory
+	ErrModelNotFound = errors.New("model not found")
+
+	// ErrModelNotLoaded is returned when activating a model that was not
+	// loaded first
+	ErrModelNotLoaded = errors.New("model not loaded")
+
+	// ErrIncompatibleModel is returned when a model file does not match the
+	// engine's model type or feature size
+	ErrIncompatibleModel = errors.New("incompatible model")
+
+	// ErrNoPreviousModel is returned when there is nothing to roll back to
+	ErrNoPreviousModel = errors.New("no previous model")
+)
+
+// ModelInfo describes a model file in the model directory
+type ModelInfo struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	ModelType   string    `json:"model_type,omitempty"`
+	FeatureSize int       `json:"feature_size,omitempty"`
+	// FeatureSchema is the fingerprint of the feature layout the model
+	// was trained on
+	FeatureSchema string    `json:"feature_schema,omitempty"`
+	CreatedAt     time.Time `json:"created_at,omitempty"`
+	Loaded        bool      `json:"loaded"`
+	Active        bool      `json:"active"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// modelVersion is a model that was active before the current one. The
+// model trained at startup has an empty name.
+type modelVersion struct {
+	name     string
+	snapshot *ml.ModelSnapshot
+}
+
+// ListModels returns the model files in the model directory, sorted by name
+func (e *MLCortexEngine) ListModels() ([]ModelInfo, error) {
+	entries, err := os.ReadDir(e.config.ModelDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []ModelInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model directory: %w", err)
+	}
+
+	models := []ModelInfo{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := e.modelInfo(entry.Name())
+		if errors.Is(err, ml.ErrNotModelFile) {
+			continue
+		}
+		if info == nil {
+			continue // removed while listing
+		}
+		models = append(models, *info)
+	}
+
+	sort.Slice(models, func(i, j int) bool { return models[i].Name < models[j].Name })
+	return models, nil
+}
+
+// LoadModel reads a model file from the model directory and validates it
+// against the engine, so it can be activated later. The file is decoded
+// before the engine is locked, so inference goes on meanwhile.
+func (e *MLCortexEngine) LoadModel(name string) (*ModelInfo, error) {
+	path, err := e.modelFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := ml.ReadModelFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrModelNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model %s: %w", name, err)
+	}
+	if err := e.mlEngine.CheckCompatible(snapshot); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIncompatibleModel, err)
+	}
+
+	e.mu.Lock()
+	e.loadedModels[name] = snapshot
+	e.loadOrder = append(slices.DeleteFunc(e.loadOrder, func(loaded string) bool { return loaded == name }), name)
+	e.unloadModels()
+	e.mu.Unlock()
+	slog.Info("Model loaded", "name", name, "model_type", snapshot.ModelType, "created_at", snapshot.CreatedAt)
+
+	return e.modelInfo(name)
+}
+
+// unloadModels unloads the least recently loaded models beyond
+// maxLoadedModels, keeping the active one. The caller holds e.mu.
+func (e *MLCortexEngine) unloadModels() {
+	for i := 0; len(e.loadedModels) > maxLoadedModels && i < len(e.loadOrder); {
+		name := e.loadOrder[i]
+		if name == e.activeModel {
+			i++
+			continue
+		}
+		delete(e.loadedModels, name)
+		e.loadOrder = slices.Delete(e.loadOrder, i, i+1)
+		slog.Info("Model unloaded", "name", name)
+	}
+}
+
+// ActivateModel makes a loaded model the one used for inference. The
+// previously active model is kept for RollbackModel.
+func (e *MLCortexEngine) ActivateModel(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	snapshot, ok := e.loadedModels[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrModelNotLoaded, name)
+	}
+
+	previous, err := e.restoreModel(snapshot, "file:"+name)
+	if err != nil {
+		return fmt.Errorf("failed to activate model %s: %w", name, err)
+	}
+	e.activeModel = name
+
+	slog.Info("Model activated", "name", name, "previous", previous.name)
+	return nil
+}
+
+// ReloadModelFile replaces the active model with the model file at the
+// configured model path, which then becomes the startup model. The previous
+// model is kept for RollbackModel. The file is validated first; on failure
+// the active model stays in use. Engines running a tflite model swap in the
+// new file without keeping the previous one.
+func (e *MLCortexEngine) ReloadModelFile() error {
+	if e.config.ModelFormat == ml.ModelFormatTFLite {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.mlEngine.LoadModel(e.config.ModelPath)
+	}
+
+	snapshot, err := ml.ReadModelFile(e.config.ModelPath)
+	if err != nil {
+		return fmt.Errorf("failed to read model %s: %w", e.config.ModelPath, err)
+	}
+	if err := e.mlEngine.CheckCompatible(snapshot); err != nil {
+		return fmt.Errorf("%w: %v", ErrIncompatibleModel, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	previous, err := e.restoreModel(snapshot, "file:"+e.config.ModelPath)
+	if err != nil {
+		return err
+	}
+	e.activeModel = ""
+
+	slog.Info("Model file reloaded", "path", e.config.ModelPath, "previous", previous.name, "created_at", snapshot.CreatedAt)
+	return nil
+}
+
+// WatchModelFile reloads the model file at the configured model path
+// whenever it is written or replaced, until ctx is done
+func (e *MLCortexEngine) WatchModelFile(ctx context.Context) error {
+	return watchModelFile(ctx, e.config.ModelPath, modelReloadDelay, e.ReloadModelFile)
+}
+
+// restoreModel makes snapshot the active parameters, registers their
+// version and records the previous ones in the model history
+func (e *MLCortexEngine) restoreModel(snapshot *ml.ModelSnapshot, source string) (modelVersion, error) {
+	previous := modelVersion{name: e.activeModel, snapshot: e.mlEngine.Snapshot()}
+	if err := e.mlEngine.Restore(snapshot); err != nil {
+		return previous, err
+	}
+	if err := e.activateVersion(source); err != nil {
+		if restoreErr := e.mlEngine.Restore(previous.snapshot); restoreErr != nil {
+			slog.Error("Failed to restore the previous model", "error", restoreErr)
+		}
+		return previous, err
+	}
+
+	e.modelHistory = append(e.modelHistory, previous)
+	if len(e.modelHistory) > maxModelHistory {
+		e.modelHistory = e.modelHistory[1:]
+	}
+	return previous, nil
+}
+
+// RollbackModel reactivates the model that was active before the current
+// one and returns its name, which is empty for the model trained at startup
+func (e *MLCortexEngine) RollbackModel() (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.modelHistory) == 0 {
+		return "", ErrNoPreviousModel
+	}
+
+	previous := e.modelHistory[len(e.modelHistory)-1]
+	current := e.mlEngine.Snapshot()
+	if err := e.mlEngine.Restore(previous.snapshot); err != nil {
+		return "", fmt.Errorf("failed to roll back to model %q: %w", previous.name, err)
+	}
+	if err := e.activateVersion("rollback"); err != nil {
+		if restoreErr := e.mlEngine.Restore(current); restoreErr != nil {
+			slog.Error("Failed to restore the current model", "error", restoreErr)
+		}
+		return "", err
+	}
+
+	slog.Info("Model rolled back", "name", previous.name, "replaced", e.activeModel)
+	e.modelHistory = e.modelHistory[:len(e.modelHistory)-1]
+	e.activeModel = previous.name
+	return previous.name, nil
+}
+
+// ActiveModel returns the name of the active model, which is empty for the
+// model trained at startup
+func (e *MLCortexEngine) ActiveModel() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.activeModel
+}
+
+// modelFile resolves a model name to a path in the model directory. Names
+// are plain file names, so the API cannot reach files outside of it.
+func (e *MLCortexEngine) modelFile(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return "", fmt.Errorf("%w: invalid name %q", ErrModelNotFound, name)
+	}
+	return filepath.Join(e.config.ModelDir, name), nil
+}
+
+// modelInfo describes a model file. Unreadable model files are described
+// with their error. The engine is only locked to look up the model's state,
+// not while the file is read.
+func (e *MLCortexEngine) modelInfo(name string) (*ModelInfo, error) {
+	path, err := e.modelFile(name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ModelInfo{
+		Name:    name,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	}
+	e.mu.RLock()
+	info.Active = name == e.activeModel
+	_, info.Loaded = e.loadedModels[name]
+	e.mu.RUnlock()
+
+	snapshot, err := ml.ReadModelFile(path)
+	if err != nil {
+		info.Error = err.Error()
+		return info, err
+	}
+	info.ModelType = snapshot.ModelType
+	info.FeatureSize = snapshot.FeatureSize
+	if snapshot.FeatureSchema != nil {
+		info.FeatureSchema = snapshot.FeatureSchema.Fingerprint()
+	}
+	info.CreatedAt = snapshot.CreatedAt
+	return info, nil
+}
+
+// ModelVersions returns the registered model versions, oldest first
+func (e *MLCortexEngine) ModelVersions() []registry.Version {
+	return e.registry.List()
+}
+
+// ModelVersion returns a registered model version by ID
+func (e *MLCortexEngine) ModelVersion(id string) (*registry.Version, error) {
+	return e.registry.Get(id)
+}
+
+// EvaluationCurves returns the ROC and precision-recall curves of the flows
+// held out when the active model was trained
+func (e *MLCortexEngine) EvaluationCurves() (*ml.Curves, error) {
+	return e.mlEngine.Curves()
+}
+
+// FeatureImportance returns the importance of each feature to the active
+// model
+func (e *MLCortexEngine) FeatureImportance() ([]ml.FeatureImportance, error) {
+	return e.mlEngine.FeatureImportance()
+}
+
+// ActiveModelVersion returns the ID of the model version producing results,
+// which is empty for tflite models
+func (e *MLCortexEngine) ActiveModelVersion() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.activeVersion
+}
+
+// activateVersion registers the version of the ML engine's parameters and
+// makes it the active version. source describes where a new version comes
+// from. The caller holds e.mu, except during construction.
+func (e *MLCortexEngine) activateVersion(source string) error {
+	// Online updates keep the distribution the model was trained on
+	if e.drift != nil && source != "online" {
+		e.drift.reset(e.mlEngine.DriftReference())
+	}
+
+	if e.config.ModelFormat == ml.ModelFormatTFLite {
+		return nil
+	}
+
+	version, err := e.registry.Activate(e.mlEngine.Snapshot(), source)
+	if err != nil {
+		return err
+	}
+	e.activeVersion = version.ID
+	return nil
+}
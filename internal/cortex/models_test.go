@@ -0,0 +1,172 @@
+package cortex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+)
+
+func TestModelManagement(t *testing.T) {
+	cfg := config.DefaultMLConfig()
+	cfg.ModelType = "svm"
+	cfg.FeatureSize = 8
+	cfg.FakeDataSize = 50
+	cfg.ModelDir = t.TempDir()
+	cfg.ModelPath = filepath.Join(t.TempDir(), "model")
+
+	engine, err := NewMLCortexEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	// Write a model with a distinct bias and one that does not fit the engine
+	snapshot := engine.mlEngine.Snapshot()
+	startupBias := snapshot.SVMBias
+	startupVersion := engine.ActiveModelVersion()
+	if startupVersion == "" {
+		t.Fatal("Expected the startup model to be versioned")
+	}
+	snapshot.SVMBias = startupBias + 1
+	if err := ml.WriteModelFile(filepath.Join(cfg.ModelDir, "v2"), snapshot); err != nil {
+		t.Fatalf("Failed to write model: %v", err)
+	}
+	if err := ml.WriteModelFile(filepath.Join(cfg.ModelDir, "wide"), &ml.ModelSnapshot{ModelType: "svm", FeatureSize: 16}); err != nil {
+		t.Fatalf("Failed to write model: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.ModelDir, "README"), []byte("not a model"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	models, err := engine.ListModels()
+	if err != nil {
+		t.Fatalf("ListModels failed: %v", err)
+	}
+	if len(models) != 2 || models[0].Name != "v2" || models[1].Name != "wide" {
+		t.Fatalf("Unexpected models: %+v", models)
+	}
+
+	if err := engine.ActivateModel("v2"); !errors.Is(err, ErrModelNotLoaded) {
+		t.Errorf("Expected ErrModelNotLoaded, got %v", err)
+	}
+	if _, err := engine.LoadModel("wide"); !errors.Is(err, ErrIncompatibleModel) {
+		t.Errorf("Expected ErrIncompatibleModel, got %v", err)
+	}
+	for _, name := range []string{"missing", "../v2", ".."} {
+		if _, err := engine.LoadModel(name); !errors.Is(err, ErrModelNotFound) {
+			t.Errorf("Expected ErrModelNotFound for %q, got %v", name, err)
+		}
+	}
+
+	info, err := engine.LoadModel("v2")
+	if err != nil {
+		t.Fatalf("LoadModel failed: %v", err)
+	}
+	if !info.Loaded || info.Active || info.FeatureSize != 8 {
+		t.Errorf("Unexpected model info: %+v", info)
+	}
+
+	if err := engine.ActivateModel("v2"); err != nil {
+		t.Fatalf("ActivateModel failed: %v", err)
+	}
+	if engine.ActiveModel() != "v2" || engine.mlEngine.Snapshot().SVMBias != startupBias+1 {
+		t.Error("Expected v2 to be active")
+	}
+
+	// Results record the version that produced them
+	v2Version := engine.ActiveModelVersion()
+	result, err := engine.Analyze(context.Background(), make([]float64, cfg.FeatureSize), "a-b")
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if result.ModelVersion != v2Version || v2Version == startupVersion {
+		t.Errorf("Expected result of version %s, got %s", v2Version, result.ModelVersion)
+	}
+	version, err := engine.ModelVersion(v2Version)
+	if err != nil {
+		t.Fatalf("ModelVersion failed: %v", err)
+	}
+	if version.Source != "file:v2" || version.FeatureSize != 8 {
+		t.Errorf("Unexpected version: %+v", version)
+	}
+
+	name, err := engine.RollbackModel()
+	if err != nil {
+		t.Fatalf("RollbackModel failed: %v", err)
+	}
+	if name != "" || engine.mlEngine.Snapshot().SVMBias != startupBias {
+		t.Errorf("Expected the startup model to be active, got %q", name)
+	}
+	if engine.ActiveModelVersion() != startupVersion {
+		t.Errorf("Expected version %s after rollback, got %s", startupVersion, engine.ActiveModelVersion())
+	}
+	if versions := engine.ModelVersions(); len(versions) != 2 {
+		t.Errorf("Expected 2 versions, got %+v", versions)
+	}
+	if _, err := engine.RollbackModel(); !errors.Is(err, ErrNoPreviousModel) {
+		t.Errorf("Expected ErrNoPreviousModel, got %v", err)
+	}
+}
+
+func TestLoadedModelsBounded(t *testing.T) {
+	cfg := config.DefaultMLConfig()
+	cfg.ModelType = "svm"
+	cfg.FeatureSize = 8
+	cfg.FakeDataSize = 50
+	cfg.ModelDir = t.TempDir()
+	cfg.ModelPath = filepath.Join(t.TempDir(), "model")
+
+	engine, err := NewMLCortexEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	snapshot := engine.mlEngine.Snapshot()
+	names := make([]string, maxLoadedModels+2)
+	for i := range names {
+		names[i] = fmt.Sprintf("v%d", i)
+		if err := ml.WriteModelFile(filepath.Join(cfg.ModelDir, names[i]), snapshot); err != nil {
+			t.Fatalf("Failed to write model: %v", err)
+		}
+	}
+
+	// The active model stays loaded; the least recently loaded others are
+	// unloaded beyond the limit
+	if _, err := engine.LoadModel(names[0]); err != nil {
+		t.Fatalf("LoadModel failed: %v", err)
+	}
+	if err := engine.ActivateModel(names[0]); err != nil {
+		t.Fatalf("ActivateModel failed: %v", err)
+	}
+	for _, name := range names[1:] {
+		if _, err := engine.LoadModel(name); err != nil {
+			t.Fatalf("LoadModel failed: %v", err)
+		}
+	}
+
+	models, err := engine.ListModels()
+	if err != nil {
+		t.Fatalf("ListModels failed: %v", err)
+	}
+	var loaded []string
+	for _, model := range models {
+		if model.Loaded {
+			loaded = append(loaded, model.Name)
+		}
+	}
+	want := append([]string{names[0]}, names[3:]...)
+	if !slices.Equal(loaded, want) {
+		t.Errorf("Expected %v to be loaded, got %v", want, loaded)
+	}
+	if err := engine.ActivateModel(names[1]); !errors.Is(err, ErrModelNotLoaded) {
+		t.Errorf("Expected ErrModelNotLoaded for an unloaded model, got %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package cortex
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	reviewQueueItems = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "argus_cortex_review_queue_items",
+			Help: "Number of uncertain predictions waiting for review",
+		},
+	)
+	reviewDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_review_dropped_total",
+			Help: "Total number of unreviewed predictions evicted from the full review queue",
+		},
+	)
+	reviewLabels = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_review_labels_total",
+			Help: "Total number of review queue items labelled by verdict",
+		},
+		[]string{"verdict"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(reviewQueueItems, reviewDropped, reviewLabels)
+}
+
+var (
+	// ErrNoReviewQueue is returned when the review queue is disabled
+	ErrNoReviewQueue = errors.New("review queue disabled")
+
+	// ErrReviewItemNotFound is returned for review item IDs not in the queue
+	ErrReviewItemNotFound = errors.New("review item not found")
+)
+
+// ReviewItem is an uncertain prediction waiting for an analyst's verdict
+type ReviewItem struct {
+	ID           string    `json:"id"`
+	FlowID       string    `json:"flow_id"`
+	Features     []float64 `json:"features"`
+	IsBot        bool      `json:"is_bot"`
+	Confidence   float64   `json:"confidence"`
+	Reasoning    string    `json:"reasoning"`
+	ModelUsed    string    `json:"model_used"`
+	ModelVersion string    `json:"model_version,omitempty"`
+	Timestamp    time.Time `json:"timestamp"` // time of the prediction
+}
+
+// reviewQueue holds uncertain predictions, evicting the oldest when full
+type reviewQueue struct {
+	size          int
+	minConfidence float64
+	maxConfidence float64
+
+	mu    sync.Mutex
+	items *list.List               // of *ReviewItem, oldest first
+	index map[string]*list.Element // by ID
+}
+
+func newReviewQueue(size int, minConfidence, maxConfidence float64) *reviewQueue {
+	return &reviewQueue{
+		size:          size,
+		minConfidence: minConfidence,
+		maxConfidence: maxConfidence,
+		items:         list.New(),
+		index:         make(map[string]*list.Element),
+	}
+}
+
+// offer queues a result whose confidence is in the review band
+func (q *reviewQueue) offer(result *DetectionResult, features []float64) {
+	if result.Confidence < q.minConfidence || result.Confidence > q.maxConfidence {
+		return
+	}
+
+	item := &ReviewItem{
+		ID:           newReviewID(),
+		FlowID:       result.FlowID,
+		Features:     append([]float64(nil), features...),
+		IsBot:        result.IsBot,
+		Confidence:   result.Confidence,
+		Reasoning:    result.Reasoning,
+		ModelUsed:    result.ModelUsed,
+		ModelVersion: result.ModelVersion,
+		Timestamp:    result.Timestamp,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.items.Len() >= q.size {
+		oldest := q.items.Front()
+		q.items.Remove(oldest)
+		delete(q.index, oldest.Value.(*ReviewItem).ID)
+		reviewDropped.Inc()
+	}
+	q.index[item.ID] = q.items.PushBack(item)
+	reviewQueueItems.Set(float64(q.items.Len()))
+}
+
+// list returns copies of the queued items, oldest first
+func (q *reviewQueue) list() []ReviewItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := make([]ReviewItem, 0, q.items.Len())
+	for e := q.items.Front(); e != nil; e = e.Next() {
+		items = append(items, *e.Value.(*ReviewItem))
+	}
+	return items
+}
+
+// get returns the item with the given ID
+func (q *reviewQueue) get(id string) (*ReviewItem, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.index[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrReviewItemNotFound, id)
+	}
+	item := *e.Value.(*ReviewItem)
+	return &item, nil
+}
+
+// remove drops the item with the given ID
+func (q *reviewQueue) remove(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if e, ok := q.index[id]; ok {
+		q.items.Remove(e)
+		delete(q.index, id)
+	}
+	reviewQueueItems.Set(float64(q.items.Len()))
+}
+
+// newReviewID returns a random review item ID
+func newReviewID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// ReviewQueue returns the predictions waiting for review, oldest first
+func (e *MLCortexEngine) ReviewQueue() ([]ReviewItem, error) {
+	if e.review == nil {
+		return nil, ErrNoReviewQueue
+	}
+	return e.review.list(), nil
+}
+
+// LabelReviewItem records an analyst's verdict on a queued prediction: the
+// flow is learned by the model and leaves the queue
+func (e *MLCortexEngine) LabelReviewItem(id string, isBot bool) (*ReviewItem, error) {
+	if e.review == nil {
+		return nil, ErrNoReviewQueue
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	item, err := e.review.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	label, verdict := 0, "human"
+	if isBot {
+		label, verdict = 1, "bot"
+	}
+	if err := e.mlEngine.PartialFit([][]float64{item.Features}, []int{label}); err != nil {
+		return nil, fmt.Errorf("failed to learn review label: %w", err)
+	}
+	if err := e.activateVersion("online"); err != nil {
+		return nil, err
+	}
+	e.review.remove(id)
+	reviewLabels.WithLabelValues(verdict).Inc()
+
+	slog.Info("Review item labelled",
+		"id", id,
+		"flow_id", item.FlowID,
+		"verdict", verdict,
+		"predicted_bot", item.IsBot,
+		"confidence", item.Confidence)
+
+	return item, nil
+}
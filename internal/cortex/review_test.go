@@ -0,0 +1,94 @@
+package cortex
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+func TestReviewQueueBand(t *testing.T) {
+	q := newReviewQueue(2, 0.4, 0.6)
+
+	for _, confidence := range []float64{0.1, 0.4, 0.5, 0.6, 0.9} {
+		q.offer(&DetectionResult{FlowID: "flow", Confidence: confidence, Timestamp: time.Now()}, []float64{confidence})
+	}
+
+	// 0.4 was evicted by the later in-band results
+	items := q.list()
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 queued items, got %d", len(items))
+	}
+	if items[0].Confidence != 0.5 || items[1].Confidence != 0.6 {
+		t.Errorf("Unexpected queued confidences %v and %v", items[0].Confidence, items[1].Confidence)
+	}
+
+	if _, err := q.get("missing"); !errors.Is(err, ErrReviewItemNotFound) {
+		t.Errorf("Expected ErrReviewItemNotFound, got %v", err)
+	}
+	q.remove(items[0].ID)
+	if len(q.list()) != 1 {
+		t.Errorf("Expected 1 queued item after removal, got %d", len(q.list()))
+	}
+}
+
+func TestLabelReviewItem(t *testing.T) {
+	cfg := config.DefaultMLConfig()
+	cfg.ModelType = "svm"
+	cfg.FeatureSize = 8
+	cfg.FakeDataSize = 50
+	cfg.ModelDir = t.TempDir()
+	cfg.ModelPath = filepath.Join(t.TempDir(), "model")
+	cfg.ReviewMinConfidence = 0
+	cfg.ReviewMaxConfidence = 1
+
+	engine, err := NewMLCortexEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	result, err := engine.Analyze(context.Background(), make([]float64, cfg.FeatureSize), "flow")
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	items, err := engine.ReviewQueue()
+	if err != nil {
+		t.Fatalf("ReviewQueue failed: %v", err)
+	}
+	if len(items) != 1 || items[0].FlowID != "flow" || items[0].ModelVersion != result.ModelVersion {
+		t.Fatalf("Unexpected review queue %+v", items)
+	}
+
+	item, err := engine.LabelReviewItem(items[0].ID, true)
+	if err != nil {
+		t.Fatalf("LabelReviewItem failed: %v", err)
+	}
+	if item.ID != items[0].ID {
+		t.Errorf("Labelled item %s, expected %s", item.ID, items[0].ID)
+	}
+	if got := engine.GetMLStatistics().OnlineSamples; got != 1 {
+		t.Errorf("Expected 1 online sample, got %d", got)
+	}
+	if engine.ActiveModelVersion() == result.ModelVersion {
+		t.Error("Expected a new model version after learning the label")
+	}
+
+	if _, err := engine.LabelReviewItem(items[0].ID, true); !errors.Is(err, ErrReviewItemNotFound) {
+		t.Errorf("Expected ErrReviewItemNotFound for a labelled item, got %v", err)
+	}
+}
+
+func TestReviewQueueDisabled(t *testing.T) {
+	engine := &MLCortexEngine{}
+	if _, err := engine.ReviewQueue(); !errors.Is(err, ErrNoReviewQueue) {
+		t.Errorf("Expected ErrNoReviewQueue, got %v", err)
+	}
+	if _, err := engine.LabelReviewItem("id", false); !errors.Is(err, ErrNoReviewQueue) {
+		t.Errorf("Expected ErrNoReviewQueue, got %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package cortex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/registry"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	shadowComparisons = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_shadow_comparisons_total",
+			Help: "Total number of flows scored by the shadow model by whether its verdict agreed with the active model",
+		},
+		[]string{"outcome"},
+	)
+	shadowErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_shadow_errors_total",
+			Help: "Total number of flows the shadow model failed to score",
+		},
+	)
+	shadowDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_shadow_dropped_total",
+			Help: "Total number of flows not scored by the shadow model because its queue was full",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(shadowComparisons, shadowErrors, shadowDropped)
+}
+
+// ErrNoShadowModel is returned for shadow statistics when no shadow model
+// is configured
+var ErrNoShadowModel = errors.New("no shadow model configured")
+
+// ShadowStats compares the verdicts of the shadow model with those of the
+// active model on the same flows
+type ShadowStats struct {
+	Model   string `json:"model"`             // path of the shadow model file
+	Version string `json:"version,omitempty"` // registry ID, empty for tflite models
+
+	Comparisons   int64   `json:"comparisons"`
+	Agreements    int64   `json:"agreements"`
+	Disagreements int64   `json:"disagreements"`
+	AgreementRate float64 `json:"agreement_rate"`
+
+	// Disagreements by direction: the shadow model found a bot where the
+	// active model did not, or the other way around
+	ShadowOnlyBot int64 `json:"shadow_only_bot"`
+	ActiveOnlyBot int64 `json:"active_only_bot"`
+
+	// Mean absolute difference of the confidence scores
+	MeanConfidenceDelta float64 `json:"mean_confidence_delta"`
+
+	Errors  int64 `json:"errors"`
+	Dropped int64 `json:"dropped"`
+}
+
+// shadowItem is a flow scored by the active model, queued for the shadow
+// model
+type shadowItem struct {
+	features   []float64
+	flowID     string
+	isBot      bool
+	confidence float64
+}
+
+// shadowModel scores flows with a challenger model in the background so
+// that it never delays or changes the active model's verdicts
+type shadowModel struct {
+	engine *ml.MLEngine
+	queue  chan shadowItem
+	done   chan struct{}
+
+	mu         sync.Mutex
+	stats      ShadowStats
+	deltaTotal float64
+}
+
+// newShadowModel loads the shadow model configured in cfg and starts
+// scoring queued flows until ctx is done
+func newShadowModel(ctx context.Context, cfg config.MLConfig) (*shadowModel, error) {
+	mlConfig := ml.MLConfig{
+		ModelType:          cfg.ModelType,
+		DetectionThreshold: cfg.DetectionThreshold,
+		FeatureSize:        cfg.FeatureSize,
+		KNNNeighbors:       cfg.KNNNeighbors,
+		KNNDistance:        cfg.KNNDistance,
+		SVMMode:            cfg.SVMMode,
+		EnsembleModels:     cfg.EnsembleModels,
+		ModelFormat:        cfg.ModelFormat,
+		ModelPath:          cfg.ShadowModelPath,
+		TFLiteThreads:      cfg.TFLiteThreads,
+	}
+
+	// The shadow model may be of another type than the active one
+	var snapshot *ml.ModelSnapshot
+	if cfg.ModelFormat != ml.ModelFormatTFLite {
+		var err error
+		if snapshot, err = ml.ReadModelFile(cfg.ShadowModelPath); err != nil {
+			return nil, fmt.Errorf("failed to read shadow model: %w", err)
+		}
+		mlConfig.ModelType = snapshot.ModelType
+	}
+
+	engine, err := ml.NewMLEngine(mlConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize shadow model: %w", err)
+	}
+
+	s := &shadowModel{
+		engine: engine,
+		queue:  make(chan shadowItem, cfg.ShadowQueueSize),
+		done:   make(chan struct{}),
+		stats:  ShadowStats{Model: cfg.ShadowModelPath},
+	}
+	if snapshot != nil {
+		if err := engine.Restore(snapshot); err != nil {
+			engine.Close()
+			return nil, fmt.Errorf("failed to load shadow model: %w", err)
+		}
+		s.stats.Version = registry.VersionID(snapshot)
+	}
+
+	go s.run(ctx)
+
+	slog.Info("Shadow model loaded",
+		"path", cfg.ShadowModelPath,
+		"model_type", mlConfig.ModelType,
+		"version", s.stats.Version)
+
+	return s, nil
+}
+
+// enqueue queues a flow scored by the active model, dropping it when the
+// queue is full
+func (s *shadowModel) enqueue(item shadowItem) {
+	select {
+	case s.queue <- item:
+	default:
+		shadowDropped.Inc()
+		s.mu.Lock()
+		s.stats.Dropped++
+		s.mu.Unlock()
+	}
+}
+
+// run scores queued flows until ctx is done
+func (s *shadowModel) run(ctx context.Context) {
+	defer close(s.done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case item := <-s.queue:
+			s.score(ctx, item)
+		}
+	}
+}
+
+// score compares the shadow verdict on a flow with the active one
+func (s *shadowModel) score(ctx context.Context, item shadowItem) {
+	result, err := s.engine.Predict(ctx, item.features, item.flowID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		shadowErrors.Inc()
+		s.stats.Errors++
+		slog.Debug("Shadow model scoring failed", "flow_id", item.flowID, "error", err)
+		return
+	}
+
+	s.stats.Comparisons++
+	s.deltaTotal += math.Abs(result.Confidence - item.confidence)
+	switch {
+	case result.IsBot == item.isBot:
+		s.stats.Agreements++
+		shadowComparisons.WithLabelValues("agree").Inc()
+	case result.IsBot:
+		s.stats.Disagreements++
+		s.stats.ShadowOnlyBot++
+		shadowComparisons.WithLabelValues("disagree").Inc()
+	default:
+		s.stats.Disagreements++
+		s.stats.ActiveOnlyBot++
+		shadowComparisons.WithLabelValues("disagree").Inc()
+	}
+}
+
+// snapshot returns a copy of the comparison statistics
+func (s *shadowModel) snapshot() ShadowStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	if stats.Comparisons > 0 {
+		stats.AgreementRate = float64(stats.Agreements) / float64(stats.Comparisons)
+		stats.MeanConfidenceDelta = s.deltaTotal / float64(stats.Comparisons)
+	}
+	return stats
+}
+
+// close waits for the scoring goroutine, whose context must be done, and
+// releases the shadow engine
+func (s *shadowModel) close() error {
+	<-s.done
+	return s.engine.Close()
+}
+
+// ShadowStats returns how the verdicts of the shadow model compare with
+// those of the active model
+func (e *MLCortexEngine) ShadowStats() (*ShadowStats, error) {
+	if e.shadow == nil {
+		return nil, ErrNoShadowModel
+	}
+	stats := e.shadow.snapshot()
+	return &stats, nil
+}
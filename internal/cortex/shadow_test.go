@@ -0,0 +1,96 @@
+package cortex
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+)
+
+func TestShadowModel(t *testing.T) {
+	cfg := config.DefaultMLConfig()
+	cfg.ModelType = "svm"
+	cfg.FeatureSize = 8
+	cfg.FakeDataSize = 50
+	cfg.ModelDir = t.TempDir()
+	cfg.ModelPath = filepath.Join(t.TempDir(), "model")
+	cfg.DetectionThreshold = 0.5
+
+	// A challenger that finds a bot in every flow
+	cfg.ShadowModelPath = filepath.Join(t.TempDir(), "challenger")
+	challenger := &ml.ModelSnapshot{ModelType: "svm", FeatureSize: 8, SVMTrained: true, SVMWeights: make([]float64, 8), SVMBias: 10}
+	if err := ml.WriteModelFile(cfg.ShadowModelPath, challenger); err != nil {
+		t.Fatalf("Failed to write model: %v", err)
+	}
+
+	engine, err := NewMLCortexEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	bots := 0
+	for i := 0; i < 20; i++ {
+		features := make([]float64, cfg.FeatureSize)
+		for j := range features {
+			features[j] = float64((i+j)%10) / 10
+		}
+		result, err := engine.Analyze(context.Background(), features, "flow")
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		if result.IsBot {
+			bots++
+		}
+	}
+
+	var stats *ShadowStats
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if stats, err = engine.ShadowStats(); err != nil {
+			t.Fatalf("ShadowStats failed: %v", err)
+		}
+		if stats.Comparisons == 20 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if stats.Comparisons != 20 || stats.Agreements != int64(bots) || stats.ShadowOnlyBot != int64(20-bots) || stats.ActiveOnlyBot != 0 {
+		t.Errorf("Unexpected shadow statistics for %d bots: %+v", bots, stats)
+	}
+	if stats.Version != challenger.Hash()[:12] || stats.Model != cfg.ShadowModelPath {
+		t.Errorf("Unexpected shadow model: %+v", stats)
+	}
+	if want := float64(bots) / 20; stats.AgreementRate != want {
+		t.Errorf("Expected agreement rate %v, got %v", want, stats.AgreementRate)
+	}
+}
+
+func TestShadowModelNotConfigured(t *testing.T) {
+	cfg := config.DefaultMLConfig()
+	cfg.ModelType = "svm"
+	cfg.FeatureSize = 8
+	cfg.FakeDataSize = 50
+	cfg.ModelDir = t.TempDir()
+	cfg.ModelPath = filepath.Join(t.TempDir(), "model")
+
+	engine, err := NewMLCortexEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	if _, err := engine.ShadowStats(); !errors.Is(err, ErrNoShadowModel) {
+		t.Errorf("Expected ErrNoShadowModel, got %v", err)
+	}
+
+	cfg.ShadowModelPath = filepath.Join(t.TempDir(), "missing")
+	if _, err := NewMLCortexEngine(cfg); err == nil {
+		t.Error("Expected an error for a missing shadow model")
+	}
+}
@@ -0,0 +1,86 @@
+package cortex
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// modelReloadDelay is how long a model file must stay unchanged after a
+// change before it is reloaded, so files written in several steps are only
+// read once complete
+const modelReloadDelay = 500 * time.Millisecond
+
+var (
+	modelReloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_model_reloads_total",
+			Help: "Total number of model file reloads by result",
+		},
+		[]string{"result"},
+	)
+	modelReloadTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "argus_cortex_model_last_reload_timestamp_seconds",
+			Help: "Time of the last successful model file reload",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(modelReloads, modelReloadTimestamp)
+}
+
+// watchModelFile calls reload after the file at path is written or
+// replaced, until ctx is done. The directory is watched rather than the
+// file so that files renamed into place are seen.
+func watchModelFile(ctx context.Context, path string, delay time.Duration, reload func() error) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create model watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", filepath.Dir(path), err)
+	}
+	slog.Info("Watching model file", "path", path)
+
+	timer := time.NewTimer(delay)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) == path && event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+				timer.Reset(delay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Warn("Model watcher error", "path", path, "error", err)
+		case <-timer.C:
+			if err := reload(); err != nil {
+				modelReloads.WithLabelValues("failure").Inc()
+				slog.Error("Model reload failed, keeping the current model", "path", path, "error", err)
+				continue
+			}
+			modelReloads.WithLabelValues("success").Inc()
+			modelReloadTimestamp.SetToCurrentTime()
+			slog.Info("Model reloaded", "path", path)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package cortex
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReloadModelFile(t *testing.T) {
+	cfg := config.DefaultMLConfig()
+	cfg.ModelType = "svm"
+	cfg.FeatureSize = 8
+	cfg.FakeDataSize = 50
+	cfg.ModelDir = t.TempDir()
+	cfg.ModelPath = filepath.Join(t.TempDir(), "model")
+
+	engine, err := NewMLCortexEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	startup := engine.mlEngine.Snapshot()
+	pushed := *startup
+	pushed.SVMBias = startup.SVMBias + 1
+	if err := ml.WriteModelFile(cfg.ModelPath, &pushed); err != nil {
+		t.Fatalf("Failed to write model: %v", err)
+	}
+	if err := engine.ReloadModelFile(); err != nil {
+		t.Fatalf("ReloadModelFile failed: %v", err)
+	}
+	if bias := engine.mlEngine.Snapshot().SVMBias; bias != pushed.SVMBias {
+		t.Errorf("Expected bias %v after reload, got %v", pushed.SVMBias, bias)
+	}
+	if version := engine.ActiveModelVersion(); version != pushed.Hash()[:12] {
+		t.Errorf("Expected version %s after reload, got %s", pushed.Hash()[:12], version)
+	}
+
+	// Invalid files keep the current model
+	if err := ml.WriteModelFile(cfg.ModelPath, &ml.ModelSnapshot{ModelType: "svm", FeatureSize: 16}); err != nil {
+		t.Fatalf("Failed to write model: %v", err)
+	}
+	if err := engine.ReloadModelFile(); !errors.Is(err, ErrIncompatibleModel) {
+		t.Errorf("Expected ErrIncompatibleModel, got %v", err)
+	}
+	if err := os.WriteFile(cfg.ModelPath, []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.ReloadModelFile(); !errors.Is(err, ml.ErrNotModelFile) {
+		t.Errorf("Expected ErrNotModelFile, got %v", err)
+	}
+	if bias := engine.mlEngine.Snapshot().SVMBias; bias != pushed.SVMBias {
+		t.Errorf("Expected bias %v to be kept, got %v", pushed.SVMBias, bias)
+	}
+
+	// The reloaded model can be rolled back
+	if _, err := engine.RollbackModel(); err != nil {
+		t.Fatalf("RollbackModel failed: %v", err)
+	}
+	if bias := engine.mlEngine.Snapshot().SVMBias; bias != startup.SVMBias {
+		t.Errorf("Expected startup bias %v after rollback, got %v", startup.SVMBias, bias)
+	}
+}
+
+func TestWatchModelConfig(t *testing.T) {
+	cfg := config.DefaultMLConfig()
+	cfg.ModelType = "svm"
+	cfg.FeatureSize = 8
+	cfg.FakeDataSize = 50
+	cfg.ModelDir = t.TempDir()
+	cfg.ModelPath = filepath.Join(t.TempDir(), "model")
+	cfg.WatchModel = true
+
+	engine, err := NewMLCortexEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	// Writing the model file reloads it without any call on the engine.
+	// Writes are repeated in case the watcher was not running yet, but
+	// less often than the reload delay each write restarts.
+	pushed := *engine.mlEngine.Snapshot()
+	pushed.SVMBias++
+	deadline := time.After(10 * time.Second)
+	rewrite := time.NewTicker(2 * modelReloadDelay)
+	defer rewrite.Stop()
+	if err := ml.WriteModelFile(cfg.ModelPath, &pushed); err != nil {
+		t.Fatalf("Failed to write model: %v", err)
+	}
+	for engine.mlEngine.Snapshot().SVMBias != pushed.SVMBias {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-rewrite.C:
+			if err := ml.WriteModelFile(cfg.ModelPath, &pushed); err != nil {
+				t.Fatalf("Failed to write model: %v", err)
+			}
+		case <-deadline:
+			t.Fatal("Timed out waiting for the model file to be reloaded")
+		}
+	}
+
+	// Close stops the watcher
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestWatchModelFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "model")
+
+	reloads := make(chan struct{}, 10)
+	fail := true
+	reload := func() error {
+		reloads <- struct{}{}
+		if fail {
+			fail = false
+			return errors.New("invalid model")
+		}
+		return nil
+	}
+	failures := testutil.ToFloat64(modelReloads.WithLabelValues("failure"))
+	successes := testutil.ToFloat64(modelReloads.WithLabelValues("success"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- watchModelFile(ctx, path, 10*time.Millisecond, reload) }()
+
+	// Other files in the directory are ignored
+	if err := os.WriteFile(filepath.Join(dir, "other"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write until the watcher has picked up a change, then replace the
+	// file atomically
+	waitReload := func(write func()) {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for {
+			write()
+			select {
+			case <-reloads:
+				return
+			case <-time.After(100 * time.Millisecond):
+			case <-deadline:
+				t.Fatal("Timed out waiting for a reload")
+			}
+		}
+	}
+	waitReload(func() {
+		if err := os.WriteFile(path, []byte("v1"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	waitReload(func() {
+		tmp := filepath.Join(dir, ".model-tmp")
+		if err := os.WriteFile(tmp, []byte("v2"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("watchModelFile failed: %v", err)
+	}
+	if got := testutil.ToFloat64(modelReloads.WithLabelValues("failure")) - failures; got != 1 {
+		t.Errorf("Expected 1 failed reload, got %v", got)
+	}
+	if got := testutil.ToFloat64(modelReloads.WithLabelValues("success")) - successes; got < 1 {
+		t.Errorf("Expected a successful reload, got %v", got)
+	}
+}
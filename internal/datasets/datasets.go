@@ -0,0 +1,549 @@
+// Package datasets manages named, versioned labelled datasets, so that
+// training, evaluation and drift analysis refer to dataset versions
+// instead of loose files.
+package datasets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/dataset"
+)
+
+// FileName is the name of the manifest in the dataset directory
+const FileName = "datasets.json"
+
+// ImportDir is the directory, within the dataset directory, that dataset
+// files are imported from by name
+const ImportDir = "import"
+
+// Operations creating dataset versions
+const (
+	OperationCreate = "create"
+	OperationAppend = "append"
+	OperationSplit  = "split"
+)
+
+// unknownLabelSource counts samples without a label source
+const unknownLabelSource = "unknown"
+
+var (
+	// ErrDatasetNotFound is returned for datasets that do not exist
+	ErrDatasetNotFound = errors.New("dataset not found")
+	// ErrVersionNotFound is returned for versions and tags a dataset does
+	// not have
+	ErrVersionNotFound = errors.New("dataset version not found")
+	// ErrDatasetExists is returned when creating a dataset that exists
+	ErrDatasetExists = errors.New("dataset already exists")
+	// ErrInvalid is returned for invalid names, references and samples
+	ErrInvalid = errors.New("invalid dataset request")
+)
+
+// namePattern matches dataset and tag names
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// versionPattern matches version numbers in references, which tags must
+// not look like
+var versionPattern = regexp.MustCompile(`^v?[0-9]+$`)
+
+// Version describes an immutable version of a dataset. Each version holds
+// all samples of the dataset at the time it was created.
+type Version struct {
+	Version   int    `json:"version"`
+	Samples   int    `json:"samples"`
+	Bots      int    `json:"bots"`
+	Added     int    `json:"added"`  // samples added by the operation
+	Digest    string `json:"digest"` // SHA-256 of the version file
+	Operation string `json:"operation"`
+	Source    string `json:"source"`           // where added samples came from, e.g. "file:flows.csv" or "flows@v2" for splits
+	Parent    int    `json:"parent,omitempty"` // version appended to
+
+	// LabelSources counts samples by where their labels came from, e.g.
+	// "feedback" or "import"
+	LabelSources map[string]int `json:"label_sources"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+// Dataset describes a named dataset and its versions, oldest first. Tags
+// name versions, e.g. "golden".
+type Dataset struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Features    []string       `json:"features"`
+	Tags        map[string]int `json:"tags,omitempty"`
+	Versions    []Version      `json:"versions"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+// latest returns the newest version
+func (d *Dataset) latest() *Version {
+	return &d.Versions[len(d.Versions)-1]
+}
+
+// clone returns a deep copy of the dataset
+func (d *Dataset) clone() *Dataset {
+	c := *d
+	c.Features = append([]string(nil), d.Features...)
+	c.Tags = maps.Clone(d.Tags)
+	c.Versions = make([]Version, len(d.Versions))
+	for i, v := range d.Versions {
+		v.LabelSources = maps.Clone(v.LabelSources)
+		c.Versions[i] = v
+	}
+	return &c
+}
+
+// Samples are labelled records added to a dataset
+type Samples struct {
+	// Names of the features, those of the dataset when empty on append
+	Features []string
+	Records  []dataset.Record
+
+	// Source tells where the samples came from; LabelSource is recorded
+	// for records without a label source of their own
+	Source      string
+	LabelSource string
+}
+
+// Reference is a dataset version resolved from a reference
+type Reference struct {
+	Dataset string
+	Version int
+	Path    string // of the version file
+}
+
+// String returns the reference in the form name@vN
+func (r Reference) String() string {
+	return fmt.Sprintf("%s@v%d", r.Dataset, r.Version)
+}
+
+// Store keeps datasets in a directory: a JSON manifest describing the
+// datasets, and a Parquet file per dataset version under a directory per
+// dataset. Version files are never modified once written.
+type Store struct {
+	dir string
+
+	mu       sync.RWMutex
+	datasets map[string]*Dataset // by name
+}
+
+// Open opens the dataset store in dir, creating it on first use
+func Open(dir string) (*Store, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("no dataset directory configured")
+	}
+	s := &Store{dir: dir, datasets: make(map[string]*Dataset)}
+
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dataset manifest: %w", err)
+	}
+
+	var datasets []*Dataset
+	if err := json.Unmarshal(data, &datasets); err != nil {
+		return nil, fmt.Errorf("failed to decode dataset manifest: %w", err)
+	}
+	for _, d := range datasets {
+		if len(d.Versions) == 0 {
+			return nil, fmt.Errorf("dataset %s has no versions", d.Name)
+		}
+		s.datasets[d.Name] = d
+	}
+	return s, nil
+}
+
+// List returns all datasets by name
+func (s *Store) List() []Dataset {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	datasets := make([]Dataset, 0, len(s.datasets))
+	for _, d := range s.datasets {
+		datasets = append(datasets, *d.clone())
+	}
+	sort.Slice(datasets, func(i, j int) bool { return datasets[i].Name < datasets[j].Name })
+	return datasets
+}
+
+// Get returns the dataset with the given name
+func (s *Store) Get(name string) (*Dataset, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.datasets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrDatasetNotFound, name)
+	}
+	return d.clone(), nil
+}
+
+// Create creates a dataset whose first version holds the samples
+func (s *Store) Create(name, description string, samples Samples) (*Version, error) {
+	if !namePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: invalid dataset name %q", ErrInvalid, name)
+	}
+	if len(samples.Features) == 0 && len(samples.Records) > 0 {
+		return nil, fmt.Errorf("%w: no feature names", ErrInvalid)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.datasets[name]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrDatasetExists, name)
+	}
+	d := &Dataset{
+		Name:        name,
+		Description: description,
+		Features:    samples.Features,
+		CreatedAt:   time.Now().UTC(),
+	}
+	v, err := s.addVersion(d, nil, samples, OperationCreate, 0)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Dataset created", "dataset", name, "samples", v.Samples, "source", samples.Source)
+	return v, nil
+}
+
+// Append creates a version of the dataset holding the samples of its
+// latest version and the given ones
+func (s *Store) Append(name string, samples Samples) (*Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.datasets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrDatasetNotFound, name)
+	}
+	if len(samples.Features) > 0 && !slices.Equal(samples.Features, d.Features) {
+		return nil, fmt.Errorf("%w: features differ from those of dataset %s", ErrInvalid, name)
+	}
+	samples.Features = d.Features
+
+	latest := d.latest()
+	existing, err := s.records(d, latest.Version)
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.addVersion(d, existing, samples, OperationAppend, latest.Version)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Dataset appended", "dataset", name, "version", v.Version, "added", v.Added, "source", samples.Source)
+	return v, nil
+}
+
+// Tag names a version of the dataset, moving the tag if it names another
+func (s *Store) Tag(name, tag string, version int) error {
+	if !namePattern.MatchString(tag) || versionPattern.MatchString(tag) || tag == "latest" {
+		return fmt.Errorf("%w: invalid tag %q", ErrInvalid, tag)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.datasets[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrDatasetNotFound, name)
+	}
+	if version < 1 || version > len(d.Versions) {
+		return fmt.Errorf("%w: %s@v%d", ErrVersionNotFound, name, version)
+	}
+
+	previous, tagged := d.Tags[tag]
+	if d.Tags == nil {
+		d.Tags = make(map[string]int)
+	}
+	d.Tags[tag] = version
+	if err := s.save(); err != nil {
+		if tagged {
+			d.Tags[tag] = previous
+		} else {
+			delete(d.Tags, tag)
+		}
+		return err
+	}
+	return nil
+}
+
+// Split divides the samples of a dataset version into a training and a
+// test set, stratified by label, holding out testShare of each label for
+// testing. The sets become new versions of the datasets <name>-train and
+// <name>-test, which are created as needed. The split is reproducible
+// from seed.
+func (s *Store) Split(ref string, testShare float64, seed int64) (train, test *Version, err error) {
+	if testShare <= 0 || testShare >= 1 {
+		return nil, nil, fmt.Errorf("%w: test share must be between 0 and 1", ErrInvalid)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resolved, err := s.resolve(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	d := s.datasets[resolved.Dataset]
+	records, err := s.records(d, resolved.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byLabel := map[int][]dataset.Record{}
+	for _, r := range records {
+		byLabel[*r.Label] = append(byLabel[*r.Label], r)
+	}
+	rng := rand.New(rand.NewSource(seed))
+	var trainRecords, testRecords []dataset.Record
+	for _, label := range []int{0, 1} {
+		group := byLabel[label]
+		rng.Shuffle(len(group), func(i, j int) { group[i], group[j] = group[j], group[i] })
+		n := int(math.Round(testShare * float64(len(group))))
+		testRecords = append(testRecords, group[:n]...)
+		trainRecords = append(trainRecords, group[n:]...)
+	}
+	if len(trainRecords) == 0 || len(testRecords) == 0 {
+		return nil, nil, fmt.Errorf("%w: too few samples in %s to split", ErrInvalid, resolved)
+	}
+
+	source := resolved.String()
+	for _, split := range []struct {
+		suffix  string
+		records []dataset.Record
+		version **Version
+	}{{"-train", trainRecords, &train}, {"-test", testRecords, &test}} {
+		name := d.Name + split.suffix
+		target, ok := s.datasets[name]
+		if !ok {
+			target = &Dataset{
+				Name:        name,
+				Description: fmt.Sprintf("Split of %s", d.Name),
+				Features:    d.Features,
+				CreatedAt:   time.Now().UTC(),
+			}
+		} else if !slices.Equal(target.Features, d.Features) {
+			return nil, nil, fmt.Errorf("%w: features of %s differ from those of %s", ErrInvalid, name, d.Name)
+		}
+		samples := Samples{Features: d.Features, Records: split.records, Source: source}
+		if *split.version, err = s.addVersion(target, nil, samples, OperationSplit, 0); err != nil {
+			return nil, nil, err
+		}
+	}
+	slog.Info("Dataset split", "dataset", source, "train", train.Samples, "test", test.Samples)
+	return train, test, nil
+}
+
+// Resolve returns the version a reference names: a dataset name for its
+// latest version, or name@<version> with a version number (3 or v3), a
+// tag or "latest"
+func (s *Store) Resolve(ref string) (Reference, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.resolve(ref)
+}
+
+// resolve resolves a reference; the caller holds s.mu
+func (s *Store) resolve(ref string) (Reference, error) {
+	name, version, _ := strings.Cut(ref, "@")
+	d, ok := s.datasets[name]
+	if !ok {
+		return Reference{}, fmt.Errorf("%w: %s", ErrDatasetNotFound, name)
+	}
+
+	n := len(d.Versions)
+	switch {
+	case version == "" || version == "latest":
+	case versionPattern.MatchString(version):
+		var err error
+		if n, err = strconv.Atoi(strings.TrimPrefix(version, "v")); err != nil || n < 1 || n > len(d.Versions) {
+			return Reference{}, fmt.Errorf("%w: %s", ErrVersionNotFound, ref)
+		}
+	default:
+		if n, ok = d.Tags[version]; !ok {
+			return Reference{}, fmt.Errorf("%w: %s", ErrVersionNotFound, ref)
+		}
+	}
+	return Reference{Dataset: name, Version: n, Path: s.versionPath(name, n)}, nil
+}
+
+// ImportFile reads the samples of a dataset file in the import directory,
+// labelled or not, as written by the training data collector or with a
+// label column and a column per feature
+func (s *Store) ImportFile(name string) (Samples, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return Samples{}, fmt.Errorf("%w: invalid import file name %q", ErrInvalid, name)
+	}
+	records, features, err := dataset.LoadRecords(filepath.Join(s.dir, ImportDir, name), dataset.Options{})
+	if errors.Is(err, os.ErrNotExist) {
+		return Samples{}, fmt.Errorf("%w: no import file %s", ErrDatasetNotFound, name)
+	}
+	if err != nil {
+		return Samples{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return Samples{Features: features, Records: records, Source: "file:" + name, LabelSource: "import"}, nil
+}
+
+// versionPath returns the path of the file of a dataset version
+func (s *Store) versionPath(name string, version int) string {
+	return filepath.Join(s.dir, name, fmt.Sprintf("v%d.parquet", version))
+}
+
+// records reads the samples of a dataset version; the caller holds s.mu
+func (s *Store) records(d *Dataset, version int) ([]dataset.Record, error) {
+	records, _, err := dataset.LoadRecords(s.versionPath(d.Name, version), dataset.Options{FeatureColumns: d.Features})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s@v%d: %w", d.Name, version, err)
+	}
+	return records, nil
+}
+
+// addVersion writes existing and the labelled samples as the next version
+// of d, adding d to the store if new; the caller holds s.mu
+func (s *Store) addVersion(d *Dataset, existing []dataset.Record, samples Samples, operation string, parent int) (*Version, error) {
+	v := Version{
+		Version:      len(d.Versions) + 1,
+		Operation:    operation,
+		Source:       samples.Source,
+		Parent:       parent,
+		LabelSources: make(map[string]int),
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	records := existing
+	for _, r := range samples.Records {
+		if r.Label == nil {
+			continue
+		}
+		if len(r.Features) != len(d.Features) {
+			return nil, fmt.Errorf("%w: sample has %d features, expected %d", ErrInvalid, len(r.Features), len(d.Features))
+		}
+		if r.LabelSource == "" {
+			r.LabelSource = samples.LabelSource
+		}
+		records = append(records, r)
+		v.Added++
+	}
+	if v.Added == 0 {
+		return nil, fmt.Errorf("%w: no labelled samples", ErrInvalid)
+	}
+	for _, r := range records {
+		v.Samples++
+		v.Bots += *r.Label
+		source := r.LabelSource
+		if source == "" {
+			source = unknownLabelSource
+		}
+		v.LabelSources[source]++
+	}
+
+	path := s.versionPath(d.Name, v.Version)
+	digest, err := writeVersion(path, d.Features, records)
+	if err != nil {
+		return nil, err
+	}
+	v.Digest = digest
+
+	_, known := s.datasets[d.Name]
+	d.Versions = append(d.Versions, v)
+	s.datasets[d.Name] = d
+	if err := s.save(); err != nil {
+		d.Versions = d.Versions[:len(d.Versions)-1]
+		if !known {
+			delete(s.datasets, d.Name)
+		}
+		os.Remove(path)
+		return nil, err
+	}
+	return &v, nil
+}
+
+// writeVersion writes the file of a version and returns its digest
+func writeVersion(path string, features []string, records []dataset.Record) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to write dataset version: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".version-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to write dataset version: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	w, err := dataset.NewWriter(io.MultiWriter(tmp, hash), dataset.FormatParquet, features)
+	if err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	for i := range records {
+		if err := w.Write(&records[i]); err != nil {
+			tmp.Close()
+			return "", fmt.Errorf("%w: sample %d: %v", ErrInvalid, i+1, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write dataset version: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write dataset version: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to write dataset version: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// save writes the manifest, replacing it atomically; the caller holds s.mu
+func (s *Store) save() error {
+	datasets := make([]*Dataset, 0, len(s.datasets))
+	for _, d := range s.datasets {
+		datasets = append(datasets, d)
+	}
+	sort.Slice(datasets, func(i, j int) bool { return datasets[i].Name < datasets[j].Name })
+	data, err := json.MarshalIndent(datasets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode dataset manifest: %w", err)
+	}
+
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to write dataset manifest: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, ".datasets-*")
+	if err != nil {
+		return fmt.Errorf("failed to write dataset manifest: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write dataset manifest: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write dataset manifest: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, FileName)); err != nil {
+		return fmt.Errorf("failed to write dataset manifest: %w", err)
+	}
+	return nil
+}
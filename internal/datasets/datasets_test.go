@@ -0,0 +1,185 @@
+package datasets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/dataset"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// samples returns n labelled samples of two features, every third a bot
+func samples(n int, labelSource string) Samples {
+	s := Samples{Features: []string{"duration", "packets"}, Source: "test", LabelSource: "import"}
+	for i := 0; i < n; i++ {
+		label := 0
+		if i%3 == 0 {
+			label = 1
+		}
+		s.Records = append(s.Records, dataset.Record{
+			FlowID:      fmt.Sprintf("flow-%d", i),
+			Features:    []float64{float64(i), float64(i * 2)},
+			Label:       &label,
+			LabelSource: labelSource,
+		})
+	}
+	return s
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	require.NoError(t, err)
+
+	v1, err := s.Create("flows", "Labelled flows", samples(6, "feedback"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, v1.Version)
+	assert.Equal(t, 6, v1.Samples)
+	assert.Equal(t, 2, v1.Bots)
+	assert.Equal(t, OperationCreate, v1.Operation)
+	assert.Equal(t, map[string]int{"feedback": 6}, v1.LabelSources)
+	assert.Len(t, v1.Digest, 64)
+
+	// Unlabelled records are not stored; records without a label source
+	// get that of the samples
+	more := samples(3, "")
+	more.Records = append(more.Records, dataset.Record{Features: []float64{1, 2}})
+	v2, err := s.Append("flows", more)
+	require.NoError(t, err)
+	assert.Equal(t, 2, v2.Version)
+	assert.Equal(t, 1, v2.Parent)
+	assert.Equal(t, 3, v2.Added)
+	assert.Equal(t, 9, v2.Samples)
+	assert.Equal(t, map[string]int{"feedback": 6, "import": 3}, v2.LabelSources)
+
+	require.NoError(t, s.Tag("flows", "golden", 1))
+
+	for ref, version := range map[string]int{
+		"flows": 2, "flows@latest": 2, "flows@1": 1, "flows@v2": 2, "flows@golden": 1,
+	} {
+		r, err := s.Resolve(ref)
+		require.NoError(t, err, ref)
+		assert.Equal(t, version, r.Version, ref)
+		assert.Equal(t, filepath.Join(dir, "flows", fmt.Sprintf("v%d.parquet", version)), r.Path)
+	}
+
+	// Versions are immutable files of their samples
+	r, err := s.Resolve("flows@golden")
+	require.NoError(t, err)
+	assert.Equal(t, "flows@v1", r.String())
+	ds, err := dataset.Load(r.Path, dataset.Options{FeatureColumns: []string{"duration", "packets"}})
+	require.NoError(t, err)
+	assert.Len(t, ds.Labels, 6)
+
+	// Reopening reads the manifest
+	s, err = Open(dir)
+	require.NoError(t, err)
+	d, err := s.Get("flows")
+	require.NoError(t, err)
+	assert.Equal(t, "Labelled flows", d.Description)
+	assert.Equal(t, []string{"duration", "packets"}, d.Features)
+	assert.Equal(t, map[string]int{"golden": 1}, d.Tags)
+	require.Len(t, d.Versions, 2)
+	assert.Equal(t, v2.Digest, d.Versions[1].Digest)
+
+	// Get returns a copy
+	d.Tags["golden"] = 2
+	d, err = s.Get("flows")
+	require.NoError(t, err)
+	assert.Equal(t, 1, d.Tags["golden"])
+}
+
+func TestStoreSplit(t *testing.T) {
+	s, err := Open(t.TempDir())
+	require.NoError(t, err)
+	_, err = s.Create("flows", "", samples(30, "feedback"))
+	require.NoError(t, err)
+
+	train, test, err := s.Split("flows", 0.2, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 24, train.Samples)
+	assert.Equal(t, 6, test.Samples)
+	assert.Equal(t, 8, train.Bots, "stratified by label")
+	assert.Equal(t, 2, test.Bots)
+	assert.Equal(t, "flows@v1", train.Source)
+	assert.Equal(t, OperationSplit, test.Operation)
+
+	// Splitting again with the same seed gives the same sets, as new
+	// versions
+	train2, _, err := s.Split("flows@v1", 0.2, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, train2.Version)
+	assert.Equal(t, train.Digest, train2.Digest)
+
+	names := []string{}
+	for _, d := range s.List() {
+		names = append(names, d.Name)
+	}
+	assert.Equal(t, []string{"flows", "flows-test", "flows-train"}, names)
+
+	_, _, err = s.Split("flows", 1, 1)
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestImportFile(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ImportDir), 0o755))
+	data := "duration,packets,label\n0.5,3,bot\n1.5,40,\n2.5,7,human\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ImportDir, "flows.csv"), []byte(data), 0o644))
+
+	imported, err := s.ImportFile("flows.csv")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"duration", "packets"}, imported.Features)
+	assert.Equal(t, "file:flows.csv", imported.Source)
+	v, err := s.Create("flows", "", imported)
+	require.NoError(t, err)
+	assert.Equal(t, 2, v.Samples)
+	assert.Equal(t, map[string]int{"import": 2}, v.LabelSources)
+
+	_, err = s.ImportFile("missing.csv")
+	assert.ErrorIs(t, err, ErrDatasetNotFound)
+	_, err = s.ImportFile("../datasets.json")
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestStoreErrors(t *testing.T) {
+	s, err := Open(t.TempDir())
+	require.NoError(t, err)
+	_, err = s.Create("flows", "", samples(3, ""))
+	require.NoError(t, err)
+
+	_, err = s.Create("flows", "", samples(3, ""))
+	assert.ErrorIs(t, err, ErrDatasetExists)
+	_, err = s.Create("Flows!", "", samples(3, ""))
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = s.Create("empty", "", Samples{Features: []string{"x"}})
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = s.Append("missing", samples(3, ""))
+	assert.ErrorIs(t, err, ErrDatasetNotFound)
+
+	other := samples(3, "")
+	other.Features = []string{"x", "y"}
+	_, err = s.Append("flows", other)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	for _, tag := range []string{"latest", "v2", "3", "Golden"} {
+		assert.ErrorIs(t, s.Tag("flows", tag, 1), ErrInvalid, tag)
+	}
+	assert.ErrorIs(t, s.Tag("flows", "golden", 2), ErrVersionNotFound)
+
+	for _, ref := range []string{"flows@v2", "flows@0", "flows@golden"} {
+		_, err = s.Resolve(ref)
+		assert.ErrorIs(t, err, ErrVersionNotFound, ref)
+	}
+	_, err = s.Resolve("missing")
+	assert.ErrorIs(t, err, ErrDatasetNotFound)
+
+	// Failed operations leave no trace
+	assert.Len(t, s.List(), 1)
+}
@@ -0,0 +1,85 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/auth"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/grpcapi/apiv1"
+)
+
+// methodRoles are the roles the API's methods require. Methods not listed,
+// such as mutations, require the admin role.
+var methodRoles = map[string]string{
+	apiv1.ArgusCortex_Analyze_FullMethodName:          auth.RoleRead,
+	apiv1.ArgusCortex_StreamDetections_FullMethodName: auth.RoleRead,
+	apiv1.ArgusCortex_GetStatistics_FullMethodName:    auth.RoleRead,
+	apiv1.ArgusCortex_ListFlows_FullMethodName:        auth.RoleRead,
+}
+
+// authUnary rejects unary calls their bearer token does not authorize
+func (s *Server) authUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authStream rejects streaming calls their bearer token does not authorize
+func (s *Server) authStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorize(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// authorize checks the bearer token of a call the way the REST API does:
+// the configured API token is accepted for every method, OIDC tokens need
+// the method's role or admin. Without OIDC, read methods are open and admin
+// methods are disabled until an api_token is configured.
+func (s *Server) authorize(ctx context.Context, method string) error {
+	role, ok := methodRoles[method]
+	if !ok {
+		role = auth.RoleAdmin
+	}
+	if role == auth.RoleRead && s.verifier == nil {
+		return nil
+	}
+	if s.config.APIToken == "" && s.verifier == nil {
+		return status.Error(codes.PermissionDenied, "method disabled: no API token configured")
+	}
+
+	token, ok := bearerToken(ctx)
+	if ok && s.config.APIToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.APIToken)) == 1 {
+		return nil
+	}
+	if ok && s.verifier != nil {
+		claims, err := s.verifier.Verify(ctx, token)
+		if err == nil {
+			if !claims.HasRole(role) && !claims.HasRole(auth.RoleAdmin) {
+				return status.Errorf(codes.PermissionDenied, "%s role required", role)
+			}
+			return nil
+		}
+		slog.Debug("Rejected bearer token", "method", method, "error", err)
+	}
+	return status.Error(codes.Unauthenticated, "unauthenticated")
+}
+
+// bearerToken returns the bearer token of the call's authorization metadata
+func bearerToken(ctx context.Context) (string, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(value, "Bearer "); ok {
+			return token, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,114 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/grpcapi/apiv1"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+// newTestIssuer serves OIDC discovery and the JWKS of key, returning the
+// issuer URL
+func newTestIssuer(t *testing.T, key *ecdsa.PrivateKey) string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	var issuer *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": issuer.URL, "jwks_uri": issuer.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "EC", "kid": "test", "crv": "P-256",
+			"x": b64(key.X.FillBytes(make([]byte, 32))), "y": b64(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	})
+	issuer = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer.URL
+}
+
+// signToken creates an ES256 token of issuer granting roles
+func signToken(t *testing.T, key *ecdsa.PrivateKey, issuer string, roles ...string) string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "kid": "test", "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(map[string]interface{}{
+		"iss": issuer, "sub": "client", "exp": time.Now().Add(time.Hour).Unix(), "roles": roles,
+	})
+	require.NoError(t, err)
+
+	input := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+	return input + "." + b64(append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...))
+}
+
+// withToken attaches a bearer token to outgoing calls
+func withToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+func TestAuthorization(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	issuer := newTestIssuer(t, key)
+	client, server := newTestClient(t, config.ServerConfig{
+		APIToken: "secret",
+		OIDC:     config.OIDCConfig{Issuer: issuer, JWKSCacheTTL: 3600, RolesClaim: "roles"},
+	})
+	ctx := context.Background()
+	listFlows := func(ctx context.Context) codes.Code {
+		_, err := client.ListFlows(ctx, &apiv1.ListFlowsRequest{})
+		return status.Code(err)
+	}
+
+	// With OIDC, queries need the API token or a token granting read
+	assert.Equal(t, codes.Unauthenticated, listFlows(ctx))
+	assert.Equal(t, codes.Unauthenticated, listFlows(withToken(ctx, "not-a-token")))
+	assert.Equal(t, codes.PermissionDenied, listFlows(withToken(ctx, signToken(t, key, issuer))))
+	assert.Equal(t, codes.OK, listFlows(withToken(ctx, "secret")))
+	assert.Equal(t, codes.OK, listFlows(withToken(ctx, signToken(t, key, issuer, "read"))))
+	assert.Equal(t, codes.OK, listFlows(withToken(ctx, signToken(t, key, issuer, "admin"))))
+
+	// Streams are checked before they open
+	stream, err := client.StreamDetections(ctx, &apiv1.StreamDetectionsRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Methods without a listed role are mutations and need admin
+	mutation := "/argus.api.v1.ArgusCortex/Mutate"
+	incoming := func(token string) context.Context {
+		return metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+	}
+	assert.Equal(t, codes.Unauthenticated, status.Code(server.authorize(ctx, mutation)))
+	assert.Equal(t, codes.PermissionDenied, status.Code(server.authorize(incoming(signToken(t, key, issuer, "read")), mutation)))
+	assert.NoError(t, server.authorize(incoming(signToken(t, key, issuer, "admin")), mutation))
+	assert.NoError(t, server.authorize(incoming("secret"), mutation))
+
+	// Without OIDC, queries are open and mutations need the API token, or
+	// are disabled without one
+	server = NewServer(config.ServerConfig{APIToken: "secret"}, nil, nil)
+	assert.NoError(t, server.authorize(ctx, apiv1.ArgusCortex_ListFlows_FullMethodName))
+	assert.Equal(t, codes.Unauthenticated, status.Code(server.authorize(ctx, mutation)))
+	assert.NoError(t, server.authorize(incoming("secret"), mutation))
+	server = NewServer(config.ServerConfig{}, nil, nil)
+	assert.Equal(t, codes.PermissionDenied, status.Code(server.authorize(incoming("secret"), mutation)))
+}
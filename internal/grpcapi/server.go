@@ -0,0 +1,356 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/auth"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/grpcapi/apiv1"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/argus"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events/eventsv1"
+)
+
+const (
+	// maxMessageSize bounds a single request message
+	maxMessageSize = 4 * 1024 * 1024
+	// callTimeout bounds unary calls whose client set no deadline
+	callTimeout = 30 * time.Second
+	// idleTimeout closes connections without calls for this long
+	idleTimeout = 5 * time.Minute
+)
+
+// Server serves the gRPC API of proto/api.proto, in plaintext or over TLS
+type Server struct {
+	apiv1.UnimplementedArgusCortexServer
+
+	config       config.ServerConfig
+	cortexEngine *cortex.Engine
+	argusEngine  *argus.Engine
+	verifier     *auth.Verifier // nil without OIDC
+	server       *grpc.Server
+
+	// closed by Shutdown to end detection streams, which never go idle
+	stopping chan struct{}
+	stopOnce sync.Once
+}
+
+// NewServer creates a new gRPC API server
+func NewServer(cfg config.ServerConfig, cortexEngine *cortex.Engine, argusEngine *argus.Engine) *Server {
+	server := &Server{
+		config:       cfg,
+		cortexEngine: cortexEngine,
+		argusEngine:  argusEngine,
+		stopping:     make(chan struct{}),
+	}
+	if cfg.OIDC.Issuer != "" {
+		server.verifier = auth.NewVerifier(cfg.OIDC)
+	}
+	return server
+}
+
+// Start starts the gRPC server, with TLS when a certificate is configured
+func (s *Server) Start() error {
+	options, err := s.serverOptions()
+	if err != nil {
+		return err
+	}
+	s.server = s.newGRPCServer(options...)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.config.GRPCPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen for gRPC: %w", err)
+	}
+	slog.Info("Starting gRPC server", "port", s.config.GRPCPort, "tls", s.config.GRPCTLSCert != "",
+		"client_certs", s.config.ClientCA != "")
+	return s.server.Serve(listener)
+}
+
+// serverOptions returns the transport credentials of the configuration
+func (s *Server) serverOptions() ([]grpc.ServerOption, error) {
+	if s.config.GRPCTLSCert == "" {
+		if s.config.ClientCA != "" {
+			return nil, fmt.Errorf("client_ca requires grpc_tls_cert and grpc_tls_key for the gRPC server")
+		}
+		return nil, nil
+	}
+
+	// Every call is sensitive, so client certificates are checked during
+	// the handshake
+	tlsConfig, err := auth.ServerTLSConfig(s.config.ClientCA, true)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(s.config.GRPCTLSCert, s.config.GRPCTLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load gRPC TLS certificate: %w", err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsConfig))}, nil
+}
+
+// newGRPCServer creates the gRPC server serving the API, bounding
+// messages and unary calls, authorizing calls and logging failed ones
+func (s *Server) newGRPCServer(options ...grpc.ServerOption) *grpc.Server {
+	options = append(options,
+		grpc.MaxRecvMsgSize(maxMessageSize),
+		grpc.KeepaliveParams(keepalive.ServerParameters{MaxConnectionIdle: idleTimeout}),
+		grpc.ChainUnaryInterceptor(recoverUnary, logUnary, deadlineUnary, s.authUnary),
+		grpc.ChainStreamInterceptor(recoverStream, logStream, s.authStream),
+	)
+	server := grpc.NewServer(options...)
+	apiv1.RegisterArgusCortexServer(server, s)
+	return server
+}
+
+// Shutdown gracefully shuts down the server. Detection streams end with
+// an OK status so clients can reconnect elsewhere. Calls still running
+// when ctx is done are cancelled.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stopping) })
+	if s.server == nil {
+		return nil
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
+
+// deadlineUnary bounds unary calls whose client set no deadline
+func deadlineUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, callTimeout)
+		defer cancel()
+	}
+	return handler(ctx, req)
+}
+
+// logUnary logs failed unary calls
+func logUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logCall(info.FullMethod, start, err)
+	return resp, err
+}
+
+// logStream logs failed streaming calls
+func logStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, stream)
+	logCall(info.FullMethod, start, err)
+	return err
+}
+
+// logCall logs a call that failed
+func logCall(method string, start time.Time, err error) {
+	if err != nil {
+		slog.Debug("gRPC call failed", "method", method, "code", status.Code(err).String(),
+			"duration", time.Since(start), "error", err)
+	}
+}
+
+// recoverUnary turns a panicking unary call into an Internal status
+func recoverUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer recoverCall(info.FullMethod, &err)
+	return handler(ctx, req)
+}
+
+// recoverStream turns a panicking streaming call into an Internal status
+func recoverStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer recoverCall(info.FullMethod, &err)
+	return handler(srv, stream)
+}
+
+// recoverCall recovers from a panic of a call, failing it instead
+func recoverCall(method string, err *error) {
+	if r := recover(); r != nil {
+		slog.Error("gRPC call panicked", "method", method, "panic", r, "stack", string(debug.Stack()))
+		*err = status.Error(codes.Internal, "internal error")
+	}
+}
+
+// Analyze implements apiv1.ArgusCortexServer
+func (s *Server) Analyze(ctx context.Context, req *apiv1.AnalyzeRequest) (*eventsv1.Detection, error) {
+	if len(req.GetFeatures()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "features are required")
+	}
+	flowID := req.GetFlowId()
+	if flowID == "" {
+		flowID = fmt.Sprintf("manual_%d", time.Now().Unix())
+	}
+
+	result, err := s.cortexEngine.Analyze(ctx, req.GetFeatures(), flowID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "analysis failed: %v", err)
+	}
+	return result.Event().Proto(), nil
+}
+
+// StreamDetections implements apiv1.ArgusCortexServer, sending detection
+// results until the client goes away
+func (s *Server) StreamDetections(req *apiv1.StreamDetectionsRequest, stream apiv1.ArgusCortex_StreamDetectionsServer) error {
+	missed, detections, cancel := s.argusEngine.SubscribeDetections(req.GetResumeAfter())
+	defer cancel()
+
+	// Send the headers now so the client sees the stream open before the
+	// first detection
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	for _, event := range missed {
+		if err := stream.Send(detectionEvent(event)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.stopping:
+			return nil
+		case event, ok := <-detections:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(detectionEvent(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// detectionEvent converts a detection feed event to its message
+func detectionEvent(event argus.DetectionEvent) *apiv1.DetectionEvent {
+	return &apiv1.DetectionEvent{EventId: event.ID, Detection: event.Result.Event().Proto()}
+}
+
+// GetStatistics implements apiv1.ArgusCortexServer, combining capture and
+// inference counters
+func (s *Server) GetStatistics(context.Context, *apiv1.GetStatisticsRequest) (*apiv1.Statistics, error) {
+	argusStats := s.argusEngine.GetStatistics()
+	cortexStats := s.cortexEngine.GetStatistics()
+
+	return &apiv1.Statistics{
+		TotalPackets:          uint64(argusStats.TotalPackets),
+		ActiveFlows:           uint64(argusStats.ActiveFlows),
+		AnalyzedFlows:         uint64(argusStats.AnalyzedFlows),
+		ExportedFlows:         uint64(argusStats.ExportedFlows),
+		EvictedFlows:          uint64(argusStats.EvictedFlows),
+		LastPacketUnixNano:    unixNano(argusStats.LastPacket),
+		TotalInferences:       uint64(cortexStats.TotalInferences),
+		BotDetections:         uint64(cortexStats.BotDetections),
+		HumanDetections:       uint64(cortexStats.HumanDetections),
+		AverageConfidence:     cortexStats.AverageConfidence,
+		LastInferenceUnixNano: unixNano(cortexStats.LastInference),
+	}, nil
+}
+
+// ListFlows implements apiv1.ArgusCortexServer, returning one page of
+// tracked flows
+func (s *Server) ListFlows(_ context.Context, req *apiv1.ListFlowsRequest) (*apiv1.ListFlowsResponse, error) {
+	query := argus.FlowQuery{
+		Protocol:   req.GetProtocol(),
+		MinPackets: req.GetMinPackets(),
+		IsBot:      req.IsBot,
+		Since:      fromUnixNano(req.GetSinceUnixNano()),
+		Until:      fromUnixNano(req.GetUntilUnixNano()),
+		Cursor:     req.GetCursor(),
+		Limit:      int(req.GetLimit()),
+	}
+
+	var err error
+	if v := req.GetSrcIp(); v != "" {
+		if query.SrcIP, err = argus.ParseIPFilter(v); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid src_ip: %q", v)
+		}
+	}
+	if v := req.GetDstIp(); v != "" {
+		if query.DstIP, err = argus.ParseIPFilter(v); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid dst_ip: %q", v)
+		}
+	}
+
+	page, err := s.argusEngine.Flows(query)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	resp := &apiv1.ListFlowsResponse{NextCursor: page.NextCursor}
+	for _, summary := range page.Flows {
+		resp.Flows = append(resp.Flows, flowFromSummary(summary))
+	}
+	return resp, nil
+}
+
+// flowFromSummary converts a flow listing entry to its message
+func flowFromSummary(summary argus.FlowSummary) *apiv1.Flow {
+	record := &events.FlowRecord{
+		ID:        summary.ID,
+		SrcIP:     net.ParseIP(summary.SrcIP),
+		DstIP:     net.ParseIP(summary.DstIP),
+		SrcPort:   summary.SrcPort,
+		DstPort:   summary.DstPort,
+		Protocol:  summary.Protocol,
+		Packets:   summary.Packets,
+		Bytes:     summary.Bytes,
+		StartTime: summary.StartTime,
+		LastSeen:  summary.LastSeen,
+	}
+	flow := &apiv1.Flow{Record: record.Proto()}
+	for _, vlan := range summary.VLANs {
+		flow.Vlans = append(flow.Vlans, uint32(vlan))
+	}
+	if v := summary.Verdict; v != nil {
+		detection := &events.Detection{
+			FlowID:     summary.ID,
+			IsBot:      v.IsBot,
+			Confidence: v.Confidence,
+			ModelUsed:  v.ModelUsed,
+			Timestamp:  v.AnalyzedAt,
+		}
+		flow.Detection = detection.Proto()
+	}
+	return flow
+}
+
+// unixNano encodes a time as Unix nanoseconds, the zero time as 0
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano decodes a time encoded by unixNano
+func fromUnixNano(v int64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, v)
+}
@@ -0,0 +1,129 @@
+package grpcapi
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/grpcapi/apiv1"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/argus"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+// newTestClient serves the API over an in-memory connection and returns
+// a client of it
+func newTestClient(t *testing.T, cfg config.ServerConfig) (apiv1.ArgusCortexClient, *Server) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.8})
+	require.NoError(t, err)
+	t.Cleanup(func() { cortexEngine.Close() })
+
+	argusEngine, err := argus.NewEngine(config.CaptureConfig{Simulation: true}, cortexEngine)
+	require.NoError(t, err)
+	t.Cleanup(func() { argusEngine.Close() })
+
+	server := NewServer(cfg, cortexEngine, argusEngine)
+	server.server = server.newGRPCServer()
+	listener := bufconn.Listen(1 << 20)
+	go server.server.Serve(listener)
+	t.Cleanup(server.server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return apiv1.NewArgusCortexClient(conn), server
+}
+
+func TestServer(t *testing.T) {
+	client, _ := newTestClient(t, config.ServerConfig{})
+	ctx := context.Background()
+
+	features := make([]float64, 128)
+	features[0] = 0.9
+	detection, err := client.Analyze(ctx, &apiv1.AnalyzeRequest{FlowId: "flow-1", Features: features})
+	require.NoError(t, err)
+	assert.Equal(t, "flow-1", detection.GetFlowId())
+	assert.Len(t, detection.GetFeatures(), 128)
+
+	_, err = client.Analyze(ctx, &apiv1.AnalyzeRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	stats, err := client.GetStatistics(ctx, &apiv1.GetStatisticsRequest{})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, stats.GetTotalInferences(), uint64(1))
+
+	_, err = client.ListFlows(ctx, &apiv1.ListFlowsRequest{Limit: 10})
+	require.NoError(t, err)
+
+	_, err = client.ListFlows(ctx, &apiv1.ListFlowsRequest{Cursor: "!"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.ListFlows(ctx, &apiv1.ListFlowsRequest{SrcIp: "not-an-ip"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// The stream stays open until the client cancels it
+	streamCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	stream, err := client.StreamDetections(streamCtx, &apiv1.StreamDetectionsRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestServerShutdown(t *testing.T) {
+	client, server := newTestClient(t, config.ServerConfig{})
+	ctx := context.Background()
+
+	stream, err := client.StreamDetections(ctx, &apiv1.StreamDetectionsRequest{})
+	require.NoError(t, err)
+	_, err = stream.Header()
+	require.NoError(t, err)
+
+	// Streams end cleanly so clients can reconnect elsewhere
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, server.Shutdown(shutdownCtx))
+	_, err = stream.Recv()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestInterceptors(t *testing.T) {
+	ctx := context.Background()
+	info := &grpc.UnaryServerInfo{FullMethod: "/argus.api.v1.ArgusCortex/Analyze"}
+
+	// Calls without a deadline get one
+	_, err := deadlineUnary(ctx, nil, info, func(ctx context.Context, _ any) (any, error) {
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(callTimeout), deadline, time.Second)
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	// Panics fail the call rather than the process
+	_, err = recoverUnary(ctx, nil, info, func(context.Context, any) (any, error) {
+		panic("boom")
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestServerOptions(t *testing.T) {
+	server := NewServer(config.ServerConfig{ClientCA: "ca.pem"}, nil, nil)
+	_, err := server.serverOptions()
+	assert.ErrorContains(t, err, "client_ca requires grpc_tls_cert")
+
+	server = NewServer(config.ServerConfig{GRPCTLSCert: "missing.pem", GRPCTLSKey: "missing.key"}, nil, nil)
+	_, err = server.serverOptions()
+	assert.ErrorContains(t, err, "failed to load gRPC TLS certificate")
+}
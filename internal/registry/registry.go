@@ -0,0 +1,203 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+)
+
+// FileName is the name of the registry file in the model directory
+const FileName = "registry.json"
+
+// idLength is the length of the hash prefix used as version ID
+const idLength = 12
+
+// ErrVersionNotFound is returned for version IDs that are not registered
+var ErrVersionNotFound = errors.New("model version not found")
+
+// Version describes a model version. Versions are identified by the hash of
+// their parameters, so the same parameters loaded from different files are
+// one version.
+type Version struct {
+	ID            string             `json:"id"`
+	Hash          string             `json:"hash"`
+	ModelType     string             `json:"model_type"`
+	FeatureSize   int                `json:"feature_size"`
+	FeatureSchema string             `json:"feature_schema,omitempty"` // fingerprint of the feature layout
+	Source        string             `json:"source"`                   // how the version first became known, e.g. "trained" or "file:v2"
+	TrainingData  ml.TrainingData    `json:"training_data"`
+	Metrics       map[string]float64 `json:"metrics,omitempty"`
+	CreatedAt     time.Time          `json:"created_at"`
+	RegisteredAt  time.Time          `json:"registered_at"`
+	ActivatedAt   time.Time          `json:"activated_at"` // last activation
+}
+
+// Registry records the model versions an engine has used. Versions are
+// kept in a JSON file so that the version IDs of stored detections can be
+// resolved after a restart.
+type Registry struct {
+	path string // empty for an in-memory registry
+
+	mu       sync.RWMutex
+	versions map[string]*Version // by ID
+}
+
+// Open opens the registry stored at path, creating it on first use. An
+// empty path keeps the registry in memory.
+func Open(path string) (*Registry, error) {
+	r := &Registry{path: path, versions: make(map[string]*Version)}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model registry: %w", err)
+	}
+
+	var versions []*Version
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, fmt.Errorf("failed to decode model registry %s: %w", path, err)
+	}
+	for _, v := range versions {
+		r.versions[v.ID] = v
+	}
+	return r, nil
+}
+
+// Activate registers the version of snapshot if it is new and records its
+// activation. source describes where new versions come from.
+func (r *Registry) Activate(snapshot *ml.ModelSnapshot, source string) (*Version, error) {
+	hash := snapshot.Hash()
+	id := VersionID(snapshot)
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v, ok := r.versions[id]
+	if !ok {
+		v = &Version{
+			ID:           id,
+			Hash:         hash,
+			ModelType:    snapshot.ModelType,
+			FeatureSize:  snapshot.FeatureSize,
+			Source:       source,
+			TrainingData: snapshot.TrainingData,
+			Metrics:      maps.Clone(snapshot.Metrics),
+			CreatedAt:    snapshot.CreatedAt,
+			RegisteredAt: now,
+		}
+		if snapshot.FeatureSchema != nil {
+			v.FeatureSchema = snapshot.FeatureSchema.Fingerprint()
+		}
+	}
+	previous := v.ActivatedAt
+	v.ActivatedAt = now
+	r.versions[id] = v
+
+	if err := r.save(); err != nil {
+		v.ActivatedAt = previous
+		if !ok {
+			delete(r.versions, id)
+		}
+		return nil, err
+	}
+
+	if !ok {
+		slog.Info("Model version registered", "version", id, "model_type", v.ModelType, "source", source)
+	}
+	copied := *v
+	return &copied, nil
+}
+
+// VersionID returns the version ID of a snapshot
+func VersionID(snapshot *ml.ModelSnapshot) string {
+	return snapshot.Hash()[:idLength]
+}
+
+// List returns all versions, oldest first
+func (r *Registry) List() []Version {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	versions := make([]Version, 0, len(r.versions))
+	for _, v := range r.versions {
+		versions = append(versions, *v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if !versions[i].RegisteredAt.Equal(versions[j].RegisteredAt) {
+			return versions[i].RegisteredAt.Before(versions[j].RegisteredAt)
+		}
+		return versions[i].ID < versions[j].ID
+	})
+	return versions
+}
+
+// Get returns the version with the given ID or full hash
+func (r *Registry) Get(id string) (*Version, error) {
+	if len(id) > idLength {
+		id = id[:idLength]
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	v, ok := r.versions[strings.ToLower(id)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, id)
+	}
+	copied := *v
+	return &copied, nil
+}
+
+// save writes the registry file, replacing it atomically
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	versions := make([]*Version, 0, len(r.versions))
+	for _, v := range r.versions {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].ID < versions[j].ID })
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode model registry: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("failed to write model registry: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), ".registry-*")
+	if err != nil {
+		return fmt.Errorf("failed to write model registry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write model registry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write model registry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		return fmt.Errorf("failed to write model registry: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func snapshot(bias float64) *ml.ModelSnapshot {
+	return &ml.ModelSnapshot{
+		ModelType:    "svm",
+		FeatureSize:  2,
+		CreatedAt:    time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		TrainingData: ml.TrainingData{Source: "synthetic", Samples: 100, Digest: "abc"},
+		Metrics:      map[string]float64{"training_accuracy": 0.9},
+		SVMTrained:   true,
+		SVMWeights:   []float64{0.5, -0.5},
+		SVMBias:      bias,
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models", FileName)
+	r, err := Open(path)
+	require.NoError(t, err)
+
+	v1, err := r.Activate(snapshot(0), "startup")
+	require.NoError(t, err)
+	assert.Len(t, v1.ID, 12)
+	assert.Equal(t, v1.Hash[:12], v1.ID)
+	assert.Equal(t, "startup", v1.Source)
+	assert.Equal(t, 100, v1.TrainingData.Samples)
+	assert.Equal(t, 0.9, v1.Metrics["training_accuracy"])
+
+	v2, err := r.Activate(snapshot(1), "file:v2")
+	require.NoError(t, err)
+	assert.NotEqual(t, v1.ID, v2.ID)
+
+	// The same parameters from another source are the same version
+	again, err := r.Activate(snapshot(0), "rollback")
+	require.NoError(t, err)
+	assert.Equal(t, v1.ID, again.ID)
+	assert.Equal(t, "startup", again.Source)
+	assert.True(t, again.ActivatedAt.After(v1.ActivatedAt) || again.ActivatedAt.Equal(v1.ActivatedAt))
+
+	// Versions survive reopening
+	r, err = Open(path)
+	require.NoError(t, err)
+	versions := r.List()
+	require.Len(t, versions, 2)
+	assert.Equal(t, v1.ID, versions[0].ID)
+	assert.Equal(t, v2.ID, versions[1].ID)
+
+	got, err := r.Get(v2.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, "file:v2", got.Source)
+	_, err = r.Get("000000000000")
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+}
+
+func TestRegistryErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+	_, err := Open(path)
+	assert.ErrorContains(t, err, "failed to decode model registry")
+
+	// In-memory registries are not persisted
+	r, err := Open("")
+	require.NoError(t, err)
+	_, err = r.Activate(snapshot(0), "startup")
+	require.NoError(t, err)
+	assert.Len(t, r.List(), 1)
+}
@@ -0,0 +1,133 @@
+package reload
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/argus"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+// hotSettings are the settings applied by a reload. Changes to any other
+// setting only take effect after a restart.
+var hotSettings = map[string]bool{
+	"cortex.detection_threshold":       true,
+	"capture.flow_idle_timeout":        true,
+	"capture.flow_active_timeout":      true,
+	"capture.min_packets_for_analysis": true,
+	"logging.level":                    true,
+}
+
+// Result reports the changed settings of a reload
+type Result struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+// Reloader applies changes of the configuration file to running engines
+type Reloader struct {
+	path         string
+	cortexEngine *cortex.Engine
+	argusEngine  *argus.Engine
+	logLevel     *slog.LevelVar
+
+	mu      sync.Mutex
+	current config.Config // the effective configuration
+}
+
+// New creates a reloader for the configuration loaded from path. logLevel
+// is the level of the default logger's handler.
+func New(path string, cfg *config.Config, cortexEngine *cortex.Engine, argusEngine *argus.Engine, logLevel *slog.LevelVar) *Reloader {
+	return &Reloader{
+		path:         path,
+		cortexEngine: cortexEngine,
+		argusEngine:  argusEngine,
+		logLevel:     logLevel,
+		current:      *cfg,
+	}
+}
+
+// Reload re-reads the configuration file and applies the changed settings
+// that can be hot-applied. Nothing is applied when the file is invalid.
+// Settings that need a restart keep reporting as changed until then.
+func (r *Reloader) Reload() (*Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := config.Load(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Logging.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level: %w", err)
+	}
+	if cfg.Cortex.DetectionThreshold < 0 || cfg.Cortex.DetectionThreshold > 1 {
+		return nil, fmt.Errorf("detection threshold must be between 0 and 1")
+	}
+
+	result := &Result{Applied: []string{}, RestartRequired: []string{}}
+	var thresholdChanged, flowSettingsChanged bool
+	for _, key := range config.Diff(&r.current, cfg) {
+		if !hotSettings[key] {
+			result.RestartRequired = append(result.RestartRequired, key)
+			continue
+		}
+		result.Applied = append(result.Applied, key)
+
+		switch key {
+		case "cortex.detection_threshold":
+			thresholdChanged = true
+		case "logging.level":
+			r.logLevel.Set(level)
+			r.current.Logging.Level = cfg.Logging.Level
+		default:
+			flowSettingsChanged = true
+		}
+	}
+
+	if thresholdChanged {
+		if err := r.cortexEngine.SetDetectionThreshold(cfg.Cortex.DetectionThreshold); err != nil {
+			return nil, err
+		}
+		r.current.Cortex.DetectionThreshold = cfg.Cortex.DetectionThreshold
+	}
+	if flowSettingsChanged {
+		r.argusEngine.SetFlowSettings(cfg.Capture.FlowIdleTimeout, cfg.Capture.FlowActiveTimeout, cfg.Capture.MinPacketsForAnalysis)
+		r.current.Capture.FlowIdleTimeout = cfg.Capture.FlowIdleTimeout
+		r.current.Capture.FlowActiveTimeout = cfg.Capture.FlowActiveTimeout
+		r.current.Capture.MinPacketsForAnalysis = cfg.Capture.MinPacketsForAnalysis
+	}
+
+	slog.Info("Configuration reloaded",
+		"path", r.path,
+		"applied", result.Applied,
+		"restart_required", result.RestartRequired)
+
+	return result, nil
+}
+
+// HandleSignals reloads the configuration on SIGHUP until ctx is done
+func (r *Reloader) HandleSignals(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if _, err := r.Reload(); err != nil {
+				slog.Error("Failed to reload configuration", "path", r.path, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package reload
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/argus"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const baseConfig = `
+server:
+  api_port: 8080
+capture:
+  simulation: true
+  flow_idle_timeout: 300
+cortex:
+  detection_threshold: 0.8
+logging:
+  level: info
+`
+
+const changedConfig = `
+server:
+  api_port: 9000
+capture:
+  simulation: true
+  flow_idle_timeout: 60
+cortex:
+  detection_threshold: 0.5
+logging:
+  level: debug
+`
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(path, []byte(baseConfig), 0o644))
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	cortexEngine, err := cortex.NewEngine(cfg.Cortex)
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+	argusEngine, err := argus.NewEngine(cfg.Capture, cortexEngine)
+	require.NoError(t, err)
+	defer argusEngine.Close()
+
+	var level slog.LevelVar
+	reloader := New(path, cfg, cortexEngine, argusEngine, &level)
+
+	result, err := reloader.Reload()
+	require.NoError(t, err)
+	assert.Empty(t, result.Applied)
+	assert.Empty(t, result.RestartRequired)
+
+	require.NoError(t, os.WriteFile(path, []byte(changedConfig), 0o644))
+	result, err = reloader.Reload()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"capture.flow_idle_timeout", "cortex.detection_threshold", "logging.level"}, result.Applied)
+	assert.Equal(t, []string{"server.api_port"}, result.RestartRequired)
+	assert.Equal(t, slog.LevelDebug, level.Level())
+
+	// Applied settings are now current, the port still needs a restart
+	result, err = reloader.Reload()
+	require.NoError(t, err)
+	assert.Empty(t, result.Applied)
+	assert.Equal(t, []string{"server.api_port"}, result.RestartRequired)
+
+	// Invalid files are rejected as a whole
+	require.NoError(t, os.WriteFile(path, []byte(strings.Replace(baseConfig, "level: info", "level: verbose", 1)), 0o644))
+	_, err = reloader.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, slog.LevelDebug, level.Level())
+}
@@ -0,0 +1,177 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	purgedRecords = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_retention_purged_records_total",
+			Help: "Total number of stored records purged by the retention janitor",
+		},
+		[]string{"rule"},
+	)
+	purgeErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_retention_errors_total",
+			Help: "Total number of failed retention janitor runs",
+		},
+		[]string{"rule"},
+	)
+	lastPurge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "argus_cortex_retention_last_purge_timestamp_seconds",
+			Help: "Time of the last successful purge",
+		},
+		[]string{"rule"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(purgedRecords, purgeErrors, lastPurge)
+}
+
+// Rule purges the records of one kind once they are older than MaxAge
+type Rule struct {
+	Name   string // metric label, e.g. bot_detections
+	MaxAge time.Duration
+	Purge  func(ctx context.Context, before time.Time) (int64, error)
+}
+
+// DetectionPurger deletes stored detections by verdict, as implemented by
+// store.Store
+type DetectionPurger interface {
+	PurgeDetections(ctx context.Context, isBot bool, before time.Time) (int64, error)
+}
+
+// ExportPurger deletes raw packet exports, as implemented by argus.Engine
+type ExportPurger interface {
+	PurgeExports(before time.Time) (int64, error)
+}
+
+// DetectionRules returns the rules purging stored bot and human detections
+func DetectionRules(cfg config.RetentionConfig, detections DetectionPurger) []Rule {
+	rule := func(name string, isBot bool, days int) Rule {
+		return Rule{
+			Name:   name,
+			MaxAge: time.Duration(days) * 24 * time.Hour,
+			Purge: func(ctx context.Context, before time.Time) (int64, error) {
+				return detections.PurgeDetections(ctx, isBot, before)
+			},
+		}
+	}
+	return []Rule{
+		rule("bot_detections", true, cfg.BotDetectionDays),
+		rule("human_detections", false, cfg.HumanDetectionDays),
+	}
+}
+
+// ExportRule returns the rule purging raw packet exports after the given
+// number of hours
+func ExportRule(hours int, exports ExportPurger) Rule {
+	return Rule{
+		Name:   "packet_exports",
+		MaxAge: time.Duration(hours) * time.Hour,
+		Purge: func(_ context.Context, before time.Time) (int64, error) {
+			return exports.PurgeExports(before)
+		},
+	}
+}
+
+// Janitor enforces retention rules in the background, once on start and
+// then at the configured interval. Rules without a maximum age are skipped.
+type Janitor struct {
+	interval time.Duration
+	rules    []Rule
+
+	ctx       context.Context // canceled by Close to abort a running purge
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewJanitor creates a janitor for the rules and starts enforcing them
+func NewJanitor(cfg config.RetentionConfig, rules ...Rule) (*Janitor, error) {
+	if cfg.Interval < 1 {
+		return nil, fmt.Errorf("retention interval must be positive")
+	}
+
+	var active []Rule
+	var names []string
+	for _, rule := range rules {
+		if rule.MaxAge > 0 {
+			active = append(active, rule)
+			names = append(names, rule.Name)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &Janitor{
+		interval: time.Duration(cfg.Interval) * time.Second,
+		rules:    active,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go j.run()
+
+	slog.Info("Retention janitor started", "rules", names, "interval", j.interval)
+	return j, nil
+}
+
+// Close stops the janitor, aborting a running purge
+func (j *Janitor) Close() error {
+	j.closeOnce.Do(j.cancel)
+	<-j.done
+	return nil
+}
+
+// run purges expired records until the janitor is closed
+func (j *Janitor) run() {
+	defer close(j.done)
+	if len(j.rules) == 0 {
+		return
+	}
+
+	j.purge(time.Now())
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			j.purge(now)
+		case <-j.ctx.Done():
+			return
+		}
+	}
+}
+
+// purge applies every rule relative to now
+func (j *Janitor) purge(now time.Time) {
+	for _, rule := range j.rules {
+		if j.ctx.Err() != nil {
+			return
+		}
+
+		purged, err := rule.Purge(j.ctx, now.Add(-rule.MaxAge))
+		if err != nil {
+			purgeErrors.WithLabelValues(rule.Name).Inc()
+			slog.Warn("Failed to purge expired records", "rule", rule.Name, "error", err)
+			continue
+		}
+		purgedRecords.WithLabelValues(rule.Name).Add(float64(purged))
+		lastPurge.WithLabelValues(rule.Name).SetToCurrentTime()
+		if purged > 0 {
+			slog.Info("Purged expired records", "rule", rule.Name, "records", purged, "max_age", rule.MaxAge)
+		}
+	}
+}
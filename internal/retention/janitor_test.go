@@ -0,0 +1,93 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// purge is one call to a fake purger
+type purge struct {
+	isBot  bool
+	before time.Time
+}
+
+type fakePurger struct {
+	mu     sync.Mutex
+	purges []purge
+	err    error
+}
+
+func (f *fakePurger) PurgeDetections(_ context.Context, isBot bool, before time.Time) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.purges = append(f.purges, purge{isBot: isBot, before: before})
+	return 3, f.err
+}
+
+func (f *fakePurger) PurgeExports(before time.Time) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.purges = append(f.purges, purge{before: before})
+	return 2, f.err
+}
+
+func (f *fakePurger) calls() []purge {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]purge(nil), f.purges...)
+}
+
+func TestJanitor(t *testing.T) {
+	detections := &fakePurger{}
+	exports := &fakePurger{}
+	cfg := config.RetentionConfig{BotDetectionDays: 90, HumanDetectionDays: 7, Interval: 3600}
+	purgedBots := testutil.ToFloat64(purgedRecords.WithLabelValues("bot_detections"))
+	purgedExports := testutil.ToFloat64(purgedRecords.WithLabelValues("packet_exports"))
+
+	start := time.Now()
+	j, err := NewJanitor(cfg, append(DetectionRules(cfg, detections), ExportRule(24, exports))...)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(exports.calls()) == 1 }, time.Second, 5*time.Millisecond,
+		"rules are enforced on start")
+	require.NoError(t, j.Close())
+
+	calls := detections.calls()
+	require.Len(t, calls, 2)
+	assert.True(t, calls[0].isBot)
+	assert.WithinDuration(t, start.Add(-90*24*time.Hour), calls[0].before, time.Second)
+	assert.False(t, calls[1].isBot)
+	assert.WithinDuration(t, start.Add(-7*24*time.Hour), calls[1].before, time.Second)
+	assert.WithinDuration(t, start.Add(-24*time.Hour), exports.calls()[0].before, time.Second)
+
+	assert.Equal(t, purgedBots+3, testutil.ToFloat64(purgedRecords.WithLabelValues("bot_detections")))
+	assert.Equal(t, purgedExports+2, testutil.ToFloat64(purgedRecords.WithLabelValues("packet_exports")))
+}
+
+func TestJanitorSkipsDisabledRules(t *testing.T) {
+	detections := &fakePurger{err: errors.New("database is locked")}
+	cfg := config.RetentionConfig{BotDetectionDays: 30, Interval: 3600}
+	failures := testutil.ToFloat64(purgeErrors.WithLabelValues("bot_detections"))
+
+	j, err := NewJanitor(cfg, DetectionRules(cfg, detections)...)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(detections.calls()) > 0 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, j.Close())
+
+	calls := detections.calls()
+	require.Len(t, calls, 1, "human detections are kept")
+	assert.True(t, calls[0].isBot)
+	assert.Equal(t, failures+1, testutil.ToFloat64(purgeErrors.WithLabelValues("bot_detections")))
+}
+
+func TestNewJanitorValidation(t *testing.T) {
+	_, err := NewJanitor(config.RetentionConfig{})
+	assert.Error(t, err)
+}
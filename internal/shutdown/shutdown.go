@@ -0,0 +1,87 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/api"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/grpcapi"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/retention"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/argus"
+)
+
+// Step is one stage of a shutdown
+type Step struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Run executes steps in order under a shared deadline. Every step runs even
+// after an earlier one failed or the deadline passed, so resources are
+// always released; the errors of all steps are joined.
+func Run(timeout time.Duration, steps ...Step) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	var errs []error
+	for _, step := range steps {
+		stepStart := time.Now()
+		if err := step.Run(ctx); err != nil {
+			slog.Error("Shutdown step failed", "step", step.Name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", step.Name, err))
+			continue
+		}
+		slog.Info("Shutdown step completed", "step", step.Name, "duration", time.Since(stepStart))
+	}
+
+	slog.Info("Shutdown complete", "duration", time.Since(start), "failed_steps", len(errs))
+	return errors.Join(errs...)
+}
+
+// Components are the parts of a running daemon. Nil components are skipped.
+type Components struct {
+	API     *api.Server
+	GRPC    *grpcapi.Server
+	Janitor *retention.Janitor
+	Argus   *argus.Engine
+	Cortex  *cortex.Engine
+}
+
+// Steps returns the shutdown sequence: stop accepting API requests and
+// finish in-flight ones, stop purging expired records, drain in-flight
+// analyses while flushing their detections to the sinks, close the capture
+// handle and sinks, then release the inference engine
+func (c Components) Steps() []Step {
+	var steps []Step
+	if c.API != nil {
+		steps = append(steps, Step{Name: "api", Run: c.API.Shutdown})
+	}
+	if c.GRPC != nil {
+		steps = append(steps, Step{Name: "grpc", Run: c.GRPC.Shutdown})
+	}
+	if c.Janitor != nil {
+		steps = append(steps, Step{Name: "retention", Run: func(context.Context) error { return c.Janitor.Close() }})
+	}
+	if c.Argus != nil {
+		steps = append(steps,
+			Step{Name: "drain", Run: c.Argus.Drain},
+			Step{Name: "capture", Run: func(context.Context) error { return c.Argus.Close() }},
+		)
+	}
+	if c.Cortex != nil {
+		steps = append(steps, Step{Name: "cortex", Run: func(context.Context) error { return c.Cortex.Close() }})
+	}
+	return steps
+}
+
+// Shutdown stops the components in order, giving up on waiting once
+// timeout has passed
+func Shutdown(c Components, timeout time.Duration) error {
+	slog.Info("Shutting down", "timeout", timeout)
+	return Run(timeout, c.Steps()...)
+}
@@ -0,0 +1,119 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/retention"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/argus"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	var order []string
+	step := func(name string, err error) Step {
+		return Step{Name: name, Run: func(ctx context.Context) error {
+			order = append(order, name)
+			return err
+		}}
+	}
+
+	err := Run(time.Second, step("a", nil), step("b", errors.New("boom")), step("c", nil))
+	assert.Equal(t, []string{"a", "b", "c"}, order)
+	assert.EqualError(t, err, "b: boom")
+
+	// Steps after the deadline still run, with an expired context
+	var expired bool
+	err = Run(10*time.Millisecond,
+		Step{Name: "slow", Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		Step{Name: "close", Run: func(ctx context.Context) error {
+			expired = ctx.Err() != nil
+			return nil
+		}},
+	)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, expired)
+}
+
+func TestShutdown(t *testing.T) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.8})
+	require.NoError(t, err)
+
+	argusEngine, err := argus.NewEngine(config.CaptureConfig{Simulation: true, MinPacketsForAnalysis: 1000}, cortexEngine)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, argusEngine.Start(ctx))
+
+	// Let the simulation build flows that are too small to be analyzed yet
+	require.Eventually(t, func() bool { return argusEngine.GetStatistics().ActiveFlows > 0 }, 2*time.Second, 10*time.Millisecond)
+
+	janitor, err := retention.NewJanitor(config.RetentionConfig{Interval: 3600})
+	require.NoError(t, err)
+
+	// The janitor stops before the sinks it purges are closed
+	components := Components{Janitor: janitor, Argus: argusEngine, Cortex: cortexEngine}
+	var names []string
+	for _, step := range components.Steps() {
+		names = append(names, step.Name)
+	}
+	assert.Equal(t, []string{"retention", "drain", "capture", "cortex"}, names)
+	require.NoError(t, Shutdown(components, 5*time.Second))
+
+	// Draining gave every flow a final analysis
+	stats := argusEngine.GetStatistics()
+	assert.Equal(t, stats.ActiveFlows, stats.AnalyzedFlows)
+	assert.Error(t, argusEngine.CaptureHealth())
+}
+
+// blockingSink holds every detection written to it until released
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (s *blockingSink) Name() string { return "blocking" }
+
+func (s *blockingSink) WriteDetection(*cortex.DetectionResult) { <-s.release }
+
+func (s *blockingSink) WriteFlow(*events.FlowRecord) {}
+
+func (s *blockingSink) Health() error { return nil }
+
+func (s *blockingSink) Close() error { return nil }
+
+func TestShutdownDeadline(t *testing.T) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.8})
+	require.NoError(t, err)
+
+	argusEngine, err := argus.NewEngine(config.CaptureConfig{Simulation: true, MinPacketsForAnalysis: 1000}, cortexEngine)
+	require.NoError(t, err)
+	sink := &blockingSink{release: make(chan struct{})}
+	argusEngine.AddSink(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, argusEngine.Start(ctx))
+	require.Eventually(t, func() bool { return argusEngine.GetStatistics().ActiveFlows > 0 }, 2*time.Second, 10*time.Millisecond)
+
+	// The final analyses hang in the sink, so draining gives up at the
+	// deadline
+	components := Components{Argus: argusEngine, Cortex: cortexEngine}
+	start := time.Now()
+	err = Run(100*time.Millisecond, components.Steps()[0])
+	assert.Less(t, time.Since(start), time.Second)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "drain: timed out draining analyses")
+
+	close(sink.release)
+	require.NoError(t, Shutdown(components, 5*time.Second))
+}
@@ -0,0 +1,286 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	livePrecision = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "argus_cortex_live_precision",
+			Help: "Share of predicted bots confirmed as bots by analyst feedback, over predictions made within the window",
+		},
+		[]string{"window"},
+	)
+	liveRecall = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "argus_cortex_live_recall",
+			Help: "Share of bots confirmed by analyst feedback that were predicted bots, over predictions made within the window",
+		},
+		[]string{"window"},
+	)
+	liveAccuracy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "argus_cortex_live_accuracy",
+			Help: "Share of predictions confirmed by analyst feedback, over predictions made within the window",
+		},
+		[]string{"window"},
+	)
+	liveFeedback = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "argus_cortex_live_feedback",
+			Help: "Number of predictions made within the window that analysts gave feedback on",
+		},
+		[]string{"window"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(livePrecision, liveRecall, liveAccuracy, liveFeedback)
+}
+
+// ErrDetectionNotFound is returned for feedback on detections that are not
+// in the store
+var ErrDetectionNotFound = errors.New("detection not found")
+
+// feedbackSchema creates the feedback table. Feedback keeps a copy of the
+// prediction so that error rates survive the retention of detections.
+const feedbackSchema = `CREATE TABLE IF NOT EXISTS feedback (
+	detection_id BIGINT PRIMARY KEY,
+	created_at_ms BIGINT NOT NULL,
+	flow_id TEXT NOT NULL,
+	predicted_bot BOOLEAN NOT NULL,
+	confidence DOUBLE PRECISION NOT NULL,
+	model_version TEXT NOT NULL,
+	is_bot BOOLEAN NOT NULL,
+	note TEXT NOT NULL
+)`
+
+// Feedback queries; a new verdict on a detection replaces the previous one
+const (
+	detectionQuery      = `SELECT id, result FROM detections WHERE id = ?`
+	insertFeedbackQuery = `INSERT INTO feedback (detection_id, created_at_ms, flow_id, predicted_bot, confidence, model_version, is_bot, note) VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (detection_id) DO UPDATE SET created_at_ms = excluded.created_at_ms, is_bot = excluded.is_bot, note = excluded.note`
+	feedbackStatsQuery  = `SELECT predicted_bot, is_bot, COUNT(*) FROM feedback GROUP BY predicted_bot, is_bot`
+
+	// liveFeedbackStatsQuery aggregates the feedback on detections predicted
+	// since a time
+	liveFeedbackStatsQuery = `SELECT f.predicted_bot, f.is_bot, COUNT(*) FROM feedback f JOIN detections d ON d.id = f.detection_id WHERE d.timestamp_ms >= ? GROUP BY f.predicted_bot, f.is_bot`
+)
+
+// Feedback is an analyst's verdict on a persisted detection, stored with
+// the original prediction
+type Feedback struct {
+	DetectionID  int64     `json:"detection_id"`
+	FlowID       string    `json:"flow_id"`
+	PredictedBot bool      `json:"predicted_bot"`
+	Confidence   float64   `json:"confidence"`
+	ModelVersion string    `json:"model_version,omitempty"`
+	IsBot        bool      `json:"is_bot"` // the analyst's verdict
+	Note         string    `json:"note,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// Detection is the detection the feedback labels, with its features
+	Detection *cortex.DetectionResult `json:"-"`
+}
+
+// FeedbackStats compares predictions with analyst verdicts, treating bots
+// as positives
+type FeedbackStats struct {
+	Total             int64   `json:"total"`
+	TruePositives     int64   `json:"true_positives"`
+	FalsePositives    int64   `json:"false_positives"`
+	TrueNegatives     int64   `json:"true_negatives"`
+	FalseNegatives    int64   `json:"false_negatives"`
+	FalsePositiveRate float64 `json:"false_positive_rate"` // share of human flows predicted bots
+	FalseNegativeRate float64 `json:"false_negative_rate"` // share of bot flows predicted human
+	Accuracy          float64 `json:"accuracy"`
+	Precision         float64 `json:"precision"` // share of predicted bots that are bots
+	Recall            float64 `json:"recall"`    // share of bots predicted bots
+	F1                float64 `json:"f1"`
+}
+
+// WindowFeedbackStats compares the predictions made within a recent window
+// with the analyst verdicts submitted on them so far
+type WindowFeedbackStats struct {
+	Window string    `json:"window"` // e.g. "24h"
+	Since  time.Time `json:"since"`
+	FeedbackStats
+}
+
+// SubmitFeedback records an analyst's verdict on the detection with the
+// given ID, replacing earlier feedback on it
+func (s *Store) SubmitFeedback(ctx context.Context, id int64, isBot bool, note string) (*Feedback, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.config.Timeout)*time.Second)
+	defer cancel()
+
+	var rowID int64
+	var data string
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(detectionQuery), id).Scan(&rowID, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrDetectionNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read detection: %w", err)
+	}
+	var result cortex.DetectionResult
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		return nil, fmt.Errorf("failed to decode detection: %w", err)
+	}
+
+	feedback := &Feedback{
+		DetectionID:  id,
+		FlowID:       result.FlowID,
+		PredictedBot: result.IsBot,
+		Confidence:   result.Confidence,
+		ModelVersion: result.ModelVersion,
+		IsBot:        isBot,
+		Note:         note,
+		CreatedAt:    time.Now().UTC().Truncate(time.Millisecond),
+		Detection:    &result,
+	}
+	_, err = s.db.ExecContext(ctx, s.dialect.rebind(insertFeedbackQuery),
+		feedback.DetectionID,
+		feedback.CreatedAt.UnixMilli(),
+		feedback.FlowID,
+		feedback.PredictedBot,
+		feedback.Confidence,
+		feedback.ModelVersion,
+		feedback.IsBot,
+		feedback.Note)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store feedback: %w", err)
+	}
+	return feedback, nil
+}
+
+// FeedbackStats aggregates all feedback into the model's observed error
+// rates
+func (s *Store) FeedbackStats(ctx context.Context) (*FeedbackStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.config.Timeout)*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, feedbackStatsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query feedback: %w", err)
+	}
+	return scanFeedbackStats(rows)
+}
+
+// LiveAccuracy compares, for each configured accuracy window, the
+// predictions made within the window with the feedback on them. Feedback
+// on detections purged by retention no longer counts.
+func (s *Store) LiveAccuracy(ctx context.Context) ([]WindowFeedbackStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.config.Timeout)*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	windows := make([]WindowFeedbackStats, 0, len(s.config.AccuracyWindows))
+	for _, hours := range s.config.AccuracyWindows {
+		since := now.Add(-time.Duration(hours) * time.Hour)
+		rows, err := s.db.QueryContext(ctx, s.dialect.rebind(liveFeedbackStatsQuery), since.UnixMilli())
+		if err != nil {
+			return nil, fmt.Errorf("failed to query feedback: %w", err)
+		}
+		stats, err := scanFeedbackStats(rows)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, WindowFeedbackStats{
+			Window:        fmt.Sprintf("%dh", hours),
+			Since:         since,
+			FeedbackStats: *stats,
+		})
+	}
+	return windows, nil
+}
+
+// trackAccuracy publishes the live accuracy of each window as metrics every
+// accuracy interval until the store is closed
+func (s *Store) trackAccuracy() {
+	defer close(s.accuracyDone)
+
+	ticker := time.NewTicker(time.Duration(s.config.AccuracyInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		s.publishAccuracy()
+		select {
+		case <-ticker.C:
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// publishAccuracy sets the live accuracy metrics
+func (s *Store) publishAccuracy() {
+	windows, err := s.LiveAccuracy(context.Background())
+	if err != nil {
+		slog.Warn("Failed to aggregate live accuracy", "error", err)
+		return
+	}
+	for _, w := range windows {
+		livePrecision.WithLabelValues(w.Window).Set(w.Precision)
+		liveRecall.WithLabelValues(w.Window).Set(w.Recall)
+		liveAccuracy.WithLabelValues(w.Window).Set(w.Accuracy)
+		liveFeedback.WithLabelValues(w.Window).Set(float64(w.Total))
+	}
+}
+
+// scanFeedbackStats reads feedback counts by predicted and actual verdict
+// into error rates and closes rows
+func scanFeedbackStats(rows *sql.Rows) (*FeedbackStats, error) {
+	defer rows.Close()
+
+	stats := &FeedbackStats{}
+	for rows.Next() {
+		var predicted, actual bool
+		var count int64
+		if err := rows.Scan(&predicted, &actual, &count); err != nil {
+			return nil, fmt.Errorf("failed to read feedback: %w", err)
+		}
+		switch {
+		case predicted && actual:
+			stats.TruePositives += count
+		case predicted:
+			stats.FalsePositives += count
+		case actual:
+			stats.FalseNegatives += count
+		default:
+			stats.TrueNegatives += count
+		}
+		stats.Total += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query feedback: %w", err)
+	}
+
+	if humans := stats.FalsePositives + stats.TrueNegatives; humans > 0 {
+		stats.FalsePositiveRate = float64(stats.FalsePositives) / float64(humans)
+	}
+	if bots := stats.FalseNegatives + stats.TruePositives; bots > 0 {
+		stats.FalseNegativeRate = float64(stats.FalseNegatives) / float64(bots)
+	}
+	if stats.Total > 0 {
+		stats.Accuracy = float64(stats.TruePositives+stats.TrueNegatives) / float64(stats.Total)
+	}
+	if predictedBots := stats.TruePositives + stats.FalsePositives; predictedBots > 0 {
+		stats.Precision = float64(stats.TruePositives) / float64(predictedBots)
+	}
+	if bots := stats.TruePositives + stats.FalseNegatives; bots > 0 {
+		stats.Recall = float64(stats.TruePositives) / float64(bots)
+	}
+	if stats.Precision+stats.Recall > 0 {
+		stats.F1 = 2 * stats.Precision * stats.Recall / (stats.Precision + stats.Recall)
+	}
+	return stats, nil
+}
@@ -0,0 +1,480 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/prometheus/client_golang/prometheus"
+
+	// database/sql drivers of the supported databases
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// Name identifies the store in sink health checks
+const Name = "store"
+
+const (
+	// DefaultLimit is the number of detections returned when a query sets
+	// no limit
+	DefaultLimit = 100
+	// MaxLimit caps the number of detections returned by one query
+	MaxLimit = 1000
+)
+
+var (
+	detectionsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_store_detections_total",
+			Help: "Total number of detections persisted in the detection store",
+		},
+	)
+	writeErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_store_write_errors_total",
+			Help: "Total number of detections that could not be persisted in the detection store",
+		},
+	)
+	droppedDetections = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_store_dropped_detections_total",
+			Help: "Total number of detections dropped because the detection store queue was full",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(detectionsTotal, writeErrors, droppedDetections)
+}
+
+// dialect covers the SQL differences between the supported databases
+type dialect int
+
+const (
+	sqlite dialect = iota
+	postgres
+)
+
+// storeDriver is a database/sql driver linked into the binary and the
+// dialect it speaks
+type storeDriver struct {
+	name    string
+	dialect dialect
+}
+
+// drivers maps the configured store drivers to the linked ones
+var drivers = map[string]storeDriver{
+	"sqlite":   {"sqlite", sqlite}, // modernc.org/sqlite
+	"sqlite3":  {"sqlite", sqlite},
+	"postgres": {"pgx", postgres}, // github.com/jackc/pgx/v5/stdlib
+	"pgx":      {"pgx", postgres},
+}
+
+// driverFor returns the linked database/sql driver of a configured one
+func driverFor(driver string) (storeDriver, error) {
+	d, ok := drivers[driver]
+	if !ok {
+		return storeDriver{}, fmt.Errorf("unsupported store driver: %q", driver)
+	}
+	return d, nil
+}
+
+// rebind rewrites the ? placeholders of a query for the dialect
+func (d dialect) rebind(query string) string {
+	if d != postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// schema returns the statements creating the detection table. Columns
+// filtered by queries are stored individually; the full result is kept as
+// JSON so responses keep features and enrichment.
+func (d dialect) schema() []string {
+	id := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if d == postgres {
+		id = "BIGSERIAL PRIMARY KEY"
+	}
+	return []string{
+		`CREATE TABLE IF NOT EXISTS detections (
+	id ` + id + `,
+	timestamp_ms BIGINT NOT NULL,
+	flow_id TEXT NOT NULL,
+	is_bot BOOLEAN NOT NULL,
+	confidence DOUBLE PRECISION NOT NULL,
+	model_used TEXT NOT NULL,
+	src_ip TEXT NOT NULL,
+	dst_ip TEXT NOT NULL,
+	result TEXT NOT NULL
+)`,
+		`CREATE INDEX IF NOT EXISTS detections_timestamp ON detections (timestamp_ms)`,
+		`CREATE INDEX IF NOT EXISTS detections_src_ip ON detections (src_ip, timestamp_ms)`,
+		`CREATE INDEX IF NOT EXISTS detections_dst_ip ON detections (dst_ip, timestamp_ms)`,
+		feedbackSchema,
+	}
+}
+
+// insertQuery inserts one detection
+const insertQuery = `INSERT INTO detections (timestamp_ms, flow_id, is_bot, confidence, model_used, src_ip, dst_ip, result) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+// purgeQuery deletes expired detections with one verdict
+const purgeQuery = `DELETE FROM detections WHERE is_bot = ? AND timestamp_ms < ?`
+
+// Query selects persisted detections. Zero-valued fields do not filter.
+type Query struct {
+	Since time.Time
+	Until time.Time
+	IsBot *bool
+	IP    net.IP // source or destination address
+	Limit int
+}
+
+// sql returns the statement and arguments selecting the detections of q,
+// most recent first
+func (q Query) sql(d dialect) (string, []any) {
+	var where []string
+	var args []any
+	if !q.Since.IsZero() {
+		where = append(where, "timestamp_ms >= ?")
+		args = append(args, q.Since.UnixMilli())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "timestamp_ms <= ?")
+		args = append(args, q.Until.UnixMilli())
+	}
+	if q.IsBot != nil {
+		where = append(where, "is_bot = ?")
+		args = append(args, *q.IsBot)
+	}
+	if q.IP != nil {
+		where = append(where, "(src_ip = ? OR dst_ip = ?)")
+		args = append(args, q.IP.String(), q.IP.String())
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	query := "SELECT id, result FROM detections"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY timestamp_ms DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+	return d.rebind(query), args
+}
+
+// Store persists detection results in SQLite or PostgreSQL and answers
+// queries over them. Detections are queued without blocking and inserted
+// in batches by a background goroutine; while the database is slow,
+// detections beyond the queue size are dropped.
+type Store struct {
+	config  config.StoreConfig
+	db      *sql.DB
+	dialect dialect
+
+	queue     chan *cortex.DetectionResult
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// Closed when the live accuracy metrics stop being updated
+	accuracyDone chan struct{}
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// Open connects to the configured database, creates the detection table
+// if needed and starts persisting in the background
+func Open(cfg config.StoreConfig) (*Store, error) {
+	driver, err := driverFor(cfg.Driver)
+	if err != nil {
+		return nil, err
+	}
+	d := driver.dialect
+	if cfg.DSN == "" {
+		return nil, fmt.Errorf("no store dsn configured")
+	}
+	if cfg.BatchSize < 1 || cfg.FlushInterval < 1 || cfg.QueueSize < 1 || cfg.Timeout < 1 {
+		return nil, fmt.Errorf("store batch size, flush interval, queue size and timeout must be positive")
+	}
+	if cfg.AccuracyInterval < 0 {
+		return nil, fmt.Errorf("store accuracy interval must not be negative")
+	}
+	for _, hours := range cfg.AccuracyWindows {
+		if hours < 1 {
+			return nil, fmt.Errorf("store accuracy windows must be positive")
+		}
+	}
+
+	db, err := sql.Open(driver.name, cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+	if d == sqlite {
+		// SQLite allows one writer at a time
+		db.SetMaxOpenConns(1)
+	}
+
+	s := &Store{
+		config:  cfg,
+		db:      db,
+		dialect: d,
+		queue:   make(chan *cortex.DetectionResult, cfg.QueueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+
+		accuracyDone: make(chan struct{}),
+	}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	go s.run()
+	if cfg.AccuracyInterval > 0 && len(cfg.AccuracyWindows) > 0 {
+		go s.trackAccuracy()
+	} else {
+		close(s.accuracyDone)
+	}
+
+	slog.Info("Detection store opened", "driver", cfg.Driver)
+	return s, nil
+}
+
+// migrate creates the detection table and its indexes
+func (s *Store) migrate() error {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to connect to store: %w", err)
+	}
+	for _, stmt := range s.dialect.schema() {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create store schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// context returns a context bounded by the configured timeout
+func (s *Store) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Duration(s.config.Timeout)*time.Second)
+}
+
+// Name implements argus.Sink
+func (s *Store) Name() string {
+	return Name
+}
+
+// WriteDetection queues a detection result, dropping it when the queue is
+// full or the store is closed
+func (s *Store) WriteDetection(result *cortex.DetectionResult) {
+	select {
+	case <-s.closing:
+		droppedDetections.Inc()
+		return
+	default:
+	}
+	select {
+	case s.queue <- result:
+	default:
+		droppedDetections.Inc()
+	}
+}
+
+// WriteFlow implements argus.Sink. The store only keeps detections.
+func (s *Store) WriteFlow(*events.FlowRecord) {}
+
+// Health returns nil while inserts succeed, or the last error
+func (s *Store) Health() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+func (s *Store) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err
+}
+
+// Close persists the queued detections and closes the database
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+	<-s.done
+	<-s.accuracyDone
+	return s.db.Close()
+}
+
+// run batches queued detections until the store is closed
+func (s *Store) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(time.Duration(s.config.FlushInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	var batch []*cortex.DetectionResult
+	for {
+		select {
+		case result := <-s.queue:
+			batch = append(batch, result)
+			if len(batch) >= s.config.BatchSize {
+				s.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				s.flush(batch)
+				batch = nil
+			}
+		case <-s.closing:
+			for len(s.queue) > 0 {
+				batch = append(batch, <-s.queue)
+				if len(batch) >= s.config.BatchSize {
+					s.flush(batch)
+					batch = nil
+				}
+			}
+			if len(batch) > 0 {
+				s.flush(batch)
+			}
+			return
+		}
+	}
+}
+
+// flush inserts a batch in one transaction
+func (s *Store) flush(batch []*cortex.DetectionResult) {
+	if err := s.insert(batch); err != nil {
+		writeErrors.Add(float64(len(batch)))
+		s.setErr(err)
+		slog.Warn("Failed to persist detections", "detections", len(batch), "error", err)
+		return
+	}
+	detectionsTotal.Add(float64(len(batch)))
+	s.setErr(nil)
+}
+
+func (s *Store) insert(batch []*cortex.DetectionResult) error {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, s.dialect.rebind(insertQuery))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, result := range batch {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		timestamp := result.Timestamp
+		if timestamp.IsZero() {
+			timestamp = time.Now()
+		}
+		_, err = stmt.ExecContext(ctx,
+			timestamp.UnixMilli(),
+			result.FlowID,
+			result.IsBot,
+			result.Confidence,
+			result.ModelUsed,
+			formatIP(result.SrcIP),
+			formatIP(result.DstIP),
+			string(data))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// PurgeDetections deletes the detections with the given verdict that are
+// older than before and returns how many were deleted
+func (s *Store) PurgeDetections(ctx context.Context, isBot bool, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.config.Timeout)*time.Second)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, s.dialect.rebind(purgeQuery), isBot, before.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge detections: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// formatIP formats an address as queries match it, empty when unknown
+func formatIP(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// QueryDetections returns the persisted detections selected by q, most
+// recent first. Detections still queued are not included.
+func (s *Store) QueryDetections(ctx context.Context, q Query) ([]*cortex.DetectionResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.config.Timeout)*time.Second)
+	defer cancel()
+
+	query, args := q.sql(s.dialect)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query detections: %w", err)
+	}
+	defer rows.Close()
+
+	results := []*cortex.DetectionResult{}
+	for rows.Next() {
+		var id int64
+		var data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, fmt.Errorf("failed to read detection: %w", err)
+		}
+		var result cortex.DetectionResult
+		if err := json.Unmarshal([]byte(data), &result); err != nil {
+			return nil, fmt.Errorf("failed to decode detection: %w", err)
+		}
+		result.ID = id
+		results = append(results, &result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query detections: %w", err)
+	}
+	return results, nil
+}
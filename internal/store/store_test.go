@@ -0,0 +1,411 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDB records the statements run through fakeDriver. Queries return
+// the inserted results, most recent insert first, with their position as
+// ID. Feedback is kept by detection ID.
+type fakeDB struct {
+	mu         sync.Mutex
+	statements []string
+	inserted   []string
+	feedback   map[int64][]driver.Value
+	commits    int
+	failInsert bool
+	lastArgs   []driver.Value
+}
+
+var (
+	fakeMu  sync.Mutex
+	fakeDBs = make(map[string]*fakeDB)
+)
+
+func init() {
+	sql.Register("fake", fakeDriver{})
+	drivers["fake"] = storeDriver{"fake", sqlite}
+}
+
+func newFakeDB(t *testing.T) (*fakeDB, string) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	db := &fakeDB{}
+	fakeDBs[t.Name()] = db
+	return db, t.Name()
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	db, ok := fakeDBs[dsn]
+	if !ok {
+		return nil, errors.New("unable to open database file")
+	}
+	return &fakeConn{db: db}, nil
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.commits++
+	return nil
+}
+func (c *fakeConn) Rollback() error { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.statements = append(s.db.statements, s.query)
+	s.db.lastArgs = args
+	switch {
+	case strings.HasPrefix(s.query, "INSERT INTO feedback"):
+		if s.db.feedback == nil {
+			s.db.feedback = make(map[int64][]driver.Value)
+		}
+		s.db.feedback[args[0].(int64)] = args
+	case strings.HasPrefix(s.query, "INSERT"):
+		if s.db.failInsert {
+			return nil, errors.New("database is locked")
+		}
+		s.db.inserted = append(s.db.inserted, args[len(args)-1].(string))
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.statements = append(s.db.statements, s.query)
+	s.db.lastArgs = args
+	rows := &fakeRows{}
+	switch {
+	case s.query == detectionQuery:
+		if id := args[0].(int64); id >= 1 && int(id) <= len(s.db.inserted) {
+			rows.rows = append(rows.rows, []driver.Value{id, s.db.inserted[id-1]})
+		}
+	case s.query == feedbackStatsQuery:
+		counts := make(map[[2]bool]int64)
+		for _, f := range s.db.feedback {
+			counts[[2]bool{f[3].(bool), f[6].(bool)}]++
+		}
+		for key, count := range counts {
+			rows.rows = append(rows.rows, []driver.Value{key[0], key[1], count})
+		}
+	case s.query == liveFeedbackStatsQuery:
+		counts := make(map[[2]bool]int64)
+		for id, f := range s.db.feedback {
+			var result cortex.DetectionResult
+			if err := json.Unmarshal([]byte(s.db.inserted[id-1]), &result); err != nil {
+				return nil, err
+			}
+			if result.Timestamp.UnixMilli() >= args[0].(int64) {
+				counts[[2]bool{f[3].(bool), f[6].(bool)}]++
+			}
+		}
+		for key, count := range counts {
+			rows.rows = append(rows.rows, []driver.Value{key[0], key[1], count})
+		}
+	default:
+		for i := len(s.db.inserted) - 1; i >= 0; i-- {
+			rows.rows = append(rows.rows, []driver.Value{int64(i + 1), s.db.inserted[i]})
+		}
+	}
+	return rows, nil
+}
+
+type fakeRows struct{ rows [][]driver.Value }
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return []string{"id", "result"}
+	}
+	return make([]string, len(r.rows[0]))
+}
+func (r *fakeRows) Close() error { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func testConfig(dsn string) config.StoreConfig {
+	return config.StoreConfig{
+		Driver:        "fake",
+		DSN:           dsn,
+		BatchSize:     10,
+		FlushInterval: 10,
+		QueueSize:     100,
+		Timeout:       5,
+	}
+}
+
+func TestStore(t *testing.T) {
+	db, dsn := newFakeDB(t)
+	s, err := Open(testConfig(dsn))
+	require.NoError(t, err)
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.WriteDetection(&cortex.DetectionResult{
+		FlowID:     "a-b",
+		IsBot:      true,
+		Confidence: 0.9,
+		Features:   []float64{1, 2},
+		Timestamp:  now,
+		SrcIP:      net.ParseIP("10.0.0.1"),
+		DstIP:      net.ParseIP("10.0.0.2"),
+	})
+	s.WriteDetection(&cortex.DetectionResult{FlowID: "c-d", Timestamp: now.Add(time.Second)})
+
+	require.Eventually(t, func() bool {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return db.commits == 1
+	}, time.Second, 5*time.Millisecond, "both detections are inserted in one transaction")
+	assert.NoError(t, s.Health())
+
+	results, err := s.QueryDetections(context.Background(), Query{})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "c-d", results[0].FlowID)
+	assert.Equal(t, int64(2), results[0].ID)
+	assert.Equal(t, "a-b", results[1].FlowID)
+	assert.Equal(t, int64(1), results[1].ID)
+	assert.Equal(t, []float64{1, 2}, results[1].Features)
+	assert.True(t, results[1].SrcIP.Equal(net.ParseIP("10.0.0.1")))
+
+	require.NoError(t, s.Close())
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	assert.True(t, strings.HasPrefix(db.statements[0], "CREATE TABLE IF NOT EXISTS detections"))
+	assert.Contains(t, db.statements[0], "INTEGER PRIMARY KEY AUTOINCREMENT")
+}
+
+func TestStoreInsertFailure(t *testing.T) {
+	db, dsn := newFakeDB(t)
+	db.failInsert = true
+	s, err := Open(testConfig(dsn))
+	require.NoError(t, err)
+
+	s.WriteDetection(&cortex.DetectionResult{FlowID: "a-b"})
+	require.NoError(t, s.Close())
+	assert.ErrorContains(t, s.Health(), "database is locked")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	assert.Zero(t, db.commits)
+}
+
+func TestPurgeDetections(t *testing.T) {
+	db, dsn := newFakeDB(t)
+	s, err := Open(testConfig(dsn))
+	require.NoError(t, err)
+	defer s.Close()
+
+	purged, err := s.PurgeDetections(context.Background(), false, time.UnixMilli(5000))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	assert.Equal(t, "DELETE FROM detections WHERE is_bot = ? AND timestamp_ms < ?", db.statements[len(db.statements)-1])
+	assert.Equal(t, []driver.Value{false, int64(5000)}, db.lastArgs)
+}
+
+func TestQuerySQL(t *testing.T) {
+	isBot := true
+	q := Query{
+		Since: time.UnixMilli(1000),
+		Until: time.UnixMilli(2000),
+		IsBot: &isBot,
+		IP:    net.ParseIP("192.0.2.1"),
+		Limit: 5000,
+	}
+
+	query, args := q.sql(sqlite)
+	assert.Equal(t, "SELECT id, result FROM detections WHERE timestamp_ms >= ? AND timestamp_ms <= ? AND is_bot = ? AND (src_ip = ? OR dst_ip = ?) ORDER BY timestamp_ms DESC, id DESC LIMIT ?", query)
+	assert.Equal(t, []any{int64(1000), int64(2000), true, "192.0.2.1", "192.0.2.1", MaxLimit}, args)
+
+	query, args = q.sql(postgres)
+	assert.Equal(t, "SELECT id, result FROM detections WHERE timestamp_ms >= $1 AND timestamp_ms <= $2 AND is_bot = $3 AND (src_ip = $4 OR dst_ip = $5) ORDER BY timestamp_ms DESC, id DESC LIMIT $6", query)
+	assert.Len(t, args, 6)
+
+	query, args = Query{}.sql(postgres)
+	assert.Equal(t, "SELECT id, result FROM detections ORDER BY timestamp_ms DESC, id DESC LIMIT $1", query)
+	assert.Equal(t, []any{DefaultLimit}, args)
+}
+
+func TestOpenValidation(t *testing.T) {
+	cfg := testConfig("argus.db")
+	cfg.Driver = "mysql"
+	_, err := Open(cfg)
+	assert.ErrorContains(t, err, "unsupported store driver")
+
+	cfg = testConfig("")
+	_, err = Open(cfg)
+	assert.Error(t, err)
+
+	cfg = testConfig("missing")
+	_, err = Open(cfg)
+	assert.ErrorContains(t, err, "failed to connect to store")
+
+	cfg = testConfig("argus.db")
+	cfg.Driver = "postgres"
+	_, err = Open(cfg)
+	assert.ErrorContains(t, err, "cannot parse", "the dsn is not a postgres one")
+}
+
+func TestStoreSQLite(t *testing.T) {
+	cfg := testConfig("file:" + filepath.Join(t.TempDir(), "argus.db"))
+	cfg.Driver = "sqlite"
+	s, err := Open(cfg)
+	require.NoError(t, err)
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.WriteDetection(&cortex.DetectionResult{FlowID: "a-b", IsBot: true, Confidence: 0.9, Timestamp: now})
+	s.WriteDetection(&cortex.DetectionResult{FlowID: "c-d", Timestamp: now.Add(time.Second)})
+	require.NoError(t, s.Close())
+	require.NoError(t, s.Health())
+
+	// Detections persist across reopening the database
+	s, err = Open(cfg)
+	require.NoError(t, err)
+	defer s.Close()
+
+	isBot := true
+	results, err := s.QueryDetections(context.Background(), Query{IsBot: &isBot})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a-b", results[0].FlowID)
+	assert.Equal(t, int64(1), results[0].ID)
+}
+
+func TestFeedback(t *testing.T) {
+	db, dsn := newFakeDB(t)
+	s, err := Open(testConfig(dsn))
+	require.NoError(t, err)
+
+	s.WriteDetection(&cortex.DetectionResult{FlowID: "a-b", IsBot: true, Confidence: 0.9, ModelVersion: "0123456789ab"})
+	s.WriteDetection(&cortex.DetectionResult{FlowID: "c-d", IsBot: false, Confidence: 0.2})
+	s.WriteDetection(&cortex.DetectionResult{FlowID: "e-f", IsBot: true, Confidence: 0.7})
+	require.Eventually(t, func() bool {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return len(db.inserted) == 3
+	}, time.Second, 5*time.Millisecond)
+	defer s.Close()
+
+	// The verdict is stored with the original prediction
+	feedback, err := s.SubmitFeedback(context.Background(), 1, false, "crawler of a partner")
+	require.NoError(t, err)
+	assert.Equal(t, "a-b", feedback.FlowID)
+	assert.True(t, feedback.PredictedBot)
+	assert.Equal(t, 0.9, feedback.Confidence)
+	assert.Equal(t, "0123456789ab", feedback.ModelVersion)
+	assert.False(t, feedback.IsBot)
+
+	_, err = s.SubmitFeedback(context.Background(), 2, true, "")
+	require.NoError(t, err)
+	_, err = s.SubmitFeedback(context.Background(), 3, true, "")
+	require.NoError(t, err)
+
+	_, err = s.SubmitFeedback(context.Background(), 4, true, "")
+	assert.ErrorIs(t, err, ErrDetectionNotFound)
+
+	stats, err := s.FeedbackStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &FeedbackStats{
+		Total:             3,
+		TruePositives:     1,
+		FalsePositives:    1,
+		FalseNegatives:    1,
+		FalsePositiveRate: 1,
+		FalseNegativeRate: 0.5,
+		Accuracy:          1.0 / 3,
+		Precision:         0.5,
+		Recall:            0.5,
+		F1:                0.5,
+	}, stats)
+}
+
+func TestLiveAccuracy(t *testing.T) {
+	db, dsn := newFakeDB(t)
+	cfg := testConfig(dsn)
+	cfg.AccuracyWindows = []int{1, 24}
+	s, err := Open(cfg)
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Now()
+	s.WriteDetection(&cortex.DetectionResult{FlowID: "a-b", IsBot: true, Timestamp: now.Add(-10 * time.Minute)})
+	s.WriteDetection(&cortex.DetectionResult{FlowID: "c-d", IsBot: true, Timestamp: now.Add(-5 * time.Hour)})
+	s.WriteDetection(&cortex.DetectionResult{FlowID: "e-f", IsBot: false, Timestamp: now.Add(-48 * time.Hour)})
+	require.Eventually(t, func() bool {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return len(db.inserted) == 3
+	}, time.Second, 5*time.Millisecond)
+
+	// Feedback counts in the windows of the prediction, not its submission
+	for id, isBot := range map[int64]bool{1: true, 2: false, 3: true} {
+		_, err := s.SubmitFeedback(context.Background(), id, isBot, "")
+		require.NoError(t, err)
+	}
+
+	windows, err := s.LiveAccuracy(context.Background())
+	require.NoError(t, err)
+	require.Len(t, windows, 2)
+
+	assert.Equal(t, "1h", windows[0].Window)
+	assert.WithinDuration(t, now.Add(-time.Hour), windows[0].Since, time.Second)
+	assert.Equal(t, int64(1), windows[0].Total)
+	assert.Equal(t, 1.0, windows[0].Precision)
+	assert.Equal(t, 1.0, windows[0].Recall)
+
+	assert.Equal(t, "24h", windows[1].Window)
+	assert.Equal(t, int64(2), windows[1].Total)
+	assert.Equal(t, int64(1), windows[1].FalsePositives)
+	assert.Equal(t, 0.5, windows[1].Precision)
+	assert.Equal(t, 1.0, windows[1].Recall)
+
+	cfg.AccuracyWindows = []int{0}
+	_, err = Open(cfg)
+	assert.ErrorContains(t, err, "accuracy windows")
+}
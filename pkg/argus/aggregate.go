@@ -0,0 +1,162 @@
+package argus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+// aggregateKey identifies the detections rolled up together
+type aggregateKey struct {
+	src, dst string
+	isBot    bool
+}
+
+// alertGroup holds the detections of one key within a window
+type alertGroup struct {
+	opened      time.Time
+	best        *cortex.DetectionResult // the most confident detection
+	count       int
+	flows       map[string]struct{}
+	first, last time.Time
+}
+
+// aggregatingSink rolls up the detections written to a sink by source,
+// destination and verdict
+type aggregatingSink struct {
+	Sink
+	window    time.Duration
+	maxGroups int
+
+	mu     sync.Mutex
+	groups map[aggregateKey]*alertGroup
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Aggregate wraps a sink so that the detections of one source, destination
+// and verdict within the configured window of the first are delivered as one
+// when the window ends. The delivered detection is the most confident of the
+// group, with an aggregate counting them when there were several. Flows are
+// delivered as they come. The sink is returned as is when the window is 0.
+func Aggregate(sink Sink, cfg config.AggregationConfig) Sink {
+	if cfg.Window <= 0 {
+		return sink
+	}
+	s := &aggregatingSink{
+		Sink:      sink,
+		window:    time.Duration(cfg.Window) * time.Second,
+		maxGroups: cfg.MaxGroups,
+		groups:    make(map[aggregateKey]*alertGroup),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// WriteDetection adds a detection to its group, opening one when there is
+// none. Detections without room for a group are delivered right away.
+func (s *aggregatingSink) WriteDetection(result *cortex.DetectionResult) {
+	s.add(result, time.Now())
+}
+
+// add adds a detection arriving at now to its group
+func (s *aggregatingSink) add(result *cortex.DetectionResult, now time.Time) {
+	key := aggregateKey{src: result.SrcIP.String(), dst: result.DstIP.String(), isBot: result.IsBot}
+	seen := result.Timestamp
+	if seen.IsZero() {
+		seen = now
+	}
+
+	s.mu.Lock()
+	group, ok := s.groups[key]
+	if !ok {
+		if s.maxGroups > 0 && len(s.groups) >= s.maxGroups {
+			s.mu.Unlock()
+			s.Sink.WriteDetection(result)
+			return
+		}
+		group = &alertGroup{opened: now, flows: make(map[string]struct{}), first: seen, last: seen}
+		s.groups[key] = group
+	}
+	group.count++
+	if result.FlowID != "" {
+		group.flows[result.FlowID] = struct{}{}
+	}
+	if group.best == nil || result.Confidence > group.best.Confidence {
+		group.best = result
+	}
+	if seen.Before(group.first) {
+		group.first = seen
+	}
+	if seen.After(group.last) {
+		group.last = seen
+	}
+	s.mu.Unlock()
+}
+
+// run delivers the groups whose window ended until the sink is closed
+func (s *aggregatingSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.flush(now, false)
+		}
+	}
+}
+
+// flush delivers the groups whose window ended by now, or every group when
+// all is set
+func (s *aggregatingSink) flush(now time.Time, all bool) {
+	var ready []*cortex.DetectionResult
+	s.mu.Lock()
+	for key, group := range s.groups {
+		if !all && now.Sub(group.opened) < s.window {
+			continue
+		}
+		delete(s.groups, key)
+		ready = append(ready, group.result())
+	}
+	s.mu.Unlock()
+
+	for _, result := range ready {
+		s.Sink.WriteDetection(result)
+	}
+}
+
+// result returns the detection delivered for a group
+func (g *alertGroup) result() *cortex.DetectionResult {
+	if g.count == 1 {
+		return g.best
+	}
+	rolled := *g.best
+	rolled.Aggregate = &cortex.AlertAggregate{
+		Count:     g.count,
+		Flows:     len(g.flows),
+		FirstSeen: g.first,
+		LastSeen:  g.last,
+	}
+	return &rolled
+}
+
+// Close delivers every open group and closes the wrapped sink
+func (s *aggregatingSink) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+		s.flush(time.Now(), true)
+	})
+	return s.Sink.Close()
+}
@@ -0,0 +1,97 @@
+package argus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+)
+
+func TestAggregateDisabled(t *testing.T) {
+	sink := &recordingSink{}
+	assert.Same(t, Sink(sink), Aggregate(sink, config.AggregationConfig{MaxGroups: 10}))
+}
+
+func TestAggregateRollsUpDetections(t *testing.T) {
+	inner := &recordingSink{}
+	sink := Aggregate(inner, config.AggregationConfig{Window: 60, MaxGroups: 10}).(*aggregatingSink)
+
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	detection := func(src, dst string, isBot bool, flowID string, confidence float64, at time.Duration) {
+		sink.add(&cortex.DetectionResult{
+			SrcIP:      net.ParseIP(src),
+			DstIP:      net.ParseIP(dst),
+			IsBot:      isBot,
+			FlowID:     flowID,
+			Confidence: confidence,
+			Timestamp:  start.Add(at),
+		}, start.Add(at))
+	}
+	detection("203.0.113.7", "198.51.100.1", true, "flow-1", 0.9, 0)
+	detection("203.0.113.7", "198.51.100.1", true, "flow-2", 0.97, 10*time.Second)
+	detection("203.0.113.7", "198.51.100.1", true, "flow-2", 0.92, 20*time.Second)
+	detection("203.0.113.7", "198.51.100.1", false, "flow-3", 0.2, 5*time.Second)
+	detection("203.0.113.7", "198.51.100.2", true, "flow-4", 0.95, 30*time.Second)
+
+	// Nothing is delivered before a window ends, flows pass through
+	sink.WriteFlow(&events.FlowRecord{ID: "flow-1"})
+	sink.flush(start.Add(59*time.Second), false)
+	assert.Empty(t, inner.detections)
+	assert.Len(t, inner.flows, 1)
+
+	sink.flush(start.Add(65*time.Second), false)
+	require.Len(t, inner.detections, 2)
+	byVerdict := map[bool]*cortex.DetectionResult{}
+	for _, result := range inner.detections {
+		byVerdict[result.IsBot] = result
+	}
+
+	rolled := byVerdict[true]
+	assert.Equal(t, "flow-2", rolled.FlowID)
+	assert.Equal(t, 0.97, rolled.Confidence)
+	require.NotNil(t, rolled.Aggregate)
+	assert.Equal(t, cortex.AlertAggregate{
+		Count:     3,
+		Flows:     2,
+		FirstSeen: start,
+		LastSeen:  start.Add(20 * time.Second),
+	}, *rolled.Aggregate)
+
+	// Single detections are delivered as they were written
+	assert.Nil(t, byVerdict[false].Aggregate)
+	assert.Equal(t, "flow-3", byVerdict[false].FlowID)
+
+	// The other destination's window ends later, and Close delivers it
+	require.NoError(t, sink.Close())
+	require.Len(t, inner.detections, 3)
+	assert.Equal(t, "flow-4", inner.detections[2].FlowID)
+	assert.True(t, inner.closed)
+}
+
+func TestAggregateMaxGroups(t *testing.T) {
+	inner := &recordingSink{}
+	sink := Aggregate(inner, config.AggregationConfig{Window: 60, MaxGroups: 1}).(*aggregatingSink)
+	defer sink.Close()
+
+	now := time.Now()
+	sink.add(&cortex.DetectionResult{SrcIP: net.ParseIP("203.0.113.7"), IsBot: true}, now)
+	sink.add(&cortex.DetectionResult{SrcIP: net.ParseIP("203.0.113.7"), IsBot: true}, now)
+	assert.Empty(t, inner.detections)
+
+	// Detections without room for a group are delivered right away
+	sink.add(&cortex.DetectionResult{SrcIP: net.ParseIP("203.0.113.8"), IsBot: true, FlowID: "overflow"}, now)
+	require.Len(t, inner.detections, 1)
+	assert.Equal(t, "overflow", inner.detections[0].FlowID)
+	assert.Nil(t, inner.detections[0].Aggregate)
+
+	sink.flush(now.Add(time.Minute), false)
+	require.Len(t, inner.detections, 2)
+	assert.Equal(t, 2, inner.detections[1].Aggregate.Count)
+	assert.Zero(t, inner.detections[1].Aggregate.Flows)
+}
@@ -0,0 +1,61 @@
+package argus
+
+import "strings"
+
+// ProtocolAnomalies counts the ways the traffic of a flow broke the state
+// machines of its protocols. Clients built on real protocol stacks rarely
+// do; scrapers writing raw requests, scanners and fuzzers often.
+type ProtocolAnomalies struct {
+	// HTTPRequestsAfterClose are requests sent on an HTTP/1.x connection
+	// after either side announced its close
+	HTTPRequestsAfterClose int `json:"http_requests_after_close"`
+	// HTTPUnsolicitedResponses are responses to no request seen, counted
+	// once a request of the flow was
+	HTTPUnsolicitedResponses int `json:"http_unsolicited_responses"`
+	// TLSAlerts are the alert records among the first records
+	TLSAlerts int `json:"tls_alerts"`
+	// TLSOutOfOrder are application data records sent before both sides
+	// started their handshake
+	TLSOutOfOrder int `json:"tls_out_of_order"`
+	// TCPDataAfterClose are TCP segments carrying data in a direction
+	// after its FIN, or after either side reset the connection
+	TCPDataAfterClose int `json:"tcp_data_after_close"`
+}
+
+// tcpState follows the closing of a TCP connection
+type tcpState struct {
+	fin            [2]bool // outbound then inbound
+	reset          bool
+	dataAfterClose int
+}
+
+// add records the flags and payload size of a segment sent by side
+func (t *tcpState) add(side int, flags string, payload int) {
+	if payload > 0 && (t.fin[side] || t.reset) {
+		t.dataAfterClose++
+	}
+	for _, flag := range strings.Split(flags, "|") {
+		switch flag {
+		case "FIN":
+			t.fin[side] = true
+		case "RST":
+			t.reset = true
+		}
+	}
+}
+
+// anomalies returns the protocol anomalies of the flow, nil when there
+// were none
+func (s *flowStats) anomalies() *ProtocolAnomalies {
+	anomalies := ProtocolAnomalies{
+		HTTPRequestsAfterClose:   s.http.afterClose,
+		HTTPUnsolicitedResponses: s.http.unsolicited,
+		TLSAlerts:                s.tls.alerts,
+		TLSOutOfOrder:            s.tls.outOfOrder,
+		TCPDataAfterClose:        s.tcp.dataAfterClose,
+	}
+	if anomalies == (ProtocolAnomalies{}) {
+		return nil
+	}
+	return &anomalies
+}
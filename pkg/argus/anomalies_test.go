@@ -0,0 +1,81 @@
+package argus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+)
+
+func TestHTTPAnomalies(t *testing.T) {
+	start := time.Now()
+	request := func(version string, headers map[string]string) *protocol.ProtocolInfo {
+		return &protocol.ProtocolInfo{Protocol: "HTTP/1.1", Version: version, Method: "GET", Path: "/", Headers: headers}
+	}
+	response := func(headers map[string]string) *protocol.ProtocolInfo {
+		return &protocol.ProtocolInfo{Protocol: "HTTP/1.1", Version: "HTTP/1.1", StatusCode: 200, Headers: headers}
+	}
+
+	// A response before any request is taken for a capture started late
+	var s httpStats
+	s.add(response(nil), start)
+	assert.Equal(t, 0, s.unsolicited)
+
+	// The client asked to close, then sent another request, and got an
+	// answer to a request it never made
+	s.add(request("HTTP/1.1", map[string]string{"Connection": "close"}), start)
+	s.add(response(nil), start)
+	s.add(request("HTTP/1.1", nil), start)
+	s.add(response(nil), start)
+	s.add(response(nil), start)
+	assert.Equal(t, 1, s.afterClose)
+	assert.Equal(t, 1, s.unsolicited)
+
+	// The server closing counts the same
+	var server httpStats
+	server.add(request("HTTP/1.1", map[string]string{"Connection": "keep-alive"}), start)
+	server.add(response(map[string]string{"Connection": "Close"}), start)
+	server.add(request("HTTP/1.1", nil), start)
+	assert.Equal(t, 1, server.afterClose)
+
+	// HTTP/1.0 closes unless kept alive, HTTP/2 never says
+	assert.True(t, closesConnection(request("HTTP/1.0", nil)))
+	assert.False(t, closesConnection(request("HTTP/1.0", map[string]string{"connection": "Keep-Alive"})))
+	assert.False(t, closesConnection(request("HTTP/1.1", nil)))
+	assert.False(t, closesConnection(&protocol.ProtocolInfo{Version: "HTTP/2", StreamID: 1, Method: "GET"}))
+}
+
+func TestTCPDataAfterClose(t *testing.T) {
+	var tcp tcpState
+	tcp.add(0, "SYN", 0)
+	tcp.add(0, "ACK|PSH", 100)
+	tcp.add(0, "FIN|ACK", 0)
+	tcp.add(1, "ACK|PSH", 100) // the other side may still send
+	assert.Equal(t, 0, tcp.dataAfterClose)
+	tcp.add(0, "ACK|PSH", 100)
+	tcp.add(1, "RST", 0)
+	tcp.add(1, "ACK|PSH", 10)
+	assert.Equal(t, 2, tcp.dataAfterClose)
+}
+
+func TestExtractAnomalyFeatures(t *testing.T) {
+	engine := &Engine{}
+	start := time.Now()
+
+	flow := &Flow{ID: "test-flow", StartTime: start}
+	flow.add(&Packet{Timestamp: start, Size: 60, Headers: map[string]interface{}{"tcp_flags": "FIN|ACK", "payload_size": 0}}, 0)
+	assert.Equal(t, []float64{0, 0, 0, 0, 0}, engine.extractFeatures(flow)[95:100])
+	assert.Nil(t, flow.Detail().Anomalies)
+
+	flow.add(&Packet{Timestamp: start, Size: 200, Headers: map[string]interface{}{"tcp_flags": "ACK|PSH", "payload_size": 140}}, 0)
+	flow.stats.tls.add([]tlsRecord{{tlsRecordHandshake, 200}}, false)
+	flow.stats.tls.add([]tlsRecord{{tlsRecordAlert, 2}}, true)
+	assert.Equal(t, []float64{0, 0, 1, 0, 1}, engine.extractFeatures(flow)[95:100])
+
+	anomalies := flow.Detail().Anomalies
+	require.NotNil(t, anomalies)
+	assert.Equal(t, ProtocolAnomalies{TLSAlerts: 1, TCPDataAfterClose: 1}, *anomalies)
+}
@@ -0,0 +1,273 @@
+package argus
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+)
+
+const (
+	// baselineFeatureExtractor names the baseline features, which engines
+	// with baselining enabled add after the registered extractors
+	baselineFeatureExtractor = "baseline"
+	// baselineFeatureCount is the number of baseline features
+	baselineFeatureCount = 3
+	// baselineMinFlows is the weight of flows a source's baseline needs
+	// before flows are compared to it
+	baselineMinFlows = 20
+	// baselineRateScale is the rate deviation, in standard deviations,
+	// that counts as fully anomalous in the score
+	baselineRateScale = 6
+	// maxBaselineSources bounds the sources whose baselines are kept
+	maxBaselineSources = 65536
+	// maxBaselineProtocols bounds the protocols counted per source
+	maxBaselineProtocols = 32
+	// ephemeralPortStart is the first port of the dynamic range, whose
+	// services are counted together in the protocol mix
+	ephemeralPortStart = 49152
+)
+
+// BaselineDeviation is how a flow departs from what its source usually
+// does, as learned from the flows the source started before it
+type BaselineDeviation struct {
+	Source string `json:"source"` // address or subnet the baseline is kept for
+	// RateDeviation is how many standard deviations the flows the source
+	// started in the current minute are above its usual rate
+	RateDeviation float64 `json:"rate_deviation"`
+	// HourRarity is 0 for the hour of day the source is most active in,
+	// and 1 for hours it was never active in
+	HourRarity float64 `json:"hour_rarity"`
+	// ProtocolNovelty is 0 for the source's most used protocol and port,
+	// and 1 for ones it never used
+	ProtocolNovelty float64 `json:"protocol_novelty"`
+	// Score is the mean of the three deviations, the rate deviation scaled
+	// to [0, 1]
+	Score float64 `json:"score"`
+}
+
+// baselineMonitor learns the normal behavior of each source of flows: the
+// rate it starts flows at, the hours of day it is active in, and the
+// protocols it speaks. Observations fade exponentially over the window, so
+// the baseline follows slow changes while bursts, activity at odd hours
+// and protocols a source never used stand out.
+type baselineMonitor struct {
+	window     time.Duration
+	prefix4    net.IPMask
+	prefix6    net.IPMask
+	minFlows   float64
+	maxSources int
+	mu         sync.Mutex
+	sources    map[string]*sourceBaseline
+}
+
+// sourceBaseline holds the decayed observations of one source
+type sourceBaseline struct {
+	updated time.Time // observations were last decayed to this time
+	flows   float64
+
+	// Flows started in the minute being counted, and the decayed moments
+	// of the flows per minute of earlier minutes the source was active in
+	minute          time.Time
+	current         float64
+	rateWeight      float64
+	rateSum, rateSq float64
+
+	hours     [24]float64 // flows by hour of day, UTC
+	protocols map[string]float64
+}
+
+// newBaselineMonitor creates a monitor learning over window, keeping a
+// baseline per IPv4 and IPv6 subnet of the given prefix lengths
+func newBaselineMonitor(window time.Duration, prefix4, prefix6 int) *baselineMonitor {
+	return &baselineMonitor{
+		window:     window,
+		prefix4:    net.CIDRMask(prefix4, 32),
+		prefix6:    net.CIDRMask(prefix6, 128),
+		minFlows:   baselineMinFlows,
+		maxSources: maxBaselineSources,
+		sources:    make(map[string]*sourceBaseline),
+	}
+}
+
+// observe records a flow a source started at and returns how it deviates
+// from the source's baseline, or nil while the baseline is still learned
+func (m *baselineMonitor) observe(src net.IP, transport string, port uint16, at time.Time) *BaselineDeviation {
+	if src == nil {
+		return nil
+	}
+	key := m.sourceKey(src)
+	protocol := serviceKey(transport, port)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.sources[key]
+	if b == nil {
+		if len(m.sources) >= m.maxSources {
+			m.prune(at)
+		}
+		if len(m.sources) >= m.maxSources {
+			// Too many active sources to learn this one
+			return nil
+		}
+		b = &sourceBaseline{updated: at, minute: at.Truncate(time.Minute), protocols: make(map[string]float64)}
+		m.sources[key] = b
+	}
+
+	b.decay(at, m.window)
+	b.count(at)
+
+	var deviation *BaselineDeviation
+	if b.flows >= m.minFlows {
+		deviation = &BaselineDeviation{
+			Source:          key,
+			RateDeviation:   b.rateDeviation(),
+			HourRarity:      rarity(b.hours[at.UTC().Hour()], b.hours[:]),
+			ProtocolNovelty: b.protocolNovelty(protocol),
+		}
+		deviation.Score = (math.Min(deviation.RateDeviation/baselineRateScale, 1) +
+			deviation.HourRarity + deviation.ProtocolNovelty) / 3
+	}
+
+	b.flows++
+	b.hours[at.UTC().Hour()]++
+	if _, ok := b.protocols[protocol]; ok || len(b.protocols) < maxBaselineProtocols {
+		b.protocols[protocol]++
+	}
+	return deviation
+}
+
+// sourceKey returns the subnet a source's baseline is kept for
+func (m *baselineMonitor) sourceKey(src net.IP) string {
+	mask := m.prefix6
+	if v4 := src.To4(); v4 != nil {
+		src, mask = v4, m.prefix4
+	}
+	ones, _ := mask.Size()
+	return src.Mask(mask).String() + "/" + strconv.Itoa(ones)
+}
+
+// prune forgets the sources whose observations faded to less than a flow.
+// The caller holds m.mu.
+func (m *baselineMonitor) prune(now time.Time) {
+	for key, b := range m.sources {
+		if b.flows*math.Exp(-now.Sub(b.updated).Seconds()/m.window.Seconds()) < 1 {
+			delete(m.sources, key)
+		}
+	}
+}
+
+// serviceKey names the protocol of a flow in the protocol mix by its
+// transport and responder port
+func serviceKey(transport string, port uint16) string {
+	if port >= ephemeralPortStart {
+		return transport + "/ephemeral"
+	}
+	return transport + "/" + strconv.Itoa(int(port))
+}
+
+// decay fades the observations to now
+func (b *sourceBaseline) decay(now time.Time, window time.Duration) {
+	elapsed := now.Sub(b.updated)
+	if elapsed <= 0 {
+		return
+	}
+	f := math.Exp(-elapsed.Seconds() / window.Seconds())
+	b.flows *= f
+	b.rateWeight *= f
+	b.rateSum *= f
+	b.rateSq *= f
+	for i := range b.hours {
+		b.hours[i] *= f
+	}
+	for protocol, n := range b.protocols {
+		if n *= f; n < 0.01 {
+			delete(b.protocols, protocol)
+		} else {
+			b.protocols[protocol] = n
+		}
+	}
+	b.updated = now
+}
+
+// count adds a flow to the minute it started in, folding the minute before
+// into the rate moments once it is over
+func (b *sourceBaseline) count(at time.Time) {
+	if minute := at.Truncate(time.Minute); minute.After(b.minute) {
+		if b.current > 0 {
+			b.rateWeight++
+			b.rateSum += b.current
+			b.rateSq += b.current * b.current
+		}
+		b.minute, b.current = minute, 0
+	}
+	b.current++
+}
+
+// rateDeviation returns how far the current minute's flows are above the
+// mean of earlier active minutes, in standard deviations. The deviation is
+// taken to be at least that of a Poisson process of the same mean, so that
+// a source starting flows at a steady rate is not flagged for one more.
+func (b *sourceBaseline) rateDeviation() float64 {
+	if b.rateWeight == 0 {
+		return 0
+	}
+	mean := b.rateSum / b.rateWeight
+	deviation := math.Sqrt(math.Max(b.rateSq/b.rateWeight-mean*mean, mean))
+	return math.Max(b.current-mean, 0) / deviation
+}
+
+// protocolNovelty returns the rarity of a protocol among the source's
+// protocols
+func (b *sourceBaseline) protocolNovelty(protocol string) float64 {
+	counts := make([]float64, 0, len(b.protocols))
+	for _, n := range b.protocols {
+		counts = append(counts, n)
+	}
+	return rarity(b.protocols[protocol], counts)
+}
+
+// rarity returns 1 - n/max(counts): 0 for the most common value, 1 for
+// values never seen
+func rarity(n float64, counts []float64) float64 {
+	var most float64
+	for _, count := range counts {
+		most = math.Max(most, count)
+	}
+	if most == 0 {
+		return 1
+	}
+	return 1 - n/most
+}
+
+// baselineFeatures extracts the deviations of flows from the baselines of
+// their sources
+type baselineFeatures struct{}
+
+// Name implements FeatureExtractor
+func (baselineFeatures) Name() string { return baselineFeatureExtractor }
+
+// FeatureCount implements FeatureExtractor
+func (baselineFeatures) FeatureCount() int { return baselineFeatureCount }
+
+// Extract implements FeatureExtractor
+func (baselineFeatures) Extract(flow *Flow) []float64 {
+	features := make([]float64, baselineFeatureCount)
+	if d := flow.Baseline; d != nil {
+		features[0], features[1], features[2] = d.RateDeviation, d.HourRarity, d.ProtocolNovelty
+	}
+	return features
+}
+
+// Features implements FeatureDescriber
+func (baselineFeatures) Features() []ml.FeatureSpec {
+	return []ml.FeatureSpec{
+		{Name: "baseline_rate_deviation", Min: 0, Max: math.Inf(1)},
+		{Name: "baseline_hour_rarity", Min: 0, Max: 1},
+		{Name: "baseline_protocol_novelty", Min: 0, Max: 1},
+	}
+}
@@ -0,0 +1,122 @@
+package argus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+func TestBaselineMonitor(t *testing.T) {
+	m := newBaselineMonitor(7*24*time.Hour, 32, 64)
+	src := net.ParseIP("192.168.1.100")
+	start := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+
+	// Two HTTPS flows a minute for half an hour, learned silently until
+	// the baseline has enough flows
+	at := start
+	for i := 0; i < 60; i++ {
+		at = start.Add(time.Duration(i/2) * time.Minute)
+		if d := m.observe(src, "TCP", 443, at); i < baselineMinFlows {
+			assert.Nil(t, d)
+		}
+	}
+
+	// More of the same deviates in nothing
+	at = at.Add(time.Minute)
+	d := m.observe(src, "TCP", 443, at)
+	require.NotNil(t, d)
+	assert.Equal(t, "192.168.1.100/32", d.Source)
+	assert.Zero(t, d.RateDeviation)
+	assert.Zero(t, d.HourRarity)
+	assert.Zero(t, d.ProtocolNovelty)
+	assert.Zero(t, d.Score)
+
+	// A flow to a port never used, at night
+	night := start.Add(15 * time.Hour)
+	d = m.observe(src, "TCP", 22, night)
+	require.NotNil(t, d)
+	assert.Equal(t, 1.0, d.HourRarity)
+	assert.Equal(t, 1.0, d.ProtocolNovelty)
+	assert.InDelta(t, 2.0/3, d.Score, 1e-9)
+
+	// and a burst of them, which the baseline starts to learn
+	for i := 0; i < 30; i++ {
+		d = m.observe(src, "TCP", 22, night)
+	}
+	assert.Greater(t, d.RateDeviation, 10.0)
+	assert.Less(t, d.HourRarity, 1.0)
+	assert.Less(t, d.ProtocolNovelty, 1.0)
+
+	// Other sources have baselines of their own
+	assert.Nil(t, m.observe(net.ParseIP("192.168.1.101"), "TCP", 443, at))
+}
+
+func TestBaselineSourceKey(t *testing.T) {
+	m := newBaselineMonitor(time.Hour, 24, 64)
+	assert.Equal(t, "10.1.2.0/24", m.sourceKey(net.ParseIP("10.1.2.3")))
+	assert.Equal(t, "2001:db8:1:2::/64", m.sourceKey(net.ParseIP("2001:db8:1:2:a:b:c:d")))
+
+	assert.Equal(t, "TCP/443", serviceKey("TCP", 443))
+	assert.Equal(t, "UDP/ephemeral", serviceKey("UDP", 60000))
+}
+
+func TestBaselineMonitorBounded(t *testing.T) {
+	m := newBaselineMonitor(time.Hour, 32, 128)
+	m.maxSources = 2
+	start := time.Now()
+
+	m.observe(net.ParseIP("10.0.0.1"), "TCP", 443, start)
+	m.observe(net.ParseIP("10.0.0.2"), "TCP", 443, start)
+	m.observe(net.ParseIP("10.0.0.3"), "TCP", 443, start)
+	assert.Len(t, m.sources, 2)
+
+	// Sources that faded make room
+	m.observe(net.ParseIP("10.0.0.3"), "TCP", 443, start.Add(2*time.Hour))
+	assert.Len(t, m.sources, 1)
+	assert.Contains(t, m.sources, "10.0.0.3/32")
+}
+
+func TestEngineBaseline(t *testing.T) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.85})
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+
+	cfg := config.CaptureConfig{Simulation: true, BaselineEnabled: true, BaselineWindow: 168, BaselinePrefixV4: 32, BaselinePrefixV6: 64}
+	engine, err := NewEngine(cfg, cortexEngine)
+	require.NoError(t, err)
+	defer engine.Close()
+	engine.baseline.minFlows = 1
+
+	// The baseline features follow the others
+	assert.Equal(t, []FeatureSpan{
+		{Extractor: "flow", Offset: 0, Count: flowFeatureCount},
+		{Extractor: "baseline", Offset: flowFeatureCount, Count: baselineFeatureCount},
+	}, featureLayout(engine.extractors))
+	assert.Equal(t, "baseline_hour_rarity", engine.FeatureSchema().Names()[flowFeatureCount+1])
+
+	client, server := net.ParseIP("192.168.1.100"), net.ParseIP("8.8.8.8")
+	now := time.Now()
+	engine.addPacket(client, server, 40000, 443, "TCP", &Packet{Timestamp: now, Size: 100})
+	engine.addPacket(client, server, 40001, 8443, "TCP", &Packet{Timestamp: now, Size: 100})
+
+	flow, ok := engine.flows.get(engine.generateFlowID(client.String(), server.String(), 40001, 8443))
+	require.True(t, ok)
+	summary := flow.Summary()
+	require.NotNil(t, summary.Baseline)
+	assert.Equal(t, "192.168.1.100/32", summary.Baseline.Source)
+	assert.Equal(t, 1.0, summary.Baseline.ProtocolNovelty)
+	features := engine.extractFeatures(flow)
+	assert.Equal(t, 1.0, features[flowFeatureCount+2])
+
+	// The baseline features must fit
+	require.NoError(t, RegisterFeatureExtractor(constantFeatures{"large", FeatureSize - flowFeatureCount - 2, 1}))
+	defer UnregisterFeatureExtractor("large")
+	_, err = NewEngine(cfg, cortexEngine)
+	assert.Error(t, err)
+}
@@ -0,0 +1,373 @@
+package argus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+)
+
+// readTimeout bounds how long a pcap read blocks so shutdown is noticed promptly
+const readTimeout = 500 * time.Millisecond
+
+// captureSource is a packet capture backend feeding the flow table
+type captureSource interface {
+	// run delivers captured packets to the engine until ctx is cancelled
+	run(ctx context.Context)
+	// close releases the capture resources
+	close()
+}
+
+// filterSetter is implemented by capture backends that can replace their
+// BPF filter while running
+type filterSetter interface {
+	setFilter(filter string) error
+}
+
+// ErrInvalidFilter is returned when a BPF filter does not compile
+var ErrInvalidFilter = errors.New("invalid BPF filter")
+
+// ErrFilterUnsupported is returned when the capture backend cannot apply
+// BPF filters at runtime
+var ErrFilterUnsupported = errors.New("capture backend does not support runtime BPF filters")
+
+// newCaptureSource opens the capture backend selected in the configuration
+func (e *Engine) newCaptureSource() (captureSource, error) {
+	switch e.config.Backend {
+	case "", "pcap":
+		return e.openPcap()
+	case "ebpf":
+		return e.openEBPF()
+	case "afpacket":
+		return e.openAFPacket()
+	case "netflow":
+		return e.openNetFlow()
+	default:
+		return nil, fmt.Errorf("unsupported capture backend: %s", e.config.Backend)
+	}
+}
+
+// pcapSource captures packets with libpcap
+type pcapSource struct {
+	engine *Engine
+	handle *pcap.Handle
+}
+
+// openPcap opens the configured interface and applies the BPF filter
+func (e *Engine) openPcap() (*pcapSource, error) {
+	inactive, err := pcap.NewInactiveHandle(e.config.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture handle for %s: %w", e.config.Interface, err)
+	}
+	defer inactive.CleanUp()
+
+	if err := inactive.SetSnapLen(e.config.SnapLen); err != nil {
+		return nil, fmt.Errorf("failed to set snap length: %w", err)
+	}
+	if err := inactive.SetPromisc(e.config.Promiscuous); err != nil {
+		return nil, fmt.Errorf("failed to set promiscuous mode: %w", err)
+	}
+	if err := inactive.SetTimeout(readTimeout); err != nil {
+		return nil, fmt.Errorf("failed to set read timeout: %w", err)
+	}
+	if err := inactive.SetBufferSize(e.config.BufferSize); err != nil {
+		return nil, fmt.Errorf("failed to set buffer size: %w", err)
+	}
+
+	handle, err := inactive.Activate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to activate capture on %s: %w", e.config.Interface, err)
+	}
+
+	if e.config.BPFFilter != "" {
+		if err := handle.SetBPFFilter(e.config.BPFFilter); err != nil {
+			handle.Close()
+			return nil, fmt.Errorf("failed to apply BPF filter %q: %w", e.config.BPFFilter, err)
+		}
+	}
+
+	return &pcapSource{engine: e, handle: handle}, nil
+}
+
+// run reads packets from the pcap handle until ctx is cancelled
+func (s *pcapSource) run(ctx context.Context) {
+	linkType := s.handle.LinkType()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		data, ci, err := s.handle.ReadPacketData()
+		if err != nil {
+			if errors.Is(err, pcap.NextErrorTimeoutExpired) {
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				slog.Info("Packet capture reached end of input")
+				return
+			}
+			slog.Warn("Failed to read packet", "error", err)
+			continue
+		}
+
+		packet := gopacket.NewPacket(data, linkType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		packet.Metadata().CaptureInfo = ci
+		s.engine.handlePacket(packet)
+	}
+}
+
+// close closes the pcap handle
+func (s *pcapSource) close() {
+	s.handle.Close()
+}
+
+// setFilter replaces the BPF filter on the pcap handle
+func (s *pcapSource) setFilter(filter string) error {
+	return s.handle.SetBPFFilter(filter)
+}
+
+// SetBPFFilter validates a BPF filter and applies it to the running capture
+// without restarting it. An empty filter captures all traffic.
+func (e *Engine) SetBPFFilter(filter string) error {
+	e.filterMu.Lock()
+	defer e.filterMu.Unlock()
+
+	if filter != "" {
+		if _, err := pcap.CompileBPFFilter(e.linkType(), e.config.SnapLen, filter); err != nil {
+			return fmt.Errorf("%w %q: %v", ErrInvalidFilter, filter, err)
+		}
+	}
+
+	if e.source != nil {
+		setter, ok := e.source.(filterSetter)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrFilterUnsupported, e.config.Backend)
+		}
+		if err := setter.setFilter(filter); err != nil {
+			return fmt.Errorf("failed to apply BPF filter: %w", err)
+		}
+	}
+
+	slog.Info("BPF filter updated", "previous", e.config.BPFFilter, "filter", filter)
+	e.config.BPFFilter = filter
+
+	return nil
+}
+
+// BPFFilter returns the BPF filter currently applied to the capture
+func (e *Engine) BPFFilter() string {
+	e.filterMu.Lock()
+	defer e.filterMu.Unlock()
+	return e.config.BPFFilter
+}
+
+// linkType returns the link type of captured frames
+func (e *Engine) linkType() layers.LinkType {
+	if ps, ok := e.source.(*pcapSource); ok {
+		return ps.handle.LinkType()
+	}
+	return layers.LinkTypeEthernet
+}
+
+// handlePacket decodes the network and transport layers of a captured
+// packet and adds it to the flow table
+func (e *Engine) handlePacket(packet gopacket.Packet) {
+	// Fragments are reassembled first so sampling and flow assignment see
+	// whole datagrams
+	if e.defrag != nil {
+		packet = e.defragment(packet)
+		if packet == nil {
+			return
+		}
+	}
+
+	// Flagged flows are exported as captured, tunnel headers included
+	frame := packet.Data()
+
+	var tunnels []Tunnel
+	if e.decap != nil {
+		packet, tunnels = e.decap.decapsulate(packet)
+		if tunnels != nil && e.defrag != nil {
+			if packet = e.defragment(packet); packet == nil {
+				return
+			}
+		}
+	}
+
+	if !e.samplePacket() {
+		return
+	}
+
+	var srcIP, dstIP net.IP
+	var info *protocol.ProtocolInfo // message parsed from a datagram
+	var protocol string
+	headers := make(map[string]interface{})
+
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		srcIP, dstIP = ip.SrcIP, ip.DstIP
+		protocol = ip.Protocol.String()
+		headers["ttl"] = ip.TTL
+	case *layers.IPv6:
+		srcIP, dstIP = ip.SrcIP, ip.DstIP
+		next, extensions := skipExtensionHeaders(packet, ip)
+		protocol = next.String()
+		headers["ttl"] = ip.HopLimit
+		headers["flow_label"] = ip.FlowLabel
+		headers["ext_headers"] = extensions
+	default:
+		// Not an IP packet
+		return
+	}
+
+	var srcPort, dstPort uint16
+	var payload []byte
+	switch transport := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		srcPort, dstPort = uint16(transport.SrcPort), uint16(transport.DstPort)
+		protocol = "TCP"
+		headers["tcp_flags"] = tcpFlags(transport)
+		headers["window"] = transport.Window
+		headers["payload_size"] = len(transport.Payload)
+		payload = transport.Payload
+	case *layers.UDP:
+		srcPort, dstPort = uint16(transport.SrcPort), uint16(transport.DstPort)
+		protocol = "UDP"
+		headers["payload_size"] = len(transport.Payload)
+		payload = transport.Payload
+		quicHeaders(transport.Payload, headers)
+		if e.dns != nil && (srcPort == dnsPort || dstPort == dnsPort) {
+			// Responses are addressed to the client
+			info = e.dns.parse(transport.Payload, dstIP, packet.Metadata().Timestamp, headers)
+		}
+	}
+
+	metadata := packet.Metadata()
+	vlans := vlanIDs(packet)
+	pkt := &Packet{
+		Timestamp: metadata.Timestamp,
+		Size:      metadata.Length,
+		Protocol:  protocol,
+		Headers:   headers,
+		tunnels:   tunnels,
+		vlans:     vlans,
+		info:      info,
+		payload:   payload,
+	}
+	// Raw bytes are only retained when flagged flows may be exported
+	if e.exporter != nil {
+		pkt.Data = frame
+	}
+	e.addPacket(srcIP, dstIP, srcPort, dstPort, protocol, pkt)
+
+	// The flow exists now, so parsed stream data can be attached to it
+	if tcp, ok := packet.TransportLayer().(*layers.TCP); ok && e.streams != nil {
+		e.streams.assemble(packet.NetworkLayer().NetworkFlow(), tcp, metadata.Timestamp, e.vlanKey(vlans))
+	}
+
+	e.stats.mu.Lock()
+	e.stats.TotalPackets++
+	e.stats.LastPacket = metadata.Timestamp
+	e.stats.mu.Unlock()
+}
+
+// defragment passes packet through the defragmenter, returning nil while
+// its datagram is incomplete or when it was discarded as invalid
+func (e *Engine) defragment(packet gopacket.Packet) gopacket.Packet {
+	whole, err := e.defrag.defragment(packet)
+	if err != nil {
+		slog.Debug("Discarded IP fragment", "error", err)
+		e.stats.mu.Lock()
+		e.stats.DiscardedFragments++
+		e.stats.mu.Unlock()
+		return nil
+	}
+	if whole != nil && whole != packet {
+		e.stats.mu.Lock()
+		e.stats.ReassembledDatagrams++
+		e.stats.mu.Unlock()
+	}
+	return whole
+}
+
+// skipExtensionHeaders follows the IPv6 extension header chain of a packet
+// to the upper-layer protocol, returning it and the number of extension
+// headers passed
+func skipExtensionHeaders(packet gopacket.Packet, ip *layers.IPv6) (layers.IPProtocol, int) {
+	next := ip.NextHeader
+	var extensions int
+	for _, layer := range packet.Layers() {
+		switch ext := layer.(type) {
+		case *layers.IPv6HopByHop:
+			next = ext.NextHeader
+		case *layers.IPv6Routing:
+			next = ext.NextHeader
+		case *layers.IPv6Destination:
+			next = ext.NextHeader
+		case *layers.IPv6Fragment:
+			next = ext.NextHeader
+		default:
+			continue
+		}
+		extensions++
+	}
+	return next, extensions
+}
+
+// vlanIDs returns the 802.1Q and QinQ tags of a frame, outermost first
+func vlanIDs(packet gopacket.Packet) []uint16 {
+	var vlans []uint16
+	for _, layer := range packet.Layers() {
+		if tag, ok := layer.(*layers.Dot1Q); ok {
+			vlans = append(vlans, tag.VLANIdentifier)
+		}
+	}
+	return vlans
+}
+
+// quicHeaders records the QUIC header of a UDP payload in the packet
+// headers. Any payload with the fixed bit set reads as a short header, so
+// its spin bit only counts on flows that also carried a long header.
+func quicHeaders(payload []byte, headers map[string]interface{}) {
+	header, err := protocol.ParseQUICHeader(payload)
+	if err != nil {
+		return
+	}
+	if header.Long {
+		headers["quic_version"] = header.Version
+		headers["quic_packet_type"] = header.PacketType
+	} else {
+		headers["quic_spin"] = header.SpinBit
+	}
+}
+
+// tcpFlags renders the TCP flags of a segment, e.g. "SYN|ACK"
+func tcpFlags(tcp *layers.TCP) string {
+	var flags string
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{tcp.SYN, "SYN"}, {tcp.ACK, "ACK"}, {tcp.FIN, "FIN"}, {tcp.RST, "RST"},
+		{tcp.PSH, "PSH"}, {tcp.URG, "URG"}, {tcp.ECE, "ECE"}, {tcp.CWR, "CWR"},
+	} {
+		if !f.set {
+			continue
+		}
+		if flags != "" {
+			flags += "|"
+		}
+		flags += f.name
+	}
+	return flags
+}
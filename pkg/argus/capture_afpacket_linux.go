@@ -0,0 +1,271 @@
+//go:build linux
+
+package argus
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/sys/unix"
+)
+
+// afpacketSource captures packets from TPACKETv3 memory-mapped rings. With a
+// fanout group configured, several sockets share the interface and the kernel
+// hashes flows across them so each worker goroutine sees whole flows.
+type afpacketSource struct {
+	engine  *Engine
+	snapLen int
+	sockets []*afpacketSocket
+}
+
+// afpacketSocket is a single AF_PACKET socket with its receive ring
+type afpacketSocket struct {
+	fd        int
+	ring      []byte
+	blockSize int
+	numBlocks int
+	current   int
+}
+
+// openAFPacket opens the configured number of AF_PACKET sockets on the interface
+func (e *Engine) openAFPacket() (*afpacketSource, error) {
+	iface, err := net.InterfaceByName(e.config.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up interface %s: %w", e.config.Interface, err)
+	}
+
+	if e.config.FanoutWorkers > 1 && e.config.FanoutGroup == 0 {
+		return nil, fmt.Errorf("fanout_group is required when fanout_workers is greater than 1")
+	}
+
+	filter, err := compileSockFilter(e.config.BPFFilter, e.config.SnapLen)
+	if err != nil {
+		return nil, err
+	}
+
+	workers := e.config.FanoutWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	source := &afpacketSource{engine: e, snapLen: e.config.SnapLen}
+	for i := 0; i < workers; i++ {
+		sock, err := e.openAFPacketSocket(iface.Index, filter)
+		if err != nil {
+			source.close()
+			return nil, err
+		}
+		source.sockets = append(source.sockets, sock)
+	}
+
+	slog.Info("AF_PACKET capture opened",
+		"interface", e.config.Interface,
+		"block_size", e.config.BlockSize,
+		"frame_size", e.config.FrameSize,
+		"num_blocks", e.config.NumBlocks,
+		"fanout_group", e.config.FanoutGroup,
+		"workers", workers)
+
+	return source, nil
+}
+
+// openAFPacketSocket creates a TPACKETv3 socket bound to the interface
+func (e *Engine) openAFPacketSocket(ifindex int, filter []unix.SockFilter) (*afpacketSocket, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AF_PACKET socket: %w", err)
+	}
+
+	sock := &afpacketSocket{fd: fd, blockSize: e.config.BlockSize, numBlocks: e.config.NumBlocks}
+
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+		sock.close()
+		return nil, fmt.Errorf("failed to select TPACKETv3: %w", err)
+	}
+
+	if len(filter) > 0 {
+		if err := sock.attachFilter(filter); err != nil {
+			sock.close()
+			return nil, err
+		}
+	}
+
+	req := unix.TpacketReq3{
+		Block_size:     uint32(e.config.BlockSize),
+		Block_nr:       uint32(e.config.NumBlocks),
+		Frame_size:     uint32(e.config.FrameSize),
+		Frame_nr:       uint32(e.config.BlockSize / e.config.FrameSize * e.config.NumBlocks),
+		Retire_blk_tov: uint32(readTimeout / time.Millisecond),
+	}
+	if err := unix.SetsockoptTpacketReq3(fd, unix.SOL_PACKET, unix.PACKET_RX_RING, &req); err != nil {
+		sock.close()
+		return nil, fmt.Errorf("failed to set up receive ring: %w", err)
+	}
+
+	sock.ring, err = unix.Mmap(fd, 0, e.config.BlockSize*e.config.NumBlocks, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		sock.close()
+		return nil, fmt.Errorf("failed to map receive ring: %w", err)
+	}
+
+	addr := &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: ifindex}
+	if err := unix.Bind(fd, addr); err != nil {
+		sock.close()
+		return nil, fmt.Errorf("failed to bind AF_PACKET socket: %w", err)
+	}
+
+	if e.config.FanoutGroup != 0 {
+		fanout := e.config.FanoutGroup | unix.PACKET_FANOUT_HASH<<16
+		if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_FANOUT, fanout); err != nil {
+			sock.close()
+			return nil, fmt.Errorf("failed to join fanout group %d: %w", e.config.FanoutGroup, err)
+		}
+	}
+
+	return sock, nil
+}
+
+// compileSockFilter compiles a filter expression for Ethernet frames
+func compileSockFilter(expr string, snapLen int) ([]unix.SockFilter, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	instructions, err := pcap.CompileBPFFilter(layers.LinkTypeEthernet, snapLen, expr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile BPF filter %q: %w", expr, err)
+	}
+
+	filter := make([]unix.SockFilter, 0, len(instructions))
+	for _, ins := range instructions {
+		filter = append(filter, unix.SockFilter{Code: ins.Code, Jt: ins.Jt, Jf: ins.Jf, K: ins.K})
+	}
+	return filter, nil
+}
+
+// setFilter replaces the socket filter on every fanout socket
+func (s *afpacketSource) setFilter(expr string) error {
+	filter, err := compileSockFilter(expr, s.snapLen)
+	if err != nil {
+		return err
+	}
+
+	for _, sock := range s.sockets {
+		if len(filter) == 0 {
+			if err := sock.detachFilter(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := sock.attachFilter(filter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run consumes every socket in its own goroutine until ctx is cancelled
+func (s *afpacketSource) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, sock := range s.sockets {
+		wg.Add(1)
+		go func(sock *afpacketSocket) {
+			defer wg.Done()
+			sock.run(ctx, s.engine)
+		}(sock)
+	}
+	wg.Wait()
+}
+
+// close releases all sockets
+func (s *afpacketSource) close() {
+	for _, sock := range s.sockets {
+		sock.close()
+	}
+}
+
+// run walks the ring block by block, handing each block back to the
+// kernel once its packets have been processed
+func (s *afpacketSocket) run(ctx context.Context, engine *Engine) {
+	pollFDs := []unix.PollFd{{Fd: int32(s.fd), Events: unix.POLLIN | unix.POLLERR}}
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		block := s.ring[s.current*s.blockSize : (s.current+1)*s.blockSize]
+		desc := (*unix.TpacketBlockDesc)(unsafe.Pointer(&block[0]))
+		header := (*unix.TpacketHdrV1)(unsafe.Pointer(&desc.Hdr[0]))
+
+		if atomic.LoadUint32(&header.Block_status)&unix.TP_STATUS_USER == 0 {
+			if _, err := unix.Poll(pollFDs, int(readTimeout/time.Millisecond)); err != nil && err != unix.EINTR {
+				slog.Warn("Failed to poll AF_PACKET socket", "error", err)
+			}
+			continue
+		}
+
+		offset := int(header.Offset_to_first_pkt)
+		for i := uint32(0); i < header.Num_pkts; i++ {
+			frame := (*unix.Tpacket3Hdr)(unsafe.Pointer(&block[offset]))
+			start := offset + int(frame.Mac)
+
+			// Copy out of the ring, which is reused as soon as the block is released
+			data := append([]byte(nil), block[start:start+int(frame.Snaplen)]...)
+			packet := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+			packet.Metadata().CaptureInfo = gopacket.CaptureInfo{
+				Timestamp:     time.Unix(int64(frame.Sec), int64(frame.Nsec)),
+				CaptureLength: int(frame.Snaplen),
+				Length:        int(frame.Len),
+			}
+			engine.handlePacket(packet)
+
+			offset += int(frame.Next_offset)
+		}
+
+		atomic.StoreUint32(&header.Block_status, unix.TP_STATUS_KERNEL)
+		s.current = (s.current + 1) % s.numBlocks
+	}
+}
+
+// attachFilter attaches a classic BPF program, replacing any previous one
+func (s *afpacketSocket) attachFilter(filter []unix.SockFilter) error {
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.SetsockoptSockFprog(s.fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+		return fmt.Errorf("failed to attach BPF filter: %w", err)
+	}
+	return nil
+}
+
+// detachFilter removes the socket filter, if any
+func (s *afpacketSocket) detachFilter() error {
+	if err := unix.SetsockoptInt(s.fd, unix.SOL_SOCKET, unix.SO_DETACH_FILTER, 0); err != nil && err != unix.ENOENT {
+		return fmt.Errorf("failed to detach BPF filter: %w", err)
+	}
+	return nil
+}
+
+// close unmaps the ring and closes the socket
+func (s *afpacketSocket) close() {
+	if s.ring != nil {
+		unix.Munmap(s.ring)
+	}
+	unix.Close(s.fd)
+}
+
+// htons converts a 16-bit value to network byte order
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.NativeEndian.Uint16(b[:])
+}
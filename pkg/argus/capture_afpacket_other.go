@@ -0,0 +1,20 @@
+//go:build !linux
+
+package argus
+
+import (
+	"context"
+	"fmt"
+)
+
+// afpacketSource is only available on Linux
+type afpacketSource struct{}
+
+// openAFPacket reports that the AF_PACKET backend is unavailable on this platform
+func (e *Engine) openAFPacket() (*afpacketSource, error) {
+	return nil, fmt.Errorf("afpacket capture backend is only supported on linux")
+}
+
+func (s *afpacketSource) run(ctx context.Context) {}
+
+func (s *afpacketSource) close() {}
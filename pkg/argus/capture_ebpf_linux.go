@@ -0,0 +1,515 @@
+//go:build linux
+
+package argus
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/google/gopacket/layers"
+	"golang.org/x/sys/unix"
+)
+
+// ebpfSource captures packet metadata with an XDP program that publishes one
+// record per IP packet into a BPF ring buffer. Payloads never leave the
+// kernel, which keeps up with line rate where libpcap starts dropping.
+type ebpfSource struct {
+	engine *Engine
+
+	mapFD   int
+	progFD  int
+	linkFD  int
+	epollFD int
+
+	consumer []byte // consumer position page (read/write)
+	producer []byte // producer position page followed by the data pages (read-only)
+	data     []byte
+	mask     uint64
+
+	bootOffset int64 // wall clock minus CLOCK_MONOTONIC, in nanoseconds
+}
+
+// Layout of the record written by the XDP program
+const (
+	xdpEventSize     = 56
+	xdpEventTime     = 0  // u64, bpf_ktime_get_ns
+	xdpEventLength   = 8  // u32, frame length
+	xdpEventProtocol = 12 // u8
+	xdpEventFamily   = 13 // u8, 4 or 6
+	xdpEventSrcPort  = 14 // u16, network order
+	xdpEventDstPort  = 16 // u16, network order
+	xdpEventSrcIP    = 24 // 4 or 16 bytes, network order
+	xdpEventDstIP    = 40 // 4 or 16 bytes, network order
+)
+
+// Ring buffer record header flags
+const (
+	ringbufBusyBit    = 1 << 31
+	ringbufDiscardBit = 1 << 30
+	ringbufHeaderSize = 8
+)
+
+// openEBPF loads the XDP program, attaches it to the configured interface
+// and maps the ring buffer for reading
+func (e *Engine) openEBPF() (*ebpfSource, error) {
+	iface, err := net.InterfaceByName(e.config.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up interface %s: %w", e.config.Interface, err)
+	}
+
+	var xdpFlags uint32
+	switch e.config.XDPMode {
+	case "native":
+		xdpFlags = unix.XDP_FLAGS_DRV_MODE
+	case "generic":
+		xdpFlags = unix.XDP_FLAGS_SKB_MODE
+	default:
+		return nil, fmt.Errorf("unsupported XDP mode: %s", e.config.XDPMode)
+	}
+
+	pageSize := os.Getpagesize()
+	if e.config.RingSize <= 0 || e.config.RingSize%pageSize != 0 || e.config.RingSize&(e.config.RingSize-1) != 0 {
+		return nil, fmt.Errorf("ring size must be a power of two multiple of the page size, got %d", e.config.RingSize)
+	}
+
+	s := &ebpfSource{engine: e, mapFD: -1, progFD: -1, linkFD: -1, epollFD: -1}
+
+	if s.mapFD, err = bpfCreateRingbuf(uint32(e.config.RingSize)); err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to create ring buffer: %w", err)
+	}
+	if s.progFD, err = bpfLoadXDP(xdpCaptureProgram(s.mapFD)); err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to load XDP program: %w", err)
+	}
+	if s.linkFD, err = bpfLinkXDP(s.progFD, iface.Index, xdpFlags); err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to attach XDP program to %s: %w", e.config.Interface, err)
+	}
+
+	if err := s.mapRingbuf(e.config.RingSize); err != nil {
+		s.close()
+		return nil, err
+	}
+
+	if s.epollFD, err = unix.EpollCreate1(unix.EPOLL_CLOEXEC); err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to create epoll instance: %w", err)
+	}
+	event := unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(s.mapFD)}
+	if err := unix.EpollCtl(s.epollFD, unix.EPOLL_CTL_ADD, s.mapFD, &event); err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to watch ring buffer: %w", err)
+	}
+
+	var mono unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &mono); err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to read monotonic clock: %w", err)
+	}
+	s.bootOffset = time.Now().UnixNano() - mono.Nano()
+
+	slog.Info("XDP capture program attached",
+		"interface", e.config.Interface,
+		"mode", e.config.XDPMode,
+		"ring_size", e.config.RingSize)
+
+	return s, nil
+}
+
+// mapRingbuf maps the consumer, producer and data pages of a ring buffer
+// of the given size
+func (s *ebpfSource) mapRingbuf(size int) error {
+	pageSize := os.Getpagesize()
+
+	var err error
+	if s.consumer, err = unix.Mmap(s.mapFD, 0, pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED); err != nil {
+		return fmt.Errorf("failed to map ring buffer consumer page: %w", err)
+	}
+	// The data area is mapped twice back to back so records never wrap
+	if s.producer, err = unix.Mmap(s.mapFD, int64(pageSize), pageSize+2*size, unix.PROT_READ, unix.MAP_SHARED); err != nil {
+		return fmt.Errorf("failed to map ring buffer data pages: %w", err)
+	}
+	s.data = s.producer[pageSize:]
+	s.mask = uint64(size - 1)
+	return nil
+}
+
+// run drains the ring buffer until ctx is cancelled
+func (s *ebpfSource) run(ctx context.Context) {
+	events := make([]unix.EpollEvent, 1)
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.drain()
+
+		if _, err := unix.EpollWait(s.epollFD, events, int(readTimeout/time.Millisecond)); err != nil && err != unix.EINTR {
+			slog.Warn("Failed to wait for ring buffer", "error", err)
+		}
+	}
+}
+
+// drain consumes all committed records from the ring buffer
+func (s *ebpfSource) drain() {
+	consumerPos := (*uint64)(unsafe.Pointer(&s.consumer[0]))
+	producerPos := (*uint64)(unsafe.Pointer(&s.producer[0]))
+
+	cons := atomic.LoadUint64(consumerPos)
+	prod := atomic.LoadUint64(producerPos)
+
+	for cons < prod {
+		offset := cons & s.mask
+		header := atomic.LoadUint32((*uint32)(unsafe.Pointer(&s.data[offset])))
+		if header&ringbufBusyBit != 0 {
+			// Producer has reserved but not yet committed this record
+			break
+		}
+
+		length := uint64(header &^ (ringbufBusyBit | ringbufDiscardBit))
+		if header&ringbufDiscardBit == 0 && length >= xdpEventSize {
+			start := offset + ringbufHeaderSize
+			s.handleEvent(s.data[start : start+xdpEventSize])
+		}
+
+		cons += (length + ringbufHeaderSize + 7) &^ 7
+		atomic.StoreUint64(consumerPos, cons)
+	}
+}
+
+// handleEvent converts an XDP record into a packet
+func (s *ebpfSource) handleEvent(record []byte) {
+	if !s.engine.samplePacket() {
+		return
+	}
+
+	addrLen := net.IPv4len
+	if record[xdpEventFamily] == 6 {
+		addrLen = net.IPv6len
+	}
+	srcIP := net.IP(append([]byte(nil), record[xdpEventSrcIP:xdpEventSrcIP+addrLen]...))
+	dstIP := net.IP(append([]byte(nil), record[xdpEventDstIP:xdpEventDstIP+addrLen]...))
+	srcPort := binary.BigEndian.Uint16(record[xdpEventSrcPort:])
+	dstPort := binary.BigEndian.Uint16(record[xdpEventDstPort:])
+	timestamp := time.Unix(0, int64(binary.NativeEndian.Uint64(record[xdpEventTime:]))+s.bootOffset)
+
+	var protocol string
+	switch ipProto := layers.IPProtocol(record[xdpEventProtocol]); ipProto {
+	case layers.IPProtocolTCP:
+		protocol = "TCP"
+	case layers.IPProtocolUDP:
+		protocol = "UDP"
+	default:
+		protocol = ipProto.String()
+	}
+
+	s.engine.addPacket(srcIP, dstIP, srcPort, dstPort, protocol, &Packet{
+		Timestamp: timestamp,
+		Size:      int(binary.NativeEndian.Uint32(record[xdpEventLength:])),
+		Protocol:  protocol,
+		Headers:   make(map[string]interface{}),
+	})
+
+	s.engine.stats.mu.Lock()
+	s.engine.stats.TotalPackets++
+	s.engine.stats.LastPacket = timestamp
+	s.engine.stats.mu.Unlock()
+}
+
+// close detaches the XDP program and releases all kernel resources
+func (s *ebpfSource) close() {
+	if s.producer != nil {
+		unix.Munmap(s.producer)
+	}
+	if s.consumer != nil {
+		unix.Munmap(s.consumer)
+	}
+	for _, fd := range []int{s.epollFD, s.linkFD, s.progFD, s.mapFD} {
+		if fd >= 0 {
+			unix.Close(fd)
+		}
+	}
+}
+
+// eBPF instruction encoding, see include/uapi/linux/bpf.h
+const (
+	bpfClassLD    = 0x00
+	bpfClassLDX   = 0x01
+	bpfClassST    = 0x02
+	bpfClassSTX   = 0x03
+	bpfClassJMP   = 0x05
+	bpfClassALU64 = 0x07
+
+	bpfSizeW  = 0x00
+	bpfSizeH  = 0x08
+	bpfSizeB  = 0x10
+	bpfSizeDW = 0x18
+
+	bpfModeIMM = 0x00
+	bpfModeMEM = 0x60
+
+	bpfSrcK = 0x00
+	bpfSrcX = 0x08
+
+	bpfOpAdd  = 0x00
+	bpfOpSub  = 0x10
+	bpfOpLsh  = 0x60
+	bpfOpAnd  = 0x50
+	bpfOpMov  = 0xb0
+	bpfOpJA   = 0x00
+	bpfOpJEQ  = 0x10
+	bpfOpJGT  = 0x20
+	bpfOpJNE  = 0x50
+	bpfOpCall = 0x80
+	bpfOpExit = 0x90
+
+	bpfFuncKtimeGetNs    = 5
+	bpfFuncRingbufOutput = 130
+
+	xdpPass = 2
+)
+
+// bpfInsn is a single eBPF instruction
+type bpfInsn struct {
+	Code uint8
+	Regs uint8 // dst in the low nibble, src in the high nibble
+	Off  int16
+	Imm  int32
+}
+
+// bpfAssembler builds an instruction stream with symbolic jump targets
+type bpfAssembler struct {
+	insns  []bpfInsn
+	labels map[string]int
+	jumps  map[int]string
+}
+
+func newBPFAssembler() *bpfAssembler {
+	return &bpfAssembler{labels: make(map[string]int), jumps: make(map[int]string)}
+}
+
+func (a *bpfAssembler) emit(code, dst, src uint8, off int16, imm int32) {
+	a.insns = append(a.insns, bpfInsn{Code: code, Regs: dst | src<<4, Off: off, Imm: imm})
+}
+
+func (a *bpfAssembler) jump(op, dst uint8, imm int32, target string) {
+	a.jumps[len(a.insns)] = target
+	a.emit(bpfClassJMP|op|bpfSrcK, dst, 0, 0, imm)
+}
+
+func (a *bpfAssembler) jumpReg(op, dst, src uint8, target string) {
+	a.jumps[len(a.insns)] = target
+	a.emit(bpfClassJMP|op|bpfSrcX, dst, src, 0, 0)
+}
+
+func (a *bpfAssembler) label(name string) {
+	a.labels[name] = len(a.insns)
+}
+
+func (a *bpfAssembler) assemble() []bpfInsn {
+	for idx, target := range a.jumps {
+		a.insns[idx].Off = int16(a.labels[target] - idx - 1)
+	}
+	return a.insns
+}
+
+// xdpCaptureProgram returns an XDP program that emits an xdpEvent for every
+// IPv4 and IPv6 frame into the ring buffer identified by mapFD and always passes the
+// frame on to the kernel stack
+func xdpCaptureProgram(mapFD int) []bpfInsn {
+	const (
+		r0, r1, r2, r3, r4, r5, r6, r7, r8, r10 = 0, 1, 2, 3, 4, 5, 6, 7, 8, 10
+		event                                   = -xdpEventSize // stack offset of the record
+	)
+	a := newBPFAssembler()
+
+	// r2 = xdp_md->data, r3 = xdp_md->data_end
+	a.emit(bpfClassLDX|bpfSizeW|bpfModeMEM, r2, r1, 0, 0)
+	a.emit(bpfClassLDX|bpfSizeW|bpfModeMEM, r3, r1, 4, 0)
+
+	// Zero the record on the stack
+	for off := int16(event); off < 0; off += 8 {
+		a.emit(bpfClassST|bpfSizeDW|bpfModeMEM, r10, 0, off, 0)
+	}
+
+	// record.length = data_end - data
+	a.emit(bpfClassALU64|bpfOpMov|bpfSrcX, r4, r3, 0, 0)
+	a.emit(bpfClassALU64|bpfOpSub|bpfSrcX, r4, r2, 0, 0)
+	a.emit(bpfClassSTX|bpfSizeW|bpfModeMEM, r10, r4, event+xdpEventLength, 0)
+
+	// Need Ethernet + minimal IPv4 header (34 bytes), then dispatch on the
+	// EtherType, loaded little-endian
+	a.emit(bpfClassALU64|bpfOpMov|bpfSrcX, r4, r2, 0, 0)
+	a.emit(bpfClassALU64|bpfOpAdd|bpfSrcK, r4, 0, 0, 34)
+	a.jumpReg(bpfOpJGT, r4, r3, "pass")
+	a.emit(bpfClassLDX|bpfSizeH|bpfModeMEM, r5, r2, 12, 0)
+	a.jump(bpfOpJEQ, r5, 0xdd86, "ipv6") // 0x86dd
+	a.jump(bpfOpJNE, r5, 0x0008, "pass") // 0x0800
+
+	// IPv4 protocol and addresses
+	a.emit(bpfClassST|bpfSizeB|bpfModeMEM, r10, 0, event+xdpEventFamily, 4)
+	a.emit(bpfClassLDX|bpfSizeB|bpfModeMEM, r5, r2, 23, 0)
+	a.emit(bpfClassSTX|bpfSizeB|bpfModeMEM, r10, r5, event+xdpEventProtocol, 0)
+	a.emit(bpfClassLDX|bpfSizeW|bpfModeMEM, r7, r2, 26, 0)
+	a.emit(bpfClassSTX|bpfSizeW|bpfModeMEM, r10, r7, event+xdpEventSrcIP, 0)
+	a.emit(bpfClassLDX|bpfSizeW|bpfModeMEM, r7, r2, 30, 0)
+	a.emit(bpfClassSTX|bpfSizeW|bpfModeMEM, r10, r7, event+xdpEventDstIP, 0)
+
+	// r4 = transport header, after the variable-length IPv4 header
+	a.emit(bpfClassLDX|bpfSizeB|bpfModeMEM, r7, r2, 14, 0)
+	a.emit(bpfClassALU64|bpfOpAnd|bpfSrcK, r7, 0, 0, 0x0f)
+	a.emit(bpfClassALU64|bpfOpLsh|bpfSrcK, r7, 0, 0, 2)
+	a.emit(bpfClassALU64|bpfOpMov|bpfSrcX, r4, r2, 0, 0)
+	a.emit(bpfClassALU64|bpfOpAdd|bpfSrcX, r4, r7, 0, 0)
+	a.emit(bpfClassALU64|bpfOpAdd|bpfSrcK, r4, 0, 0, 14)
+	a.jump(bpfOpJA, 0, 0, "transport")
+
+	// IPv6 needs the full 54 bytes of Ethernet and fixed header. Extension
+	// headers are not walked, so their packets are emitted without ports
+	a.label("ipv6")
+	a.emit(bpfClassALU64|bpfOpMov|bpfSrcX, r4, r2, 0, 0)
+	a.emit(bpfClassALU64|bpfOpAdd|bpfSrcK, r4, 0, 0, 54)
+	a.jumpReg(bpfOpJGT, r4, r3, "pass")
+	a.emit(bpfClassST|bpfSizeB|bpfModeMEM, r10, 0, event+xdpEventFamily, 6)
+	a.emit(bpfClassLDX|bpfSizeB|bpfModeMEM, r5, r2, 20, 0)
+	a.emit(bpfClassSTX|bpfSizeB|bpfModeMEM, r10, r5, event+xdpEventProtocol, 0)
+	for off := int16(0); off < 16; off += 4 {
+		a.emit(bpfClassLDX|bpfSizeW|bpfModeMEM, r7, r2, 22+off, 0)
+		a.emit(bpfClassSTX|bpfSizeW|bpfModeMEM, r10, r7, event+xdpEventSrcIP+off, 0)
+		a.emit(bpfClassLDX|bpfSizeW|bpfModeMEM, r7, r2, 38+off, 0)
+		a.emit(bpfClassSTX|bpfSizeW|bpfModeMEM, r10, r7, event+xdpEventDstIP+off, 0)
+	}
+
+	// Ports for TCP and UDP at r4
+	a.label("transport")
+	a.jump(bpfOpJEQ, r5, int32(layers.IPProtocolTCP), "ports")
+	a.jump(bpfOpJNE, r5, int32(layers.IPProtocolUDP), "emit")
+	a.label("ports")
+	a.emit(bpfClassALU64|bpfOpMov|bpfSrcX, r8, r4, 0, 0)
+	a.emit(bpfClassALU64|bpfOpAdd|bpfSrcK, r8, 0, 0, 4)
+	a.jumpReg(bpfOpJGT, r8, r3, "emit")
+	a.emit(bpfClassLDX|bpfSizeH|bpfModeMEM, r8, r4, 0, 0)
+	a.emit(bpfClassSTX|bpfSizeH|bpfModeMEM, r10, r8, event+xdpEventSrcPort, 0)
+	a.emit(bpfClassLDX|bpfSizeH|bpfModeMEM, r8, r4, 2, 0)
+	a.emit(bpfClassSTX|bpfSizeH|bpfModeMEM, r10, r8, event+xdpEventDstPort, 0)
+
+	// record.timestamp = bpf_ktime_get_ns(); bpf_ringbuf_output(map, &record, size, 0)
+	a.label("emit")
+	a.emit(bpfClassJMP|bpfOpCall, 0, 0, 0, bpfFuncKtimeGetNs)
+	a.emit(bpfClassSTX|bpfSizeDW|bpfModeMEM, r10, r0, event+xdpEventTime, 0)
+	a.emit(bpfClassLD|bpfSizeDW|bpfModeIMM, r1, unix.BPF_PSEUDO_MAP_FD, 0, int32(mapFD))
+	a.emit(0, 0, 0, 0, 0) // second half of the 64-bit immediate load
+	a.emit(bpfClassALU64|bpfOpMov|bpfSrcX, r2, r10, 0, 0)
+	a.emit(bpfClassALU64|bpfOpAdd|bpfSrcK, r2, 0, 0, event)
+	a.emit(bpfClassALU64|bpfOpMov|bpfSrcK, r3, 0, 0, xdpEventSize)
+	a.emit(bpfClassALU64|bpfOpMov|bpfSrcK, r4, 0, 0, 0)
+	a.emit(bpfClassJMP|bpfOpCall, 0, 0, 0, bpfFuncRingbufOutput)
+
+	a.label("pass")
+	a.emit(bpfClassALU64|bpfOpMov|bpfSrcK, r0, 0, 0, xdpPass)
+	a.emit(bpfClassJMP|bpfOpExit, 0, 0, 0, 0)
+
+	return a.assemble()
+}
+
+// bpf issues a bpf(2) syscall and returns the resulting file descriptor
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// bpfCreateRingbuf creates a BPF_MAP_TYPE_RINGBUF map of the given size
+func bpfCreateRingbuf(size uint32) (int, error) {
+	attr := struct {
+		MapType    uint32
+		KeySize    uint32
+		ValueSize  uint32
+		MaxEntries uint32
+	}{
+		MapType:    unix.BPF_MAP_TYPE_RINGBUF,
+		MaxEntries: size,
+	}
+	return bpf(unix.BPF_MAP_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+// bpfLoadXDP loads an XDP program, returning the verifier log on failure
+func bpfLoadXDP(insns []bpfInsn) (int, error) {
+	license := []byte("GPL\x00")
+	verifierLog := make([]byte, 64*1024)
+
+	attr := struct {
+		ProgType           uint32
+		InsnCnt            uint32
+		Insns              uint64
+		License            uint64
+		LogLevel           uint32
+		LogSize            uint32
+		LogBuf             uint64
+		KernVersion        uint32
+		ProgFlags          uint32
+		ProgName           [16]byte
+		ProgIfindex        uint32
+		ExpectedAttachType uint32
+	}{
+		ProgType:           unix.BPF_PROG_TYPE_XDP,
+		InsnCnt:            uint32(len(insns)),
+		Insns:              uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		License:            uint64(uintptr(unsafe.Pointer(&license[0]))),
+		LogLevel:           1,
+		LogSize:            uint32(len(verifierLog)),
+		LogBuf:             uint64(uintptr(unsafe.Pointer(&verifierLog[0]))),
+		ExpectedAttachType: unix.BPF_XDP,
+	}
+	copy(attr.ProgName[:], "argus_capture")
+
+	fd, err := bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if err != nil {
+		if n := indexNUL(verifierLog); n > 0 {
+			return -1, fmt.Errorf("%w: %s", err, verifierLog[:n])
+		}
+		return -1, err
+	}
+	return fd, nil
+}
+
+// bpfLinkXDP attaches an XDP program to an interface through a BPF link,
+// which detaches automatically when the link descriptor is closed
+func bpfLinkXDP(progFD, ifindex int, flags uint32) (int, error) {
+	attr := struct {
+		ProgFD     uint32
+		TargetFD   uint32
+		AttachType uint32
+		Flags      uint32
+	}{
+		ProgFD:     uint32(progFD),
+		TargetFD:   uint32(ifindex),
+		AttachType: unix.BPF_XDP,
+		Flags:      flags,
+	}
+	return bpf(unix.BPF_LINK_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+// indexNUL returns the length of a NUL-terminated byte string
+func indexNUL(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
@@ -0,0 +1,141 @@
+//go:build linux
+
+package argus
+
+import (
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"unsafe"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+// errNotSupported is the kernel-internal ENOTSUPP, returned by program types
+// without test run support
+const errNotSupported = unix.Errno(524)
+
+// bpfTestRun runs a loaded XDP program once over frame and returns its
+// verdict
+func bpfTestRun(progFD int, frame []byte) (uint32, error) {
+	attr := struct {
+		ProgFD      uint32
+		Retval      uint32
+		DataSizeIn  uint32
+		DataSizeOut uint32
+		DataIn      uint64
+		DataOut     uint64
+		Repeat      uint32
+		Duration    uint32
+	}{
+		ProgFD:     uint32(progFD),
+		DataSizeIn: uint32(len(frame)),
+		DataIn:     uint64(uintptr(unsafe.Pointer(&frame[0]))),
+		Repeat:     1,
+	}
+	_, err := bpf(unix.BPF_PROG_TEST_RUN, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(frame)
+	return attr.Retval, err
+}
+
+// serializeFrame encodes layers into an Ethernet frame
+func serializeFrame(t *testing.T, l ...gopacket.SerializableLayer) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	require.NoError(t, gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, l...))
+	return buf.Bytes()
+}
+
+// TestXDPCaptureProgram loads the hand-assembled capture program through the
+// kernel verifier and checks the records it emits for test frames. It needs
+// CAP_BPF and is skipped without it.
+func TestXDPCaptureProgram(t *testing.T) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.85})
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+
+	engine, err := NewEngine(config.CaptureConfig{Simulation: true}, cortexEngine)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	s := &ebpfSource{engine: engine, mapFD: -1, progFD: -1, linkFD: -1, epollFD: -1}
+	defer s.close()
+
+	ringSize := os.Getpagesize()
+	s.mapFD, err = bpfCreateRingbuf(uint32(ringSize))
+	if errors.Is(err, unix.EPERM) || errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EINVAL) {
+		t.Skipf("BPF ring buffers unavailable: %v", err)
+	}
+	require.NoError(t, err)
+
+	// A rejected program fails with the verifier log in the error
+	s.progFD, err = bpfLoadXDP(xdpCaptureProgram(s.mapFD))
+	if errors.Is(err, unix.EPERM) {
+		t.Skipf("loading XDP programs not permitted: %v", err)
+	}
+	require.NoError(t, err)
+	require.NoError(t, s.mapRingbuf(ringSize))
+
+	eth := func(etherType layers.EthernetType) *layers.Ethernet {
+		return &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+			EthernetType: etherType,
+		}
+	}
+
+	// IPv4 with options, so the TCP header follows a 24-byte IP header
+	ip4 := &layers.IPv4{
+		Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP,
+		SrcIP: net.ParseIP("192.0.2.1"), DstIP: net.ParseIP("198.51.100.1"),
+		Options: []layers.IPv4Option{{OptionType: 148, OptionLength: 4, OptionData: []byte{0, 0}}},
+	}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 443, SYN: true, Window: 1024}
+	require.NoError(t, tcp.SetNetworkLayerForChecksum(ip4))
+	tcpFrame := serializeFrame(t, eth(layers.EthernetTypeIPv4), ip4, tcp)
+
+	ip6 := &layers.IPv6{
+		Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2"),
+	}
+	udp := &layers.UDP{SrcPort: 5353, DstPort: 53}
+	require.NoError(t, udp.SetNetworkLayerForChecksum(ip6))
+	udpFrame := serializeFrame(t, eth(layers.EthernetTypeIPv6), ip6, udp, gopacket.Payload("query"))
+
+	// Neither a non-IP frame nor a truncated IPv4 header emits a record
+	arpFrame := serializeFrame(t, eth(layers.EthernetTypeARP), gopacket.Payload(make([]byte, 28)))
+	truncatedFrame := tcpFrame[:30]
+
+	for _, frame := range [][]byte{tcpFrame, udpFrame, arpFrame, truncatedFrame} {
+		verdict, err := bpfTestRun(s.progFD, frame)
+		if errors.Is(err, errNotSupported) || errors.Is(err, unix.EINVAL) {
+			t.Skipf("XDP test runs unavailable: %v", err)
+		}
+		require.NoError(t, err)
+		assert.Equal(t, uint32(xdpPass), verdict)
+	}
+
+	s.drain()
+	assert.Equal(t, int64(2), engine.GetStatistics().TotalPackets)
+	assert.Equal(t, 2, engine.flows.len())
+
+	flow, ok := engine.flows.get(engine.generateFlowID("192.0.2.1", "198.51.100.1", 40000, 443))
+	require.True(t, ok)
+	assert.Equal(t, "TCP", flow.Protocol)
+	require.Len(t, flow.Packets, 1)
+	assert.Equal(t, len(tcpFrame), flow.Packets[0].Size)
+
+	flow, ok = engine.flows.get(engine.generateFlowID("2001:db8::1", "2001:db8::2", 5353, 53))
+	require.True(t, ok)
+	assert.Equal(t, "UDP", flow.Protocol)
+	require.Len(t, flow.Packets, 1)
+	assert.Equal(t, len(udpFrame), flow.Packets[0].Size)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package argus
+
+import (
+	"context"
+	"fmt"
+)
+
+// ebpfSource is only available on Linux
+type ebpfSource struct{}
+
+// openEBPF reports that the eBPF backend is unavailable on this platform
+func (e *Engine) openEBPF() (*ebpfSource, error) {
+	return nil, fmt.Errorf("ebpf capture backend is only supported on linux")
+}
+
+func (s *ebpfSource) run(ctx context.Context) {}
+
+func (s *ebpfSource) close() {}
@@ -0,0 +1,302 @@
+package argus
+
+import (
+	"fmt"
+	"hash/maphash"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+const (
+	// ddosModel is the ModelUsed of detections raised by the DDoS
+	// heuristics
+	ddosModel = "ddos-heuristic"
+	// maxDDoSAddresses bounds the destinations and sources whose counts are
+	// kept
+	maxDDoSAddresses = 65536
+	// ddosShardCount is the number of independently locked shards of the
+	// counts
+	ddosShardCount = 64
+)
+
+// amplificationPorts are the UDP services commonly abused to reflect
+// amplified traffic at a spoofed victim
+var amplificationPorts = map[uint16]string{
+	19:    "chargen",
+	53:    "DNS",
+	123:   "NTP",
+	161:   "SNMP",
+	389:   "CLDAP",
+	1900:  "SSDP",
+	3702:  "WS-Discovery",
+	11211: "memcached",
+}
+
+// ddosAttack is a kind of attack the DDoS heuristics recognize
+type ddosAttack int
+
+const (
+	synFlood ddosAttack = iota
+	connectionSpike
+	amplification
+	ddosAttacks
+)
+
+func (a ddosAttack) String() string {
+	switch a {
+	case synFlood:
+		return "SYN flood"
+	case connectionSpike:
+		return "connection rate spike"
+	case amplification:
+		return "UDP amplification"
+	}
+	return "unknown"
+}
+
+// ddosAlert is an attack the heuristics recognized, at rate per second
+// against a threshold of limit
+type ddosAlert struct {
+	attack ddosAttack
+	target net.IP // the destination flooded, or the source opening flows
+	rate   float64
+	limit  float64
+}
+
+// ddosDetector counts packets and new flows per address over fixed windows
+// as they are captured. Since it needs no packets per flow, it recognizes
+// floods of flows too short for Cortex to ever analyze. It alerts at most
+// once per attack and address within a window. Like the flow table, the
+// counts are split into shards by address, so capture workers counting
+// different addresses rarely contend, and each shard holds an equal share
+// of the addresses.
+type ddosDetector struct {
+	window              time.Duration
+	synRate             float64
+	connectionRate      float64
+	amplificationRate   float64
+	amplificationFactor float64
+	maxPerShard         int
+
+	seed   maphash.Seed
+	shards [ddosShardCount]ddosShard
+}
+
+// ddosShard is a single locked partition of the counts
+type ddosShard struct {
+	mu           sync.Mutex
+	destinations map[netip.Addr]*ddosCounts
+	sources      map[netip.Addr]*ddosCounts
+}
+
+// ddosCounts are the counts of an address within its current window
+type ddosCounts struct {
+	start       time.Time
+	syns        int // received without ACK
+	acks        int // received without SYN
+	connections int // flows opened
+	amplified   int // bytes received from amplification services
+	requests    int // bytes sent to amplification services
+	alerted     [ddosAttacks]bool
+}
+
+// newDDoSDetector creates a detector of the configured thresholds
+func newDDoSDetector(cfg config.DDoSConfig) *ddosDetector {
+	d := &ddosDetector{
+		window:              time.Duration(cfg.Window) * time.Second,
+		synRate:             cfg.SYNRate,
+		connectionRate:      cfg.ConnectionRate,
+		amplificationRate:   cfg.AmplificationRate,
+		amplificationFactor: cfg.AmplificationFactor,
+		maxPerShard:         maxDDoSAddresses / ddosShardCount,
+		seed:                maphash.MakeSeed(),
+	}
+	for i := range d.shards {
+		d.shards[i].destinations = make(map[netip.Addr]*ddosCounts)
+		d.shards[i].sources = make(map[netip.Addr]*ddosCounts)
+	}
+	return d
+}
+
+// shard returns the shard counting an address
+func (d *ddosDetector) shard(addr netip.Addr) *ddosShard {
+	b := addr.As16()
+	return &d.shards[maphash.Bytes(d.seed, b[:])%ddosShardCount]
+}
+
+// observe counts a packet from src to dst, which opened its flow if
+// opened, and returns the attacks it tips over their threshold
+func (d *ddosDetector) observe(src, dst net.IP, srcPort, dstPort uint16, packet *Packet, opened bool) []ddosAlert {
+	weight := packet.weight()
+	at := packet.Timestamp
+	srcAddr, _ := netip.AddrFromSlice(src)
+	dstAddr, _ := netip.AddrFromSlice(dst)
+	srcAddr, dstAddr = srcAddr.Unmap(), dstAddr.Unmap()
+
+	var alerts []ddosAlert
+	if opened {
+		d.update(srcAddr, true, at, func(counts *ddosCounts) {
+			counts.connections += weight
+			alerts = d.check(alerts, counts, connectionSpike, src, counts.connections, d.connectionRate)
+		})
+	}
+
+	switch packet.Protocol {
+	case "TCP":
+		flags, _ := packet.Headers["tcp_flags"].(string)
+		syn, ack := strings.Contains(flags, "SYN"), strings.Contains(flags, "ACK")
+		if syn == ack {
+			// SYN-ACKs and packets without either flag say nothing about
+			// the handshakes a destination completes
+			break
+		}
+		d.update(dstAddr, false, at, func(counts *ddosCounts) {
+			if syn {
+				counts.syns += weight
+			} else {
+				counts.acks += weight
+			}
+			if 2*counts.acks < counts.syns {
+				alerts = d.check(alerts, counts, synFlood, dst, counts.syns, d.synRate)
+			}
+		})
+	case "UDP":
+		if _, ok := amplificationPorts[dstPort]; ok {
+			d.update(srcAddr, false, at, func(counts *ddosCounts) {
+				counts.requests += packet.Size * weight
+			})
+		}
+		if _, ok := amplificationPorts[srcPort]; ok {
+			d.update(dstAddr, false, at, func(counts *ddosCounts) {
+				counts.amplified += packet.Size * weight
+				if float64(counts.amplified) >= d.amplificationFactor*float64(counts.requests) {
+					alerts = d.check(alerts, counts, amplification, dst, counts.amplified, d.amplificationRate)
+				}
+			})
+		}
+	}
+	return alerts
+}
+
+// update calls fn with the counts of addr as a source or destination for
+// the window at is in, under the lock of its shard. fn is not called when
+// the shard counts too many addresses already.
+func (d *ddosDetector) update(addr netip.Addr, source bool, at time.Time, fn func(counts *ddosCounts)) {
+	s := d.shard(addr)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	table := s.destinations
+	if source {
+		table = s.sources
+	}
+	counts, ok := table[addr]
+	if !ok {
+		if len(table) >= d.maxPerShard {
+			d.prune(table, at)
+			if len(table) >= d.maxPerShard {
+				return
+			}
+		}
+		counts = &ddosCounts{start: at}
+		table[addr] = counts
+	} else if at.Sub(counts.start) >= d.window {
+		*counts = ddosCounts{start: at}
+	}
+	fn(counts)
+}
+
+// prune forgets the addresses whose window ended before at. The caller
+// holds the lock of the table's shard.
+func (d *ddosDetector) prune(table map[netip.Addr]*ddosCounts, at time.Time) {
+	for addr, counts := range table {
+		if at.Sub(counts.start) >= d.window {
+			delete(table, addr)
+		}
+	}
+}
+
+// check adds an alert of attack on target once count reaches the rate per
+// second over the window, unless the window alerted already. The caller
+// holds the lock of the counts' shard.
+func (d *ddosDetector) check(alerts []ddosAlert, counts *ddosCounts, attack ddosAttack, target net.IP, count int, rate float64) []ddosAlert {
+	seconds := d.window.Seconds()
+	if counts.alerted[attack] || float64(count) < rate*seconds {
+		return alerts
+	}
+	counts.alerted[attack] = true
+	return append(alerts, ddosAlert{
+		attack: attack,
+		target: target,
+		rate:   float64(count) / seconds,
+		limit:  rate,
+	})
+}
+
+// observeDDoS feeds a packet that joined flowID to the DDoS heuristics and
+// reports the attacks it reveals, unless they come from an allowlisted
+// source
+func (e *Engine) observeDDoS(flowID string, srcIP, dstIP net.IP, srcPort, dstPort uint16, packet *Packet, opened bool) {
+	for _, alert := range e.ddos.observe(srcIP, dstIP, srcPort, dstPort, packet, opened) {
+		if e.allowlisted(srcIP) {
+			continue
+		}
+		e.reportDDoS(flowID, srcIP, dstIP, srcPort, dstPort, packet, alert)
+	}
+}
+
+// reportDDoS publishes a bot detection of an attack, on the flow of the
+// packet that revealed it. Confidence grows from one half at the threshold
+// towards one as the rate exceeds it. Only the sources of connection rate
+// spikes offend: SYN floods are commonly spoofed, and amplified traffic
+// comes from the abused services rather than the attacker.
+func (e *Engine) reportDDoS(flowID string, srcIP, dstIP net.IP, srcPort, dstPort uint16, packet *Packet, alert ddosAlert) {
+	var reasoning string
+	switch alert.attack {
+	case synFlood:
+		reasoning = fmt.Sprintf("SYN flood: %.0f SYNs/s to %s, most never acknowledged", alert.rate, alert.target)
+	case connectionSpike:
+		reasoning = fmt.Sprintf("Connection rate spike: %.0f new flows/s from %s", alert.rate, alert.target)
+	case amplification:
+		reasoning = fmt.Sprintf("UDP amplification: %.0f bytes/s to %s from %s servers", alert.rate, alert.target,
+			amplificationPorts[srcPort])
+	}
+	reasoning += fmt.Sprintf(" (threshold %.0f/s)", alert.limit)
+
+	result := &cortex.DetectionResult{
+		IsBot:      true,
+		Confidence: 1 - alert.limit/(2*alert.rate),
+		Reasoning:  reasoning,
+		Timestamp:  packet.Timestamp,
+		FlowID:     flowID,
+		ModelUsed:  ddosModel,
+		SrcIP:      srcIP,
+		DstIP:      dstIP,
+		SrcPort:    srcPort,
+		DstPort:    dstPort,
+		Protocol:   packet.Protocol,
+		SrcGeo:     e.lookupGeo(srcIP),
+		DstGeo:     e.lookupGeo(dstIP),
+	}
+
+	slog.Warn("DDoS heuristic triggered",
+		"attack", alert.attack.String(),
+		"target", alert.target,
+		"rate", alert.rate,
+		"threshold", alert.limit,
+		"flow_id", flowID)
+
+	if alert.attack == connectionSpike {
+		e.offend(result)
+	}
+
+	e.detections.publish(result)
+	e.writeDetection(result)
+}
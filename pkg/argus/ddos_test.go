@@ -0,0 +1,203 @@
+package argus
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+var testDDoSConfig = config.DDoSConfig{
+	Enabled:             true,
+	Window:              10,
+	SYNRate:             10,
+	ConnectionRate:      5,
+	AmplificationRate:   1000,
+	AmplificationFactor: 10,
+}
+
+// tcpPacket returns a TCP packet with flags at
+func tcpPacket(flags string, at time.Time) *Packet {
+	return &Packet{Timestamp: at, Size: 60, Protocol: "TCP", Headers: map[string]interface{}{"tcp_flags": flags}}
+}
+
+func TestDDoSDetectorSYNFlood(t *testing.T) {
+	detector := newDDoSDetector(testDDoSConfig)
+	victim := net.ParseIP("198.51.100.1")
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Handshakes that complete keep SYNs from counting as a flood
+	var alerts []ddosAlert
+	for i := 0; i < 150; i++ {
+		src := net.IPv4(203, 0, 113, byte(i))
+		alerts = append(alerts, detector.observe(src, victim, 40000, 80, tcpPacket("SYN", start), true)...)
+		alerts = append(alerts, detector.observe(src, victim, 40000, 80, tcpPacket("ACK", start), false)...)
+	}
+	assert.Empty(t, alerts)
+
+	// Unacknowledged SYNs alert once the rate is reached, once per window
+	later := start.Add(time.Minute)
+	for i := 0; i < 150; i++ {
+		packet := tcpPacket("SYN", later)
+		if i%2 == 0 {
+			packet.Weight = 2
+		}
+		alerts = append(alerts, detector.observe(net.IPv4(192, 0, 2, byte(i)), victim, 40000, 80, packet, false)...)
+		alerts = append(alerts, detector.observe(victim, net.IPv4(192, 0, 2, byte(i)), 80, 40000, tcpPacket("SYN|ACK", later), false)...)
+	}
+	require.Len(t, alerts, 1)
+	assert.Equal(t, synFlood, alerts[0].attack)
+	assert.Equal(t, "198.51.100.1", alerts[0].target.String())
+	assert.InDelta(t, 10.1, alerts[0].rate, 1e-9)
+	assert.Equal(t, 10.0, alerts[0].limit)
+
+	alerts = detector.observe(net.ParseIP("192.0.2.1"), victim, 40000, 80, tcpPacket("SYN", later.Add(10*time.Second)), false)
+	assert.Empty(t, alerts)
+}
+
+func TestDDoSDetectorConnectionSpike(t *testing.T) {
+	detector := newDDoSDetector(testDDoSConfig)
+	src := net.ParseIP("203.0.113.7")
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	var alerts []ddosAlert
+	for i := 0; i < 49; i++ {
+		packet := &Packet{Timestamp: start, Size: 80, Protocol: "UDP"}
+		alerts = append(alerts, detector.observe(src, net.IPv4(198, 51, 100, byte(i)), 40000, 9999, packet, true)...)
+	}
+	// Packets of flows already open are not connections
+	alerts = append(alerts, detector.observe(src, net.ParseIP("198.51.100.1"), 40000, 9999, &Packet{Timestamp: start, Protocol: "UDP"}, false)...)
+	assert.Empty(t, alerts)
+
+	alerts = detector.observe(src, net.ParseIP("198.51.100.99"), 40000, 9999, &Packet{Timestamp: start, Protocol: "UDP"}, true)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, connectionSpike, alerts[0].attack)
+	assert.Equal(t, "203.0.113.7", alerts[0].target.String())
+	assert.Equal(t, 5.0, alerts[0].rate)
+}
+
+func TestDDoSDetectorAmplification(t *testing.T) {
+	detector := newDDoSDetector(testDDoSConfig)
+	client := net.ParseIP("198.51.100.1")
+	victim := net.ParseIP("198.51.100.2")
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Responses to queries the destination sent are not amplification
+	var alerts []ddosAlert
+	for i := 0; i < 20; i++ {
+		resolver := net.IPv4(192, 0, 2, byte(i))
+		alerts = append(alerts, detector.observe(client, resolver, 40000, 53, &Packet{Timestamp: start, Size: 100, Protocol: "UDP"}, true)...)
+		alerts = append(alerts, detector.observe(resolver, client, 53, 40000, &Packet{Timestamp: start, Size: 900, Protocol: "UDP"}, false)...)
+	}
+	assert.Empty(t, alerts)
+
+	// Large responses to queries it never sent are
+	for i := 0; i < 20; i++ {
+		reflector := net.IPv4(192, 0, 2, byte(i))
+		alerts = append(alerts, detector.observe(reflector, victim, 123, 40000, &Packet{Timestamp: start, Size: 600, Protocol: "UDP"}, true)...)
+	}
+	require.Len(t, alerts, 1)
+	assert.Equal(t, amplification, alerts[0].attack)
+	assert.Equal(t, "198.51.100.2", alerts[0].target.String())
+	assert.Equal(t, 1020.0, alerts[0].rate)
+}
+
+func TestDDoSDetectorBounded(t *testing.T) {
+	detector := newDDoSDetector(testDDoSConfig)
+	detector.maxPerShard = 1
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Two sources counted in the same shard
+	first := netip.MustParseAddr("203.0.113.1")
+	second := first.Next()
+	for detector.shard(second) != detector.shard(first) {
+		second = second.Next()
+	}
+	shard := detector.shard(first)
+
+	detector.observe(first.AsSlice(), net.ParseIP("198.51.100.1"), 40000, 80, tcpPacket("SYN", start), true)
+	detector.observe(second.AsSlice(), net.ParseIP("198.51.100.1"), 40000, 80, tcpPacket("SYN", start), true)
+	assert.Len(t, shard.sources, 1)
+	assert.Contains(t, shard.sources, first)
+
+	// Addresses whose window ended make room
+	detector.observe(second.AsSlice(), net.ParseIP("198.51.100.1"), 40000, 80, tcpPacket("SYN", start.Add(time.Minute)), true)
+	assert.Contains(t, shard.sources, second)
+}
+
+func TestDDoSDetectorMappedAddresses(t *testing.T) {
+	detector := newDDoSDetector(testDDoSConfig)
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// IPv4 addresses count the same in either of their 4 and 16 byte forms
+	src := net.ParseIP("203.0.113.7")
+	var alerts []ddosAlert
+	for i := 0; i < 50; i++ {
+		if i%2 == 0 {
+			src = src.To4()
+		} else {
+			src = src.To16()
+		}
+		alerts = append(alerts, detector.observe(src, net.IPv4(198, 51, 100, byte(i)), 40000, 9999, &Packet{Timestamp: start, Protocol: "UDP"}, true)...)
+	}
+	require.Len(t, alerts, 1)
+	assert.Equal(t, connectionSpike, alerts[0].attack)
+}
+
+func TestEngineDDoS(t *testing.T) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.85})
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+
+	engine, err := NewEngine(config.CaptureConfig{
+		Simulation:  true,
+		DDoS:        testDDoSConfig,
+		Enforcement: config.EnforcementConfig{Enabled: true, DryRun: true, MinConfidence: 0.5, MinOffenses: 1, Window: 60, BlockDuration: 60},
+	}, cortexEngine)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	_, detections, cancel := engine.SubscribeDetections("")
+	defer cancel()
+
+	// A source opening a flow to every port is reported at its first
+	// packets, long before any flow is analyzed, and blocked
+	src := net.ParseIP("203.0.113.7")
+	now := time.Now()
+	for port := uint16(1); port <= 100; port++ {
+		engine.addPacket(src, net.ParseIP("198.51.100.1"), 40000, port, "TCP", tcpPacket("SYN", now))
+	}
+
+	var results []*cortex.DetectionResult
+	for len(results) < 2 {
+		select {
+		case event := <-detections:
+			results = append(results, event.Result)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no DDoS detection")
+		}
+	}
+	reasons := make([]string, len(results))
+	for i, result := range results {
+		assert.True(t, result.IsBot)
+		assert.Equal(t, ddosModel, result.ModelUsed)
+		assert.Equal(t, src, result.SrcIP)
+		reasons[i] = result.Reasoning
+	}
+	assert.Contains(t, reasons, "Connection rate spike: 5 new flows/s from 203.0.113.7 (threshold 5/s)")
+	assert.Contains(t, reasons, "SYN flood: 10 SYNs/s to 198.51.100.1, most never acknowledged (threshold 10/s)")
+	assert.Equal(t, engine.generateFlowID("203.0.113.7", "198.51.100.1", 40000, 50), results[0].FlowID)
+	assert.InDelta(t, 0.5, results[0].Confidence, 1e-9)
+
+	blocks, _ := engine.Blocks()
+	require.Len(t, blocks, 1)
+	assert.Equal(t, "203.0.113.7", blocks[0].IP)
+	assert.Equal(t, fmt.Sprintf("%s: %s", ddosModel, reasons[0]), blocks[0].Reason)
+}
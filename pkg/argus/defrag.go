@@ -0,0 +1,234 @@
+package argus
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/ip4defrag"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	// fragmentTimeout is how long the fragments of an incomplete datagram
+	// are kept, as in RFC 8200 section 4.5
+	fragmentTimeout = 60 * time.Second
+	// maxPendingDatagrams bounds the IPv6 datagrams being reassembled at once
+	maxPendingDatagrams = 4096
+	// maxFragmentsPerDatagram bounds the fragments buffered per IPv6 datagram
+	maxFragmentsPerDatagram = 256
+	// maxDatagramSize is the largest payload an IPv6 fragment set may rebuild
+	maxDatagramSize = 65535
+)
+
+var (
+	errFragmentOverlap  = errors.New("overlapping IPv6 fragment")
+	errFragmentTooLarge = errors.New("IPv6 fragment exceeds maximum datagram size")
+	errTooManyFragments = errors.New("too many IPv6 fragments")
+)
+
+// defragmenter reassembles fragmented IPv4 and IPv6 datagrams so they are
+// assigned to their flow once, whole, instead of as degenerate fragments
+type defragmenter struct {
+	ipv4 *ip4defrag.IPv4Defragmenter
+
+	mu   sync.Mutex
+	ipv6 map[ipv6FragmentKey]*ipv6Datagram
+}
+
+// ipv6FragmentKey identifies the fragments of one IPv6 datagram
+type ipv6FragmentKey struct {
+	src, dst [16]byte
+	id       uint32
+}
+
+// ipv6Datagram collects the fragments of one IPv6 datagram
+type ipv6Datagram struct {
+	fragments []ipv6Fragment
+	size      int // payload length, known once the last fragment arrives
+	received  int
+	lastSeen  time.Time
+}
+
+// ipv6Fragment is the payload of one fragment and its offset in bytes
+type ipv6Fragment struct {
+	offset int
+	data   []byte
+}
+
+// newDefragmenter creates an empty defragmenter
+func newDefragmenter() *defragmenter {
+	return &defragmenter{
+		ipv4: ip4defrag.NewIPv4Defragmenter(),
+		ipv6: make(map[ipv6FragmentKey]*ipv6Datagram),
+	}
+}
+
+// defragment returns packet unchanged when it is not a fragment, and the
+// rebuilt datagram when it completes one. It returns nil while fragments
+// are outstanding. err is set when a fragment is invalid and its datagram
+// has been discarded.
+func (d *defragmenter) defragment(packet gopacket.Packet) (gopacket.Packet, error) {
+	timestamp := packet.Metadata().Timestamp
+
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		out, err := d.ipv4.DefragIPv4WithTimestamp(ip, timestamp)
+		if err != nil || out == nil {
+			return nil, err
+		}
+		if out == ip {
+			return packet, nil
+		}
+		return rebuildPacket(packet, out, gopacket.Payload(out.Payload))
+
+	case *layers.IPv6:
+		frag, ok := packet.Layer(layers.LayerTypeIPv6Fragment).(*layers.IPv6Fragment)
+		if !ok {
+			return packet, nil
+		}
+		payload, err := d.addIPv6Fragment(ip, frag, timestamp)
+		if err != nil || payload == nil {
+			return nil, err
+		}
+		// Extension headers ahead of the fragment header are dropped; only
+		// the transport layer matters from here on
+		out := &layers.IPv6{
+			Version:      ip.Version,
+			TrafficClass: ip.TrafficClass,
+			FlowLabel:    ip.FlowLabel,
+			NextHeader:   frag.NextHeader,
+			HopLimit:     ip.HopLimit,
+			SrcIP:        ip.SrcIP,
+			DstIP:        ip.DstIP,
+		}
+		return rebuildPacket(packet, out, gopacket.Payload(payload))
+	}
+
+	return packet, nil
+}
+
+// addIPv6Fragment stores a fragment and returns the reassembled payload
+// once every fragment of its datagram has arrived
+func (d *defragmenter) addIPv6Fragment(ip *layers.IPv6, frag *layers.IPv6Fragment, timestamp time.Time) ([]byte, error) {
+	key := ipv6FragmentKey{id: frag.Identification}
+	copy(key.src[:], ip.SrcIP.To16())
+	copy(key.dst[:], ip.DstIP.To16())
+
+	offset := int(frag.FragmentOffset) * 8
+	data := frag.Payload
+	if offset+len(data) > maxDatagramSize {
+		d.discard(key)
+		return nil, errFragmentTooLarge
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dg, exists := d.ipv6[key]
+	if !exists {
+		if len(d.ipv6) >= maxPendingDatagrams {
+			return nil, errTooManyFragments
+		}
+		dg = &ipv6Datagram{}
+		d.ipv6[key] = dg
+	}
+	dg.lastSeen = timestamp
+
+	// Overlapping fragments are a known evasion technique and RFC 5722
+	// requires dropping the whole datagram
+	for _, f := range dg.fragments {
+		if offset < f.offset+len(f.data) && f.offset < offset+len(data) {
+			delete(d.ipv6, key)
+			return nil, errFragmentOverlap
+		}
+	}
+	if len(dg.fragments) >= maxFragmentsPerDatagram {
+		delete(d.ipv6, key)
+		return nil, errTooManyFragments
+	}
+
+	dg.fragments = append(dg.fragments, ipv6Fragment{offset: offset, data: data})
+	dg.received += len(data)
+	if !frag.MoreFragments {
+		dg.size = offset + len(data)
+	}
+
+	if dg.size == 0 || dg.received < dg.size {
+		return nil, nil
+	}
+	delete(d.ipv6, key)
+
+	sort.Slice(dg.fragments, func(i, j int) bool {
+		return dg.fragments[i].offset < dg.fragments[j].offset
+	})
+	payload := make([]byte, dg.size)
+	for _, f := range dg.fragments {
+		if f.offset+len(f.data) > dg.size {
+			return nil, errFragmentTooLarge
+		}
+		copy(payload[f.offset:], f.data)
+	}
+
+	return payload, nil
+}
+
+// discard forgets the fragments of an IPv6 datagram
+func (d *defragmenter) discard(key ipv6FragmentKey) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.ipv6, key)
+}
+
+// expire drops incomplete datagrams that have not seen a fragment since
+// fragmentTimeout before now, returning how many were dropped
+func (d *defragmenter) expire(now time.Time) int {
+	cutoff := now.Add(-fragmentTimeout)
+	expired := d.ipv4.DiscardOlderThan(cutoff)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, dg := range d.ipv6 {
+		if dg.lastSeen.Before(cutoff) {
+			delete(d.ipv6, key)
+			expired++
+		}
+	}
+
+	return expired
+}
+
+// rebuildPacket serializes a reassembled IP datagram behind the link
+// layer headers of the fragment that completed it and decodes the result,
+// so the transport layer and the raw frame reflect the whole datagram
+func rebuildPacket(last gopacket.Packet, ip gopacket.SerializableLayer, payload gopacket.Payload) (gopacket.Packet, error) {
+	// The link layer headers are everything ahead of the network layer
+	var prefix []byte
+	var first gopacket.LayerType
+	for i, layer := range last.Layers() {
+		if i == 0 {
+			first = layer.LayerType()
+		}
+		if layer == last.NetworkLayer() {
+			break
+		}
+		prefix = append(prefix, layer.LayerContents()...)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, payload); err != nil {
+		return nil, err
+	}
+	data := append(prefix, buf.Bytes()...)
+
+	packet := gopacket.NewPacket(data, first, gopacket.Default)
+	metadata := packet.Metadata()
+	metadata.CaptureInfo = last.Metadata().CaptureInfo
+	metadata.CaptureLength = len(data)
+	metadata.Length = len(data)
+
+	return packet, nil
+}
@@ -0,0 +1,144 @@
+package argus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fragmentUDP splits a UDP datagram into IP fragments carrying at most
+// chunk bytes of the transport segment each
+func fragmentUDP(t *testing.T, ipv6 bool, payload []byte, chunk int) []gopacket.Packet {
+	src, dst := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	etherType := layers.EthernetTypeIPv4
+	if ipv6 {
+		src, dst = net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+		etherType = layers.EthernetTypeIPv6
+	}
+
+	udp := &layers.UDP{SrcPort: 5353, DstPort: 53}
+	ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: src, DstIP: dst, Id: 42}
+	ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+	if ipv6 {
+		require.NoError(t, udp.SetNetworkLayerForChecksum(ip6))
+	} else {
+		require.NoError(t, udp.SetNetworkLayerForChecksum(ip4))
+	}
+
+	segment := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(segment, opts, udp, gopacket.Payload(payload)))
+	data := segment.Bytes()
+
+	var packets []gopacket.Packet
+	for offset := 0; offset < len(data); offset += chunk {
+		end := offset + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		more := end < len(data)
+
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+			EthernetType: etherType,
+		}
+		buf := gopacket.NewSerializeBuffer()
+		if ipv6 {
+			ip := *ip6
+			ip.NextHeader = layers.IPProtocolIPv6Fragment
+			frag := []byte{byte(layers.IPProtocolUDP), 0, byte(offset >> 8), byte(offset&0xf8) | boolByte(more), 0, 0, 0, 7}
+			require.NoError(t, gopacket.SerializeLayers(buf, opts, eth, &ip, gopacket.Payload(append(frag, data[offset:end]...))))
+		} else {
+			ip := *ip4
+			ip.FragOffset = uint16(offset / 8)
+			if more {
+				ip.Flags = layers.IPv4MoreFragments
+			}
+			require.NoError(t, gopacket.SerializeLayers(buf, opts, eth, &ip, gopacket.Payload(data[offset:end])))
+		}
+
+		packet := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+		packet.Metadata().Timestamp = time.Now()
+		packet.Metadata().Length = len(buf.Bytes())
+		packets = append(packets, packet)
+	}
+
+	return packets
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestDefragment(t *testing.T) {
+	payload := make([]byte, 1000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	for _, ipv6 := range []bool{false, true} {
+		engine := &Engine{
+			flows:  newFlowTable(0),
+			stats:  &CaptureStats{},
+			defrag: newDefragmenter(),
+		}
+
+		fragments := fragmentUDP(t, ipv6, payload, 400)
+		require.Len(t, fragments, 3)
+
+		// Fragments may arrive in any order
+		engine.handlePacket(fragments[2])
+		engine.handlePacket(fragments[0])
+		assert.Equal(t, 0, engine.flows.len(), "incomplete datagram must not create a flow")
+		engine.handlePacket(fragments[1])
+
+		require.Equal(t, 1, engine.flows.len())
+		engine.flows.forEach(func(flow *Flow) bool {
+			assert.Equal(t, uint16(5353), flow.SrcPort)
+			assert.Equal(t, uint16(53), flow.DstPort)
+			require.Len(t, flow.Packets, 1)
+			assert.Equal(t, len(payload), flow.Packets[0].Headers["payload_size"])
+			return true
+		})
+		assert.Equal(t, int64(1), engine.stats.TotalPackets)
+		assert.Equal(t, int64(1), engine.stats.ReassembledDatagrams)
+	}
+}
+
+func TestDefragmentOverlap(t *testing.T) {
+	d := newDefragmenter()
+	payload := make([]byte, 1000)
+
+	fragments := fragmentUDP(t, true, payload, 400)
+	out, err := d.defragment(fragments[0])
+	require.NoError(t, err)
+	assert.Nil(t, out)
+
+	// Replaying a fragment overlaps the one already held
+	_, err = d.defragment(fragments[0])
+	assert.ErrorIs(t, err, errFragmentOverlap)
+	assert.Empty(t, d.ipv6)
+}
+
+func TestDefragmentExpire(t *testing.T) {
+	d := newDefragmenter()
+	payload := make([]byte, 1000)
+
+	for _, ipv6 := range []bool{false, true} {
+		out, err := d.defragment(fragmentUDP(t, ipv6, payload, 400)[0])
+		require.NoError(t, err)
+		assert.Nil(t, out)
+	}
+
+	assert.Equal(t, 0, d.expire(time.Now()))
+	assert.Equal(t, 2, d.expire(time.Now().Add(2*fragmentTimeout)))
+}
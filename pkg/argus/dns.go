@@ -0,0 +1,174 @@
+package argus
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+)
+
+const (
+	// dnsPort is the port DNS is served on over UDP
+	dnsPort = 53
+	// dnsClientWindow is how long the answers of a client are counted
+	// before its NXDOMAIN ratio starts over
+	dnsClientWindow = 10 * time.Minute
+	// maxDNSClients bounds the clients whose answers are counted
+	maxDNSClients = 65536
+)
+
+// dnsMonitor parses the DNS datagrams of the captured traffic and follows
+// the answers each client gets. Stub resolvers pick a fresh source port for
+// every query, so a client's lookups spread over many short flows; domain
+// generation algorithms only show in the share of NXDOMAIN answers across
+// all of them.
+type dnsMonitor struct {
+	parser *protocol.Parser
+
+	mu      sync.Mutex
+	clients map[string]*dnsClient
+}
+
+// dnsClient counts the answers a client got in its current window
+type dnsClient struct {
+	since               time.Time
+	responses, nxdomain int
+}
+
+// newDNSMonitor creates a monitor parsing with parser and tracking no
+// clients
+func newDNSMonitor(parser *protocol.Parser) *dnsMonitor {
+	return &dnsMonitor{parser: parser, clients: make(map[string]*dnsClient)}
+}
+
+// parse parses a datagram to or from the DNS port and records what it
+// reveals in the packet headers. Responses count towards the NXDOMAIN
+// ratio of the client they are sent to. It returns nil for datagrams that
+// are not DNS messages.
+func (m *dnsMonitor) parse(payload []byte, client net.IP, at time.Time, headers map[string]interface{}) *protocol.ProtocolInfo {
+	info, err := m.parser.ParseDNS(payload)
+	if err != nil {
+		return nil
+	}
+
+	msg := info.DNS
+	headers["dns_response"] = msg.Response
+	if len(msg.Questions) > 0 {
+		name := protocol.MeasureDNSName(msg.Questions[0].Name)
+		headers["dns_qtype"] = msg.Questions[0].Type
+		headers["dns_qname_length"] = name.Length
+		headers["dns_label_entropy"] = name.Entropy
+	}
+	if msg.Response {
+		headers["dns_rcode"] = msg.RCode
+		headers["dns_client_nxdomain_ratio"] = m.answer(client, msg.RCode == protocol.DNSRCodeNXDomain, at)
+	}
+	return info
+}
+
+// answer counts a response to a client and returns the share of the
+// client's responses in the current window that were NXDOMAIN
+func (m *dnsMonitor) answer(client net.IP, nxdomain bool, at time.Time) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := client.String()
+	stats := m.clients[key]
+	if stats == nil || at.Sub(stats.since) > dnsClientWindow {
+		if stats == nil && len(m.clients) >= maxDNSClients {
+			m.prune(at)
+		}
+		if stats == nil && len(m.clients) >= maxDNSClients {
+			// Too many active clients to count this one
+			return boolFeature(nxdomain)
+		}
+		stats = &dnsClient{since: at}
+		m.clients[key] = stats
+	}
+
+	stats.responses++
+	if nxdomain {
+		stats.nxdomain++
+	}
+	return float64(stats.nxdomain) / float64(stats.responses)
+}
+
+// prune forgets the clients whose window ended. The caller holds m.mu.
+func (m *dnsMonitor) prune(now time.Time) {
+	for key, stats := range m.clients {
+		if now.Sub(stats.since) > dnsClientWindow {
+			delete(m.clients, key)
+		}
+	}
+}
+
+// dnsStats are the running aggregates of the DNS messages of a flow
+type dnsStats struct {
+	queries, responses, nxdomain int
+
+	// Queries by type: addresses (A, AAAA), the types tunnels favour
+	// (TXT, NULL), and the others
+	addressQueries, tunnelQueries int
+
+	nameLength runningStats
+	maxEntropy float64
+
+	// clientNXDomain is the NXDOMAIN ratio of the client across its flows
+	// when the last response was seen
+	clientNXDomain float64
+}
+
+// add records the DNS headers of a packet
+func (s *dnsStats) add(headers map[string]interface{}) {
+	response, ok := headers["dns_response"].(bool)
+	if !ok {
+		return
+	}
+
+	if response {
+		s.responses++
+		if rcode, _ := headers["dns_rcode"].(uint8); rcode == protocol.DNSRCodeNXDomain {
+			s.nxdomain++
+		}
+		if ratio, ok := headers["dns_client_nxdomain_ratio"].(float64); ok {
+			s.clientNXDomain = ratio
+		}
+		return
+	}
+
+	s.queries++
+	switch qtype, _ := headers["dns_qtype"].(uint16); protocol.DNSTypeName(qtype) {
+	case "A", "AAAA":
+		s.addressQueries++
+	case "TXT", "NULL":
+		s.tunnelQueries++
+	}
+	if length, ok := headers["dns_qname_length"].(int); ok {
+		s.nameLength.add(float64(length))
+	}
+	if entropy, ok := headers["dns_label_entropy"].(float64); ok && entropy > s.maxEntropy {
+		s.maxEntropy = entropy
+	}
+}
+
+// nxdomainRatio returns the share of the flow's responses that were
+// NXDOMAIN
+func (s *dnsStats) nxdomainRatio() float64 {
+	if s.responses == 0 {
+		return 0
+	}
+	return float64(s.nxdomain) / float64(s.responses)
+}
+
+// queryShares returns the shares of address, tunnel-favoured and other
+// queries
+func (s *dnsStats) queryShares() (address, tunnel, other float64) {
+	if s.queries == 0 {
+		return 0, 0, 0
+	}
+	n := float64(s.queries)
+	address = float64(s.addressQueries) / n
+	tunnel = float64(s.tunnelQueries) / n
+	return address, tunnel, float64(s.queries-s.addressQueries-s.tunnelQueries) / n
+}
@@ -0,0 +1,84 @@
+package argus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dnsDatagram returns a DNS message asking for a TXT record of
+// abc.example.com with the given flags
+func dnsDatagram(flags uint16) []byte {
+	msg := []byte{0, 1, byte(flags >> 8), byte(flags), 0, 1, 0, 0, 0, 0, 0, 0}
+	msg = append(msg, 3, 'a', 'b', 'c', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0)
+	return append(msg, 0, 16, 0, 1)
+}
+
+func TestDNSMonitorClientRatio(t *testing.T) {
+	monitor := newDNSMonitor(protocol.NewParser())
+	client := net.ParseIP("192.168.1.10")
+	start := time.Unix(1700000000, 0)
+
+	headers := make(map[string]interface{})
+	info := monitor.parse(dnsDatagram(0x0100), client, start, headers)
+	require.NotNil(t, info)
+	assert.Equal(t, false, headers["dns_response"])
+	assert.Equal(t, uint16(16), headers["dns_qtype"])
+	assert.Equal(t, 15, headers["dns_qname_length"])
+	assert.Nil(t, headers["dns_client_nxdomain_ratio"])
+
+	// Responses count per client, whatever flow they arrive on
+	for i, flags := range []uint16{0x8183, 0x8180, 0x8183, 0x8183} {
+		headers = make(map[string]interface{})
+		monitor.parse(dnsDatagram(flags), client, start.Add(time.Duration(i)*time.Second), headers)
+	}
+	assert.Equal(t, 0.75, headers["dns_client_nxdomain_ratio"])
+
+	// The count starts over once the window ends
+	headers = make(map[string]interface{})
+	monitor.parse(dnsDatagram(0x8180), client, start.Add(dnsClientWindow+time.Minute), headers)
+	assert.Equal(t, 0.0, headers["dns_client_nxdomain_ratio"])
+
+	// Other datagrams on the DNS port are left alone
+	headers = make(map[string]interface{})
+	assert.Nil(t, monitor.parse([]byte{1, 2, 3}, client, start, headers))
+	assert.Empty(t, headers)
+}
+
+func TestExtractDNSFeatures(t *testing.T) {
+	engine := &Engine{}
+	monitor := newDNSMonitor(protocol.NewParser())
+	client := net.ParseIP("192.168.1.10")
+	start := time.Now().Add(-time.Minute)
+
+	flow := &Flow{ID: "test-flow", StartTime: start}
+	for i, flags := range []uint16{0x0100, 0x8183, 0x0100, 0x8180} {
+		headers := make(map[string]interface{})
+		info := monitor.parse(dnsDatagram(flags), client, start, headers)
+		direction := "outbound"
+		if i%2 == 1 {
+			direction = "inbound"
+		}
+		flow.add(&Packet{Timestamp: start.Add(time.Duration(i) * time.Millisecond), Size: 80,
+			Direction: direction, Headers: headers, info: info}, 0)
+	}
+
+	require.NotNil(t, flow.ClientProtocol)
+	assert.False(t, flow.ClientProtocol.DNS.Response)
+	require.NotNil(t, flow.ServerProtocol)
+	assert.True(t, flow.ServerProtocol.DNS.Response)
+
+	features := engine.extractFeatures(flow)
+	assert.Equal(t, 2.0, features[11])  // queries
+	assert.Equal(t, 15.0, features[12]) // mean name length
+	assert.Greater(t, features[13], 0.0)
+	assert.Equal(t, 0.5, features[14])
+	assert.Equal(t, 0.5, features[15])
+	assert.Equal(t, 0.0, features[16])
+	assert.Equal(t, 1.0, features[17]) // TXT
+	assert.Equal(t, 0.0, features[18])
+}
@@ -0,0 +1,190 @@
+package argus
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+)
+
+// Encrypted DNS transports
+const (
+	TransportDoT = "dot" // DNS over TLS, RFC 7858
+	TransportDoH = "doh" // DNS over HTTPS, RFC 8484
+)
+
+// dotPort is the port DNS over TLS is served on
+const dotPort = 853
+
+// dnsMessageType is the media type of DNS messages carried over HTTPS
+const dnsMessageType = "application/dns-message"
+
+// knownResolvers are the names public DNS over HTTPS and TLS resolvers are
+// reached at. Their subdomains count too, as several providers hand out a
+// name per customer or filtering profile.
+var knownResolvers = []string{
+	"dns.google",
+	"dns.google.com",
+	"cloudflare-dns.com",
+	"one.one.one.one",
+	"1dot1dot1dot1.cloudflare-dns.com",
+	"dns.quad9.net",
+	"doh.opendns.com",
+	"dns.adguard.com",
+	"dns.adguard-dns.com",
+	"dns.nextdns.io",
+	"doh.cleanbrowsing.org",
+	"doh.mullvad.net",
+	"dns.controld.com",
+	"doh.dns.sb",
+}
+
+// EncryptedDNS tags a flow carrying DNS over TLS or HTTPS, with the
+// signals it was recognized by: "port" (853), "sni" (a known resolver),
+// "alpn" ("dot", or HTTP/2 or HTTP/3 towards a resolver), "content_type"
+// (DNS messages seen in decrypted HTTP) and "cadence" (a run of small
+// packets, as query and answer exchanges make).
+type EncryptedDNS struct {
+	Transport string   `json:"transport"`
+	Resolver  string   `json:"resolver,omitempty"` // server name the client asked for
+	Signals   []string `json:"signals"`
+	// Unexpected marks flows opened from the configured server networks,
+	// where resolving through a DoH or DoT resolver of one's own choosing
+	// bypasses the network's resolvers and is more often malware than not
+	Unexpected bool `json:"unexpected,omitempty"`
+}
+
+// encryptedDNSDetector recognizes encrypted DNS flows
+type encryptedDNSDetector struct {
+	resolvers      []string
+	serverNetworks []*net.IPNet
+}
+
+// newEncryptedDNSDetector creates a detector knowing the public resolvers
+// and those named, which tags the flows opened from networks, given as
+// addresses or CIDR prefixes, as unexpected
+func newEncryptedDNSDetector(resolvers, networks []string) (*encryptedDNSDetector, error) {
+	d := &encryptedDNSDetector{resolvers: append([]string(nil), knownResolvers...)}
+	for _, name := range resolvers {
+		d.resolvers = append(d.resolvers, strings.ToLower(strings.TrimSuffix(name, ".")))
+	}
+	for _, network := range networks {
+		ipNet, err := ParseIPFilter(network)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server network %q: %w", network, err)
+		}
+		d.serverNetworks = append(d.serverNetworks, ipNet)
+	}
+	return d, nil
+}
+
+// knownResolver reports whether a server name is that of a resolver
+func (d *encryptedDNSDetector) knownResolver(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, resolver := range d.resolvers {
+		if name == resolver || strings.HasSuffix(name, "."+resolver) {
+			return true
+		}
+	}
+	return false
+}
+
+// detect tags a flow carrying encrypted DNS, returning nil for other
+// flows. The caller holds the flow's lock.
+func (d *encryptedDNSDetector) detect(flow *Flow) *EncryptedDNS {
+	client := flow.ClientProtocol
+	if client == nil {
+		return nil
+	}
+
+	var tag EncryptedDNS
+	var alpn []string
+	if hello := client.ClientHello; hello != nil {
+		tag.Resolver = hello.ServerName
+		alpn = hello.ALPN
+	}
+	resolver := tag.Resolver != "" && d.knownResolver(tag.Resolver)
+
+	switch {
+	case client.Protocol == "TLS" && (flow.DstPort == dotPort || hasString(alpn, "dot")):
+		tag.Transport = TransportDoT
+		if flow.DstPort == dotPort {
+			tag.Signals = append(tag.Signals, "port")
+		}
+		if hasString(alpn, "dot") {
+			tag.Signals = append(tag.Signals, "alpn")
+		}
+		if resolver {
+			tag.Signals = append(tag.Signals, "sni")
+		}
+	case carriesDNSMessages(client) || carriesDNSMessages(flow.ServerProtocol):
+		tag.Transport = TransportDoH
+		tag.Signals = append(tag.Signals, "content_type")
+	case client.Protocol == "TLS" && resolver:
+		tag.Transport = TransportDoH
+		tag.Signals = append(tag.Signals, "sni")
+		if hasString(alpn, "h2") || hasString(alpn, "h3") {
+			tag.Signals = append(tag.Signals, "alpn")
+		}
+	default:
+		return nil
+	}
+
+	if flow.stats.smallPacketCadence() {
+		tag.Signals = append(tag.Signals, "cadence")
+	}
+	for _, network := range d.serverNetworks {
+		if network.Contains(flow.SrcIP) {
+			tag.Unexpected = true
+			break
+		}
+	}
+	return &tag
+}
+
+// carriesDNSMessages reports whether an HTTP message carries DNS messages
+func carriesDNSMessages(info *protocol.ProtocolInfo) bool {
+	if info == nil {
+		return false
+	}
+	for name, value := range info.Headers {
+		if (strings.EqualFold(name, "content-type") || strings.EqualFold(name, "accept")) &&
+			strings.HasPrefix(strings.ToLower(value), dnsMessageType) {
+			return true
+		}
+	}
+	return strings.HasPrefix(info.Path, "/dns-query")
+}
+
+// hasString reports whether list holds s
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Cadence of encrypted DNS: queries and answers fit in a few hundred bytes
+// each, so resolver connections are runs of small packets
+const (
+	cadenceMinPackets = 8
+	cadenceMaxSize    = 256
+	cadenceShare      = 0.8
+)
+
+// smallPacketCadence reports whether the flow is mostly small packets
+func (s *flowStats) smallPacketCadence() bool {
+	if s.packets < cadenceMinPackets {
+		return false
+	}
+	var small uint64
+	for i, bound := range packetSizeBins {
+		if bound <= cadenceMaxSize {
+			small += s.sizes[i]
+		}
+	}
+	return float64(small) >= cadenceShare*float64(s.packets)
+}
@@ -0,0 +1,89 @@
+package argus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+)
+
+// tlsFlow returns a flow from src to port whose client sent a ClientHello
+// for sni offering alpn, with packets of size
+func tlsFlow(src string, port uint16, sni string, alpn []string, packets, size int) *Flow {
+	start := time.Now().Add(-time.Minute)
+	flow := &Flow{ID: "test-flow", SrcIP: net.ParseIP(src), DstPort: port, StartTime: start}
+	flow.ClientProtocol = &protocol.ProtocolInfo{
+		Protocol:    "TLS",
+		ClientHello: &protocol.ClientHello{ServerName: sni, ALPN: alpn},
+	}
+	for i := 0; i < packets; i++ {
+		flow.add(&Packet{Timestamp: start.Add(time.Duration(i) * time.Millisecond), Size: size}, 0)
+	}
+	return flow
+}
+
+func TestDetectEncryptedDNS(t *testing.T) {
+	detector, err := newEncryptedDNSDetector([]string{"resolver.corp.example."}, []string{"10.1.0.0/16", "192.168.7.7"})
+	require.NoError(t, err)
+
+	tag := detector.detect(tlsFlow("192.168.1.2", 853, "dns.quad9.net", nil, 4, 1000))
+	require.NotNil(t, tag)
+	assert.Equal(t, TransportDoT, tag.Transport)
+	assert.Equal(t, []string{"port", "sni"}, tag.Signals)
+	assert.False(t, tag.Unexpected)
+
+	// Profile subdomains of known resolvers, with the cadence of queries
+	tag = detector.detect(tlsFlow("10.1.2.3", 443, "abc123.dns.nextdns.io", []string{"h2", "http/1.1"}, 20, 120))
+	require.NotNil(t, tag)
+	assert.Equal(t, TransportDoH, tag.Transport)
+	assert.Equal(t, "abc123.dns.nextdns.io", tag.Resolver)
+	assert.Equal(t, []string{"sni", "alpn", "cadence"}, tag.Signals)
+	assert.True(t, tag.Unexpected)
+
+	tag = detector.detect(tlsFlow("192.168.7.7", 443, "Resolver.Corp.Example", nil, 2, 500))
+	require.NotNil(t, tag)
+	assert.Equal(t, TransportDoH, tag.Transport)
+	assert.True(t, tag.Unexpected)
+
+	// Other HTTPS is left alone, whatever its cadence
+	assert.Nil(t, detector.detect(tlsFlow("192.168.1.2", 443, "www.google.com", []string{"h2"}, 20, 120)))
+	assert.Nil(t, detector.detect(tlsFlow("192.168.1.2", 443, "notdns.google", []string{"h2"}, 20, 120)))
+	assert.Nil(t, detector.detect(&Flow{ID: "no-protocol"}))
+
+	// Decrypted or plain HTTP carrying DNS messages
+	flow := &Flow{ID: "doh", DstPort: 8080}
+	flow.ClientProtocol = &protocol.ProtocolInfo{
+		Protocol: "HTTP/2",
+		Method:   "POST",
+		Path:     "/resolve",
+		Headers:  map[string]string{"content-type": "application/dns-message"},
+	}
+	tag = detector.detect(flow)
+	require.NotNil(t, tag)
+	assert.Equal(t, TransportDoH, tag.Transport)
+	assert.Equal(t, []string{"content_type"}, tag.Signals)
+
+	_, err = newEncryptedDNSDetector(nil, []string{"not-a-network"})
+	assert.Error(t, err)
+}
+
+func TestExtractEncryptedDNSFeatures(t *testing.T) {
+	detector, err := newEncryptedDNSDetector(nil, []string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	engine := &Engine{}
+
+	flow := tlsFlow("10.0.0.5", 443, "cloudflare-dns.com", []string{"h2"}, 10, 150)
+	features := engine.extractFeatures(flow)
+	assert.Equal(t, 0.0, features[19])
+	assert.Equal(t, 0.0, features[9])
+
+	flow.EncryptedDNS = detector.detect(flow)
+	features = engine.extractFeatures(flow)
+	assert.Equal(t, 1.0, features[19])
+	assert.Equal(t, 1.0, features[9])
+	assert.Equal(t, TransportDoH, flow.Summary().EncryptedDNS.Transport)
+}
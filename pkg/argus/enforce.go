@@ -0,0 +1,64 @@
+package argus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/enforce"
+)
+
+// ErrEnforcementDisabled is returned by Unblock when blocking is disabled
+var ErrEnforcementDisabled = errors.New("enforcement disabled")
+
+// newEnforcer creates the enforcer of the configured policy
+func newEnforcer(ctx context.Context, cfg config.EnforcementConfig) (*enforce.Enforcer, error) {
+	firewall, err := enforce.NewFirewall(cfg.Backend)
+	if err != nil {
+		return nil, err
+	}
+	policy := enforce.Policy{
+		MinConfidence: cfg.MinConfidence,
+		MinOffenses:   cfg.MinOffenses,
+		Window:        time.Duration(cfg.Window) * time.Second,
+		Duration:      time.Duration(cfg.BlockDuration) * time.Second,
+	}
+	return enforce.New(ctx, policy, firewall, cfg.DryRun, cfg.AuditLog)
+}
+
+// offend holds the first bot verdict on a flow against the flow's source,
+// in its reputation and towards blocking it. Allowlisted sources never
+// offend.
+func (e *Engine) offend(result *cortex.DetectionResult) {
+	if e.allowlisted(result.SrcIP) {
+		return
+	}
+	if e.reputation != nil {
+		e.reputation.offend(result.SrcIP, result.Confidence, result.Timestamp)
+	}
+	if e.enforcer != nil {
+		e.enforcer.Observe(e.ctx, result.SrcIP, result.Confidence,
+			result.ModelUsed+": "+result.Reasoning, result.Timestamp)
+	}
+}
+
+// Blocks returns the active blocks of offending sources, or false when
+// blocking is disabled
+func (e *Engine) Blocks() ([]enforce.Block, bool) {
+	if e.enforcer == nil {
+		return nil, false
+	}
+	return e.enforcer.Blocks(), true
+}
+
+// Unblock lifts the block of a source ahead of its expiry, reporting
+// whether it was blocked
+func (e *Engine) Unblock(ctx context.Context, ip net.IP) (bool, error) {
+	if e.enforcer == nil {
+		return false, ErrEnforcementDisabled
+	}
+	return e.enforcer.Unblock(ctx, ip)
+}
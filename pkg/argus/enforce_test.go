@@ -0,0 +1,75 @@
+package argus
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+func TestEngineEnforcement(t *testing.T) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.85})
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+
+	_, ok := (&Engine{}).Blocks()
+	assert.False(t, ok)
+	_, err = (&Engine{}).Unblock(context.Background(), net.ParseIP("203.0.113.7"))
+	assert.ErrorIs(t, err, ErrEnforcementDisabled)
+
+	_, err = NewEngine(config.CaptureConfig{Simulation: true, Enforcement: config.EnforcementConfig{
+		Enabled: true, Backend: "pf", MinOffenses: 1, Window: 60, BlockDuration: 60,
+	}}, cortexEngine)
+	assert.ErrorContains(t, err, "unknown firewall backend")
+
+	engine, err := NewEngine(config.CaptureConfig{Simulation: true, Enforcement: config.EnforcementConfig{
+		Enabled:       true,
+		Backend:       "nftables",
+		DryRun:        true,
+		MinConfidence: 0.9,
+		MinOffenses:   2,
+		Window:        600,
+		BlockDuration: 3600,
+	}}, cortexEngine)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	// Sources are blocked once enough of their flows were judged bots
+	src := net.ParseIP("203.0.113.7")
+	offense := func(flowID string, confidence float64) {
+		engine.offend(&cortex.DetectionResult{
+			IsBot:      true,
+			Confidence: confidence,
+			Reasoning:  "scripted client",
+			Timestamp:  time.Now(),
+			FlowID:     flowID,
+			ModelUsed:  "heuristic",
+			SrcIP:      src,
+		})
+	}
+	offense("flow-1", 0.95)
+	offense("flow-2", 0.6)
+	blocks, ok := engine.Blocks()
+	require.True(t, ok)
+	assert.Empty(t, blocks)
+
+	offense("flow-3", 0.99)
+	blocks, _ = engine.Blocks()
+	require.Len(t, blocks, 1)
+	assert.Equal(t, "203.0.113.7", blocks[0].IP)
+	assert.Equal(t, "heuristic: scripted client", blocks[0].Reason)
+	assert.Equal(t, 2, blocks[0].Offenses)
+	assert.True(t, blocks[0].DryRun)
+
+	unblocked, err := engine.Unblock(context.Background(), src)
+	require.NoError(t, err)
+	assert.True(t, unblocked)
+	blocks, _ = engine.Blocks()
+	assert.Empty(t, blocks)
+}
@@ -5,24 +5,69 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
 	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
-	"github.com/google/gopacket/pcap"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/enforce"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/enrich"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/intel"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/lists"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
 )
 
 // Engine represents the packet capture and feature extraction engine
 type Engine struct {
-	config  config.CaptureConfig
-	cortex  *cortex.Engine
-	handle  *pcap.Handle
-	flows   map[string]*Flow
-	flowsMu sync.RWMutex
-	ctx     context.Context
-	cancel  context.CancelFunc
-	stats   *CaptureStats
+	config      config.CaptureConfig
+	cortex      *cortex.Engine
+	source      captureSource
+	exporter    *pcapExporter
+	ipfix       *ipfixExporter
+	geoip       *enrich.GeoIP
+	rdns        *enrich.ReverseDNS
+	dns         *dnsMonitor
+	encDNS      *encryptedDNSDetector
+	baseline    *baselineMonitor
+	reputation  *reputationTracker
+	threatIntel *intel.Manager
+	ddos        *ddosDetector
+	lists       *lists.Store
+	enforcer    *enforce.Enforcer
+	streams     *tcpReassembler
+	defrag      *defragmenter
+	decap       *decapsulator
+	flows       *flowTable
+	extractors  []FeatureExtractor
+	detections  *detectionFeed
+	ctx         context.Context
+	cancel      context.CancelFunc
+	stats       *CaptureStats
+
+	filterMu    sync.Mutex
+	sampleCount atomic.Uint64
+
+	// settingsMu guards the flow lifecycle settings of config, which can
+	// be changed at runtime with SetFlowSettings
+	settingsMu sync.RWMutex
+
+	healthMu sync.RWMutex
+	health   health
+
+	// Drain stops the loops started by Start through loops, then waits for
+	// them and for in-flight analyses
+	loops     context.Context
+	stopLoops context.CancelFunc
+	running   sync.WaitGroup
+	analyses  sync.WaitGroup
+
+	sinksMu sync.RWMutex
+	sinks   []Sink
 }
 
 // Flow represents a network flow being tracked
@@ -33,14 +78,61 @@ type Flow struct {
 	SrcPort         uint16
 	DstPort         uint16
 	Protocol        string
-	Packets         []*Packet
+	SrcGeo          *enrich.GeoInfo
+	DstGeo          *enrich.GeoInfo
+	SrcName         string // PTR name, filled in asynchronously
+	DstName         string
+	ClientProtocol  *protocol.ProtocolInfo // first message sent by the initiator
+	ServerProtocol  *protocol.ProtocolInfo // first message sent by the responder
+	EncryptedDNS    *EncryptedDNS          // set when the flow carries DNS over TLS or HTTPS
+	Baseline        *BaselineDeviation     // from its source's baseline, nil while that is learned
+	ThreatIntel     []intel.Match          // indicators the flow matched
+	UserAgent       *protocol.UserAgent    // claimed by the flow's requests, or the client's last in the clear
+	Tunnels         []Tunnel               // encapsulation the flow was seen in, outermost first
+	VLANs           []uint16               // 802.1Q tags, outermost first
+	Packets         []*Packet              // the first packets, up to the flow packet buffer, for export
 	StartTime       time.Time
 	LastSeen        time.Time
 	Features        []float64
+	Result          *cortex.DetectionResult // latest analysis, nil until analyzed
 	AnalysisPending bool
+	observed        uint64    // packets seen, including those dropped by sampling
+	analyzedPackets int       // packets covered by the last analysis
+	reputation      float64   // of the source when last analyzed
+	intelPackets    int       // packets covered by the last threat intel match
+	recentOnly      bool      // only the latest packets are kept, the flow was not flagged
+	stats           flowStats // aggregates of all packets added
 	mu              sync.RWMutex
 }
 
+// Record converts the flow into its transport representation
+func (f *Flow) Record() *events.FlowRecord {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	record := &events.FlowRecord{
+		ID:        f.ID,
+		SrcIP:     f.SrcIP,
+		DstIP:     f.DstIP,
+		SrcPort:   f.SrcPort,
+		DstPort:   f.DstPort,
+		Protocol:  f.Protocol,
+		StartTime: f.StartTime,
+		LastSeen:  f.LastSeen,
+		Packets:   f.stats.packets,
+		Bytes:     f.stats.bytes,
+	}
+
+	return record
+}
+
+// hasUnanalyzedPackets reports whether packets arrived since the last analysis
+func (f *Flow) hasUnanalyzedPackets() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.stats.added > f.analyzedPackets
+}
+
 // Packet represents a captured network packet
 type Packet struct {
 	Timestamp time.Time
@@ -48,6 +140,12 @@ type Packet struct {
 	Direction string // "inbound" or "outbound"
 	Protocol  string
 	Headers   map[string]interface{}
+	Data      []byte // raw frame, only retained when pcapng export is enabled, until the flow is cleared
+	Weight    int    // original packets represented when sampling, 0 means 1
+	tunnels   []Tunnel
+	vlans     []uint16
+	info      *protocol.ProtocolInfo // message parsed from a datagram
+	payload   []byte                 // transport payload, dropped once the packet is added to its flow
 }
 
 // CaptureStats holds packet capture statistics
@@ -55,21 +153,44 @@ type CaptureStats struct {
 	TotalPackets  int64     `json:"total_packets"`
 	ActiveFlows   int64     `json:"active_flows"`
 	AnalyzedFlows int64     `json:"analyzed_flows"`
+	ExportedFlows int64     `json:"exported_flows"`
+	EvictedFlows  int64     `json:"evicted_flows"`
 	LastPacket    time.Time `json:"last_packet"`
-	mu            sync.RWMutex
+
+	SampledOutPackets int64 `json:"sampled_out_packets"`
+
+	ReassembledDatagrams int64 `json:"reassembled_datagrams"`
+	DiscardedFragments   int64 `json:"discarded_fragments"` // invalid or timed out datagrams
+	mu                   sync.RWMutex
+}
+
+// newParser creates the protocol parser the configuration asks for
+func newParser(cfg config.CaptureConfig) *protocol.Parser {
+	if cfg.StrictParsing {
+		return protocol.NewStrictParser()
+	}
+	return protocol.NewParser()
 }
 
 // NewEngine creates a new Argus engine instance
 func NewEngine(cfg config.CaptureConfig, cortexEngine *cortex.Engine) (*Engine, error) {
 	ctx, cancel := context.WithCancel(context.Background())
+	loops, stopLoops := context.WithCancel(ctx)
 
 	engine := &Engine{
-		config: cfg,
-		cortex: cortexEngine,
-		flows:  make(map[string]*Flow),
-		ctx:    ctx,
-		cancel: cancel,
-		stats:  &CaptureStats{},
+		config:     cfg,
+		cortex:     cortexEngine,
+		flows:      newFlowTable(cfg.MaxFlows),
+		extractors: FeatureExtractors(),
+		ctx:        ctx,
+		cancel:     cancel,
+		stats:      &CaptureStats{},
+		defrag:     newDefragmenter(),
+		dns:        newDNSMonitor(newParser(cfg)),
+		detections: newDetectionFeed(),
+		health:     health{captureErr: errCaptureNotStarted},
+		loops:      loops,
+		stopLoops:  stopLoops,
 	}
 
 	// Initialize packet capture handle
@@ -78,6 +199,123 @@ func NewEngine(cfg config.CaptureConfig, cortexEngine *cortex.Engine) (*Engine,
 		return nil, fmt.Errorf("failed to initialize packet capture: %w", err)
 	}
 
+	encDNS, err := newEncryptedDNSDetector(cfg.EncryptedDNSResolvers, cfg.ServerNetworks)
+	if err != nil {
+		engine.Close()
+		return nil, err
+	}
+	engine.encDNS = encDNS
+
+	store, err := newLists(cfg.Lists)
+	if err != nil {
+		engine.Close()
+		return nil, err
+	}
+	engine.lists = store
+
+	if cfg.BaselineEnabled {
+		if err := engine.addExtractor(baselineFeatures{}); err != nil {
+			engine.Close()
+			return nil, err
+		}
+		engine.baseline = newBaselineMonitor(time.Duration(cfg.BaselineWindow)*time.Hour,
+			cfg.BaselinePrefixV4, cfg.BaselinePrefixV6)
+		slog.Info("Baselining flow sources",
+			"window_hours", cfg.BaselineWindow,
+			"prefix_v4", cfg.BaselinePrefixV4,
+			"prefix_v6", cfg.BaselinePrefixV6)
+	}
+
+	if cfg.ReputationEnabled {
+		if err := engine.addExtractor(reputationFeatures{}); err != nil {
+			engine.Close()
+			return nil, err
+		}
+		engine.reputation = newReputationTracker(time.Duration(cfg.ReputationHalfLife)*time.Hour,
+			cfg.ReputationThreshold)
+		slog.Info("Tracking source reputation",
+			"half_life_hours", cfg.ReputationHalfLife,
+			"threshold", cfg.ReputationThreshold)
+	}
+
+	if len(cfg.ThreatIntel.Feeds) > 0 {
+		threatIntel, err := newThreatIntel(cfg.ThreatIntel)
+		if err != nil {
+			engine.Close()
+			return nil, err
+		}
+		if err := engine.addExtractor(threatIntelFeatures{}); err != nil {
+			engine.Close()
+			return nil, err
+		}
+		engine.threatIntel = threatIntel
+		slog.Info("Matching flows against threat intel feeds",
+			"feeds", len(cfg.ThreatIntel.Feeds),
+			"default_interval", cfg.ThreatIntel.Interval,
+			"default_ttl_hours", cfg.ThreatIntel.TTL)
+	}
+
+	if cfg.DDoS.Enabled {
+		engine.ddos = newDDoSDetector(cfg.DDoS)
+		slog.Info("Detecting DDoS attacks from packet rates",
+			"window", cfg.DDoS.Window,
+			"syn_rate", cfg.DDoS.SYNRate,
+			"connection_rate", cfg.DDoS.ConnectionRate,
+			"amplification_rate", cfg.DDoS.AmplificationRate,
+			"amplification_factor", cfg.DDoS.AmplificationFactor)
+	}
+
+	if cfg.Enforcement.Enabled {
+		enforcer, err := newEnforcer(ctx, cfg.Enforcement)
+		if err != nil {
+			engine.Close()
+			return nil, err
+		}
+		engine.enforcer = enforcer
+		slog.Info("Blocking offending sources",
+			"backend", cfg.Enforcement.Backend,
+			"dry_run", cfg.Enforcement.DryRun,
+			"min_confidence", cfg.Enforcement.MinConfidence,
+			"min_offenses", cfg.Enforcement.MinOffenses,
+			"window", cfg.Enforcement.Window,
+			"block_duration", cfg.Enforcement.BlockDuration)
+	}
+
+	if len(engine.extractors) > 1 {
+		slog.Info("Extracting flow features", "layout", featureLayout(engine.extractors))
+	}
+
+	if cfg.GeoIPCityDB != "" || cfg.GeoIPASNDB != "" {
+		geoip, err := enrich.NewGeoIP(cfg.GeoIPCityDB, cfg.GeoIPASNDB)
+		if err != nil {
+			engine.Close()
+			return nil, fmt.Errorf("failed to load GeoIP databases: %w", err)
+		}
+		engine.geoip = geoip
+		slog.Info("Enriching flows with GeoIP data",
+			"city_db", cfg.GeoIPCityDB,
+			"asn_db", cfg.GeoIPASNDB)
+	}
+
+	if cfg.RDNSEnabled {
+		engine.rdns = enrich.NewReverseDNS(
+			time.Duration(cfg.RDNSCacheTTL)*time.Second,
+			cfg.RDNSRateLimit)
+		slog.Info("Resolving flow endpoints with reverse DNS",
+			"cache_ttl", cfg.RDNSCacheTTL,
+			"rate_limit", cfg.RDNSRateLimit)
+	}
+
+	if cfg.IPFIXCollector != "" {
+		exporter, err := newIPFIXExporter(cfg)
+		if err != nil {
+			engine.Close()
+			return nil, err
+		}
+		engine.ipfix = exporter
+		slog.Info("Exporting analyzed flows over IPFIX", "collector", cfg.IPFIXCollector)
+	}
+
 	slog.Info("Argus engine initialized",
 		"interface", cfg.Interface,
 		"bpf_filter", cfg.BPFFilter,
@@ -88,12 +326,45 @@ func NewEngine(cfg config.CaptureConfig, cortexEngine *cortex.Engine) (*Engine,
 
 // initializeCapture sets up the packet capture interface
 func (e *Engine) initializeCapture() error {
-	// In a real implementation, this would open the actual network interface
-	// For now, we'll simulate the handle creation
-	slog.Info("Initializing packet capture", "interface", e.config.Interface)
+	if e.config.Simulation {
+		slog.Info("Packet capture running in simulation mode")
+		return nil
+	}
 
-	// Simulate handle creation
-	e.handle = &pcap.Handle{} // This would be the actual handle in real implementation
+	slog.Info("Initializing packet capture",
+		"interface", e.config.Interface,
+		"backend", e.config.Backend)
+
+	source, err := e.newCaptureSource()
+	if err != nil {
+		return err
+	}
+	e.source = source
+
+	if len(e.config.Decapsulate) > 0 {
+		e.decap = newDecapsulator(e.config.Decapsulate, e.config.DecapMaxDepth)
+		slog.Info("Decapsulating tunnelled traffic",
+			"types", e.config.Decapsulate,
+			"max_depth", e.config.DecapMaxDepth)
+	}
+
+	if e.config.TCPReassembly {
+		e.streams = newTCPReassembler(e, e.config.ReassemblyMaxBytes)
+		slog.Info("Reassembling TCP streams for protocol parsing",
+			"max_bytes", e.config.ReassemblyMaxBytes)
+	}
+
+	if e.config.ExportDir != "" {
+		exporter, err := newPcapExporter(e.config, e.linkType())
+		if err != nil {
+			return err
+		}
+		e.exporter = exporter
+
+		slog.Info("Exporting flagged flows as pcapng",
+			"dir", e.config.ExportDir,
+			"threshold", e.config.ExportThreshold)
+	}
 
 	return nil
 }
@@ -102,22 +373,67 @@ func (e *Engine) initializeCapture() error {
 func (e *Engine) Start(ctx context.Context) error {
 	slog.Info("Starting packet capture")
 
-	// Start packet processing goroutine
-	go e.processPackets(ctx)
+	// The loops also stop when the engine is drained
+	ctx, cancel := context.WithCancel(ctx)
+	context.AfterFunc(e.loops, cancel)
 
-	// Start flow analysis goroutine
-	go e.analyzeFlows(ctx)
+	for _, loop := range []func(context.Context){
+		e.processPackets, // packet processing
+		e.analyzeFlows,   // periodic flow analysis
+		e.cleanupFlows,   // flow expiry
+	} {
+		e.running.Add(1)
+		go func(loop func(context.Context)) {
+			defer e.running.Done()
+			loop(ctx)
+		}(loop)
+	}
 
-	// Start flow cleanup goroutine
-	go e.cleanupFlows(ctx)
+	if e.rdns != nil {
+		go e.rdns.Run(ctx)
+	}
+	if e.threatIntel != nil {
+		go e.threatIntel.Run(ctx)
+	}
+	if e.enforcer != nil {
+		go e.enforcer.Run(ctx)
+	}
 
 	return nil
 }
 
 // processPackets handles incoming packets
 func (e *Engine) processPackets(ctx context.Context) {
-	// In a real implementation, this would read from the pcap handle
-	// For simulation, we'll generate some fake packets
+	e.setCaptureState(nil)
+
+	if e.config.Simulation {
+		e.simulateTraffic(ctx)
+		e.setCaptureState(errCaptureStopped)
+		return
+	}
+
+	// Stop on either the caller's context or engine shutdown
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-e.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	e.source.run(ctx)
+
+	if ctx.Err() != nil {
+		e.setCaptureState(errCaptureStopped)
+	} else {
+		e.setCaptureState(errCaptureEnded)
+	}
+}
+
+// simulateTraffic generates fake packets until ctx is cancelled
+func (e *Engine) simulateTraffic(ctx context.Context) {
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
 
@@ -169,33 +485,194 @@ func (e *Engine) simulatePacketCapture() {
 
 // addPacketToFlow adds a packet to the appropriate flow
 func (e *Engine) addPacketToFlow(flowID string, packet *Packet) {
-	e.flowsMu.Lock()
-	defer e.flowsMu.Unlock()
-
-	flow, exists := e.flows[flowID]
-	if !exists {
-		flow = &Flow{
+	newFlow := func() *Flow {
+		return &Flow{
 			ID:        flowID,
 			Packets:   make([]*Packet, 0),
 			StartTime: time.Now(),
 		}
-		e.flows[flowID] = flow
 	}
 
-	flow.mu.Lock()
-	flow.Packets = append(flow.Packets, packet)
-	flow.LastSeen = packet.Timestamp
-	flow.mu.Unlock()
+	created, evicted := e.flows.update(flowID, "", newFlow, func(flow *Flow, _ bool) {
+		flow.mu.Lock()
+		flow.add(packet, e.config.FlowPacketBuffer)
+		flow.mu.Unlock()
+	})
+
+	if evicted != nil {
+		e.evictFlow(evicted)
+	}
+	if created {
+		e.updateActiveFlows()
+	}
+}
+
+// addPacket adds a decoded packet to its flow, matching replies to the flow
+// opened by the initiator so both directions are tracked together
+func (e *Engine) addPacket(srcIP, dstIP net.IP, srcPort, dstPort uint16, protocol string, packet *Packet) {
+	key := e.vlanKey(packet.vlans)
+	flowID := key + e.generateFlowID(srcIP.String(), dstIP.String(), srcPort, dstPort)
+	reverseID := key + e.generateFlowID(dstIP.String(), srcIP.String(), dstPort, srcPort)
+
+	newFlow := func() *Flow {
+		flow := &Flow{
+			ID:        flowID,
+			SrcIP:     srcIP,
+			DstIP:     dstIP,
+			SrcPort:   srcPort,
+			DstPort:   dstPort,
+			Protocol:  protocol,
+			SrcGeo:    e.lookupGeo(srcIP),
+			DstGeo:    e.lookupGeo(dstIP),
+			Baseline:  e.observeBaseline(srcIP, protocol, dstPort, packet.Timestamp),
+			Tunnels:   packet.tunnels,
+			VLANs:     packet.vlans,
+			Packets:   make([]*Packet, 0),
+			StartTime: packet.Timestamp,
+		}
+		e.resolveNames(flow)
+		return flow
+	}
+
+	var id string
+	var kept bool
+	created, evicted := e.flows.update(flowID, reverseID, newFlow, func(flow *Flow, reverse bool) {
+		packet.Direction = "outbound"
+		if reverse {
+			packet.Direction = "inbound"
+		}
 
-	// Update active flows count
+		flow.mu.Lock()
+		defer flow.mu.Unlock()
+
+		id = flow.ID
+		if !e.sampleFlowPacket(flow, packet) {
+			return
+		}
+		kept = true
+		flow.add(packet, e.config.FlowPacketBuffer)
+	})
+
+	if evicted != nil {
+		e.evictFlow(evicted)
+	}
+	if created {
+		e.updateActiveFlows()
+	}
+	// Packets sampled out are counted through the weight of those kept
+	if e.ddos != nil && kept {
+		e.observeDDoS(id, srcIP, dstIP, srcPort, dstPort, packet, created)
+	}
+}
+
+// addExtractor adds the features of an optional engine module after those
+// of the registered extractors
+func (e *Engine) addExtractor(extractor FeatureExtractor) error {
+	name := extractor.Name()
+	size := extractor.FeatureCount()
+	for _, existing := range e.extractors {
+		if existing.Name() == name {
+			return fmt.Errorf("feature extractor %s is already registered", name)
+		}
+		size += existing.FeatureCount()
+	}
+	if size > FeatureSize {
+		return fmt.Errorf("%s features need %d features, %d are free",
+			name, extractor.FeatureCount(), FeatureSize-size+extractor.FeatureCount())
+	}
+
+	e.extractors = append(e.extractors, extractor)
+	return nil
+}
+
+// observeBaseline records a flow in its source's baseline and returns how
+// it deviates, or nil when baselining is disabled
+func (e *Engine) observeBaseline(src net.IP, protocol string, port uint16, at time.Time) *BaselineDeviation {
+	if e.baseline == nil {
+		return nil
+	}
+	return e.baseline.observe(src, protocol, port, at)
+}
+
+// lookupGeo returns the GeoIP data for an address, or nil when enrichment
+// is disabled or the databases have no record of it
+func (e *Engine) lookupGeo(ip net.IP) *enrich.GeoInfo {
+	if e.geoip == nil {
+		return nil
+	}
+
+	info, err := e.geoip.Lookup(ip)
+	if err != nil {
+		slog.Debug("GeoIP lookup failed", "ip", ip, "error", err)
+		return nil
+	}
+	return info
+}
+
+// resolveNames requests the PTR names of both flow endpoints. Names that
+// are not cached yet are attached once the lookup completes.
+func (e *Engine) resolveNames(flow *Flow) {
+	if e.rdns == nil {
+		return
+	}
+
+	e.rdns.Resolve(flow.SrcIP, func(name string) {
+		flow.mu.Lock()
+		flow.SrcName = name
+		flow.mu.Unlock()
+	})
+	e.rdns.Resolve(flow.DstIP, func(name string) {
+		flow.mu.Lock()
+		flow.DstName = name
+		flow.mu.Unlock()
+	})
+}
+
+// evictFlow accounts for a flow pushed out of a full flow table and gives
+// it a final analysis
+func (e *Engine) evictFlow(flow *Flow) {
+	e.stats.mu.Lock()
+	e.stats.EvictedFlows++
+	e.stats.mu.Unlock()
+
+	if flow.hasUnanalyzedPackets() {
+		e.analyzeFlow(flow, true)
+	}
+	e.completeFlow(flow)
+}
+
+// updateActiveFlows refreshes the active flow count in the statistics
+func (e *Engine) updateActiveFlows() {
 	e.stats.mu.Lock()
-	e.stats.ActiveFlows = int64(len(e.flows))
+	e.stats.ActiveFlows = int64(e.flows.len())
 	e.stats.mu.Unlock()
 }
 
 // generateFlowID creates a unique identifier for a network flow
 func (e *Engine) generateFlowID(srcIP, dstIP string, srcPort, dstPort uint16) string {
-	return fmt.Sprintf("%s:%d-%s:%d", srcIP, srcPort, dstIP, dstPort)
+	// IPv6 addresses are bracketed so the port separator stays unambiguous
+	return net.JoinHostPort(srcIP, strconv.Itoa(int(srcPort))) + "-" +
+		net.JoinHostPort(dstIP, strconv.Itoa(int(dstPort)))
+}
+
+// vlanKey returns the flow ID prefix keeping apart flows with the same
+// 5-tuple on different VLANs, e.g. "vlan100.200/", or "" when VLANs are
+// not part of the flow key
+func (e *Engine) vlanKey(vlans []uint16) string {
+	if !e.config.VLANInFlowKey || len(vlans) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("vlan")
+	for i, id := range vlans {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(strconv.Itoa(int(id)))
+	}
+	b.WriteByte('/')
+	return b.String()
 }
 
 // analyzeFlows periodically analyzes flows for bot detection
@@ -215,101 +692,390 @@ func (e *Engine) analyzeFlows(ctx context.Context) {
 
 // performFlowAnalysis analyzes flows that are ready for analysis
 func (e *Engine) performFlowAnalysis() {
-	e.flowsMu.RLock()
-	flows := make([]*Flow, 0, len(e.flows))
-	for _, flow := range e.flows {
-		if !flow.AnalysisPending && len(flow.Packets) >= 10 {
+	// Threat intel matches are reported whether Cortex is ready or not
+	if e.threatIntel != nil {
+		e.matchThreatIntel()
+	}
+
+	// Hold flows back until Cortex has warmed up; they are picked up on a later tick
+	if !e.cortex.IsReady() {
+		return
+	}
+
+	_, _, minPackets := e.flowSettings()
+	now := time.Now()
+
+	var flows []*Flow
+	e.flows.forEach(func(flow *Flow) bool {
+		need := minPackets
+		if e.reputation != nil && e.reputation.flagged(flow.SrcIP, now) {
+			need = max(minPackets/reputationEarlyAnalysis, 1)
+		}
+
+		flow.mu.RLock()
+		ready := !flow.AnalysisPending && flow.stats.added >= need
+		flow.mu.RUnlock()
+		if ready {
 			flows = append(flows, flow)
 		}
-	}
-	e.flowsMu.RUnlock()
+		return true
+	})
 
 	for _, flow := range flows {
-		flow.mu.Lock()
-		flow.AnalysisPending = true
-		flow.mu.Unlock()
+		e.analyzeFlow(flow, false)
+	}
+}
+
+// analyzeFlow extracts the features of a flow and sends them to Cortex.
+// final marks the analysis emitted when the flow expires.
+func (e *Engine) analyzeFlow(flow *Flow, final bool) {
+	flow.mu.Lock()
+	flow.AnalysisPending = true
+	flow.analyzedPackets = flow.stats.added
+	if e.encDNS != nil {
+		flow.EncryptedDNS = e.encDNS.detect(flow)
+	}
+	if e.reputation != nil {
+		flow.reputation = e.reputation.score(flow.SrcIP, time.Now())
+	}
+	flow.mu.Unlock()
 
-		// Extract features from the flow
-		features := e.extractFeatures(flow)
+	// Extract features from the flow
+	features := e.extractFeatures(flow)
 
-		// Send to Cortex for analysis
-		go func(f *Flow, feat []float64) {
-			result, err := e.cortex.Analyze(e.ctx, feat, f.ID)
+	flow.mu.Lock()
+	flow.Features = features
+	flow.mu.Unlock()
+
+	entry := e.listed(flow)
+
+	// Send to Cortex for analysis, unless the lists decide the flow first
+	e.analyses.Add(1)
+	go func(f *Flow, feat []float64) {
+		defer e.analyses.Done()
+
+		var result *cortex.DetectionResult
+		if entry != nil && e.config.Lists.Mode != listsAfter {
+			result = listedResult(entry, feat, f.ID)
+		} else {
+			var err error
+			result, err = e.cortex.Analyze(e.ctx, feat, f.ID)
 			if err != nil {
 				slog.Error("Failed to analyze flow", "flow_id", f.ID, "error", err)
 				return
 			}
+			if entry != nil {
+				decideListed(result, entry)
+			}
+		}
 
-			slog.Info("Flow analysis completed",
-				"flow_id", f.ID,
-				"is_bot", result.IsBot,
-				"confidence", result.Confidence)
+		slog.Info("Flow analysis completed",
+			"flow_id", f.ID,
+			"final", final,
+			"is_bot", result.IsBot,
+			"confidence", result.Confidence)
 
-			// Update statistics
-			e.stats.mu.Lock()
-			e.stats.AnalyzedFlows++
-			e.stats.mu.Unlock()
-		}(flow, features)
-	}
-}
+		result.SrcIP, result.DstIP = f.SrcIP, f.DstIP
+		result.SrcPort, result.DstPort = f.SrcPort, f.DstPort
+		result.Protocol = f.Protocol
+		result.SrcGeo, result.DstGeo = f.SrcGeo, f.DstGeo
 
-// extractFeatures extracts behavioral features from a flow
-func (e *Engine) extractFeatures(flow *Flow) []float64 {
-	flow.mu.RLock()
-	defer flow.mu.RUnlock()
+		f.mu.Lock()
+		offense := result.IsBot && (f.Result == nil || !f.Result.IsBot) // once per flow
+		f.Result = result
+		f.mu.Unlock()
 
-	features := make([]float64, 128) // Match the model input size
+		if offense {
+			e.offend(result)
+		}
+
+		e.detections.publish(result)
+		e.writeDetection(result)
 
-	if len(flow.Packets) == 0 {
-		return features
+		// Update statistics
+		e.stats.mu.Lock()
+		e.stats.AnalyzedFlows++
+		e.stats.mu.Unlock()
+
+		if e.exporter != nil {
+			if e.exporter.shouldExport(result) {
+				e.exportFlow(f, result)
+			} else {
+				keepRecentPackets(f)
+			}
+		}
+		if e.ipfix != nil {
+			err := e.ipfix.export(f, result)
+			if err != nil {
+				slog.Warn("Failed to export flow over IPFIX", "flow_id", f.ID, "error", err)
+			}
+			e.setSinkState(SinkIPFIX, err)
+		}
+	}(flow, features)
+}
+
+// exportFlow writes a flagged flow to the pcapng export directory. A flow
+// flagged again replaces its earlier export and is counted once.
+func (e *Engine) exportFlow(flow *Flow, result *cortex.DetectionResult) {
+	path, replaced, err := e.exporter.export(flow, result)
+	if err != nil {
+		slog.Error("Failed to export flow", "flow_id", flow.ID, "error", err)
+	}
+	e.setSinkState(SinkPcapExport, err)
+	if path == "" {
+		return
 	}
 
-	// Calculate packet size statistics
-	var totalSize int
-	var sizes []int
-	for _, pkt := range flow.Packets {
-		totalSize += pkt.Size
-		sizes = append(sizes, pkt.Size)
+	slog.Info("Exported flagged flow", "flow_id", flow.ID, "path", path, "replaced", replaced)
+
+	if !replaced {
+		e.stats.mu.Lock()
+		e.stats.ExportedFlows++
+		e.stats.mu.Unlock()
 	}
-	avgSize := float64(totalSize) / float64(len(flow.Packets))
-	features[0] = avgSize
+}
 
-	// Calculate timing patterns
-	if len(flow.Packets) > 1 {
-		var intervals []float64
-		for i := 1; i < len(flow.Packets); i++ {
-			interval := flow.Packets[i].Timestamp.Sub(flow.Packets[i-1].Timestamp).Seconds()
-			intervals = append(intervals, interval)
-		}
+// flowRecentPackets bounds the packets kept of a flow analyzed below the
+// export threshold, the latest ones, so it can still be exported when a
+// later analysis flags it
+const flowRecentPackets = 16
 
-		// Calculate timing variance
-		var sum, sumSq float64
-		for _, interval := range intervals {
-			sum += interval
-			sumSq += interval * interval
-		}
-		mean := sum / float64(len(intervals))
-		variance := (sumSq / float64(len(intervals))) - (mean * mean)
-		features[10] = variance
+// keepRecentPackets releases the packets of a flow analyzed below the
+// export threshold but the latest ones, and from then on keeps a ring of
+// its latest packets until the flow expires
+func keepRecentPackets(flow *Flow) {
+	flow.mu.Lock()
+	defer flow.mu.Unlock()
+
+	flow.recentOnly = true
+	if n := len(flow.Packets); n > flowRecentPackets {
+		flow.Packets = slices.Clone(flow.Packets[n-flowRecentPackets:])
 	}
+}
+
+// FeatureSize is the length of the feature vectors extracted from flows;
+// ml.FeatureName names the slots of the built-in flow features
+const FeatureSize = 128
+
+// flowFeatureCount is the number of slots the built-in flow features take
+// at the start of the feature vector
+const flowFeatureCount = 124
+
+// flowFeatures extracts the built-in behavioral features from the running
+// statistics of a flow, in constant time whatever the flow's packet count
+type flowFeatures struct{}
+
+// Name implements FeatureExtractor
+func (flowFeatures) Name() string { return flowFeatureExtractor }
+
+// FeatureCount implements FeatureExtractor
+func (flowFeatures) FeatureCount() int { return flowFeatureCount }
+
+// Extract implements FeatureExtractor
+func (flowFeatures) Extract(flow *Flow) []float64 {
+	features := make([]float64, flowFeatureCount)
+	stats := &flow.stats
+
+	// Packet size statistics. Sampled packets are weighted by the number
+	// of packets they stand for so rates match the unsampled flow.
+	features[0] = float64(stats.bytes) / float64(stats.packets)
+
+	// Timing patterns
+	features[10] = stats.intervals.variance()
 
 	// Protocol-specific features
-	features[20] = float64(len(flow.Packets))                  // Packet count
+	features[20] = float64(stats.packets)                      // Packet count
 	features[21] = flow.LastSeen.Sub(flow.StartTime).Seconds() // Flow duration
 
 	// Add some realistic noise
 	for i := 0; i < len(features); i++ {
 		if features[i] == 0 {
-			features[i] = float64(i%10) / 10.0 // Add some pattern
+			features[i] = fillFeature(i)
+		}
+	}
+
+	// Endpoint enrichment, set after the fill so a false flag stays 0
+	if flow.SrcGeo != nil && flow.DstGeo != nil {
+		features[22] = boolFeature(flow.SrcGeo.Country != "" && flow.DstGeo.Country != "" &&
+			flow.SrcGeo.Country != flow.DstGeo.Country) // Crosses a border
+		features[23] = boolFeature(flow.SrcGeo.ASN != 0 && flow.SrcGeo.ASN == flow.DstGeo.ASN) // Stays within one AS
+	}
+
+	// Server certificate: command and control servers favour short-lived,
+	// self-signed certificates without alternative names or intermediates
+	if flow.ServerProtocol != nil && flow.ServerProtocol.Certificate != nil {
+		cert := flow.ServerProtocol.Certificate
+		features[27] = cert.ValidityDays()
+		features[28] = float64(cert.SANCount)
+		features[29] = boolFeature(cert.SelfSigned)
+		features[37] = float64(cert.ChainLength)
+	}
+
+	// IPv6 flow labels: real stacks pick one non-zero label per connection
+	// direction, crafted traffic tends to leave it zero or change it
+	features[24] = boolFeature(flow.SrcIP != nil && flow.SrcIP.To4() == nil)
+	if features[24] == 1 && stats.labelled > 0 {
+		features[25] = float64(stats.zeroLabels) / float64(stats.labelled) // Unlabelled packet ratio
+		features[26] = stats.flowLabelChanges()                            // Label changes per direction
+	}
+
+	// Share of packets per size bin, set after the fill so empty bins stay 0
+	for i, n := range stats.sizes {
+		features[30+i] = float64(n) / float64(stats.packets)
+	}
+
+	// DNS: generated domains and tunnels make for long, random-looking
+	// names, answered NXDOMAIN or asked for TXT and NULL records
+	features[11] = float64(stats.dns.queries)
+	features[12] = stats.dns.nameLength.mean
+	features[13] = stats.dns.maxEntropy
+	features[14] = stats.dns.nxdomainRatio()
+	features[15] = stats.dns.clientNXDomain
+	features[16], features[17], features[18] = stats.dns.queryShares()
+	// SSH: brute-force tools and botnets log in through libraries whose
+	// identification string and short algorithm lists give them away
+	features[1], features[2], features[3] = 0, 0, 0
+	if client := flow.ClientProtocol; client != nil && client.SSH != nil {
+		features[1] = 1
+		features[2] = boolFeature(client.SSH.AutomationClient())
+		features[3] = float64(len(client.SSH.KexAlgorithms))
+	} else if flow.ServerProtocol != nil && flow.ServerProtocol.SSH != nil {
+		features[1] = 1
+	}
+
+	// gRPC, which internal automation favours over browser-facing HTTP
+	features[4] = 0
+	for _, info := range []*protocol.ProtocolInfo{flow.ClientProtocol, flow.ServerProtocol} {
+		if info != nil && info.GRPC != nil {
+			features[4] = 1
 		}
 	}
 
+	// Remote access: credential stuffing against RDP asks for standard
+	// security, scanners of VNC look for servers without authentication
+	features[5], features[6], features[7], features[8] = 0, 0, 0, 0
+	for _, info := range []*protocol.ProtocolInfo{flow.ClientProtocol, flow.ServerProtocol} {
+		switch {
+		case info == nil:
+		case info.RDP != nil:
+			features[5] = 1
+			if info.RDP.StandardSecurity() {
+				features[6] = 1
+			}
+		case info.VNC != nil:
+			features[7] = 1
+			if info.VNC.NoAuthentication() {
+				features[8] = 1
+			}
+		}
+	}
+
+	features[19], features[9] = 0, 0
+	if flow.EncryptedDNS != nil {
+		features[19] = 1
+		features[9] = boolFeature(flow.EncryptedDNS.Unexpected)
+	}
+
+	// QUIC spin bit: a low flip ratio tracks round trips, about half means
+	// the endpoints randomize it, 0 that they hold it fixed
+	features[38] = boolFeature(stats.quic)
+	features[39] = stats.spinFlipRatio()
+
+	// HTTP transactions: scanners and credential stuffing make many
+	// requests collecting error responses, and the time to first byte
+	// tells the targets that slow them down
+	features[40] = float64(stats.http.transactions)
+	features[41] = stats.http.ttfb.mean
+	features[42] = stats.http.ttfb.max
+	features[43] = stats.http.errorRatio()
+	features[44] = stats.http.responseBytes.mean
+
+	// User-Agent claims: a browser's name is free to copy, its TLS stack
+	// and request headers are not
+	features[45], features[46], features[47], features[48] = 0, 0, 0, 0
+	if agent := flow.UserAgent; agent != nil {
+		features[45] = boolFeature(agent.Browser())
+		features[46] = boolFeature(agent.Automation)
+		if client := flow.ClientProtocol; client != nil {
+			if client.ClientHello != nil {
+				features[47] = boolFeature(tlsContradicts(agent, client.ClientHello))
+			}
+			features[48] = boolFeature(httpContradicts(agent, client))
+		}
+	}
+
+	// Request header order: HTTP libraries write their few headers in an
+	// order of their own, whatever User-Agent they put among them
+	features[49], features[50] = 0, 0
+	if client := flow.ClientProtocol; client != nil && client.HeaderFingerprint != "" {
+		features[49] = fingerprintFeature(client.HeaderFingerprint)
+		features[50] = float64(len(client.HeaderOrder))
+	}
+
+	// TLS record sequence: the sizes and turns of the first records of an
+	// encrypted flow, set after the fill so flows without TLS stay 0
+	records := &stats.tls
+	features[51] = float64(len(records.sizes))
+	features[52] = records.meanSize()
+	features[53], features[54] = records.bursts()
+	bigrams := records.bigrams()
+	copy(features[55:55+tlsRecordBigramSlots], bigrams[:])
+
+	// Sequence of packet lengths and times: the lengths outbound then
+	// inbound, then the times, each padded with 0 to spltPackets
+	for dir, sequence := range stats.splt {
+		lengths := features[63+dir*spltPackets : 63+(dir+1)*spltPackets]
+		times := features[63+(2+dir)*spltPackets : 63+(3+dir)*spltPackets]
+		for i := range lengths {
+			lengths[i], times[i] = 0, 0
+		}
+		for i, length := range sequence.Lengths {
+			lengths[i], times[i] = float64(length), sequence.Times[i]
+		}
+	}
+
+	// Protocol state machine anomalies
+	features[95] = float64(stats.http.afterClose)
+	features[96] = float64(stats.http.unsolicited)
+	features[97] = float64(stats.tls.alerts)
+	features[98] = float64(stats.tls.outOfOrder)
+	features[99] = float64(stats.tcp.dataAfterClose)
+
+	// Payload bytes: entropy, mean value and histogram per direction,
+	// outbound at 100 and inbound at 110
+	for dir, counts := range stats.payload {
+		slots := features[100+dir*10 : 110+dir*10]
+		d := counts.distribution()
+		slots[0], slots[1] = d.Entropy, d.Mean
+		copy(slots[2:], d.Histogram[:])
+	}
+
+	// Features only the protocol parsers extract
+	extractProtocolFeatures(flow.ClientProtocol, features)
+
 	return features
 }
 
-// cleanupFlows removes old flows
+// boolFeature encodes a flag as a feature value
+func boolFeature(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// fingerprintFeature encodes a hex fingerprint as a feature value in
+// (0, 1], from its first 32 bits, so that equal fingerprints have equal
+// values and absent ones stay 0
+func fingerprintFeature(fingerprint string) float64 {
+	v, _ := strconv.ParseUint(fingerprint[:min(8, len(fingerprint))], 16, 64)
+	return float64(v+1) / (1 << 32)
+}
+
+// cleanupFlows periodically expires idle and long-running flows
 func (e *Engine) cleanupFlows(ctx context.Context) {
-	ticker := time.NewTicker(30 * time.Second)
+	interval := e.cleanupInterval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
@@ -317,28 +1083,90 @@ func (e *Engine) cleanupFlows(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			e.removeOldFlows()
+			// Follow idle timeout changes made with SetFlowSettings
+			if next := e.cleanupInterval(); next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
+
+			now := time.Now()
+			e.expireFlows(now)
+			if e.streams != nil {
+				e.streams.flush(now)
+			}
+			if e.defrag != nil {
+				if expired := e.defrag.expire(now); expired > 0 {
+					e.stats.mu.Lock()
+					e.stats.DiscardedFragments += int64(expired)
+					e.stats.mu.Unlock()
+				}
+			}
 		}
 	}
 }
 
-// removeOldFlows removes flows that haven't been seen recently
-func (e *Engine) removeOldFlows() {
-	cutoff := time.Now().Add(-5 * time.Minute)
+// cleanupInterval returns how often flows are checked for expiry: every
+// 30 seconds, or twice per idle timeout when that is shorter
+func (e *Engine) cleanupInterval() time.Duration {
+	interval := 30 * time.Second
+	if idle, _, _ := e.flowSettings(); idle > 0 && idle/2 < interval {
+		interval = idle / 2
+	}
+	return interval
+}
+
+// flowSettings returns the flow idle and active timeouts and the number of
+// packets a flow needs before it is analyzed
+func (e *Engine) flowSettings() (idleTimeout, activeTimeout time.Duration, minPackets int) {
+	e.settingsMu.RLock()
+	defer e.settingsMu.RUnlock()
+	return time.Duration(e.config.FlowIdleTimeout) * time.Second,
+		time.Duration(e.config.FlowActiveTimeout) * time.Second,
+		e.config.MinPacketsForAnalysis
+}
+
+// SetFlowSettings changes the flow idle and active timeouts (seconds) and
+// the number of packets a flow needs before it is analyzed. Tracked flows
+// are expired with the new timeouts from the next cleanup on.
+func (e *Engine) SetFlowSettings(idleTimeout, activeTimeout, minPackets int) {
+	e.settingsMu.Lock()
+	defer e.settingsMu.Unlock()
+
+	e.config.FlowIdleTimeout = idleTimeout
+	e.config.FlowActiveTimeout = activeTimeout
+	e.config.MinPacketsForAnalysis = minPackets
+
+	slog.Info("Flow settings updated",
+		"flow_idle_timeout", idleTimeout,
+		"flow_active_timeout", activeTimeout,
+		"min_packets_for_analysis", minPackets)
+}
+
+// expireFlows removes flows that have been idle longer than the idle
+// timeout or active longer than the active timeout. Flows with packets
+// that have not been analyzed yet get a final analysis, however few
+// packets they hold.
+func (e *Engine) expireFlows(now time.Time) {
+	idleTimeout, activeTimeout, _ := e.flowSettings()
+
+	removed := e.flows.removeIf(func(flow *Flow) bool {
+		flow.mu.RLock()
+		defer flow.mu.RUnlock()
+
+		idle := idleTimeout > 0 && now.Sub(flow.LastSeen) >= idleTimeout
+		active := activeTimeout > 0 && now.Sub(flow.StartTime) >= activeTimeout
+		return idle || active
+	})
 
-	e.flowsMu.Lock()
-	defer e.flowsMu.Unlock()
+	e.updateActiveFlows()
 
-	for flowID, flow := range e.flows {
-		if flow.LastSeen.Before(cutoff) {
-			delete(e.flows, flowID)
+	// Only flows with packets that have not been analyzed need a final pass
+	for _, flow := range removed {
+		if flow.hasUnanalyzedPackets() {
+			e.analyzeFlow(flow, true)
 		}
+		e.completeFlow(flow)
 	}
-
-	// Update active flows count
-	e.stats.mu.Lock()
-	e.stats.ActiveFlows = int64(len(e.flows))
-	e.stats.mu.Unlock()
 }
 
 // GetStatistics returns current capture statistics
@@ -351,17 +1179,83 @@ func (e *Engine) GetStatistics() *CaptureStats {
 		TotalPackets:  e.stats.TotalPackets,
 		ActiveFlows:   e.stats.ActiveFlows,
 		AnalyzedFlows: e.stats.AnalyzedFlows,
+		ExportedFlows: e.stats.ExportedFlows,
+		EvictedFlows:  e.stats.EvictedFlows,
 		LastPacket:    e.stats.LastPacket,
+
+		SampledOutPackets: e.stats.SampledOutPackets,
+
+		ReassembledDatagrams: e.stats.ReassembledDatagrams,
+		DiscardedFragments:   e.stats.DiscardedFragments,
 	}
 	return &stats
 }
 
+// Drain stops capturing and analyzing packets, gives flows with packets
+// that have not been analyzed yet a final analysis and waits for in-flight
+// analyses to finish, including publishing and exporting their results to
+// the sinks. It returns an error when ctx is done first. The capture handle
+// stays open until Close.
+func (e *Engine) Drain(ctx context.Context) error {
+	e.stopLoops()
+
+	if err := waitGroup(ctx, &e.running); err != nil {
+		return fmt.Errorf("timed out stopping capture: %w", err)
+	}
+
+	var pending []*Flow
+	e.flows.forEach(func(flow *Flow) bool {
+		if flow.hasUnanalyzedPackets() {
+			pending = append(pending, flow)
+		}
+		return true
+	})
+	for _, flow := range pending {
+		e.analyzeFlow(flow, true)
+	}
+
+	if err := waitGroup(ctx, &e.analyses); err != nil {
+		return fmt.Errorf("timed out draining analyses: %w", err)
+	}
+
+	slog.Info("Argus engine drained", "final_analyses", len(pending))
+	return nil
+}
+
+// waitGroup waits for wg or until ctx is done
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Close shuts down the Argus engine
 func (e *Engine) Close() error {
 	e.cancel()
-	if e.handle != nil {
-		// In real implementation: e.handle.Close()
+	if e.source != nil {
+		e.source.close()
+	}
+	if e.streams != nil {
+		e.streams.close()
+	}
+	if e.ipfix != nil {
+		e.ipfix.close()
+	}
+	if e.enforcer != nil {
+		if err := e.enforcer.Close(); err != nil {
+			slog.Error("Failed to lift blocks", "error", err)
+		}
 	}
+	e.closeSinks()
 	slog.Info("Argus engine shutdown complete")
 	return nil
 }
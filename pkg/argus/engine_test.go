@@ -2,11 +2,16 @@ package argus
 
 import (
 	"context"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
 	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/enrich"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -16,6 +21,7 @@ func TestNewEngine(t *testing.T) {
 		Interface:  "eth0",
 		BPFFilter:  "tcp or udp",
 		BufferSize: 1024 * 1024,
+		Simulation: true,
 	}
 
 	cortexCfg := config.CortexConfig{
@@ -54,7 +60,7 @@ func TestGenerateFlowID(t *testing.T) {
 
 func TestAddPacketToFlow(t *testing.T) {
 	engine := &Engine{
-		flows: make(map[string]*Flow),
+		flows: newFlowTable(0),
 		stats: &CaptureStats{},
 	}
 
@@ -70,7 +76,7 @@ func TestAddPacketToFlow(t *testing.T) {
 	engine.addPacketToFlow(flowID, packet)
 
 	// Check that flow was created
-	flow, exists := engine.flows[flowID]
+	flow, exists := engine.flows.get(flowID)
 	assert.True(t, exists)
 	assert.Equal(t, flowID, flow.ID)
 	assert.Len(t, flow.Packets, 1)
@@ -95,21 +101,22 @@ func TestExtractFeatures(t *testing.T) {
 	flow := &Flow{
 		ID:        "test-flow",
 		StartTime: time.Now().Add(-5 * time.Minute),
-		LastSeen:  time.Now(),
-		Packets: []*Packet{
-			{
-				Timestamp: time.Now().Add(-4 * time.Minute),
-				Size:      1200,
-			},
-			{
-				Timestamp: time.Now().Add(-3 * time.Minute),
-				Size:      800,
-			},
-			{
-				Timestamp: time.Now().Add(-2 * time.Minute),
-				Size:      1400,
-			},
+	}
+	for _, packet := range []*Packet{
+		{
+			Timestamp: time.Now().Add(-4 * time.Minute),
+			Size:      1200,
+		},
+		{
+			Timestamp: time.Now().Add(-3 * time.Minute),
+			Size:      800,
 		},
+		{
+			Timestamp: time.Now().Add(-2 * time.Minute),
+			Size:      1400,
+		},
+	} {
+		flow.add(packet, 0)
 	}
 
 	features := engine.extractFeatures(flow)
@@ -127,12 +134,228 @@ func TestExtractFeatures(t *testing.T) {
 	// Check that flow duration is set
 	duration := flow.LastSeen.Sub(flow.StartTime).Seconds()
 	assert.Equal(t, duration, features[21])
+
+	// Two of the three packets fall in the 1024-1500 size bin
+	assert.InDelta(t, 1.0/3, features[34], 1e-12)
+	assert.InDelta(t, 2.0/3, features[35], 1e-12)
+	assert.Equal(t, 0.0, features[36])
+}
+
+func TestFlowRunningStatistics(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	flow := &Flow{ID: "test-flow", StartTime: start}
+	intervals := []float64{1, 2, 4, 1, 7}
+	sizes := []int{60, 1500, 9000, 200, 600, 40}
+	at := start
+	for i, size := range sizes {
+		if i > 0 {
+			at = at.Add(time.Duration(intervals[i-1] * float64(time.Second)))
+		}
+		direction := "outbound"
+		if i%2 == 1 {
+			direction = "inbound"
+		}
+		flow.add(&Packet{Timestamp: at, Size: size, Direction: direction}, 2)
+	}
+
+	// Only the first packets are kept, the statistics cover all of them
+	assert.Len(t, flow.Packets, 2)
+	assert.Equal(t, at, flow.LastSeen)
+	record := flow.Record()
+	assert.Equal(t, uint64(6), record.Packets)
+	assert.Equal(t, uint64(11400), record.Bytes)
+	assert.Equal(t, DirectionStats{Packets: 3, Bytes: 9660}, flow.stats.outbound)
+	assert.Equal(t, DirectionStats{Packets: 3, Bytes: 1740}, flow.stats.inbound)
+
+	// Welford's variance matches the two-pass computation
+	var mean, variance float64
+	for _, interval := range intervals {
+		mean += interval / float64(len(intervals))
+	}
+	for _, interval := range intervals {
+		variance += (interval - mean) * (interval - mean) / float64(len(intervals))
+	}
+	features := (&Engine{}).extractFeatures(flow)
+	assert.InDelta(t, variance, features[10], 1e-9)
+	assert.Equal(t, 1.0, flow.stats.intervals.min)
+	assert.Equal(t, 7.0, flow.stats.intervals.max)
+	assert.InDelta(t, mean, flow.stats.intervals.mean, 1e-12)
+
+	// Size histogram shares sum to one
+	var share float64
+	for i := range flow.stats.sizes {
+		share += features[30+i]
+	}
+	assert.InDelta(t, 1.0, share, 1e-12)
+	assert.InDelta(t, 2.0/6, features[30], 1e-12) // 60 and 40 bytes
+	assert.InDelta(t, 1.0/6, features[36], 1e-12) // 9000 bytes
+
+	// The packet sequence of each direction, padded with 0
+	assert.Equal(t, PacketSequence{Lengths: []int{60, 9000, 600}, Times: []float64{0, 2, 1}}, flow.stats.splt[0])
+	assert.Equal(t, PacketSequence{Lengths: []int{1500, 200, 40}, Times: []float64{1, 4, 7}}, flow.stats.splt[1])
+	assert.Equal(t, []float64{60, 9000, 600, 0, 0, 0, 0, 0}, features[63:71])
+	assert.Equal(t, []float64{1500, 200, 40, 0, 0, 0, 0, 0}, features[71:79])
+	assert.Equal(t, []float64{0, 2, 1, 0, 0, 0, 0, 0}, features[79:87])
+	assert.Equal(t, []float64{1, 4, 7, 0, 0, 0, 0, 0}, features[87:95])
+	assert.Equal(t, flow.stats.splt[1].Lengths, flow.Detail().SPLT.Inbound.Lengths)
+}
+
+func TestPacketSequence(t *testing.T) {
+	var s PacketSequence
+	for i := 0; i < 2*spltPackets; i++ {
+		s.add(100+i, float64(i))
+	}
+	assert.Len(t, s.Lengths, spltPackets)
+	assert.Equal(t, 100+spltPackets-1, s.Lengths[spltPackets-1])
+
+	// Empty sequences are handed out as empty lists
+	empty := (&PacketSequence{}).clone()
+	assert.NotNil(t, empty.Lengths)
+	assert.NotNil(t, empty.Times)
+}
+
+func TestExtractCertificateFeatures(t *testing.T) {
+	engine := &Engine{}
+
+	flow := &Flow{ID: "test-flow", StartTime: time.Now().Add(-time.Minute)}
+	flow.add(&Packet{Timestamp: time.Now(), Size: 100}, 0)
+	features := engine.extractFeatures(flow)
+	assert.Equal(t, 0.7, features[27]) // filled when there is no certificate
+
+	flow.ServerProtocol = &protocol.ProtocolInfo{Certificate: &protocol.Certificate{
+		NotBefore:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:    time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+		SANCount:    0,
+		ChainLength: 2,
+	}}
+	features = engine.extractFeatures(flow)
+	assert.Equal(t, 30.0, features[27])
+	assert.Equal(t, 0.0, features[28])
+	assert.Equal(t, 0.0, features[29])
+	assert.Equal(t, 2.0, features[37])
+}
+
+func TestExtractQUICFeatures(t *testing.T) {
+	engine := &Engine{}
+	start := time.Now().Add(-time.Minute)
+
+	// Short headers before any long header are not taken for QUIC
+	flow := &Flow{ID: "test-flow", StartTime: start}
+	flow.add(&Packet{Timestamp: start, Size: 100, Headers: map[string]interface{}{"quic_spin": true}}, 0)
+	features := engine.extractFeatures(flow)
+	assert.Equal(t, 0.0, features[38])
+	assert.Equal(t, 0.0, features[39])
+
+	// The spin bit flips once per round trip in each direction
+	flow = &Flow{ID: "test-flow", StartTime: start}
+	flow.add(&Packet{Timestamp: start, Size: 1200, Direction: "outbound",
+		Headers: map[string]interface{}{"quic_version": uint32(1)}}, 0)
+	for i, spin := range []bool{false, false, true, true, false} {
+		for _, direction := range []string{"outbound", "inbound"} {
+			flow.add(&Packet{Timestamp: start.Add(time.Duration(i) * time.Millisecond), Size: 100,
+				Direction: direction, Headers: map[string]interface{}{"quic_spin": spin}}, 0)
+		}
+	}
+	features = engine.extractFeatures(flow)
+	assert.Equal(t, 1.0, features[38])
+	assert.Equal(t, 0.5, features[39]) // 2 flips over 4 pairs per direction
+}
+
+func TestExtractSSHFeatures(t *testing.T) {
+	engine := &Engine{}
+
+	flow := &Flow{ID: "test-flow", StartTime: time.Now().Add(-time.Minute)}
+	flow.add(&Packet{Timestamp: time.Now(), Size: 100}, 0)
+	features := engine.extractFeatures(flow)
+	assert.Equal(t, []float64{0, 0, 0}, features[1:4])
+
+	flow.ClientProtocol = &protocol.ProtocolInfo{Protocol: "SSH", SSH: &protocol.SSHHandshake{
+		Software:      "libssh_0.9.6",
+		KexAlgorithms: []string{"curve25519-sha256", "diffie-hellman-group14-sha1"},
+	}}
+	features = engine.extractFeatures(flow)
+	assert.Equal(t, []float64{1, 1, 2}, features[1:4])
+}
+
+func TestExtractGRPCFeatures(t *testing.T) {
+	engine := &Engine{}
+
+	flow := &Flow{ID: "test-flow", StartTime: time.Now().Add(-time.Minute)}
+	flow.add(&Packet{Timestamp: time.Now(), Size: 100}, 0)
+	flow.ClientProtocol = &protocol.ProtocolInfo{Protocol: "HTTP/2"}
+	assert.Equal(t, 0.0, engine.extractFeatures(flow)[4])
+
+	flow.ServerProtocol = &protocol.ProtocolInfo{Protocol: "HTTP/2", GRPC: &protocol.GRPCCall{ContentType: "application/grpc"}}
+	assert.Equal(t, 1.0, engine.extractFeatures(flow)[4])
+}
+
+func TestExtractRemoteAccessFeatures(t *testing.T) {
+	engine := &Engine{}
+
+	flow := &Flow{ID: "test-flow", StartTime: time.Now().Add(-time.Minute)}
+	flow.add(&Packet{Timestamp: time.Now(), Size: 100}, 0)
+	assert.Equal(t, []float64{0, 0, 0, 0}, engine.extractFeatures(flow)[5:9])
+
+	flow.ClientProtocol = &protocol.ProtocolInfo{Protocol: "RDP", RDP: &protocol.RDPNegotiation{Request: true, Cookie: "Administr"}}
+	assert.Equal(t, []float64{1, 1, 0, 0}, engine.extractFeatures(flow)[5:9])
+
+	flow.ClientProtocol = nil
+	flow.ServerProtocol = &protocol.ProtocolInfo{Protocol: "VNC", VNC: &protocol.RFBHandshake{
+		Major: 3, Minor: 8, SecurityTypes: []uint8{protocol.RFBSecurityNone},
+	}}
+	assert.Equal(t, []float64{0, 0, 1, 1}, engine.extractFeatures(flow)[5:9])
+}
+
+func TestExtractHeaderOrderFeatures(t *testing.T) {
+	engine := &Engine{}
+
+	flow := &Flow{ID: "test-flow", StartTime: time.Now().Add(-time.Minute)}
+	flow.add(&Packet{Timestamp: time.Now(), Size: 100}, 0)
+	assert.Equal(t, []float64{0, 0}, engine.extractFeatures(flow)[49:51])
+
+	order := []string{"Host", "User-Agent", "Accept"}
+	flow.ClientProtocol = &protocol.ProtocolInfo{Protocol: "HTTP/1.1", Method: "GET",
+		HeaderOrder: order, HeaderFingerprint: protocol.HeaderFingerprint(order)}
+	features := engine.extractFeatures(flow)
+	assert.Greater(t, features[49], 0.0)
+	assert.LessOrEqual(t, features[49], 1.0)
+	assert.Equal(t, 3.0, features[50])
+
+	reordered := []string{"User-Agent", "Host", "Accept"}
+	flow.ClientProtocol.HeaderFingerprint = protocol.HeaderFingerprint(reordered)
+	assert.NotEqual(t, features[49], engine.extractFeatures(flow)[49])
+}
+
+func TestFingerprintFeature(t *testing.T) {
+	assert.Equal(t, 1.0/(1<<32), fingerprintFeature("000000000000"))
+	assert.Equal(t, 1.0, fingerprintFeature("ffffffffffff"))
+}
+
+func TestExtractGeoFeatures(t *testing.T) {
+	engine := &Engine{}
+
+	flow := &Flow{
+		ID:        "test-flow",
+		SrcGeo:    &enrich.GeoInfo{Country: "SE", ASN: 64500},
+		DstGeo:    &enrich.GeoInfo{Country: "US", ASN: 64501},
+		StartTime: time.Now().Add(-time.Minute),
+	}
+	flow.add(&Packet{Timestamp: time.Now(), Size: 100}, 0)
+
+	features := engine.extractFeatures(flow)
+	assert.Equal(t, 1.0, features[22])
+	assert.Equal(t, 0.0, features[23])
+
+	flow.DstGeo = &enrich.GeoInfo{Country: "SE", ASN: 64500}
+	features = engine.extractFeatures(flow)
+	assert.Equal(t, 0.0, features[22])
+	assert.Equal(t, 1.0, features[23])
 }
 
 func TestSimulatePacketCapture(t *testing.T) {
 	engine := &Engine{
 		stats: &CaptureStats{},
-		flows: make(map[string]*Flow),
+		flows: newFlowTable(0),
 	}
 
 	initialPackets := engine.stats.TotalPackets
@@ -145,10 +368,11 @@ func TestSimulatePacketCapture(t *testing.T) {
 	assert.Greater(t, engine.stats.ActiveFlows, initialFlows)
 }
 
-func TestRemoveOldFlows(t *testing.T) {
+func TestExpireFlows(t *testing.T) {
 	engine := &Engine{
-		flows: make(map[string]*Flow),
-		stats: &CaptureStats{},
+		config: config.CaptureConfig{FlowIdleTimeout: 300},
+		flows:  newFlowTable(0),
+		stats:  &CaptureStats{},
 	}
 
 	// Add a recent flow
@@ -157,7 +381,7 @@ func TestRemoveOldFlows(t *testing.T) {
 		LastSeen:  time.Now(),
 		StartTime: time.Now().Add(-1 * time.Minute),
 	}
-	engine.flows["recent-flow"] = recentFlow
+	engine.flows.set(recentFlow)
 
 	// Add an old flow
 	oldFlow := &Flow{
@@ -165,13 +389,53 @@ func TestRemoveOldFlows(t *testing.T) {
 		LastSeen:  time.Now().Add(-10 * time.Minute),
 		StartTime: time.Now().Add(-15 * time.Minute),
 	}
-	engine.flows["old-flow"] = oldFlow
+	engine.flows.set(oldFlow)
 
-	engine.removeOldFlows()
+	engine.expireFlows(time.Now())
 
 	// Check that only the recent flow remains
-	assert.Contains(t, engine.flows, "recent-flow")
-	assert.NotContains(t, engine.flows, "old-flow")
+	_, exists := engine.flows.get("recent-flow")
+	assert.True(t, exists)
+	_, exists = engine.flows.get("old-flow")
+	assert.False(t, exists)
+}
+
+func TestFinalAnalysisOnExpiry(t *testing.T) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.85})
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	engine := &Engine{
+		config: config.CaptureConfig{
+			FlowIdleTimeout:       300,
+			FlowActiveTimeout:     600,
+			MinPacketsForAnalysis: 10,
+		},
+		cortex: cortexEngine,
+		flows:  newFlowTable(0),
+		ctx:    ctx,
+		stats:  &CaptureStats{},
+	}
+
+	// A long-running but still active flow with too few packets for analysis
+	start := time.Now().Add(-15 * time.Minute)
+	for i := 0; i < 3; i++ {
+		engine.addPacket(net.ParseIP("192.168.1.100"), net.ParseIP("8.8.8.8"), 54321, 443, "TCP", &Packet{
+			Timestamp: start.Add(time.Duration(i) * 7 * time.Minute),
+			Size:      100,
+		})
+	}
+	engine.performFlowAnalysis()
+	assert.Equal(t, int64(0), engine.GetStatistics().AnalyzedFlows)
+
+	engine.expireFlows(time.Now())
+	assert.Equal(t, 0, engine.flows.len())
+	assert.Eventually(t, func() bool {
+		return engine.GetStatistics().AnalyzedFlows == 1
+	}, 5*time.Second, 10*time.Millisecond)
 }
 
 func TestGetStatistics(t *testing.T) {
@@ -196,6 +460,7 @@ func TestEngineStartStop(t *testing.T) {
 		Interface:  "eth0",
 		BPFFilter:  "tcp or udp",
 		BufferSize: 1024 * 1024,
+		Simulation: true,
 	}
 
 	cortexCfg := config.CortexConfig{
@@ -226,3 +491,230 @@ func TestEngineStartStop(t *testing.T) {
 	err = engine.Close()
 	assert.NoError(t, err)
 }
+
+// buildTCPPacket serializes an Ethernet/IPv4/TCP packet for decoding tests
+func buildTCPPacket(t *testing.T, srcIP, dstIP string, srcPort, dstPort uint16, syn, ack bool) gopacket.Packet {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.ParseIP(srcIP),
+		DstIP:    net.ParseIP(dstIP),
+	}
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(srcPort),
+		DstPort: layers.TCPPort(dstPort),
+		SYN:     syn,
+		ACK:     ack,
+		Window:  65535,
+	}
+	require.NoError(t, tcp.SetNetworkLayerForChecksum(ip))
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload("hello")))
+
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	packet.Metadata().Timestamp = time.Now()
+	packet.Metadata().Length = len(buf.Bytes())
+	packet.Metadata().CaptureLength = len(buf.Bytes())
+	return packet
+}
+
+func TestHandlePacket(t *testing.T) {
+	engine := &Engine{
+		flows: newFlowTable(0),
+		stats: &CaptureStats{},
+	}
+
+	engine.handlePacket(buildTCPPacket(t, "192.168.1.100", "8.8.8.8", 54321, 443, true, false))
+	engine.handlePacket(buildTCPPacket(t, "8.8.8.8", "192.168.1.100", 443, 54321, true, true))
+
+	// Both directions belong to the flow opened by the initiator
+	require.Equal(t, 1, engine.flows.len())
+	flow, exists := engine.flows.get("192.168.1.100:54321-8.8.8.8:443")
+	require.True(t, exists)
+
+	assert.Equal(t, "TCP", flow.Protocol)
+	assert.True(t, flow.SrcIP.Equal(net.ParseIP("192.168.1.100")))
+	assert.Equal(t, uint16(443), flow.DstPort)
+	require.Len(t, flow.Packets, 2)
+
+	assert.Equal(t, "outbound", flow.Packets[0].Direction)
+	assert.Equal(t, "SYN", flow.Packets[0].Headers["tcp_flags"])
+	assert.Equal(t, 5, flow.Packets[0].Headers["payload_size"])
+	assert.Equal(t, "inbound", flow.Packets[1].Direction)
+	assert.Equal(t, "SYN|ACK", flow.Packets[1].Headers["tcp_flags"])
+
+	assert.Equal(t, int64(2), engine.stats.TotalPackets)
+	assert.Equal(t, int64(1), engine.stats.ActiveFlows)
+}
+
+// buildVLANPacket serializes an Ethernet/IPv4/TCP packet carrying the given
+// 802.1Q tags, outermost first
+func buildVLANPacket(t *testing.T, vlans []uint16) gopacket.Packet {
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.ParseIP("10.0.0.1"),
+		DstIP:    net.ParseIP("10.0.0.2"),
+	}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 443, SYN: true}
+	require.NoError(t, tcp.SetNetworkLayerForChecksum(ip))
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		EthernetType: layers.EthernetTypeQinQ,
+	}
+	stack := []gopacket.SerializableLayer{eth}
+	for i, id := range vlans {
+		next := layers.EthernetTypeDot1Q
+		if i == len(vlans)-1 {
+			next = layers.EthernetTypeIPv4
+		}
+		stack = append(stack, &layers.Dot1Q{VLANIdentifier: id, Type: next})
+	}
+	stack = append(stack, ip, tcp)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, stack...))
+
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	packet.Metadata().Timestamp = time.Now()
+	packet.Metadata().Length = len(buf.Bytes())
+	return packet
+}
+
+func TestVLANFlowKey(t *testing.T) {
+	for _, keyed := range []bool{true, false} {
+		engine := &Engine{
+			config: config.CaptureConfig{VLANInFlowKey: keyed},
+			flows:  newFlowTable(0),
+			stats:  &CaptureStats{},
+		}
+
+		engine.handlePacket(buildVLANPacket(t, []uint16{100, 200}))
+		engine.handlePacket(buildVLANPacket(t, []uint16{100, 300}))
+
+		if !keyed {
+			assert.Equal(t, 1, engine.flows.len())
+			continue
+		}
+
+		require.Equal(t, 2, engine.flows.len())
+		flow, exists := engine.flows.get("vlan100.300/10.0.0.1:40000-10.0.0.2:443")
+		require.True(t, exists)
+		assert.Equal(t, []uint16{100, 300}, flow.VLANs)
+	}
+}
+
+func TestSetBPFFilter(t *testing.T) {
+	engine := &Engine{
+		config: config.CaptureConfig{BPFFilter: "tcp", SnapLen: 65535, Simulation: true},
+	}
+
+	err := engine.SetBPFFilter("tcp port not-a-port")
+	require.ErrorIs(t, err, ErrInvalidFilter)
+	assert.Equal(t, "tcp", engine.BPFFilter())
+
+	// An empty filter captures everything and needs no compilation
+	require.NoError(t, engine.SetBPFFilter(""))
+	assert.Equal(t, "", engine.BPFFilter())
+}
+
+func TestPacketSampling(t *testing.T) {
+	engine := &Engine{
+		config: config.CaptureConfig{SampleRate: 4},
+		flows:  newFlowTable(0),
+		stats:  &CaptureStats{},
+	}
+
+	for i := 0; i < 20; i++ {
+		engine.handlePacket(buildTCPPacket(t, "192.168.1.100", "8.8.8.8", 54321, 443, false, true))
+	}
+
+	flow, _ := engine.flows.get("192.168.1.100:54321-8.8.8.8:443")
+	require.NotNil(t, flow)
+	require.Len(t, flow.Packets, 5)
+	assert.Equal(t, 4, flow.Packets[0].Weight)
+	assert.Equal(t, int64(20), engine.stats.TotalPackets)
+	assert.Equal(t, int64(15), engine.stats.SampledOutPackets)
+
+	// Rate features and records are scaled back to the unsampled flow
+	assert.Equal(t, 20.0, engine.extractFeatures(flow)[20])
+	assert.Equal(t, uint64(20), flow.Record().Packets)
+}
+
+func TestFlowSampling(t *testing.T) {
+	engine := &Engine{
+		config: config.CaptureConfig{SampleRate: 4, FlowSampleAfter: 3},
+		flows:  newFlowTable(0),
+		stats:  &CaptureStats{},
+	}
+
+	for i := 0; i < 11; i++ {
+		engine.handlePacket(buildTCPPacket(t, "192.168.1.100", "8.8.8.8", 54321, 443, false, true))
+	}
+
+	// The first 3 packets are kept, then 1 in 4 of the remaining 8
+	flow, _ := engine.flows.get("192.168.1.100:54321-8.8.8.8:443")
+	require.NotNil(t, flow)
+	require.Len(t, flow.Packets, 5)
+	assert.Equal(t, 1, flow.Packets[2].Weight)
+	assert.Equal(t, 4, flow.Packets[3].Weight)
+	assert.Equal(t, int64(6), engine.stats.SampledOutPackets)
+	assert.Equal(t, uint64(11), flow.Record().Packets)
+}
+
+func TestEvictionFinalAnalysis(t *testing.T) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.85})
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+
+	engine := &Engine{
+		cortex: cortexEngine,
+		flows:  newFlowTable(flowShardCount),
+		ctx:    context.Background(),
+		stats:  &CaptureStats{},
+	}
+
+	for i := 0; i < 4*flowShardCount; i++ {
+		engine.addPacket(net.IPv4(10, 0, byte(i>>8), byte(i)), net.ParseIP("8.8.8.8"), 40000, 443, "TCP", &Packet{
+			Timestamp: time.Now(),
+			Size:      100,
+		})
+	}
+
+	stats := engine.GetStatistics()
+	assert.LessOrEqual(t, stats.ActiveFlows, int64(flowShardCount))
+	assert.Equal(t, int64(4*flowShardCount)-stats.ActiveFlows, stats.EvictedFlows)
+	assert.Eventually(t, func() bool {
+		return engine.GetStatistics().AnalyzedFlows == stats.EvictedFlows
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestExtractTLSRecordFeatures(t *testing.T) {
+	engine := &Engine{}
+
+	flow := &Flow{ID: "test-flow", StartTime: time.Now().Add(-time.Minute)}
+	flow.add(&Packet{Timestamp: time.Now(), Size: 100}, 0)
+	assert.Equal(t, make([]float64, 12), engine.extractFeatures(flow)[51:63])
+
+	flow.stats.tls.add([]tlsRecord{{tlsRecordHandshake, 517}}, false)
+	flow.stats.tls.add([]tlsRecord{{tlsRecordHandshake, 1400}, {tlsRecordHandshake, 800}}, true)
+	features := engine.extractFeatures(flow)
+	assert.Equal(t, []float64{3, 2717.0 / 3, 1.5, 2}, features[51:55])
+	var total float64
+	for _, share := range features[55:63] {
+		total += share
+	}
+	assert.InDelta(t, 1, total, 1e-9)
+}
@@ -0,0 +1,76 @@
+package argus
+
+import "math"
+
+const (
+	// maxEntropyBytes is the number of payload bytes per direction at the
+	// start of a flow whose values are counted
+	maxEntropyBytes = 16 * 1024
+	// byteHistogramBins is the number of ranges byte values are shared
+	// out to in the features: control characters, punctuation and digits,
+	// upper and lower case letters, and four ranges above ASCII
+	byteHistogramBins = 8
+)
+
+// byteCounts counts the values of the first payload bytes of a direction.
+// Text protocols keep to a few printable ranges, compressed and encrypted
+// data spreads evenly over all values, near 8 bits of entropy per byte,
+// and hand-rolled binary protocols show in between.
+type byteCounts struct {
+	counts [256]uint16
+	total  int
+}
+
+// add counts the bytes of a payload until maxEntropyBytes were counted
+func (c *byteCounts) add(payload []byte) {
+	for _, b := range payload[:min(len(payload), maxEntropyBytes-c.total)] {
+		c.counts[b]++
+	}
+	c.total = min(c.total+len(payload), maxEntropyBytes)
+}
+
+// ByteDistribution summarizes the payload bytes of one direction of a flow
+type ByteDistribution struct {
+	Bytes   int     `json:"bytes"`   // counted, at most the first 16 KiB
+	Entropy float64 `json:"entropy"` // Shannon entropy in bits per byte
+	Mean    float64 `json:"mean"`    // mean byte value
+	// Histogram holds the shares of byte values in eight ranges of 32
+	Histogram [byteHistogramBins]float64 `json:"histogram"`
+}
+
+// PayloadStats summarizes the payload bytes of both directions of a flow
+type PayloadStats struct {
+	Outbound ByteDistribution `json:"outbound"` // sent by the initiator
+	Inbound  ByteDistribution `json:"inbound"`
+}
+
+// payloadStats returns the API representation of the byte counts, nil for
+// flows without payload
+func payloadStats(counts [2]*byteCounts) *PayloadStats {
+	if counts[0] == nil && counts[1] == nil {
+		return nil
+	}
+	return &PayloadStats{Outbound: counts[0].distribution(), Inbound: counts[1].distribution()}
+}
+
+// distribution summarizes the counted bytes; a nil c counted none
+func (c *byteCounts) distribution() ByteDistribution {
+	if c == nil {
+		return ByteDistribution{}
+	}
+	d := ByteDistribution{Bytes: c.total}
+	if c.total == 0 {
+		return d
+	}
+	n := float64(c.total)
+	for value, count := range c.counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / n
+		d.Entropy -= p * math.Log2(p)
+		d.Mean += float64(value) * p
+		d.Histogram[value*byteHistogramBins/256] += p
+	}
+	return d
+}
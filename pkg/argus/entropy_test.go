@@ -0,0 +1,63 @@
+package argus
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestByteDistribution(t *testing.T) {
+	var c byteCounts
+	assert.Equal(t, ByteDistribution{}, c.distribution())
+
+	// Two values, evenly: one bit per byte
+	c.add([]byte("abababab"))
+	d := c.distribution()
+	assert.Equal(t, 8, d.Bytes)
+	assert.InDelta(t, 1, d.Entropy, 1e-12)
+	assert.InDelta(t, 97.5, d.Mean, 1e-12)
+	assert.Equal(t, 1.0, d.Histogram[3]) // lower case letters
+
+	// Random bytes come close to eight bits
+	var random byteCounts
+	data := make([]byte, 2*maxEntropyBytes)
+	rand.New(rand.NewSource(1)).Read(data)
+	random.add(data[:1000])
+	random.add(data[1000:])
+	d = random.distribution()
+	assert.Equal(t, maxEntropyBytes, d.Bytes)
+	assert.Greater(t, d.Entropy, 7.9)
+	var total float64
+	for _, share := range d.Histogram {
+		assert.InDelta(t, 1.0/byteHistogramBins, share, 0.02)
+		total += share
+	}
+	assert.InDelta(t, 1, total, 1e-9)
+
+	random.add(data)
+	assert.Equal(t, maxEntropyBytes, random.total)
+}
+
+func TestExtractPayloadFeatures(t *testing.T) {
+	engine := &Engine{}
+	start := time.Now()
+
+	flow := &Flow{ID: "test-flow", StartTime: start}
+	flow.add(&Packet{Timestamp: start, Size: 60, Direction: "outbound"}, 0)
+	features := engine.extractFeatures(flow)
+	assert.Equal(t, make([]float64, 20), features[100:120])
+
+	request := &Packet{Timestamp: start, Size: 100, Direction: "outbound", payload: []byte("GET / HTTP/1.1\r\n\r\n")}
+	flow.add(request, 0)
+	assert.Nil(t, request.payload)
+	flow.add(&Packet{Timestamp: start, Size: 100, Direction: "inbound", payload: []byte{0, 0, 0xff, 0xff}}, 0)
+
+	features = engine.extractFeatures(flow)
+	assert.Greater(t, features[100], 2.0)
+	assert.Greater(t, features[101], 32.0)
+	assert.Equal(t, []float64{1, 127.5, 0.5, 0, 0, 0, 0, 0, 0, 0.5}, features[110:120])
+	require.NotNil(t, flow.stats.payload[1])
+}
@@ -0,0 +1,208 @@
+package argus
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/google/gopacket/layers"
+)
+
+// pcapExporter writes the packets of flagged flows to pcapng files, one file
+// per flow, keeping the export directory within its size and age limits.
+// Raw frames are retained only until a flow is analyzed below the threshold.
+type pcapExporter struct {
+	dir       string
+	threshold float64
+	maxBytes  int64
+	retention time.Duration
+	snapLen   int
+	linkType  layers.LinkType
+	mu        sync.Mutex
+}
+
+// newPcapExporter creates the export directory and returns an exporter for it
+func newPcapExporter(cfg config.CaptureConfig, linkType layers.LinkType) (*pcapExporter, error) {
+	if err := os.MkdirAll(cfg.ExportDir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	return &pcapExporter{
+		dir:       cfg.ExportDir,
+		threshold: cfg.ExportThreshold,
+		maxBytes:  cfg.ExportMaxBytes,
+		retention: time.Duration(cfg.ExportRetention) * time.Hour,
+		snapLen:   cfg.SnapLen,
+		linkType:  linkType,
+	}, nil
+}
+
+// shouldExport reports whether a detection result qualifies for export
+func (x *pcapExporter) shouldExport(result *cortex.DetectionResult) bool {
+	return result.IsBot && result.Confidence >= x.threshold
+}
+
+// export writes the retained packets of a flow to a pcapng file whose
+// section comment carries the detection result. The file is named after
+// the flow, so a flow flagged again on a later analysis replaces its export.
+// It returns the file path and whether an earlier export was replaced, or
+// an empty path if the flow holds no raw packet data.
+func (x *pcapExporter) export(flow *Flow, result *cortex.DetectionResult) (string, bool, error) {
+	flow.mu.RLock()
+	started := flow.StartTime
+	packets := make([]*Packet, 0, len(flow.Packets))
+	for _, pkt := range flow.Packets {
+		if len(pkt.Data) > 0 {
+			packets = append(packets, pkt)
+		}
+	}
+	flow.mu.RUnlock()
+
+	if len(packets) == 0 {
+		return "", false, nil
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	// The start time tells apart flows reusing the same addresses and ports
+	name := fmt.Sprintf("%s_%s.pcapng", started.UTC().Format("20060102T150405.000Z"), sanitizeFileName(flow.ID))
+	path := filepath.Join(x.dir, name)
+	_, err := os.Stat(path)
+	replaced := err == nil
+
+	tmp, err := os.CreateTemp(x.dir, ".export-*")
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	comment := fmt.Sprintf("flow_id=%s is_bot=%t confidence=%.4f detected_at=%s reasoning=%q",
+		flow.ID, result.IsBot, result.Confidence, result.Timestamp.UTC().Format(time.RFC3339), result.Reasoning)
+
+	writer, err := newPcapngWriter(tmp, x.linkType, x.snapLen, comment)
+	if err != nil {
+		tmp.Close()
+		return "", false, fmt.Errorf("failed to write pcapng header: %w", err)
+	}
+	for _, pkt := range packets {
+		if err := writer.writePacket(pkt.Timestamp, pkt.Data, pkt.Size); err != nil {
+			tmp.Close()
+			return "", false, fmt.Errorf("failed to write packet: %w", err)
+		}
+	}
+	if err := writer.flush(); err != nil {
+		tmp.Close()
+		return "", false, fmt.Errorf("failed to flush pcapng file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", false, fmt.Errorf("failed to close export file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", false, fmt.Errorf("failed to finalize export file: %w", err)
+	}
+
+	if err := x.enforceLimits(); err != nil {
+		return path, replaced, fmt.Errorf("failed to enforce export limits: %w", err)
+	}
+
+	return path, replaced, nil
+}
+
+// enforceLimits removes exports older than the retention period, then the
+// oldest remaining exports until the directory fits within maxBytes
+func (x *pcapExporter) enforceLimits() error {
+	matches, err := filepath.Glob(filepath.Join(x.dir, "*.pcapng"))
+	if err != nil {
+		return err
+	}
+
+	type exportFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+
+	files := make([]exportFile, 0, len(matches))
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, exportFile{path: path, size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+
+	cutoff := time.Now().Add(-x.retention)
+	for _, f := range files {
+		expired := x.retention > 0 && f.modTime.Before(cutoff)
+		oversized := x.maxBytes > 0 && total > x.maxBytes
+		if !expired && !oversized {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= f.size
+	}
+
+	return nil
+}
+
+// purge removes the exports written before the cutoff and returns how many
+// were removed
+func (x *pcapExporter) purge(before time.Time) (int64, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	matches, err := filepath.Glob(filepath.Join(x.dir, "*.pcapng"))
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int64
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Before(before) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// PurgeExports removes the pcapng exports of flagged flows written before
+// the cutoff and returns how many were removed. Writing an export only
+// enforces the export retention when flows keep being flagged; a retention
+// janitor calls this to enforce it on quiet sensors too.
+func (e *Engine) PurgeExports(before time.Time) (int64, error) {
+	if e.exporter == nil {
+		return 0, nil
+	}
+	return e.exporter.purge(before)
+}
+
+// sanitizeFileName replaces characters that are awkward in file names
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '/', '\\', ' ', '[', ']':
+			return '_'
+		}
+		return r
+	}, name)
+}
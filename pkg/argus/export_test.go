@@ -0,0 +1,215 @@
+package argus
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportFlow(t *testing.T) {
+	dir := t.TempDir()
+	exporter, err := newPcapExporter(config.CaptureConfig{
+		ExportDir:       dir,
+		ExportThreshold: 0.9,
+		SnapLen:         65535,
+	}, layers.LinkTypeEthernet)
+	require.NoError(t, err)
+
+	engine := &Engine{
+		exporter: exporter,
+		flows:    newFlowTable(0),
+		stats:    &CaptureStats{},
+	}
+	engine.handlePacket(buildTCPPacket(t, "192.168.1.100", "8.8.8.8", 54321, 443, true, false))
+	engine.handlePacket(buildTCPPacket(t, "8.8.8.8", "192.168.1.100", 443, 54321, true, true))
+	flow, _ := engine.flows.get("192.168.1.100:54321-8.8.8.8:443")
+	require.NotNil(t, flow)
+
+	result := &cortex.DetectionResult{
+		IsBot:      true,
+		Confidence: 0.95,
+		Reasoning:  "uniform timing",
+		Timestamp:  time.Now(),
+		FlowID:     flow.ID,
+	}
+	assert.True(t, exporter.shouldExport(result))
+	assert.False(t, exporter.shouldExport(&cortex.DetectionResult{IsBot: true, Confidence: 0.5}))
+
+	engine.exportFlow(flow, result)
+	assert.Equal(t, int64(1), engine.GetStatistics().ExportedFlows)
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.pcapng"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	assert.NotContains(t, filepath.Base(matches[0]), ":")
+
+	data, err := os.ReadFile(matches[0])
+	require.NoError(t, err)
+	comment, packets := readPcapng(t, data)
+	assert.True(t, strings.Contains(comment, "flow_id="+flow.ID), comment)
+	assert.Contains(t, comment, "confidence=0.9500")
+	assert.Contains(t, comment, "uniform timing")
+
+	require.Len(t, packets, 2)
+	assert.Equal(t, flow.Packets[0].Data, packets[0])
+	assert.Equal(t, flow.Packets[1].Data, packets[1])
+
+	// Flagged again, e.g. on its final analysis, the flow replaces its export
+	engine.handlePacket(buildTCPPacket(t, "192.168.1.100", "8.8.8.8", 54321, 443, false, true))
+	engine.exportFlow(flow, result)
+	assert.Equal(t, int64(1), engine.GetStatistics().ExportedFlows)
+	matches, err = filepath.Glob(filepath.Join(dir, "*.pcapng"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	data, err = os.ReadFile(matches[0])
+	require.NoError(t, err)
+	_, packets = readPcapng(t, data)
+	assert.Len(t, packets, 3)
+}
+
+func TestKeepRecentPackets(t *testing.T) {
+	dir := t.TempDir()
+	exporter, err := newPcapExporter(config.CaptureConfig{
+		ExportDir:       dir,
+		ExportThreshold: 0.9,
+		SnapLen:         65535,
+	}, layers.LinkTypeEthernet)
+	require.NoError(t, err)
+
+	engine := &Engine{
+		exporter: exporter,
+		flows:    newFlowTable(0),
+		stats:    &CaptureStats{},
+	}
+	send := func(n int) {
+		for i := 0; i < n; i++ {
+			engine.handlePacket(buildTCPPacket(t, "192.168.1.100", "8.8.8.8", 54321, 443, false, true))
+		}
+	}
+	send(flowRecentPackets + 4)
+	flow, _ := engine.flows.get("192.168.1.100:54321-8.8.8.8:443")
+	require.NotNil(t, flow)
+
+	// A flow analyzed below the threshold keeps its latest packets only
+	latest := flow.Packets[4]
+	keepRecentPackets(flow)
+	require.Len(t, flow.Packets, flowRecentPackets)
+	assert.Same(t, latest, flow.Packets[0])
+
+	// and goes on keeping the latest ones as packets arrive
+	latest = flow.Packets[1]
+	send(1)
+	require.Len(t, flow.Packets, flowRecentPackets)
+	assert.Same(t, latest, flow.Packets[0])
+
+	// so it is exported with frames when a later analysis flags it
+	engine.exportFlow(flow, &cortex.DetectionResult{IsBot: true, Confidence: 0.95, Timestamp: time.Now(), FlowID: flow.ID})
+	assert.Equal(t, int64(1), engine.GetStatistics().ExportedFlows)
+	matches, err := filepath.Glob(filepath.Join(dir, "*.pcapng"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	data, err := os.ReadFile(matches[0])
+	require.NoError(t, err)
+	_, packets := readPcapng(t, data)
+	require.Len(t, packets, flowRecentPackets)
+	assert.Equal(t, flow.Packets[0].Data, packets[0])
+}
+
+// readPcapng returns the section comment and packet data of a pcapng file
+func readPcapng(t *testing.T, data []byte) (string, [][]byte) {
+	var comment string
+	var packets [][]byte
+
+	for len(data) > 0 {
+		require.GreaterOrEqual(t, len(data), 12)
+		blockType := binary.LittleEndian.Uint32(data[0:])
+		total := int(binary.LittleEndian.Uint32(data[4:]))
+		require.Equal(t, uint32(total), binary.LittleEndian.Uint32(data[total-4:]))
+		body := data[8 : total-4]
+
+		switch blockType {
+		case pcapngSectionHeader:
+			require.Equal(t, uint32(pcapngByteOrderMagic), binary.LittleEndian.Uint32(body[0:]))
+			for opts := body[16:]; len(opts) >= 4; {
+				code := binary.LittleEndian.Uint16(opts[0:])
+				length := int(binary.LittleEndian.Uint16(opts[2:]))
+				if code == pcapngOptEnd {
+					break
+				}
+				if code == pcapngOptComment {
+					comment = string(opts[4 : 4+length])
+				}
+				opts = opts[4+length+pad4(length):]
+			}
+		case pcapngEnhancedPacket:
+			capLen := int(binary.LittleEndian.Uint32(body[12:]))
+			packets = append(packets, body[20:20+capLen])
+		}
+
+		data = data[total:]
+	}
+
+	return comment, packets
+}
+
+func TestExportLimits(t *testing.T) {
+	dir := t.TempDir()
+	exporter := &pcapExporter{dir: dir, maxBytes: 250, retention: time.Hour}
+
+	write := func(name string, age time.Duration) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, make([]byte, 100), 0o600))
+		modTime := time.Now().Add(-age)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+		return path
+	}
+
+	expired := write("expired.pcapng", 2*time.Hour)
+	oldest := write("oldest.pcapng", 30*time.Minute)
+	middle := write("middle.pcapng", 20*time.Minute)
+	newest := write("newest.pcapng", 10*time.Minute)
+
+	require.NoError(t, exporter.enforceLimits())
+
+	assert.NoFileExists(t, expired)
+	assert.NoFileExists(t, oldest)
+	assert.FileExists(t, middle)
+	assert.FileExists(t, newest)
+}
+
+func TestPurgeExports(t *testing.T) {
+	dir := t.TempDir()
+	engine := &Engine{exporter: &pcapExporter{dir: dir}}
+
+	write := func(name string, age time.Duration) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, make([]byte, 100), 0o600))
+		modTime := time.Now().Add(-age)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+		return path
+	}
+
+	expired := write("expired.pcapng", 25*time.Hour)
+	recent := write("recent.pcapng", time.Hour)
+	other := write("notes.txt", 48*time.Hour)
+
+	purged, err := engine.PurgeExports(time.Now().Add(-24 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	assert.NoFileExists(t, expired)
+	assert.FileExists(t, recent)
+	assert.FileExists(t, other)
+
+	purged, err = (&Engine{}).PurgeExports(time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, purged, "exports are disabled")
+}
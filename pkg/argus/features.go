@@ -0,0 +1,197 @@
+package argus
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+)
+
+// FeatureExtractor computes a fixed number of features from a flow. The
+// engine concatenates the built-in flow features and the registered
+// extractors, ordered by name, into the feature vector sent to Cortex, so
+// registering an extractor changes the layout models are trained on.
+type FeatureExtractor interface {
+	// Name identifies the extractor and orders it in the feature vector
+	Name() string
+	// FeatureCount is the number of features Extract returns, constant for
+	// the life of the extractor
+	FeatureCount() int
+	// Extract returns the features of a flow. It is called with the flow
+	// read-locked, possibly for several flows at once, and must not modify
+	// the flow.
+	Extract(flow *Flow) []float64
+}
+
+// FeatureDescriber is implemented by extractors that describe their
+// features for the feature schema. The features of other extractors are
+// named after the extractor and their position, and are unbounded.
+type FeatureDescriber interface {
+	// Features returns FeatureCount specs, in the order Extract returns
+	// the features
+	Features() []ml.FeatureSpec
+}
+
+// flowFeatureExtractor names the built-in flow features, which always come
+// first in the feature vector
+const flowFeatureExtractor = "flow"
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   = make(map[string]FeatureExtractor)
+)
+
+// RegisterFeatureExtractor adds an extractor to the feature vectors of
+// engines created afterwards. Extractors must have distinct names and fit,
+// together with the others, in FeatureSize features.
+func RegisterFeatureExtractor(extractor FeatureExtractor) error {
+	name := extractor.Name()
+	if name == "" {
+		return fmt.Errorf("feature extractor has no name")
+	}
+	if extractor.FeatureCount() <= 0 {
+		return fmt.Errorf("feature extractor %s has no features", name)
+	}
+
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+
+	if _, exists := extractors[name]; exists || name == flowFeatureExtractor {
+		return fmt.Errorf("feature extractor %s is already registered", name)
+	}
+	size := flowFeatureCount + extractor.FeatureCount()
+	for _, registered := range extractors {
+		size += registered.FeatureCount()
+	}
+	if size > FeatureSize {
+		return fmt.Errorf("feature extractor %s needs %d features, %d are free",
+			name, extractor.FeatureCount(), FeatureSize-size+extractor.FeatureCount())
+	}
+
+	extractors[name] = extractor
+	return nil
+}
+
+// UnregisterFeatureExtractor removes an extractor from the feature vectors
+// of engines created afterwards
+func UnregisterFeatureExtractor(name string) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+
+	delete(extractors, name)
+}
+
+// FeatureExtractors returns the extractors in feature vector order: the
+// built-in flow features, then the registered extractors by name
+func FeatureExtractors() []FeatureExtractor {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+
+	list := make([]FeatureExtractor, 0, len(extractors)+1)
+	for _, extractor := range extractors {
+		list = append(list, extractor)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return append([]FeatureExtractor{flowFeatures{}}, list...)
+}
+
+// FeatureSpan locates the features of an extractor in the feature vector
+type FeatureSpan struct {
+	Extractor string `json:"extractor"`
+	Offset    int    `json:"offset"`
+	Count     int    `json:"count"`
+}
+
+// FeatureLayout returns where each extractor's features sit in the feature
+// vector of engines created now
+func FeatureLayout() []FeatureSpan {
+	return featureLayout(FeatureExtractors())
+}
+
+// FeatureSchema returns the schema of the feature vectors of engines
+// created now, for the ML engines and models they feed
+func FeatureSchema() *ml.FeatureSchema {
+	return featureSchema(FeatureExtractors())
+}
+
+// FeatureSchema returns the schema of the feature vectors the engine
+// extracts
+func (e *Engine) FeatureSchema() *ml.FeatureSchema {
+	return featureSchema(e.extractors)
+}
+
+// featureSchema describes the features of extractors laid out one after
+// the other. The built-in flow features and the unused slots are those of
+// ml.DefaultFeatureSchema.
+func featureSchema(list []FeatureExtractor) *ml.FeatureSchema {
+	schema := ml.DefaultFeatureSchema(FeatureSize)
+	for i, span := range featureLayout(list) {
+		if span.Extractor == flowFeatureExtractor {
+			continue
+		}
+		specs := make([]ml.FeatureSpec, span.Count)
+		if describer, ok := list[i].(FeatureDescriber); ok {
+			copy(specs, describer.Features())
+		}
+		for j := range specs {
+			if specs[j].Name == "" {
+				specs[j] = ml.FeatureSpec{Name: fmt.Sprintf("%s_%d", span.Extractor, j), Min: math.Inf(-1), Max: math.Inf(1)}
+			}
+		}
+		copy(schema.Features[span.Offset:], specs)
+	}
+	return schema
+}
+
+// featureLayout places extractors one after the other
+func featureLayout(list []FeatureExtractor) []FeatureSpan {
+	spans := make([]FeatureSpan, len(list))
+	offset := 0
+	for i, extractor := range list {
+		spans[i] = FeatureSpan{Extractor: extractor.Name(), Offset: offset, Count: extractor.FeatureCount()}
+		offset += extractor.FeatureCount()
+	}
+	return spans
+}
+
+// extractFeatures builds the feature vector of a flow from the engine's
+// extractors. Slots no extractor fills carry the fixed pattern of the
+// built-in features' unused slots.
+func (e *Engine) extractFeatures(flow *Flow) []float64 {
+	flow.mu.RLock()
+	defer flow.mu.RUnlock()
+
+	features := make([]float64, FeatureSize) // Match the model input size
+	if flow.stats.added == 0 {
+		return features
+	}
+
+	list := e.extractors
+	if list == nil {
+		list = []FeatureExtractor{flowFeatures{}}
+	}
+	offset := 0
+	for _, extractor := range list {
+		count := extractor.FeatureCount()
+		values := extractor.Extract(flow)
+		if len(values) != count {
+			slog.Warn("Feature extractor returned the wrong number of features",
+				"extractor", extractor.Name(), "expected", count, "got", len(values))
+		}
+		copy(features[offset:offset+count], values)
+		offset += count
+	}
+	for i := offset; i < FeatureSize; i++ {
+		features[i] = fillFeature(i)
+	}
+
+	return features
+}
+
+// fillFeature is the value of a feature slot that carries no signal
+func fillFeature(index int) float64 {
+	return float64(index%10) / 10.0
+}
@@ -0,0 +1,98 @@
+package argus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// constantFeatures is an extractor returning the same value for every
+// feature
+type constantFeatures struct {
+	name  string
+	count int
+	value float64
+}
+
+func (c constantFeatures) Name() string      { return c.name }
+func (c constantFeatures) FeatureCount() int { return c.count }
+func (c constantFeatures) Extract(*Flow) []float64 {
+	features := make([]float64, c.count)
+	for i := range features {
+		features[i] = c.value
+	}
+	return features
+}
+
+func TestFeatureExtractors(t *testing.T) {
+	flow := &Flow{ID: "test-flow", StartTime: time.Now().Add(-time.Minute)}
+	flow.add(&Packet{Timestamp: time.Now(), Size: 100}, 0)
+	builtin := (&Engine{extractors: FeatureExtractors()}).extractFeatures(flow)
+	assert.Equal(t, builtin, (&Engine{}).extractFeatures(flow))
+
+	// Registered extractors follow the flow features ordered by name
+	for _, extractor := range []constantFeatures{{"tls", 2, 7}, {"dns", 1, 5}} {
+		require.NoError(t, RegisterFeatureExtractor(extractor))
+		defer UnregisterFeatureExtractor(extractor.name)
+	}
+	assert.Equal(t, []FeatureSpan{
+		{Extractor: "flow", Offset: 0, Count: flowFeatureCount},
+		{Extractor: "dns", Offset: flowFeatureCount, Count: 1},
+		{Extractor: "tls", Offset: flowFeatureCount + 1, Count: 2},
+	}, FeatureLayout())
+
+	features := (&Engine{extractors: FeatureExtractors()}).extractFeatures(flow)
+	require.Len(t, features, FeatureSize)
+	assert.Equal(t, builtin[:flowFeatureCount], features[:flowFeatureCount])
+	assert.Equal(t, []float64{5, 7, 7}, features[flowFeatureCount:flowFeatureCount+3])
+	assert.Equal(t, builtin[flowFeatureCount+3:], features[flowFeatureCount+3:])
+
+	// Names are unique and the vector has a fixed size
+	assert.Error(t, RegisterFeatureExtractor(constantFeatures{"tls", 1, 0}))
+	assert.Error(t, RegisterFeatureExtractor(constantFeatures{"flow", 1, 0}))
+	assert.Error(t, RegisterFeatureExtractor(constantFeatures{"", 1, 0}))
+	assert.Error(t, RegisterFeatureExtractor(constantFeatures{"empty", 0, 0}))
+	assert.Error(t, RegisterFeatureExtractor(constantFeatures{"large", FeatureSize - flowFeatureCount - 2, 0}))
+	require.NoError(t, RegisterFeatureExtractor(constantFeatures{"large", FeatureSize - flowFeatureCount - 3, 1}))
+	defer UnregisterFeatureExtractor("large")
+
+	features = (&Engine{extractors: FeatureExtractors()}).extractFeatures(flow)
+	assert.Equal(t, 1.0, features[flowFeatureCount+1])
+	assert.Equal(t, 7.0, features[FeatureSize-1])
+}
+
+// describedFeatures is an extractor naming its features
+type describedFeatures struct{ constantFeatures }
+
+func (describedFeatures) Features() []ml.FeatureSpec {
+	return []ml.FeatureSpec{{Name: "tls_version", Min: 0, Max: 4}}
+}
+
+func TestFeatureSchema(t *testing.T) {
+	schema := FeatureSchema()
+	assert.Equal(t, ml.DefaultFeatureSchema(FeatureSize), schema)
+
+	// Built-in feature vectors fit the schema
+	flow := &Flow{ID: "test-flow", SrcIP: net.ParseIP("2001:db8::1"), StartTime: time.Now().Add(-time.Minute)}
+	flow.add(&Packet{Timestamp: time.Now(), Size: 100, Headers: map[string]interface{}{"flow_label": uint32(7)}}, 0)
+	flow.add(&Packet{Timestamp: time.Now(), Size: 9000}, 0)
+	assert.NoError(t, schema.Validate((&Engine{}).extractFeatures(flow)))
+
+	require.NoError(t, RegisterFeatureExtractor(describedFeatures{constantFeatures{"tls", 1, 3}}))
+	defer UnregisterFeatureExtractor("tls")
+	require.NoError(t, RegisterFeatureExtractor(constantFeatures{"dns", 2, 0}))
+	defer UnregisterFeatureExtractor("dns")
+
+	engine := &Engine{extractors: FeatureExtractors()}
+	schema = engine.FeatureSchema()
+	assert.Equal(t, FeatureSize, schema.Size())
+	names := schema.Names()
+	assert.Equal(t, ml.DefaultFeatureSchema(FeatureSize).Names()[:flowFeatureCount], names[:flowFeatureCount])
+	assert.Equal(t, []string{"dns_0", "dns_1", "tls_version", "unused_127"}, names[flowFeatureCount:flowFeatureCount+4])
+	assert.ErrorIs(t, ml.DefaultFeatureSchema(FeatureSize).Check(schema), ml.ErrFeatureSchemaMismatch)
+	assert.NoError(t, schema.Validate(engine.extractFeatures(flow)))
+}
@@ -0,0 +1,130 @@
+package argus
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+)
+
+const (
+	// feedHistorySize is the number of recent detections kept for clients
+	// resuming a stream
+	feedHistorySize = 1024
+	// feedBufferSize is the number of detections queued per subscriber
+	// before further ones are dropped for it
+	feedBufferSize = 256
+)
+
+// DetectionEvent is a detection result and its position in the feed. IDs
+// are "<epoch>-<sequence>", where the epoch identifies the process that
+// published the event, so IDs from before a restart are not mistaken for
+// current ones.
+type DetectionEvent struct {
+	ID     string
+	Result *cortex.DetectionResult
+	seq    uint64
+}
+
+// detectionFeed fans detection results out to subscribers. It keeps a short
+// history so clients that reconnect can pick up the events they missed.
+type detectionFeed struct {
+	mu      sync.Mutex
+	epoch   string
+	nextSeq uint64
+	history []DetectionEvent // ring buffer, oldest at start
+	start   int
+	subs    map[chan DetectionEvent]struct{}
+}
+
+// newDetectionFeed creates an empty detection feed with a new epoch
+func newDetectionFeed() *detectionFeed {
+	return &detectionFeed{
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		nextSeq: 1,
+		subs:    make(map[chan DetectionEvent]struct{}),
+	}
+}
+
+// parseID returns the sequence number of an event ID from this feed's
+// epoch. IDs that are malformed or from another epoch are not resumable.
+func (f *detectionFeed) parseID(id string) (uint64, bool) {
+	epoch, seq, found := strings.Cut(id, "-")
+	if !found || epoch != f.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	return n, err == nil
+}
+
+// publish assigns the result the next event ID and delivers it. Subscribers
+// that are not keeping up miss the event rather than stall analysis.
+func (f *detectionFeed) publish(result *cortex.DetectionResult) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	seq := f.nextSeq
+	f.nextSeq++
+	event := DetectionEvent{ID: f.epoch + "-" + strconv.FormatUint(seq, 10), Result: result, seq: seq}
+
+	if len(f.history) < feedHistorySize {
+		f.history = append(f.history, event)
+	} else {
+		f.history[f.start] = event
+		f.start = (f.start + 1) % feedHistorySize
+	}
+
+	for ch := range f.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// subscribe registers a subscriber and returns the retained events after
+// lastID, if it is one of this feed's IDs
+func (f *detectionFeed) subscribe(lastID string) ([]DetectionEvent, chan DetectionEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var missed []DetectionEvent
+	if lastSeq, ok := f.parseID(lastID); ok {
+		for i := range f.history {
+			if event := f.history[(f.start+i)%len(f.history)]; event.seq > lastSeq {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	ch := make(chan DetectionEvent, feedBufferSize)
+	f.subs[ch] = struct{}{}
+	return missed, ch
+}
+
+// unsubscribe removes a subscriber and closes its channel
+func (f *detectionFeed) unsubscribe(ch chan DetectionEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exists := f.subs[ch]; exists {
+		delete(f.subs, ch)
+		close(ch)
+	}
+}
+
+// SubscribeDetections streams detection results as flows are analyzed. When
+// lastID is an event ID from this process, retained events published after
+// it are returned first so a reconnecting client can resume; events older
+// than the retained history are lost. Empty, malformed and pre-restart IDs
+// replay nothing. cancel releases the subscription and closes the channel.
+func (e *Engine) SubscribeDetections(lastID string) (missed []DetectionEvent, events <-chan DetectionEvent, cancel func()) {
+	missed, ch := e.detections.subscribe(lastID)
+	return missed, ch, func() { e.detections.unsubscribe(ch) }
+}
@@ -0,0 +1,57 @@
+package argus
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectionFeed(t *testing.T) {
+	engine := &Engine{detections: newDetectionFeed()}
+
+	for i := 0; i < 3; i++ {
+		engine.detections.publish(&cortex.DetectionResult{FlowID: "before"})
+	}
+
+	missed, events, cancel := engine.SubscribeDetections("")
+	assert.Empty(t, missed)
+
+	engine.detections.publish(&cortex.DetectionResult{FlowID: "live"})
+	event := <-events
+	assert.Equal(t, engine.detections.epoch+"-4", event.ID)
+	assert.Equal(t, "live", event.Result.FlowID)
+
+	cancel()
+	_, open := <-events
+	assert.False(t, open)
+
+	// Resuming replays the retained events after the last one seen
+	missed, _, cancel = engine.SubscribeDetections(engine.detections.epoch + "-2")
+	defer cancel()
+	require.Len(t, missed, 2)
+	assert.Equal(t, engine.detections.epoch+"-3", missed[0].ID)
+	assert.Equal(t, event.ID, missed[1].ID)
+
+	// IDs from before a restart or not from the feed at all replay nothing
+	restarted := &Engine{detections: newDetectionFeed()}
+	restarted.detections.epoch += "x"
+	restarted.detections.publish(&cortex.DetectionResult{})
+	for _, id := range []string{event.ID, "4", "-4", restarted.detections.epoch + "-x"} {
+		missed, _, cancel := restarted.SubscribeDetections(id)
+		assert.Empty(t, missed, id)
+		cancel()
+	}
+
+	// Only the most recent events are retained
+	for i := 0; i < feedHistorySize; i++ {
+		engine.detections.publish(&cortex.DetectionResult{})
+	}
+	missed, _, cancel = engine.SubscribeDetections(engine.detections.epoch + "-1")
+	defer cancel()
+	require.Len(t, missed, feedHistorySize)
+	assert.Equal(t, engine.detections.epoch+"-5", missed[0].ID)
+	assert.Equal(t, fmt.Sprintf("%s-%d", engine.detections.epoch, 4+feedHistorySize), missed[len(missed)-1].ID)
+}
@@ -0,0 +1,235 @@
+package argus
+
+import (
+	"math"
+	"time"
+)
+
+// packetSizeBins are the upper bounds of the packet size histogram of a
+// flow, in bytes; larger packets fall in a last bin
+var packetSizeBins = [...]int{64, 128, 256, 512, 1024, 1500}
+
+// maxFlowLabels bounds the distinct IPv6 flow labels remembered per
+// direction, so that flows changing their label on every packet do not
+// grow without bound
+const maxFlowLabels = 64
+
+// flowStats are running aggregates of the packets of a flow, updated as
+// each packet arrives so that features are computed without revisiting
+// packets and a flow's memory does not grow with its packet count. Sampled
+// packets count with the number of packets they stand for.
+type flowStats struct {
+	added    int    // packets added, sampled ones once
+	packets  uint64 // original packets
+	bytes    uint64
+	outbound DirectionStats
+	inbound  DirectionStats
+
+	// Inter-arrival times; a sampled gap spans weight original intervals
+	last      time.Time
+	intervals runningStats
+
+	// Lengths and times of the first packets, outbound then inbound
+	splt [2]PacketSequence
+
+	// Values of the first payload bytes, outbound then inbound, allocated
+	// once a direction carries payload
+	payload [2]*byteCounts
+
+	sizes [len(packetSizeBins) + 1]uint64
+
+	// IPv6 flow labels by direction, and how many packets carried a label
+	// and how many of those a zero label
+	labels               map[string]map[uint32]bool
+	labelled, zeroLabels int
+
+	// QUIC: whether a long header was seen, and the spin bit of the short
+	// header packets after it, outbound then inbound
+	quic bool
+	spin [2]spinStats
+
+	dns  dnsStats
+	http httpStats
+	tls  tlsRecordStats
+	tcp  tcpState
+}
+
+// spinStats follows the QUIC spin bit of one direction. Endpoints that
+// spin flip it once per round trip; those that opt out keep it fixed or
+// randomize it on every packet.
+type spinStats struct {
+	packets, flips int
+	last           bool
+}
+
+// add records the spin bit of a short header packet
+func (s *spinStats) add(spin bool) {
+	if s.packets > 0 && spin != s.last {
+		s.flips++
+	}
+	s.packets++
+	s.last = spin
+}
+
+// runningStats accumulates the mean, variance and extremes of a series of
+// values with Welford's algorithm
+type runningStats struct {
+	n        int
+	mean, m2 float64
+	min, max float64
+}
+
+// add adds a value to the series
+func (s *runningStats) add(x float64) {
+	s.n++
+	if s.n == 1 || x < s.min {
+		s.min = x
+	}
+	if s.n == 1 || x > s.max {
+		s.max = x
+	}
+	delta := x - s.mean
+	s.mean += delta / float64(s.n)
+	s.m2 += delta * (x - s.mean)
+}
+
+// variance returns the population variance of the series
+func (s *runningStats) variance() float64 {
+	if s.n == 0 {
+		return 0
+	}
+	return math.Max(s.m2/float64(s.n), 0)
+}
+
+// add records a packet in the flow, keeping the packet itself while the
+// flow holds fewer than retain packets, or always when retain is not
+// positive. The caller holds f.mu.
+func (f *Flow) add(packet *Packet, retain int) {
+	s := &f.stats
+	weight := packet.weight()
+	s.added++
+	s.packets += uint64(weight)
+	s.bytes += uint64(packet.Size * weight)
+
+	dir := &s.outbound
+	if packet.Direction == "inbound" {
+		dir = &s.inbound
+	}
+	dir.Packets += uint64(weight)
+	dir.Bytes += uint64(packet.Size * weight)
+
+	var gap float64
+	if s.added > 1 {
+		gap = packet.Timestamp.Sub(s.last).Seconds()
+		s.intervals.add(gap / float64(weight))
+	}
+	s.last = packet.Timestamp
+
+	side := 0
+	if packet.Direction == "inbound" {
+		side = 1
+	}
+	s.splt[side].add(packet.Size, gap)
+	if flags, ok := packet.Headers["tcp_flags"].(string); ok {
+		payload, _ := packet.Headers["payload_size"].(int)
+		s.tcp.add(side, flags, payload)
+	}
+	if len(packet.payload) > 0 {
+		if s.payload[side] == nil {
+			s.payload[side] = &byteCounts{}
+		}
+		s.payload[side].add(packet.payload)
+		packet.payload = nil
+	}
+
+	bin := len(packetSizeBins)
+	for i, bound := range packetSizeBins {
+		if packet.Size <= bound {
+			bin = i
+			break
+		}
+	}
+	s.sizes[bin] += uint64(weight)
+
+	if label, ok := packet.Headers["flow_label"].(uint32); ok {
+		if s.labels == nil {
+			s.labels = make(map[string]map[uint32]bool)
+		}
+		seen := s.labels[packet.Direction]
+		if seen == nil {
+			seen = make(map[uint32]bool)
+			s.labels[packet.Direction] = seen
+		}
+		if len(seen) < maxFlowLabels {
+			seen[label] = true
+		}
+		s.labelled++
+		if label == 0 {
+			s.zeroLabels++
+		}
+	}
+
+	s.dns.add(packet.Headers)
+
+	// The first message parsed from a datagram in each direction describes
+	// the flow like the first of a stream does
+	if packet.info != nil {
+		if packet.Direction == "inbound" && f.ServerProtocol == nil {
+			f.ServerProtocol = packet.info
+		} else if packet.Direction != "inbound" && f.ClientProtocol == nil {
+			f.ClientProtocol = packet.info
+		}
+	}
+
+	if _, ok := packet.Headers["quic_version"]; ok {
+		s.quic = true
+	}
+	if spin, ok := packet.Headers["quic_spin"].(bool); ok && s.quic {
+		if packet.Direction == "inbound" {
+			s.spin[1].add(spin)
+		} else {
+			s.spin[0].add(spin)
+		}
+	}
+
+	switch {
+	case f.recentOnly:
+		limit := flowRecentPackets
+		if retain > 0 {
+			limit = min(limit, retain)
+		}
+		if len(f.Packets) >= limit {
+			f.Packets = append(f.Packets[:0], f.Packets[len(f.Packets)-limit+1:]...)
+		}
+		f.Packets = append(f.Packets, packet)
+	case retain <= 0 || len(f.Packets) < retain:
+		f.Packets = append(f.Packets, packet)
+	}
+	f.LastSeen = packet.Timestamp
+}
+
+// flowLabelChanges returns the distinct flow labels per direction beyond
+// the first
+func (s *flowStats) flowLabelChanges() float64 {
+	var distinct int
+	for _, seen := range s.labels {
+		distinct += len(seen)
+	}
+	return float64(distinct)/float64(len(s.labels)) - 1
+}
+
+// spinFlipRatio returns the share of consecutive short header packets of
+// a direction whose spin bits differ
+func (s *flowStats) spinFlipRatio() float64 {
+	var pairs, flips int
+	for _, dir := range s.spin {
+		if dir.packets > 1 {
+			pairs += dir.packets - 1
+			flips += dir.flips
+		}
+	}
+	if pairs == 0 {
+		return 0
+	}
+	return float64(flips) / float64(pairs)
+}
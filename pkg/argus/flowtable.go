@@ -0,0 +1,190 @@
+package argus
+
+import (
+	"container/list"
+	"hash/maphash"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// flowShardCount is the number of independently locked flow table shards,
+// fewer for tables bounded to fewer flows
+const flowShardCount = 64
+
+// flowTable is the set of tracked flows, split into shards with their own
+// locks so capture workers on different cores rarely contend. When bounded,
+// the shards split the limit between them and each evicts its least
+// recently used flow to make room.
+type flowTable struct {
+	shards []flowShard
+	seed   maphash.Seed
+	count  atomic.Int64
+}
+
+// flowShard is a single locked partition of the flow table
+type flowShard struct {
+	mu    sync.RWMutex
+	flows map[string]*list.Element // values are *Flow
+	lru   *list.List               // most recently used at the front
+	max   int                      // flows held, unbounded when 0
+}
+
+// newFlowTable creates an empty flow table holding at most maxFlows flows,
+// or an unbounded one when maxFlows is not positive
+func newFlowTable(maxFlows int) *flowTable {
+	shards := flowShardCount
+	if maxFlows > 0 {
+		shards = min(shards, maxFlows)
+	}
+	t := &flowTable{shards: make([]flowShard, shards), seed: maphash.MakeSeed()}
+	for i := range t.shards {
+		t.shards[i].flows = make(map[string]*list.Element)
+		t.shards[i].lru = list.New()
+		if maxFlows > 0 {
+			// The first shards take the remainder, so the limits sum to maxFlows
+			t.shards[i].max = maxFlows / shards
+			if i < maxFlows%shards {
+				t.shards[i].max++
+			}
+		}
+	}
+	return t
+}
+
+// shard returns the shard for a flow. Both directions of a conversation
+// hash to the same shard so replies can be matched under a single lock,
+// whichever direction the ID was generated for: the endpoints of the ID
+// are hashed in a fixed order.
+func (t *flowTable) shard(flowID string) *flowShard {
+	prefix, endpoints := "", flowID
+	if i := strings.LastIndexByte(flowID, '/'); i >= 0 {
+		prefix, endpoints = flowID[:i+1], flowID[i+1:]
+	}
+	a, b, _ := strings.Cut(endpoints, "-")
+	if b < a {
+		a, b = b, a
+	}
+
+	var h maphash.Hash
+	h.SetSeed(t.seed)
+	h.WriteString(prefix)
+	h.WriteString(a)
+	h.WriteByte('-')
+	h.WriteString(b)
+	return &t.shards[h.Sum64()%uint64(len(t.shards))]
+}
+
+// update finds the flow for flowID, or for reverseID when only the reply
+// direction is tracked, creating it with newFlow if neither exists. fn runs
+// with the shard locked, so the flow cannot be expired concurrently.
+// It reports whether a new flow was created and returns the flow evicted
+// to make room for it, if any.
+func (t *flowTable) update(flowID, reverseID string, newFlow func() *Flow, fn func(flow *Flow, reverse bool)) (bool, *Flow) {
+	s := t.shard(flowID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, exists := s.flows[flowID]; exists {
+		s.lru.MoveToFront(elem)
+		fn(elem.Value.(*Flow), false)
+		return false, nil
+	}
+	if reverseID != "" {
+		if elem, exists := s.flows[reverseID]; exists {
+			s.lru.MoveToFront(elem)
+			fn(elem.Value.(*Flow), true)
+			return false, nil
+		}
+	}
+
+	evicted := t.insert(s, newFlow())
+	fn(s.lru.Front().Value.(*Flow), false)
+	return true, evicted
+}
+
+// insert adds a flow to a locked shard, evicting the least recently used
+// flow when the shard is full
+func (t *flowTable) insert(s *flowShard, flow *Flow) *Flow {
+	var evicted *Flow
+	if s.max > 0 && s.lru.Len() >= s.max {
+		oldest := s.lru.Back()
+		evicted = s.lru.Remove(oldest).(*Flow)
+		delete(s.flows, evicted.ID)
+		t.count.Add(-1)
+	}
+
+	s.flows[flow.ID] = s.lru.PushFront(flow)
+	t.count.Add(1)
+	return evicted
+}
+
+// get returns the flow stored under id
+func (t *flowTable) get(id string) (*Flow, bool) {
+	s := t.shard(id)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elem, exists := s.flows[id]
+	if !exists {
+		return nil, false
+	}
+	return elem.Value.(*Flow), true
+}
+
+// set stores a flow under its ID, replacing any previous flow, and returns
+// the flow evicted to make room for it, if any
+func (t *flowTable) set(flow *Flow) *Flow {
+	s := t.shard(flow.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, exists := s.flows[flow.ID]; exists {
+		elem.Value = flow
+		s.lru.MoveToFront(elem)
+		return nil
+	}
+	return t.insert(s, flow)
+}
+
+// len returns the number of tracked flows
+func (t *flowTable) len() int {
+	return int(t.count.Load())
+}
+
+// forEach calls fn for every flow until fn returns false. Each shard is
+// read-locked while it is visited.
+func (t *flowTable) forEach(fn func(flow *Flow) bool) {
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mu.RLock()
+		for _, elem := range s.flows {
+			if !fn(elem.Value.(*Flow)) {
+				s.mu.RUnlock()
+				return
+			}
+		}
+		s.mu.RUnlock()
+	}
+}
+
+// removeIf deletes every flow for which pred returns true and returns them
+func (t *flowTable) removeIf(pred func(flow *Flow) bool) []*Flow {
+	var removed []*Flow
+
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mu.Lock()
+		for id, elem := range s.flows {
+			if flow := elem.Value.(*Flow); pred(flow) {
+				s.lru.Remove(elem)
+				delete(s.flows, id)
+				removed = append(removed, flow)
+			}
+		}
+		s.mu.Unlock()
+	}
+	t.count.Add(-int64(len(removed)))
+
+	return removed
+}
@@ -0,0 +1,171 @@
+package argus
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlowTable(t *testing.T) {
+	table := newFlowTable(0)
+	newFlow := func(id string) func() *Flow {
+		return func() *Flow { return &Flow{ID: id} }
+	}
+
+	var reverse bool
+	created, _ := table.update("a:1-b:2", "b:2-a:1", newFlow("a:1-b:2"), func(_ *Flow, r bool) { reverse = r })
+	assert.True(t, created)
+	assert.False(t, reverse)
+
+	// The reply direction resolves to the initiator's flow
+	created, _ = table.update("b:2-a:1", "a:1-b:2", newFlow("b:2-a:1"), func(flow *Flow, r bool) {
+		assert.Equal(t, "a:1-b:2", flow.ID)
+		reverse = r
+	})
+	assert.False(t, created)
+	assert.True(t, reverse)
+	assert.Equal(t, 1, table.len())
+
+	for i := 0; i < 100; i++ {
+		table.set(&Flow{ID: fmt.Sprintf("flow-%d", i)})
+	}
+	assert.Equal(t, 101, table.len())
+
+	var visited int
+	table.forEach(func(*Flow) bool {
+		visited++
+		return true
+	})
+	assert.Equal(t, 101, visited)
+
+	removed := table.removeIf(func(flow *Flow) bool { return flow.ID != "a:1-b:2" })
+	assert.Len(t, removed, 100)
+	assert.Equal(t, 1, table.len())
+
+	_, exists := table.get("a:1-b:2")
+	require.True(t, exists)
+
+	// Flows whose reply direction sorts first are found by their own ID too
+	table.update("z:1-b:2", "b:2-z:1", newFlow("z:1-b:2"), func(*Flow, bool) {})
+	_, exists = table.get("z:1-b:2")
+	assert.True(t, exists)
+}
+
+func TestFlowTableEviction(t *testing.T) {
+	// One flow per shard, so every shard evicts on its second flow
+	table := newFlowTable(flowShardCount)
+
+	var evicted []*Flow
+	for i := 0; i < 4*flowShardCount; i++ {
+		if flow := table.set(&Flow{ID: fmt.Sprintf("flow-%d", i)}); flow != nil {
+			evicted = append(evicted, flow)
+		}
+	}
+	assert.LessOrEqual(t, table.len(), flowShardCount)
+	assert.Len(t, evicted, 4*flowShardCount-table.len())
+
+	// The table never holds more flows than its limit, whether it is below,
+	// at or above the shard count or not a multiple of it
+	for _, maxFlows := range []int{1, 10, flowShardCount - 1, flowShardCount + 1, 3*flowShardCount + 17} {
+		table = newFlowTable(maxFlows)
+		for i := 0; i < 8*maxFlows+flowShardCount; i++ {
+			table.set(&Flow{ID: fmt.Sprintf("flow-%d", i)})
+			require.LessOrEqual(t, table.len(), maxFlows, "max_flows %d", maxFlows)
+		}
+	}
+
+	// Touching a flow protects it from eviction in its shard
+	table = newFlowTable(2 * flowShardCount)
+	newFlow := func(id string) func() *Flow {
+		return func() *Flow { return &Flow{ID: id} }
+	}
+	table.set(&Flow{ID: "old"})
+	shard := table.shard("old")
+
+	var ids []string
+	for i := 0; len(ids) < 2; i++ {
+		id := fmt.Sprintf("flow-%d", i)
+		if table.shard(id) == shard {
+			ids = append(ids, id)
+		}
+	}
+	table.update(ids[0], "", newFlow(ids[0]), func(*Flow, bool) {})
+	table.update("old", "", newFlow("old"), func(*Flow, bool) {})
+	_, flow := table.update(ids[1], "", newFlow(ids[1]), func(*Flow, bool) {})
+	require.NotNil(t, flow)
+	assert.Equal(t, ids[0], flow.ID)
+}
+
+// benchmarkPackets returns packet endpoints spread over many flows
+func benchmarkPackets(n int) [][2]net.IP {
+	endpoints := make([][2]net.IP, n)
+	for i := range endpoints {
+		endpoints[i] = [2]net.IP{
+			net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)),
+			net.IPv4(192, 168, byte(i>>8), byte(i)),
+		}
+	}
+	return endpoints
+}
+
+// BenchmarkAddPacketParallel measures flow table insertion from concurrent
+// capture workers with the sharded table
+func BenchmarkAddPacketParallel(b *testing.B) {
+	engine := &Engine{
+		flows: newFlowTable(0),
+		stats: &CaptureStats{},
+	}
+	endpoints := benchmarkPackets(4096)
+	var next atomic.Uint64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ep := endpoints[next.Add(1)%uint64(len(endpoints))]
+			engine.addPacket(ep[0], ep[1], 40000, 443, "TCP", &Packet{Timestamp: time.Now(), Size: 100})
+		}
+	})
+}
+
+// BenchmarkAddPacketGlobalMutex is the same workload against a single
+// RWMutex-protected map, the layout the sharded table replaced
+func BenchmarkAddPacketGlobalMutex(b *testing.B) {
+	var mu sync.RWMutex
+	flows := make(map[string]*Flow)
+	engine := &Engine{}
+	endpoints := benchmarkPackets(4096)
+	var next atomic.Uint64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ep := endpoints[next.Add(1)%uint64(len(endpoints))]
+			flowID := engine.generateFlowID(ep[0].String(), ep[1].String(), 40000, 443)
+			reverseID := engine.generateFlowID(ep[1].String(), ep[0].String(), 443, 40000)
+			packet := &Packet{Timestamp: time.Now(), Size: 100}
+
+			mu.Lock()
+			flow, exists := flows[flowID]
+			if !exists {
+				flow, exists = flows[reverseID]
+			}
+			if !exists {
+				flow = &Flow{ID: flowID}
+				flows[flowID] = flow
+			}
+			flow.mu.Lock()
+			flow.Packets = append(flow.Packets, packet)
+			flow.LastSeen = packet.Timestamp
+			flow.mu.Unlock()
+			mu.Unlock()
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package argus
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	errCaptureNotStarted = errors.New("capture not started")
+	errCaptureStopped    = errors.New("capture stopped")
+	errCaptureEnded      = errors.New("capture source ended")
+)
+
+// Sink names reported by SinkHealth
+const (
+	SinkPcapExport = "pcap_export"
+	SinkIPFIX      = "ipfix"
+)
+
+// health tracks the state of capture and the flow sinks for readiness checks
+type health struct {
+	captureErr error            // why capture is not running, nil while it is
+	sinkErrs   map[string]error // result of the last write to each sink
+}
+
+// setCaptureState records whether capture is running
+func (e *Engine) setCaptureState(err error) {
+	e.healthMu.Lock()
+	defer e.healthMu.Unlock()
+	e.health.captureErr = err
+}
+
+// setSinkState records the result of a write to a sink
+func (e *Engine) setSinkState(sink string, err error) {
+	e.healthMu.Lock()
+	defer e.healthMu.Unlock()
+	if e.health.sinkErrs == nil {
+		e.health.sinkErrs = make(map[string]error)
+	}
+	e.health.sinkErrs[sink] = err
+}
+
+// CaptureHealth returns nil while packets are being captured, or why they
+// are not
+func (e *Engine) CaptureHealth() error {
+	e.healthMu.RLock()
+	defer e.healthMu.RUnlock()
+	return e.health.captureErr
+}
+
+// SinkHealth returns the state of each configured flow sink: nil when it
+// is reachable, or the error of its last failed write
+func (e *Engine) SinkHealth() map[string]error {
+	e.healthMu.RLock()
+	defer e.healthMu.RUnlock()
+
+	sinks := make(map[string]error)
+	if e.exporter != nil {
+		sinks[SinkPcapExport] = e.health.sinkErrs[SinkPcapExport]
+		if info, err := os.Stat(e.exporter.dir); err != nil {
+			sinks[SinkPcapExport] = err
+		} else if !info.IsDir() {
+			sinks[SinkPcapExport] = fmt.Errorf("%s is not a directory", e.exporter.dir)
+		}
+	}
+	if e.ipfix != nil {
+		sinks[SinkIPFIX] = e.health.sinkErrs[SinkIPFIX]
+	}
+
+	e.sinksMu.RLock()
+	defer e.sinksMu.RUnlock()
+	for _, sink := range e.sinks {
+		sinks[sink.Name()] = sink.Health()
+	}
+	return sinks
+}
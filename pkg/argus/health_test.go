@@ -0,0 +1,51 @@
+package argus
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptureHealth(t *testing.T) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.8})
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+
+	engine, err := NewEngine(config.CaptureConfig{Simulation: true}, cortexEngine)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	assert.ErrorIs(t, engine.CaptureHealth(), errCaptureNotStarted)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, engine.Start(ctx))
+	assert.Eventually(t, func() bool { return engine.CaptureHealth() == nil }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		return errors.Is(engine.CaptureHealth(), errCaptureStopped)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSinkHealth(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "exports")
+	require.NoError(t, os.Mkdir(dir, 0o750))
+
+	engine := &Engine{exporter: &pcapExporter{dir: dir}}
+	assert.Equal(t, map[string]error{SinkPcapExport: nil}, engine.SinkHealth())
+
+	engine.setSinkState(SinkPcapExport, errors.New("disk full"))
+	assert.EqualError(t, engine.SinkHealth()[SinkPcapExport], "disk full")
+
+	engine.setSinkState(SinkPcapExport, nil)
+	require.NoError(t, os.Remove(dir))
+	assert.Error(t, engine.SinkHealth()[SinkPcapExport])
+}
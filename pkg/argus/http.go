@@ -0,0 +1,192 @@
+package argus
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+)
+
+const (
+	// maxPendingHTTPRequests bounds the requests of a flow waiting for
+	// their response; the oldest is given up on when another arrives
+	maxPendingHTTPRequests = 64
+	// maxRecentHTTPTransactions bounds the answered transactions a flow
+	// keeps for the API; the aggregates cover all of them
+	maxRecentHTTPTransactions = 16
+)
+
+// HTTPTransaction is an HTTP request of a flow and the response to it
+type HTTPTransaction struct {
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	StreamID    uint32    `json:"stream_id,omitempty"` // HTTP/2 only
+	RequestedAt time.Time `json:"requested_at"`
+	StatusCode  int       `json:"status_code"`
+	// ResponseBytes is the body length the response declared, -1 when it
+	// declared none, as chunked responses do
+	ResponseBytes int64 `json:"response_bytes"`
+	// TimeToFirstByte is the time from the request to the response
+	// header, in seconds
+	TimeToFirstByte float64 `json:"ttfb"`
+}
+
+// HTTPStats describes the HTTP transactions of a flow
+type HTTPStats struct {
+	Transactions int               `json:"transactions"`
+	Errors       int               `json:"errors"`  // answered 4xx or 5xx
+	Pending      int               `json:"pending"` // requests not answered yet
+	MeanTTFB     float64           `json:"mean_ttfb"`
+	MaxTTFB      float64           `json:"max_ttfb"`
+	Recent       []HTTPTransaction `json:"recent,omitempty"` // latest first
+}
+
+// httpStats pairs the HTTP requests of a flow with their responses, in
+// order for HTTP/1.x and by stream for HTTP/2, and keeps running
+// aggregates of the transactions. It counts the messages breaking the
+// protocol's state machine: requests sent on an HTTP/1.x connection either
+// side announced to close, and responses to requests never made.
+type httpStats struct {
+	pending []HTTPTransaction // requests waiting for a response, oldest first
+	recent  []HTTPTransaction // answered transactions, oldest first
+
+	requests, transactions, errors int
+	ttfb                           runningStats // seconds
+	responseBytes                  runningStats // over responses that declared a length
+
+	closing                 bool // a message announced the connection's close
+	afterClose, unsolicited int
+}
+
+// add records an HTTP message parsed at seen. Other messages are ignored.
+func (s *httpStats) add(info *protocol.ProtocolInfo, seen time.Time) {
+	switch {
+	case info.Method != "":
+		if s.closing {
+			s.afterClose++
+		}
+		s.closing = s.closing || closesConnection(info)
+		s.requests++
+		if len(s.pending) == maxPendingHTTPRequests {
+			s.pending = s.pending[1:]
+		}
+		s.pending = append(s.pending, HTTPTransaction{
+			Method:        info.Method,
+			Path:          info.Path,
+			StreamID:      info.StreamID,
+			RequestedAt:   seen,
+			ResponseBytes: -1,
+		})
+	case info.StatusCode >= 200:
+		// Interim 1xx responses precede the final one
+		s.answer(info, seen)
+		s.closing = s.closing || closesConnection(info)
+	}
+}
+
+// closesConnection reports whether an HTTP/1.x message is the last of its
+// connection: it says so, or it is HTTP/1.0 and does not ask to keep the
+// connection alive
+func closesConnection(info *protocol.ProtocolInfo) bool {
+	if info.StreamID != 0 || !strings.HasPrefix(info.Version, "HTTP/1.") {
+		return false
+	}
+	connection, _ := header(info.Headers, "Connection")
+	for _, option := range strings.Split(connection, ",") {
+		switch strings.ToLower(strings.TrimSpace(option)) {
+		case "close":
+			return true
+		case "keep-alive":
+			return false
+		}
+	}
+	return info.Version == "HTTP/1.0"
+}
+
+// answer completes the request a response belongs to
+func (s *httpStats) answer(info *protocol.ProtocolInfo, seen time.Time) {
+	i := 0
+	if info.StreamID != 0 {
+		for i < len(s.pending) && s.pending[i].StreamID != info.StreamID {
+			i++
+		}
+	}
+	if i == len(s.pending) {
+		// The request was not seen, as when the capture started after it,
+		// or never made
+		if s.requests > 0 {
+			s.unsolicited++
+		}
+		return
+	}
+	tx := s.pending[i]
+	s.pending = append(s.pending[:i], s.pending[i+1:]...)
+
+	tx.StatusCode = info.StatusCode
+	tx.TimeToFirstByte = math.Max(seen.Sub(tx.RequestedAt).Seconds(), 0)
+	tx.ResponseBytes = contentLength(info.Headers)
+
+	s.transactions++
+	if tx.StatusCode >= 400 {
+		s.errors++
+	}
+	s.ttfb.add(tx.TimeToFirstByte)
+	if tx.ResponseBytes >= 0 {
+		s.responseBytes.add(float64(tx.ResponseBytes))
+	}
+
+	if len(s.recent) == maxRecentHTTPTransactions {
+		s.recent = s.recent[1:]
+	}
+	s.recent = append(s.recent, tx)
+}
+
+// errorRatio returns the share of answered requests that failed
+func (s *httpStats) errorRatio() float64 {
+	if s.transactions == 0 {
+		return 0
+	}
+	return float64(s.errors) / float64(s.transactions)
+}
+
+// summary returns the API representation of the stats, nil for flows
+// without HTTP requests
+func (s *httpStats) summary() *HTTPStats {
+	if s.transactions == 0 && len(s.pending) == 0 {
+		return nil
+	}
+	summary := &HTTPStats{
+		Transactions: s.transactions,
+		Errors:       s.errors,
+		Pending:      len(s.pending),
+		MeanTTFB:     s.ttfb.mean,
+		MaxTTFB:      s.ttfb.max,
+	}
+	for i := len(s.recent) - 1; i >= 0; i-- {
+		summary.Recent = append(summary.Recent, s.recent[i])
+	}
+	return summary
+}
+
+// contentLength returns the Content-Length of a message's headers, or -1
+func contentLength(headers map[string]string) int64 {
+	if value, ok := header(headers, "Content-Length"); ok {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return -1
+}
+
+// header looks a header up by name, whatever its case: HTTP/1.x keeps
+// the case the client sent, HTTP/2 lowercases names
+func header(headers map[string]string, name string) (string, bool) {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,94 @@
+package argus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+)
+
+func TestHTTPTransactions(t *testing.T) {
+	start := time.Now()
+	var s httpStats
+
+	// Pipelined HTTP/1.1 requests are answered in order
+	s.add(&protocol.ProtocolInfo{Protocol: "HTTP/1.1", Method: "GET", Path: "/a"}, start)
+	s.add(&protocol.ProtocolInfo{Protocol: "HTTP/1.1", Method: "POST", Path: "/login"}, start.Add(10*time.Millisecond))
+	s.add(&protocol.ProtocolInfo{Protocol: "HTTP/1.1", StatusCode: 100}, start.Add(20*time.Millisecond))
+	s.add(&protocol.ProtocolInfo{Protocol: "HTTP/1.1", StatusCode: 200,
+		Headers: map[string]string{"Content-Length": "512"}}, start.Add(50*time.Millisecond))
+	s.add(&protocol.ProtocolInfo{Protocol: "HTTP/1.1", StatusCode: 401,
+		Headers: map[string]string{"Transfer-Encoding": "chunked"}}, start.Add(160*time.Millisecond))
+
+	// A response to a request the capture missed is not paired
+	s.add(&protocol.ProtocolInfo{Protocol: "HTTP/1.1", StatusCode: 200}, start.Add(time.Second))
+	s.add(&protocol.ProtocolInfo{Protocol: "SSH"}, start)
+
+	summary := s.summary()
+	require.NotNil(t, summary)
+	assert.Equal(t, 2, summary.Transactions)
+	assert.Equal(t, 1, summary.Errors)
+	assert.Equal(t, 0, summary.Pending)
+	assert.InDelta(t, 0.1, summary.MeanTTFB, 1e-9)
+	assert.InDelta(t, 0.15, summary.MaxTTFB, 1e-9)
+	require.Len(t, summary.Recent, 2)
+	assert.Equal(t, "/login", summary.Recent[0].Path)
+	assert.Equal(t, 401, summary.Recent[0].StatusCode)
+	assert.Equal(t, int64(-1), summary.Recent[0].ResponseBytes)
+	assert.Equal(t, "/a", summary.Recent[1].Path)
+	assert.Equal(t, int64(512), summary.Recent[1].ResponseBytes)
+	assert.Equal(t, 0.5, s.errorRatio())
+	assert.Equal(t, 512.0, s.responseBytes.mean)
+}
+
+func TestHTTP2Transactions(t *testing.T) {
+	start := time.Now()
+	var s httpStats
+
+	// HTTP/2 answers streams in any order
+	s.add(&protocol.ProtocolInfo{Protocol: "HTTP/2", Method: "GET", Path: "/slow", StreamID: 1}, start)
+	s.add(&protocol.ProtocolInfo{Protocol: "HTTP/2", Method: "GET", Path: "/fast", StreamID: 3}, start)
+	s.add(&protocol.ProtocolInfo{Protocol: "HTTP/2", StatusCode: 204, StreamID: 3}, start.Add(time.Millisecond))
+
+	summary := s.summary()
+	require.NotNil(t, summary)
+	assert.Equal(t, 1, summary.Transactions)
+	assert.Equal(t, 1, summary.Pending)
+	require.Len(t, summary.Recent, 1)
+	assert.Equal(t, "/fast", summary.Recent[0].Path)
+	assert.Equal(t, uint32(3), summary.Recent[0].StreamID)
+}
+
+func TestHTTPTransactionsBounded(t *testing.T) {
+	start := time.Now()
+	var s httpStats
+	assert.Nil(t, s.summary())
+
+	for i := 0; i < 2*maxPendingHTTPRequests; i++ {
+		s.add(&protocol.ProtocolInfo{Protocol: "HTTP/1.1", Method: "GET", Path: "/"}, start)
+	}
+	assert.Len(t, s.pending, maxPendingHTTPRequests)
+	for i := 0; i < 2*maxPendingHTTPRequests; i++ {
+		s.add(&protocol.ProtocolInfo{Protocol: "HTTP/1.1", StatusCode: 404}, start)
+	}
+	assert.Len(t, s.recent, maxRecentHTTPTransactions)
+	assert.Equal(t, maxPendingHTTPRequests, s.transactions)
+	assert.Equal(t, 1.0, s.errorRatio())
+}
+
+func TestExtractHTTPFeatures(t *testing.T) {
+	engine := &Engine{}
+	start := time.Now()
+
+	flow := &Flow{ID: "test-flow", StartTime: start.Add(-time.Minute)}
+	flow.add(&Packet{Timestamp: start, Size: 100}, 0)
+	assert.Equal(t, []float64{0, 0, 0, 0, 0}, engine.extractFeatures(flow)[40:45])
+
+	flow.stats.http.add(&protocol.ProtocolInfo{Method: "GET", Path: "/"}, start)
+	flow.stats.http.add(&protocol.ProtocolInfo{StatusCode: 500,
+		Headers: map[string]string{"Content-Length": "42"}}, start.Add(250*time.Millisecond))
+	assert.Equal(t, []float64{1, 0.25, 0.25, 1, 42}, engine.extractFeatures(flow)[40:45])
+}
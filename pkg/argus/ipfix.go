@@ -0,0 +1,218 @@
+package argus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/google/gopacket/layers"
+)
+
+// Enterprise-specific information elements carrying detection verdicts,
+// scoped to the configured private enterprise number
+const (
+	ieBotConfidence = 1 // float64
+	ieIsBot         = 2 // boolean, 1 = true, 2 = false per RFC 7011
+	ieModelName     = 3 // variable-length string
+)
+
+// Template IDs for exported records
+const (
+	ipfixTemplateIPv4 = 256
+	ipfixTemplateIPv6 = 257
+)
+
+// ipfixExporter sends an IPFIX record for every analyzed flow to a
+// collector, so existing flow infrastructure can consume the verdicts
+type ipfixExporter struct {
+	conn            net.Conn
+	domain          uint32
+	enterprise      uint32
+	templateRefresh time.Duration
+	templateSent    time.Time
+	sequence        uint32
+	mu              sync.Mutex
+}
+
+// newIPFIXExporter connects to the configured IPFIX collector over UDP
+func newIPFIXExporter(cfg config.CaptureConfig) (*ipfixExporter, error) {
+	conn, err := net.Dial("udp", cfg.IPFIXCollector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to IPFIX collector %s: %w", cfg.IPFIXCollector, err)
+	}
+
+	return &ipfixExporter{
+		conn:            conn,
+		domain:          cfg.IPFIXDomain,
+		enterprise:      cfg.IPFIXEnterprise,
+		templateRefresh: time.Duration(cfg.IPFIXTemplateRefresh) * time.Second,
+	}, nil
+}
+
+// export sends the record for an analyzed flow. Templates are included in
+// the first message and again every template refresh interval, since UDP
+// collectors may have missed or expired them. A flow is exported on every
+// analysis, so its counters are sent as running totals rather than deltas.
+// Flows without IP addresses fit neither template and are skipped.
+func (x *ipfixExporter) export(flow *Flow, result *cortex.DetectionResult) error {
+	record := flow.Record()
+	if record.SrcIP.To16() == nil || record.DstIP.To16() == nil {
+		return nil
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	now := time.Now()
+	var sets []byte
+	if x.templateSent.IsZero() || now.Sub(x.templateSent) >= x.templateRefresh {
+		sets = append(sets, x.templateSet()...)
+		x.templateSent = now
+	}
+
+	templateID := uint16(ipfixTemplateIPv4)
+	if record.SrcIP.To4() == nil || record.DstIP.To4() == nil {
+		templateID = ipfixTemplateIPv6
+	}
+
+	var data []byte
+	if templateID == ipfixTemplateIPv4 {
+		data = append(data, record.SrcIP.To4()...)
+		data = append(data, record.DstIP.To4()...)
+	} else {
+		data = append(data, record.SrcIP.To16()...)
+		data = append(data, record.DstIP.To16()...)
+	}
+	data = binary.BigEndian.AppendUint16(data, record.SrcPort)
+	data = binary.BigEndian.AppendUint16(data, record.DstPort)
+	data = append(data, ipProtocolNumber(record.Protocol))
+	data = binary.BigEndian.AppendUint64(data, record.Packets)
+	data = binary.BigEndian.AppendUint64(data, record.Bytes)
+	data = binary.BigEndian.AppendUint64(data, uint64(record.StartTime.UnixMilli()))
+	data = binary.BigEndian.AppendUint64(data, uint64(record.LastSeen.UnixMilli()))
+	data = binary.BigEndian.AppendUint64(data, math.Float64bits(result.Confidence))
+	if result.IsBot {
+		data = append(data, 1)
+	} else {
+		data = append(data, 2)
+	}
+	data = appendVariableLength(data, []byte(result.ModelUsed))
+	sets = append(sets, ipfixSet(templateID, data)...)
+
+	msg := make([]byte, 16, 16+len(sets))
+	binary.BigEndian.PutUint16(msg[0:], ipfix)
+	binary.BigEndian.PutUint16(msg[2:], uint16(16+len(sets)))
+	binary.BigEndian.PutUint32(msg[4:], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(msg[8:], x.sequence)
+	binary.BigEndian.PutUint32(msg[12:], x.domain)
+	msg = append(msg, sets...)
+
+	if _, err := x.conn.Write(msg); err != nil {
+		// Resend templates with the next message in case this one carried them
+		x.templateSent = time.Time{}
+		return fmt.Errorf("failed to send IPFIX message: %w", err)
+	}
+	x.sequence++ // counts data records
+
+	return nil
+}
+
+// templateSet returns the template set describing the IPv4 and IPv6 records
+func (x *ipfixExporter) templateSet() []byte {
+	var body []byte
+	for _, t := range []struct {
+		id       uint16
+		src, dst uint16
+		addrLen  uint16
+	}{
+		{ipfixTemplateIPv4, ieSourceIPv4Address, ieDestinationIPv4Address, 4},
+		{ipfixTemplateIPv6, ieSourceIPv6Address, ieDestinationIPv6Address, 16},
+	} {
+		fields := [][2]uint16{
+			{t.src, t.addrLen},
+			{t.dst, t.addrLen},
+			{ieSourceTransportPort, 2},
+			{ieDestinationTransportPort, 2},
+			{ieProtocolIdentifier, 1},
+			{iePacketTotalCount, 8},
+			{ieOctetTotalCount, 8},
+			{ieFlowStartMilliseconds, 8},
+			{ieFlowEndMilliseconds, 8},
+		}
+		enterpriseFields := [][2]uint16{
+			{ieBotConfidence, 8},
+			{ieIsBot, 1},
+			{ieModelName, 65535},
+		}
+
+		body = binary.BigEndian.AppendUint16(body, t.id)
+		body = binary.BigEndian.AppendUint16(body, uint16(len(fields)+len(enterpriseFields)))
+		for _, f := range fields {
+			body = binary.BigEndian.AppendUint16(body, f[0])
+			body = binary.BigEndian.AppendUint16(body, f[1])
+		}
+		for _, f := range enterpriseFields {
+			body = binary.BigEndian.AppendUint16(body, f[0]|0x8000)
+			body = binary.BigEndian.AppendUint16(body, f[1])
+			body = binary.BigEndian.AppendUint32(body, x.enterprise)
+		}
+	}
+
+	return ipfixSet(2, body)
+}
+
+// close closes the connection to the collector
+func (x *ipfixExporter) close() {
+	x.conn.Close()
+}
+
+// ipfixSet wraps a set body with its ID and length
+func ipfixSet(id uint16, body []byte) []byte {
+	set := binary.BigEndian.AppendUint16(nil, id)
+	set = binary.BigEndian.AppendUint16(set, uint16(4+len(body)))
+	return append(set, body...)
+}
+
+// appendVariableLength encodes an IPFIX variable-length field
+func appendVariableLength(b, value []byte) []byte {
+	if len(value) > 65535 {
+		value = value[:65535]
+	}
+	if len(value) < 255 {
+		b = append(b, byte(len(value)))
+	} else {
+		b = append(b, 255)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	}
+	return append(b, value...)
+}
+
+// ipProtocolNumber returns the IP protocol number for a protocol name as
+// produced by ipProtocolName or gopacket
+func ipProtocolNumber(name string) uint8 {
+	switch name {
+	case "TCP":
+		return 6
+	case "UDP":
+		return 17
+	case "ICMP":
+		return 1
+	}
+
+	var number uint8
+	if _, err := fmt.Sscanf(name, "IP-%d", &number); err == nil {
+		return number
+	}
+	for i := 0; i < 256; i++ {
+		if strings.EqualFold(layers.IPProtocol(i).String(), name) {
+			return uint8(i)
+		}
+	}
+	return 0
+}
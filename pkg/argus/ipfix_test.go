@@ -0,0 +1,85 @@
+package argus
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPFIXExport(t *testing.T) {
+	collector, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer collector.Close()
+
+	exporter, err := newIPFIXExporter(config.CaptureConfig{
+		IPFIXCollector:       collector.LocalAddr().String(),
+		IPFIXDomain:          7,
+		IPFIXEnterprise:      32473,
+		IPFIXTemplateRefresh: 600,
+	})
+	require.NoError(t, err)
+	defer exporter.close()
+
+	engine := &Engine{flows: newFlowTable(0), stats: &CaptureStats{}}
+	start := time.UnixMilli(1700000000000)
+	for i := 0; i < 3; i++ {
+		engine.addPacket(net.ParseIP("192.168.1.100"), net.ParseIP("8.8.8.8"), 54321, 443, "TCP", &Packet{
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			Size:      100,
+		})
+	}
+	flow, _ := engine.flows.get("192.168.1.100:54321-8.8.8.8:443")
+
+	result := &cortex.DetectionResult{IsBot: true, Confidence: 0.97, ModelUsed: "neural_network"}
+	require.NoError(t, exporter.export(flow, result))
+	require.NoError(t, exporter.export(flow, result))
+
+	// Decode the messages with the collector side of the NetFlow backend
+	source := &netflowSource{templates: make(map[templateKey][]templateField)}
+	buf := make([]byte, 65535)
+	var sizes []int
+	for i := 0; i < 2; i++ {
+		require.NoError(t, collector.SetReadDeadline(time.Now().Add(2*time.Second)))
+		n, err := collector.Read(buf)
+		require.NoError(t, err)
+		sizes = append(sizes, n)
+
+		assert.True(t, bytes.Contains(buf[:n], []byte("neural_network")))
+
+		records, err := source.decode("127.0.0.1", buf[:n])
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+
+		rec := records[0]
+		assert.True(t, rec.srcIP.Equal(net.ParseIP("192.168.1.100")))
+		assert.True(t, rec.dstIP.Equal(net.ParseIP("8.8.8.8")))
+		assert.Equal(t, uint16(443), rec.dstPort)
+		assert.Equal(t, uint8(6), rec.protocol)
+		assert.Equal(t, uint64(3), rec.packets)
+		assert.Equal(t, uint64(300), rec.bytes)
+		assert.Equal(t, start, rec.start)
+		assert.Equal(t, start.Add(2*time.Second), rec.end)
+	}
+
+	// Templates are only sent with the first message
+	assert.Less(t, sizes[1], sizes[0])
+
+	// Flows without addresses are not exported
+	require.NoError(t, exporter.export(&Flow{ID: "no-ip"}, result))
+	require.NoError(t, collector.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, err = collector.Read(buf)
+	assert.Error(t, err)
+}
+
+func TestIPProtocolNumber(t *testing.T) {
+	assert.Equal(t, uint8(6), ipProtocolNumber("TCP"))
+	assert.Equal(t, uint8(17), ipProtocolNumber("UDP"))
+	assert.Equal(t, uint8(47), ipProtocolNumber(ipProtocolName(47)))
+	assert.Equal(t, uint8(58), ipProtocolNumber("ICMPv6"))
+}
@@ -0,0 +1,141 @@
+package argus
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ipv6Segment describes one packet of the IPv6 test capture
+type ipv6Segment struct {
+	src, dst         string
+	srcPort, dstPort uint16
+	flowLabel        uint32
+	hopByHop         bool
+}
+
+// writeIPv6Pcap writes the segments as Ethernet/IPv6/TCP frames to a pcap
+// file, optionally behind a hop-by-hop options header
+func writeIPv6Pcap(t *testing.T, segments []ipv6Segment) string {
+	path := filepath.Join(t.TempDir(), "ipv6.pcap")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	w := pcapgo.NewWriter(f)
+	require.NoError(t, w.WriteFileHeader(65535, layers.LinkTypeEthernet))
+
+	start := time.Now()
+	for i, s := range segments {
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+			EthernetType: layers.EthernetTypeIPv6,
+		}
+		ip := &layers.IPv6{
+			Version:    6,
+			FlowLabel:  s.flowLabel,
+			HopLimit:   64,
+			NextHeader: layers.IPProtocolTCP,
+			SrcIP:      net.ParseIP(s.src),
+			DstIP:      net.ParseIP(s.dst),
+		}
+		tcp := &layers.TCP{SrcPort: layers.TCPPort(s.srcPort), DstPort: layers.TCPPort(s.dstPort), ACK: true, Window: 65535}
+		require.NoError(t, tcp.SetNetworkLayerForChecksum(ip))
+
+		stack := []gopacket.SerializableLayer{eth, ip}
+		if s.hopByHop {
+			ip.NextHeader = layers.IPProtocolIPv6HopByHop
+			// Next header TCP, length 0, PadN filling the remaining 6 bytes
+			stack = append(stack, gopacket.Payload{byte(layers.IPProtocolTCP), 0, 1, 4, 0, 0, 0, 0})
+			tcpBuf := gopacket.NewSerializeBuffer()
+			opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+			require.NoError(t, gopacket.SerializeLayers(tcpBuf, opts, tcp, gopacket.Payload("data")))
+			stack = append(stack, gopacket.Payload(tcpBuf.Bytes()))
+		} else {
+			stack = append(stack, tcp, gopacket.Payload("data"))
+		}
+
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		require.NoError(t, gopacket.SerializeLayers(buf, opts, stack...))
+
+		data := buf.Bytes()
+		require.NoError(t, w.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     start.Add(time.Duration(i) * time.Millisecond),
+			CaptureLength: len(data),
+			Length:        len(data),
+		}, data))
+	}
+
+	return path
+}
+
+// replayPcap feeds every packet of a pcap file to the engine
+func replayPcap(t *testing.T, engine *Engine, path string) {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	r, err := pcapgo.NewReader(f)
+	require.NoError(t, err)
+
+	for {
+		data, ci, err := r.ReadPacketData()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		require.NoError(t, err)
+
+		packet := gopacket.NewPacket(data, r.LinkType(), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		packet.Metadata().CaptureInfo = ci
+		engine.handlePacket(packet)
+	}
+}
+
+func TestIPv6Flows(t *testing.T) {
+	const client, server = "2001:db8::10", "2001:db8:1::443"
+	path := writeIPv6Pcap(t, []ipv6Segment{
+		{client, server, 50000, 443, 0x12345, true},
+		{server, client, 443, 50000, 0xabcde, false},
+		{client, server, 50000, 443, 0x12345, false},
+		// Same ports, different host: must not collide with the flow above
+		{"2001:db8::1", "0:db8:1::443", 50000, 443, 0, false},
+	})
+
+	engine := &Engine{
+		flows: newFlowTable(0),
+		stats: &CaptureStats{},
+	}
+	replayPcap(t, engine, path)
+
+	require.Equal(t, 2, engine.flows.len())
+	flow, exists := engine.flows.get("[2001:db8::10]:50000-[2001:db8:1::443]:443")
+	require.True(t, exists)
+
+	assert.Equal(t, "TCP", flow.Protocol)
+	require.Len(t, flow.Packets, 3)
+	assert.Equal(t, 1, flow.Packets[0].Headers["ext_headers"])
+	assert.Equal(t, uint32(0x12345), flow.Packets[0].Headers["flow_label"])
+	assert.Equal(t, "inbound", flow.Packets[1].Direction)
+
+	features := engine.extractFeatures(flow)
+	assert.Equal(t, 1.0, features[24])
+	assert.Equal(t, 0.0, features[25])
+	assert.Equal(t, 0.0, features[26])
+
+	crafted, exists := engine.flows.get("[2001:db8::1]:50000-[0:db8:1::443]:443")
+	require.True(t, exists)
+	features = engine.extractFeatures(crafted)
+	assert.Equal(t, 1.0, features[25])
+}
@@ -0,0 +1,138 @@
+package argus
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/lists"
+)
+
+// List modes
+const (
+	listsBefore = "before" // listed flows are decided without Cortex
+	listsAfter  = "after"  // the lists override the verdict of Cortex
+)
+
+// newLists opens the allowlist and denylist of the configuration
+func newLists(cfg config.ListsConfig) (*lists.Store, error) {
+	switch cfg.Mode {
+	case "", listsBefore, listsAfter:
+	default:
+		return nil, fmt.Errorf("unknown lists mode %q, expected %s or %s", cfg.Mode, listsBefore, listsAfter)
+	}
+	return lists.Open(cfg.Path)
+}
+
+// listed returns the allowlist entry a flow's endpoints or TLS server name
+// match, or else the denylist entry, or nil. Flows on both lists are
+// allowed.
+func (e *Engine) listed(flow *Flow) *lists.Entry {
+	flow.mu.RLock()
+	ips := []net.IP{flow.SrcIP, flow.DstIP}
+	var names []string
+	if info := flow.ClientProtocol; info != nil && info.ClientHello != nil && info.ClientHello.ServerName != "" {
+		names = append(names, info.ClientHello.ServerName)
+	}
+	flow.mu.RUnlock()
+
+	if e.lists == nil {
+		return nil
+	}
+	if entry := e.lists.Match(lists.Allow, ips, names); entry != nil {
+		return entry
+	}
+	return e.lists.Match(lists.Deny, ips, names)
+}
+
+// allowlistedFlow reports whether a flow is allowlisted
+func (e *Engine) allowlistedFlow(flow *Flow) bool {
+	entry := e.listed(flow)
+	return entry != nil && entry.List == lists.Allow
+}
+
+// allowlisted reports whether an address is allowlisted
+func (e *Engine) allowlisted(ip net.IP) bool {
+	return e.lists != nil && e.lists.Match(lists.Allow, []net.IP{ip}, nil) != nil
+}
+
+// listedResult returns the verdict of the entry a flow matches, reached
+// without Cortex
+func listedResult(entry *lists.Entry, features []float64, flowID string) *cortex.DetectionResult {
+	result := &cortex.DetectionResult{
+		Features:  features,
+		Timestamp: time.Now(),
+		FlowID:    flowID,
+		ModelUsed: entry.List + "list",
+	}
+	decideListed(result, entry)
+	return result
+}
+
+// decideListed sets the verdict of the entry a flow matches on its result:
+// allowlisted flows are humans and denylisted flows bots, either with full
+// confidence. A verdict Cortex reached already is noted in the reasoning.
+func decideListed(result *cortex.DetectionResult, entry *lists.Entry) {
+	reason := "Allowlisted"
+	if entry.List == lists.Deny {
+		reason = "Denylisted"
+	}
+	reason += fmt.Sprintf(" %s %s", entry.Type, entry.Value)
+	if entry.Comment != "" {
+		reason += " (" + entry.Comment + ")"
+	}
+	if result.ModelUsed != entry.List+"list" {
+		reason += fmt.Sprintf(", overriding %s confidence %.2f", result.ModelUsed, result.Confidence)
+	}
+
+	result.IsBot = entry.List == lists.Deny
+	result.Confidence = 0
+	if result.IsBot {
+		result.Confidence = 1
+	}
+	result.Reasoning = reason
+}
+
+// ListEntries returns the entries of list, or of both lists when it is
+// empty
+func (e *Engine) ListEntries(list string) []lists.Entry {
+	return e.lists.Entries(list)
+}
+
+// AddListEntry lists an address, CIDR prefix or TLS server name, or updates
+// the comment of one listed already, reporting whether it is new. Blocked
+// sources the entry allowlists are unblocked.
+func (e *Engine) AddListEntry(ctx context.Context, list, typ, value, comment string) (*lists.Entry, bool, error) {
+	entry, created, err := e.lists.Add(list, typ, value, comment)
+	if err != nil {
+		return nil, false, err
+	}
+	slog.Info("Listed entry", "list", entry.List, "type", entry.Type, "value", entry.Value, "id", entry.ID)
+
+	if entry.List == lists.Allow && e.enforcer != nil {
+		for _, block := range e.enforcer.Blocks() {
+			ip := net.ParseIP(block.IP)
+			if !e.allowlisted(ip) {
+				continue
+			}
+			if _, err := e.enforcer.Unblock(ctx, ip); err != nil {
+				slog.Error("Failed to unblock allowlisted source", "ip", block.IP, "error", err)
+			}
+		}
+	}
+	return entry, created, nil
+}
+
+// RemoveListEntry unlists the entry with the given ID, returning it
+func (e *Engine) RemoveListEntry(id string) (*lists.Entry, error) {
+	entry, err := e.lists.Remove(id)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Unlisted entry", "list", entry.List, "type", entry.Type, "value", entry.Value, "id", entry.ID)
+	return entry, nil
+}
@@ -0,0 +1,134 @@
+package argus
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/lists"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+)
+
+// analyzeListed analyzes a flow from src to dst naming a TLS server and
+// returns the verdict published on it
+func analyzeListed(t *testing.T, engine *Engine, src, dst, serverName string) *cortex.DetectionResult {
+	t.Helper()
+	_, detections, cancel := engine.SubscribeDetections("")
+	defer cancel()
+
+	engine.addPacket(net.ParseIP(src), net.ParseIP(dst), 40000, 443, "TCP", &Packet{Timestamp: time.Now(), Size: 100})
+	flow, ok := engine.flows.get(engine.generateFlowID(src, dst, 40000, 443))
+	require.True(t, ok)
+	flow.mu.Lock()
+	flow.ClientProtocol = &protocol.ProtocolInfo{Protocol: "TLS", ClientHello: &protocol.ClientHello{ServerName: serverName}}
+	flow.mu.Unlock()
+	engine.analyzeFlow(flow, false)
+
+	select {
+	case event := <-detections:
+		return event.Result
+	case <-time.After(5 * time.Second):
+		t.Fatal("flow not analyzed")
+		return nil
+	}
+}
+
+func TestEngineLists(t *testing.T) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.85})
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+
+	_, err = NewEngine(config.CaptureConfig{Simulation: true, Lists: config.ListsConfig{Mode: "during"}}, cortexEngine)
+	assert.ErrorContains(t, err, "unknown lists mode")
+
+	path := filepath.Join(t.TempDir(), "lists.json")
+	engine, err := NewEngine(config.CaptureConfig{Simulation: true, Lists: config.ListsConfig{Path: path, Mode: "before"}}, cortexEngine)
+	require.NoError(t, err)
+	ctx := context.Background()
+	_, _, err = engine.AddListEntry(ctx, lists.Allow, "", "192.0.2.0/24", "office")
+	require.NoError(t, err)
+	_, _, err = engine.AddListEntry(ctx, lists.Deny, "", "evil.example", "")
+	require.NoError(t, err)
+	_, _, err = engine.AddListEntry(ctx, lists.Allow, "sni", "203.0.113.7", "")
+	assert.ErrorIs(t, err, lists.ErrInvalid)
+
+	// Listed flows are decided without Cortex, the allowlist first
+	result := analyzeListed(t, engine, "192.0.2.10", "198.51.100.1", "c2.evil.example")
+	assert.False(t, result.IsBot)
+	assert.Zero(t, result.Confidence)
+	assert.Equal(t, "allowlist", result.ModelUsed)
+	assert.Equal(t, "Allowlisted ip 192.0.2.0/24 (office)", result.Reasoning)
+
+	result = analyzeListed(t, engine, "203.0.113.7", "198.51.100.1", "c2.evil.example")
+	assert.True(t, result.IsBot)
+	assert.Equal(t, 1.0, result.Confidence)
+	assert.Equal(t, "denylist", result.ModelUsed)
+	assert.Equal(t, "Denylisted sni evil.example", result.Reasoning)
+	assert.NotEmpty(t, result.Features)
+	engine.Close()
+
+	// The lists persist, and override the verdict of Cortex after it
+	engine, err = NewEngine(config.CaptureConfig{Simulation: true, Lists: config.ListsConfig{Path: path, Mode: "after"}}, cortexEngine)
+	require.NoError(t, err)
+	defer engine.Close()
+	assert.Len(t, engine.ListEntries(""), 2)
+
+	result = analyzeListed(t, engine, "203.0.113.8", "198.51.100.1", "evil.example")
+	assert.True(t, result.IsBot)
+	assert.Equal(t, 1.0, result.Confidence)
+	assert.NotEqual(t, "denylist", result.ModelUsed)
+	assert.Contains(t, result.Reasoning, "Denylisted sni evil.example, overriding ")
+
+	result = analyzeListed(t, engine, "203.0.113.9", "198.51.100.1", "example.org")
+	assert.NotContains(t, result.Reasoning, "listed")
+
+	entries := engine.ListEntries(lists.Deny)
+	require.Len(t, entries, 1)
+	_, err = engine.RemoveListEntry(entries[0].ID)
+	require.NoError(t, err)
+	_, err = engine.RemoveListEntry(entries[0].ID)
+	assert.ErrorIs(t, err, lists.ErrEntryNotFound)
+}
+
+func TestEngineListsEnforcement(t *testing.T) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.85})
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+
+	engine, err := NewEngine(config.CaptureConfig{Simulation: true, Enforcement: config.EnforcementConfig{
+		Enabled: true, DryRun: true, MinConfidence: 0.9, MinOffenses: 1, Window: 60, BlockDuration: 60,
+	}}, cortexEngine)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	ctx := context.Background()
+	_, _, err = engine.AddListEntry(ctx, lists.Allow, "", "192.0.2.10", "")
+	require.NoError(t, err)
+
+	offense := func(src string) {
+		engine.offend(&cortex.DetectionResult{IsBot: true, Confidence: 1, Timestamp: time.Now(), SrcIP: net.ParseIP(src)})
+	}
+
+	// Allowlisted sources are never blocked
+	offense("192.0.2.10")
+	blocks, _ := engine.Blocks()
+	assert.Empty(t, blocks)
+
+	// and blocked sources are unblocked once allowlisted
+	offense("203.0.113.7")
+	offense("203.0.113.8")
+	blocks, _ = engine.Blocks()
+	require.Len(t, blocks, 2)
+	_, _, err = engine.AddListEntry(ctx, lists.Allow, "", "203.0.113.7", "")
+	require.NoError(t, err)
+	blocks, _ = engine.Blocks()
+	require.Len(t, blocks, 1)
+	assert.Equal(t, "203.0.113.8", blocks[0].IP)
+}
@@ -0,0 +1,426 @@
+package argus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// NetFlow v9 and IPFIX protocol versions
+const (
+	netflowV9 = 9
+	ipfix     = 10
+)
+
+// Information elements understood by the collector. NetFlow v9 field types
+// and IPFIX element IDs share the same numbering for these.
+const (
+	ieOctetDeltaCount          = 1
+	iePacketDeltaCount         = 2
+	ieProtocolIdentifier       = 4
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieFlowEndSysUpTime         = 21
+	ieFlowStartSysUpTime       = 22
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieOctetTotalCount          = 85
+	iePacketTotalCount         = 86
+	ieFlowStartSeconds         = 150
+	ieFlowEndSeconds           = 151
+	ieFlowStartMilliseconds    = 152
+	ieFlowEndMilliseconds      = 153
+	ieIPTTL                    = 192
+)
+
+// maxNetFlowPackets caps the packets synthesized for a single flow record
+const maxNetFlowPackets = 100
+
+// netflowSource collects NetFlow v9 and IPFIX exports over UDP and feeds
+// the records into the flow table, so deployments without a mirror port can
+// still be analyzed
+type netflowSource struct {
+	engine    *Engine
+	conn      *net.UDPConn
+	templates map[templateKey][]templateField
+	mu        sync.Mutex
+}
+
+// templateKey identifies a template; template IDs are only unique per
+// exporter and observation domain
+type templateKey struct {
+	exporter string
+	domain   uint32
+	id       uint16
+}
+
+// templateField is a single field of a data template
+type templateField struct {
+	id     uint16
+	length uint16 // 65535 marks an IPFIX variable-length field
+}
+
+// netflowRecord is a decoded flow record
+type netflowRecord struct {
+	srcIP    net.IP
+	dstIP    net.IP
+	srcPort  uint16
+	dstPort  uint16
+	protocol uint8
+	ttl      uint8
+	packets  uint64
+	bytes    uint64
+	start    time.Time
+	end      time.Time
+}
+
+// openNetFlow starts listening for NetFlow/IPFIX exports
+func (e *Engine) openNetFlow() (*netflowSource, error) {
+	addr, err := net.ResolveUDPAddr("udp", e.config.NetFlowListen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve netflow listen address %s: %w", e.config.NetFlowListen, err)
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for netflow on %s: %w", e.config.NetFlowListen, err)
+	}
+	if e.config.BufferSize > 0 {
+		if err := conn.SetReadBuffer(e.config.BufferSize); err != nil {
+			slog.Warn("Failed to set netflow receive buffer", "error", err)
+		}
+	}
+
+	slog.Info("NetFlow/IPFIX collector listening", "address", conn.LocalAddr().String())
+
+	return newNetflowSource(e, conn), nil
+}
+
+// newNetflowSource creates a collector reading from conn
+func newNetflowSource(e *Engine, conn *net.UDPConn) *netflowSource {
+	return &netflowSource{
+		engine:    e,
+		conn:      conn,
+		templates: make(map[templateKey][]templateField),
+	}
+}
+
+// run reads export datagrams until ctx is cancelled
+func (s *netflowSource) run(ctx context.Context) {
+	buf := make([]byte, 65535)
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err := s.conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+			slog.Warn("Failed to set netflow read deadline", "error", err)
+		}
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("Failed to read netflow datagram", "error", err)
+			continue
+		}
+
+		records, err := s.decode(addr.IP.String(), buf[:n])
+		if err != nil {
+			slog.Warn("Failed to decode netflow datagram", "exporter", addr.String(), "error", err)
+		}
+		for _, rec := range records {
+			s.engine.addFlowRecord(rec)
+		}
+	}
+}
+
+// close stops the collector
+func (s *netflowSource) close() {
+	s.conn.Close()
+}
+
+// decode parses a NetFlow v9 or IPFIX message, learning any templates it
+// carries and returning the data records it can decode
+func (s *netflowSource) decode(exporter string, data []byte) ([]netflowRecord, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("message too short")
+	}
+
+	var (
+		headerLen   int
+		domain      uint32
+		exportTime  time.Time
+		sysUptime   uint32
+		templateSet uint16
+	)
+
+	switch version := binary.BigEndian.Uint16(data[0:]); version {
+	case netflowV9:
+		if len(data) < 20 {
+			return nil, fmt.Errorf("netflow v9 header too short")
+		}
+		headerLen = 20
+		sysUptime = binary.BigEndian.Uint32(data[4:])
+		exportTime = time.Unix(int64(binary.BigEndian.Uint32(data[8:])), 0)
+		domain = binary.BigEndian.Uint32(data[16:])
+		templateSet = 0
+	case ipfix:
+		if len(data) < 16 {
+			return nil, fmt.Errorf("ipfix header too short")
+		}
+		length := int(binary.BigEndian.Uint16(data[2:]))
+		if length < 16 || length > len(data) {
+			return nil, fmt.Errorf("ipfix message length %d out of range", length)
+		}
+		data = data[:length]
+		headerLen = 16
+		exportTime = time.Unix(int64(binary.BigEndian.Uint32(data[4:])), 0)
+		domain = binary.BigEndian.Uint32(data[12:])
+		templateSet = 2
+	default:
+		return nil, fmt.Errorf("unsupported netflow version %d", version)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []netflowRecord
+	for sets := data[headerLen:]; len(sets) >= 4; {
+		setID := binary.BigEndian.Uint16(sets[0:])
+		setLen := int(binary.BigEndian.Uint16(sets[2:]))
+		if setLen < 4 || setLen > len(sets) {
+			return records, fmt.Errorf("set length %d out of range", setLen)
+		}
+		body := sets[4:setLen]
+		sets = sets[setLen:]
+
+		switch {
+		case setID == templateSet:
+			if err := s.parseTemplates(exporter, domain, body, setID == 2); err != nil {
+				return records, err
+			}
+		case setID >= 256:
+			fields, ok := s.templates[templateKey{exporter: exporter, domain: domain, id: setID}]
+			if !ok {
+				// Data arrived before its template; the exporter resends templates periodically
+				continue
+			}
+			records = append(records, parseDataSet(fields, body, exportTime, sysUptime)...)
+		}
+		// Options templates and options data describe the exporter, not flows
+	}
+
+	return records, nil
+}
+
+// parseTemplates stores the template records in a template set
+func (s *netflowSource) parseTemplates(exporter string, domain uint32, body []byte, isIPFIX bool) error {
+	for len(body) >= 4 {
+		id := binary.BigEndian.Uint16(body[0:])
+		count := int(binary.BigEndian.Uint16(body[2:]))
+		body = body[4:]
+
+		fields := make([]templateField, 0, count)
+		for i := 0; i < count; i++ {
+			if len(body) < 4 {
+				return fmt.Errorf("template %d truncated", id)
+			}
+			field := templateField{
+				id:     binary.BigEndian.Uint16(body[0:]),
+				length: binary.BigEndian.Uint16(body[2:]),
+			}
+			body = body[4:]
+
+			// Enterprise-specific IPFIX elements carry a 4-byte enterprise number
+			if isIPFIX && field.id&0x8000 != 0 {
+				if len(body) < 4 {
+					return fmt.Errorf("template %d truncated", id)
+				}
+				body = body[4:]
+				field.id = 0 // never matches a known element
+			}
+			fields = append(fields, field)
+		}
+
+		s.templates[templateKey{exporter: exporter, domain: domain, id: id}] = fields
+	}
+
+	return nil
+}
+
+// parseDataSet decodes the records of a data set using its template
+func parseDataSet(fields []templateField, body []byte, exportTime time.Time, sysUptime uint32) []netflowRecord {
+	var records []netflowRecord
+
+	for len(body) > 0 {
+		var rec netflowRecord
+		var startUptime, endUptime uint32
+		var hasUptime bool
+
+		rest := body
+		ok := true
+		for _, field := range fields {
+			length := int(field.length)
+			if field.length == 65535 {
+				if len(rest) < 1 {
+					ok = false
+					break
+				}
+				length, rest = int(rest[0]), rest[1:]
+				if length == 255 {
+					if len(rest) < 2 {
+						ok = false
+						break
+					}
+					length, rest = int(binary.BigEndian.Uint16(rest)), rest[2:]
+				}
+			}
+			if length > len(rest) {
+				ok = false
+				break
+			}
+			value := rest[:length]
+			rest = rest[length:]
+
+			switch field.id {
+			case ieOctetDeltaCount, ieOctetTotalCount:
+				rec.bytes = readUint(value)
+			case iePacketDeltaCount, iePacketTotalCount:
+				rec.packets = readUint(value)
+			case ieProtocolIdentifier:
+				rec.protocol = uint8(readUint(value))
+			case ieIPTTL:
+				rec.ttl = uint8(readUint(value))
+			case ieSourceTransportPort:
+				rec.srcPort = uint16(readUint(value))
+			case ieDestinationTransportPort:
+				rec.dstPort = uint16(readUint(value))
+			case ieSourceIPv4Address, ieSourceIPv6Address:
+				rec.srcIP = append(net.IP(nil), value...)
+			case ieDestinationIPv4Address, ieDestinationIPv6Address:
+				rec.dstIP = append(net.IP(nil), value...)
+			case ieFlowStartSysUpTime:
+				startUptime, hasUptime = uint32(readUint(value)), true
+			case ieFlowEndSysUpTime:
+				endUptime, hasUptime = uint32(readUint(value)), true
+			case ieFlowStartSeconds:
+				rec.start = time.Unix(int64(readUint(value)), 0)
+			case ieFlowEndSeconds:
+				rec.end = time.Unix(int64(readUint(value)), 0)
+			case ieFlowStartMilliseconds:
+				rec.start = time.UnixMilli(int64(readUint(value)))
+			case ieFlowEndMilliseconds:
+				rec.end = time.UnixMilli(int64(readUint(value)))
+			}
+		}
+		// Anything shorter than a full record is set padding
+		if !ok {
+			break
+		}
+		body = rest
+
+		// NetFlow v9 timestamps are milliseconds of exporter uptime
+		if hasUptime {
+			rec.start = exportTime.Add(-time.Duration(sysUptime-startUptime) * time.Millisecond)
+			rec.end = exportTime.Add(-time.Duration(sysUptime-endUptime) * time.Millisecond)
+		}
+		if rec.end.IsZero() {
+			rec.end = exportTime
+		}
+		if rec.start.IsZero() {
+			rec.start = rec.end
+		}
+
+		if rec.srcIP != nil && rec.dstIP != nil && rec.packets > 0 {
+			records = append(records, rec)
+		}
+	}
+
+	return records
+}
+
+// readUint decodes a big-endian unsigned integer of up to 8 bytes
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// addFlowRecord adds a flow record to the flow table. Records only carry
+// totals, so weighted packets are synthesized evenly over the flow's
+// lifetime to drive the same feature extraction as captured traffic.
+func (e *Engine) addFlowRecord(rec netflowRecord) {
+	protocol := ipProtocolName(rec.protocol)
+
+	count := rec.packets
+	if count > maxNetFlowPackets {
+		count = maxNetFlowPackets
+	}
+	size := int(rec.bytes / rec.packets)
+
+	var interval time.Duration
+	if count > 1 {
+		interval = rec.end.Sub(rec.start) / time.Duration(count-1)
+	}
+
+	// Weight the synthesized packets so they add up to the record's total
+	weight, remainder := rec.packets/count, rec.packets%count
+
+	for i := uint64(0); i < count; i++ {
+		w := weight
+		if i < remainder {
+			w++
+		}
+
+		headers := map[string]interface{}{
+			"source": "netflow",
+		}
+		if rec.ttl != 0 {
+			headers["ttl"] = rec.ttl
+		}
+
+		e.addPacket(rec.srcIP, rec.dstIP, rec.srcPort, rec.dstPort, protocol, &Packet{
+			Timestamp: rec.start.Add(time.Duration(i) * interval),
+			Size:      size,
+			Protocol:  protocol,
+			Headers:   headers,
+			Weight:    int(w),
+		})
+	}
+
+	e.stats.mu.Lock()
+	e.stats.TotalPackets += int64(rec.packets)
+	if rec.end.After(e.stats.LastPacket) {
+		e.stats.LastPacket = rec.end
+	}
+	e.stats.mu.Unlock()
+}
+
+// ipProtocolName returns the name used for an IP protocol number
+func ipProtocolName(protocol uint8) string {
+	switch protocol {
+	case 6:
+		return "TCP"
+	case 17:
+		return "UDP"
+	case 1:
+		return "ICMP"
+	default:
+		return fmt.Sprintf("IP-%d", protocol)
+	}
+}
@@ -0,0 +1,176 @@
+package argus
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildSet wraps a set body with its ID and length
+func buildSet(id uint16, body []byte) []byte {
+	set := binary.BigEndian.AppendUint16(nil, id)
+	set = binary.BigEndian.AppendUint16(set, uint16(4+len(body)))
+	return append(set, body...)
+}
+
+// buildTemplate encodes a template record from (element, length) pairs
+func buildTemplate(id uint16, fields ...uint16) []byte {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(fields)/2))
+	for _, f := range fields {
+		b = binary.BigEndian.AppendUint16(b, f)
+	}
+	return b
+}
+
+func TestDecodeNetFlowV9(t *testing.T) {
+	source := &netflowSource{templates: make(map[templateKey][]templateField)}
+
+	template := buildTemplate(256,
+		ieSourceIPv4Address, 4,
+		ieDestinationIPv4Address, 4,
+		ieSourceTransportPort, 2,
+		ieDestinationTransportPort, 2,
+		ieProtocolIdentifier, 1,
+		iePacketDeltaCount, 4,
+		ieOctetDeltaCount, 4,
+		ieFlowStartSysUpTime, 4,
+		ieFlowEndSysUpTime, 4)
+
+	var record []byte
+	record = append(record, net.ParseIP("192.168.1.100").To4()...)
+	record = append(record, net.ParseIP("8.8.8.8").To4()...)
+	record = binary.BigEndian.AppendUint16(record, 54321)
+	record = binary.BigEndian.AppendUint16(record, 443)
+	record = append(record, 6)
+	record = binary.BigEndian.AppendUint32(record, 20)
+	record = binary.BigEndian.AppendUint32(record, 3000)
+	record = binary.BigEndian.AppendUint32(record, 50000) // started 10s before export
+	record = binary.BigEndian.AppendUint32(record, 58000) // ended 2s before export
+
+	exportTime := time.Unix(1700000000, 0)
+	header := binary.BigEndian.AppendUint16(nil, netflowV9)
+	header = binary.BigEndian.AppendUint16(header, 2)
+	header = binary.BigEndian.AppendUint32(header, 60000)
+	header = binary.BigEndian.AppendUint32(header, uint32(exportTime.Unix()))
+	header = binary.BigEndian.AppendUint32(header, 1)
+	header = binary.BigEndian.AppendUint32(header, 0)
+
+	// Data before its template is skipped
+	records, err := source.decode("10.0.0.1", append(append([]byte(nil), header...), buildSet(256, record)...))
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	msg := append(append([]byte(nil), header...), buildSet(0, template)...)
+	msg = append(msg, buildSet(256, append(record, 0, 0, 0))...) // padded
+	records, err = source.decode("10.0.0.1", msg)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	rec := records[0]
+	assert.True(t, rec.srcIP.Equal(net.ParseIP("192.168.1.100")))
+	assert.True(t, rec.dstIP.Equal(net.ParseIP("8.8.8.8")))
+	assert.Equal(t, uint16(54321), rec.srcPort)
+	assert.Equal(t, uint16(443), rec.dstPort)
+	assert.Equal(t, uint8(6), rec.protocol)
+	assert.Equal(t, uint64(20), rec.packets)
+	assert.Equal(t, uint64(3000), rec.bytes)
+	assert.Equal(t, exportTime.Add(-10*time.Second), rec.start)
+	assert.Equal(t, exportTime.Add(-2*time.Second), rec.end)
+
+	// Templates are scoped to the exporter
+	records, err = source.decode("10.0.0.2", append(append([]byte(nil), header...), buildSet(256, record)...))
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestDecodeIPFIX(t *testing.T) {
+	source := &netflowSource{templates: make(map[templateKey][]templateField)}
+
+	// An enterprise element and a variable-length element must be skipped
+	template := buildTemplate(300,
+		ieSourceIPv6Address, 16,
+		ieDestinationIPv6Address, 16,
+		ieDestinationTransportPort, 2,
+		ieProtocolIdentifier, 1,
+		iePacketTotalCount, 8,
+		ieOctetTotalCount, 8,
+		0x8000|100, 4)
+	template = binary.BigEndian.AppendUint32(template, 9) // enterprise number
+	for _, f := range []uint16{96, 65535, ieFlowStartMilliseconds, 8, ieFlowEndMilliseconds, 8} {
+		template = binary.BigEndian.AppendUint16(template, f)
+	}
+	binary.BigEndian.PutUint16(template[2:], 10)
+
+	start := time.UnixMilli(1700000000123)
+	var record []byte
+	record = append(record, net.ParseIP("2001:db8::1").To16()...)
+	record = append(record, net.ParseIP("2001:db8::2").To16()...)
+	record = binary.BigEndian.AppendUint16(record, 53)
+	record = append(record, 17)
+	record = binary.BigEndian.AppendUint64(record, 4)
+	record = binary.BigEndian.AppendUint64(record, 400)
+	record = binary.BigEndian.AppendUint32(record, 0xdeadbeef)
+	record = append(record, 4, 'e', 't', 'h', '0')
+	record = binary.BigEndian.AppendUint64(record, uint64(start.UnixMilli()))
+	record = binary.BigEndian.AppendUint64(record, uint64(start.Add(3*time.Second).UnixMilli()))
+
+	body := append(buildSet(2, template), buildSet(300, record)...)
+	msg := binary.BigEndian.AppendUint16(nil, ipfix)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(16+len(body)))
+	msg = binary.BigEndian.AppendUint32(msg, 1700000010)
+	msg = binary.BigEndian.AppendUint32(msg, 1)
+	msg = binary.BigEndian.AppendUint32(msg, 7)
+	msg = append(msg, body...)
+
+	records, err := source.decode("10.0.0.1", msg)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	rec := records[0]
+	assert.True(t, rec.srcIP.Equal(net.ParseIP("2001:db8::1")))
+	assert.Equal(t, uint16(53), rec.dstPort)
+	assert.Equal(t, uint8(17), rec.protocol)
+	assert.Equal(t, uint64(4), rec.packets)
+	assert.Equal(t, uint64(400), rec.bytes)
+	assert.Equal(t, start, rec.start)
+	assert.Equal(t, start.Add(3*time.Second), rec.end)
+
+	_, err = source.decode("10.0.0.1", []byte{0, 5, 0, 0})
+	assert.Error(t, err)
+}
+
+func TestAddFlowRecord(t *testing.T) {
+	engine := &Engine{
+		flows: newFlowTable(0),
+		stats: &CaptureStats{},
+	}
+
+	start := time.Now().Add(-time.Minute)
+	engine.addFlowRecord(netflowRecord{
+		srcIP:    net.ParseIP("192.168.1.100"),
+		dstIP:    net.ParseIP("8.8.8.8"),
+		srcPort:  54321,
+		dstPort:  443,
+		protocol: 6,
+		packets:  500,
+		bytes:    50000,
+		start:    start,
+		end:      start.Add(99 * time.Second),
+	})
+
+	flow, exists := engine.flows.get("192.168.1.100:54321-8.8.8.8:443")
+	require.True(t, exists)
+	assert.Equal(t, "TCP", flow.Protocol)
+	require.Len(t, flow.Packets, maxNetFlowPackets)
+	assert.Equal(t, 100, flow.Packets[0].Size)
+	assert.Equal(t, "netflow", flow.Packets[0].Headers["source"])
+	assert.Equal(t, start, flow.StartTime)
+	assert.Equal(t, start.Add(99*time.Second), flow.LastSeen)
+	assert.Equal(t, int64(500), engine.stats.TotalPackets)
+	assert.Equal(t, uint64(500), flow.Record().Packets)
+}
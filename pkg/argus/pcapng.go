@@ -0,0 +1,117 @@
+package argus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// pcapng block types and option codes, see
+// https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-01.html
+const (
+	pcapngSectionHeader   = 0x0A0D0D0A
+	pcapngInterfaceDesc   = 0x00000001
+	pcapngEnhancedPacket  = 0x00000006
+	pcapngByteOrderMagic  = 0x1A2B3C4D
+	pcapngOptEnd          = 0
+	pcapngOptComment      = 1
+	pcapngOptUserAppl     = 4
+	pcapngOptIfTsResol    = 9
+	pcapngNanosecondResol = 9
+)
+
+// pcapngWriter writes a single-section, single-interface pcapng stream with
+// nanosecond timestamps
+type pcapngWriter struct {
+	w *bufio.Writer
+}
+
+// pcapngOption is a block option
+type pcapngOption struct {
+	code  uint16
+	value []byte
+}
+
+// newPcapngWriter writes the section header, carrying comment, and the
+// interface description block
+func newPcapngWriter(w io.Writer, linkType layers.LinkType, snapLen int, comment string) (*pcapngWriter, error) {
+	pw := &pcapngWriter{w: bufio.NewWriter(w)}
+
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1) // major version
+	binary.LittleEndian.PutUint16(shb[6:], 0) // minor version
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0))
+	options := []pcapngOption{{code: pcapngOptUserAppl, value: []byte("protocol-argus-cortex")}}
+	if comment != "" {
+		options = append(options, pcapngOption{code: pcapngOptComment, value: []byte(comment)})
+	}
+	if err := pw.writeBlock(pcapngSectionHeader, shb, options); err != nil {
+		return nil, err
+	}
+
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], uint16(linkType))
+	binary.LittleEndian.PutUint32(idb[4:], uint32(snapLen))
+	options = []pcapngOption{{code: pcapngOptIfTsResol, value: []byte{pcapngNanosecondResol}}}
+	if err := pw.writeBlock(pcapngInterfaceDesc, idb, options); err != nil {
+		return nil, err
+	}
+
+	return pw, nil
+}
+
+// writePacket writes an enhanced packet block
+func (pw *pcapngWriter) writePacket(timestamp time.Time, data []byte, length int) error {
+	ts := uint64(timestamp.UnixNano())
+
+	body := make([]byte, 20, 20+len(data)+3)
+	binary.LittleEndian.PutUint32(body[0:], 0) // interface ID
+	binary.LittleEndian.PutUint32(body[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(length))
+	body = append(body, data...)
+	body = append(body, make([]byte, pad4(len(data)))...)
+
+	return pw.writeBlock(pcapngEnhancedPacket, body, nil)
+}
+
+// flush writes any buffered data to the underlying writer
+func (pw *pcapngWriter) flush() error {
+	return pw.w.Flush()
+}
+
+// writeBlock writes a block with its options and trailing length
+func (pw *pcapngWriter) writeBlock(blockType uint32, body []byte, options []pcapngOption) error {
+	var opts []byte
+	if len(options) > 0 {
+		for _, opt := range options {
+			opts = binary.LittleEndian.AppendUint16(opts, opt.code)
+			opts = binary.LittleEndian.AppendUint16(opts, uint16(len(opt.value)))
+			opts = append(opts, opt.value...)
+			opts = append(opts, make([]byte, pad4(len(opt.value)))...)
+		}
+		opts = binary.LittleEndian.AppendUint16(opts, pcapngOptEnd)
+		opts = binary.LittleEndian.AppendUint16(opts, 0)
+	}
+
+	total := uint32(12 + len(body) + len(opts))
+	block := make([]byte, 0, total)
+	block = binary.LittleEndian.AppendUint32(block, blockType)
+	block = binary.LittleEndian.AppendUint32(block, total)
+	block = append(block, body...)
+	block = append(block, opts...)
+	block = binary.LittleEndian.AppendUint32(block, total)
+
+	_, err := pw.w.Write(block)
+	return err
+}
+
+// pad4 returns the padding needed to align n to 32 bits
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
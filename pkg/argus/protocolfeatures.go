@@ -0,0 +1,60 @@
+package argus
+
+import "github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+
+// protocolFeatureSlots maps the features the protocol parsers extract from
+// the first client message of a flow, by name, to slots of the flow
+// features. A slot takes the first of its names the message carries. Most
+// parsed messages reach the flow features through their typed fields; the
+// ones here exist only among the parser's features.
+var protocolFeatureSlots = []struct {
+	slot  int
+	names []string
+}{
+	{120, []string{"has_bot_keywords"}},           // User-Agent names a crawler or library
+	{121, []string{"user_agent_length"}},          // Libraries send short ones, browsers long
+	{122, []string{"path_length"}},                // Scanners probe long and encoded paths
+	{123, []string{"hpack_error", "qpack_error"}}, // Header compression crafted by hand
+}
+
+// extractProtocolFeatures folds the parser's features of info into their
+// slots, leaving the slots of features info lacks 0
+func extractProtocolFeatures(info *protocol.ProtocolInfo, features []float64) {
+	for _, mapping := range protocolFeatureSlots {
+		features[mapping.slot] = 0
+		if info == nil {
+			continue
+		}
+		for _, name := range mapping.names {
+			if value, ok := protocolFeatureValue(info.Features[name]); ok {
+				features[mapping.slot] = value
+				break
+			}
+		}
+	}
+}
+
+// protocolFeatureValue encodes a parser feature as a feature value: flags
+// as 0 or 1, numbers as they are, and text, such as an error, as 1 for
+// being present
+func protocolFeatureValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case bool:
+		return boolFeature(v), true
+	case int:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		return boolFeature(v != ""), true
+	}
+	return 0, false
+}
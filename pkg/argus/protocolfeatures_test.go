@@ -0,0 +1,53 @@
+package argus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+)
+
+func TestProtocolFeatureValue(t *testing.T) {
+	for _, tt := range []struct {
+		value interface{}
+		want  float64
+		ok    bool
+	}{
+		{true, 1, true},
+		{false, 0, true},
+		{42, 42, true},
+		{uint32(7), 7, true},
+		{0.5, 0.5, true},
+		{"hpack: invalid index", 1, true},
+		{"", 0, true},
+		{[]string{"h2"}, 0, false},
+		{nil, 0, false},
+	} {
+		value, ok := protocolFeatureValue(tt.value)
+		assert.Equal(t, tt.want, value, "%v", tt.value)
+		assert.Equal(t, tt.ok, ok, "%v", tt.value)
+	}
+}
+
+func TestExtractProtocolFeatures(t *testing.T) {
+	engine := &Engine{}
+
+	flow := &Flow{ID: "test-flow", StartTime: time.Now().Add(-time.Minute)}
+	flow.add(&Packet{Timestamp: time.Now(), Size: 100}, 0)
+	assert.Equal(t, []float64{0, 0, 0, 0}, engine.extractFeatures(flow)[120:124])
+
+	info, err := protocol.NewParser().ParsePacket([]byte("GET /admin/../etc/passwd?x=1 HTTP/1.1\r\nHost: example.com\r\nUser-Agent: python-requests/2.31.0\r\n\r\n"))
+	require.NoError(t, err)
+	flow.ClientProtocol = info
+	assert.Equal(t, []float64{0, 22, 24, 0}, engine.extractFeatures(flow)[120:124])
+
+	// The HTTP/3 error stands in for the HTTP/2 one
+	flow.ClientProtocol = &protocol.ProtocolInfo{Features: map[string]interface{}{
+		"has_bot_keywords": true,
+		"qpack_error":      "qpack: unknown static index",
+	}}
+	assert.Equal(t, []float64{1, 0, 0, 1}, engine.extractFeatures(flow)[120:124])
+}
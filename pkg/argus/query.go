@@ -0,0 +1,346 @@
+package argus
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"errors"
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/enrich"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/intel"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+)
+
+const (
+	// DefaultFlowPageSize is the page size used when a query sets no limit
+	DefaultFlowPageSize = 100
+	// MaxFlowPageSize caps the number of flows returned in one page
+	MaxFlowPageSize = 1000
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// FlowSummary is the stable JSON representation of a tracked flow
+type FlowSummary struct {
+	ID        string          `json:"id"`
+	SrcIP     string          `json:"src_ip"`
+	DstIP     string          `json:"dst_ip"`
+	SrcPort   uint16          `json:"src_port"`
+	DstPort   uint16          `json:"dst_port"`
+	Protocol  string          `json:"protocol"`
+	Packets   uint64          `json:"packets"`
+	Bytes     uint64          `json:"bytes"`
+	StartTime time.Time       `json:"start_time"`
+	LastSeen  time.Time       `json:"last_seen"`
+	SrcName   string          `json:"src_name,omitempty"`
+	DstName   string          `json:"dst_name,omitempty"`
+	SrcGeo    *enrich.GeoInfo `json:"src_geo,omitempty"`
+	DstGeo    *enrich.GeoInfo `json:"dst_geo,omitempty"`
+	VLANs     []uint16        `json:"vlans,omitempty"`
+	Tunnels   []Tunnel        `json:"tunnels,omitempty"`
+	// EncryptedDNS is set once an analysis recognized DNS over TLS or HTTPS
+	EncryptedDNS *EncryptedDNS `json:"encrypted_dns,omitempty"`
+	// Baseline is set when baselining is enabled and the source's
+	// baseline was learned when the flow started
+	Baseline *BaselineDeviation `json:"baseline,omitempty"`
+	// ThreatIntel lists the threat intel indicators the flow matched
+	ThreatIntel []intel.Match `json:"threat_intel,omitempty"`
+	Verdict     *FlowVerdict  `json:"verdict,omitempty"` // absent until the flow has been analyzed
+}
+
+// FlowVerdict is the outcome of the latest analysis of a flow
+type FlowVerdict struct {
+	IsBot      bool      `json:"is_bot"`
+	Confidence float64   `json:"confidence"`
+	ModelUsed  string    `json:"model_used"`
+	AnalyzedAt time.Time `json:"analyzed_at"`
+}
+
+// FlowQuery selects flows from the flow table. Zero-valued fields do not
+// filter.
+type FlowQuery struct {
+	SrcIP      *net.IPNet // initiator address
+	DstIP      *net.IPNet // responder address
+	Protocol   string     // case-insensitive
+	MinPackets uint64
+	IsBot      *bool // flows not analyzed yet never match
+	Since      time.Time
+	Until      time.Time
+
+	Cursor string // from a previous FlowPage
+	Limit  int
+}
+
+// FlowPage is one page of a flow listing. Flows are ordered by ID so pages
+// stay consistent while the table changes underneath.
+type FlowPage struct {
+	Flows      []FlowSummary `json:"flows"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// ParseIPFilter parses an address or CIDR prefix into a network for
+// FlowQuery
+func ParseIPFilter(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		return network, err
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: s}
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// Flows returns the page of tracked flows selected by q
+func (e *Engine) Flows(q FlowQuery) (*FlowPage, error) {
+	after, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultFlowPageSize
+	}
+	if limit > MaxFlowPageSize {
+		limit = MaxFlowPageSize
+	}
+
+	// Keep the limit+1 lowest matching IDs while the shards are locked, and
+	// summarize only the page once they are released
+	found := make(flowHeap, 0, limit+1)
+	e.flows.forEach(func(flow *Flow) bool {
+		if after != "" && flow.ID <= after {
+			return true
+		}
+		if len(found) > limit && flow.ID >= found[0].ID {
+			return true
+		}
+		if q.matches(flow) {
+			heap.Push(&found, flow)
+			if len(found) > limit+1 {
+				heap.Pop(&found)
+			}
+		}
+		return true
+	})
+
+	flows := make([]*Flow, len(found))
+	for i := len(flows) - 1; i >= 0; i-- {
+		flows[i] = heap.Pop(&found).(*Flow)
+	}
+
+	page := &FlowPage{}
+	if len(flows) > limit {
+		flows = flows[:limit]
+		page.NextCursor = encodeCursor(flows[limit-1].ID)
+	}
+	page.Flows = make([]FlowSummary, len(flows))
+	for i, flow := range flows {
+		page.Flows[i] = flow.Summary()
+	}
+	return page, nil
+}
+
+// flowHeap is a max-heap of flows by ID, holding the lowest IDs found so far
+type flowHeap []*Flow
+
+func (h flowHeap) Len() int           { return len(h) }
+func (h flowHeap) Less(i, j int) bool { return h[i].ID > h[j].ID }
+func (h flowHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *flowHeap) Push(x any)        { *h = append(*h, x.(*Flow)) }
+func (h *flowHeap) Pop() any {
+	old := *h
+	f := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return f
+}
+
+// matches reports whether a flow passes every filter of the query
+func (q *FlowQuery) matches(f *Flow) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	switch {
+	case q.SrcIP != nil && (f.SrcIP == nil || !q.SrcIP.Contains(f.SrcIP)):
+		return false
+	case q.DstIP != nil && (f.DstIP == nil || !q.DstIP.Contains(f.DstIP)):
+		return false
+	case q.Protocol != "" && !strings.EqualFold(q.Protocol, f.Protocol):
+		return false
+	case f.stats.packets < q.MinPackets:
+		return false
+	case q.IsBot != nil && (f.Result == nil || f.Result.IsBot != *q.IsBot):
+		return false
+	case !q.Since.IsZero() && f.LastSeen.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && f.StartTime.After(q.Until):
+		return false
+	}
+	return true
+}
+
+// Summary returns the JSON representation of the flow
+func (f *Flow) Summary() FlowSummary {
+	record := f.Record()
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	summary := FlowSummary{
+		ID:        f.ID,
+		SrcIP:     ipString(f.SrcIP),
+		DstIP:     ipString(f.DstIP),
+		SrcPort:   f.SrcPort,
+		DstPort:   f.DstPort,
+		Protocol:  f.Protocol,
+		Packets:   record.Packets,
+		Bytes:     record.Bytes,
+		StartTime: f.StartTime,
+		LastSeen:  f.LastSeen,
+		SrcName:   f.SrcName,
+		DstName:   f.DstName,
+		SrcGeo:    f.SrcGeo,
+		DstGeo:    f.DstGeo,
+		VLANs:     slices.Clone(f.VLANs),
+		Tunnels:   slices.Clone(f.Tunnels),
+
+		EncryptedDNS: f.EncryptedDNS,
+		Baseline:     f.Baseline,
+		ThreatIntel:  slices.Clone(f.ThreatIntel),
+	}
+	if f.Result != nil {
+		summary.Verdict = &FlowVerdict{
+			IsBot:      f.Result.IsBot,
+			Confidence: f.Result.Confidence,
+			ModelUsed:  f.Result.ModelUsed,
+			AnalyzedAt: f.Result.Timestamp,
+		}
+	}
+
+	return summary
+}
+
+// ipString renders an address, or "" for flows without one
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// encodeCursor makes an opaque pagination cursor from the last flow ID of
+// a page
+func encodeCursor(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+// decodeCursor returns the flow ID a cursor continues after
+func decodeCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	id, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(id) == 0 {
+		return "", ErrInvalidCursor
+	}
+	return string(id), nil
+}
+
+// FlowDetail is the full state of a single flow
+type FlowDetail struct {
+	FlowSummary
+	Outbound       DirectionStats          `json:"outbound"` // sent by the initiator
+	Inbound        DirectionStats          `json:"inbound"`
+	Timing         TimingStats             `json:"timing"`
+	Features       []float64               `json:"features,omitempty"` // vector of the latest analysis
+	ClientProtocol *protocol.ProtocolInfo  `json:"client_protocol,omitempty"`
+	ServerProtocol *protocol.ProtocolInfo  `json:"server_protocol,omitempty"`
+	HTTP           *HTTPStats              `json:"http,omitempty"`
+	UserAgent      *protocol.UserAgent     `json:"user_agent,omitempty"`
+	TLSRecords     []int                   `json:"tls_records,omitempty"` // first record lengths, the responder's negative
+	SPLT           SPLT                    `json:"splt"`
+	Payload        *PayloadStats           `json:"payload,omitempty"`
+	Anomalies      *ProtocolAnomalies      `json:"anomalies,omitempty"`
+	Detection      *cortex.DetectionResult `json:"detection,omitempty"`
+}
+
+// DirectionStats counts the traffic of one direction of a flow
+type DirectionStats struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// TimingStats describes packet inter-arrival times of a flow, in seconds
+type TimingStats struct {
+	Duration           float64 `json:"duration"`
+	MeanInterarrival   float64 `json:"mean_interarrival"`
+	MinInterarrival    float64 `json:"min_interarrival"`
+	MaxInterarrival    float64 `json:"max_interarrival"`
+	StdDevInterarrival float64 `json:"stddev_interarrival"`
+}
+
+// Flow returns the full state of the flow with the given ID
+func (e *Engine) Flow(id string) (*FlowDetail, bool) {
+	flow, exists := e.flows.get(id)
+	if !exists {
+		return nil, false
+	}
+	return flow.Detail(), true
+}
+
+// Reputation returns the reputation of a source address, false when
+// reputation tracking is disabled
+func (e *Engine) Reputation(ip net.IP) (*Reputation, bool) {
+	if e.reputation == nil {
+		return nil, false
+	}
+	return e.reputation.lookup(ip, time.Now()), true
+}
+
+// Detail returns the full state of the flow
+func (f *Flow) Detail() *FlowDetail {
+	detail := &FlowDetail{FlowSummary: f.Summary()}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	detail.Features = f.Features
+	detail.ClientProtocol = f.ClientProtocol
+	detail.ServerProtocol = f.ServerProtocol
+	detail.Detection = f.Result
+	detail.HTTP = f.stats.http.summary()
+	detail.UserAgent = f.UserAgent
+	detail.TLSRecords = slices.Clone(f.stats.tls.sizes)
+	detail.SPLT = SPLT{
+		Outbound: f.stats.splt[0].clone(),
+		Inbound:  f.stats.splt[1].clone(),
+	}
+	detail.Payload = payloadStats(f.stats.payload)
+	detail.Anomalies = f.stats.anomalies()
+
+	detail.Outbound = f.stats.outbound
+	detail.Inbound = f.stats.inbound
+
+	detail.Timing.Duration = f.LastSeen.Sub(f.StartTime).Seconds()
+	if intervals := f.stats.intervals; intervals.n > 0 {
+		detail.Timing.MinInterarrival = intervals.min
+		detail.Timing.MaxInterarrival = intervals.max
+		detail.Timing.MeanInterarrival = intervals.mean
+		detail.Timing.StdDevInterarrival = math.Sqrt(intervals.variance())
+	}
+
+	return detail
+}
@@ -0,0 +1,129 @@
+package argus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlows(t *testing.T) {
+	engine := &Engine{
+		flows: newFlowTable(0),
+		stats: &CaptureStats{},
+	}
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		for j := 0; j <= i; j++ {
+			engine.addPacket(net.IPv4(10, 0, 0, byte(i)), net.ParseIP("8.8.8.8"), 40000, 443, "TCP", &Packet{
+				Timestamp: now.Add(time.Duration(i) * time.Minute),
+				Size:      100,
+			})
+		}
+	}
+	engine.addPacket(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 5353, 53, "UDP", &Packet{
+		Timestamp: now,
+		Size:      80,
+	})
+
+	flagged, exists := engine.flows.get("10.0.0.4:40000-8.8.8.8:443")
+	require.True(t, exists)
+	flagged.Result = &cortex.DetectionResult{IsBot: true, Confidence: 0.97, Timestamp: now}
+	flagged.VLANs = []uint16{100}
+
+	page, err := engine.Flows(FlowQuery{})
+	require.NoError(t, err)
+	assert.Len(t, page.Flows, 6)
+	assert.Empty(t, page.NextCursor)
+
+	// Paging visits every flow once, in ID order
+	var ids []string
+	query := FlowQuery{Limit: 4}
+	for {
+		page, err := engine.Flows(query)
+		require.NoError(t, err)
+		for _, f := range page.Flows {
+			ids = append(ids, f.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	assert.IsIncreasing(t, ids)
+	assert.Len(t, ids, 6)
+
+	network, err := ParseIPFilter("10.0.0.0/30")
+	require.NoError(t, err)
+	page, err = engine.Flows(FlowQuery{SrcIP: network})
+	require.NoError(t, err)
+	assert.Len(t, page.Flows, 4)
+
+	host, err := ParseIPFilter("2001:db8::2")
+	require.NoError(t, err)
+	page, err = engine.Flows(FlowQuery{DstIP: host, Protocol: "udp"})
+	require.NoError(t, err)
+	require.Len(t, page.Flows, 1)
+	assert.Equal(t, "2001:db8::1", page.Flows[0].SrcIP)
+
+	page, err = engine.Flows(FlowQuery{MinPackets: 3})
+	require.NoError(t, err)
+	assert.Len(t, page.Flows, 3)
+
+	isBot := true
+	page, err = engine.Flows(FlowQuery{IsBot: &isBot})
+	require.NoError(t, err)
+	require.Len(t, page.Flows, 1)
+	require.NotNil(t, page.Flows[0].Verdict)
+	assert.Equal(t, 0.97, page.Flows[0].Verdict.Confidence)
+
+	// Summaries do not share the flow's slices
+	page.Flows[0].VLANs[0] = 200
+	assert.Equal(t, []uint16{100}, flagged.VLANs)
+
+	page, err = engine.Flows(FlowQuery{Since: now.Add(150 * time.Second), Until: now.Add(time.Hour)})
+	require.NoError(t, err)
+	assert.Len(t, page.Flows, 2)
+
+	_, err = engine.Flows(FlowQuery{Cursor: "!"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestFlowDetail(t *testing.T) {
+	engine := &Engine{
+		flows: newFlowTable(0),
+		stats: &CaptureStats{},
+	}
+
+	client, server := net.ParseIP("10.0.0.1"), net.ParseIP("8.8.8.8")
+	now := time.Now()
+	engine.addPacket(client, server, 40000, 443, "TCP", &Packet{Timestamp: now, Size: 100})
+	engine.addPacket(server, client, 443, 40000, "TCP", &Packet{Timestamp: now.Add(time.Second), Size: 1500})
+	engine.addPacket(client, server, 40000, 443, "TCP", &Packet{Timestamp: now.Add(3 * time.Second), Size: 60})
+
+	_, exists := engine.Flow("10.0.0.2:40000-8.8.8.8:443")
+	assert.False(t, exists)
+
+	flow, exists := engine.flows.get("10.0.0.1:40000-8.8.8.8:443")
+	require.True(t, exists)
+	flow.Features = []float64{0.5, 1}
+	flow.Result = &cortex.DetectionResult{IsBot: true, Confidence: 0.9, Timestamp: now}
+
+	detail, exists := engine.Flow("10.0.0.1:40000-8.8.8.8:443")
+	require.True(t, exists)
+	assert.Equal(t, DirectionStats{Packets: 2, Bytes: 160}, detail.Outbound)
+	assert.Equal(t, DirectionStats{Packets: 1, Bytes: 1500}, detail.Inbound)
+	assert.Equal(t, 3.0, detail.Timing.Duration)
+	assert.Equal(t, 1.5, detail.Timing.MeanInterarrival)
+	assert.Equal(t, 1.0, detail.Timing.MinInterarrival)
+	assert.Equal(t, 2.0, detail.Timing.MaxInterarrival)
+	assert.Equal(t, 0.5, detail.Timing.StdDevInterarrival)
+	assert.Equal(t, []float64{0.5, 1}, detail.Features)
+	assert.Same(t, flow.Result, detail.Detection)
+	require.NotNil(t, detail.Verdict)
+	assert.True(t, detail.Verdict.IsBot)
+}
@@ -0,0 +1,205 @@
+package argus
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+const (
+	// reassemblyFlushAge is how long out-of-order segments wait for a
+	// missing one before the gap is skipped, and how long a drained stream
+	// may stay quiet before it is closed
+	reassemblyFlushAge = 30 * time.Second
+	// reassemblyMaxPagesPerConnection and reassemblyMaxPagesTotal bound the
+	// out-of-order data buffered while waiting for gaps to fill
+	reassemblyMaxPagesPerConnection = 64
+	reassemblyMaxPagesTotal         = 65536
+)
+
+// tcpReassembler rebuilds the byte stream of each TCP connection direction
+// so protocol messages spanning several segments reach the parser whole
+type tcpReassembler struct {
+	engine    *Engine
+	parser    *protocol.Parser
+	maxBytes  int
+	assembler *tcpassembly.Assembler
+	key       string     // flow ID prefix of the segment being assembled
+	mu        sync.Mutex // tcpassembly.Assembler is not safe for concurrent use
+
+	// connections holds the protocol stream both directions of a
+	// connection feed, by the flow ID of the direction seen first
+	connections map[string]*connection
+
+	claims *userAgentClaims
+}
+
+// connection is the protocol stream of a TCP connection and the number of
+// its directions still open
+type connection struct {
+	stream *protocol.Stream
+	open   int
+}
+
+// newTCPReassembler creates a reassembler whose protocol streams buffer at
+// most maxBytes of a message
+func newTCPReassembler(engine *Engine, maxBytes int) *tcpReassembler {
+	r := &tcpReassembler{
+		engine:      engine,
+		parser:      newParser(engine.config),
+		maxBytes:    maxBytes,
+		connections: make(map[string]*connection),
+		claims:      newUserAgentClaims(),
+	}
+
+	r.assembler = tcpassembly.NewAssembler(tcpassembly.NewStreamPool(r))
+	r.assembler.MaxBufferedPagesPerConnection = reassemblyMaxPagesPerConnection
+	r.assembler.MaxBufferedPagesTotal = reassemblyMaxPagesTotal
+
+	return r
+}
+
+// New creates the stream for one direction of a connection, implementing
+// tcpassembly.StreamFactory. The direction seen first is taken for the
+// client's; the other shares its connection.
+func (r *tcpReassembler) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
+	src, dst := netFlow.Endpoints()
+	srcPort, dstPort := tcpFlow.Endpoints()
+	sport := binary.BigEndian.Uint16(srcPort.Raw())
+	dport := binary.BigEndian.Uint16(dstPort.Raw())
+
+	s := &parserStream{
+		reassembler: r,
+		flowID:      r.key + r.engine.generateFlowID(src.String(), dst.String(), sport, dport),
+		reverseID:   r.key + r.engine.generateFlowID(dst.String(), src.String(), dport, sport),
+	}
+	if conn, ok := r.connections[s.reverseID]; ok {
+		s.conn, s.key, s.dir = conn, s.reverseID, protocol.ServerToClient
+	} else {
+		s.conn = &connection{stream: r.parser.NewStream(r.maxBytes)}
+		s.key, s.dir = s.flowID, protocol.ClientToServer
+		r.connections[s.key] = s.conn
+	}
+	s.conn.open++
+	return s
+}
+
+// assemble feeds a TCP segment to the reassembler. key is the VLAN prefix
+// of the segment's flow ID; the assembler itself tracks connections by
+// 5-tuple only.
+func (r *tcpReassembler) assemble(netFlow gopacket.Flow, tcp *layers.TCP, timestamp time.Time, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.key = key
+	r.assembler.AssembleWithTimestamp(netFlow, tcp, timestamp)
+}
+
+// flush skips gaps that have been pending for too long and closes streams
+// that have gone quiet
+func (r *tcpReassembler) flush(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.assembler.FlushOlderThan(now.Add(-reassemblyFlushAge))
+}
+
+// close completes every open stream
+func (r *tcpReassembler) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.assembler.FlushAll()
+}
+
+// parserStream feeds one direction of a connection to the connection's
+// protocol stream and attaches the messages parsed to the flow. Its
+// methods are called with the reassembler's lock held.
+type parserStream struct {
+	reassembler *tcpReassembler
+	conn        *connection
+	key         string // of conn in the reassembler's connections
+	dir         protocol.Direction
+	flowID      string
+	reverseID   string
+	seen        time.Time // capture time of the latest data
+	records     tlsRecordScanner
+}
+
+// Reassembled receives in-order stream data, implementing tcpassembly.Stream
+func (s *parserStream) Reassembled(reassemblies []tcpassembly.Reassembly) {
+	for _, r := range reassemblies {
+		s.seen = r.Seen
+		if r.Skip != 0 {
+			s.attach(s.conn.stream.Gap(s.dir))
+			s.records.done = true
+		}
+		s.attach(s.conn.stream.Feed(s.dir, r.Bytes))
+		s.recordTLS(s.records.scan(r.Bytes))
+	}
+}
+
+// ReassemblyComplete is called when the stream closes, implementing
+// tcpassembly.Stream
+func (s *parserStream) ReassemblyComplete() {
+	s.attach(s.conn.stream.End(s.dir))
+	if s.conn.open--; s.conn.open == 0 {
+		delete(s.reassembler.connections, s.key)
+	}
+}
+
+// attach records the first message of each side of the flow the stream
+// belongs to as its client or server protocol, and passes every message
+// to the flow's HTTP transactions. The client the flow's requests claim to
+// be is remembered for the client's TLS flows.
+func (s *parserStream) attach(events []protocol.Event) {
+	flows := s.reassembler.engine.flows
+	claims := s.reassembler.claims
+	for _, event := range events {
+		if flow, ok := flows.get(s.flowID); ok {
+			flow.mu.Lock()
+			if flow.ClientProtocol == nil {
+				flow.ClientProtocol = event.Info
+			}
+			switch {
+			case event.Info.Agent != nil:
+				if flow.UserAgent == nil {
+					flow.UserAgent = event.Info.Agent
+				}
+				claims.record(flow.SrcIP, event.Info.Agent, s.seen)
+			case event.Info.ClientHello != nil && flow.UserAgent == nil:
+				flow.UserAgent = claims.lookup(flow.SrcIP, s.seen)
+			}
+			flow.stats.http.add(event.Info, s.seen)
+			flow.mu.Unlock()
+		} else if flow, ok := flows.get(s.reverseID); ok {
+			flow.mu.Lock()
+			if flow.ServerProtocol == nil {
+				flow.ServerProtocol = event.Info
+			}
+			flow.stats.http.add(event.Info, s.seen)
+			flow.mu.Unlock()
+		}
+	}
+}
+
+// recordTLS adds the TLS records the stream sent to its flow
+func (s *parserStream) recordTLS(records []tlsRecord) {
+	if len(records) == 0 {
+		return
+	}
+	flows := s.reassembler.engine.flows
+	flow, ok := flows.get(s.flowID)
+	inbound := !ok
+	if inbound {
+		flow, ok = flows.get(s.reverseID)
+	}
+	if !ok {
+		return
+	}
+	flow.mu.Lock()
+	s.records.done = !flow.stats.tls.add(records, inbound)
+	flow.mu.Unlock()
+}
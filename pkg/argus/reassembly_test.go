@@ -0,0 +1,132 @@
+package argus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildTCPSegment serializes an Ethernet/IPv4/TCP segment carrying payload
+func buildTCPSegment(t *testing.T, srcIP, dstIP string, srcPort, dstPort uint16, seq uint32, syn bool, payload string) gopacket.Packet {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.ParseIP(srcIP),
+		DstIP:    net.ParseIP(dstIP),
+	}
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(srcPort),
+		DstPort: layers.TCPPort(dstPort),
+		Seq:     seq,
+		SYN:     syn,
+		ACK:     !syn,
+		Window:  65535,
+	}
+	require.NoError(t, tcp.SetNetworkLayerForChecksum(ip))
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload(payload)))
+
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	packet.Metadata().Timestamp = time.Now()
+	packet.Metadata().Length = len(buf.Bytes())
+	packet.Metadata().CaptureLength = len(buf.Bytes())
+	return packet
+}
+
+func TestTCPReassembly(t *testing.T) {
+	engine := &Engine{
+		flows: newFlowTable(0),
+		stats: &CaptureStats{},
+	}
+	engine.streams = newTCPReassembler(engine, 16*1024)
+
+	const client, server = "192.168.1.100", "93.184.216.34"
+	request := "GET /index.html HTTP/1.1\r\nHost: example.com\r\nUser-Agent: curl/8.0\r\n\r\n"
+	response := "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+
+	engine.handlePacket(buildTCPSegment(t, client, server, 54321, 80, 1000, true, ""))
+	engine.handlePacket(buildTCPSegment(t, server, client, 80, 54321, 5000, true, ""))
+
+	// The request is split over three segments, the last two out of order
+	engine.handlePacket(buildTCPSegment(t, client, server, 54321, 80, 1001, false, request[:10]))
+	engine.handlePacket(buildTCPSegment(t, client, server, 54321, 80, 1001+30, false, request[30:]))
+
+	flow, exists := engine.flows.get(engine.generateFlowID(client, server, 54321, 80))
+	require.True(t, exists)
+	assert.Nil(t, flow.ClientProtocol)
+
+	engine.handlePacket(buildTCPSegment(t, client, server, 54321, 80, 1001+10, false, request[10:30]))
+	engine.handlePacket(buildTCPSegment(t, server, client, 80, 54321, 5001, false, response))
+
+	require.NotNil(t, flow.ClientProtocol)
+	assert.Equal(t, "HTTP/1.1", flow.ClientProtocol.Protocol)
+	assert.Equal(t, "GET", flow.ClientProtocol.Method)
+	assert.Equal(t, "/index.html", flow.ClientProtocol.Path)
+	assert.Equal(t, "curl/8.0", flow.ClientProtocol.UserAgent)
+	require.NotNil(t, flow.UserAgent)
+	assert.Equal(t, "curl", flow.UserAgent.Family)
+	assert.Same(t, flow.UserAgent, engine.streams.claims.lookup(net.ParseIP(client), time.Now()))
+
+	require.NotNil(t, flow.ServerProtocol)
+	assert.Equal(t, "HTTP/1.1", flow.ServerProtocol.Version)
+	require.NotNil(t, flow.Detail().Payload)
+	assert.Equal(t, len(request), flow.Detail().Payload.Outbound.Bytes)
+	assert.Equal(t, 200, flow.ServerProtocol.StatusCode)
+
+	// The response completed the request's transaction
+	http := flow.Detail().HTTP
+	require.NotNil(t, http)
+	assert.Equal(t, 1, http.Transactions)
+	require.Len(t, http.Recent, 1)
+	assert.Equal(t, "/index.html", http.Recent[0].Path)
+	assert.Equal(t, 200, http.Recent[0].StatusCode)
+	assert.Equal(t, int64(0), http.Recent[0].ResponseBytes)
+	assert.GreaterOrEqual(t, http.Recent[0].TimeToFirstByte, 0.0)
+
+	// Both directions shared one protocol stream, dropped once they close
+	assert.Len(t, engine.streams.connections, 1)
+	engine.streams.close()
+	assert.Empty(t, engine.streams.connections)
+}
+
+func TestTCPReassemblyTLSRecords(t *testing.T) {
+	engine := &Engine{
+		flows: newFlowTable(0),
+		stats: &CaptureStats{},
+	}
+	engine.streams = newTCPReassembler(engine, 16*1024)
+
+	const client, server = "192.168.1.100", "93.184.216.34"
+	record := func(recordType byte, length int) string {
+		return string(append([]byte{recordType, 3, 3, byte(length >> 8), byte(length)}, make([]byte, length)...))
+	}
+
+	engine.handlePacket(buildTCPSegment(t, client, server, 54321, 443, 1000, true, ""))
+	engine.handlePacket(buildTCPSegment(t, server, client, 443, 54321, 5000, true, ""))
+
+	// A record header split between segments is read once complete
+	hello := record(0x16, 512)
+	engine.handlePacket(buildTCPSegment(t, client, server, 54321, 443, 1001, false, hello[:3]))
+	engine.handlePacket(buildTCPSegment(t, client, server, 54321, 443, 1004, false, hello[3:]))
+	answer := record(0x16, 1200) + record(0x14, 1) + record(0x17, 40)
+	engine.handlePacket(buildTCPSegment(t, server, client, 443, 54321, 5001, false, answer))
+	request := record(0x17, 300)
+	engine.handlePacket(buildTCPSegment(t, client, server, 54321, 443, 1001+uint32(len(hello)), false, request))
+
+	flow, exists := engine.flows.get(engine.generateFlowID(client, server, 54321, 443))
+	require.True(t, exists)
+	assert.Equal(t, []int{512, -1200, -1, -40, 300}, flow.Detail().TLSRecords)
+}
@@ -0,0 +1,186 @@
+package argus
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+)
+
+const (
+	// reputationFeatureExtractor names the reputation feature, which
+	// engines with reputation enabled add after the registered extractors
+	reputationFeatureExtractor = "reputation"
+	// maxReputationScore caps the score of a source
+	maxReputationScore = 100
+	// minReputationScore is the score below which a source is forgotten
+	minReputationScore = 0.01
+	// maxReputationSources bounds the sources whose scores are kept
+	maxReputationSources = 65536
+	// reputationEarlyAnalysis divides the packets flows of flagged sources
+	// need before they are analyzed
+	reputationEarlyAnalysis = 4
+)
+
+// Reputation is the standing of a source address, built from the bot
+// verdicts on the flows it started
+type Reputation struct {
+	IP string `json:"ip"`
+	// Score grows with every bot verdict, by the verdict's confidence
+	// times one plus the score so far, and halves every half-life; 0 for
+	// sources never flagged
+	Score       float64    `json:"score"`
+	Offenses    int        `json:"offenses"` // bot verdicts while the score lasted
+	Flagged     bool       `json:"flagged"`  // score at or above the threshold
+	LastOffense *time.Time `json:"last_offense,omitempty"`
+}
+
+// reputationTracker keeps the reputation of the sources of flows judged to
+// be bots. Since a verdict adds more the worse a source's score already is,
+// a repeat offender reaches the threshold within a verdict or two where a
+// first-time source needs several, and the flows of flagged sources are
+// analyzed after fewer packets.
+type reputationTracker struct {
+	halfLife   time.Duration
+	threshold  float64
+	maxSources int
+
+	mu      sync.Mutex
+	sources map[string]*sourceReputation
+}
+
+// sourceReputation is the decayed score of one source
+type sourceReputation struct {
+	score       float64
+	updated     time.Time // the score was last decayed to this time
+	offenses    int
+	lastOffense time.Time
+}
+
+// newReputationTracker creates a tracker whose scores halve every
+// halfLife and flag sources from threshold on
+func newReputationTracker(halfLife time.Duration, threshold float64) *reputationTracker {
+	return &reputationTracker{
+		halfLife:   halfLife,
+		threshold:  threshold,
+		maxSources: maxReputationSources,
+		sources:    make(map[string]*sourceReputation),
+	}
+}
+
+// offend records a bot verdict of the given confidence on a flow src
+// started
+func (t *reputationTracker) offend(src net.IP, confidence float64, at time.Time) {
+	if src == nil {
+		return
+	}
+	key := src.String()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.sources[key]
+	if r == nil {
+		if len(t.sources) >= t.maxSources {
+			t.prune(at)
+		}
+		if len(t.sources) >= t.maxSources {
+			// Too many offending sources to track this one
+			return
+		}
+		r = &sourceReputation{updated: at}
+		t.sources[key] = r
+	}
+
+	r.decay(at, t.halfLife)
+	r.score = math.Min(r.score+confidence*(1+r.score), maxReputationScore)
+	r.offenses++
+	r.lastOffense = at
+}
+
+// score returns the score of a source at now
+func (t *reputationTracker) score(src net.IP, now time.Time) float64 {
+	if src == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.sources[src.String()]
+	if r == nil {
+		return 0
+	}
+	r.decay(now, t.halfLife)
+	return r.score
+}
+
+// flagged reports whether a source offended and scores at or above the
+// threshold
+func (t *reputationTracker) flagged(src net.IP, now time.Time) bool {
+	score := t.score(src, now)
+	return score > 0 && score >= t.threshold
+}
+
+// lookup returns the reputation of a source at now
+func (t *reputationTracker) lookup(src net.IP, now time.Time) *Reputation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	reputation := &Reputation{IP: src.String()}
+	if r := t.sources[reputation.IP]; r != nil {
+		r.decay(now, t.halfLife)
+		reputation.Score = r.score
+		reputation.Offenses = r.offenses
+		if r.offenses > 0 {
+			last := r.lastOffense
+			reputation.LastOffense = &last
+		}
+		reputation.Flagged = r.score > 0 && r.score >= t.threshold
+	}
+	return reputation
+}
+
+// prune forgets the sources whose score faded away. The caller holds t.mu.
+func (t *reputationTracker) prune(now time.Time) {
+	for key, r := range t.sources {
+		if r.decay(now, t.halfLife); r.score < minReputationScore {
+			delete(t.sources, key)
+		}
+	}
+}
+
+// decay halves the score for every half-life since it was last decayed.
+// Offenses count while the score lasts, and start over once it faded.
+func (r *sourceReputation) decay(now time.Time, halfLife time.Duration) {
+	elapsed := now.Sub(r.updated)
+	if elapsed <= 0 {
+		return
+	}
+	r.score *= math.Exp2(-elapsed.Seconds() / halfLife.Seconds())
+	if r.score < minReputationScore {
+		r.score, r.offenses = 0, 0
+	}
+	r.updated = now
+}
+
+// reputationFeatures extracts the reputation of the sources of flows
+type reputationFeatures struct{}
+
+// Name implements FeatureExtractor
+func (reputationFeatures) Name() string { return reputationFeatureExtractor }
+
+// FeatureCount implements FeatureExtractor
+func (reputationFeatures) FeatureCount() int { return 1 }
+
+// Extract implements FeatureExtractor
+func (reputationFeatures) Extract(flow *Flow) []float64 {
+	return []float64{flow.reputation}
+}
+
+// Features implements FeatureDescriber
+func (reputationFeatures) Features() []ml.FeatureSpec {
+	return []ml.FeatureSpec{{Name: "source_reputation", Min: 0, Max: maxReputationScore}}
+}
@@ -0,0 +1,109 @@
+package argus
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+)
+
+func TestReputationTracker(t *testing.T) {
+	tracker := newReputationTracker(24*time.Hour, 2)
+	src := net.ParseIP("203.0.113.7")
+	start := time.Now()
+
+	reputation := tracker.lookup(src, start)
+	assert.Equal(t, &Reputation{IP: "203.0.113.7"}, reputation)
+	assert.False(t, tracker.flagged(src, start))
+
+	// A first verdict does not flag a source, a second soon after adds
+	// more than the first and does
+	tracker.offend(src, 0.9, start)
+	assert.InDelta(t, 0.9, tracker.score(src, start), 1e-9)
+	assert.False(t, tracker.flagged(src, start))
+	tracker.offend(src, 0.9, start.Add(time.Minute))
+	assert.Greater(t, tracker.score(src, start.Add(time.Minute)), 2.5)
+	assert.True(t, tracker.flagged(src, start.Add(time.Minute)))
+
+	reputation = tracker.lookup(src, start.Add(time.Minute))
+	assert.True(t, reputation.Flagged)
+	assert.Equal(t, 2, reputation.Offenses)
+	require.NotNil(t, reputation.LastOffense)
+	assert.Equal(t, start.Add(time.Minute), *reputation.LastOffense)
+
+	// Scores halve every half-life
+	score := tracker.score(src, start.Add(time.Minute))
+	assert.InDelta(t, score/2, tracker.score(src, start.Add(24*time.Hour+time.Minute)), 1e-9)
+	assert.False(t, tracker.flagged(src, start.Add(48*time.Hour)))
+
+	// and sources are forgotten once theirs faded
+	reputation = tracker.lookup(src, start.Add(30*24*time.Hour))
+	assert.Zero(t, reputation.Score)
+	assert.Zero(t, reputation.Offenses)
+}
+
+func TestReputationTrackerBounded(t *testing.T) {
+	tracker := newReputationTracker(time.Hour, 2)
+	tracker.maxSources = 1
+	start := time.Now()
+
+	tracker.offend(net.ParseIP("203.0.113.1"), 1, start)
+	tracker.offend(net.ParseIP("203.0.113.2"), 1, start)
+	assert.Zero(t, tracker.score(net.ParseIP("203.0.113.2"), start))
+
+	tracker.offend(net.ParseIP("203.0.113.2"), 1, start.Add(24*time.Hour))
+	assert.Equal(t, 1.0, tracker.score(net.ParseIP("203.0.113.2"), start.Add(24*time.Hour)))
+	assert.NotContains(t, tracker.sources, "203.0.113.1")
+}
+
+func TestEngineReputation(t *testing.T) {
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.85})
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+
+	engine, err := NewEngine(config.CaptureConfig{Simulation: true, ReputationEnabled: true, ReputationHalfLife: 24, ReputationThreshold: 2}, cortexEngine)
+	require.NoError(t, err)
+	defer engine.Close()
+	engine.SetFlowSettings(300, 1800, 8)
+	require.NoError(t, cortexEngine.WaitReady(context.Background()))
+
+	_, ok := (&Engine{}).Reputation(net.ParseIP("203.0.113.7"))
+	assert.False(t, ok)
+	assert.Equal(t, "source_reputation", engine.FeatureSchema().Names()[flowFeatureCount])
+
+	// The flows of a flagged source are analyzed early, its score among
+	// their features
+	src := net.ParseIP("203.0.113.7")
+	engine.reputation.offend(src, 1, time.Now())
+	engine.reputation.offend(src, 1, time.Now())
+	reputation, ok := engine.Reputation(src)
+	require.True(t, ok)
+	assert.True(t, reputation.Flagged)
+
+	for _, client := range []net.IP{src, net.ParseIP("203.0.113.8")} {
+		for i := 0; i < 2; i++ {
+			engine.addPacket(client, net.ParseIP("8.8.8.8"), 40000, 443, "TCP", &Packet{Timestamp: time.Now(), Size: 100})
+		}
+	}
+	engine.performFlowAnalysis()
+
+	flagged, ok := engine.flows.get(engine.generateFlowID("203.0.113.7", "8.8.8.8", 40000, 443))
+	require.True(t, ok)
+	other, ok := engine.flows.get(engine.generateFlowID("203.0.113.8", "8.8.8.8", 40000, 443))
+	require.True(t, ok)
+
+	flagged.mu.RLock()
+	assert.True(t, flagged.AnalysisPending)
+	assert.InDelta(t, 3, flagged.reputation, 0.01)
+	flagged.mu.RUnlock()
+	other.mu.RLock()
+	assert.False(t, other.AnalysisPending)
+	other.mu.RUnlock()
+	engine.analyses.Wait()
+}
@@ -0,0 +1,69 @@
+package argus
+
+// samplingEnabled reports whether packets are being sampled at all
+func (e *Engine) samplingEnabled() bool {
+	return e.config.SampleRate > 1
+}
+
+// samplePacket applies 1-in-N packet sampling ahead of decoding. It always
+// keeps the packet in per-flow mode, where sampling is decided once the
+// flow is known.
+func (e *Engine) samplePacket() bool {
+	if !e.samplingEnabled() || e.config.FlowSampleAfter > 0 {
+		return true
+	}
+
+	if (e.sampleCount.Add(1)-1)%uint64(e.config.SampleRate) == 0 {
+		return true
+	}
+
+	e.stats.mu.Lock()
+	e.stats.TotalPackets++
+	e.stats.SampledOutPackets++
+	e.stats.mu.Unlock()
+	return false
+}
+
+// sampleFlowPacket decides whether a packet joins its flow and sets the
+// number of original packets it stands for. Called with the flow locked.
+// Packets that already carry a weight, such as those synthesized from flow
+// records, are kept as they are.
+func (e *Engine) sampleFlowPacket(flow *Flow, packet *Packet) bool {
+	if packet.Weight > 0 {
+		return true
+	}
+	packet.Weight = 1
+
+	if !e.samplingEnabled() {
+		return true
+	}
+
+	if e.config.FlowSampleAfter <= 0 {
+		// Already sampled 1-in-N by samplePacket
+		packet.Weight = e.config.SampleRate
+		return true
+	}
+
+	// Per-flow mode: the first K packets are kept, then 1-in-N
+	flow.observed++
+	if flow.observed <= uint64(e.config.FlowSampleAfter) {
+		return true
+	}
+	if (flow.observed-uint64(e.config.FlowSampleAfter)-1)%uint64(e.config.SampleRate) == 0 {
+		packet.Weight = e.config.SampleRate
+		return true
+	}
+
+	e.stats.mu.Lock()
+	e.stats.SampledOutPackets++
+	e.stats.mu.Unlock()
+	return false
+}
+
+// weight returns the number of original packets the packet represents
+func (p *Packet) weight() int {
+	if p.Weight < 1 {
+		return 1
+	}
+	return p.Weight
+}
@@ -0,0 +1,72 @@
+package argus
+
+import (
+	"log/slog"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+)
+
+// Sink receives detection results and the records of completed flows, for
+// delivery to external systems. Writes must not block packet processing:
+// sinks queue and deliver in the background.
+type Sink interface {
+	// Name identifies the sink in logs and readiness checks
+	Name() string
+	// WriteDetection queues the result of a flow analysis
+	WriteDetection(result *cortex.DetectionResult)
+	// WriteFlow queues the record of a flow that expired or was evicted
+	WriteFlow(record *events.FlowRecord)
+	// Health returns nil while deliveries succeed, or the last error
+	Health() error
+	// Close delivers queued writes and releases the sink
+	Close() error
+}
+
+// AddSink registers a sink for detection results and completed flows. Sinks
+// are closed with the engine.
+func (e *Engine) AddSink(sink Sink) {
+	e.sinksMu.Lock()
+	defer e.sinksMu.Unlock()
+
+	e.sinks = append(e.sinks, sink)
+	slog.Info("Delivering detections to sink", "sink", sink.Name())
+}
+
+// writeDetection hands a detection result to every sink
+func (e *Engine) writeDetection(result *cortex.DetectionResult) {
+	e.sinksMu.RLock()
+	defer e.sinksMu.RUnlock()
+
+	for _, sink := range e.sinks {
+		sink.WriteDetection(result)
+	}
+}
+
+// completeFlow hands the record of a flow leaving the flow table to every
+// sink
+func (e *Engine) completeFlow(flow *Flow) {
+	e.sinksMu.RLock()
+	defer e.sinksMu.RUnlock()
+
+	if len(e.sinks) == 0 {
+		return
+	}
+	record := flow.Record()
+	for _, sink := range e.sinks {
+		sink.WriteFlow(record)
+	}
+}
+
+// closeSinks closes every sink, delivering their queued writes
+func (e *Engine) closeSinks() {
+	e.sinksMu.Lock()
+	defer e.sinksMu.Unlock()
+
+	for _, sink := range e.sinks {
+		if err := sink.Close(); err != nil {
+			slog.Error("Failed to close sink", "sink", sink.Name(), "error", err)
+		}
+	}
+	e.sinks = nil
+}
@@ -0,0 +1,70 @@
+package argus
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSink records the writes it receives
+type recordingSink struct {
+	mu         sync.Mutex
+	detections []*cortex.DetectionResult
+	flows      []*events.FlowRecord
+	err        error
+	closed     bool
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) WriteDetection(result *cortex.DetectionResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.detections = append(s.detections, result)
+}
+
+func (s *recordingSink) WriteFlow(record *events.FlowRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flows = append(s.flows, record)
+}
+
+func (s *recordingSink) Health() error { return s.err }
+
+func (s *recordingSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestSinkReceivesCompletedFlows(t *testing.T) {
+	engine := &Engine{
+		config: config.CaptureConfig{FlowIdleTimeout: 300},
+		flows:  newFlowTable(0),
+		stats:  &CaptureStats{},
+	}
+	sink := &recordingSink{err: errors.New("broker unreachable")}
+	engine.AddSink(sink)
+
+	engine.flows.set(&Flow{ID: "recent-flow", LastSeen: time.Now(), StartTime: time.Now()})
+	engine.flows.set(&Flow{
+		ID:        "old-flow",
+		LastSeen:  time.Now().Add(-10 * time.Minute),
+		StartTime: time.Now().Add(-15 * time.Minute),
+	})
+	engine.expireFlows(time.Now())
+
+	if assert.Len(t, sink.flows, 1) {
+		assert.Equal(t, "old-flow", sink.flows[0].ID)
+	}
+	assert.EqualError(t, engine.SinkHealth()["recording"], "broker unreachable")
+
+	engine.closeSinks()
+	assert.True(t, sink.closed)
+	assert.Empty(t, engine.SinkHealth())
+}
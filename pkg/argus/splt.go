@@ -0,0 +1,40 @@
+package argus
+
+// spltPackets is the number of packets per direction at the start of a
+// flow whose lengths and times are kept
+const spltPackets = 8
+
+// SPLT is the sequence of packet lengths and times of both directions of
+// a flow, as Cisco's Joy records it. The aggregates of a flow lose what the
+// sequence shows: how a handshake and the first request and response
+// unfold, which differs between client implementations.
+type SPLT struct {
+	Outbound PacketSequence `json:"outbound"` // sent by the initiator
+	Inbound  PacketSequence `json:"inbound"`
+}
+
+// PacketSequence holds the lengths and times of the first packets of one
+// direction of a flow, in order
+type PacketSequence struct {
+	Lengths []int `json:"lengths"`
+	// Times are the seconds since the flow's previous packet, in either
+	// direction; 0 for the flow's first packet
+	Times []float64 `json:"times"`
+}
+
+// add appends a packet to the sequence until it is full
+func (s *PacketSequence) add(length int, gap float64) {
+	if len(s.Lengths) < spltPackets {
+		s.Lengths = append(s.Lengths, length)
+		s.Times = append(s.Times, gap)
+	}
+}
+
+// clone returns a copy of the sequence safe to hand out while packets are
+// added
+func (s *PacketSequence) clone() PacketSequence {
+	return PacketSequence{
+		Lengths: append([]int{}, s.Lengths...),
+		Times:   append([]float64{}, s.Times...),
+	}
+}
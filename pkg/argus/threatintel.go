@@ -0,0 +1,201 @@
+package argus
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/intel"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+)
+
+const (
+	// threatIntelFeatureExtractor names the threat intel feature, which
+	// engines with feeds configured add after the registered extractors
+	threatIntelFeatureExtractor = "threat_intel"
+	// threatIntelModel is the ModelUsed of detections of flows matching
+	// threat intel indicators
+	threatIntelModel = "threat-intel"
+)
+
+// newThreatIntel creates the manager of the configured feeds
+func newThreatIntel(cfg config.ThreatIntelConfig) (*intel.Manager, error) {
+	feeds := make([]intel.Feed, len(cfg.Feeds))
+	for i, feed := range cfg.Feeds {
+		feeds[i] = intel.Feed{
+			Name:     feed.Name,
+			URL:      feed.URL,
+			Format:   feed.Format,
+			Type:     intel.Type(feed.Type),
+			Interval: time.Duration(feed.Interval) * time.Second,
+			TTL:      time.Duration(feed.TTL) * time.Hour,
+			Username: feed.Username,
+			Password: feed.Password,
+		}
+	}
+	return intel.NewManager(feeds, time.Duration(cfg.Timeout)*time.Second)
+}
+
+// matchThreatIntel matches the flows that received packets since they
+// were last matched against the threat intel indicators, and reports the
+// flows matching indicators they did not match before right away, without
+// waiting for Cortex
+func (e *Engine) matchThreatIntel() {
+	now := time.Now()
+	e.flows.forEach(func(flow *Flow) bool {
+		flow.mu.RLock()
+		pending := flow.stats.added > flow.intelPackets
+		flow.mu.RUnlock()
+
+		if pending && !e.allowlistedFlow(flow) {
+			if matches := e.matchFlow(flow, now); len(matches) > 0 {
+				e.reportThreatIntel(flow, matches, now)
+			}
+		}
+		return true
+	})
+}
+
+// matchFlow matches the observables of a flow against the indicators: its
+// endpoints, the JA3 fingerprint and server name of its ClientHello, the
+// host of its HTTP request and the names its DNS query asks for. The
+// matches are added to the flow, and those it did not have yet returned.
+func (e *Engine) matchFlow(flow *Flow, now time.Time) []intel.Match {
+	flow.mu.RLock()
+	ips := []net.IP{flow.SrcIP, flow.DstIP}
+	info := flow.ClientProtocol
+	flow.mu.RUnlock()
+
+	var matches []intel.Match
+	for _, ip := range ips {
+		matches = append(matches, e.threatIntel.MatchIP(ip, now)...)
+	}
+	if info != nil {
+		if hello := info.ClientHello; hello != nil {
+			matches = append(matches, e.threatIntel.MatchJA3(hello.JA3(), now)...)
+		}
+		for _, name := range flowDomains(info) {
+			matches = append(matches, e.threatIntel.MatchDomain(name, now)...)
+		}
+	}
+
+	flow.mu.Lock()
+	defer flow.mu.Unlock()
+
+	flow.intelPackets = flow.stats.added
+	var added []intel.Match
+	for _, match := range matches {
+		known := false
+		for _, m := range flow.ThreatIntel {
+			known = known || m.Type == match.Type && m.Value == match.Value &&
+				m.Indicator == match.Indicator && m.Feed == match.Feed
+		}
+		if !known {
+			flow.ThreatIntel = append(flow.ThreatIntel, match)
+			added = append(added, match)
+		}
+	}
+	return added
+}
+
+// flowDomains returns the domain names a client message names: the server
+// name of a ClientHello, the host of an HTTP request, or the questions of
+// a DNS query
+func flowDomains(info *protocol.ProtocolInfo) []string {
+	var names []string
+	if info.ClientHello != nil && info.ClientHello.ServerName != "" {
+		names = append(names, info.ClientHello.ServerName)
+	}
+	for name, value := range info.Headers {
+		if strings.EqualFold(name, "host") && value != "" {
+			if host, _, err := net.SplitHostPort(value); err == nil {
+				value = host
+			}
+			names = append(names, value)
+		}
+	}
+	if info.DNS != nil && !info.DNS.Response {
+		for _, question := range info.DNS.Questions {
+			names = append(names, question.Name)
+		}
+	}
+	return names
+}
+
+// reportThreatIntel publishes a bot detection of full confidence for a
+// flow matching threat intel indicators. It stands as the flow's verdict
+// until Cortex analyzes the flow, which sees the matches among its
+// features.
+func (e *Engine) reportThreatIntel(flow *Flow, matches []intel.Match, now time.Time) {
+	reasons := make([]string, len(matches))
+	for i, match := range matches {
+		reasons[i] = fmt.Sprintf("%s %s listed as %s by %s", match.Type, match.Value, match.Indicator, match.Feed)
+		if match.Description != "" {
+			reasons[i] += " (" + match.Description + ")"
+		}
+	}
+
+	features := e.extractFeatures(flow)
+
+	flow.mu.Lock()
+	result := &cortex.DetectionResult{
+		IsBot:      true,
+		Confidence: 1,
+		Features:   features,
+		Reasoning:  "Threat intel match: " + strings.Join(reasons, "; "),
+		Timestamp:  now,
+		FlowID:     flow.ID,
+		ModelUsed:  threatIntelModel,
+		SrcIP:      flow.SrcIP,
+		DstIP:      flow.DstIP,
+		SrcPort:    flow.SrcPort,
+		DstPort:    flow.DstPort,
+		Protocol:   flow.Protocol,
+		SrcGeo:     flow.SrcGeo,
+		DstGeo:     flow.DstGeo,
+	}
+	offense := flow.Result == nil || !flow.Result.IsBot
+	if offense {
+		flow.Result = result
+	}
+	flow.mu.Unlock()
+
+	slog.Warn("Flow matches threat intel",
+		"flow_id", flow.ID,
+		"src_ip", flow.SrcIP,
+		"dst_ip", flow.DstIP,
+		"reasoning", result.Reasoning)
+
+	if offense {
+		e.offend(result)
+	}
+
+	e.detections.publish(result)
+	e.writeDetection(result)
+}
+
+// threatIntelFeatures extracts the number of threat intel indicators flows
+// matched
+type threatIntelFeatures struct{}
+
+// Name implements FeatureExtractor
+func (threatIntelFeatures) Name() string { return threatIntelFeatureExtractor }
+
+// FeatureCount implements FeatureExtractor
+func (threatIntelFeatures) FeatureCount() int { return 1 }
+
+// Extract implements FeatureExtractor
+func (threatIntelFeatures) Extract(flow *Flow) []float64 {
+	return []float64{float64(len(flow.ThreatIntel))}
+}
+
+// Features implements FeatureDescriber
+func (threatIntelFeatures) Features() []ml.FeatureSpec {
+	return []ml.FeatureSpec{{Name: "threat_intel_matches", Min: 0, Max: math.Inf(1)}}
+}
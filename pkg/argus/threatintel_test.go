@@ -0,0 +1,122 @@
+package argus
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/intel"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+)
+
+func TestFlowDomains(t *testing.T) {
+	assert.Equal(t, []string{"api.example.com"}, flowDomains(&protocol.ProtocolInfo{
+		ClientHello: &protocol.ClientHello{ServerName: "api.example.com"},
+	}))
+	assert.Equal(t, []string{"www.example.com"}, flowDomains(&protocol.ProtocolInfo{
+		Headers: map[string]string{"Host": "www.example.com:8080", "Accept": "*/*"},
+	}))
+	assert.Equal(t, []string{"a.example.", "b.example."}, flowDomains(&protocol.ProtocolInfo{
+		DNS: &protocol.DNSMessage{Questions: []protocol.DNSQuestion{{Name: "a.example."}, {Name: "b.example."}}},
+	}))
+	assert.Empty(t, flowDomains(&protocol.ProtocolInfo{
+		DNS: &protocol.DNSMessage{Response: true, Questions: []protocol.DNSQuestion{{Name: "a.example."}}},
+	}))
+}
+
+func TestEngineThreatIntel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "203.0.113.7\nevil.example")
+	}))
+	defer server.Close()
+
+	cortexEngine, err := cortex.NewEngine(config.CortexConfig{DetectionThreshold: 0.85})
+	require.NoError(t, err)
+	defer cortexEngine.Close()
+
+	engine, err := NewEngine(config.CaptureConfig{
+		Simulation: true,
+		ThreatIntel: config.ThreatIntelConfig{
+			Feeds:   []config.ThreatFeedConfig{{Name: "blocklist", URL: server.URL, Format: "list", Interval: 3600, TTL: 24}},
+			Timeout: 5,
+		},
+	}, cortexEngine)
+	require.NoError(t, err)
+	defer engine.Close()
+	engine.SetFlowSettings(300, 1800, 100)
+	assert.Equal(t, "threat_intel_matches", engine.FeatureSchema().Names()[flowFeatureCount])
+
+	stored, err := engine.threatIntel.Refresh(context.Background(), engine.threatIntel.Feeds()[0])
+	require.NoError(t, err)
+	assert.Equal(t, 2, stored)
+
+	_, detections, cancel := engine.SubscribeDetections("")
+	defer cancel()
+
+	// Flows from a listed address and to a listed domain are reported at
+	// their first packet
+	engine.addPacket(net.ParseIP("203.0.113.7"), net.ParseIP("198.51.100.1"), 40000, 443, "TCP", &Packet{Timestamp: time.Now(), Size: 100})
+	engine.addPacket(net.ParseIP("192.0.2.10"), net.ParseIP("198.51.100.2"), 40001, 80, "TCP", &Packet{Timestamp: time.Now(), Size: 100})
+	engine.addPacket(net.ParseIP("192.0.2.11"), net.ParseIP("198.51.100.3"), 40002, 80, "TCP", &Packet{Timestamp: time.Now(), Size: 100})
+
+	web, ok := engine.flows.get(engine.generateFlowID("192.0.2.10", "198.51.100.2", 40001, 80))
+	require.True(t, ok)
+	web.mu.Lock()
+	web.ClientProtocol = &protocol.ProtocolInfo{Protocol: "HTTP", Headers: map[string]string{"Host": "cdn.evil.example"}}
+	web.mu.Unlock()
+
+	engine.performFlowAnalysis()
+
+	reported := make(map[string]*cortex.DetectionResult)
+	for len(reported) < 2 {
+		select {
+		case event := <-detections:
+			reported[event.Result.SrcIP.String()] = event.Result
+		case <-time.After(5 * time.Second):
+			t.Fatal("no threat intel detection")
+		}
+	}
+	result := reported["203.0.113.7"]
+	require.NotNil(t, result)
+	assert.True(t, result.IsBot)
+	assert.Equal(t, 1.0, result.Confidence)
+	assert.Equal(t, threatIntelModel, result.ModelUsed)
+	assert.Contains(t, result.Reasoning, "ip 203.0.113.7 listed as 203.0.113.7 by blocklist")
+	assert.Equal(t, 1.0, result.Features[flowFeatureCount])
+	assert.Contains(t, reported["192.0.2.10"].Reasoning, "domain cdn.evil.example listed as evil.example by blocklist")
+
+	web.mu.RLock()
+	assert.Equal(t, []intel.Match{{
+		Type:      intel.TypeDomain,
+		Value:     "cdn.evil.example",
+		Indicator: "evil.example",
+		Feed:      "blocklist",
+		Expires:   web.ThreatIntel[0].Expires,
+	}}, web.ThreatIntel)
+	assert.Equal(t, threatIntelModel, web.Result.ModelUsed)
+	web.mu.RUnlock()
+
+	// Flows are reported once, and only matched again once packets arrive
+	engine.addPacket(net.ParseIP("203.0.113.7"), net.ParseIP("198.51.100.1"), 40000, 443, "TCP", &Packet{Timestamp: time.Now(), Size: 100})
+	engine.performFlowAnalysis()
+	select {
+	case event := <-detections:
+		t.Fatalf("flow reported again: %s", event.Result.Reasoning)
+	case <-time.After(100 * time.Millisecond):
+	}
+	clean, ok := engine.flows.get(engine.generateFlowID("192.0.2.11", "198.51.100.3", 40002, 80))
+	require.True(t, ok)
+	clean.mu.RLock()
+	assert.Empty(t, clean.ThreatIntel)
+	assert.Nil(t, clean.Result)
+	clean.mu.RUnlock()
+}
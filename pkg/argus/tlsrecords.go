@@ -0,0 +1,180 @@
+package argus
+
+import (
+	"hash/fnv"
+	"math/bits"
+)
+
+const (
+	// maxTLSRecords is the number of TLS records at the start of a flow
+	// whose sizes are followed
+	maxTLSRecords = 32
+	// tlsRecordBigramSlots is the number of slots the record bigrams are
+	// hashed into
+	tlsRecordBigramSlots = 8
+	// maxTLSRecordLength is the largest record length allowed, that of
+	// a full ciphertext record
+	maxTLSRecordLength = 16384 + 2048
+)
+
+// TLS record content types
+const (
+	tlsRecordAlert           = 0x15
+	tlsRecordHandshake       = 0x16
+	tlsRecordApplicationData = 0x17
+)
+
+// tlsRecord is the header of a TLS record
+type tlsRecord struct {
+	contentType byte
+	length      int
+}
+
+// tlsRecordScanner reads the record headers of one direction of a TLS
+// connection as its data arrives, skipping their payloads
+type tlsRecordScanner struct {
+	header  []byte // of the record being read, while incomplete
+	skip    int    // payload bytes of the current record still to come
+	started bool
+	done    bool // the stream is not TLS, lost its place, or was followed far enough
+}
+
+// scan reads data and returns the records whose header it completes. A
+// stream not starting with a handshake record is left alone.
+func (s *tlsRecordScanner) scan(data []byte) []tlsRecord {
+	var records []tlsRecord
+	for len(data) > 0 && !s.done {
+		if s.skip > 0 {
+			n := min(s.skip, len(data))
+			s.skip -= n
+			data = data[n:]
+			continue
+		}
+
+		n := min(5-len(s.header), len(data))
+		s.header = append(s.header, data[:n]...)
+		data = data[n:]
+		if len(s.header) < 5 {
+			break
+		}
+
+		recordType, length := s.header[0], int(s.header[3])<<8|int(s.header[4])
+		if (!s.started && recordType != tlsRecordHandshake) || recordType < 0x14 || recordType > 0x18 ||
+			s.header[1] != 3 || length > maxTLSRecordLength {
+			s.done = true
+			break
+		}
+		s.started = true
+		s.header = s.header[:0]
+		s.skip = length
+		records = append(records, tlsRecord{contentType: recordType, length: length})
+	}
+	return records
+}
+
+// tlsRecordStats holds the sizes of the first TLS records of a flow in the
+// order they were sent, those of the responder negative. The handshake
+// sizes tell client libraries apart, the ones after it how the client
+// talks: browsers fetch in bursts of requests, scripts often in strict
+// request and response turns.
+//
+// Alerts, mostly ending handshakes that failed, and application data sent
+// before both sides started their handshake count as anomalies: clients
+// probing for what a server accepts, or talking before it answered other
+// than with TLS 1.3 early data, are rarely browsers.
+type tlsRecordStats struct {
+	sizes []int
+
+	handshake  [2]bool // a handshake record was sent, outbound then inbound
+	alerts     int
+	outOfOrder int
+}
+
+// add records the records sent in a direction, reporting whether more are
+// wanted
+func (s *tlsRecordStats) add(records []tlsRecord, inbound bool) bool {
+	side := 0
+	if inbound {
+		side = 1
+	}
+	for _, record := range records {
+		if len(s.sizes) == maxTLSRecords {
+			break
+		}
+		switch record.contentType {
+		case tlsRecordHandshake:
+			s.handshake[side] = true
+		case tlsRecordAlert:
+			s.alerts++
+		case tlsRecordApplicationData:
+			if !s.handshake[0] || !s.handshake[1] {
+				s.outOfOrder++
+			}
+		}
+		length := record.length
+		if inbound {
+			length = -length
+		}
+		s.sizes = append(s.sizes, length)
+	}
+	return len(s.sizes) < maxTLSRecords
+}
+
+// meanSize returns the mean record length
+func (s *tlsRecordStats) meanSize() float64 {
+	if len(s.sizes) == 0 {
+		return 0
+	}
+	var total int
+	for _, size := range s.sizes {
+		total += max(size, -size)
+	}
+	return float64(total) / float64(len(s.sizes))
+}
+
+// bursts returns the mean and longest runs of records sent in one
+// direction before the other side sent any
+func (s *tlsRecordStats) bursts() (mean, longest float64) {
+	if len(s.sizes) == 0 {
+		return 0, 0
+	}
+	runs, run := 1, 1
+	longest = 1
+	for i := 1; i < len(s.sizes); i++ {
+		if (s.sizes[i] < 0) != (s.sizes[i-1] < 0) {
+			runs++
+			run = 0
+		}
+		run++
+		longest = max(longest, float64(run))
+	}
+	return float64(len(s.sizes)) / float64(runs), longest
+}
+
+// bigrams returns the share of consecutive record pairs falling in each
+// hash slot. A record is reduced to its direction and the power of two
+// its length falls under, so that the handful of pairs a client repeats
+// land in the same slots whatever the exact lengths.
+func (s *tlsRecordStats) bigrams() [tlsRecordBigramSlots]float64 {
+	var shares [tlsRecordBigramSlots]float64
+	if len(s.sizes) < 2 {
+		return shares
+	}
+	for i := 1; i < len(s.sizes); i++ {
+		h := fnv.New32a()
+		h.Write([]byte{recordToken(s.sizes[i-1]), recordToken(s.sizes[i])})
+		shares[h.Sum32()%tlsRecordBigramSlots]++
+	}
+	for i := range shares {
+		shares[i] /= float64(len(s.sizes) - 1)
+	}
+	return shares
+}
+
+// recordToken reduces a signed record size to its direction and size class
+func recordToken(size int) byte {
+	if size < 0 {
+		return 0x80 | byte(bits.Len(uint(-size)))
+	}
+	return byte(bits.Len(uint(size)))
+}
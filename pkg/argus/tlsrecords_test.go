@@ -0,0 +1,83 @@
+package argus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSRecordScanner(t *testing.T) {
+	var s tlsRecordScanner
+	data := append([]byte{0x16, 3, 1, 0, 4, 1, 2, 3, 4}, 0x17, 3, 3, 0x01, 0x00)
+
+	// Headers and payloads split anywhere
+	assert.Equal(t, []tlsRecord{{tlsRecordHandshake, 4}}, s.scan(data[:7]))
+	assert.Empty(t, s.scan(data[7:11]))
+	assert.Equal(t, []tlsRecord{{tlsRecordApplicationData, 256}}, s.scan(data[11:]))
+	assert.Equal(t, 256, s.skip)
+	assert.Empty(t, s.scan(make([]byte, 256)))
+	assert.Equal(t, []tlsRecord{{tlsRecordAlert, 2}}, s.scan([]byte{0x15, 3, 3, 0, 2}))
+	assert.False(t, s.done)
+
+	// Streams not starting with a handshake are not TLS
+	var plain tlsRecordScanner
+	assert.Empty(t, plain.scan([]byte("GET / HTTP/1.1\r\n\r\n")))
+	assert.True(t, plain.done)
+	var application tlsRecordScanner
+	assert.Empty(t, application.scan([]byte{0x17, 3, 3, 0, 1, 0}))
+	assert.True(t, application.done)
+
+	// A header that makes no sense ends the scan
+	var lost tlsRecordScanner
+	assert.Equal(t, []tlsRecord{{tlsRecordHandshake, 1}}, lost.scan([]byte{0x16, 3, 3, 0, 1, 0, 'x', 'y', 'z', 0, 0}))
+	assert.True(t, lost.done)
+}
+
+func TestTLSRecordStats(t *testing.T) {
+	var s tlsRecordStats
+	mean, longest := s.bursts()
+	assert.Equal(t, 0.0, mean)
+	assert.Equal(t, 0.0, longest)
+	assert.Equal(t, [tlsRecordBigramSlots]float64{}, s.bigrams())
+
+	assert.True(t, s.add([]tlsRecord{{tlsRecordHandshake, 517}}, false))
+	assert.True(t, s.add([]tlsRecord{{tlsRecordHandshake, 1400}, {tlsRecordHandshake, 1400}, {tlsRecordApplicationData, 800}}, true))
+	assert.True(t, s.add([]tlsRecord{{tlsRecordApplicationData, 64}, {tlsRecordApplicationData, 300}}, false))
+	assert.Equal(t, []int{517, -1400, -1400, -800, 64, 300}, s.sizes)
+	assert.InDelta(t, 4481.0/6, s.meanSize(), 1e-9)
+
+	mean, longest = s.bursts()
+	assert.Equal(t, 2.0, mean)
+	assert.Equal(t, 3.0, longest)
+
+	var total float64
+	for _, share := range s.bigrams() {
+		total += share
+	}
+	assert.InDelta(t, 1, total, 1e-9)
+
+	// Only the first records are followed
+	assert.False(t, s.add(make([]tlsRecord, maxTLSRecords), false))
+	assert.Len(t, s.sizes, maxTLSRecords)
+}
+
+func TestRecordToken(t *testing.T) {
+	assert.Equal(t, recordToken(1400), recordToken(1200))
+	assert.NotEqual(t, recordToken(1400), recordToken(-1400))
+	assert.NotEqual(t, recordToken(1400), recordToken(300))
+}
+
+func TestTLSRecordAnomalies(t *testing.T) {
+	var s tlsRecordStats
+
+	// Data before the server answered, then an alert ending the handshake
+	s.add([]tlsRecord{{tlsRecordHandshake, 517}, {tlsRecordApplicationData, 100}}, false)
+	s.add([]tlsRecord{{tlsRecordAlert, 2}}, true)
+	assert.Equal(t, 1, s.outOfOrder)
+	assert.Equal(t, 1, s.alerts)
+
+	// Data once both sides started their handshake is in order
+	s.add([]tlsRecord{{tlsRecordHandshake, 90}, {tlsRecordApplicationData, 50}}, true)
+	s.add([]tlsRecord{{tlsRecordApplicationData, 100}}, false)
+	assert.Equal(t, 1, s.outOfOrder)
+}
@@ -0,0 +1,90 @@
+package argus
+
+import (
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Tunnel describes one layer of encapsulation around the packets of a flow
+type Tunnel struct {
+	Type  string `json:"type"` // vxlan, gre or geneve
+	SrcIP net.IP `json:"src_ip"`
+	DstIP net.IP `json:"dst_ip"`
+	ID    uint32 `json:"id,omitempty"` // VXLAN/GENEVE VNI or GRE key
+}
+
+// decapsulator unwraps tunnelled traffic so flows are built from the
+// inner packets
+type decapsulator struct {
+	types    map[string]bool
+	maxDepth int
+}
+
+// newDecapsulator creates a decapsulator for the given tunnel types,
+// unwrapping at most maxDepth nested tunnels
+func newDecapsulator(types []string, maxDepth int) *decapsulator {
+	d := &decapsulator{types: make(map[string]bool), maxDepth: maxDepth}
+	for _, t := range types {
+		d.types[t] = true
+	}
+	return d
+}
+
+// decapsulate returns the innermost packet carried by enabled tunnels and
+// the tunnels it was found in, outermost first. Packets that are not
+// tunnelled are returned unchanged.
+func (d *decapsulator) decapsulate(packet gopacket.Packet) (gopacket.Packet, []Tunnel) {
+	var tunnels []Tunnel
+	var outer gopacket.NetworkLayer
+	var innermost gopacket.DecodingLayer
+
+	for _, layer := range packet.Layers() {
+		if network, ok := layer.(gopacket.NetworkLayer); ok {
+			outer = network
+			continue
+		}
+
+		tunnel, ok := tunnelOf(layer)
+		if !ok {
+			continue
+		}
+		if !d.types[tunnel.Type] || len(tunnels) == d.maxDepth || outer == nil {
+			break
+		}
+
+		src, dst := outer.NetworkFlow().Endpoints()
+		// Copied so the flow does not pin the whole frame in memory
+		tunnel.SrcIP = append(net.IP(nil), src.Raw()...)
+		tunnel.DstIP = append(net.IP(nil), dst.Raw()...)
+		tunnels = append(tunnels, tunnel)
+		innermost = layer.(gopacket.DecodingLayer)
+	}
+
+	if innermost == nil {
+		return packet, nil
+	}
+
+	payload := innermost.LayerPayload()
+	inner := gopacket.NewPacket(payload, innermost.NextLayerType(), gopacket.Default)
+	metadata := inner.Metadata()
+	metadata.CaptureInfo = packet.Metadata().CaptureInfo
+	metadata.CaptureLength = len(payload)
+	metadata.Length = len(payload)
+
+	return inner, tunnels
+}
+
+// tunnelOf describes layer when it is a supported tunnel header
+func tunnelOf(layer gopacket.Layer) (Tunnel, bool) {
+	switch t := layer.(type) {
+	case *layers.VXLAN:
+		return Tunnel{Type: "vxlan", ID: t.VNI}, true
+	case *layers.GRE:
+		return Tunnel{Type: "gre", ID: t.Key}, true
+	case *layers.Geneve:
+		return Tunnel{Type: "geneve", ID: t.VNI}, true
+	}
+	return Tunnel{}, false
+}
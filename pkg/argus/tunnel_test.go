@@ -0,0 +1,88 @@
+package argus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildTunnelPacket serializes a TCP segment wrapped in a GRE tunnel that
+// is itself carried over VXLAN
+func buildTunnelPacket(t *testing.T) gopacket.Packet {
+	mac := func(last byte) net.HardwareAddr { return net.HardwareAddr{0, 1, 2, 3, 4, last} }
+
+	outerEth := &layers.Ethernet{SrcMAC: mac(1), DstMAC: mac(2), EthernetType: layers.EthernetTypeIPv4}
+	outerIP := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP("172.16.0.1"), DstIP: net.ParseIP("172.16.0.2")}
+	outerUDP := &layers.UDP{SrcPort: 40000, DstPort: 4789}
+	require.NoError(t, outerUDP.SetNetworkLayerForChecksum(outerIP))
+	vxlan := &layers.VXLAN{ValidIDFlag: true, VNI: 5001}
+
+	midEth := &layers.Ethernet{SrcMAC: mac(3), DstMAC: mac(4), EthernetType: layers.EthernetTypeIPv4}
+	midIP := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolGRE,
+		SrcIP: net.ParseIP("10.1.0.1"), DstIP: net.ParseIP("10.1.0.2")}
+	gre := &layers.GRE{KeyPresent: true, Key: 77, Protocol: layers.EthernetTypeIPv4}
+
+	innerIP := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP,
+		SrcIP: net.ParseIP("192.168.1.10"), DstIP: net.ParseIP("192.168.1.20")}
+	innerTCP := &layers.TCP{SrcPort: 50000, DstPort: 443, SYN: true, Window: 65535}
+	require.NoError(t, innerTCP.SetNetworkLayerForChecksum(innerIP))
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts,
+		outerEth, outerIP, outerUDP, vxlan, midEth, midIP, gre, innerIP, innerTCP))
+
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	packet.Metadata().Timestamp = time.Now()
+	packet.Metadata().Length = len(buf.Bytes())
+	return packet
+}
+
+func TestDecapsulate(t *testing.T) {
+	packet := buildTunnelPacket(t)
+
+	inner, tunnels := newDecapsulator([]string{"vxlan", "gre"}, 2).decapsulate(packet)
+	require.Len(t, tunnels, 2)
+	assert.Equal(t, Tunnel{Type: "vxlan", SrcIP: net.ParseIP("172.16.0.1").To4(), DstIP: net.ParseIP("172.16.0.2").To4(), ID: 5001}, tunnels[0])
+	assert.Equal(t, Tunnel{Type: "gre", SrcIP: net.ParseIP("10.1.0.1").To4(), DstIP: net.ParseIP("10.1.0.2").To4(), ID: 77}, tunnels[1])
+
+	ip, ok := inner.NetworkLayer().(*layers.IPv4)
+	require.True(t, ok)
+	assert.Equal(t, "192.168.1.10", ip.SrcIP.String())
+	tcp, ok := inner.TransportLayer().(*layers.TCP)
+	require.True(t, ok)
+	assert.Equal(t, layers.TCPPort(443), tcp.DstPort)
+
+	// The depth limit stops at the outer tunnel
+	inner, tunnels = newDecapsulator([]string{"vxlan", "gre"}, 1).decapsulate(packet)
+	require.Len(t, tunnels, 1)
+	assert.Equal(t, "10.1.0.1", inner.NetworkLayer().(*layers.IPv4).SrcIP.String())
+
+	// Disabled tunnel types are left alone
+	inner, tunnels = newDecapsulator([]string{"gre"}, 2).decapsulate(packet)
+	assert.Empty(t, tunnels)
+	assert.Same(t, packet, inner)
+}
+
+func TestHandleTunnelledPacket(t *testing.T) {
+	engine := &Engine{
+		flows: newFlowTable(0),
+		stats: &CaptureStats{},
+		decap: newDecapsulator([]string{"vxlan", "gre", "geneve"}, 2),
+	}
+
+	engine.handlePacket(buildTunnelPacket(t))
+
+	flow, exists := engine.flows.get(engine.generateFlowID("192.168.1.10", "192.168.1.20", 50000, 443))
+	require.True(t, exists)
+	assert.Equal(t, "TCP", flow.Protocol)
+	require.Len(t, flow.Tunnels, 2)
+	assert.Equal(t, "vxlan", flow.Tunnels[0].Type)
+	assert.Equal(t, "gre", flow.Tunnels[1].Type)
+}
@@ -0,0 +1,123 @@
+package argus
+
+import (
+	"crypto/tls"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+)
+
+const (
+	// userAgentClaimWindow is how long the client an address claimed to
+	// be stands for its flows that do not say
+	userAgentClaimWindow = 10 * time.Minute
+	// maxUserAgentClaims bounds the addresses whose claim is remembered
+	maxUserAgentClaims = 65536
+)
+
+// tls13Since is the first major version of each browser that offered TLS
+// 1.3 and HTTP/2 by default; from then on their ClientHellos are checked
+// against the claim
+var tls13Since = map[string]int{
+	"Chrome":           70,
+	"Chromium":         70,
+	"Edge":             79,
+	"Opera":            57,
+	"Samsung Internet": 10,
+	"Firefox":          63,
+	"Safari":           13,
+}
+
+// userAgentClaims remembers the client each address last claimed to be in
+// a request sent in the clear. TLS hides the User-Agent of most flows; the
+// claim made before, as by the request a redirect to HTTPS answers, stands
+// for the address's TLS flows until the window ends. Its methods are
+// called with the reassembler's lock held.
+type userAgentClaims struct {
+	clients map[string]userAgentClaim
+}
+
+// userAgentClaim is the latest claim of an address
+type userAgentClaim struct {
+	agent *protocol.UserAgent
+	seen  time.Time
+}
+
+// newUserAgentClaims creates an empty claim memory
+func newUserAgentClaims() *userAgentClaims {
+	return &userAgentClaims{clients: make(map[string]userAgentClaim)}
+}
+
+// record remembers the claim a client made at
+func (c *userAgentClaims) record(client net.IP, agent *protocol.UserAgent, at time.Time) {
+	key := client.String()
+	if _, ok := c.clients[key]; !ok && len(c.clients) >= maxUserAgentClaims {
+		c.prune(at)
+		if len(c.clients) >= maxUserAgentClaims {
+			return
+		}
+	}
+	c.clients[key] = userAgentClaim{agent: agent, seen: at}
+}
+
+// lookup returns the claim a client made within the window before at, or
+// nil
+func (c *userAgentClaims) lookup(client net.IP, at time.Time) *protocol.UserAgent {
+	claim, ok := c.clients[client.String()]
+	if !ok || at.Sub(claim.seen) > userAgentClaimWindow {
+		return nil
+	}
+	return claim.agent
+}
+
+// prune forgets the claims whose window ended
+func (c *userAgentClaims) prune(now time.Time) {
+	for key, claim := range c.clients {
+		if now.Sub(claim.seen) > userAgentClaimWindow {
+			delete(c.clients, key)
+		}
+	}
+}
+
+// modernBrowser reports whether agent claims a browser recent enough that
+// its TLS and HTTP traffic is known
+func modernBrowser(agent *protocol.UserAgent) bool {
+	since, ok := tls13Since[agent.Family]
+	return ok && agent.Browser() && agent.Major >= since
+}
+
+// tlsContradicts reports whether a ClientHello cannot come from the
+// browser agent claims to be: Chromium and Apple's stack send GREASE
+// values and Firefox never does, and all of them offer TLS 1.3 and
+// HTTP/2. HTTP libraries claiming to be a browser keep the ClientHello of
+// their TLS library.
+func tlsContradicts(agent *protocol.UserAgent, hello *protocol.ClientHello) bool {
+	if !modernBrowser(agent) {
+		return false
+	}
+	switch agent.Engine {
+	case protocol.EngineBlink, protocol.EngineWebKit:
+		if !hello.HasGREASE() {
+			return true
+		}
+	case protocol.EngineGecko:
+		if hello.HasGREASE() {
+			return true
+		}
+	}
+	return hello.MaxVersion() < tls.VersionTLS13 || !slices.Contains(hello.ALPN, "h2")
+}
+
+// httpContradicts reports whether a request cannot come from the browser
+// agent claims to be: browsers always say which languages and encodings
+// they accept, where libraries leave out what they have no use for
+func httpContradicts(agent *protocol.UserAgent, request *protocol.ProtocolInfo) bool {
+	if !modernBrowser(agent) || request.Method == "" {
+		return false
+	}
+	_, language := header(request.Headers, "Accept-Language")
+	_, encoding := header(request.Headers, "Accept-Encoding")
+	return !language || !encoding
+}
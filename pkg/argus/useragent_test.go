@@ -0,0 +1,109 @@
+package argus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/protocol"
+)
+
+const (
+	chromeUA  = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"
+	firefoxUA = "Mozilla/5.0 (X11; Linux x86_64; rv:127.0) Gecko/20100101 Firefox/127.0"
+)
+
+// browserHello returns a ClientHello offering TLS 1.3 and HTTP/2, with or
+// without GREASE values
+func browserHello(grease bool) *protocol.ClientHello {
+	hello := &protocol.ClientHello{
+		Version:           0x0303,
+		CipherSuites:      []uint16{0x1301, 0x1302, 0x1303},
+		Extensions:        []uint16{0, 16, 43},
+		ALPN:              []string{"h2", "http/1.1"},
+		SupportedVersions: []uint16{0x0304, 0x0303},
+	}
+	if grease {
+		hello.CipherSuites = append([]uint16{0x0a0a}, hello.CipherSuites...)
+	}
+	return hello
+}
+
+func TestTLSContradicts(t *testing.T) {
+	chrome := protocol.ParseUserAgent(chromeUA)
+	firefox := protocol.ParseUserAgent(firefoxUA)
+
+	assert.False(t, tlsContradicts(chrome, browserHello(true)))
+	assert.True(t, tlsContradicts(chrome, browserHello(false)))
+	assert.False(t, tlsContradicts(firefox, browserHello(false)))
+	assert.True(t, tlsContradicts(firefox, browserHello(true)))
+
+	// Libraries offer TLS 1.2 only, or leave out ALPN
+	legacy := browserHello(true)
+	legacy.SupportedVersions = nil
+	assert.True(t, tlsContradicts(chrome, legacy))
+	noALPN := browserHello(true)
+	noALPN.ALPN = nil
+	assert.True(t, tlsContradicts(chrome, noALPN))
+
+	// Old browsers and non-browsers claim nothing to check
+	assert.False(t, tlsContradicts(protocol.ParseUserAgent("Mozilla/5.0 (Windows NT 6.1) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/49.0.2623.112 Safari/537.36"), legacy))
+	assert.False(t, tlsContradicts(protocol.ParseUserAgent("curl/8.4.0"), legacy))
+}
+
+func TestHTTPContradicts(t *testing.T) {
+	chrome := protocol.ParseUserAgent(chromeUA)
+	request := &protocol.ProtocolInfo{Method: "GET", Headers: map[string]string{
+		"User-Agent":      chromeUA,
+		"Accept":          "text/html",
+		"Accept-Language": "en-US,en;q=0.9",
+		"Accept-Encoding": "gzip, deflate",
+	}}
+	assert.False(t, httpContradicts(chrome, request))
+
+	// HTTP/2 lowercases header names
+	assert.False(t, httpContradicts(chrome, &protocol.ProtocolInfo{Method: "GET", Headers: map[string]string{
+		"accept-language": "en-US", "accept-encoding": "gzip, br",
+	}}))
+
+	delete(request.Headers, "Accept-Language")
+	assert.True(t, httpContradicts(chrome, request))
+	assert.False(t, httpContradicts(protocol.ParseUserAgent("python-requests/2.31.0"), request))
+	assert.False(t, httpContradicts(chrome, &protocol.ProtocolInfo{StatusCode: 200}))
+}
+
+func TestUserAgentClaims(t *testing.T) {
+	claims := newUserAgentClaims()
+	client := net.ParseIP("192.168.1.100")
+	start := time.Now()
+
+	assert.Nil(t, claims.lookup(client, start))
+	chrome := protocol.ParseUserAgent(chromeUA)
+	claims.record(client, chrome, start)
+	assert.Same(t, chrome, claims.lookup(client, start.Add(time.Minute)))
+	assert.Nil(t, claims.lookup(net.ParseIP("192.168.1.101"), start))
+	assert.Nil(t, claims.lookup(client, start.Add(userAgentClaimWindow+time.Second)))
+
+	claims.prune(start.Add(userAgentClaimWindow + time.Second))
+	assert.Empty(t, claims.clients)
+}
+
+func TestExtractUserAgentFeatures(t *testing.T) {
+	engine := &Engine{}
+
+	flow := &Flow{ID: "test-flow", StartTime: time.Now().Add(-time.Minute)}
+	flow.add(&Packet{Timestamp: time.Now(), Size: 100}, 0)
+	assert.Equal(t, []float64{0, 0, 0, 0}, engine.extractFeatures(flow)[45:49])
+
+	// A TLS flow of a client that claimed Chrome in the clear, with the
+	// ClientHello of a library
+	flow.UserAgent = protocol.ParseUserAgent(chromeUA)
+	flow.ClientProtocol = &protocol.ProtocolInfo{Protocol: "TLS", ClientHello: browserHello(false)}
+	assert.Equal(t, []float64{1, 0, 1, 0}, engine.extractFeatures(flow)[45:49])
+
+	flow.UserAgent = protocol.ParseUserAgent("python-requests/2.31.0")
+	flow.ClientProtocol = &protocol.ProtocolInfo{Protocol: "HTTP/1.1", Method: "GET", Headers: map[string]string{}}
+	assert.Equal(t, []float64{0, 1, 0, 0}, engine.extractFeatures(flow)[45:49])
+}
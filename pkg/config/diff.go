@@ -0,0 +1,38 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Diff returns the keys of the settings that differ between two
+// configurations, such as "capture.flow_idle_timeout", sorted
+func Diff(old, new *Config) []string {
+	var keys []string
+	diffValues("", reflect.ValueOf(*old), reflect.ValueOf(*new), &keys)
+	sort.Strings(keys)
+	return keys
+}
+
+// diffValues compares the fields of two structs by their mapstructure
+// keys, descending into nested sections
+func diffValues(prefix string, old, new reflect.Value, keys *[]string) {
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		key := field.Tag.Get("mapstructure")
+		if key == "" || !field.IsExported() {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			diffValues(key, old.Field(i), new.Field(i), keys)
+			continue
+		}
+		if !reflect.DeepEqual(old.Field(i).Interface(), new.Field(i).Interface()) {
+			*keys = append(*keys, key)
+		}
+	}
+}
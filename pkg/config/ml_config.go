@@ -8,7 +8,10 @@ import (
 // MLConfig holds configuration for the machine learning engine
 type MLConfig struct {
 	// Model selection
-	ModelType string `mapstructure:"model_type" yaml:"model_type"`
+	ModelType      string   `mapstructure:"model_type" yaml:"model_type"`
+	EnsembleModels []string `mapstructure:"ensemble_models" yaml:"ensemble_models"` // models averaged when ModelType is "ensemble"
+	ModelFormat    string   `mapstructure:"model_format" yaml:"model_format"`       // "native" or "tflite"
+	TFLiteThreads  int      `mapstructure:"tflite_threads" yaml:"tflite_threads"`   // interpreter threads for tflite models
 
 	// Detection parameters
 	DetectionThreshold float64 `mapstructure:"detection_threshold" yaml:"detection_threshold"`
@@ -19,18 +22,111 @@ type MLConfig struct {
 	LearningRate   float64 `mapstructure:"learning_rate" yaml:"learning_rate"`
 	FeatureSize    int     `mapstructure:"feature_size" yaml:"feature_size"`
 
+	// Gradient boosted decision trees
+	GBDTTrees          int     `mapstructure:"gbdt_trees" yaml:"gbdt_trees"`
+	GBDTMaxDepth       int     `mapstructure:"gbdt_max_depth" yaml:"gbdt_max_depth"`
+	GBDTLearningRate   float64 `mapstructure:"gbdt_learning_rate" yaml:"gbdt_learning_rate"` // shrinkage of each tree
+	GBDTBins           int     `mapstructure:"gbdt_bins" yaml:"gbdt_bins"`                   // histogram bins per feature, at most 256
+	GBDTMinSamplesLeaf int     `mapstructure:"gbdt_min_samples_leaf" yaml:"gbdt_min_samples_leaf"`
+
+	// k-nearest neighbors
+	KNNNeighbors int    `mapstructure:"knn_neighbors" yaml:"knn_neighbors"`
+	KNNDistance  string `mapstructure:"knn_distance" yaml:"knn_distance"` // "euclidean", "manhattan" or "chebyshev"
+
+	// SVM; the one_class mode is trained on human traffic only and flags
+	// flows outside it
+	SVMMode  string  `mapstructure:"svm_mode" yaml:"svm_mode"`   // "binary" or "one_class"
+	SVMNu    float64 `mapstructure:"svm_nu" yaml:"svm_nu"`       // share of training flows left outside the one-class boundary
+	SVMGamma float64 `mapstructure:"svm_gamma" yaml:"svm_gamma"` // one-class RBF kernel width, 1/feature_size if 0
+
+	// Online learning from labelled flows: gradient boosted trees and knn
+	// are refit from a sample of OnlineBufferSize flows every
+	// OnlineRefitSamples new ones
+	OnlineBufferSize   int `mapstructure:"online_buffer_size" yaml:"online_buffer_size"`
+	OnlineRefitSamples int `mapstructure:"online_refit_samples" yaml:"online_refit_samples"`
+
+	// Evaluation of trained models: stratified k-fold cross-validation
+	// with two or more folds, otherwise a stratified holdout of the given
+	// share of samples
+	EvaluationFolds   int     `mapstructure:"evaluation_folds" yaml:"evaluation_folds"`
+	EvaluationHoldout float64 `mapstructure:"evaluation_holdout" yaml:"evaluation_holdout"`
+
+	// Calibration of model scores into bot probabilities before
+	// thresholding, fitted on a held-out share of the training flows
+	Calibration        string  `mapstructure:"calibration" yaml:"calibration"` // "none", "platt" or "isotonic"
+	CalibrationHoldout float64 `mapstructure:"calibration_holdout" yaml:"calibration_holdout"`
+
+	// Feature scaling fitted on the training flows and persisted with the
+	// model
+	Scaling string `mapstructure:"scaling" yaml:"scaling"` // "none", "standard" or "minmax"
+
+	// Number of features each prediction is attributed to in its
+	// explanations, 0 disables them
+	Explanations int `mapstructure:"explanations" yaml:"explanations"`
+
 	// Data generation
 	GenerateFakeData bool `mapstructure:"generate_fake_data" yaml:"generate_fake_data"`
 	FakeDataSize     int  `mapstructure:"fake_data_size" yaml:"fake_data_size"`
 
+	// Labelled CSV or Parquet dataset trained on instead of fake data when
+	// set. Features are read from TrainingFeatureColumns in order, or from
+	// every column but TrainingLabelColumn.
+	TrainingDataPath       string   `mapstructure:"training_data_path" yaml:"training_data_path"`
+	TrainingLabelColumn    string   `mapstructure:"training_label_column" yaml:"training_label_column"`
+	TrainingFeatureColumns []string `mapstructure:"training_feature_columns" yaml:"training_feature_columns"`
+
+	// Versioned labelled datasets kept in DatasetDir. TrainingDataset and
+	// EvaluationDataset reference dataset versions, as name, name@v3 or
+	// name@tag, trained and evaluated on instead of TrainingDataPath.
+	DatasetDir        string `mapstructure:"dataset_dir" yaml:"dataset_dir"`
+	TrainingDataset   string `mapstructure:"training_dataset" yaml:"training_dataset"`
+	EvaluationDataset string `mapstructure:"evaluation_dataset" yaml:"evaluation_dataset"`
+
 	// Model persistence
-	ModelPath string `mapstructure:"model_path" yaml:"model_path"`
-	SaveModel bool   `mapstructure:"save_model" yaml:"save_model"`
-	LoadModel bool   `mapstructure:"load_model" yaml:"load_model"`
+	ModelPath  string `mapstructure:"model_path" yaml:"model_path"`
+	SaveModel  bool   `mapstructure:"save_model" yaml:"save_model"`
+	LoadModel  bool   `mapstructure:"load_model" yaml:"load_model"`
+	WatchModel bool   `mapstructure:"watch_model" yaml:"watch_model"` // reload ModelPath when it changes
+	ModelDir   string `mapstructure:"model_dir" yaml:"model_dir"`     // model files managed through the API
+
+	// Shadow model scoring every flow alongside the active model without
+	// affecting verdicts
+	ShadowModelPath string `mapstructure:"shadow_model_path" yaml:"shadow_model_path"`
+	ShadowQueueSize int    `mapstructure:"shadow_queue_size" yaml:"shadow_queue_size"`
+
+	// Active learning: predictions with a confidence between
+	// ReviewMinConfidence and ReviewMaxConfidence are queued for analysts to
+	// label; a ReviewQueueSize of 0 disables the queue
+	ReviewQueueSize     int     `mapstructure:"review_queue_size" yaml:"review_queue_size"`
+	ReviewMinConfidence float64 `mapstructure:"review_min_confidence" yaml:"review_min_confidence"`
+	ReviewMaxConfidence float64 `mapstructure:"review_max_confidence" yaml:"review_max_confidence"`
+
+	// Drift detection: every DriftWindow scored flows are compared with the
+	// training distribution, and a population stability index above
+	// DriftThreshold raises an alert and, with DriftRetrain, retrains the
+	// model at most once per DriftRetrainCooldown; a DriftWindow of 0
+	// disables it
+	DriftWindow          int     `mapstructure:"drift_window" yaml:"drift_window"`
+	DriftThreshold       float64 `mapstructure:"drift_threshold" yaml:"drift_threshold"`
+	DriftRetrain         bool    `mapstructure:"drift_retrain" yaml:"drift_retrain"`
+	DriftRetrainCooldown int     `mapstructure:"drift_retrain_cooldown" yaml:"drift_retrain_cooldown"` // seconds
 
 	// Performance settings
-	EnableGPU      bool `mapstructure:"enable_gpu" yaml:"enable_gpu"`
-	MaxConcurrency int  `mapstructure:"max_concurrency" yaml:"max_concurrency"`
+	EnableGPU        bool `mapstructure:"enable_gpu" yaml:"enable_gpu"`           // neural network on the GPU in builds with the cuda tag
+	MaxConcurrency   int  `mapstructure:"max_concurrency" yaml:"max_concurrency"` // concurrent predictions and batch workers
+	WarmupInferences int  `mapstructure:"warmup_inferences" yaml:"warmup_inferences"`
+
+	// Prediction cache: predictions of up to cache_size feature vectors,
+	// rounded to multiples of cache_quantization, reused for cache_ttl
+	CacheSize         int     `mapstructure:"cache_size" yaml:"cache_size"` // 0 disables the cache
+	CacheTTL          int     `mapstructure:"cache_ttl" yaml:"cache_ttl"`   // seconds, 0 for no expiry
+	CacheQuantization float64 `mapstructure:"cache_quantization" yaml:"cache_quantization"`
+
+	// Latency SLO and inference circuit breaker
+	LatencySLO       int     `mapstructure:"latency_slo" yaml:"latency_slo"` // milliseconds
+	BreakerWindow    int     `mapstructure:"breaker_window" yaml:"breaker_window"`
+	BreakerTripRatio float64 `mapstructure:"breaker_trip_ratio" yaml:"breaker_trip_ratio"`
+	BreakerCooldown  int     `mapstructure:"breaker_cooldown" yaml:"breaker_cooldown"` // seconds
 
 	// Monitoring
 	EnableMetrics  bool `mapstructure:"enable_metrics" yaml:"enable_metrics"`
@@ -40,21 +136,60 @@ type MLConfig struct {
 // DefaultMLConfig returns default ML configuration
 func DefaultMLConfig() MLConfig {
 	return MLConfig{
-		ModelType:          "ensemble",
-		DetectionThreshold: 0.6,
-		BatchSize:          32,
-		TrainingEpochs:     100,
-		LearningRate:       0.001,
-		FeatureSize:        128,
-		GenerateFakeData:   true,
-		FakeDataSize:       1000,
-		ModelPath:          "./models/bot_detection_model",
-		SaveModel:          true,
-		LoadModel:          false,
-		EnableGPU:          false,
-		MaxConcurrency:     4,
-		EnableMetrics:      true,
-		LogPredictions:     false,
+		ModelType:            "ensemble",
+		EnsembleModels:       []string{"neural_network", "svm"},
+		ModelFormat:          "native",
+		TFLiteThreads:        1,
+		DetectionThreshold:   0.6,
+		BatchSize:            32,
+		TrainingEpochs:       100,
+		LearningRate:         0.001,
+		FeatureSize:          128,
+		GBDTTrees:            100,
+		GBDTMaxDepth:         4,
+		GBDTLearningRate:     0.1,
+		GBDTBins:             32,
+		GBDTMinSamplesLeaf:   10,
+		KNNNeighbors:         5,
+		KNNDistance:          "euclidean",
+		SVMMode:              "binary",
+		SVMNu:                0.05,
+		OnlineBufferSize:     5000,
+		OnlineRefitSamples:   200,
+		EvaluationFolds:      0,
+		EvaluationHoldout:    0.2,
+		Calibration:          "none",
+		CalibrationHoldout:   0.2,
+		Scaling:              "standard",
+		Explanations:         3,
+		GenerateFakeData:     true,
+		FakeDataSize:         1000,
+		TrainingLabelColumn:  "label",
+		ModelPath:            "./models/bot_detection_model",
+		SaveModel:            true,
+		LoadModel:            false,
+		WatchModel:           false,
+		ShadowQueueSize:      1000,
+		ModelDir:             "./models",
+		ReviewQueueSize:      1000,
+		ReviewMinConfidence:  0.4,
+		ReviewMaxConfidence:  0.6,
+		DriftWindow:          5000,
+		DriftThreshold:       0.25,
+		DriftRetrain:         false,
+		DriftRetrainCooldown: 3600,
+		EnableGPU:            false,
+		MaxConcurrency:       4,
+		WarmupInferences:     5,
+		CacheSize:            0,
+		CacheTTL:             60,
+		CacheQuantization:    0.01,
+		LatencySLO:           50,
+		BreakerWindow:        20,
+		BreakerTripRatio:     0.5,
+		BreakerCooldown:      30,
+		EnableMetrics:        true,
+		LogPredictions:       false,
 	}
 }
 
@@ -81,6 +216,7 @@ func ValidateMLConfig(config MLConfig) error {
 		"random_forest":  true,
 		"knn":            true,
 		"svm":            true,
+		"gbdt":           true,
 		"ensemble":       true,
 	}
 
@@ -88,6 +224,34 @@ func ValidateMLConfig(config MLConfig) error {
 		return fmt.Errorf("invalid model type: %s", config.ModelType)
 	}
 
+	if config.ModelType == "ensemble" {
+		if len(config.EnsembleModels) == 0 {
+			return fmt.Errorf("ensemble requires at least one model")
+		}
+		for _, model := range config.EnsembleModels {
+			if model == "ensemble" || model == "random_forest" || !validModels[model] {
+				return fmt.Errorf("invalid ensemble model: %s", model)
+			}
+		}
+	}
+
+	switch config.ModelFormat {
+	case "native":
+	case "tflite":
+		if config.ModelPath == "" {
+			return fmt.Errorf("tflite model format requires a model path")
+		}
+		if config.TFLiteThreads <= 0 {
+			return fmt.Errorf("tflite threads must be positive")
+		}
+	default:
+		return fmt.Errorf("invalid model format: %s", config.ModelFormat)
+	}
+
+	if config.WatchModel && config.ModelPath == "" {
+		return fmt.Errorf("watch_model requires a model path")
+	}
+
 	// Validate thresholds
 	if config.DetectionThreshold < 0 || config.DetectionThreshold > 1 {
 		return fmt.Errorf("detection threshold must be between 0 and 1")
@@ -106,6 +270,22 @@ func ValidateMLConfig(config MLConfig) error {
 		return fmt.Errorf("feature size must be positive")
 	}
 
+	if n := len(config.TrainingFeatureColumns); n > 0 && n != config.FeatureSize {
+		return fmt.Errorf("training feature columns must name %d columns, got %d", config.FeatureSize, n)
+	}
+
+	if (config.TrainingDataset != "" || config.EvaluationDataset != "") && config.DatasetDir == "" {
+		return fmt.Errorf("training and evaluation datasets require a dataset directory")
+	}
+
+	if config.TrainingDataset != "" && config.TrainingDataPath != "" {
+		return fmt.Errorf("training dataset and training data path are mutually exclusive")
+	}
+
+	if config.EvaluationDataset != "" && config.TrainingDataset == "" {
+		return fmt.Errorf("evaluation dataset requires a training dataset")
+	}
+
 	if config.FakeDataSize <= 0 {
 		return fmt.Errorf("fake data size must be positive")
 	}
@@ -114,9 +294,125 @@ func ValidateMLConfig(config MLConfig) error {
 		return fmt.Errorf("training epochs must be positive")
 	}
 
+	if config.GBDTTrees <= 0 || config.GBDTMaxDepth <= 0 || config.GBDTMinSamplesLeaf <= 0 {
+		return fmt.Errorf("gbdt trees, max depth and min samples per leaf must be positive")
+	}
+
+	if config.GBDTLearningRate <= 0 || config.GBDTLearningRate > 1 {
+		return fmt.Errorf("gbdt learning rate must be between 0 and 1")
+	}
+
+	if config.GBDTBins < 2 || config.GBDTBins > 256 {
+		return fmt.Errorf("gbdt bins must be between 2 and 256")
+	}
+
+	if config.KNNNeighbors <= 0 {
+		return fmt.Errorf("knn neighbors must be positive")
+	}
+
+	switch config.KNNDistance {
+	case "euclidean", "manhattan", "chebyshev":
+	default:
+		return fmt.Errorf("invalid knn distance: %s", config.KNNDistance)
+	}
+
+	switch config.SVMMode {
+	case "binary", "one_class":
+	default:
+		return fmt.Errorf("invalid svm mode: %s", config.SVMMode)
+	}
+
+	if config.SVMNu <= 0 || config.SVMNu > 1 {
+		return fmt.Errorf("svm nu must be between 0 and 1")
+	}
+
+	if config.SVMGamma < 0 {
+		return fmt.Errorf("svm gamma must not be negative")
+	}
+
+	if config.OnlineBufferSize <= 0 || config.OnlineRefitSamples <= 0 {
+		return fmt.Errorf("online buffer size and refit samples must be positive")
+	}
+
+	if config.EvaluationFolds < 0 || config.EvaluationFolds == 1 {
+		return fmt.Errorf("evaluation folds must be 0 for a holdout or at least 2")
+	}
+
+	if config.EvaluationHoldout <= 0 || config.EvaluationHoldout >= 1 {
+		return fmt.Errorf("evaluation holdout must be between 0 and 1")
+	}
+
+	switch config.Calibration {
+	case "none", "platt", "isotonic":
+	default:
+		return fmt.Errorf("invalid calibration: %s", config.Calibration)
+	}
+
+	if config.CalibrationHoldout <= 0 || config.CalibrationHoldout >= 1 {
+		return fmt.Errorf("calibration holdout must be between 0 and 1")
+	}
+
+	switch config.Scaling {
+	case "none", "standard", "minmax":
+	default:
+		return fmt.Errorf("invalid feature scaling: %s", config.Scaling)
+	}
+
+	if config.Explanations < 0 {
+		return fmt.Errorf("explanations cannot be negative")
+	}
+
 	if config.MaxConcurrency <= 0 {
 		return fmt.Errorf("max concurrency must be positive")
 	}
 
+	if config.CacheSize < 0 || config.CacheTTL < 0 || config.CacheQuantization < 0 {
+		return fmt.Errorf("cache size, TTL and quantization must not be negative")
+	}
+
+	if config.ShadowModelPath != "" && config.ShadowQueueSize <= 0 {
+		return fmt.Errorf("shadow queue size must be positive")
+	}
+
+	if config.ReviewQueueSize < 0 {
+		return fmt.Errorf("review queue size must not be negative")
+	}
+
+	if config.ReviewMinConfidence < 0 || config.ReviewMinConfidence > config.ReviewMaxConfidence || config.ReviewMaxConfidence > 1 {
+		return fmt.Errorf("review confidence band must satisfy 0 <= min <= max <= 1")
+	}
+
+	if config.DriftWindow < 0 {
+		return fmt.Errorf("drift window must not be negative")
+	}
+
+	if config.DriftWindow > 0 && config.DriftThreshold <= 0 {
+		return fmt.Errorf("drift threshold must be positive")
+	}
+
+	if config.DriftRetrainCooldown < 0 {
+		return fmt.Errorf("drift retrain cooldown must not be negative")
+	}
+
+	if config.WarmupInferences < 0 {
+		return fmt.Errorf("warmup inferences must not be negative")
+	}
+
+	if config.LatencySLO <= 0 {
+		return fmt.Errorf("latency SLO must be positive")
+	}
+
+	if config.BreakerWindow <= 0 {
+		return fmt.Errorf("breaker window must be positive")
+	}
+
+	if config.BreakerTripRatio <= 0 || config.BreakerTripRatio > 1 {
+		return fmt.Errorf("breaker trip ratio must be between 0 and 1")
+	}
+
+	if config.BreakerCooldown <= 0 {
+		return fmt.Errorf("breaker cooldown must be positive")
+	}
+
 	return nil
 }
@@ -0,0 +1,41 @@
+package dataset
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// readCSV reads a CSV file with a header row naming the columns
+func readCSV(data []byte) ([]column, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.ReuseRecord = true
+
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("no header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]column, len(header))
+	for i, name := range header {
+		columns[i] = column{name: strings.TrimSpace(name), text: true}
+	}
+
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return columns, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range record {
+			columns[i].texts = append(columns[i].texts, v)
+		}
+	}
+}
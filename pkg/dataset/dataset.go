@@ -0,0 +1,416 @@
+// Package dataset reads and writes labelled feature datasets for training:
+// one row per flow with a numeric column per feature and a label column, in
+// CSV, JSON Lines or Parquet. Rows without a label are skipped when
+// loading, so files collected from live traffic train on the flows
+// analysts have labelled.
+package dataset
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dataset formats
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// DefaultLabelColumn names the label column when no other is configured
+const DefaultLabelColumn = "label"
+
+// Dataset holds labelled feature vectors: 1 for bots, 0 for humans
+type Dataset struct {
+	Features [][]float64
+	Labels   []int
+}
+
+// Options map the columns of a dataset file to features and labels
+type Options struct {
+	// Format of the file, from its extension when empty
+	Format string
+
+	// Number of feature columns expected, not checked when 0
+	FeatureSize int
+
+	// Column holding the labels, DefaultLabelColumn when empty. Labels are
+	// 1 or 0, true or false, or bot or human.
+	LabelColumn string
+
+	// Columns holding features 0, 1, ... in order; when empty, every column
+	// but the label column and the flow metadata columns written by Writer
+	// in the order of the file
+	FeatureColumns []string
+}
+
+// column holds the values of one column, as numbers or, for text columns,
+// as text. Missing values hold a zero placeholder and are marked in nulls,
+// which is nil while there are none.
+type column struct {
+	name    string
+	numbers []float64
+	texts   []string
+	text    bool
+	nulls   []bool
+}
+
+// len returns the number of values in the column
+func (c *column) len() int {
+	if c.text {
+		return len(c.texts)
+	}
+	return len(c.numbers)
+}
+
+// null reports whether value i of the column is missing
+func (c *column) null(i int) bool {
+	return c.nulls != nil && c.nulls[i]
+}
+
+// appendNull appends a missing value
+func (c *column) appendNull() {
+	if c.nulls == nil {
+		c.nulls = make([]bool, c.len())
+	}
+	if c.text {
+		c.texts = append(c.texts, "")
+	} else {
+		c.numbers = append(c.numbers, 0)
+	}
+	c.nulls = append(c.nulls, true)
+}
+
+// appendFrom appends value i of src, which has the same type
+func (c *column) appendFrom(src *column, i int) {
+	if c.text {
+		c.texts = append(c.texts, src.texts[i])
+	} else {
+		c.numbers = append(c.numbers, src.numbers[i])
+	}
+	if c.nulls != nil {
+		c.nulls = append(c.nulls, false)
+	}
+}
+
+// appendText appends a value to a text column
+func (c *column) appendText(v string) {
+	c.texts = append(c.texts, v)
+	if c.nulls != nil {
+		c.nulls = append(c.nulls, false)
+	}
+}
+
+// unlabelled reports whether value i of a label column is missing or empty
+func (c *column) unlabelled(i int) bool {
+	return c.null(i) || (c.text && strings.TrimSpace(c.texts[i]) == "")
+}
+
+// number returns value i of the column as a number; booleans are 1 or 0
+// as in parquet boolean columns
+func (c *column) number(i int) (float64, error) {
+	if c.null(i) {
+		return 0, fmt.Errorf("missing value")
+	}
+	if !c.text {
+		return c.numbers[i], nil
+	}
+	text := strings.TrimSpace(c.texts[i])
+	switch strings.ToLower(text) {
+	case "true":
+		return 1, nil
+	case "false":
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", c.texts[i])
+	}
+	return v, nil
+}
+
+// label returns value i of the column as a label
+func (c *column) label(i int) (int, error) {
+	if !c.text {
+		switch c.numbers[i] {
+		case 0:
+			return 0, nil
+		case 1:
+			return 1, nil
+		}
+		return 0, fmt.Errorf("invalid label %v", c.numbers[i])
+	}
+	switch strings.ToLower(strings.TrimSpace(c.texts[i])) {
+	case "0", "false", "human":
+		return 0, nil
+	case "1", "true", "bot":
+		return 1, nil
+	}
+	return 0, fmt.Errorf("invalid label %q", c.texts[i])
+}
+
+// Load reads the dataset file at path. Rows without a label are skipped.
+func Load(path string, opts Options) (*Dataset, error) {
+	columns, err := readColumns(path, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := fromColumns(columns, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dataset %s: %w", path, err)
+	}
+	return ds, nil
+}
+
+// LoadRecords reads every row of the dataset file at path, labelled or
+// not, as a record with the flow metadata found in the file. It returns
+// the names of the feature columns along with the records.
+func LoadRecords(path string, opts Options) ([]Record, []string, error) {
+	columns, err := readColumns(path, opts.Format)
+	if err != nil {
+		return nil, nil, err
+	}
+	records, names, err := recordsFromColumns(columns, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid dataset %s: %w", path, err)
+	}
+	return records, names, nil
+}
+
+// readColumns reads the columns of the dataset file at path, in the format
+// of its extension when format is empty
+func readColumns(path, format string) ([]column, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			format = FormatCSV
+		case ".jsonl", ".ndjson":
+			format = FormatJSONL
+		case ".parquet", ".pq":
+			format = FormatParquet
+		default:
+			return nil, fmt.Errorf("unknown dataset format of %s", path)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dataset: %w", err)
+	}
+
+	var columns []column
+	switch format {
+	case FormatCSV:
+		columns, err = readCSV(data)
+	case FormatJSONL:
+		columns, err = readJSONL(data)
+	case FormatParquet:
+		columns, err = readParquet(data)
+	default:
+		return nil, fmt.Errorf("unsupported dataset format: %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dataset %s: %w", path, err)
+	}
+	return columns, nil
+}
+
+// mapping maps the columns of a file to features and labels
+type mapping struct {
+	byName   map[string]*column
+	label    *column
+	features []*column
+	rows     int
+}
+
+// mapColumns maps columns to features and labels as configured
+func mapColumns(columns []column, opts Options) (*mapping, error) {
+	m := &mapping{byName: make(map[string]*column, len(columns))}
+	for i := range columns {
+		if _, ok := m.byName[columns[i].name]; ok {
+			return nil, fmt.Errorf("duplicate column %q", columns[i].name)
+		}
+		m.byName[columns[i].name] = &columns[i]
+	}
+
+	labelName := opts.LabelColumn
+	if labelName == "" {
+		labelName = DefaultLabelColumn
+	}
+	var ok bool
+	if m.label, ok = m.byName[labelName]; !ok {
+		return nil, fmt.Errorf("no label column %q", labelName)
+	}
+
+	if len(opts.FeatureColumns) > 0 {
+		for _, name := range opts.FeatureColumns {
+			c, ok := m.byName[name]
+			if !ok {
+				return nil, fmt.Errorf("no feature column %q", name)
+			}
+			m.features = append(m.features, c)
+		}
+	} else {
+		for i := range columns {
+			if columns[i].name != labelName && !isMetadataColumn(columns[i].name) {
+				m.features = append(m.features, &columns[i])
+			}
+		}
+	}
+	if opts.FeatureSize > 0 && len(m.features) != opts.FeatureSize {
+		return nil, fmt.Errorf("%d feature columns, expected %d", len(m.features), opts.FeatureSize)
+	}
+
+	m.rows = m.label.len()
+	if m.rows == 0 {
+		return nil, fmt.Errorf("no samples")
+	}
+	return m, nil
+}
+
+// row returns the features of row i
+func (m *mapping) row(i int) ([]float64, error) {
+	row := make([]float64, len(m.features))
+	for j, c := range m.features {
+		v, err := c.number(i)
+		if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+			err = fmt.Errorf("non-finite value %v", v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("row %d, column %q: %w", i+1, c.name, err)
+		}
+		row[j] = v
+	}
+	return row, nil
+}
+
+// labelOf returns the label of row i, nil when the row is unlabelled
+func (m *mapping) labelOf(i int) (*int, error) {
+	if m.label.unlabelled(i) {
+		return nil, nil
+	}
+	label, err := m.label.label(i)
+	if err != nil {
+		return nil, fmt.Errorf("row %d, column %q: %w", i+1, m.label.name, err)
+	}
+	return &label, nil
+}
+
+// fromColumns maps columns to the features and labels of labelled rows
+func fromColumns(columns []column, opts Options) (*Dataset, error) {
+	m, err := mapColumns(columns, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ds := &Dataset{}
+	for i := 0; i < m.rows; i++ {
+		label, err := m.labelOf(i)
+		if err != nil {
+			return nil, err
+		}
+		if label == nil {
+			continue
+		}
+		row, err := m.row(i)
+		if err != nil {
+			return nil, err
+		}
+		ds.Features = append(ds.Features, row)
+		ds.Labels = append(ds.Labels, *label)
+	}
+	if len(ds.Labels) == 0 {
+		return nil, fmt.Errorf("no labelled samples in %d rows", m.rows)
+	}
+	return ds, nil
+}
+
+// recordsFromColumns maps columns to records and returns the feature
+// column names
+func recordsFromColumns(columns []column, opts Options) ([]Record, []string, error) {
+	m, err := mapColumns(columns, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, len(m.features))
+	for i, c := range m.features {
+		names[i] = c.name
+	}
+
+	records := make([]Record, m.rows)
+	for i := range records {
+		r := &records[i]
+		if r.Features, err = m.row(i); err != nil {
+			return nil, nil, err
+		}
+		if r.Label, err = m.labelOf(i); err != nil {
+			return nil, nil, err
+		}
+		if err := m.metadata(i, r); err != nil {
+			return nil, nil, fmt.Errorf("row %d: %w", i+1, err)
+		}
+	}
+	return records, names, nil
+}
+
+// metadata sets the flow metadata of row i found in the file on r
+func (m *mapping) metadata(i int, r *Record) error {
+	text := func(name string) string {
+		c, ok := m.byName[name]
+		if !ok || c.null(i) {
+			return ""
+		}
+		if c.text {
+			return c.texts[i]
+		}
+		return formatFloat(c.numbers[i])
+	}
+	number := func(name string) (float64, error) {
+		c, ok := m.byName[name]
+		if !ok || c.null(i) || (c.text && strings.TrimSpace(c.texts[i]) == "") {
+			return 0, nil
+		}
+		v, err := c.number(i)
+		if err != nil {
+			return 0, fmt.Errorf("column %q: %w", name, err)
+		}
+		return v, nil
+	}
+
+	if c, ok := m.byName[ColumnTimestamp]; ok && !c.null(i) {
+		if c.text {
+			if v := strings.TrimSpace(c.texts[i]); v != "" {
+				t, err := time.Parse(time.RFC3339Nano, v)
+				if err != nil {
+					return fmt.Errorf("column %q: invalid time %q", ColumnTimestamp, v)
+				}
+				r.Timestamp = t
+			}
+		} else {
+			r.Timestamp = time.UnixMilli(int64(c.numbers[i])).UTC()
+		}
+	}
+	r.FlowID = text(ColumnFlowID)
+	r.SrcIP = text(ColumnSrcIP)
+	r.DstIP = text(ColumnDstIP)
+	r.Protocol = text(ColumnProtocol)
+	r.ModelVersion = text(ColumnModelVersion)
+	r.LabelSource = text(ColumnLabelSource)
+
+	var values [4]float64
+	for j, name := range []string{ColumnSrcPort, ColumnDstPort, ColumnPredictedBot, ColumnConfidence} {
+		v, err := number(name)
+		if err != nil {
+			return err
+		}
+		values[j] = v
+	}
+	r.SrcPort, r.DstPort = int(values[0]), int(values[1])
+	r.PredictedBot, r.Confidence = values[2] != 0, values[3]
+	return nil
+}
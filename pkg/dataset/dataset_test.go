@@ -0,0 +1,56 @@
+package dataset
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCSV(t *testing.T) {
+	path := writeFile(t, "train.csv", []byte("duration, packets ,label\n0.5,3,1\n1.5,40,human\n2.5,7,false\n"))
+
+	ds, err := Load(path, Options{FeatureSize: 2})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{0.5, 3}, {1.5, 40}, {2.5, 7}}, ds.Features)
+	assert.Equal(t, []int{1, 0, 0}, ds.Labels)
+
+	// Columns are mapped to features in the configured order
+	ds, err = Load(path, Options{FeatureColumns: []string{"packets", "duration"}})
+	require.NoError(t, err)
+	assert.Equal(t, []float64{3, 0.5}, ds.Features[0])
+
+	_, err = Load(path, Options{FeatureSize: 3})
+	assert.ErrorContains(t, err, "2 feature columns, expected 3")
+	_, err = Load(path, Options{FeatureColumns: []string{"bytes"}})
+	assert.ErrorContains(t, err, `no feature column "bytes"`)
+	_, err = Load(path, Options{LabelColumn: "verdict"})
+	assert.ErrorContains(t, err, `no label column "verdict"`)
+}
+
+func TestLoadCSVErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		data string
+		err  string
+	}{
+		"empty":        {"", "no header row"},
+		"no samples":   {"x,label\n", "no samples"},
+		"ragged":       {"x,label\n1,0\n2\n", "wrong number of fields"},
+		"bad number":   {"x,label\nabc,0\n", `row 1, column "x": invalid number "abc"`},
+		"non-finite":   {"x,label\n1,0\nNaN,1\n", `row 2, column "x": non-finite value NaN`},
+		"bad label":    {"x,label\n1,maybe\n", `row 1, column "label": invalid label "maybe"`},
+		"duplicate":    {"x,x,label\n1,2,0\n", `duplicate column "x"`},
+		"empty number": {"x,label\n,1\n", `invalid number ""`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load(writeFile(t, "train.csv", []byte(tc.data)), Options{})
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+
+	_, err := Load(writeFile(t, "train.txt", []byte("x,label\n1,0\n")), Options{})
+	assert.ErrorContains(t, err, "unknown dataset format")
+	ds, err := Load(writeFile(t, "train.txt", []byte("x,label\n1,0\n")), Options{Format: FormatCSV})
+	require.NoError(t, err)
+	assert.Len(t, ds.Labels, 1)
+}
@@ -0,0 +1,79 @@
+package dataset
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// readJSONL reads JSON Lines of flat objects, one row per object, with a
+// column per key in the order the keys first appear. Keys missing from an
+// object and null values are missing values.
+func readJSONL(data []byte) ([]column, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var columns []column
+	index := make(map[string]int)
+	for row := 0; ; row++ {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return columns, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+			return nil, fmt.Errorf("row %d is not an object", row+1)
+		}
+
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			name, _ := tok.(string)
+			i, ok := index[name]
+			if !ok {
+				i = len(columns)
+				index[name] = i
+				columns = append(columns, column{name: name, text: true})
+				for j := 0; j < row; j++ {
+					columns[i].appendNull()
+				}
+			}
+			c := &columns[i]
+			if c.len() > row {
+				return nil, fmt.Errorf("row %d: duplicate key %q", row+1, name)
+			}
+
+			if tok, err = dec.Token(); err != nil {
+				return nil, err
+			}
+			switch v := tok.(type) {
+			case json.Number:
+				c.appendText(v.String())
+			case string:
+				c.appendText(v)
+			case bool:
+				c.appendText(strconv.FormatBool(v))
+			case nil:
+				c.appendNull()
+			default:
+				return nil, fmt.Errorf("row %d, column %q: nested values are not supported", row+1, name)
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+
+		for i := range columns {
+			if columns[i].len() == row {
+				columns[i].appendNull()
+			}
+		}
+	}
+}
@@ -0,0 +1,647 @@
+package dataset
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The reader covers flat parquet files as written by pandas, pyarrow and
+// Spark: required or optional columns of booleans, integers, floats and
+// strings, plain or dictionary encoded, in v1 or v2 data pages that are
+// uncompressed or compressed with snappy or gzip. Null values of optional
+// columns are kept as missing values.
+
+// parquetMagic starts and ends parquet files
+const parquetMagic = "PAR1"
+
+// Parquet physical types
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// Parquet repetition types
+const (
+	parquetRequired = 0
+	parquetOptional = 1
+)
+
+// Parquet compression codecs
+const (
+	parquetUncompressed = 0
+	parquetSnappy       = 1
+	parquetGzip         = 2
+)
+
+// Parquet page types
+const (
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+	parquetDataPageV2     = 3
+)
+
+// Parquet encodings
+const (
+	parquetPlain           = 0
+	parquetPlainDictionary = 2
+	parquetRLE             = 3
+	parquetRLEDictionary   = 8
+)
+
+var errTruncated = errors.New("truncated parquet data")
+
+// parquetLeaf is a column of the parquet schema
+type parquetLeaf struct {
+	name       string
+	typ        int64
+	repetition int64
+}
+
+// readParquet reads the columns of a parquet file
+func readParquet(data []byte) ([]column, error) {
+	if len(data) < 12 || string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		return nil, fmt.Errorf("not a parquet file")
+	}
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footerLength > len(data)-12 {
+		return nil, errTruncated
+	}
+	footer := &thriftReader{buf: data[len(data)-8-footerLength : len(data)-8]}
+	meta, err := footer.readStruct()
+	if err != nil {
+		return nil, fmt.Errorf("invalid parquet footer: %w", err)
+	}
+
+	schema := thriftList(meta, 2)
+	if len(schema) < 2 {
+		return nil, fmt.Errorf("parquet file has no columns")
+	}
+	leaves := make([]parquetLeaf, len(schema)-1)
+	columns := make([]column, len(leaves))
+	for i, v := range schema[1:] {
+		element, _ := v.(thriftStruct)
+		leaf := parquetLeaf{name: thriftString(element, 4), repetition: thriftInt(element, 3)}
+		if thriftInt(element, 5) > 0 {
+			return nil, fmt.Errorf("nested parquet column %q is not supported", leaf.name)
+		}
+		if leaf.repetition != parquetRequired && leaf.repetition != parquetOptional {
+			return nil, fmt.Errorf("repeated parquet column %q is not supported", leaf.name)
+		}
+		leaf.typ = thriftInt(element, 1)
+		switch leaf.typ {
+		case parquetBoolean, parquetInt32, parquetInt64, parquetFloat, parquetDouble:
+		case parquetByteArray:
+			columns[i].text = true
+		default:
+			return nil, fmt.Errorf("parquet column %q has unsupported type %d", leaf.name, leaf.typ)
+		}
+		leaves[i] = leaf
+		columns[i].name = leaf.name
+	}
+
+	for _, v := range thriftList(meta, 4) {
+		rowGroup, _ := v.(thriftStruct)
+		chunks := thriftList(rowGroup, 1)
+		if len(chunks) != len(leaves) {
+			return nil, fmt.Errorf("parquet row group has %d columns, expected %d", len(chunks), len(leaves))
+		}
+		for i, v := range chunks {
+			chunk, _ := v.(thriftStruct)
+			md, ok := chunk[3].(thriftStruct)
+			if !ok {
+				return nil, fmt.Errorf("parquet column %q is stored in another file", leaves[i].name)
+			}
+			start := thriftInt(md, 9)
+			if dictionary := thriftInt(md, 11); dictionary > 0 && dictionary < start {
+				start = dictionary
+			}
+			end := start + thriftInt(md, 7)
+			if start < 4 || end > int64(len(data)) || end < start {
+				return nil, errTruncated
+			}
+			err := readColumnChunk(&columns[i], leaves[i], data[start:end], thriftInt(md, 4), thriftInt(md, 5))
+			if err != nil {
+				return nil, fmt.Errorf("parquet column %q: %w", leaves[i].name, err)
+			}
+		}
+	}
+	return columns, nil
+}
+
+// readColumnChunk appends the values of one column chunk to c
+func readColumnChunk(c *column, leaf parquetLeaf, chunk []byte, codec, values int64) error {
+	r := &thriftReader{buf: chunk}
+	var dictionary *column
+	for read := int64(0); read < values; {
+		header, err := r.readStruct()
+		if err != nil {
+			return fmt.Errorf("invalid page header: %w", err)
+		}
+		size := int(thriftInt(header, 3))
+		if size < 0 || size > len(r.buf)-r.pos {
+			return errTruncated
+		}
+		body := r.buf[r.pos : r.pos+size]
+		r.pos += size
+		uncompressed := int(thriftInt(header, 2))
+
+		switch thriftInt(header, 1) {
+		case parquetDictionaryPage:
+			page, err := decompress(codec, body, uncompressed)
+			if err != nil {
+				return err
+			}
+			dictionary = &column{text: c.text}
+			n := int(thriftInt(thriftStructField(header, 7), 1))
+			if _, err := decodePlain(dictionary, leaf.typ, page, n); err != nil {
+				return err
+			}
+
+		case parquetDataPage:
+			h := thriftStructField(header, 5)
+			n := int(thriftInt(h, 1))
+			page, err := decompress(codec, body, uncompressed)
+			if err != nil {
+				return err
+			}
+			var levels []int
+			if leaf.repetition == parquetOptional {
+				if len(page) < 4 {
+					return errTruncated
+				}
+				length := int(binary.LittleEndian.Uint32(page))
+				if length > len(page)-4 {
+					return errTruncated
+				}
+				if levels, err = decodeHybrid(page[4:4+length], 1, n); err != nil {
+					return err
+				}
+				page = page[4+length:]
+			}
+			err = appendLevels(c, levels, n, func(values *column, defined int) error {
+				return decodeValues(values, leaf.typ, thriftInt(h, 2), page, defined, dictionary)
+			})
+			if err != nil {
+				return err
+			}
+			read += int64(n)
+
+		case parquetDataPageV2:
+			h := thriftStructField(header, 8)
+			n := int(thriftInt(h, 1))
+			// Repetition levels precede the definition levels, without
+			// length prefixes
+			repetitionSize, definitionSize := int(thriftInt(h, 6)), int(thriftInt(h, 5))
+			if repetitionSize < 0 || definitionSize < 0 || repetitionSize+definitionSize > len(body) {
+				return errTruncated
+			}
+			var levels []int
+			if leaf.repetition == parquetOptional {
+				definitions := body[repetitionSize : repetitionSize+definitionSize]
+				if levels, err = decodeHybrid(definitions, 1, n); err != nil {
+					return err
+				}
+			} else if thriftInt(h, 2) > 0 {
+				return fmt.Errorf("null values in a required column")
+			}
+			page := body[repetitionSize+definitionSize:]
+			if compressed, ok := h[7].(bool); !ok || compressed {
+				if page, err = decompress(codec, page, uncompressed-repetitionSize-definitionSize); err != nil {
+					return err
+				}
+			}
+			err = appendLevels(c, levels, n, func(values *column, defined int) error {
+				return decodeValues(values, leaf.typ, thriftInt(h, 4), page, defined, dictionary)
+			})
+			if err != nil {
+				return err
+			}
+			read += int64(n)
+		}
+	}
+	return nil
+}
+
+// appendLevels appends n values of a page to c, decoding the values
+// defined by the definition levels, all of them when levels is nil, with
+// decode
+func appendLevels(c *column, levels []int, n int, decode func(values *column, defined int) error) error {
+	defined := n
+	if levels != nil {
+		defined = 0
+		for _, level := range levels {
+			defined += level
+		}
+	}
+	if defined == n && c.nulls == nil {
+		return decode(c, n)
+	}
+
+	values := &column{text: c.text}
+	if err := decode(values, defined); err != nil {
+		return err
+	}
+	next := 0
+	for i := 0; i < n; i++ {
+		if levels != nil && levels[i] == 0 {
+			c.appendNull()
+			continue
+		}
+		c.appendFrom(values, next)
+		next++
+	}
+	return nil
+}
+
+// decompress returns the uncompressed contents of a page
+func decompress(codec int64, body []byte, size int) ([]byte, error) {
+	var page []byte
+	switch codec {
+	case parquetUncompressed:
+		page = body
+	case parquetSnappy:
+		var err error
+		if page, err = snappyDecode(body); err != nil {
+			return nil, err
+		}
+	case parquetGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if page, err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression codec %d", codec)
+	}
+	if len(page) != size {
+		return nil, fmt.Errorf("page has %d bytes, expected %d", len(page), size)
+	}
+	return page, nil
+}
+
+// decodeValues appends n values encoded in a data page to c
+func decodeValues(c *column, typ, encoding int64, page []byte, n int, dictionary *column) error {
+	switch encoding {
+	case parquetPlain:
+		_, err := decodePlain(c, typ, page, n)
+		return err
+
+	case parquetPlainDictionary, parquetRLEDictionary:
+		if dictionary == nil {
+			return fmt.Errorf("dictionary encoded page without dictionary")
+		}
+		if len(page) < 1 {
+			return errTruncated
+		}
+		indexes, err := decodeHybrid(page[1:], int(page[0]), n)
+		if err != nil {
+			return err
+		}
+		for _, i := range indexes {
+			if i >= dictionary.len() {
+				return fmt.Errorf("dictionary index %d out of range", i)
+			}
+			if c.text {
+				c.texts = append(c.texts, dictionary.texts[i])
+			} else {
+				c.numbers = append(c.numbers, dictionary.numbers[i])
+			}
+		}
+		return nil
+
+	case parquetRLE:
+		if typ != parquetBoolean || len(page) < 4 {
+			return fmt.Errorf("unsupported RLE encoded page")
+		}
+		length := int(binary.LittleEndian.Uint32(page))
+		if length > len(page)-4 {
+			return errTruncated
+		}
+		bits, err := decodeHybrid(page[4:4+length], 1, n)
+		if err != nil {
+			return err
+		}
+		for _, b := range bits {
+			c.numbers = append(c.numbers, float64(b))
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported encoding %d", encoding)
+}
+
+// decodePlain appends n plain encoded values to c and returns the number of
+// bytes they took
+func decodePlain(c *column, typ int64, page []byte, n int) (int, error) {
+	var width int
+	switch typ {
+	case parquetBoolean:
+		if len(page) < (n+7)/8 {
+			return 0, errTruncated
+		}
+		for i := 0; i < n; i++ {
+			c.numbers = append(c.numbers, float64(page[i/8]>>(i%8)&1))
+		}
+		return (n + 7) / 8, nil
+	case parquetInt32, parquetFloat:
+		width = 4
+	case parquetInt64, parquetDouble:
+		width = 8
+	case parquetByteArray:
+		pos := 0
+		for i := 0; i < n; i++ {
+			if len(page)-pos < 4 {
+				return 0, errTruncated
+			}
+			length := int(binary.LittleEndian.Uint32(page[pos:]))
+			pos += 4
+			if length > len(page)-pos {
+				return 0, errTruncated
+			}
+			c.texts = append(c.texts, string(page[pos:pos+length]))
+			pos += length
+		}
+		return pos, nil
+	}
+
+	if len(page) < n*width {
+		return 0, errTruncated
+	}
+	for i := 0; i < n; i++ {
+		b := page[i*width:]
+		var v float64
+		switch typ {
+		case parquetInt32:
+			v = float64(int32(binary.LittleEndian.Uint32(b)))
+		case parquetFloat:
+			v = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		case parquetInt64:
+			v = float64(int64(binary.LittleEndian.Uint64(b)))
+		case parquetDouble:
+			v = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+		c.numbers = append(c.numbers, v)
+	}
+	return n * width, nil
+}
+
+// decodeHybrid decodes n values of the given bit width in the parquet
+// RLE/bit-packing hybrid encoding
+func decodeHybrid(buf []byte, bitWidth, n int) ([]int, error) {
+	if bitWidth > 32 {
+		return nil, fmt.Errorf("invalid bit width %d", bitWidth)
+	}
+	values := make([]int, 0, n)
+	pos := 0
+	for len(values) < n {
+		header, k := binary.Uvarint(buf[pos:])
+		if k <= 0 {
+			return nil, errTruncated
+		}
+		pos += k
+
+		if header&1 == 0 {
+			// Run of one repeated value
+			count := int(min(header>>1, uint64(n-len(values))))
+			width := (bitWidth + 7) / 8
+			if len(buf)-pos < width {
+				return nil, errTruncated
+			}
+			v := 0
+			for i := width - 1; i >= 0; i-- {
+				v = v<<8 | int(buf[pos+i])
+			}
+			pos += width
+			for i := 0; i < count; i++ {
+				values = append(values, v)
+			}
+			continue
+		}
+
+		// Groups of eight bit-packed values, least significant bit first
+		groups := header >> 1
+		if groups > uint64(len(buf)) {
+			return nil, errTruncated
+		}
+		size := int(groups) * bitWidth
+		if len(buf)-pos < size {
+			return nil, errTruncated
+		}
+		packed := buf[pos : pos+size]
+		pos += size
+		for i := 0; i < int(groups)*8 && len(values) < n; i++ {
+			v := 0
+			for b := 0; b < bitWidth; b++ {
+				bit := i*bitWidth + b
+				v |= int(packed[bit/8]>>(bit%8)&1) << b
+			}
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// thriftStruct is a decoded thrift struct by field ID. Integers are int64,
+// binaries []byte, lists []any and structs thriftStruct.
+type thriftStruct map[int16]any
+
+// thriftInt returns an integer field, 0 when absent
+func thriftInt(s thriftStruct, id int16) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+// thriftString returns a binary field as a string
+func thriftString(s thriftStruct, id int16) string {
+	v, _ := s[id].([]byte)
+	return string(v)
+}
+
+// thriftList returns a list field
+func thriftList(s thriftStruct, id int16) []any {
+	v, _ := s[id].([]any)
+	return v
+}
+
+// thriftStructField returns a struct field, empty when absent
+func thriftStructField(s thriftStruct, id int16) thriftStruct {
+	v, _ := s[id].(thriftStruct)
+	return v
+}
+
+// Thrift compact protocol types
+const (
+	compactStop   = 0
+	compactTrue   = 1
+	compactFalse  = 2
+	compactByte   = 3
+	compactI16    = 4
+	compactI32    = 5
+	compactI64    = 6
+	compactDouble = 7
+	compactBinary = 8
+	compactList   = 9
+	compactSet    = 10
+	compactMap    = 11
+	compactStruct = 12
+)
+
+// thriftReader decodes the thrift compact protocol used by parquet
+// metadata
+type thriftReader struct {
+	buf   []byte
+	pos   int
+	depth int
+}
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, errTruncated
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	v, k := binary.Uvarint(r.buf[r.pos:])
+	if k <= 0 {
+		return 0, errTruncated
+	}
+	r.pos += k
+	return v, nil
+}
+
+func (r *thriftReader) varint() (int64, error) {
+	v, err := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+// readStruct reads fields up to the end of a struct
+func (r *thriftReader) readStruct() (thriftStruct, error) {
+	if r.depth++; r.depth > 32 {
+		return nil, fmt.Errorf("thrift structs nested too deeply")
+	}
+	defer func() { r.depth-- }()
+
+	s := make(thriftStruct)
+	var id int16
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if b == compactStop {
+			return s, nil
+		}
+		if delta := b >> 4; delta != 0 {
+			id += int16(delta)
+		} else {
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		typ := b & 0x0f
+		switch typ {
+		case compactTrue:
+			s[id] = true
+		case compactFalse:
+			s[id] = false
+		default:
+			if s[id], err = r.readValue(typ); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// readValue reads a value of a type other than a boolean field
+func (r *thriftReader) readValue(typ byte) (any, error) {
+	switch typ {
+	case compactTrue, compactFalse:
+		// Booleans in lists take a byte each
+		b, err := r.byte()
+		return b == compactTrue, err
+	case compactByte:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case compactI16, compactI32, compactI64:
+		return r.varint()
+	case compactDouble:
+		if len(r.buf)-r.pos < 8 {
+			return nil, errTruncated
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.buf[r.pos:]))
+		r.pos += 8
+		return v, nil
+	case compactBinary:
+		n, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(r.buf)-r.pos) {
+			return nil, errTruncated
+		}
+		v := r.buf[r.pos : r.pos+int(n)]
+		r.pos += int(n)
+		return v, nil
+	case compactList, compactSet:
+		header, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(header >> 4)
+		if n == 15 {
+			if n, err = r.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		if n > uint64(len(r.buf)-r.pos) {
+			return nil, errTruncated
+		}
+		list := make([]any, n)
+		for i := range list {
+			if list[i], err = r.readValue(header & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case compactMap:
+		n, err := r.uvarint()
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		if n > uint64(len(r.buf)-r.pos) {
+			return nil, errTruncated
+		}
+		types, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < 2*n; i++ {
+			typ := types >> 4
+			if i%2 == 1 {
+				typ = types & 0x0f
+			}
+			if _, err := r.readValue(typ); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case compactStruct:
+		return r.readStruct()
+	}
+	return nil, fmt.Errorf("unknown thrift type %d", typ)
+}
@@ -0,0 +1,225 @@
+package dataset
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testColumn is a column written by writeTestParquet
+type testColumn struct {
+	name     string
+	typ      int32
+	optional bool
+	values   []any // float64, int64 or string
+	dict     bool  // dictionary encode the values
+	v2       bool  // write a v2 data page
+}
+
+// snappyLiteral compresses into a snappy block of literals only
+func snappyLiteral(data []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := min(len(data), 60)
+		b = append(b, byte(n-1)<<2)
+		b = append(b, data[:n]...)
+		data = data[n:]
+	}
+	return b
+}
+
+func plainValues(typ int32, values []any) []byte {
+	var b []byte
+	for _, v := range values {
+		switch typ {
+		case parquetDouble:
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v.(float64)))
+		case parquetInt64:
+			b = binary.LittleEndian.AppendUint64(b, uint64(v.(int64)))
+		case parquetByteArray:
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(v.(string))))
+			b = append(b, v.(string)...)
+		}
+	}
+	return b
+}
+
+// writeTestParquet writes a parquet file of one row group
+func writeTestParquet(t *testing.T, codec int32, columns ...testColumn) []byte {
+	compress := func(page []byte) []byte {
+		switch codec {
+		case parquetSnappy:
+			return snappyLiteral(page)
+		case parquetGzip:
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			w.Write(page)
+			w.Close()
+			return buf.Bytes()
+		}
+		return page
+	}
+	page := func(b []byte, typ int32, body []byte, header thriftField, uncompressed int) []byte {
+		b = encodeThrift(b, []thriftField{
+			{1, typ}, {2, int32(uncompressed)}, {3, int32(len(body))}, header,
+		})
+		return append(b, body...)
+	}
+
+	file := []byte(parquetMagic)
+	schema := []any{[]thriftField{{4, "schema"}, {5, int32(len(columns))}}}
+	var chunks []any
+	rows := int64(0)
+	for _, c := range columns {
+		rows = int64(len(c.values))
+		repetition := int32(parquetRequired)
+		if c.optional {
+			repetition = parquetOptional
+		}
+		schema = append(schema, []thriftField{{1, c.typ}, {3, repetition}, {4, c.name}})
+
+		start := int64(len(file))
+		values, encoding := plainValues(c.typ, c.values), int32(parquetPlain)
+		if c.dict {
+			var dictionary []any
+			index := map[any]int{}
+			var indexes []byte
+			for _, v := range c.values {
+				if _, ok := index[v]; !ok {
+					index[v] = len(dictionary)
+					dictionary = append(dictionary, v)
+				}
+				// Runs of one 8-bit index each
+				indexes = binary.AppendUvarint(indexes, 1<<1)
+				indexes = append(indexes, byte(index[v]))
+			}
+			dictPage := plainValues(c.typ, dictionary)
+			file = page(file, parquetDictionaryPage, compress(dictPage),
+				thriftField{7, []thriftField{{1, int32(len(dictionary))}, {2, int32(parquetPlain)}}}, len(dictPage))
+			values, encoding = append([]byte{8}, indexes...), parquetRLEDictionary
+		}
+
+		if c.v2 {
+			var levels []byte
+			if c.optional {
+				levels = binary.AppendUvarint(nil, uint64(len(c.values))<<1)
+				levels = append(levels, 1)
+			}
+			body := append(levels, compress(values)...)
+			file = page(file, parquetDataPageV2, body, thriftField{8, []thriftField{
+				{1, int32(len(c.values))}, {2, int32(0)}, {3, int32(len(c.values))}, {4, encoding},
+				{5, int32(len(levels))}, {6, int32(0)},
+			}}, len(levels)+len(values))
+		} else {
+			data := values
+			if c.optional {
+				levels := binary.AppendUvarint(nil, uint64(len(c.values))<<1)
+				levels = append(levels, 1)
+				data = append(binary.LittleEndian.AppendUint32(nil, uint32(len(levels))), levels...)
+				data = append(data, values...)
+			}
+			file = page(file, parquetDataPage, compress(data), thriftField{5, []thriftField{
+				{1, int32(len(c.values))}, {2, encoding}, {3, int32(parquetRLE)}, {4, int32(parquetRLE)},
+			}}, len(data))
+		}
+
+		meta := []thriftField{
+			{1, c.typ}, {2, thriftValues{compactI32, []any{encoding}}},
+			{3, thriftValues{compactBinary, []any{c.name}}}, {4, codec},
+			{5, int64(len(c.values))}, {6, int64(0)}, {7, int64(len(file)) - start}, {9, start},
+		}
+		if c.dict {
+			meta = append(meta, thriftField{11, start})
+		}
+		chunks = append(chunks, []thriftField{{2, start}, {3, meta}})
+	}
+
+	footer := encodeThrift(nil, []thriftField{
+		{1, int32(1)},
+		{2, thriftValues{compactStruct, schema}},
+		{3, rows},
+		{4, thriftValues{compactStruct, []any{[]thriftField{
+			{1, thriftValues{compactStruct, chunks}}, {2, int64(0)}, {3, rows},
+		}}}},
+	})
+	file = append(file, footer...)
+	file = binary.LittleEndian.AppendUint32(file, uint32(len(footer)))
+	return append(file, parquetMagic...)
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	return path
+}
+
+func TestLoadParquet(t *testing.T) {
+	for name, codec := range map[string]int32{"uncompressed": parquetUncompressed, "snappy": parquetSnappy, "gzip": parquetGzip} {
+		t.Run(name, func(t *testing.T) {
+			data := writeTestParquet(t, codec,
+				testColumn{name: "duration", typ: parquetDouble, values: []any{0.5, 1.5, 2.5}},
+				testColumn{name: "packets", typ: parquetInt64, optional: true, values: []any{int64(3), int64(40), int64(7)}},
+				testColumn{name: "size", typ: parquetDouble, dict: true, values: []any{100.0, 100.0, 60.0}, v2: true},
+				testColumn{name: "label", typ: parquetByteArray, dict: true, values: []any{"bot", "human", "bot"}},
+			)
+			ds, err := Load(writeFile(t, "train.parquet", data), Options{FeatureSize: 3})
+			require.NoError(t, err)
+			assert.Equal(t, [][]float64{{0.5, 3, 100}, {1.5, 40, 100}, {2.5, 7, 60}}, ds.Features)
+			assert.Equal(t, []int{1, 0, 1}, ds.Labels)
+		})
+	}
+}
+
+func TestLoadParquetErrors(t *testing.T) {
+	_, err := Load(writeFile(t, "bad.parquet", []byte("PAR1 not really PAR1")), Options{})
+	assert.Error(t, err)
+
+	data := writeTestParquet(t, parquetUncompressed,
+		testColumn{name: "x", typ: parquetDouble, values: []any{1.0, 2.0}},
+		testColumn{name: "label", typ: parquetInt64, values: []any{int64(1), int64(2)}},
+	)
+	_, err = Load(writeFile(t, "labels.parquet", data), Options{})
+	assert.ErrorContains(t, err, `row 2, column "label": invalid label 2`)
+
+	// Truncated files are rejected, not read out of bounds
+	for n := 1; n < len(data); n += 7 {
+		_, err := readParquet(append(append([]byte(nil), data[:len(data)-n]...), parquetMagic...))
+		assert.Error(t, err)
+	}
+}
+
+func TestDecodeHybrid(t *testing.T) {
+	// A run of three 5s, then one group of eight bit-packed 3-bit values
+	buf := []byte{3 << 1, 5, 1<<1 | 1, 0x88, 0xc6, 0xfa}
+	values, err := decodeHybrid(buf, 3, 11)
+	require.NoError(t, err)
+	assert.Equal(t, []int{5, 5, 5, 0, 1, 2, 3, 4, 5, 6, 7}, values)
+
+	_, err = decodeHybrid(buf[:4], 3, 11)
+	assert.Error(t, err)
+}
+
+func TestSnappyDecode(t *testing.T) {
+	// "abcd" as a literal, then a one-byte offset copy of 8 bytes
+	block := []byte{12, 3 << 2, 'a', 'b', 'c', 'd', (8-4)<<2 | 1, 4}
+	data, err := snappyDecode(block)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdabcdabcd", string(data))
+
+	// A two-byte offset copy
+	data, err = snappyDecode([]byte{6, 1 << 2, 'x', 'y', (4-1)<<2 | 2, 2, 0})
+	require.NoError(t, err)
+	assert.Equal(t, "xyxyxy", string(data))
+
+	_, err = snappyDecode([]byte{12, 3 << 2, 'a', 'b', 'c', 'd', (8-4)<<2 | 1, 9})
+	assert.Error(t, err)
+	_, err = snappyDecode(snappyLiteral([]byte("abc"))[:3])
+	assert.Error(t, err)
+}
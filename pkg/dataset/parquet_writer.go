@@ -0,0 +1,305 @@
+package dataset
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// parquetRowGroupSize is the number of records buffered per row group
+const parquetRowGroupSize = 10000
+
+// Parquet converted types annotating the physical types
+const (
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+)
+
+// thriftField is a field of a struct encoded by encodeThrift. Values are
+// int32, int64, bool, string, []thriftField for structs and thriftValues
+// for lists.
+type thriftField struct {
+	id    int16
+	value any
+}
+
+// thriftValues is a list of values of one thrift compact type
+type thriftValues struct {
+	typ    byte
+	values []any
+}
+
+func appendThriftValue(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case int32:
+		return binary.AppendUvarint(b, uint64(v<<1^v>>31))
+	case int64:
+		return binary.AppendUvarint(b, uint64(v<<1^v>>63))
+	case string:
+		return append(binary.AppendUvarint(b, uint64(len(v))), v...)
+	case []thriftField:
+		return encodeThrift(b, v)
+	case thriftValues:
+		if len(v.values) < 15 {
+			b = append(b, byte(len(v.values))<<4|v.typ)
+		} else {
+			b = binary.AppendUvarint(append(b, 0xf0|v.typ), uint64(len(v.values)))
+		}
+		for _, item := range v.values {
+			b = appendThriftValue(b, item)
+		}
+		return b
+	}
+	panic("unsupported thrift value")
+}
+
+func thriftType(v any) byte {
+	switch v := v.(type) {
+	case int32:
+		return compactI32
+	case int64:
+		return compactI64
+	case bool:
+		if v {
+			return compactTrue
+		}
+		return compactFalse
+	case string:
+		return compactBinary
+	case []thriftField:
+		return compactStruct
+	case thriftValues:
+		return compactList
+	}
+	panic("unsupported thrift value")
+}
+
+// encodeThrift appends a struct in the thrift compact protocol; fields are
+// in ascending order of their IDs
+func encodeThrift(b []byte, fields []thriftField) []byte {
+	var last int16
+	for _, f := range fields {
+		b = append(b, byte(f.id-last)<<4|thriftType(f.value))
+		last = f.id
+		if _, ok := f.value.(bool); !ok {
+			b = appendThriftValue(b, f.value)
+		}
+	}
+	return append(b, compactStop)
+}
+
+// parquetColumn is a column written by parquetEncoder
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32 // -1 for none
+	optional  bool
+}
+
+// parquetEncoder writes records as a parquet file of gzip compressed
+// plain encoded columns, in row groups of parquetRowGroupSize records. The
+// label column is optional, null for unlabelled records.
+type parquetEncoder struct {
+	w       *countingWriter
+	columns []parquetColumn
+	records []Record
+	groups  []any
+	rows    int64
+}
+
+func newParquetEncoder(w *countingWriter, features []string) (*parquetEncoder, error) {
+	e := &parquetEncoder{w: w}
+	for _, name := range metadataColumns {
+		c := parquetColumn{name: name, typ: parquetByteArray, converted: parquetUTF8}
+		switch name {
+		case ColumnTimestamp:
+			c.typ, c.converted = parquetInt64, parquetTimestampMillis
+		case ColumnSrcPort, ColumnDstPort:
+			c.typ, c.converted = parquetInt32, -1
+		case ColumnPredictedBot:
+			c.typ, c.converted = parquetBoolean, -1
+		case ColumnConfidence:
+			c.typ, c.converted = parquetDouble, -1
+		}
+		e.columns = append(e.columns, c)
+	}
+	for _, name := range features {
+		e.columns = append(e.columns, parquetColumn{name: name, typ: parquetDouble, converted: -1})
+	}
+	e.columns = append(e.columns, parquetColumn{
+		name: DefaultLabelColumn, typ: parquetInt32, converted: -1, optional: true,
+	})
+
+	if _, err := io.WriteString(w, parquetMagic); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *parquetEncoder) encode(r *Record) error {
+	record := *r
+	record.Features = append([]float64(nil), r.Features...)
+	if r.Label != nil {
+		label := *r.Label
+		record.Label = &label
+	}
+	e.records = append(e.records, record)
+	if len(e.records) >= parquetRowGroupSize {
+		return e.writeRowGroup()
+	}
+	return nil
+}
+
+// values returns the plain encoding of the defined values of column i of
+// the buffered records, and their definition levels for optional columns
+func (e *parquetEncoder) values(i int) (values []byte, levels []int) {
+	c := e.columns[i]
+	features := len(e.columns) - len(metadataColumns) - 1
+	for n, r := range e.records {
+		switch {
+		case i >= len(metadataColumns) && i < len(metadataColumns)+features:
+			values = binary.LittleEndian.AppendUint64(values, math.Float64bits(r.Features[i-len(metadataColumns)]))
+		case c.name == DefaultLabelColumn:
+			if r.Label == nil {
+				levels = append(levels, 0)
+				continue
+			}
+			levels = append(levels, 1)
+			values = binary.LittleEndian.AppendUint32(values, uint32(*r.Label))
+		case c.name == ColumnTimestamp:
+			values = binary.LittleEndian.AppendUint64(values, uint64(r.Timestamp.UnixMilli()))
+		case c.name == ColumnSrcPort:
+			values = binary.LittleEndian.AppendUint32(values, uint32(r.SrcPort))
+		case c.name == ColumnDstPort:
+			values = binary.LittleEndian.AppendUint32(values, uint32(r.DstPort))
+		case c.name == ColumnPredictedBot:
+			if n%8 == 0 {
+				values = append(values, 0)
+			}
+			if r.PredictedBot {
+				values[n/8] |= 1 << (n % 8)
+			}
+		case c.name == ColumnConfidence:
+			values = binary.LittleEndian.AppendUint64(values, math.Float64bits(r.Confidence))
+		default:
+			var s string
+			switch c.name {
+			case ColumnFlowID:
+				s = r.FlowID
+			case ColumnSrcIP:
+				s = r.SrcIP
+			case ColumnDstIP:
+				s = r.DstIP
+			case ColumnProtocol:
+				s = r.Protocol
+			case ColumnModelVersion:
+				s = r.ModelVersion
+			case ColumnLabelSource:
+				s = r.LabelSource
+			}
+			values = binary.LittleEndian.AppendUint32(values, uint32(len(s)))
+			values = append(values, s...)
+		}
+	}
+	return values, levels
+}
+
+// appendBitPacked appends 1-bit values in the RLE/bit-packing hybrid
+// encoding as one bit-packed run
+func appendBitPacked(b []byte, bits []int) []byte {
+	groups := (len(bits) + 7) / 8
+	b = binary.AppendUvarint(b, uint64(groups)<<1|1)
+	start := len(b)
+	b = append(b, make([]byte, groups)...)
+	for i, bit := range bits {
+		b[start+i/8] |= byte(bit) << (i % 8)
+	}
+	return b
+}
+
+// writeRowGroup writes the buffered records as a row group
+func (e *parquetEncoder) writeRowGroup() error {
+	if len(e.records) == 0 {
+		return nil
+	}
+	n := int32(len(e.records))
+	var chunks []any
+	var groupSize int64
+	for i, c := range e.columns {
+		page, levels := e.values(i)
+		if c.optional {
+			encoded := appendBitPacked(nil, levels)
+			data := binary.LittleEndian.AppendUint32(nil, uint32(len(encoded)))
+			page = append(append(data, encoded...), page...)
+		}
+
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(page)
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		header := encodeThrift(nil, []thriftField{
+			{1, int32(parquetDataPage)}, {2, int32(len(page))}, {3, int32(compressed.Len())},
+			{5, []thriftField{{1, n}, {2, int32(parquetPlain)}, {3, int32(parquetRLE)}, {4, int32(parquetRLE)}}},
+		})
+
+		start := e.w.n
+		if _, err := e.w.Write(header); err != nil {
+			return err
+		}
+		if _, err := e.w.Write(compressed.Bytes()); err != nil {
+			return err
+		}
+		uncompressed := int64(len(header) + len(page))
+		groupSize += uncompressed
+		chunks = append(chunks, []thriftField{{2, start}, {3, []thriftField{
+			{1, c.typ},
+			{2, thriftValues{compactI32, []any{int32(parquetPlain), int32(parquetRLE)}}},
+			{3, thriftValues{compactBinary, []any{c.name}}},
+			{4, int32(parquetGzip)},
+			{5, int64(n)},
+			{6, uncompressed},
+			{7, e.w.n - start},
+			{9, start},
+		}}})
+	}
+
+	e.groups = append(e.groups, []thriftField{
+		{1, thriftValues{compactStruct, chunks}}, {2, groupSize}, {3, int64(n)},
+	})
+	e.rows += int64(n)
+	e.records = e.records[:0]
+	return nil
+}
+
+func (e *parquetEncoder) close() error {
+	if err := e.writeRowGroup(); err != nil {
+		return err
+	}
+
+	schema := []any{[]thriftField{{4, "schema"}, {5, int32(len(e.columns))}}}
+	for _, c := range e.columns {
+		repetition := int32(parquetRequired)
+		if c.optional {
+			repetition = parquetOptional
+		}
+		element := []thriftField{{1, c.typ}, {3, repetition}, {4, c.name}}
+		if c.converted >= 0 {
+			element = append(element, thriftField{6, c.converted})
+		}
+		schema = append(schema, element)
+	}
+	footer := encodeThrift(nil, []thriftField{
+		{1, int32(1)},
+		{2, thriftValues{compactStruct, schema}},
+		{3, e.rows},
+		{4, thriftValues{compactStruct, e.groups}},
+		{6, "argus-cortex"},
+	})
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	_, err := e.w.Write(append(footer, parquetMagic...))
+	return err
+}
@@ -0,0 +1,254 @@
+package dataset
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Flow metadata columns, written ahead of the features and skipped by Load
+// unless configured as feature columns
+const (
+	ColumnTimestamp    = "timestamp"
+	ColumnFlowID       = "flow_id"
+	ColumnSrcIP        = "src_ip"
+	ColumnDstIP        = "dst_ip"
+	ColumnSrcPort      = "src_port"
+	ColumnDstPort      = "dst_port"
+	ColumnProtocol     = "protocol"
+	ColumnPredictedBot = "predicted_bot"
+	ColumnConfidence   = "confidence"
+	ColumnModelVersion = "model_version"
+	ColumnLabelSource  = "label_source"
+)
+
+// metadataColumns lists the flow metadata columns in the order written
+var metadataColumns = []string{
+	ColumnTimestamp, ColumnFlowID, ColumnSrcIP, ColumnDstIP, ColumnSrcPort,
+	ColumnDstPort, ColumnProtocol, ColumnPredictedBot, ColumnConfidence, ColumnModelVersion,
+	ColumnLabelSource,
+}
+
+func isMetadataColumn(name string) bool {
+	for _, c := range metadataColumns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Record is a row of a dataset: the feature vector of a flow with the
+// flow's metadata and the prediction made for it
+type Record struct {
+	Timestamp    time.Time
+	FlowID       string
+	SrcIP        string
+	DstIP        string
+	SrcPort      int
+	DstPort      int
+	Protocol     string
+	PredictedBot bool
+	Confidence   float64
+	ModelVersion string
+	Features     []float64
+
+	// Label is 1 for bots and 0 for humans, nil when the flow is
+	// unlabelled. LabelSource tells where the label came from, e.g.
+	// "feedback".
+	Label       *int
+	LabelSource string
+}
+
+// recordEncoder writes records in one format
+type recordEncoder interface {
+	encode(r *Record) error
+	close() error
+}
+
+// Writer writes records to a dataset in CSV, JSON Lines or Parquet, with
+// the flow metadata columns, a column per named feature and the label
+// column last. Unlabelled records have an empty label, which Load skips.
+type Writer struct {
+	out      *countingWriter
+	features []string
+	encoder  recordEncoder
+	rows     int
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewWriter creates a writer of the format to w, with a column per name in
+// features. Closing the writer does not close w.
+func NewWriter(w io.Writer, format string, features []string) (*Writer, error) {
+	seen := make(map[string]bool, len(features))
+	for _, name := range features {
+		if name == "" || name == DefaultLabelColumn || isMetadataColumn(name) || seen[name] {
+			return nil, fmt.Errorf("invalid feature column name %q", name)
+		}
+		seen[name] = true
+	}
+
+	dw := &Writer{out: &countingWriter{w: w}, features: features}
+	var err error
+	switch format {
+	case FormatCSV:
+		dw.encoder, err = newCSVEncoder(dw.out, features)
+	case FormatJSONL:
+		dw.encoder = newJSONLEncoder(dw.out, features)
+	case FormatParquet:
+		dw.encoder, err = newParquetEncoder(dw.out, features)
+	default:
+		return nil, fmt.Errorf("unsupported dataset format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dw, nil
+}
+
+// Write appends a record
+func (w *Writer) Write(r *Record) error {
+	if len(r.Features) != len(w.features) {
+		return fmt.Errorf("record has %d features, expected %d", len(r.Features), len(w.features))
+	}
+	for i, v := range r.Features {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("feature %q has non-finite value %v", w.features[i], v)
+		}
+	}
+	if r.Label != nil && *r.Label != 0 && *r.Label != 1 {
+		return fmt.Errorf("invalid label %d", *r.Label)
+	}
+	if err := w.encoder.encode(r); err != nil {
+		return err
+	}
+	w.rows++
+	return nil
+}
+
+// Rows returns the number of records written
+func (w *Writer) Rows() int {
+	return w.rows
+}
+
+// Size returns the number of bytes written to the underlying writer so far
+func (w *Writer) Size() int64 {
+	return w.out.n
+}
+
+// Close writes the buffered records and completes the file
+func (w *Writer) Close() error {
+	return w.encoder.close()
+}
+
+// formatFloat formats a value as the shortest exact decimal
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// csvEncoder writes records as CSV with a header row
+type csvEncoder struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVEncoder(w io.Writer, features []string) (*csvEncoder, error) {
+	e := &csvEncoder{w: csv.NewWriter(w)}
+	header := append(append(append([]string(nil), metadataColumns...), features...), DefaultLabelColumn)
+	if err := e.w.Write(header); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *csvEncoder) encode(r *Record) error {
+	e.record = append(e.record[:0],
+		r.Timestamp.UTC().Format(time.RFC3339Nano), r.FlowID, r.SrcIP, r.DstIP,
+		strconv.Itoa(r.SrcPort), strconv.Itoa(r.DstPort), r.Protocol,
+		strconv.FormatBool(r.PredictedBot), formatFloat(r.Confidence), r.ModelVersion, r.LabelSource)
+	for _, v := range r.Features {
+		e.record = append(e.record, formatFloat(v))
+	}
+	label := ""
+	if r.Label != nil {
+		label = strconv.Itoa(*r.Label)
+	}
+	return e.w.Write(append(e.record, label))
+}
+
+func (e *csvEncoder) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonlEncoder writes records as JSON Lines of flat objects
+type jsonlEncoder struct {
+	w        *bufio.Writer
+	features [][]byte // JSON encoded feature names
+	buf      []byte
+}
+
+func newJSONLEncoder(w io.Writer, features []string) *jsonlEncoder {
+	e := &jsonlEncoder{w: bufio.NewWriter(w)}
+	for _, name := range features {
+		key, _ := json.Marshal(name)
+		e.features = append(e.features, key)
+	}
+	return e
+}
+
+// appendString appends a key and its string value
+func appendString(b []byte, key, value string) []byte {
+	v, _ := json.Marshal(value)
+	b = append(b, '"')
+	b = append(b, key...)
+	b = append(b, '"', ':')
+	return append(b, v...)
+}
+
+func (e *jsonlEncoder) encode(r *Record) error {
+	b := append(e.buf[:0], '{')
+	b = appendString(b, ColumnTimestamp, r.Timestamp.UTC().Format(time.RFC3339Nano))
+	b = appendString(append(b, ','), ColumnFlowID, r.FlowID)
+	b = appendString(append(b, ','), ColumnSrcIP, r.SrcIP)
+	b = appendString(append(b, ','), ColumnDstIP, r.DstIP)
+	b = fmt.Appendf(b, `,"%s":%d,"%s":%d`, ColumnSrcPort, r.SrcPort, ColumnDstPort, r.DstPort)
+	b = appendString(append(b, ','), ColumnProtocol, r.Protocol)
+	b = fmt.Appendf(b, `,"%s":%t,"%s":%s`, ColumnPredictedBot, r.PredictedBot, ColumnConfidence, formatFloat(r.Confidence))
+	b = appendString(append(b, ','), ColumnModelVersion, r.ModelVersion)
+	b = appendString(append(b, ','), ColumnLabelSource, r.LabelSource)
+	for i, v := range r.Features {
+		b = append(append(append(b, ','), e.features[i]...), ':')
+		b = strconv.AppendFloat(b, v, 'g', -1, 64)
+	}
+	b = append(b, `,"`+DefaultLabelColumn+`":`...)
+	if r.Label != nil {
+		b = strconv.AppendInt(b, int64(*r.Label), 10)
+	} else {
+		b = append(b, "null"...)
+	}
+	b = append(b, '}', '\n')
+	e.buf = b
+	_, err := e.w.Write(b)
+	return err
+}
+
+func (e *jsonlEncoder) close() error {
+	return e.w.Flush()
+}
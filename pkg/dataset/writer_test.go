@@ -0,0 +1,165 @@
+package dataset
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRecords(features int) []*Record {
+	bot, human := 1, 0
+	var records []*Record
+	for i := 0; i < 5; i++ {
+		r := &Record{
+			Timestamp:    time.Date(2024, 3, 1, 12, 0, i, 0, time.UTC),
+			FlowID:       fmt.Sprintf("flow-%d", i),
+			SrcIP:        "10.0.0.1",
+			DstIP:        "192.0.2.7",
+			SrcPort:      40000 + i,
+			DstPort:      443,
+			Protocol:     "TLS",
+			PredictedBot: i%2 == 0,
+			Confidence:   0.75,
+			ModelVersion: "v3",
+		}
+		for j := 0; j < features; j++ {
+			r.Features = append(r.Features, float64(i)+float64(j)/10)
+		}
+		switch i {
+		case 0, 3:
+			r.Label = &bot
+		case 1:
+			r.Label = &human
+		}
+		if r.Label != nil {
+			r.LabelSource = "feedback"
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func featureNames(n int) []string {
+	var names []string
+	for i := 0; i < n; i++ {
+		names = append(names, fmt.Sprintf("f%d", i))
+	}
+	return names
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	// More columns than fit a short thrift list header
+	const features = 20
+	for _, format := range []string{FormatCSV, FormatJSONL, FormatParquet} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, format, featureNames(features))
+			require.NoError(t, err)
+			for _, r := range testRecords(features) {
+				require.NoError(t, w.Write(r))
+			}
+			require.NoError(t, w.Close())
+			assert.Equal(t, 5, w.Rows())
+			assert.Equal(t, int64(buf.Len()), w.Size())
+
+			// Unlabelled rows and the metadata columns are skipped
+			ds, err := Load(writeFile(t, "collected."+format, buf.Bytes()), Options{FeatureSize: features})
+			require.NoError(t, err)
+			assert.Equal(t, []int{1, 0, 1}, ds.Labels)
+			require.Len(t, ds.Features, 3)
+			assert.Equal(t, 0.0, ds.Features[0][0])
+			assert.Equal(t, 1.5, ds.Features[1][5])
+			assert.Equal(t, 4.9, ds.Features[2][19])
+
+			// Metadata columns load as configured features
+			ds, err = Load(writeFile(t, "collected."+format, buf.Bytes()), Options{
+				FeatureColumns: []string{ColumnDstPort, ColumnPredictedBot, ColumnConfidence},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []float64{443, 0, 0.75}, ds.Features[1])
+
+			// Records read back whole, unlabelled ones included
+			records, names, err := LoadRecords(writeFile(t, "collected."+format, buf.Bytes()), Options{})
+			require.NoError(t, err)
+			assert.Equal(t, featureNames(features), names)
+			require.Len(t, records, 5)
+			for i, r := range testRecords(features) {
+				assert.Equal(t, *r, records[i])
+			}
+		})
+	}
+}
+
+func TestWriterParquetRowGroups(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatParquet, []string{"x"})
+	require.NoError(t, err)
+	label := 1
+	for i := 0; i < parquetRowGroupSize+10; i++ {
+		r := &Record{Features: []float64{float64(i)}}
+		if i%3 == 0 {
+			r.Label = &label
+		}
+		require.NoError(t, w.Write(r))
+	}
+	require.NoError(t, w.Close())
+
+	columns, err := readParquet(buf.Bytes())
+	require.NoError(t, err)
+	x := columns[len(metadataColumns)]
+	assert.Equal(t, parquetRowGroupSize+10, x.len())
+	assert.Equal(t, float64(parquetRowGroupSize+9), x.numbers[parquetRowGroupSize+9])
+
+	labels := columns[len(columns)-1]
+	assert.False(t, labels.null(parquetRowGroupSize+8))
+	assert.True(t, labels.null(parquetRowGroupSize+9))
+}
+
+func TestWriterErrors(t *testing.T) {
+	for _, names := range [][]string{{""}, {"x", "x"}, {"label"}, {"src_ip"}} {
+		_, err := NewWriter(&bytes.Buffer{}, FormatCSV, names)
+		assert.Error(t, err, "%v", names)
+	}
+	_, err := NewWriter(&bytes.Buffer{}, "xlsx", nil)
+	assert.ErrorContains(t, err, "unsupported dataset format")
+
+	w, err := NewWriter(&bytes.Buffer{}, FormatCSV, []string{"x"})
+	require.NoError(t, err)
+	assert.ErrorContains(t, w.Write(&Record{}), "0 features, expected 1")
+	assert.ErrorContains(t, w.Write(&Record{Features: []float64{math.NaN()}}), "non-finite")
+	label := 2
+	assert.ErrorContains(t, w.Write(&Record{Features: []float64{1}, Label: &label}), "invalid label 2")
+	assert.Zero(t, w.Rows())
+}
+
+func TestLoadJSONL(t *testing.T) {
+	data := strings.Join([]string{
+		`{"duration":0.5,"packets":3,"label":"bot"}`,
+		`{"packets":40,"duration":1.5,"label":null}`,
+		`{"duration":2.5,"packets":7,"label":false,"note":"x"}`,
+	}, "\n")
+	ds, err := Load(writeFile(t, "train.jsonl", []byte(data)), Options{FeatureColumns: []string{"duration", "packets"}})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{0.5, 3}, {2.5, 7}}, ds.Features)
+	assert.Equal(t, []int{1, 0}, ds.Labels)
+
+	// A key missing from earlier rows is a missing value
+	_, err = Load(writeFile(t, "train.jsonl", []byte(data)), Options{})
+	assert.ErrorContains(t, err, `row 1, column "note": missing value`)
+
+	for name, data := range map[string]string{
+		"not an object": `[1, 2]`,
+		"duplicate key": `{"x":1,"x":2,"label":1}`,
+		"nested":        `{"x":{"y":1},"label":1}`,
+		"unlabelled":    `{"x":1,"label":null}`,
+	} {
+		_, err := Load(writeFile(t, "train.jsonl", []byte(data)), Options{})
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,365 @@
+package enforce
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxOffenders bounds the sources whose recent offenses are kept
+	maxOffenders = 65536
+	// expireInterval is how often expired blocks are lifted
+	expireInterval = 10 * time.Second
+)
+
+// Audit actions
+const (
+	ActionBlock   = "block"
+	ActionUnblock = "unblock" // lifted through the API
+	ActionExpire  = "expire"
+)
+
+// Policy decides which sources are blocked: those with MinOffenses bot
+// verdicts of at least MinConfidence within Window, for Duration
+type Policy struct {
+	MinConfidence float64
+	MinOffenses   int
+	Window        time.Duration
+	Duration      time.Duration
+}
+
+// Block is a source address whose traffic is dropped
+type Block struct {
+	IP         string    `json:"ip"`
+	Reason     string    `json:"reason"`     // of the verdict that tipped the policy
+	Offenses   int       `json:"offenses"`   // verdicts within the policy window
+	Confidence float64   `json:"confidence"` // highest of the verdicts
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires"`
+	DryRun     bool      `json:"dry_run"` // only logged, the traffic still flows
+}
+
+// AuditEntry is a line of the audit log
+type AuditEntry struct {
+	Time     time.Time  `json:"time"`
+	Action   string     `json:"action"` // block, unblock or expire
+	IP       string     `json:"ip"`
+	Reason   string     `json:"reason,omitempty"`
+	Offenses int        `json:"offenses,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	Backend  string     `json:"backend"`
+	DryRun   bool       `json:"dry_run"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Enforcer blocks the sources of repeated bot verdicts in the firewall for
+// a limited time. In dry-run mode it decides and audits blocks the same
+// way without touching the firewall. Blocks are lifted when they expire,
+// through Unblock, and when the enforcer is closed.
+//
+// Blocks are decided under mu, but the firewall commands run without it,
+// so that a slow firewall holds up neither verdicts nor the API.
+type Enforcer struct {
+	policy   Policy
+	firewall Firewall
+	dryRun   bool
+	audit    *os.File // nil when auditing to the log only
+
+	mu         sync.Mutex
+	offenses   map[string][]offense
+	blocks     map[string]*Block
+	changing   map[string]bool // sources with a firewall change in flight
+	maxSources int
+}
+
+// offense is a bot verdict counted against a source
+type offense struct {
+	at         time.Time
+	confidence float64
+}
+
+// New creates an enforcer, setting up the firewall unless dryRun. Audit
+// entries are appended to the JSON lines file at auditPath, or only
+// logged when it is empty.
+func New(ctx context.Context, policy Policy, firewall Firewall, dryRun bool, auditPath string) (*Enforcer, error) {
+	if policy.MinOffenses < 1 || policy.Window <= 0 || policy.Duration <= 0 {
+		return nil, fmt.Errorf("enforcement policy needs at least one offense, a window and a block duration")
+	}
+
+	e := &Enforcer{
+		policy:     policy,
+		firewall:   firewall,
+		dryRun:     dryRun,
+		offenses:   make(map[string][]offense),
+		blocks:     make(map[string]*Block),
+		changing:   make(map[string]bool),
+		maxSources: maxOffenders,
+	}
+	if auditPath != "" {
+		audit, err := os.OpenFile(auditPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open enforcement audit log: %w", err)
+		}
+		e.audit = audit
+	}
+	if !dryRun {
+		if err := firewall.Setup(ctx); err != nil {
+			e.closeAudit()
+			return nil, fmt.Errorf("failed to set up %s: %w", firewall.Name(), err)
+		}
+	}
+	return e, nil
+}
+
+// Observe counts a bot verdict of the given confidence against ip and
+// blocks it once the policy is met, returning the new block. Sources
+// already blocked, or being blocked, are not counted.
+func (e *Enforcer) Observe(ctx context.Context, ip net.IP, confidence float64, reason string, at time.Time) *Block {
+	if ip == nil || confidence < e.policy.MinConfidence {
+		return nil
+	}
+	block := e.decide(ip.String(), confidence, reason, at)
+	if block == nil {
+		return nil
+	}
+
+	var err error
+	if !e.dryRun {
+		err = e.firewall.Block(ctx, ip, e.policy.Duration)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.changing, block.IP)
+	e.record(ActionBlock, block, at, err)
+	if err != nil {
+		return nil
+	}
+	e.blocks[block.IP] = block
+	return block
+}
+
+// decide counts a verdict against a source, returning the block to add to
+// the firewall once the policy is met. The source is marked as changing
+// until the block is added.
+func (e *Enforcer) decide(key string, confidence float64, reason string, at time.Time) *Block {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.blocks[key]; ok || e.changing[key] {
+		return nil
+	}
+	recent := e.recent(key, at)
+	if recent == nil && len(e.offenses) >= e.maxSources {
+		e.prune(at)
+		if len(e.offenses) >= e.maxSources {
+			// Too many offending sources to count this one
+			return nil
+		}
+	}
+	recent = append(recent, offense{at, confidence})
+	if len(recent) < e.policy.MinOffenses {
+		e.offenses[key] = recent
+		return nil
+	}
+	delete(e.offenses, key)
+
+	block := &Block{
+		IP:       key,
+		Reason:   reason,
+		Offenses: len(recent),
+		Created:  at,
+		Expires:  at.Add(e.policy.Duration),
+		DryRun:   e.dryRun,
+	}
+	for _, o := range recent {
+		block.Confidence = max(block.Confidence, o.confidence)
+	}
+	e.changing[key] = true
+	return block
+}
+
+// recent returns the offenses of a source within the window before now.
+// The caller holds e.mu.
+func (e *Enforcer) recent(key string, now time.Time) []offense {
+	offenses := e.offenses[key]
+	for len(offenses) > 0 && now.Sub(offenses[0].at) >= e.policy.Window {
+		offenses = offenses[1:]
+	}
+	return offenses
+}
+
+// prune forgets the sources without offenses within the window. The
+// caller holds e.mu.
+func (e *Enforcer) prune(now time.Time) {
+	for key := range e.offenses {
+		if len(e.recent(key, now)) == 0 {
+			delete(e.offenses, key)
+		}
+	}
+}
+
+// Blocks returns the active blocks, the earliest expiring first
+func (e *Enforcer) Blocks() []Block {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	blocks := make([]Block, 0, len(e.blocks))
+	for _, block := range e.blocks {
+		blocks = append(blocks, *block)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		if !blocks[i].Expires.Equal(blocks[j].Expires) {
+			return blocks[i].Expires.Before(blocks[j].Expires)
+		}
+		return blocks[i].IP < blocks[j].IP
+	})
+	return blocks
+}
+
+// Unblock lifts the block of ip ahead of its expiry, reporting whether it
+// was blocked. Blocks already being lifted are reported without waiting.
+func (e *Enforcer) Unblock(ctx context.Context, ip net.IP) (bool, error) {
+	key := ip.String()
+
+	e.mu.Lock()
+	block, ok := e.blocks[key]
+	if !ok || e.changing[key] {
+		e.mu.Unlock()
+		return ok, nil
+	}
+	e.changing[key] = true
+	e.mu.Unlock()
+
+	if errs := e.lift(ctx, []*Block{block}, ActionUnblock, time.Now()); len(errs) > 0 {
+		return true, errs[0]
+	}
+	return true, nil
+}
+
+// Run lifts blocks as they expire until ctx is cancelled
+func (e *Enforcer) Run(ctx context.Context) {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.expire(ctx, now)
+		}
+	}
+}
+
+// expire lifts the blocks expired at now
+func (e *Enforcer) expire(ctx context.Context, now time.Time) {
+	e.lift(ctx, e.claim(func(block *Block) bool { return !now.Before(block.Expires) }), ActionExpire, now)
+}
+
+// claim marks the blocks selected by keep, and not already changing, as
+// changing and returns them
+func (e *Enforcer) claim(keep func(block *Block) bool) []*Block {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var blocks []*Block
+	for key, block := range e.blocks {
+		if !e.changing[key] && keep(block) {
+			e.changing[key] = true
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// lift removes claimed blocks from the firewall and forgets them,
+// returning the errors of those the firewall failed to remove. These are
+// kept, to be retried.
+func (e *Enforcer) lift(ctx context.Context, blocks []*Block, action string, now time.Time) []error {
+	errs := make([]error, len(blocks))
+	for i, block := range blocks {
+		if !block.DryRun {
+			errs[i] = e.firewall.Unblock(ctx, net.ParseIP(block.IP))
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var failed []error
+	for i, block := range blocks {
+		delete(e.changing, block.IP)
+		e.record(action, block, now, errs[i])
+		if errs[i] != nil {
+			failed = append(failed, errs[i])
+			continue
+		}
+		delete(e.blocks, block.IP)
+	}
+	return failed
+}
+
+// Close lifts every active block and closes the audit log
+func (e *Enforcer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	errs := e.lift(ctx, e.claim(func(*Block) bool { return true }), ActionExpire, time.Now())
+
+	e.mu.Lock()
+	e.closeAudit()
+	e.mu.Unlock()
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to lift %d blocks: %w", len(errs), errs[0])
+	}
+	return nil
+}
+
+// record writes an action on a block to the log and the audit log. The
+// caller holds e.mu.
+func (e *Enforcer) record(action string, block *Block, at time.Time, err error) {
+	entry := AuditEntry{
+		Time:    at,
+		Action:  action,
+		IP:      block.IP,
+		Backend: e.firewall.Name(),
+		DryRun:  block.DryRun,
+	}
+	if action == ActionBlock {
+		expires := block.Expires
+		entry.Reason, entry.Offenses, entry.Expires = block.Reason, block.Offenses, &expires
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+		slog.Error("Failed to enforce block", "action", action, "ip", block.IP, "backend", entry.Backend, "error", err)
+	} else {
+		slog.Warn("Enforcement "+action, "ip", block.IP, "backend", entry.Backend, "dry_run", block.DryRun,
+			"offenses", entry.Offenses, "expires", block.Expires)
+	}
+
+	if e.audit == nil {
+		return
+	}
+	line, _ := json.Marshal(entry)
+	if _, err := e.audit.Write(append(line, '\n')); err != nil {
+		slog.Error("Failed to write enforcement audit log", "error", err)
+	}
+}
+
+// closeAudit closes the audit log
+func (e *Enforcer) closeAudit() {
+	if e.audit != nil {
+		e.audit.Close()
+		e.audit = nil
+	}
+}
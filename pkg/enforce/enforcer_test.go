@@ -0,0 +1,204 @@
+package enforce
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPolicy = Policy{MinConfidence: 0.9, MinOffenses: 3, Window: 10 * time.Minute, Duration: time.Hour}
+
+// readAudit returns the entries of an audit log
+func readAudit(t *testing.T, path string) []AuditEntry {
+	t.Helper()
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestEnforcerPolicy(t *testing.T) {
+	log := &commandLog{}
+	audit := filepath.Join(t.TempDir(), "audit.jsonl")
+	ctx := context.Background()
+	enforcer, err := New(ctx, testPolicy, &nftables{run: log.run}, false, audit)
+	require.NoError(t, err)
+
+	src := net.ParseIP("203.0.113.7")
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Verdicts below the confidence or spread beyond the window do not add up
+	assert.Nil(t, enforcer.Observe(ctx, src, 0.95, "bot", start))
+	assert.Nil(t, enforcer.Observe(ctx, src, 0.5, "bot", start.Add(time.Minute)))
+	assert.Nil(t, enforcer.Observe(ctx, src, 0.95, "bot", start.Add(2*time.Minute)))
+	assert.Nil(t, enforcer.Observe(ctx, src, 0.97, "bot", start.Add(11*time.Minute)))
+	assert.Empty(t, enforcer.Blocks())
+
+	block := enforcer.Observe(ctx, src, 0.92, "scraper", start.Add(11*time.Minute+30*time.Second))
+	require.NotNil(t, block)
+	assert.Equal(t, Block{
+		IP:         "203.0.113.7",
+		Reason:     "scraper",
+		Offenses:   3,
+		Confidence: 0.97,
+		Created:    start.Add(11*time.Minute + 30*time.Second),
+		Expires:    start.Add(71*time.Minute + 30*time.Second),
+	}, *block)
+	assert.Equal(t, []Block{*block}, enforcer.Blocks())
+	assert.Equal(t, "nft add element inet argus blocked4 { 203.0.113.7 timeout 3600s }", log.commands[len(log.commands)-1])
+
+	// Blocked sources are not counted again
+	assert.Nil(t, enforcer.Observe(ctx, src, 1, "bot", start.Add(13*time.Minute)))
+
+	// Blocks are lifted once they expire
+	enforcer.expire(ctx, start.Add(71*time.Minute))
+	assert.Len(t, enforcer.Blocks(), 1)
+	enforcer.expire(ctx, start.Add(72*time.Minute))
+	assert.Empty(t, enforcer.Blocks())
+	assert.Equal(t, "nft delete element inet argus blocked4 { 203.0.113.7 }", log.commands[len(log.commands)-1])
+
+	require.NoError(t, enforcer.Close())
+	entries := readAudit(t, audit)
+	require.Len(t, entries, 2)
+	assert.Equal(t, ActionBlock, entries[0].Action)
+	assert.Equal(t, "scraper", entries[0].Reason)
+	assert.Equal(t, 3, entries[0].Offenses)
+	assert.Equal(t, BackendNFTables, entries[0].Backend)
+	require.NotNil(t, entries[0].Expires)
+	assert.Equal(t, ActionExpire, entries[1].Action)
+	assert.Nil(t, entries[1].Expires)
+}
+
+func TestEnforcerDryRun(t *testing.T) {
+	log := &commandLog{}
+	audit := filepath.Join(t.TempDir(), "audit.jsonl")
+	ctx := context.Background()
+	policy := testPolicy
+	policy.MinOffenses = 1
+	enforcer, err := New(ctx, policy, &iptables{run: log.run}, true, audit)
+	require.NoError(t, err)
+
+	block := enforcer.Observe(ctx, net.ParseIP("203.0.113.7"), 1, "bot", time.Now())
+	require.NotNil(t, block)
+	assert.True(t, block.DryRun)
+
+	unblocked, err := enforcer.Unblock(ctx, net.ParseIP("203.0.113.7"))
+	require.NoError(t, err)
+	assert.True(t, unblocked)
+	unblocked, err = enforcer.Unblock(ctx, net.ParseIP("203.0.113.7"))
+	require.NoError(t, err)
+	assert.False(t, unblocked)
+
+	// The firewall is never touched
+	require.NoError(t, enforcer.Close())
+	assert.Empty(t, log.commands)
+	entries := readAudit(t, audit)
+	require.Len(t, entries, 2)
+	assert.True(t, entries[0].DryRun)
+	assert.Equal(t, ActionUnblock, entries[1].Action)
+}
+
+func TestEnforcerFirewallErrors(t *testing.T) {
+	log := &commandLog{}
+	ctx := context.Background()
+	policy := testPolicy
+	policy.MinOffenses = 1
+
+	log.fail = func(string) error { return errors.New("nft: command not found") }
+	_, err := New(ctx, policy, &nftables{run: log.run}, false, "")
+	assert.ErrorContains(t, err, "failed to set up nftables")
+
+	log.fail = nil
+	enforcer, err := New(ctx, policy, &iptables{run: log.run}, false, "")
+	require.NoError(t, err)
+
+	// Failed blocks are not kept, and blocks failing to lift are retried
+	log.fail = func(command string) error {
+		if strings.Contains(command, "-A") {
+			return errors.New("iptables: Permission denied")
+		}
+		return nil
+	}
+	assert.Nil(t, enforcer.Observe(ctx, net.ParseIP("203.0.113.7"), 1, "bot", time.Now()))
+	assert.Empty(t, enforcer.Blocks())
+
+	log.fail = func(command string) error {
+		if strings.Contains(command, "-D") {
+			return errors.New("iptables: Resource temporarily unavailable")
+		}
+		return nil
+	}
+	require.NotNil(t, enforcer.Observe(ctx, net.ParseIP("203.0.113.7"), 1, "bot", time.Now()))
+	_, err = enforcer.Unblock(ctx, net.ParseIP("203.0.113.7"))
+	assert.Error(t, err)
+	assert.Len(t, enforcer.Blocks(), 1)
+	assert.Error(t, enforcer.Close())
+
+	log.fail = nil
+	assert.NoError(t, enforcer.Close())
+	assert.Empty(t, enforcer.Blocks())
+
+	_, err = New(ctx, Policy{MinOffenses: 1}, &iptables{run: log.run}, true, "")
+	assert.Error(t, err)
+}
+
+// slowFirewall holds Block until released
+type slowFirewall struct {
+	iptables
+	blocking chan struct{}
+	release  chan struct{}
+}
+
+func (f *slowFirewall) Block(context.Context, net.IP, time.Duration) error {
+	f.blocking <- struct{}{}
+	<-f.release
+	return nil
+}
+
+func TestEnforcerFirewallUnlocked(t *testing.T) {
+	ctx := context.Background()
+	policy := testPolicy
+	policy.MinOffenses = 1
+	firewall := &slowFirewall{
+		iptables: iptables{run: (&commandLog{}).run},
+		blocking: make(chan struct{}),
+		release:  make(chan struct{}),
+	}
+	enforcer, err := New(ctx, policy, firewall, false, "")
+	require.NoError(t, err)
+
+	src := net.ParseIP("203.0.113.7")
+	done := make(chan *Block)
+	go func() { done <- enforcer.Observe(ctx, src, 1, "bot", time.Now()) }()
+	<-firewall.blocking
+
+	// The enforcer answers while the firewall is busy, without counting
+	// the source being blocked again
+	assert.Empty(t, enforcer.Blocks())
+	assert.Nil(t, enforcer.Observe(ctx, src, 1, "bot", time.Now()))
+	unblocked, err := enforcer.Unblock(ctx, src)
+	require.NoError(t, err)
+	assert.False(t, unblocked)
+
+	close(firewall.release)
+	require.NotNil(t, <-done)
+	assert.Len(t, enforcer.Blocks(), 1)
+}
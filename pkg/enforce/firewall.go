@@ -0,0 +1,183 @@
+package enforce
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Firewall backends
+const (
+	BackendNFTables = "nftables"
+	BackendIPTables = "iptables"
+)
+
+const (
+	// nftTable is the table holding the nftables block sets and chains
+	nftTable = "argus"
+	// iptablesChain is the chain holding the iptables block rules
+	iptablesChain = "ARGUS-BLOCK"
+)
+
+// Firewall drops the traffic of blocked source addresses
+type Firewall interface {
+	// Name identifies the backend in logs and the audit log
+	Name() string
+	// Setup creates the rules blocked addresses are dropped by, removing
+	// any blocks left from an earlier run
+	Setup(ctx context.Context) error
+	// Block drops the traffic of ip for ttl
+	Block(ctx context.Context, ip net.IP, ttl time.Duration) error
+	// Unblock stops dropping the traffic of ip
+	Unblock(ctx context.Context, ip net.IP) error
+}
+
+// runner runs a command, feeding it stdin when not empty
+type runner func(ctx context.Context, stdin string, name string, args ...string) error
+
+// runCommand runs a command, returning its output with the error when it
+// fails
+func runCommand(ctx context.Context, stdin string, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// NewFirewall creates the firewall of a backend
+func NewFirewall(backend string) (Firewall, error) {
+	switch backend {
+	case BackendNFTables, "":
+		return &nftables{run: runCommand}, nil
+	case BackendIPTables:
+		return &iptables{run: runCommand}, nil
+	}
+	return nil, fmt.Errorf("unknown firewall backend %q", backend)
+}
+
+// nftables blocks addresses by adding them to sets with timeouts, which
+// the kernel expires by itself should the blocks outlive the process
+type nftables struct {
+	run runner
+}
+
+// nftRuleset creates the argus table, dropping sources in its sets on
+// input and forward. The table is added and deleted first, so that it is
+// created afresh whether it existed or not.
+const nftRuleset = `add table inet argus
+delete table inet argus
+table inet argus {
+	set blocked4 {
+		type ipv4_addr
+		flags timeout
+	}
+	set blocked6 {
+		type ipv6_addr
+		flags timeout
+	}
+	chain input {
+		type filter hook input priority filter - 10; policy accept;
+		ip saddr @blocked4 drop
+		ip6 saddr @blocked6 drop
+	}
+	chain forward {
+		type filter hook forward priority filter - 10; policy accept;
+		ip saddr @blocked4 drop
+		ip6 saddr @blocked6 drop
+	}
+}
+`
+
+// Name implements Firewall
+func (n *nftables) Name() string { return BackendNFTables }
+
+// Setup implements Firewall
+func (n *nftables) Setup(ctx context.Context) error {
+	return n.run(ctx, nftRuleset, "nft", "-f", "-")
+}
+
+// Block implements Firewall. Set timeouts are whole seconds, so shorter
+// blocks last one second.
+func (n *nftables) Block(ctx context.Context, ip net.IP, ttl time.Duration) error {
+	seconds := max(int(ttl.Round(time.Second)/time.Second), 1)
+	element := fmt.Sprintf("{ %s timeout %ds }", ip, seconds)
+	return n.run(ctx, "", "nft", "add", "element", "inet", nftTable, nftSet(ip), element)
+}
+
+// Unblock implements Firewall. Addresses the kernel expired already are
+// not an error.
+func (n *nftables) Unblock(ctx context.Context, ip net.IP) error {
+	err := n.run(ctx, "", "nft", "delete", "element", "inet", nftTable, nftSet(ip), fmt.Sprintf("{ %s }", ip))
+	if err != nil && strings.Contains(err.Error(), "No such file or directory") {
+		return nil
+	}
+	return err
+}
+
+// nftSet returns the set of an address's family
+func nftSet(ip net.IP) string {
+	if ip.To4() != nil {
+		return "blocked4"
+	}
+	return "blocked6"
+}
+
+// iptables blocks addresses with a DROP rule each in a chain jumped to
+// from INPUT and FORWARD, using ip6tables for IPv6. The rules do not
+// expire by themselves: they are removed when blocks expire, and left ones
+// are flushed by the next Setup.
+type iptables struct {
+	run runner
+}
+
+// Name implements Firewall
+func (t *iptables) Name() string { return BackendIPTables }
+
+// Setup implements Firewall
+func (t *iptables) Setup(ctx context.Context) error {
+	for _, command := range []string{"iptables", "ip6tables"} {
+		// The chain may exist from an earlier run
+		_ = t.run(ctx, "", command, "-N", iptablesChain)
+		if err := t.run(ctx, "", command, "-F", iptablesChain); err != nil {
+			return err
+		}
+		for _, chain := range []string{"INPUT", "FORWARD"} {
+			if t.run(ctx, "", command, "-C", chain, "-j", iptablesChain) == nil {
+				continue
+			}
+			if err := t.run(ctx, "", command, "-I", chain, "-j", iptablesChain); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Block implements Firewall
+func (t *iptables) Block(ctx context.Context, ip net.IP, _ time.Duration) error {
+	return t.run(ctx, "", iptablesCommand(ip), "-A", iptablesChain, "-s", ip.String(), "-j", "DROP")
+}
+
+// Unblock implements Firewall
+func (t *iptables) Unblock(ctx context.Context, ip net.IP) error {
+	return t.run(ctx, "", iptablesCommand(ip), "-D", iptablesChain, "-s", ip.String(), "-j", "DROP")
+}
+
+// iptablesCommand returns the command managing rules of an address's
+// family
+func iptablesCommand(ip net.IP) string {
+	if ip.To4() != nil {
+		return "iptables"
+	}
+	return "ip6tables"
+}
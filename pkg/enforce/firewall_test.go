@@ -0,0 +1,95 @@
+package enforce
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commandLog records the commands a firewall runs, failing those it is
+// told to
+type commandLog struct {
+	commands []string
+	stdin    []string
+	fail     func(command string) error
+}
+
+func (l *commandLog) run(_ context.Context, stdin string, name string, args ...string) error {
+	command := name + " " + strings.Join(args, " ")
+	l.commands = append(l.commands, command)
+	if stdin != "" {
+		l.stdin = append(l.stdin, stdin)
+	}
+	if l.fail != nil {
+		return l.fail(command)
+	}
+	return nil
+}
+
+func TestNFTables(t *testing.T) {
+	log := &commandLog{}
+	firewall := &nftables{run: log.run}
+	ctx := context.Background()
+
+	require.NoError(t, firewall.Setup(ctx))
+	require.NoError(t, firewall.Block(ctx, net.ParseIP("203.0.113.7"), time.Hour))
+	require.NoError(t, firewall.Block(ctx, net.ParseIP("2001:db8::7"), 90*time.Second))
+	require.NoError(t, firewall.Block(ctx, net.ParseIP("203.0.113.8"), 300*time.Millisecond))
+	require.NoError(t, firewall.Unblock(ctx, net.ParseIP("203.0.113.7")))
+	assert.Equal(t, []string{
+		"nft -f -",
+		"nft add element inet argus blocked4 { 203.0.113.7 timeout 3600s }",
+		"nft add element inet argus blocked6 { 2001:db8::7 timeout 90s }",
+		"nft add element inet argus blocked4 { 203.0.113.8 timeout 1s }",
+		"nft delete element inet argus blocked4 { 203.0.113.7 }",
+	}, log.commands)
+	require.Len(t, log.stdin, 1)
+	assert.Contains(t, log.stdin[0], "ip saddr @blocked4 drop")
+
+	// Elements the kernel expired already are gone, as asked
+	log.fail = func(string) error {
+		return errors.New("Error: Could not process rule: No such file or directory")
+	}
+	assert.NoError(t, firewall.Unblock(ctx, net.ParseIP("203.0.113.7")))
+	log.fail = func(string) error { return errors.New("Error: Operation not permitted") }
+	assert.Error(t, firewall.Unblock(ctx, net.ParseIP("203.0.113.7")))
+}
+
+func TestIPTables(t *testing.T) {
+	// The chain exists and is jumped to from INPUT but not FORWARD
+	log := &commandLog{fail: func(command string) error {
+		if strings.HasSuffix(command, " -N ARGUS-BLOCK") || strings.Contains(command, "-C FORWARD") {
+			return errors.New("exists or missing")
+		}
+		return nil
+	}}
+	firewall := &iptables{run: log.run}
+	ctx := context.Background()
+
+	require.NoError(t, firewall.Setup(ctx))
+	require.NoError(t, firewall.Block(ctx, net.ParseIP("203.0.113.7"), time.Hour))
+	require.NoError(t, firewall.Unblock(ctx, net.ParseIP("2001:db8::7")))
+	assert.Equal(t, []string{
+		"iptables -N ARGUS-BLOCK",
+		"iptables -F ARGUS-BLOCK",
+		"iptables -C INPUT -j ARGUS-BLOCK",
+		"iptables -C FORWARD -j ARGUS-BLOCK",
+		"iptables -I FORWARD -j ARGUS-BLOCK",
+		"ip6tables -N ARGUS-BLOCK",
+		"ip6tables -F ARGUS-BLOCK",
+		"ip6tables -C INPUT -j ARGUS-BLOCK",
+		"ip6tables -C FORWARD -j ARGUS-BLOCK",
+		"ip6tables -I FORWARD -j ARGUS-BLOCK",
+		"iptables -A ARGUS-BLOCK -s 203.0.113.7 -j DROP",
+		"ip6tables -D ARGUS-BLOCK -s 2001:db8::7 -j DROP",
+	}, log.commands)
+
+	_, err := NewFirewall("pf")
+	assert.ErrorContains(t, err, "unknown firewall backend")
+}
@@ -0,0 +1,97 @@
+package enrich
+
+import (
+	"fmt"
+	"net"
+)
+
+// GeoInfo describes the location and network of an IP address
+type GeoInfo struct {
+	Country string `json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	City    string `json:"city,omitempty"`
+	ASN     uint32 `json:"asn,omitempty"`
+	ASOrg   string `json:"as_org,omitempty"`
+}
+
+// GeoIP resolves IP addresses against MaxMind GeoLite2/GeoIP2 City and ASN
+// databases. Either database may be omitted.
+type GeoIP struct {
+	city *mmdbReader
+	asn  *mmdbReader
+}
+
+// NewGeoIP opens the City and ASN databases at the given paths. An empty
+// path skips that database.
+func NewGeoIP(cityPath, asnPath string) (*GeoIP, error) {
+	g := &GeoIP{}
+
+	if cityPath != "" {
+		city, err := openMMDB(cityPath)
+		if err != nil {
+			return nil, err
+		}
+		g.city = city
+	}
+
+	if asnPath != "" {
+		asn, err := openMMDB(asnPath)
+		if err != nil {
+			return nil, err
+		}
+		g.asn = asn
+	}
+
+	return g, nil
+}
+
+// Lookup returns what the databases know about ip, or nil if neither has a
+// record for it
+func (g *GeoIP) Lookup(ip net.IP) (*GeoInfo, error) {
+	info := &GeoInfo{}
+	found := false
+
+	if g.city != nil {
+		record, err := g.city.lookup(ip)
+		if err != nil {
+			return nil, fmt.Errorf("city lookup for %v failed: %w", ip, err)
+		}
+		if record != nil {
+			found = true
+			info.Country, _ = field(record, "country", "iso_code").(string)
+			if info.Country == "" {
+				// Anycast and satellite ranges only carry a registered country
+				info.Country, _ = field(record, "registered_country", "iso_code").(string)
+			}
+			info.City, _ = field(record, "city", "names", "en").(string)
+		}
+	}
+
+	if g.asn != nil {
+		record, err := g.asn.lookup(ip)
+		if err != nil {
+			return nil, fmt.Errorf("ASN lookup for %v failed: %w", ip, err)
+		}
+		if record != nil {
+			found = true
+			info.ASN = uint32(mmdbUint(field(record, "autonomous_system_number")))
+			info.ASOrg, _ = field(record, "autonomous_system_organization").(string)
+		}
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return info, nil
+}
+
+// field walks nested maps of a decoded record along path
+func field(record interface{}, path ...string) interface{} {
+	for _, key := range path {
+		m, ok := record.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		record = m[key]
+	}
+	return record
+}
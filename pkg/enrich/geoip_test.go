@@ -0,0 +1,204 @@
+package enrich
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mmdbNetwork is a record to place in a test database
+type mmdbNetwork struct {
+	cidr   string
+	record map[string]interface{}
+}
+
+// buildMMDB writes a MaxMind DB holding the given networks
+func buildMMDB(t *testing.T, ipVersion, recordSize int, databaseType string, networks []mmdbNetwork) string {
+	t.Helper()
+
+	const empty = -1
+	nodes := [][2]int{{empty, empty}}
+	var data []byte
+	type leaf struct{ node, bit, offset int }
+	var leaves []leaf
+
+	for _, n := range networks {
+		_, ipNet, err := net.ParseCIDR(n.cidr)
+		require.NoError(t, err)
+
+		addr := ipNet.IP.To4()
+		ones, _ := ipNet.Mask.Size()
+		if ipVersion == 6 {
+			if addr != nil {
+				addr = append(make(net.IP, 12), addr...)
+				ones += 96
+			} else {
+				addr = ipNet.IP.To16()
+			}
+		}
+
+		offset := len(data)
+		data = appendMMDBValue(data, n.record)
+
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := int(addr[i/8]>>(7-i%8)) & 1
+			if i == ones-1 {
+				leaves = append(leaves, leaf{node, bit, offset})
+				break
+			}
+			if nodes[node][bit] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	for i := range nodes {
+		for bit := range nodes[i] {
+			if nodes[i][bit] == empty {
+				nodes[i][bit] = nodeCount
+			}
+		}
+	}
+	for _, l := range leaves {
+		nodes[l.node][l.bit] = nodeCount + dataSectionSeparator + l.offset
+	}
+
+	var buf []byte
+	for _, n := range nodes {
+		left, right := uint32(n[0]), uint32(n[1])
+		switch recordSize {
+		case 24:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left))
+			buf = append(buf, byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left))
+			buf = append(buf, byte(left>>24)<<4|byte(right>>24))
+			buf = append(buf, byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			buf = binary.BigEndian.AppendUint32(buf, left)
+			buf = binary.BigEndian.AppendUint32(buf, right)
+		}
+	}
+	buf = append(buf, make([]byte, dataSectionSeparator)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataMarker...)
+	buf = appendMMDBValue(buf, map[string]interface{}{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+		"ip_version":                  uint16(ipVersion),
+		"database_type":               databaseType,
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+	})
+
+	path := filepath.Join(t.TempDir(), databaseType+".mmdb")
+	require.NoError(t, os.WriteFile(path, buf, 0o644))
+	return path
+}
+
+// appendMMDBValue encodes a value in the MaxMind DB data section format
+func appendMMDBValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		if len(v) < 29 {
+			b = append(b, mmdbString<<5|byte(len(v)))
+		} else {
+			b = append(b, mmdbString<<5|29, byte(len(v)-29))
+		}
+		return append(b, v...)
+	case uint16:
+		b = append(b, mmdbUint16<<5|2)
+		return binary.BigEndian.AppendUint16(b, v)
+	case uint32:
+		b = append(b, mmdbUint32<<5|4)
+		return binary.BigEndian.AppendUint32(b, v)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b = append(b, mmdbMap<<5|byte(len(v)))
+		for _, k := range keys {
+			b = appendMMDBValue(b, k)
+			b = appendMMDBValue(b, v[k])
+		}
+		return b
+	}
+	panic("unsupported test value")
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	for _, recordSize := range []int{24, 28, 32} {
+		cityDB := buildMMDB(t, 6, recordSize, "GeoLite2-City", []mmdbNetwork{
+			{"81.2.69.0/24", map[string]interface{}{
+				"country": map[string]interface{}{"iso_code": "GB"},
+				"city":    map[string]interface{}{"names": map[string]interface{}{"en": "London"}},
+			}},
+			{"2001:db8::/32", map[string]interface{}{
+				"registered_country": map[string]interface{}{"iso_code": "SE"},
+			}},
+		})
+		asnDB := buildMMDB(t, 4, recordSize, "GeoLite2-ASN", []mmdbNetwork{
+			{"81.2.64.0/19", map[string]interface{}{
+				"autonomous_system_number":       uint32(20712),
+				"autonomous_system_organization": "Andrews & Arnold Ltd",
+			}},
+		})
+
+		geo, err := NewGeoIP(cityDB, asnDB)
+		require.NoError(t, err)
+
+		info, err := geo.Lookup(net.ParseIP("81.2.69.160"))
+		require.NoError(t, err)
+		assert.Equal(t, &GeoInfo{Country: "GB", City: "London", ASN: 20712, ASOrg: "Andrews & Arnold Ltd"}, info)
+
+		// Only the ASN database covers the rest of the /19
+		info, err = geo.Lookup(net.ParseIP("81.2.80.1"))
+		require.NoError(t, err)
+		assert.Equal(t, &GeoInfo{ASN: 20712, ASOrg: "Andrews & Arnold Ltd"}, info)
+
+		info, err = geo.Lookup(net.ParseIP("2001:db8::1"))
+		require.NoError(t, err)
+		assert.Equal(t, &GeoInfo{Country: "SE"}, info)
+
+		info, err = geo.Lookup(net.ParseIP("8.8.8.8"))
+		require.NoError(t, err)
+		assert.Nil(t, info)
+	}
+}
+
+func TestGeoIPInvalidDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bogus.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte("not a database"), 0o644))
+
+	_, err := NewGeoIP(path, "")
+	assert.Error(t, err)
+
+	_, err = NewGeoIP("", filepath.Join(t.TempDir(), "missing.mmdb"))
+	assert.Error(t, err)
+}
+
+func TestMMDBDecodePointer(t *testing.T) {
+	// A map whose value points back at the string at offset 0
+	data := appendMMDBValue(nil, "shared")
+	data = append(data, mmdbMap<<5|1)
+	data = appendMMDBValue(data, "key")
+	data = append(data, mmdbPointer<<5, 0)
+
+	value, next, err := (&mmdbDecoder{data: data}).decode(7)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"key": "shared"}, value)
+	assert.Equal(t, uint(len(data)), next)
+}
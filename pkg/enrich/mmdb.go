@@ -0,0 +1,350 @@
+package enrich
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// metadataMarker precedes the metadata map at the end of a MaxMind DB file
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// dataSectionSeparator is the number of zero bytes between the search tree
+// and the data section
+const dataSectionSeparator = 16
+
+// Data section field types, see the MaxMind DB format specification
+const (
+	mmdbExtended = 0
+	mmdbPointer  = 1
+	mmdbString   = 2
+	mmdbDouble   = 3
+	mmdbBytes    = 4
+	mmdbUint16   = 5
+	mmdbUint32   = 6
+	mmdbMap      = 7
+	mmdbInt32    = 8
+	mmdbUint64   = 9
+	mmdbUint128  = 10
+	mmdbArray    = 11
+	mmdbBool     = 14
+	mmdbFloat    = 15
+)
+
+// mmdbReader looks up records in a MaxMind DB file such as the GeoLite2
+// City and ASN databases. The whole file is held in memory.
+type mmdbReader struct {
+	buf          []byte
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	databaseType string
+	data         []byte
+	ipv4Start    uint
+}
+
+// openMMDB reads and validates a MaxMind DB file
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MaxMind DB %s: %w", path, err)
+	}
+
+	r, err := newMMDBReader(buf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind DB %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// newMMDBReader parses the metadata of an in-memory MaxMind DB
+func newMMDBReader(buf []byte) (*mmdbReader, error) {
+	idx := bytes.LastIndex(buf, metadataMarker)
+	if idx < 0 {
+		return nil, fmt.Errorf("metadata marker not found")
+	}
+
+	metaSection := buf[idx+len(metadataMarker):]
+	meta, _, err := (&mmdbDecoder{data: metaSection}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	fields, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("metadata is not a map")
+	}
+
+	r := &mmdbReader{buf: buf}
+	r.nodeCount = uint(mmdbUint(fields["node_count"]))
+	r.recordSize = uint(mmdbUint(fields["record_size"]))
+	r.ipVersion = uint(mmdbUint(fields["ip_version"]))
+	r.databaseType, _ = fields["database_type"].(string)
+
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", r.ipVersion)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(idx) {
+		return nil, fmt.Errorf("search tree exceeds file size")
+	}
+	r.data = buf[treeSize+dataSectionSeparator : idx]
+
+	// IPv4 addresses live under ::/96 in IPv6 databases
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.readNode(r.ipv4Start, 0)
+		}
+	}
+
+	return r, nil
+}
+
+// lookup returns the record for ip, or nil if the database has none
+func (r *mmdbReader) lookup(ip net.IP) (interface{}, error) {
+	addr := ip.To4()
+	node := r.ipv4Start
+	if addr == nil {
+		if r.ipVersion == 4 {
+			return nil, nil
+		}
+		addr = ip.To16()
+		node = 0
+	}
+	if addr == nil {
+		return nil, fmt.Errorf("invalid IP address %v", ip)
+	}
+
+	for i := 0; i < len(addr)*8 && node < r.nodeCount; i++ {
+		bit := uint(addr[i/8]>>(7-i%8)) & 1
+		node = r.readNode(node, bit)
+	}
+
+	switch {
+	case node == r.nodeCount:
+		return nil, nil
+	case node < r.nodeCount:
+		return nil, fmt.Errorf("search tree is deeper than the address")
+	}
+
+	offset := node - r.nodeCount - dataSectionSeparator
+	if offset >= uint(len(r.data)) {
+		return nil, fmt.Errorf("record offset %d outside data section", offset)
+	}
+	value, _, err := (&mmdbDecoder{data: r.data}).decode(offset)
+	return value, err
+}
+
+// readNode returns the left (bit 0) or right (bit 1) record of a node
+func (r *mmdbReader) readNode(node, bit uint) uint {
+	b := r.buf[node*r.recordSize/4:]
+
+	switch r.recordSize {
+	case 24:
+		off := bit * 3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// mmdbDecoder decodes values from a MaxMind DB data section
+type mmdbDecoder struct {
+	data []byte
+}
+
+// decode decodes the value at offset and returns it with the offset of the
+// next value
+func (d *mmdbDecoder) decode(offset uint) (interface{}, uint, error) {
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == mmdbPointer {
+		target, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(target)
+		return value, next, err
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key is not a string")
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.data)) {
+		return nil, 0, fmt.Errorf("value at offset %d exceeds data section", offset)
+	}
+	b := d.data[offset : offset+size]
+	next := offset + size
+
+	switch typ {
+	case mmdbString:
+		return string(b), next, nil
+	case mmdbBytes:
+		return append([]byte(nil), b...), next, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbUint128:
+		if size > 8 {
+			// Only used for IPv6 network fields we never read
+			return append([]byte(nil), b...), next, nil
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, next, nil
+	case mmdbInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("invalid int32 size %d", size)
+		}
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		if size == 4 {
+			return int64(int32(v)), next, nil
+		}
+		return int64(v), next, nil
+	}
+
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
+
+// control decodes a control byte and any extended type and size bytes
+func (d *mmdbDecoder) control(offset uint) (typ, size, next uint, err error) {
+	if offset >= uint(len(d.data)) {
+		return 0, 0, 0, fmt.Errorf("offset %d outside data section", offset)
+	}
+	ctrl := d.data[offset]
+	offset++
+
+	typ = uint(ctrl >> 5)
+	if typ == mmdbPointer {
+		return typ, uint(ctrl & 0x1F), offset, nil
+	}
+	if typ == mmdbExtended {
+		if offset >= uint(len(d.data)) {
+			return 0, 0, 0, fmt.Errorf("truncated extended type")
+		}
+		typ = 7 + uint(d.data[offset])
+		offset++
+	}
+
+	size = uint(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.data)) {
+			return 0, 0, 0, fmt.Errorf("truncated size")
+		}
+		var ext uint
+		for _, c := range d.data[offset : offset+n] {
+			ext = ext<<8 | uint(c)
+		}
+		switch size {
+		case 29:
+			size = 29 + ext
+		case 30:
+			size = 285 + ext
+		default:
+			size = 65821 + ext
+		}
+		offset += n
+	}
+
+	return typ, size, offset, nil
+}
+
+// pointer resolves a pointer whose control byte carried bits and returns
+// the target offset and the offset following the pointer
+func (d *mmdbDecoder) pointer(bits, offset uint) (uint, uint, error) {
+	n := (bits>>3)&0x3 + 1
+	if offset+n > uint(len(d.data)) {
+		return 0, 0, fmt.Errorf("truncated pointer")
+	}
+
+	var target uint
+	if n < 4 {
+		target = bits & 0x7
+	}
+	for _, c := range d.data[offset : offset+n] {
+		target = target<<8 | uint(c)
+	}
+
+	switch n {
+	case 2:
+		target += 2048
+	case 3:
+		target += 526336
+	}
+
+	return target, offset + n, nil
+}
+
+// mmdbUint converts a decoded unsigned value to uint64
+func mmdbUint(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int64:
+		if n > 0 {
+			return uint64(n)
+		}
+	}
+	return 0
+}
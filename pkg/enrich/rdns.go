@@ -0,0 +1,211 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rdnsWorkers is the number of lookups that may be in flight at once
+	rdnsWorkers = 4
+	// rdnsQueueSize bounds the addresses waiting for a lookup; requests
+	// beyond it are dropped rather than blocking the packet path
+	rdnsQueueSize = 1024
+	// rdnsTimeout bounds a single PTR query
+	rdnsTimeout = 2 * time.Second
+)
+
+// ReverseDNS resolves PTR names for IP addresses in the background. Answers,
+// including the absence of a PTR record, are cached for the configured TTL
+// and lookups are spaced out to stay under the configured rate.
+type ReverseDNS struct {
+	lookup   func(ctx context.Context, addr string) ([]string, error)
+	ttl      time.Duration
+	interval time.Duration
+	queue    chan string
+
+	mu      sync.Mutex
+	cache   map[string]rdnsEntry
+	pending map[string][]func(name string)
+	dropped int64
+}
+
+// rdnsEntry is a cached PTR answer; an empty name records that there is none
+type rdnsEntry struct {
+	name    string
+	expires time.Time
+}
+
+// NewReverseDNS creates a resolver caching answers for ttl and issuing at
+// most rateLimit queries per second. Call Run to start resolving.
+func NewReverseDNS(ttl time.Duration, rateLimit int) *ReverseDNS {
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	if rateLimit < 1 {
+		rateLimit = 1
+	}
+
+	return &ReverseDNS{
+		lookup:   net.DefaultResolver.LookupAddr,
+		ttl:      ttl,
+		interval: time.Second / time.Duration(rateLimit),
+		queue:    make(chan string, rdnsQueueSize),
+		cache:    make(map[string]rdnsEntry),
+		pending:  make(map[string][]func(name string)),
+	}
+}
+
+// Resolve calls fn with the PTR name of ip once it is known. Cached names
+// are delivered immediately; otherwise the lookup is queued and fn runs on
+// a resolver goroutine. fn is not called when ip has no PTR record or the
+// lookup fails.
+func (r *ReverseDNS) Resolve(ip net.IP, fn func(name string)) {
+	key := ip.String()
+
+	r.mu.Lock()
+	if entry, ok := r.cache[key]; ok && time.Now().Before(entry.expires) {
+		r.mu.Unlock()
+		if entry.name != "" {
+			fn(entry.name)
+		}
+		return
+	}
+
+	// Piggyback on a lookup that is already queued
+	if waiters, ok := r.pending[key]; ok {
+		r.pending[key] = append(waiters, fn)
+		r.mu.Unlock()
+		return
+	}
+
+	select {
+	case r.queue <- key:
+		r.pending[key] = []func(name string){fn}
+	default:
+		r.dropped++
+	}
+	r.mu.Unlock()
+}
+
+// Cached returns the cached PTR name of ip without triggering a lookup
+func (r *ReverseDNS) Cached(ip net.IP) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.cache[ip.String()]
+	if !ok || time.Now().After(entry.expires) {
+		return "", false
+	}
+	return entry.name, true
+}
+
+// Dropped returns the number of lookups skipped because the queue was full
+func (r *ReverseDNS) Dropped() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dropped
+}
+
+// Run resolves queued addresses until ctx is cancelled
+func (r *ReverseDNS) Run(ctx context.Context) {
+	// Workers take turns on a shared ticker, which drops ticks nobody is
+	// waiting for, so an idle period does not allow a burst afterwards
+	limiter := time.NewTicker(r.interval)
+	defer limiter.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < rdnsWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.worker(ctx, limiter.C)
+		}()
+	}
+
+	sweep := time.NewTicker(r.ttl)
+	defer sweep.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case now := <-sweep.C:
+			r.expire(now)
+		}
+	}
+}
+
+// worker performs queued lookups, one per limiter tick
+func (r *ReverseDNS) worker(ctx context.Context, limiter <-chan time.Time) {
+	for {
+		var key string
+		select {
+		case <-ctx.Done():
+			return
+		case key = <-r.queue:
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-limiter:
+		}
+
+		r.resolve(ctx, key)
+	}
+}
+
+// resolve looks up a single address and hands the answer to its waiters
+func (r *ReverseDNS) resolve(ctx context.Context, key string) {
+	ctx, cancel := context.WithTimeout(ctx, rdnsTimeout)
+	defer cancel()
+
+	names, err := r.lookup(ctx, key)
+
+	var name string
+	cache := true
+	if err != nil {
+		var dnsErr *net.DNSError
+		// Only a definitive "no PTR record" is worth remembering
+		cache = errors.As(err, &dnsErr) && dnsErr.IsNotFound
+		if !cache {
+			slog.Debug("Reverse DNS lookup failed", "ip", key, "error", err)
+		}
+	} else if len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+
+	r.mu.Lock()
+	waiters := r.pending[key]
+	delete(r.pending, key)
+	if cache {
+		r.cache[key] = rdnsEntry{name: name, expires: time.Now().Add(r.ttl)}
+	}
+	r.mu.Unlock()
+
+	if name == "" {
+		return
+	}
+	for _, fn := range waiters {
+		fn(name)
+	}
+}
+
+// expire drops cache entries that are past their TTL
+func (r *ReverseDNS) expire(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, entry := range r.cache {
+		if now.After(entry.expires) {
+			delete(r.cache, key)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package enrich
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseDNSResolve(t *testing.T) {
+	var lookups atomic.Int32
+	r := NewReverseDNS(time.Hour, 1000)
+	r.lookup = func(ctx context.Context, addr string) ([]string, error) {
+		lookups.Add(1)
+		switch addr {
+		case "192.0.2.10":
+			return []string{"host-10.example.net."}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	var mu sync.Mutex
+	var names []string
+	record := func(name string) {
+		mu.Lock()
+		names = append(names, name)
+		mu.Unlock()
+	}
+
+	// Concurrent requests for the same address share one lookup
+	r.Resolve(net.ParseIP("192.0.2.10"), record)
+	r.Resolve(net.ParseIP("192.0.2.10"), record)
+	r.Resolve(net.ParseIP("192.0.2.99"), record)
+
+	require.Eventually(t, func() bool {
+		_, hit := r.Cached(net.ParseIP("192.0.2.99"))
+		mu.Lock()
+		defer mu.Unlock()
+		return len(names) == 2 && hit
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"host-10.example.net", "host-10.example.net"}, names)
+
+	// Later requests are answered from the cache, including the negative one
+	r.Resolve(net.ParseIP("192.0.2.10"), record)
+	r.Resolve(net.ParseIP("192.0.2.99"), record)
+	assert.Len(t, names, 3)
+	assert.Equal(t, int32(2), lookups.Load())
+}
+
+func TestReverseDNSTransientFailureNotCached(t *testing.T) {
+	var lookups atomic.Int32
+	r := NewReverseDNS(time.Hour, 1000)
+	r.lookup = func(ctx context.Context, addr string) ([]string, error) {
+		lookups.Add(1)
+		return nil, &net.DNSError{Err: "i/o timeout", Name: addr, IsTimeout: true}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	r.Resolve(net.ParseIP("192.0.2.1"), func(string) {})
+	require.Eventually(t, func() bool { return lookups.Load() == 1 }, 2*time.Second, 5*time.Millisecond)
+
+	r.Resolve(net.ParseIP("192.0.2.1"), func(string) {})
+	require.Eventually(t, func() bool { return lookups.Load() == 2 }, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestReverseDNSRateLimit(t *testing.T) {
+	var lookups atomic.Int32
+	r := NewReverseDNS(time.Hour, 20) // one lookup every 50ms
+	r.lookup = func(ctx context.Context, addr string) ([]string, error) {
+		lookups.Add(1)
+		return []string{"host.example.net"}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	for i := 0; i < 10; i++ {
+		r.Resolve(net.IPv4(192, 0, 2, byte(i)), func(string) {})
+	}
+
+	time.Sleep(175 * time.Millisecond)
+	assert.LessOrEqual(t, lookups.Load(), int32(4))
+	assert.Eventually(t, func() bool { return lookups.Load() == 10 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestReverseDNSExpire(t *testing.T) {
+	r := NewReverseDNS(time.Minute, 10)
+	r.cache["192.0.2.1"] = rdnsEntry{name: "old.example.net", expires: time.Now().Add(-time.Second)}
+	r.cache["192.0.2.2"] = rdnsEntry{name: "new.example.net", expires: time.Now().Add(time.Minute)}
+
+	_, hit := r.Cached(net.ParseIP("192.0.2.1"))
+	assert.False(t, hit)
+
+	r.expire(time.Now())
+	assert.Len(t, r.cache, 1)
+	name, hit := r.Cached(net.ParseIP("192.0.2.2"))
+	assert.True(t, hit)
+	assert.Equal(t, "new.example.net", name)
+}
@@ -0,0 +1,79 @@
+package events
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// MaxEnvelopeSize bounds a single encoded envelope to guard against corrupt streams
+const MaxEnvelopeSize = 4 * 1024 * 1024
+
+// Encoder writes length-delimited envelopes to a stream
+type Encoder struct {
+	w   io.Writer
+	buf []byte
+	mu  sync.Mutex
+}
+
+// NewEncoder creates an encoder writing to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes a single envelope prefixed with its varint-encoded length
+func (e *Encoder) Encode(env *Envelope) error {
+	data, err := env.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to marshal envelope: %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.buf = binary.AppendUvarint(e.buf[:0], uint64(len(data)))
+	e.buf = append(e.buf, data...)
+	if _, err := e.w.Write(e.buf); err != nil {
+		return fmt.Errorf("failed to write envelope: %w", err)
+	}
+
+	return nil
+}
+
+// Decoder reads length-delimited envelopes from a stream
+type Decoder struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+// NewDecoder creates a decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next envelope. It returns io.EOF at a clean end of stream.
+func (d *Decoder) Decode(env *Envelope) error {
+	size, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return err
+	}
+	if size > MaxEnvelopeSize {
+		return fmt.Errorf("envelope too large: %d bytes", size)
+	}
+
+	if cap(d.buf) < int(size) {
+		d.buf = make([]byte, size)
+	}
+	d.buf = d.buf[:size]
+	if _, err := io.ReadFull(d.r, d.buf); err != nil {
+		return fmt.Errorf("failed to read envelope: %w", err)
+	}
+
+	if err := env.Unmarshal(d.buf); err != nil {
+		return fmt.Errorf("failed to unmarshal envelope: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package events
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events/eventsv1"
+)
+
+// FlowRecord describes a tracked network flow
+type FlowRecord struct {
+	ID        string    `json:"id"`
+	SrcIP     net.IP    `json:"src_ip"`
+	DstIP     net.IP    `json:"dst_ip"`
+	SrcPort   uint16    `json:"src_port"`
+	DstPort   uint16    `json:"dst_port"`
+	Protocol  string    `json:"protocol"`
+	Packets   uint64    `json:"packets"`
+	Bytes     uint64    `json:"bytes"`
+	StartTime time.Time `json:"start_time"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// FeatureVector carries the features extracted from a flow
+type FeatureVector struct {
+	FlowID    string
+	Values    []float64
+	Timestamp time.Time
+}
+
+// Detection is the outcome of analyzing a flow
+type Detection struct {
+	FlowID     string
+	IsBot      bool
+	Confidence float64
+	Reasoning  string
+	ModelUsed  string
+	Timestamp  time.Time
+	Features   []float64
+
+	// ModelVersion is the registry ID of the model version that produced
+	// the verdict
+	ModelVersion string
+
+	// Detections rolled up into this one by an aggregating sink, and the
+	// distinct flows among them, with when the first and last were seen;
+	// zero for single detections
+	AlertCount uint64
+	AlertFlows uint64
+	FirstSeen  time.Time
+	LastSeen   time.Time
+}
+
+// Envelope wraps a single event for transport. Exactly one of Flow,
+// Features or Detection is set.
+type Envelope struct {
+	SensorID  string
+	Sequence  uint64
+	Flow      *FlowRecord
+	Features  *FeatureVector
+	Detection *Detection
+}
+
+// Marshal encodes the envelope in protobuf wire format
+func (e *Envelope) Marshal() ([]byte, error) {
+	msg := &eventsv1.Envelope{SensorId: validUTF8(e.SensorID), Sequence: e.Sequence}
+	switch {
+	case e.Flow != nil:
+		msg.Event = &eventsv1.Envelope_Flow{Flow: e.Flow.Proto()}
+	case e.Features != nil:
+		msg.Event = &eventsv1.Envelope_Features{Features: e.Features.proto()}
+	case e.Detection != nil:
+		msg.Event = &eventsv1.Envelope_Detection{Detection: e.Detection.Proto()}
+	default:
+		return nil, fmt.Errorf("envelope has no event")
+	}
+	return proto.Marshal(msg)
+}
+
+// Unmarshal decodes an envelope from protobuf wire format
+func (e *Envelope) Unmarshal(b []byte) error {
+	*e = Envelope{}
+	var msg eventsv1.Envelope
+	if err := proto.Unmarshal(b, &msg); err != nil {
+		return err
+	}
+
+	e.SensorID, e.Sequence = msg.GetSensorId(), msg.GetSequence()
+	switch event := msg.GetEvent().(type) {
+	case *eventsv1.Envelope_Flow:
+		e.Flow = FlowRecordFromProto(event.Flow)
+	case *eventsv1.Envelope_Features:
+		e.Features = featureVectorFromProto(event.Features)
+	case *eventsv1.Envelope_Detection:
+		e.Detection = DetectionFromProto(event.Detection)
+	}
+	return nil
+}
+
+// Marshal encodes the flow record in protobuf wire format
+func (f *FlowRecord) Marshal() []byte {
+	return marshal(f.Proto())
+}
+
+// Unmarshal decodes a flow record from protobuf wire format
+func (f *FlowRecord) Unmarshal(b []byte) error {
+	var msg eventsv1.FlowRecord
+	if err := proto.Unmarshal(b, &msg); err != nil {
+		*f = FlowRecord{}
+		return err
+	}
+	*f = *FlowRecordFromProto(&msg)
+	return nil
+}
+
+// Proto returns the generated message of the flow record
+func (f *FlowRecord) Proto() *eventsv1.FlowRecord {
+	return &eventsv1.FlowRecord{
+		Id:                validUTF8(f.ID),
+		SrcIp:             ipBytes(f.SrcIP),
+		DstIp:             ipBytes(f.DstIP),
+		SrcPort:           uint32(f.SrcPort),
+		DstPort:           uint32(f.DstPort),
+		Protocol:          validUTF8(f.Protocol),
+		Packets:           f.Packets,
+		Bytes:             f.Bytes,
+		StartTimeUnixNano: timeNano(f.StartTime),
+		LastSeenUnixNano:  timeNano(f.LastSeen),
+	}
+}
+
+// FlowRecordFromProto converts a generated flow record message
+func FlowRecordFromProto(msg *eventsv1.FlowRecord) *FlowRecord {
+	return &FlowRecord{
+		ID:        msg.GetId(),
+		SrcIP:     ipFromBytes(msg.GetSrcIp()),
+		DstIP:     ipFromBytes(msg.GetDstIp()),
+		SrcPort:   uint16(msg.GetSrcPort()),
+		DstPort:   uint16(msg.GetDstPort()),
+		Protocol:  msg.GetProtocol(),
+		Packets:   msg.GetPackets(),
+		Bytes:     msg.GetBytes(),
+		StartTime: unixNano(msg.GetStartTimeUnixNano()),
+		LastSeen:  unixNano(msg.GetLastSeenUnixNano()),
+	}
+}
+
+func (f *FeatureVector) proto() *eventsv1.FeatureVector {
+	return &eventsv1.FeatureVector{
+		FlowId:            validUTF8(f.FlowID),
+		Values:            f.Values,
+		TimestampUnixNano: timeNano(f.Timestamp),
+	}
+}
+
+func featureVectorFromProto(msg *eventsv1.FeatureVector) *FeatureVector {
+	return &FeatureVector{
+		FlowID:    msg.GetFlowId(),
+		Values:    msg.GetValues(),
+		Timestamp: unixNano(msg.GetTimestampUnixNano()),
+	}
+}
+
+// Marshal encodes the detection in protobuf wire format
+func (d *Detection) Marshal() []byte {
+	return marshal(d.Proto())
+}
+
+// Unmarshal decodes a detection from protobuf wire format
+func (d *Detection) Unmarshal(b []byte) error {
+	var msg eventsv1.Detection
+	if err := proto.Unmarshal(b, &msg); err != nil {
+		*d = Detection{}
+		return err
+	}
+	*d = *DetectionFromProto(&msg)
+	return nil
+}
+
+// Proto returns the generated message of the detection
+func (d *Detection) Proto() *eventsv1.Detection {
+	return &eventsv1.Detection{
+		FlowId:            validUTF8(d.FlowID),
+		IsBot:             d.IsBot,
+		Confidence:        d.Confidence,
+		Reasoning:         validUTF8(d.Reasoning),
+		ModelUsed:         validUTF8(d.ModelUsed),
+		TimestampUnixNano: timeNano(d.Timestamp),
+		Features:          d.Features,
+		ModelVersion:      validUTF8(d.ModelVersion),
+		AlertCount:        d.AlertCount,
+		AlertFlows:        d.AlertFlows,
+		FirstSeenUnixNano: timeNano(d.FirstSeen),
+		LastSeenUnixNano:  timeNano(d.LastSeen),
+	}
+}
+
+// DetectionFromProto converts a generated detection message
+func DetectionFromProto(msg *eventsv1.Detection) *Detection {
+	return &Detection{
+		FlowID:       msg.GetFlowId(),
+		IsBot:        msg.GetIsBot(),
+		Confidence:   msg.GetConfidence(),
+		Reasoning:    msg.GetReasoning(),
+		ModelUsed:    msg.GetModelUsed(),
+		Timestamp:    unixNano(msg.GetTimestampUnixNano()),
+		Features:     msg.GetFeatures(),
+		ModelVersion: msg.GetModelVersion(),
+		AlertCount:   msg.GetAlertCount(),
+		AlertFlows:   msg.GetAlertFlows(),
+		FirstSeen:    unixNano(msg.GetFirstSeenUnixNano()),
+		LastSeen:     unixNano(msg.GetLastSeenUnixNano()),
+	}
+}
+
+// marshal encodes a message whose strings are valid UTF-8, which is all
+// proto.Marshal can fail on
+func marshal(msg proto.Message) []byte {
+	b, _ := proto.Marshal(msg)
+	return b
+}
+
+// validUTF8 replaces the invalid UTF-8 proto3 strings may not carry, which
+// names and reasons taken from captured packets can hold
+func validUTF8(s string) string {
+	return strings.ToValidUTF8(s, "\uFFFD")
+}
+
+// ipBytes returns the 4-byte form for IPv4 addresses and 16 bytes otherwise
+func ipBytes(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// ipFromBytes copies an address out of a decoded message
+func ipFromBytes(b []byte) net.IP {
+	if len(b) == 0 {
+		return nil
+	}
+	return append(net.IP(nil), b...)
+}
+
+// timeNano encodes a time as Unix nanoseconds, the zero time as 0 so that
+// it is left out
+func timeNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// unixNano decodes a time encoded by timeNano
+func unixNano(v int64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, v)
+}
@@ -0,0 +1,105 @@
+package events
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	now := time.Unix(0, time.Now().UnixNano())
+
+	envelopes := []*Envelope{
+		{
+			SensorID: "sensor-1",
+			Sequence: 1,
+			Flow: &FlowRecord{
+				ID:        "192.168.1.100:54321-8.8.8.8:443",
+				SrcIP:     net.ParseIP("192.168.1.100").To4(),
+				DstIP:     net.ParseIP("2001:db8::1"),
+				SrcPort:   54321,
+				DstPort:   443,
+				Protocol:  "TCP",
+				Packets:   15,
+				Bytes:     18000,
+				StartTime: now.Add(-time.Minute),
+				LastSeen:  now,
+			},
+		},
+		{
+			SensorID: "sensor-1",
+			Sequence: 2,
+			Features: &FeatureVector{
+				FlowID:    "flow-1",
+				Values:    []float64{0.1, 0, -2.5, 1400},
+				Timestamp: now,
+			},
+		},
+		{
+			SensorID: "sensor-1",
+			Sequence: 3,
+			Detection: &Detection{
+				FlowID:       "flow-1",
+				IsBot:        true,
+				Confidence:   0.93,
+				Reasoning:    "regular timing",
+				ModelUsed:    "ensemble",
+				ModelVersion: "3f2a9c0d41b7",
+				Timestamp:    now,
+				Features:     []float64{1, 2, 3},
+				AlertCount:   4,
+				AlertFlows:   3,
+				FirstSeen:    now.Add(-time.Minute),
+				LastSeen:     now,
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	encoder := NewEncoder(&buf)
+	for _, env := range envelopes {
+		require.NoError(t, encoder.Encode(env))
+	}
+
+	decoder := NewDecoder(&buf)
+	for _, want := range envelopes {
+		var got Envelope
+		require.NoError(t, decoder.Decode(&got))
+		assert.Equal(t, want, &got)
+	}
+
+	var extra Envelope
+	assert.Equal(t, io.EOF, decoder.Decode(&extra))
+}
+
+func TestEnvelopeWithoutEvent(t *testing.T) {
+	_, err := (&Envelope{SensorID: "sensor-1"}).Marshal()
+	assert.Error(t, err)
+}
+
+func TestUnmarshalSkipsUnknownFields(t *testing.T) {
+	env := &Envelope{Sequence: 7, Detection: &Detection{FlowID: "flow-1"}}
+	data, err := env.Marshal()
+	require.NoError(t, err)
+
+	// Append an unknown varint field (number 99)
+	data = append(data, 0x98, 0x06, 0x01)
+
+	var got Envelope
+	require.NoError(t, got.Unmarshal(data))
+	assert.Equal(t, env, &got)
+}
+
+func TestDetectionInvalidUTF8(t *testing.T) {
+	// Reasons can quote names taken from packets, which need not be UTF-8
+	d := &Detection{FlowID: "flow-1", Reasoning: "SNI bad\xffname"}
+
+	var got Detection
+	require.NoError(t, got.Unmarshal(d.Marshal()))
+	assert.Equal(t, "SNI bad�name", got.Reasoning)
+}
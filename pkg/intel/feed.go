@@ -0,0 +1,283 @@
+package intel
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Feed formats
+const (
+	FormatList  = "list"  // plain text, one indicator per line
+	FormatSTIX  = "stix"  // STIX 2.1 bundle served over HTTP
+	FormatTAXII = "taxii" // TAXII 2.1 collection
+)
+
+const (
+	// maxFeedBytes bounds the size of a feed document or TAXII page
+	maxFeedBytes = 64 << 20
+	// maxTAXIIPages bounds the pages fetched from a collection per refresh
+	maxTAXIIPages = 1000
+	// taxiiMediaType is the media type of TAXII 2.1 responses
+	taxiiMediaType = "application/taxii+json;version=2.1"
+)
+
+// Feed is a source of indicators
+type Feed struct {
+	Name   string
+	URL    string // of the list or bundle, or the TAXII collection
+	Format string // list, stix or taxii
+	// Type of the indicators of plain lists, detected line by line when
+	// empty
+	Type Type
+	// Interval between refreshes, and TTL of the indicators after the last
+	// refresh listing them
+	Interval time.Duration
+	TTL      time.Duration
+	// HTTP basic authentication, as TAXII servers commonly require
+	Username string
+	Password string
+}
+
+// validate checks the feed's settings
+func (f *Feed) validate() error {
+	if f.Name == "" {
+		return fmt.Errorf("threat intel feed %s has no name", f.URL)
+	}
+	if _, err := url.Parse(f.URL); err != nil || f.URL == "" {
+		return fmt.Errorf("threat intel feed %s: invalid URL %q", f.Name, f.URL)
+	}
+	switch f.Format {
+	case FormatList, FormatSTIX, FormatTAXII:
+	default:
+		return fmt.Errorf("threat intel feed %s: unknown format %q", f.Name, f.Format)
+	}
+	switch f.Type {
+	case "", TypeIP, TypeJA3, TypeDomain:
+	default:
+		return fmt.Errorf("threat intel feed %s: unknown indicator type %q", f.Name, f.Type)
+	}
+	if f.Interval <= 0 || f.TTL <= 0 {
+		return fmt.Errorf("threat intel feed %s: interval and TTL must be positive", f.Name)
+	}
+	return nil
+}
+
+// fetch downloads and parses the indicators of a feed
+func fetch(ctx context.Context, client *http.Client, feed Feed) ([]Indicator, error) {
+	switch feed.Format {
+	case FormatList:
+		body, err := get(ctx, client, feed, feed.URL, "text/plain")
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return parseList(body, feed.Type)
+	case FormatSTIX:
+		body, err := get(ctx, client, feed, feed.URL, "application/json")
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		var bundle struct {
+			Objects []stixObject `json:"objects"`
+		}
+		if err := json.NewDecoder(body).Decode(&bundle); err != nil {
+			return nil, fmt.Errorf("failed to decode STIX bundle: %w", err)
+		}
+		return stixIndicators(bundle.Objects), nil
+	case FormatTAXII:
+		return fetchTAXII(ctx, client, feed)
+	}
+	return nil, fmt.Errorf("unknown feed format %q", feed.Format)
+}
+
+// fetchTAXII pages through the objects of a TAXII 2.1 collection
+func fetchTAXII(ctx context.Context, client *http.Client, feed Feed) ([]Indicator, error) {
+	objectsURL, err := url.Parse(strings.TrimSuffix(feed.URL, "/") + "/objects/")
+	if err != nil {
+		return nil, err
+	}
+
+	var indicators []Indicator
+	for page := 0; page < maxTAXIIPages; page++ {
+		body, err := get(ctx, client, feed, objectsURL.String(), taxiiMediaType)
+		if err != nil {
+			return nil, err
+		}
+		var envelope struct {
+			More    bool         `json:"more"`
+			Next    string       `json:"next"`
+			Objects []stixObject `json:"objects"`
+		}
+		err = json.NewDecoder(body).Decode(&envelope)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode TAXII envelope: %w", err)
+		}
+		indicators = append(indicators, stixIndicators(envelope.Objects)...)
+
+		if !envelope.More || envelope.Next == "" {
+			return indicators, nil
+		}
+		query := objectsURL.Query()
+		query.Set("next", envelope.Next)
+		objectsURL.RawQuery = query.Encode()
+	}
+	return nil, fmt.Errorf("TAXII collection has more than %d pages", maxTAXIIPages)
+}
+
+// get requests a feed document, returning its body
+func get(ctx context.Context, client *http.Client, feed Feed, url, accept string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if feed.Username != "" || feed.Password != "" {
+		req.SetBasicAuth(feed.Username, feed.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, maxFeedBytes), resp.Body}, nil
+}
+
+// parseList reads a plain list of indicators, one per line. Lines may
+// carry more fields after the indicator, separated by whitespace or
+// commas, and # starts a comment. Values that are not indicators of the
+// type, or of any type when typ is empty, such as CSV headers, are
+// skipped.
+func parseList(r io.Reader, typ Type) ([]Indicator, error) {
+	var indicators []Indicator
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.FieldsFunc(line, func(c rune) bool {
+			return c == ',' || c == ';' || c == ' ' || c == '\t'
+		})
+		if len(fields) == 0 {
+			continue
+		}
+		value := strings.Trim(fields[0], `"`)
+
+		lineType := typ
+		if lineType == "" {
+			lineType = detectType(value)
+		}
+		if indicator, err := NewIndicator(lineType, value); err == nil {
+			indicators = append(indicators, indicator)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read indicator list: %w", err)
+	}
+	return indicators, nil
+}
+
+// detectType guesses the type of an indicator from its value
+func detectType(value string) Type {
+	if net.ParseIP(value) != nil || strings.Contains(value, "/") {
+		return TypeIP
+	}
+	if _, err := NewIndicator(TypeJA3, value); err == nil {
+		return TypeJA3
+	}
+	return TypeDomain
+}
+
+// stixObject holds the fields of STIX 2.1 objects read from indicators
+type stixObject struct {
+	Type        string     `json:"type"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Pattern     string     `json:"pattern"`
+	PatternType string     `json:"pattern_type"`
+	ValidUntil  *time.Time `json:"valid_until"`
+	Revoked     bool       `json:"revoked"`
+}
+
+// stixComparison matches an equality comparison of a STIX pattern,
+// capturing the object type, the property path and the quoted value
+var stixComparison = regexp.MustCompile(`([a-z0-9-]+):([A-Za-z0-9_.'\[\]*-]+)\s*=\s*'((?:[^'\\]|\\.)*)'`)
+
+// stixConnectives matches what may be left of a pattern once its
+// comparisons are removed, for the comparisons to be alternatives
+var stixConnectives = regexp.MustCompile(`^[\s\[\]()]*(?:OR[\s\[\]()]*)*$`)
+
+// stixIndicators returns the indicators of the STIX indicator objects
+// whose pattern is one or more alternative equality comparisons on IP
+// addresses, domain names or JA3 fingerprints. Patterns combining
+// comparisons in other ways cannot be matched against one observable and
+// are skipped, like revoked indicators.
+func stixIndicators(objects []stixObject) []Indicator {
+	var indicators []Indicator
+	for _, object := range objects {
+		if object.Type != "indicator" || object.Revoked ||
+			(object.PatternType != "" && object.PatternType != "stix") {
+			continue
+		}
+		if !stixConnectives.MatchString(stixComparison.ReplaceAllString(object.Pattern, "")) {
+			continue
+		}
+
+		description := object.Name
+		if description == "" {
+			description = object.Description
+		}
+		for _, comparison := range stixComparison.FindAllStringSubmatch(object.Pattern, -1) {
+			typ, ok := stixType(comparison[1], comparison[2])
+			if !ok {
+				continue
+			}
+			value := strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(comparison[3])
+			indicator, err := NewIndicator(typ, value)
+			if err != nil {
+				continue
+			}
+			indicator.Description = description
+			if object.ValidUntil != nil {
+				indicator.ValidUntil = *object.ValidUntil
+			}
+			indicators = append(indicators, indicator)
+		}
+	}
+	return indicators
+}
+
+// stixType returns the indicator type of a comparison on a property of a
+// STIX object type. JA3 fingerprints have no standard object, so any
+// object or property naming JA3 is taken to hold one.
+func stixType(object, path string) (Type, bool) {
+	switch {
+	case strings.Contains(strings.ToLower(object+":"+path), "ja3"):
+		return TypeJA3, true
+	case path != "value":
+		return "", false
+	case object == "ipv4-addr" || object == "ipv6-addr":
+		return TypeIP, true
+	case object == "domain-name":
+		return TypeDomain, true
+	}
+	return "", false
+}
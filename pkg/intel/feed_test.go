@@ -0,0 +1,126 @@
+package intel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseList(t *testing.T) {
+	list := `# Blocklist, updated hourly
+203.0.113.7
+198.51.100.0/24  # scanners
+"ja3_md5","first_seen","reason"
+e7d705a3286e19ea42f587b344ee6865,2026-01-01,Tofsee
+evil.example;phishing
+http://not-an-indicator.example/path
+
+`
+	indicators, err := parseList(strings.NewReader(list), "")
+	require.NoError(t, err)
+	assert.Equal(t, []Indicator{
+		{Type: TypeIP, Value: "203.0.113.7"},
+		{Type: TypeIP, Value: "198.51.100.0/24"},
+		{Type: TypeJA3, Value: "e7d705a3286e19ea42f587b344ee6865"},
+		{Type: TypeDomain, Value: "evil.example"},
+	}, indicators)
+
+	// A configured type skips lines of other types
+	indicators, err = parseList(strings.NewReader(list), TypeIP)
+	require.NoError(t, err)
+	assert.Len(t, indicators, 2)
+}
+
+func TestSTIXIndicators(t *testing.T) {
+	validUntil := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	objects := []stixObject{
+		{Type: "indicator", Name: "C2 server", Pattern: "[ipv4-addr:value = '203.0.113.7']", PatternType: "stix", ValidUntil: &validUntil},
+		{Type: "indicator", Pattern: "[ipv6-addr:value = '2001:db8::/32'] OR [domain-name:value = 'evil.example']", Description: "campaign"},
+		{Type: "indicator", Pattern: "[x-ja3-hash:value = 'E7D705A3286E19EA42F587B344EE6865']"},
+		{Type: "indicator", Pattern: "[network-traffic:extensions.'x-tls'.ja3 = '6734f37431670b3ab4292b8f60f29984']"},
+		// Not matchable against a single observable, or not an indicator
+		{Type: "indicator", Pattern: "[ipv4-addr:value = '192.0.2.1' AND network-traffic:dst_port = 443]"},
+		{Type: "indicator", Pattern: "[ipv4-addr:value = '192.0.2.2'] FOLLOWEDBY [domain-name:value = 'x.example']"},
+		{Type: "indicator", Pattern: "[ipv4-addr:value ISSUBSET '192.0.2.0/24']"},
+		{Type: "indicator", Pattern: "[url:value = 'http://x.example/']"},
+		{Type: "indicator", Pattern: "[ipv4-addr:value = '192.0.2.3']", Revoked: true},
+		{Type: "indicator", Pattern: "alert tcp any any -> 192.0.2.4 any", PatternType: "snort"},
+		{Type: "malware", Name: "Tofsee"},
+	}
+
+	assert.Equal(t, []Indicator{
+		{Type: TypeIP, Value: "203.0.113.7", Description: "C2 server", ValidUntil: validUntil},
+		{Type: TypeIP, Value: "2001:db8::/32", Description: "campaign"},
+		{Type: TypeDomain, Value: "evil.example", Description: "campaign"},
+		{Type: TypeJA3, Value: "e7d705a3286e19ea42f587b344ee6865"},
+		{Type: TypeJA3, Value: "6734f37431670b3ab4292b8f60f29984"},
+	}, stixIndicators(objects))
+}
+
+func TestManagerRefresh(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/list.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "# ips\n203.0.113.7")
+	})
+	mux.HandleFunc("/bundle.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"type": "bundle", "objects": [
+			{"type": "indicator", "pattern": "[domain-name:value = 'evil.example']", "pattern_type": "stix"}]}`)
+	})
+	mux.HandleFunc("/taxii2/api/collections/c1/objects/", func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "argus" || password != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, taxiiMediaType, r.Header.Get("Accept"))
+		w.Header().Set("Content-Type", taxiiMediaType)
+		if r.URL.Query().Get("next") == "" {
+			fmt.Fprint(w, `{"more": true, "next": "p2", "objects": [
+				{"type": "indicator", "pattern": "[x-ja3-hash:value = 'e7d705a3286e19ea42f587b344ee6865']"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"objects": [{"type": "indicator", "pattern": "[ipv4-addr:value = '198.51.100.0/24']"}]}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	feed := func(name, path, format string) Feed {
+		return Feed{Name: name, URL: server.URL + path, Format: format, Interval: time.Hour, TTL: time.Hour}
+	}
+	taxii := feed("taxii", "/taxii2/api/collections/c1/", FormatTAXII)
+	taxii.Username, taxii.Password = "argus", "secret"
+	feeds := []Feed{feed("list", "/list.txt", FormatList), feed("stix", "/bundle.json", FormatSTIX), taxii}
+
+	manager, err := NewManager(feeds, 5*time.Second)
+	require.NoError(t, err)
+	for _, f := range manager.Feeds() {
+		_, err := manager.Refresh(context.Background(), f)
+		require.NoError(t, err, f.Name)
+	}
+
+	now := time.Now()
+	assert.Len(t, manager.MatchIP(net.ParseIP("203.0.113.7"), now), 1)
+	assert.Len(t, manager.MatchDomain("www.evil.example", now), 1)
+	assert.Len(t, manager.MatchJA3("e7d705a3286e19ea42f587b344ee6865", now), 1)
+	matches := manager.MatchIP(net.ParseIP("198.51.100.9"), now)
+	require.Len(t, matches, 1)
+	assert.Equal(t, "taxii", matches[0].Feed)
+
+	// A failed refresh keeps the indicators fetched before
+	taxii.Password = "wrong"
+	_, err = manager.Refresh(context.Background(), taxii)
+	assert.ErrorContains(t, err, "401")
+	assert.Len(t, manager.MatchIP(net.ParseIP("198.51.100.9"), now), 1)
+
+	_, err = NewManager([]Feed{feed("list", "/list.txt", "csv")}, time.Second)
+	assert.ErrorContains(t, err, "unknown format")
+	_, err = NewManager([]Feed{feeds[0], feeds[0]}, time.Second)
+	assert.ErrorContains(t, err, "duplicate")
+}
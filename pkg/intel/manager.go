@@ -0,0 +1,93 @@
+package intel
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Manager keeps the indicators of a set of feeds current in its Store,
+// refreshing each feed at its interval. Indicators of a feed that fails
+// to refresh are kept until they expire.
+type Manager struct {
+	*Store
+	feeds  []Feed
+	client *http.Client
+}
+
+// NewManager creates a manager of feeds, fetching with the given timeout
+// per request. Nothing is fetched until Run.
+func NewManager(feeds []Feed, timeout time.Duration) (*Manager, error) {
+	names := make(map[string]bool, len(feeds))
+	for i := range feeds {
+		if err := feeds[i].validate(); err != nil {
+			return nil, err
+		}
+		if names[feeds[i].Name] {
+			return nil, fmt.Errorf("duplicate threat intel feed %s", feeds[i].Name)
+		}
+		names[feeds[i].Name] = true
+	}
+
+	return &Manager{
+		Store:  NewStore(),
+		feeds:  feeds,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Feeds returns the managed feeds
+func (m *Manager) Feeds() []Feed {
+	return m.feeds
+}
+
+// Run refreshes every feed right away and then at its interval until ctx
+// is cancelled
+func (m *Manager) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, feed := range m.feeds {
+		wg.Add(1)
+		go func(feed Feed) {
+			defer wg.Done()
+			m.poll(ctx, feed)
+		}(feed)
+	}
+	wg.Wait()
+}
+
+// poll refreshes a feed at its interval
+func (m *Manager) poll(ctx context.Context, feed Feed) {
+	ticker := time.NewTicker(feed.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := m.Refresh(ctx, feed); err != nil && ctx.Err() == nil {
+			slog.Warn("Failed to refresh threat intel feed, keeping its indicators until they expire",
+				"feed", feed.Name, "error", err)
+		}
+		m.Expire(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh fetches a feed and replaces its indicators, returning how many
+// were stored
+func (m *Manager) Refresh(ctx context.Context, feed Feed) (int, error) {
+	fetched := time.Now()
+	indicators, err := fetch(ctx, m.client, feed)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch threat intel feed %s: %w", feed.Name, err)
+	}
+
+	stored := m.Replace(feed.Name, indicators, fetched, feed.TTL)
+	slog.Info("Refreshed threat intel feed", "feed", feed.Name, "indicators", stored)
+	return stored, nil
+}
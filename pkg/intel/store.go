@@ -0,0 +1,283 @@
+package intel
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Type is the kind of observable an indicator matches
+type Type string
+
+const (
+	TypeIP     Type = "ip"     // address or CIDR prefix
+	TypeJA3    Type = "ja3"    // JA3 fingerprint of TLS ClientHellos
+	TypeDomain Type = "domain" // domain name and its subdomains
+)
+
+// Indicator is an observable a feed lists as malicious
+type Indicator struct {
+	Type Type
+	// Value is normalized: addresses and prefixes in their canonical form,
+	// JA3 fingerprints and domains in lower case, domains without a
+	// trailing dot
+	Value       string
+	Description string
+	ValidUntil  time.Time // zero when the feed sets no end
+}
+
+// NewIndicator validates and normalizes an indicator value of a type
+func NewIndicator(typ Type, value string) (Indicator, error) {
+	value = strings.TrimSpace(value)
+	switch typ {
+	case TypeIP:
+		if ip := net.ParseIP(value); ip != nil {
+			return Indicator{Type: typ, Value: ip.String()}, nil
+		}
+		_, prefix, err := net.ParseCIDR(value)
+		if err != nil {
+			return Indicator{}, fmt.Errorf("invalid IP indicator %q", value)
+		}
+		return Indicator{Type: typ, Value: prefix.String()}, nil
+	case TypeJA3:
+		value = strings.ToLower(value)
+		if b, err := hex.DecodeString(value); err != nil || len(b) != 16 {
+			return Indicator{}, fmt.Errorf("invalid JA3 indicator %q", value)
+		}
+		return Indicator{Type: typ, Value: value}, nil
+	case TypeDomain:
+		value = strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(value), "*."), ".")
+		if !isDomain(value) {
+			return Indicator{}, fmt.Errorf("invalid domain indicator %q", value)
+		}
+		return Indicator{Type: typ, Value: value}, nil
+	}
+	return Indicator{}, fmt.Errorf("unknown indicator type %q", typ)
+}
+
+// isDomain reports whether name is a domain name of at least two labels
+func isDomain(name string) bool {
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 || len(name) > 253 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Match is an indicator an observable of a flow matched
+type Match struct {
+	Type        Type      `json:"type"`
+	Value       string    `json:"value"`     // as observed, e.g. the flow's address
+	Indicator   string    `json:"indicator"` // as listed, e.g. the prefix holding the address
+	Feed        string    `json:"feed"`
+	Description string    `json:"description,omitempty"`
+	Expires     time.Time `json:"expires"`
+}
+
+// Store holds the indicators of each feed until they expire. A refresh of
+// a feed replaces all of its indicators, so indicators a feed dropped
+// disappear, while those of a feed that cannot be reached stay until
+// their TTL runs out.
+type Store struct {
+	mu    sync.RWMutex
+	feeds map[string]*feedSet
+}
+
+// feedSet holds the indicators of one feed by type
+type feedSet struct {
+	ips      map[string]entry // addresses, by their canonical form
+	prefixes []prefixEntry
+	ja3      map[string]entry
+	domains  map[string]entry
+}
+
+// entry is a stored indicator
+type entry struct {
+	description string
+	expires     time.Time
+}
+
+// prefixEntry is a stored CIDR prefix
+type prefixEntry struct {
+	prefix *net.IPNet
+	entry
+}
+
+// NewStore creates an empty store
+func NewStore() *Store {
+	return &Store{feeds: make(map[string]*feedSet)}
+}
+
+// Replace replaces the indicators of a feed with those it listed at
+// fetched. Each expires at its ValidUntil or ttl after fetched, whichever
+// comes first; indicators expired already are left out. Replace returns
+// the number of indicators stored.
+func (s *Store) Replace(feed string, indicators []Indicator, fetched time.Time, ttl time.Duration) int {
+	set := &feedSet{
+		ips:     make(map[string]entry),
+		ja3:     make(map[string]entry),
+		domains: make(map[string]entry),
+	}
+	stored := 0
+	for _, indicator := range indicators {
+		e := entry{description: indicator.Description, expires: fetched.Add(ttl)}
+		if !indicator.ValidUntil.IsZero() && indicator.ValidUntil.Before(e.expires) {
+			e.expires = indicator.ValidUntil
+		}
+		if !e.expires.After(fetched) {
+			continue
+		}
+
+		switch indicator.Type {
+		case TypeIP:
+			if _, prefix, err := net.ParseCIDR(indicator.Value); err == nil {
+				if ones, bits := prefix.Mask.Size(); ones < bits {
+					set.prefixes = append(set.prefixes, prefixEntry{prefix, e})
+					break
+				}
+				set.ips[prefix.IP.String()] = e
+			} else {
+				set.ips[indicator.Value] = e
+			}
+		case TypeJA3:
+			set.ja3[indicator.Value] = e
+		case TypeDomain:
+			set.domains[indicator.Value] = e
+		default:
+			continue
+		}
+		stored++
+	}
+
+	s.mu.Lock()
+	s.feeds[feed] = set
+	s.mu.Unlock()
+	return stored
+}
+
+// Len returns the number of indicators stored, including expired ones not
+// swept yet
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, set := range s.feeds {
+		n += len(set.ips) + len(set.prefixes) + len(set.ja3) + len(set.domains)
+	}
+	return n
+}
+
+// Expire removes the indicators expired at now
+func (s *Store) Expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, set := range s.feeds {
+		for _, entries := range []map[string]entry{set.ips, set.ja3, set.domains} {
+			for key, e := range entries {
+				if !now.Before(e.expires) {
+					delete(entries, key)
+				}
+			}
+		}
+		prefixes := set.prefixes[:0]
+		for _, p := range set.prefixes {
+			if now.Before(p.expires) {
+				prefixes = append(prefixes, p)
+			}
+		}
+		set.prefixes = prefixes
+	}
+}
+
+// MatchIP returns the indicators listing ip, or a prefix holding it, at now
+func (s *Store) MatchIP(ip net.IP, now time.Time) []Match {
+	if ip == nil {
+		return nil
+	}
+	value := ip.String()
+	return s.match(TypeIP, value, now, func(set *feedSet) (string, entry, bool) {
+		if e, ok := set.ips[value]; ok {
+			return value, e, true
+		}
+		for _, p := range set.prefixes {
+			if p.prefix.Contains(ip) && now.Before(p.expires) {
+				return p.prefix.String(), p.entry, true
+			}
+		}
+		return "", entry{}, false
+	})
+}
+
+// MatchJA3 returns the indicators listing a JA3 fingerprint at now
+func (s *Store) MatchJA3(fingerprint string, now time.Time) []Match {
+	if fingerprint == "" {
+		return nil
+	}
+	value := strings.ToLower(fingerprint)
+	return s.match(TypeJA3, value, now, func(set *feedSet) (string, entry, bool) {
+		e, ok := set.ja3[value]
+		return value, e, ok
+	})
+}
+
+// MatchDomain returns the indicators listing name, or a domain it is a
+// subdomain of, at now
+func (s *Store) MatchDomain(name string, now time.Time) []Match {
+	value := strings.TrimSuffix(strings.ToLower(name), ".")
+	if value == "" {
+		return nil
+	}
+	return s.match(TypeDomain, value, now, func(set *feedSet) (string, entry, bool) {
+		for domain := value; ; {
+			if e, ok := set.domains[domain]; ok && now.Before(e.expires) {
+				return domain, e, true
+			}
+			dot := strings.IndexByte(domain, '.')
+			if dot < 0 {
+				return "", entry{}, false
+			}
+			domain = domain[dot+1:]
+		}
+	})
+}
+
+// match looks value up in every feed with lookup, returning the
+// unexpired matches ordered by feed
+func (s *Store) match(typ Type, value string, now time.Time, lookup func(*feedSet) (string, entry, bool)) []Match {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matches []Match
+	for feed, set := range s.feeds {
+		indicator, e, ok := lookup(set)
+		if !ok || !now.Before(e.expires) {
+			continue
+		}
+		matches = append(matches, Match{
+			Type:        typ,
+			Value:       value,
+			Indicator:   indicator,
+			Feed:        feed,
+			Description: e.description,
+			Expires:     e.expires,
+		})
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Feed < matches[j].Feed })
+	return matches
+}
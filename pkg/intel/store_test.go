@@ -0,0 +1,125 @@
+package intel
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func indicator(t *testing.T, typ Type, value string) Indicator {
+	t.Helper()
+	i, err := NewIndicator(typ, value)
+	require.NoError(t, err)
+	return i
+}
+
+func TestNewIndicator(t *testing.T) {
+	assert.Equal(t, "2001:db8::1", indicator(t, TypeIP, "2001:DB8:0::1").Value)
+	assert.Equal(t, "10.0.0.0/8", indicator(t, TypeIP, "10.1.2.3/8").Value)
+	assert.Equal(t, "e7d705a3286e19ea42f587b344ee6865", indicator(t, TypeJA3, "E7D705A3286E19EA42F587B344EE6865").Value)
+	assert.Equal(t, "evil.example", indicator(t, TypeDomain, "*.Evil.Example.").Value)
+
+	for _, bad := range []struct {
+		typ   Type
+		value string
+	}{
+		{TypeIP, "10.0.0"},
+		{TypeJA3, "e7d705a3"},
+		{TypeDomain, "localhost"},
+		{TypeDomain, "http://evil.example/"},
+		{"url", "http://evil.example/"},
+	} {
+		_, err := NewIndicator(bad.typ, bad.value)
+		assert.Error(t, err, bad.value)
+	}
+}
+
+func TestStoreMatch(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := NewStore()
+
+	c2 := indicator(t, TypeIP, "203.0.113.7")
+	c2.Description = "botnet C2"
+	stored := store.Replace("c2", []Indicator{
+		c2,
+		indicator(t, TypeIP, "198.51.100.0/24"),
+		indicator(t, TypeIP, "192.0.2.1/32"),
+		indicator(t, TypeJA3, "e7d705a3286e19ea42f587b344ee6865"),
+		indicator(t, TypeDomain, "evil.example"),
+	}, now, time.Hour)
+	assert.Equal(t, 5, stored)
+	assert.Equal(t, 5, store.Len())
+
+	matches := store.MatchIP(net.ParseIP("203.0.113.7"), now)
+	require.Len(t, matches, 1)
+	assert.Equal(t, Match{
+		Type:        TypeIP,
+		Value:       "203.0.113.7",
+		Indicator:   "203.0.113.7",
+		Feed:        "c2",
+		Description: "botnet C2",
+		Expires:     now.Add(time.Hour),
+	}, matches[0])
+
+	matches = store.MatchIP(net.ParseIP("198.51.100.20"), now)
+	require.Len(t, matches, 1)
+	assert.Equal(t, "198.51.100.0/24", matches[0].Indicator)
+	assert.Len(t, store.MatchIP(net.ParseIP("192.0.2.1"), now), 1)
+	assert.Empty(t, store.MatchIP(net.ParseIP("192.0.2.2"), now))
+
+	assert.Len(t, store.MatchJA3("E7D705A3286E19EA42F587B344EE6865", now), 1)
+	assert.Empty(t, store.MatchJA3("", now))
+
+	// Subdomains match their parent domain, other domains ending alike do not
+	matches = store.MatchDomain("cdn.Evil.Example.", now)
+	require.Len(t, matches, 1)
+	assert.Equal(t, "cdn.evil.example", matches[0].Value)
+	assert.Equal(t, "evil.example", matches[0].Indicator)
+	assert.Empty(t, store.MatchDomain("notevil.example", now))
+
+	// Every feed listing an observable matches
+	store.Replace("blocklist", []Indicator{indicator(t, TypeIP, "203.0.113.0/28")}, now, time.Hour)
+	matches = store.MatchIP(net.ParseIP("203.0.113.7"), now)
+	require.Len(t, matches, 2)
+	assert.Equal(t, "blocklist", matches[0].Feed)
+	assert.Equal(t, "c2", matches[1].Feed)
+
+	// A refresh replaces the indicators of its feed only
+	store.Replace("c2", nil, now, time.Hour)
+	assert.Len(t, store.MatchIP(net.ParseIP("203.0.113.7"), now), 1)
+	assert.Empty(t, store.MatchDomain("evil.example", now))
+}
+
+func TestStoreExpiry(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := NewStore()
+
+	short := indicator(t, TypeDomain, "short.example")
+	short.ValidUntil = now.Add(10 * time.Minute)
+	gone := indicator(t, TypeDomain, "gone.example")
+	gone.ValidUntil = now.Add(-time.Minute)
+	stored := store.Replace("feed", []Indicator{
+		short,
+		gone,
+		indicator(t, TypeDomain, "long.example"),
+		indicator(t, TypeIP, "10.0.0.0/8"),
+	}, now, time.Hour)
+	assert.Equal(t, 3, stored)
+
+	// Indicators expire at their end of validity or TTL, whichever is first
+	matches := store.MatchDomain("short.example", now)
+	require.Len(t, matches, 1)
+	assert.Equal(t, now.Add(10*time.Minute), matches[0].Expires)
+	assert.Empty(t, store.MatchDomain("short.example", now.Add(10*time.Minute)))
+	assert.Len(t, store.MatchDomain("long.example", now.Add(59*time.Minute)), 1)
+	assert.Empty(t, store.MatchDomain("long.example", now.Add(time.Hour)))
+	assert.Empty(t, store.MatchIP(net.ParseIP("10.1.1.1"), now.Add(time.Hour)))
+
+	store.Expire(now.Add(30 * time.Minute))
+	assert.Equal(t, 2, store.Len())
+	store.Expire(now.Add(time.Hour))
+	assert.Equal(t, 0, store.Len())
+}
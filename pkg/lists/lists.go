@@ -0,0 +1,327 @@
+// Package lists keeps the allowlist and denylist of addresses, prefixes and
+// TLS server names that decide the verdict on matching flows whatever their
+// features say.
+package lists
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lists
+const (
+	Allow = "allow" // never flagged, never blocked
+	Deny  = "deny"  // always flagged
+)
+
+// Entry types
+const (
+	TypeIP  = "ip"  // an address or CIDR prefix
+	TypeSNI = "sni" // a TLS server name, matching its subdomains too
+)
+
+// idLength is the length of the hash prefix used as entry ID
+const idLength = 12
+
+var (
+	// ErrEntryNotFound is returned for entry IDs that are not listed
+	ErrEntryNotFound = errors.New("list entry not found")
+	// ErrInvalid is returned for entries that cannot be listed
+	ErrInvalid = errors.New("invalid list entry")
+)
+
+// Entry is a listed address, prefix or server name
+type Entry struct {
+	ID      string    `json:"id"`
+	List    string    `json:"list"` // allow or deny
+	Type    string    `json:"type"` // ip or sni
+	Value   string    `json:"value"`
+	Comment string    `json:"comment,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// NewEntry validates and normalizes an entry of a list. An empty typ is
+// detected from the value.
+func NewEntry(list, typ, value, comment string) (*Entry, error) {
+	if list != Allow && list != Deny {
+		return nil, fmt.Errorf("%w: list must be %s or %s", ErrInvalid, Allow, Deny)
+	}
+
+	value = strings.TrimSpace(value)
+	if typ == "" {
+		typ = TypeSNI
+		if net.ParseIP(value) != nil || strings.Contains(value, "/") {
+			typ = TypeIP
+		}
+	}
+
+	switch typ {
+	case TypeIP:
+		if ip := net.ParseIP(value); ip != nil {
+			value = ip.String()
+		} else if _, prefix, err := net.ParseCIDR(value); err == nil {
+			value = prefix.String()
+		} else {
+			return nil, fmt.Errorf("%w: %q is not an address or CIDR prefix", ErrInvalid, value)
+		}
+	case TypeSNI:
+		value = strings.TrimSuffix(strings.ToLower(value), ".")
+		if value == "" || strings.ContainsAny(value, " /:@") || net.ParseIP(value) != nil {
+			return nil, fmt.Errorf("%w: %q is not a server name", ErrInvalid, value)
+		}
+	default:
+		return nil, fmt.Errorf("%w: type must be %s or %s", ErrInvalid, TypeIP, TypeSNI)
+	}
+
+	sum := sha256.Sum256([]byte(list + "\x00" + typ + "\x00" + value))
+	return &Entry{
+		ID:      hex.EncodeToString(sum[:])[:idLength],
+		List:    list,
+		Type:    typ,
+		Value:   value,
+		Comment: comment,
+	}, nil
+}
+
+// Store keeps the entries of both lists, in a JSON file so that they
+// survive restarts
+type Store struct {
+	path string // empty for in-memory lists
+
+	mu      sync.RWMutex
+	entries map[string]*Entry // by ID
+	index   map[string]*index // by list
+}
+
+// index matches flows against the entries of a list
+type index struct {
+	ips      map[string]*Entry
+	prefixes []prefixEntry
+	names    map[string]*Entry
+}
+
+// prefixEntry is a listed CIDR prefix
+type prefixEntry struct {
+	prefix *net.IPNet
+	entry  *Entry
+}
+
+// Open opens the lists stored at path, creating the file on first change.
+// An empty path keeps the lists in memory.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, entries: make(map[string]*Entry)}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read lists: %w", err)
+		}
+		if err == nil {
+			var entries []*Entry
+			if err := json.Unmarshal(data, &entries); err != nil {
+				return nil, fmt.Errorf("failed to decode lists %s: %w", path, err)
+			}
+			for _, entry := range entries {
+				s.entries[entry.ID] = entry
+			}
+		}
+	}
+	s.reindex()
+	return s, nil
+}
+
+// Add lists an entry, or updates the comment of the entry listed already,
+// and reports whether it is new
+func (s *Store) Add(list, typ, value, comment string) (*Entry, bool, error) {
+	entry, err := NewEntry(list, typ, value, comment)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, exists := s.entries[entry.ID]
+	if exists {
+		entry.Created = previous.Created
+	} else {
+		entry.Created = time.Now().UTC()
+	}
+	s.entries[entry.ID] = entry
+	if err := s.save(); err != nil {
+		if exists {
+			s.entries[entry.ID] = previous
+		} else {
+			delete(s.entries, entry.ID)
+		}
+		return nil, false, err
+	}
+	s.reindex()
+
+	copied := *entry
+	return &copied, !exists, nil
+}
+
+// Remove unlists the entry with the given ID, returning it
+func (s *Store) Remove(id string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[id]
+	if !ok {
+		return nil, ErrEntryNotFound
+	}
+	delete(s.entries, id)
+	if err := s.save(); err != nil {
+		s.entries[id] = entry
+		return nil, err
+	}
+	s.reindex()
+
+	copied := *entry
+	return &copied, nil
+}
+
+// Entries returns the entries of list, or of both lists when it is empty,
+// ordered by list, type and value
+func (s *Store) Entries(list string) []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]Entry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		if list == "" || entry.List == list {
+			entries = append(entries, *entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.List != b.List {
+			return a.List < b.List
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Value < b.Value
+	})
+	return entries
+}
+
+// Match returns the entry of list that one of the addresses or server
+// names matches, or nil
+func (s *Store) Match(list string, ips []net.IP, names []string) *Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if entry := s.index[list].match(ips, names); entry != nil {
+		copied := *entry
+		return &copied
+	}
+	return nil
+}
+
+// match returns the entry an address or server name matches
+func (idx *index) match(ips []net.IP, names []string) *Entry {
+	if idx == nil {
+		return nil
+	}
+	for _, ip := range ips {
+		if ip == nil {
+			continue
+		}
+		if entry, ok := idx.ips[ip.String()]; ok {
+			return entry
+		}
+		for _, p := range idx.prefixes {
+			if p.prefix.Contains(ip) {
+				return p.entry
+			}
+		}
+	}
+	for _, name := range names {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		for name != "" {
+			if entry, ok := idx.names[name]; ok {
+				return entry
+			}
+			_, parent, found := strings.Cut(name, ".")
+			if !found {
+				break
+			}
+			name = parent
+		}
+	}
+	return nil
+}
+
+// reindex rebuilds the indexes of the lists. The caller holds s.mu or owns
+// s.
+func (s *Store) reindex() {
+	s.index = make(map[string]*index)
+	for _, list := range []string{Allow, Deny} {
+		s.index[list] = &index{ips: make(map[string]*Entry), names: make(map[string]*Entry)}
+	}
+	for _, entry := range s.entries {
+		idx := s.index[entry.List]
+		if idx == nil {
+			continue
+		}
+		switch entry.Type {
+		case TypeIP:
+			if _, prefix, err := net.ParseCIDR(entry.Value); err == nil {
+				idx.prefixes = append(idx.prefixes, prefixEntry{prefix, entry})
+			} else {
+				idx.ips[entry.Value] = entry
+			}
+		case TypeSNI:
+			idx.names[entry.Value] = entry
+		}
+	}
+}
+
+// save writes the entries to the lists file. The caller holds s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	entries := make([]*Entry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode lists: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to write lists: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".lists-*")
+	if err != nil {
+		return fmt.Errorf("failed to write lists: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write lists: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write lists: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write lists: %w", err)
+	}
+	return nil
+}
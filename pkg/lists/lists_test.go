@@ -0,0 +1,128 @@
+package lists
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEntry(t *testing.T) {
+	for _, tc := range []struct {
+		typ, value   string
+		wantType     string
+		wantValue    string
+		wantErrorMsg string
+	}{
+		{"", "203.0.113.7", TypeIP, "203.0.113.7", ""},
+		{"", "2001:DB8::7", TypeIP, "2001:db8::7", ""},
+		{"", "10.1.2.3/8", TypeIP, "10.0.0.0/8", ""},
+		{"", "API.Example.COM.", TypeSNI, "api.example.com", ""},
+		{TypeIP, "example.com", "", "", "not an address"},
+		{TypeSNI, "203.0.113.7", "", "", "not a server name"},
+		{TypeSNI, "", "", "", "not a server name"},
+		{"ja3", "abc", "", "", "type must be"},
+	} {
+		entry, err := NewEntry(Allow, tc.typ, tc.value, "")
+		if tc.wantErrorMsg != "" {
+			assert.ErrorIs(t, err, ErrInvalid, tc.value)
+			assert.ErrorContains(t, err, tc.wantErrorMsg, tc.value)
+			continue
+		}
+		require.NoError(t, err, tc.value)
+		assert.Equal(t, tc.wantType, entry.Type, tc.value)
+		assert.Equal(t, tc.wantValue, entry.Value, tc.value)
+		assert.Len(t, entry.ID, idLength)
+	}
+
+	_, err := NewEntry("grey", "", "203.0.113.7", "")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// IDs depend on the list, type and normalized value
+	a, _ := NewEntry(Allow, "", "203.0.113.7", "one")
+	b, _ := NewEntry(Allow, TypeIP, " 203.0.113.7 ", "two")
+	c, _ := NewEntry(Deny, "", "203.0.113.7", "")
+	assert.Equal(t, a.ID, b.ID)
+	assert.NotEqual(t, a.ID, c.ID)
+}
+
+func TestStoreMatch(t *testing.T) {
+	store, err := Open("")
+	require.NoError(t, err)
+
+	for _, entry := range []struct{ list, value string }{
+		{Allow, "192.0.2.10"},
+		{Allow, "198.51.100.0/24"},
+		{Allow, "example.com"},
+		{Deny, "evil.example"},
+		{Deny, "2001:db8::/32"},
+	} {
+		_, created, err := store.Add(entry.list, "", entry.value, "")
+		require.NoError(t, err)
+		assert.True(t, created)
+	}
+
+	match := func(list string, ip string, name string) string {
+		var names []string
+		if name != "" {
+			names = append(names, name)
+		}
+		entry := store.Match(list, []net.IP{nil, net.ParseIP(ip)}, names)
+		if entry == nil {
+			return ""
+		}
+		return entry.Value
+	}
+	assert.Equal(t, "192.0.2.10", match(Allow, "192.0.2.10", ""))
+	assert.Equal(t, "198.51.100.0/24", match(Allow, "198.51.100.77", ""))
+	assert.Equal(t, "example.com", match(Allow, "203.0.113.1", "cdn.Example.com."))
+	assert.Equal(t, "", match(Allow, "203.0.113.1", "notexample.com"))
+	assert.Equal(t, "", match(Deny, "192.0.2.10", "example.com"))
+	assert.Equal(t, "evil.example", match(Deny, "203.0.113.1", "a.b.evil.example"))
+	assert.Equal(t, "2001:db8::/32", match(Deny, "2001:db8::1", ""))
+	assert.Equal(t, "", match("grey", "192.0.2.10", ""))
+}
+
+func TestStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "lists.json")
+	store, err := Open(path)
+	require.NoError(t, err)
+	assert.Empty(t, store.Entries(""))
+
+	allowed, created, err := store.Add(Allow, "", "192.0.2.10", "monitoring")
+	require.NoError(t, err)
+	assert.True(t, created)
+	denied, _, err := store.Add(Deny, "", "evil.example", "")
+	require.NoError(t, err)
+
+	// Listing an entry again updates its comment
+	updated, created, err := store.Add(Allow, "", "192.0.2.10", "uptime probe")
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, allowed.ID, updated.ID)
+	assert.Equal(t, allowed.Created, updated.Created)
+
+	reopened, err := Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{*updated, *denied}, reopened.Entries(""))
+	assert.Equal(t, []Entry{*denied}, reopened.Entries(Deny))
+	assert.NotNil(t, reopened.Match(Deny, nil, []string{"evil.example"}))
+
+	removed, err := reopened.Remove(denied.ID)
+	require.NoError(t, err)
+	assert.Equal(t, denied.Value, removed.Value)
+	assert.Nil(t, reopened.Match(Deny, nil, []string{"evil.example"}))
+	_, err = reopened.Remove(denied.ID)
+	assert.ErrorIs(t, err, ErrEntryNotFound)
+
+	reopened, err = Open(path)
+	require.NoError(t, err)
+	assert.Len(t, reopened.Entries(""), 1)
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+	_, err = Open(path)
+	assert.ErrorContains(t, err, "failed to decode lists")
+}
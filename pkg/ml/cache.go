@@ -0,0 +1,159 @@
+package ml
+
+import (
+	"container/list"
+	"encoding/binary"
+	"hash/maphash"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// predictionCache remembers the predictions of recent feature vectors, so
+// that the near-identical vectors of bot flows are scored once. Vectors
+// are rounded to a step before hashing; those rounding to the same values
+// share a prediction. The least recently used prediction makes room for
+// a new one when the cache is full, and predictions expire after a TTL.
+type predictionCache struct {
+	size int
+	ttl  time.Duration // predictions never expire when zero
+	step float64       // features are compared exactly when zero
+	seed maphash.Seed
+
+	mu      sync.Mutex
+	entries map[uint64]*list.Element
+	lru     *list.List // most recently used at the front
+	hits    int64
+	misses  int64
+}
+
+// cachedPrediction is a prediction held by the cache
+type cachedPrediction struct {
+	key          uint64
+	confidence   float64
+	modelUsed    string
+	explanations []Explanation
+	expires      time.Time
+}
+
+// newPredictionCache returns a cache of up to size predictions, nil when
+// size is not positive
+func newPredictionCache(size int, ttl time.Duration, step float64) *predictionCache {
+	if size <= 0 {
+		return nil
+	}
+	return &predictionCache{
+		size:    size,
+		ttl:     ttl,
+		step:    step,
+		seed:    maphash.MakeSeed(),
+		entries: make(map[uint64]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// key hashes a feature vector rounded to the cache's step. The seed is
+// random so that flows cannot be crafted to collide with others.
+func (c *predictionCache) key(features []float64) uint64 {
+	var h maphash.Hash
+	h.SetSeed(c.seed)
+	var buf [8]byte
+	for _, x := range features {
+		if c.step > 0 {
+			x = math.Round(x / c.step)
+		}
+		if x == 0 {
+			x = 0 // -0 rounds to the same prediction as 0
+		}
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(x))
+		h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+// get returns the unexpired prediction for a key, counting a hit or miss
+func (c *predictionCache) get(key uint64, now time.Time) (*cachedPrediction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cachedPrediction)
+		if c.ttl == 0 || now.Before(entry.expires) {
+			c.lru.MoveToFront(elem)
+			c.hits++
+			return entry, true
+		}
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+	c.misses++
+	return nil, false
+}
+
+// put stores a prediction, evicting the least recently used one when full
+func (c *predictionCache) put(entry *cachedPrediction, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.expires = now.Add(c.ttl)
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	if c.lru.Len() >= c.size {
+		oldest := c.lru.Back()
+		delete(c.entries, c.lru.Remove(oldest).(*cachedPrediction).key)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+}
+
+// purge drops all predictions, as when the models change
+func (c *predictionCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	c.lru.Init()
+}
+
+// counts returns the hits, misses and predictions held
+func (c *predictionCache) counts() (hits, misses int64, entries int) {
+	if c == nil {
+		return 0, 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses, c.lru.Len()
+}
+
+// lookupCache returns the cached prediction for a feature vector, its
+// explanations naming the vector's own values. The caller holds e.mu.
+func (e *MLEngine) lookupCache(features []float64, now time.Time) (*cachedPrediction, bool) {
+	if e.cache == nil {
+		return nil, false
+	}
+	entry, ok := e.cache.get(e.cache.key(features), now)
+	if !ok {
+		return nil, false
+	}
+
+	prediction := *entry
+	prediction.explanations = slices.Clone(entry.explanations)
+	for i := range prediction.explanations {
+		prediction.explanations[i].Value = features[prediction.explanations[i].Feature]
+	}
+	return &prediction, true
+}
+
+// lock takes the engine's lock for writing. Cached predictions are
+// dropped, as what changes under the lock may change them, and the models
+// run at full precision until quantized again.
+func (e *MLEngine) lock() {
+	e.mu.Lock()
+	e.cache.purge()
+	e.quantized = nil
+}
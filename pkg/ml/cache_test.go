@@ -0,0 +1,82 @@
+package ml
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPredictionCache(t *testing.T) {
+	assert.Nil(t, newPredictionCache(0, time.Minute, 0.01))
+
+	cache := newPredictionCache(2, time.Minute, 0.1)
+	assert.Equal(t, cache.key([]float64{1.02, -0.01}), cache.key([]float64{0.98, 0.01}))
+	assert.NotEqual(t, cache.key([]float64{1.02, 0}), cache.key([]float64{1.2, 0}))
+
+	now := time.Now()
+	for i, features := range [][]float64{{1}, {2}, {3}} {
+		cache.put(&cachedPrediction{key: cache.key(features), confidence: float64(i)}, now)
+	}
+	_, ok := cache.get(cache.key([]float64{1}), now)
+	assert.False(t, ok, "least recently used prediction evicted")
+	entry, ok := cache.get(cache.key([]float64{3}), now)
+	require.True(t, ok)
+	assert.Equal(t, 2.0, entry.confidence)
+
+	_, ok = cache.get(cache.key([]float64{2}), now.Add(time.Minute))
+	assert.False(t, ok, "expired prediction")
+
+	hits, misses, entries := cache.counts()
+	assert.Equal(t, int64(1), hits)
+	assert.Equal(t, int64(2), misses)
+	assert.Equal(t, 1, entries)
+
+	cache.purge()
+	_, _, entries = cache.counts()
+	assert.Zero(t, entries)
+}
+
+func TestPredictCached(t *testing.T) {
+	config := MLConfig{
+		ModelType:          "svm",
+		DetectionThreshold: 0.5,
+		FeatureSize:        4,
+		GenerateFakeData:   true,
+		FakeDataSize:       200,
+		EvaluationHoldout:  0.2,
+		Explanations:       2,
+		CacheSize:          16,
+		CacheQuantization:  0.01,
+	}
+	engine, err := NewMLEngine(config)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	first, err := engine.Predict(context.Background(), []float64{0.5, 0.2, 0.9, 0.1}, "a")
+	require.NoError(t, err)
+	assert.False(t, first.Cached)
+
+	// A near-identical flow reuses the prediction, explained by its own values
+	second, err := engine.Predict(context.Background(), []float64{0.501, 0.2, 0.9, 0.1}, "b")
+	require.NoError(t, err)
+	assert.True(t, second.Cached)
+	assert.Equal(t, first.Confidence, second.Confidence)
+	assert.Equal(t, "b", second.FlowID)
+	for _, explanation := range second.Explanations {
+		assert.Equal(t, second.Features[explanation.Feature], explanation.Value)
+	}
+
+	stats := engine.GetStatistics()
+	assert.Equal(t, int64(1), stats.CacheHits)
+	assert.Equal(t, int64(1), stats.CacheMisses)
+	assert.Equal(t, int64(2), stats.TotalPredictions)
+
+	// Retraining empties the cache
+	require.NoError(t, engine.TrainOnFakeData())
+	third, err := engine.Predict(context.Background(), []float64{0.5, 0.2, 0.9, 0.1}, "c")
+	require.NoError(t, err)
+	assert.False(t, third.Cached)
+}
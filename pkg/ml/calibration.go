@@ -0,0 +1,252 @@
+package ml
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Calibration methods
+const (
+	CalibrationNone     = "none"
+	CalibrationPlatt    = "platt"    // logistic fit of the scores
+	CalibrationIsotonic = "isotonic" // monotonic step function of the scores
+)
+
+const (
+	// defaultCalibrationHoldout is the share of training samples the
+	// calibrator is fitted on when MLConfig.CalibrationHoldout is unset
+	defaultCalibrationHoldout = 0.2
+
+	// plattIterations bounds the Newton steps of Platt scaling
+	plattIterations = 100
+)
+
+// Calibrator maps raw model scores to calibrated bot probabilities
+type Calibrator struct {
+	Method string
+
+	// Platt scaling: sigmoid(A*score + B)
+	A float64
+	B float64
+
+	// Isotonic regression: scores up to Thresholds[i], and above the
+	// previous threshold, map to Values[i]. Thresholds are ascending.
+	Thresholds []float64
+	Values     []float64
+}
+
+// apply returns the calibrated probability of a raw score
+func (c *Calibrator) apply(score float64) float64 {
+	switch c.Method {
+	case CalibrationPlatt:
+		return sigmoid(c.A*score + c.B)
+	case CalibrationIsotonic:
+		i := sort.SearchFloat64s(c.Thresholds, score)
+		return c.Values[min(i, len(c.Values)-1)]
+	default:
+		return score
+	}
+}
+
+// validate checks a calibrator read from a snapshot
+func (c *Calibrator) validate() error {
+	switch c.Method {
+	case CalibrationPlatt:
+		if math.IsNaN(c.A) || math.IsNaN(c.B) {
+			return fmt.Errorf("platt calibration has invalid parameters")
+		}
+	case CalibrationIsotonic:
+		if len(c.Thresholds) == 0 || len(c.Thresholds) != len(c.Values) {
+			return fmt.Errorf("isotonic calibration has %d thresholds and %d values", len(c.Thresholds), len(c.Values))
+		}
+		if !sort.Float64sAreSorted(c.Thresholds) || !sort.Float64sAreSorted(c.Values) {
+			return fmt.Errorf("isotonic calibration is not monotonic")
+		}
+	default:
+		return fmt.Errorf("unsupported calibration method: %s", c.Method)
+	}
+	return nil
+}
+
+// clone returns a copy of the calibrator, nil for nil
+func (c *Calibrator) clone() *Calibrator {
+	if c == nil {
+		return nil
+	}
+	clone := *c
+	clone.Thresholds = append([]float64(nil), c.Thresholds...)
+	clone.Values = append([]float64(nil), c.Values...)
+	return &clone
+}
+
+// fitPlatt fits a logistic function to labelled scores with Newton's
+// method, using Platt's smoothed targets so that separable scores do not
+// drive the parameters to infinity
+func fitPlatt(scores []float64, labels []int) *Calibrator {
+	var bots, humans float64
+	for _, label := range labels {
+		if label == 1 {
+			bots++
+		} else {
+			humans++
+		}
+	}
+	targets := make([]float64, len(labels))
+	for i, label := range labels {
+		if label == 1 {
+			targets[i] = (bots + 1) / (bots + 2)
+		} else {
+			targets[i] = 1 / (humans + 2)
+		}
+	}
+
+	loss := func(a, b float64) float64 {
+		var sum float64
+		for i, s := range scores {
+			p := math.Min(math.Max(sigmoid(a*s+b), 1e-12), 1-1e-12)
+			sum -= targets[i]*math.Log(p) + (1-targets[i])*math.Log(1-p)
+		}
+		return sum
+	}
+
+	a, b := 0.0, math.Log((bots+1)/(humans+1))
+	current := loss(a, b)
+	for iter := 0; iter < plattIterations; iter++ {
+		var ga, gb, haa, hbb, hab float64
+		for i, s := range scores {
+			p := sigmoid(a*s + b)
+			d := p - targets[i]
+			w := p * (1 - p)
+			ga += d * s
+			gb += d
+			haa += w * s * s
+			hbb += w
+			hab += w * s
+		}
+		haa += 1e-12
+		hbb += 1e-12
+		det := haa*hbb - hab*hab
+		if det <= 0 {
+			break
+		}
+		da := -(hbb*ga - hab*gb) / det
+		db := -(haa*gb - hab*ga) / det
+
+		// Halve the step until the loss does not increase
+		step := 1.0
+		for ; step > 1e-8; step /= 2 {
+			if next := loss(a+step*da, b+step*db); next <= current {
+				current = next
+				break
+			}
+		}
+		if step <= 1e-8 {
+			break
+		}
+		a += step * da
+		b += step * db
+		if math.Abs(step*da) < 1e-10 && math.Abs(step*db) < 1e-10 {
+			break
+		}
+	}
+	return &Calibrator{Method: CalibrationPlatt, A: a, B: b}
+}
+
+// fitIsotonic fits a non-decreasing step function to labelled scores by
+// pooling adjacent violators
+func fitIsotonic(scores []float64, labels []int) *Calibrator {
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return scores[order[i]] < scores[order[j]] })
+
+	type block struct {
+		upper float64 // highest score in the block
+		sum   float64 // number of bots
+		count float64
+	}
+	var blocks []block
+	for _, i := range order {
+		b := block{upper: scores[i], sum: float64(labels[i]), count: 1}
+		// Tied scores share a block
+		if n := len(blocks); n > 0 && blocks[n-1].upper == b.upper {
+			blocks[n-1].sum += b.sum
+			blocks[n-1].count++
+		} else {
+			blocks = append(blocks, b)
+		}
+		for n := len(blocks); n > 1 && blocks[n-2].sum/blocks[n-2].count >= blocks[n-1].sum/blocks[n-1].count; n = len(blocks) {
+			blocks[n-2].upper = blocks[n-1].upper
+			blocks[n-2].sum += blocks[n-1].sum
+			blocks[n-2].count += blocks[n-1].count
+			blocks = blocks[:n-1]
+		}
+	}
+
+	c := &Calibrator{Method: CalibrationIsotonic}
+	for _, b := range blocks {
+		c.Thresholds = append(c.Thresholds, b.upper)
+		c.Values = append(c.Values, b.sum/b.count)
+	}
+	return c
+}
+
+// fit trains models on labelled samples. With calibration configured, a
+// stratified share of CalibrationHoldout samples is held out of training
+// and the calibrator is fitted on the models' scores for them.
+func (e *MLEngine) fit(models []string, features [][]float64, labels []int, rng *rand.Rand) error {
+	method := e.config.Calibration
+	if method == "" || method == CalibrationNone {
+		e.lock()
+		e.calibrator = nil
+		e.mu.Unlock()
+		return e.trainModels(models, features, labels)
+	}
+	if method != CalibrationPlatt && method != CalibrationIsotonic {
+		return fmt.Errorf("unsupported calibration method: %s", method)
+	}
+
+	holdout := e.config.CalibrationHoldout
+	if holdout <= 0 || holdout >= 1 {
+		holdout = defaultCalibrationHoldout
+	}
+	train, calibrate := stratifiedSplit(labels, holdout, rng)
+	calibrationFeatures, calibrationLabels := subset(features, labels, calibrate)
+	bots := 0
+	for _, label := range calibrationLabels {
+		bots += label
+	}
+	if bots == 0 || bots == len(calibrationLabels) || len(train) == 0 {
+		slog.Warn("Too few bot and human samples to calibrate, leaving scores uncalibrated", "samples", len(features))
+		e.lock()
+		e.calibrator = nil
+		e.mu.Unlock()
+		return e.trainModels(models, features, labels)
+	}
+
+	trainFeatures, trainLabels := subset(features, labels, train)
+	if err := e.trainModels(models, trainFeatures, trainLabels); err != nil {
+		return err
+	}
+
+	e.lock()
+	defer e.mu.Unlock()
+	scores := make([]float64, len(calibrationFeatures))
+	for i, sample := range calibrationFeatures {
+		score, err := e.inferModels(sample)
+		if err != nil {
+			return fmt.Errorf("failed to score calibration sample %d: %w", i, err)
+		}
+		scores[i] = score
+	}
+	if method == CalibrationPlatt {
+		e.calibrator = fitPlatt(scores, calibrationLabels)
+	} else {
+		e.calibrator = fitIsotonic(scores, calibrationLabels)
+	}
+	return nil
+}
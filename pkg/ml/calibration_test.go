@@ -0,0 +1,91 @@
+package ml
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFitPlatt(t *testing.T) {
+	// Scores whose bot probability is sigmoid(4*score - 2)
+	r := rand.New(rand.NewSource(1))
+	scores := make([]float64, 5000)
+	labels := make([]int, len(scores))
+	for i := range scores {
+		scores[i] = r.Float64()
+		if r.Float64() < sigmoid(4*scores[i]-2) {
+			labels[i] = 1
+		}
+	}
+
+	c := fitPlatt(scores, labels)
+	require.NoError(t, c.validate())
+	assert.InDelta(t, 4, c.A, 0.5)
+	assert.InDelta(t, -2, c.B, 0.3)
+	assert.InDelta(t, 0.5, c.apply(0.5), 0.05)
+}
+
+func TestFitIsotonic(t *testing.T) {
+	c := fitIsotonic([]float64{0.4, 0.1, 0.3, 0.2}, []int{1, 0, 0, 1})
+	require.NoError(t, c.validate())
+	assert.Equal(t, []float64{0.1, 0.3, 0.4}, c.Thresholds)
+	assert.Equal(t, []float64{0, 0.5, 1}, c.Values)
+
+	assert.Equal(t, 0.0, c.apply(0.05))
+	assert.Equal(t, 0.5, c.apply(0.25))
+	assert.Equal(t, 1.0, c.apply(0.9))
+
+	assert.Error(t, (&Calibrator{Method: CalibrationIsotonic, Thresholds: []float64{0.1}}).validate())
+	assert.Error(t, (&Calibrator{Method: CalibrationIsotonic, Thresholds: []float64{0.1, 0.2}, Values: []float64{1, 0}}).validate())
+	assert.Error(t, (&Calibrator{Method: "beta"}).validate())
+}
+
+func TestCalibratedEngine(t *testing.T) {
+	cfg := MLConfig{
+		ModelType:          "svm",
+		DetectionThreshold: 0.5,
+		FeatureSize:        8,
+		GenerateFakeData:   true,
+		FakeDataSize:       400,
+		Calibration:        CalibrationIsotonic,
+	}
+	engine, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	snapshot := engine.Snapshot()
+	require.NotNil(t, snapshot.Calibration)
+	assert.Equal(t, CalibrationIsotonic, snapshot.Calibration.Method)
+
+	// Calibrated confidences are the bot shares the calibrator was fitted on
+	features := []float64{0.1, 0.9, 0.3, 0.5, 0.2, 0.8, 0.4, 0.6}
+	want, err := engine.Predict(context.Background(), features, "a-b")
+	require.NoError(t, err)
+	assert.Contains(t, snapshot.Calibration.Values, want.Confidence)
+
+	curves, err := engine.Curves()
+	require.NoError(t, err)
+	assert.NotEmpty(t, curves.Calibration)
+	assert.Equal(t, curves.BrierScore, snapshot.Metrics["test_brier_score"])
+
+	// The calibration is part of the persisted model
+	cfg.GenerateFakeData = false
+	restored, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer restored.Close()
+	require.NoError(t, restored.Restore(snapshot))
+	assert.Equal(t, snapshot.Hash(), restored.Snapshot().Hash())
+	got, err := restored.Predict(context.Background(), features, "a-b")
+	require.NoError(t, err)
+	assert.Equal(t, want.Confidence, got.Confidence)
+
+	uncalibrated := *snapshot
+	uncalibrated.Calibration = nil
+	assert.NotEqual(t, snapshot.Hash(), uncalibrated.Hash())
+
+	snapshot.Calibration = &Calibrator{Method: "beta"}
+	assert.ErrorContains(t, restored.Restore(snapshot), "unsupported calibration method")
+}
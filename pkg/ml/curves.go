@@ -0,0 +1,165 @@
+package ml
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrNotEvaluated is returned for models without held-out evaluation scores
+var ErrNotEvaluated = errors.New("model has no held-out evaluation")
+
+// CurvePoint is the classification of held-out samples at one detection
+// threshold, samples scoring at least the threshold being predicted bots
+type CurvePoint struct {
+	Threshold         float64 `json:"threshold"`
+	TruePositiveRate  float64 `json:"true_positive_rate"` // also the recall
+	FalsePositiveRate float64 `json:"false_positive_rate"`
+	Precision         float64 `json:"precision"`
+}
+
+// CalibrationBin compares the mean confidence of held-out samples scoring
+// within a range with the share of bots among them. The confidences of a
+// calibrated model match the shares.
+type CalibrationBin struct {
+	Lower          float64 `json:"lower"`
+	Upper          float64 `json:"upper"`
+	Samples        int     `json:"samples"`
+	MeanConfidence float64 `json:"mean_confidence"`
+	BotRate        float64 `json:"bot_rate"`
+}
+
+// calibrationBins is the number of equal-width bins of the calibration
+// curve
+const calibrationBins = 10
+
+// Curves are the ROC and precision-recall curves of held-out samples, with
+// one point per distinct score in descending order of threshold, and their
+// calibration curve
+type Curves struct {
+	Samples          int              `json:"samples"`
+	ROCAUC           float64          `json:"roc_auc"`
+	AveragePrecision float64          `json:"average_precision"`
+	BrierScore       float64          `json:"brier_score"` // mean squared error of the confidences
+	Points           []CurvePoint     `json:"points"`
+	Calibration      []CalibrationBin `json:"calibration"` // bins without samples are left out
+}
+
+// computeCurves sweeps the detection threshold over the scores of labelled
+// samples
+func computeCurves(scores []float64, labels []int) (*Curves, error) {
+	if len(scores) != len(labels) {
+		return nil, fmt.Errorf("got %d scores and %d labels", len(scores), len(labels))
+	}
+	var bots, humans int
+	for _, label := range labels {
+		if label == 1 {
+			bots++
+		} else {
+			humans++
+		}
+	}
+	if bots == 0 || humans == 0 {
+		return nil, fmt.Errorf("curves need bot and human samples, got %d and %d", bots, humans)
+	}
+
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+
+	curves := &Curves{Samples: len(scores)}
+	var tp, fp int
+	var prev CurvePoint
+	for n, i := range order {
+		if labels[i] == 1 {
+			tp++
+		} else {
+			fp++
+		}
+		// Tied scores are classified alike
+		if n+1 < len(order) && scores[order[n+1]] == scores[i] {
+			continue
+		}
+
+		point := CurvePoint{
+			Threshold:         scores[i],
+			TruePositiveRate:  float64(tp) / float64(bots),
+			FalsePositiveRate: float64(fp) / float64(humans),
+			Precision:         float64(tp) / float64(tp+fp),
+		}
+		curves.ROCAUC += (point.FalsePositiveRate - prev.FalsePositiveRate) * (point.TruePositiveRate + prev.TruePositiveRate) / 2
+		curves.AveragePrecision += (point.TruePositiveRate - prev.TruePositiveRate) * point.Precision
+		curves.Points = append(curves.Points, point)
+		prev = point
+	}
+
+	bins := make([]CalibrationBin, calibrationBins)
+	for i, score := range scores {
+		label := float64(labels[i])
+		curves.BrierScore += (score - label) * (score - label)
+
+		b := &bins[min(max(int(score*calibrationBins), 0), calibrationBins-1)]
+		b.Samples++
+		b.MeanConfidence += score
+		b.BotRate += label
+	}
+	curves.BrierScore /= float64(len(scores))
+	for i, b := range bins {
+		if b.Samples == 0 {
+			continue
+		}
+		b.Lower = float64(i) / calibrationBins
+		b.Upper = float64(i+1) / calibrationBins
+		b.MeanConfidence /= float64(b.Samples)
+		b.BotRate /= float64(b.Samples)
+		curves.Calibration = append(curves.Calibration, b)
+	}
+	return curves, nil
+}
+
+// RecommendThreshold returns the point detecting the most bots while
+// keeping the false positive rate at or below maxFalsePositiveRate, and of
+// those the one with the fewest false positives
+func (c *Curves) RecommendThreshold(maxFalsePositiveRate float64) (CurvePoint, error) {
+	if maxFalsePositiveRate < 0 || maxFalsePositiveRate > 1 {
+		return CurvePoint{}, fmt.Errorf("false positive rate must be between 0 and 1")
+	}
+
+	// Points are ordered by rising rates
+	best := -1
+	for i, point := range c.Points {
+		if point.FalsePositiveRate > maxFalsePositiveRate {
+			break
+		}
+		if best < 0 || point.TruePositiveRate > c.Points[best].TruePositiveRate {
+			best = i
+		}
+	}
+	if best < 0 {
+		return CurvePoint{}, fmt.Errorf("no threshold keeps the false positive rate at or below %g", maxFalsePositiveRate)
+	}
+	return c.Points[best], nil
+}
+
+// Curves returns the ROC and precision-recall curves of the samples held
+// out when the model was trained
+func (s *ModelSnapshot) Curves() (*Curves, error) {
+	if len(s.EvaluationScores) == 0 {
+		return nil, ErrNotEvaluated
+	}
+	return computeCurves(s.EvaluationScores, s.EvaluationLabels)
+}
+
+// Curves returns the ROC and precision-recall curves of the samples held
+// out when the model was trained
+func (e *MLEngine) Curves() (*Curves, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(e.evalScores) == 0 {
+		return nil, ErrNotEvaluated
+	}
+	return computeCurves(e.evalScores, e.evalLabels)
+}
@@ -0,0 +1,84 @@
+package ml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeCurves(t *testing.T) {
+	curves, err := computeCurves([]float64{0.9, 0.8, 0.7, 0.6, 0.5, 0.4}, []int{1, 1, 0, 1, 0, 0})
+	require.NoError(t, err)
+	assert.Equal(t, 6, curves.Samples)
+	assert.Len(t, curves.Points, 6)
+	assert.InDelta(t, 8.0/9, curves.ROCAUC, 1e-9)
+	assert.InDelta(t, 11.0/12, curves.AveragePrecision, 1e-9)
+	assert.Equal(t, CurvePoint{Threshold: 0.6, TruePositiveRate: 1, FalsePositiveRate: 1.0 / 3, Precision: 0.75}, curves.Points[3])
+	assert.InDelta(t, (0.01+0.04+0.49+0.16+0.25+0.16)/6, curves.BrierScore, 1e-9)
+	require.Len(t, curves.Calibration, 6)
+	assert.Equal(t, CalibrationBin{Lower: 0.4, Upper: 0.5, Samples: 1, MeanConfidence: 0.4}, curves.Calibration[0])
+	assert.Equal(t, 1.0, curves.Calibration[5].BotRate)
+
+	point, err := curves.RecommendThreshold(0)
+	require.NoError(t, err)
+	assert.Equal(t, 0.8, point.Threshold)
+
+	point, err = curves.RecommendThreshold(0.4)
+	require.NoError(t, err)
+	assert.Equal(t, 0.6, point.Threshold)
+	assert.Equal(t, 1.0, point.TruePositiveRate)
+
+	// Of equally sensitive thresholds the one with fewer false positives
+	point, err = curves.RecommendThreshold(1)
+	require.NoError(t, err)
+	assert.Equal(t, 0.6, point.Threshold)
+
+	_, err = curves.RecommendThreshold(1.5)
+	assert.Error(t, err)
+
+	// Tied scores form a single point
+	curves, err = computeCurves([]float64{0.5, 0.5}, []int{1, 0})
+	require.NoError(t, err)
+	assert.Len(t, curves.Points, 1)
+	assert.Equal(t, 0.5, curves.ROCAUC)
+	_, err = curves.RecommendThreshold(0.5)
+	assert.ErrorContains(t, err, "no threshold")
+
+	_, err = computeCurves([]float64{0.1, 0.2}, []int{0, 0})
+	assert.ErrorContains(t, err, "bot and human samples")
+}
+
+func TestEngineCurves(t *testing.T) {
+	untrained, err := NewMLEngine(MLConfig{ModelType: "svm", FeatureSize: 8})
+	require.NoError(t, err)
+	defer untrained.Close()
+	_, err = untrained.Curves()
+	assert.ErrorIs(t, err, ErrNotEvaluated)
+
+	engine, err := NewMLEngine(MLConfig{
+		ModelType:          "gbdt",
+		DetectionThreshold: 0.6,
+		FeatureSize:        8,
+		GenerateFakeData:   true,
+		FakeDataSize:       200,
+		GBDTTrees:          10,
+	})
+	require.NoError(t, err)
+	defer engine.Close()
+
+	curves, err := engine.Curves()
+	require.NoError(t, err)
+	assert.Equal(t, 40, curves.Samples)
+	assert.Greater(t, curves.ROCAUC, 0.9)
+
+	// The held-out scores are persisted with the model
+	snapshot := engine.Snapshot()
+	assert.Equal(t, curves.ROCAUC, snapshot.Metrics["test_roc_auc"])
+	fromSnapshot, err := snapshot.Curves()
+	require.NoError(t, err)
+	assert.Equal(t, curves, fromSnapshot)
+
+	mismatched := &ModelSnapshot{ModelType: "svm", FeatureSize: 8, EvaluationScores: []float64{0.5, 0.7}, EvaluationLabels: []int{1}}
+	assert.ErrorContains(t, untrained.Restore(mismatched), "evaluation scores")
+}
@@ -0,0 +1,161 @@
+package ml
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// driftBins is the number of quantile bins of drift histograms
+	driftBins = 10
+
+	// psiFloor replaces empty bin shares so that the population stability
+	// index stays finite
+	psiFloor = 1e-4
+)
+
+// Histogram is the distribution of a value over bins bounded by Edges: bin
+// i holds values above Edges[i-1] up to Edges[i], the last bin values above
+// every edge. Shares sum to one.
+type Histogram struct {
+	Edges  []float64
+	Shares []float64
+}
+
+// DriftReference is the distribution of the features a model was trained on
+// and of its confidence on held-out samples, against which incoming flows
+// are compared to detect drift
+type DriftReference struct {
+	Features []Histogram
+	Scores   *Histogram // nil for models without held-out samples
+	Source   string     // the training data, as in TrainingData.Source
+}
+
+// newHistogram bins values at their deciles
+func newHistogram(values []float64) Histogram {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	var h Histogram
+	for k := 1; k < driftBins; k++ {
+		edge := sorted[k*(len(sorted)-1)/driftBins]
+		if n := len(h.Edges); n == 0 || edge > h.Edges[n-1] {
+			h.Edges = append(h.Edges, edge)
+		}
+	}
+	h.Shares = make([]float64, len(h.Edges)+1)
+	for _, v := range values {
+		h.Shares[h.Bin(v)]++
+	}
+	for i := range h.Shares {
+		h.Shares[i] /= float64(len(values))
+	}
+	return h
+}
+
+// Bin returns the bin of a value
+func (h *Histogram) Bin(v float64) int {
+	return sort.SearchFloat64s(h.Edges, v)
+}
+
+// PSI returns the population stability index of counts per bin against the
+// histogram: below 0.1 is commonly read as stable, above 0.25 as a
+// significant shift
+func (h *Histogram) PSI(counts []int64) float64 {
+	var total int64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	var psi float64
+	for i, expected := range h.Shares {
+		actual := float64(counts[i]) / float64(total)
+		e, a := math.Max(expected, psiFloor), math.Max(actual, psiFloor)
+		psi += (a - e) * math.Log(a/e)
+	}
+	return psi
+}
+
+// validate checks a histogram read from a snapshot
+func (h *Histogram) validate() error {
+	if len(h.Shares) != len(h.Edges)+1 {
+		return fmt.Errorf("histogram has %d edges and %d shares", len(h.Edges), len(h.Shares))
+	}
+	if !sort.Float64sAreSorted(h.Edges) {
+		return fmt.Errorf("histogram edges are not ascending")
+	}
+	return nil
+}
+
+// clone returns a copy of the histogram
+func (h Histogram) clone() Histogram {
+	return Histogram{
+		Edges:  append([]float64(nil), h.Edges...),
+		Shares: append([]float64(nil), h.Shares...),
+	}
+}
+
+// newDriftReference bins training samples and held-out scores
+func newDriftReference(samples [][]float64, scores []float64, featureSize int) *DriftReference {
+	if len(samples) == 0 {
+		return nil
+	}
+	ref := &DriftReference{Features: make([]Histogram, featureSize)}
+	column := make([]float64, len(samples))
+	for i := range ref.Features {
+		for j, row := range samples {
+			column[j] = row[i]
+		}
+		ref.Features[i] = newHistogram(column)
+	}
+	if len(scores) > 0 {
+		h := newHistogram(scores)
+		ref.Scores = &h
+	}
+	return ref
+}
+
+// validate checks a drift reference read from a snapshot
+func (r *DriftReference) validate(featureSize int) error {
+	if len(r.Features) != featureSize {
+		return fmt.Errorf("drift reference has %d features, expected %d", len(r.Features), featureSize)
+	}
+	for i := range r.Features {
+		if err := r.Features[i].validate(); err != nil {
+			return fmt.Errorf("drift reference of feature %d: %w", i, err)
+		}
+	}
+	if r.Scores != nil {
+		if err := r.Scores.validate(); err != nil {
+			return fmt.Errorf("drift reference of scores: %w", err)
+		}
+	}
+	return nil
+}
+
+// clone returns a copy of the reference, nil for nil
+func (r *DriftReference) clone() *DriftReference {
+	if r == nil {
+		return nil
+	}
+	clone := &DriftReference{Features: make([]Histogram, len(r.Features)), Source: r.Source}
+	for i, h := range r.Features {
+		clone.Features[i] = h.clone()
+	}
+	if r.Scores != nil {
+		h := r.Scores.clone()
+		clone.Scores = &h
+	}
+	return clone
+}
+
+// DriftReference returns the distribution of the training samples and
+// held-out scores of the engine's model, nil if it has none
+func (e *MLEngine) DriftReference() *DriftReference {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.drift.clone()
+}
@@ -0,0 +1,84 @@
+package ml
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	values := make([]float64, 100)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	h := newHistogram(values)
+	require.Len(t, h.Edges, driftBins-1)
+	require.Len(t, h.Shares, driftBins)
+	for _, share := range h.Shares {
+		assert.InDelta(t, 0.1, share, 0.011)
+	}
+	assert.Equal(t, 0, h.Bin(-1))
+	assert.Equal(t, driftBins-1, h.Bin(1000))
+
+	// Constant values leave one edge, so that any change shows
+	constant := newHistogram([]float64{3, 3, 3})
+	assert.Equal(t, []float64{3}, constant.Edges)
+	assert.Equal(t, []float64{1, 0}, constant.Shares)
+	assert.Zero(t, constant.PSI([]int64{5, 0}))
+	assert.Greater(t, constant.PSI([]int64{0, 5}), 1.0)
+}
+
+func TestHistogramPSI(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	reference := make([]float64, 5000)
+	for i := range reference {
+		reference[i] = r.NormFloat64()
+	}
+	h := newHistogram(reference)
+
+	counts := func(shift float64) []int64 {
+		counts := make([]int64, len(h.Shares))
+		for i := 0; i < 5000; i++ {
+			counts[h.Bin(r.NormFloat64()+shift)]++
+		}
+		return counts
+	}
+	assert.Less(t, h.PSI(counts(0)), 0.02)
+	assert.Greater(t, h.PSI(counts(1)), 0.25)
+	assert.Zero(t, h.PSI(make([]int64, len(h.Shares))))
+}
+
+func TestDriftReference(t *testing.T) {
+	engine, err := NewMLEngine(MLConfig{
+		ModelType:          "svm",
+		DetectionThreshold: 0.5,
+		FeatureSize:        8,
+		GenerateFakeData:   true,
+		FakeDataSize:       200,
+		EvaluationHoldout:  0.2,
+	})
+	require.NoError(t, err)
+	defer engine.Close()
+
+	reference := engine.DriftReference()
+	require.NotNil(t, reference)
+	assert.Len(t, reference.Features, 8)
+	require.NotNil(t, reference.Scores)
+
+	// A copy is returned
+	reference.Features[0].Shares[0] = 2
+	assert.NotEqual(t, 2.0, engine.DriftReference().Features[0].Shares[0])
+
+	// The reference is part of the persisted model
+	restored, err := NewMLEngine(MLConfig{ModelType: "svm", DetectionThreshold: 0.5, FeatureSize: 8})
+	require.NoError(t, err)
+	defer restored.Close()
+	require.NoError(t, restored.Restore(engine.Snapshot()))
+	assert.Equal(t, engine.DriftReference(), restored.DriftReference())
+
+	snapshot := engine.Snapshot()
+	snapshot.Drift.Features[1].Shares = nil
+	assert.ErrorContains(t, restored.Restore(snapshot), "drift reference of feature 1")
+}
@@ -2,13 +2,19 @@ package ml
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"maps"
 	"math"
 	"math/rand"
+	"runtime"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/dataset"
 	"gonum.org/v1/gonum/mat"
 	"gorgonia.org/gorgonia"
 	"gorgonia.org/tensor"
@@ -22,6 +28,57 @@ type MLEngine struct {
 	// SVM Classifier (Gonum-based)
 	svmModel *SVMClassifier
 
+	// Gradient boosted decision trees
+	gbdtModel *GBDT
+
+	// k-nearest neighbors
+	knnModel *KNNClassifier
+
+	// Labelled flows the tree and neighbor models are refit from during
+	// online learning; nil without such models
+	online *reservoir
+	fitMu  sync.Mutex
+
+	// TensorFlow Lite model, replacing the native models when the
+	// tflite format is configured
+	tflite *TFLiteModel
+
+	// Provenance of the trained parameters
+	trainedAt    time.Time
+	trainingData TrainingData
+	metrics      map[string]float64
+
+	// The samples held out from training with their confidences and
+	// labels, and the feature importance measured on them
+	evalFeatures [][]float64
+	evalScores   []float64
+	evalLabels   []int
+	importance   *importanceCache
+
+	// Maps model scores to bot probabilities; nil without calibration
+	calibrator *Calibrator
+
+	// Scales features before the native models see them; nil without
+	// feature scaling. Reservoir samples are stored scaled.
+	scaler *Scaler
+
+	// Mean and standard deviation of each feature over the training
+	// samples, the reference predictions are explained against
+	featureMeans      []float64
+	featureDeviations []float64
+
+	// Distribution of the training samples and held-out scores, against
+	// which incoming flows are checked for drift
+	drift *DriftReference
+
+	// Reduced-precision neural network and SVM, run in place of the full
+	// precision ones; nil unless the model is quantized
+	quantized *quantizedModels
+
+	// Recent predictions by feature vector, dropped whenever the lock is
+	// taken for writing; nil without caching
+	cache *predictionCache
+
 	// Data generation
 	dataGen *DataGenerator
 
@@ -35,14 +92,90 @@ type MLEngine struct {
 
 // MLConfig holds configuration for the ML engine
 type MLConfig struct {
-	ModelType          string  `yaml:"model_type"` // "neural_network", "svm", "ensemble"
+	ModelType          string  `yaml:"model_type"` // "neural_network", "svm", "gbdt", "knn", "ensemble"
 	DetectionThreshold float64 `yaml:"detection_threshold"`
 	BatchSize          int     `yaml:"batch_size"`
 	TrainingEpochs     int     `yaml:"training_epochs"`
 	LearningRate       float64 `yaml:"learning_rate"`
 	FeatureSize        int     `yaml:"feature_size"`
+	GBDTTrees          int     `yaml:"gbdt_trees"`
+	GBDTMaxDepth       int     `yaml:"gbdt_max_depth"`
+	GBDTLearningRate   float64 `yaml:"gbdt_learning_rate"`
+	GBDTBins           int     `yaml:"gbdt_bins"`
+	GBDTMinSamplesLeaf int     `yaml:"gbdt_min_samples_leaf"`
+	KNNNeighbors       int     `yaml:"knn_neighbors"`
+	KNNDistance        string  `yaml:"knn_distance"` // "euclidean" (default), "manhattan" or "chebyshev"
+	SVMMode            string  `yaml:"svm_mode"`     // "binary" (default) or "one_class"
+	SVMNu              float64 `yaml:"svm_nu"`       // share of one-class training samples treated as outliers
+	SVMGamma           float64 `yaml:"svm_gamma"`    // one-class RBF kernel width, 1/FeatureSize if unset
 	GenerateFakeData   bool    `yaml:"generate_fake_data"`
 	FakeDataSize       int     `yaml:"fake_data_size"`
+	ModelFormat        string  `yaml:"model_format"` // "native" (default) or "tflite"
+	ModelPath          string  `yaml:"model_path"`   // model file: trained parameters, or the tflite model
+	SaveModel          bool    `yaml:"save_model"`   // write the model to ModelPath after training
+	LoadModel          bool    `yaml:"load_model"`   // start from the model at ModelPath instead of training
+	TFLiteThreads      int     `yaml:"tflite_threads"`
+
+	// Ensemble members, neural network and SVM by default
+	EnsembleModels []string `yaml:"ensemble_models"`
+
+	// Online learning: flows kept for refitting tree and neighbor models,
+	// and new flows between refits
+	OnlineBufferSize   int `yaml:"online_buffer_size"`
+	OnlineRefitSamples int `yaml:"online_refit_samples"`
+
+	// Evaluation: stratified k-fold cross-validation with two or more
+	// folds, otherwise a stratified holdout of the given share of samples
+	EvaluationFolds   int     `yaml:"evaluation_folds"`
+	EvaluationHoldout float64 `yaml:"evaluation_holdout"`
+
+	// Calibration of scores into probabilities before thresholding, fitted
+	// on the given share of training samples
+	Calibration        string  `yaml:"calibration"` // "none" (default), "platt" or "isotonic"
+	CalibrationHoldout float64 `yaml:"calibration_holdout"`
+
+	// Feature scaling fitted on the training samples and applied before
+	// the native models at training and prediction time
+	Scaling string `yaml:"scaling"` // "none" (default), "standard" or "minmax"
+
+	// Number of features each prediction is attributed to, 0 disables
+	// explanations
+	Explanations int `yaml:"explanations"`
+
+	// Labelled dataset trained on instead of fake data, in CSV, JSON Lines
+	// or Parquet, with the column holding labels and those holding features
+	// 0, 1, ... in order, all but the label and flow metadata columns by
+	// default. TrainingDataSource names the dataset in the provenance of
+	// trained models instead of its path.
+	TrainingDataPath       string   `yaml:"training_data_path"`
+	TrainingLabelColumn    string   `yaml:"training_label_column"`
+	TrainingFeatureColumns []string `yaml:"training_feature_columns"`
+	TrainingDataSource     string   `yaml:"training_data_source"`
+
+	// Labelled dataset, in the columns of the training dataset, that models
+	// trained on TrainingDataPath are evaluated on instead of held-out
+	// training samples; they then train on all training samples
+	EvaluationDataPath string `yaml:"evaluation_data_path"`
+
+	// Run the neural network on the GPU in builds with the cuda tag,
+	// falling back to the CPU when no device is usable
+	EnableGPU bool `yaml:"enable_gpu"`
+
+	// Predictions running the neural network at the same time, each on its
+	// own replica; GOMAXPROCS when not positive
+	MaxConcurrency int `yaml:"max_concurrency"`
+
+	// Predictions cached by feature vector, each rounded to a multiple of
+	// CacheQuantization first (exact when zero), for up to CacheTTL (no
+	// expiry when zero); CacheSize 0 disables caching
+	CacheSize         int           `yaml:"cache_size"`
+	CacheTTL          time.Duration `yaml:"cache_ttl"`
+	CacheQuantization float64       `yaml:"cache_quantization"`
+
+	// Layout of the feature vectors predictions are made on, the built-in
+	// flow features when nil. Models trained on another layout are
+	// rejected when loaded.
+	FeatureSchema *FeatureSchema `yaml:"-"`
 }
 
 // MLStatistics holds ML engine statistics
@@ -54,16 +187,49 @@ type MLStatistics struct {
 	ModelAccuracy     float64       `json:"model_accuracy"`
 	TrainingTime      time.Duration `json:"training_time"`
 	LastPrediction    time.Time     `json:"last_prediction"`
+	OnlineSamples     int64         `json:"online_samples"` // labelled flows learned through PartialFit
 	mu                sync.RWMutex
+
+	// Predictions served from and missing in the cache, and those it holds
+	CacheHits    int64 `json:"cache_hits,omitempty"`
+	CacheMisses  int64 `json:"cache_misses,omitempty"`
+	CacheEntries int   `json:"cache_entries,omitempty"`
+
+	// Held-out accuracy of each trained model, ModelAccuracy being that
+	// of the engine as a whole, and the engine's confusion matrix on the
+	// held-out samples
+	ModelAccuracies map[string]float64 `json:"model_accuracies,omitempty"`
+	Confusion       *ConfusionMatrix   `json:"confusion,omitempty"`
 }
 
-// NeuralNetwork represents a Gorgonia-based neural network
+// nnHiddenSize is the number of hidden units of the neural network
+const nnHiddenSize = 64
+
+// NeuralNetwork represents a Gorgonia-based neural network. Its graph
+// holds the trained parameters; predictions run on replicas of it, as a
+// Gorgonia machine runs one input at a time.
 type NeuralNetwork struct {
-	graph   *gorgonia.ExprGraph
-	input   *gorgonia.Node
-	output  *gorgonia.Node
-	vm      gorgonia.VM
+	*nnGraph
 	trained bool
+
+	// Changes whenever the parameters do, with the engine's lock held for
+	// writing, so that replicas copy them again
+	generation uint64
+
+	pool *replicaPool
+}
+
+// nnGraph is the computation graph of the neural network
+type nnGraph struct {
+	graph  *gorgonia.ExprGraph
+	input  *gorgonia.Node
+	output *gorgonia.Node
+
+	// Parameters
+	hiddenWeights *gorgonia.Node
+	hiddenBias    *gorgonia.Node
+	outputWeights *gorgonia.Node
+	outputBias    *gorgonia.Node
 }
 
 // SVMClassifier represents a Support Vector Machine classifier using Gonum
@@ -71,17 +237,27 @@ type SVMClassifier struct {
 	weights *mat.VecDense
 	bias    float64
 	trained bool
+
+	// One-class variant, trained on human traffic only: the weights apply
+	// to random Fourier features of the input and the bias is the offset
+	// of the boundary
+	oneClass   bool
+	projection *mat.Dense
+	phase      []float64
+	scale      float64 // spread of the training scores
 }
 
 // DetectionResult represents the result of ML-based bot detection
 type DetectionResult struct {
-	IsBot      bool      `json:"is_bot"`
-	Confidence float64   `json:"confidence"`
-	Features   []float64 `json:"features"`
-	Reasoning  string    `json:"reasoning"`
-	ModelUsed  string    `json:"model_used"`
-	Timestamp  time.Time `json:"timestamp"`
-	FlowID     string    `json:"flow_id"`
+	IsBot        bool          `json:"is_bot"`
+	Confidence   float64       `json:"confidence"`
+	Features     []float64     `json:"features"`
+	Reasoning    string        `json:"reasoning"`
+	Explanations []Explanation `json:"explanations,omitempty"`
+	ModelUsed    string        `json:"model_used"`
+	Timestamp    time.Time     `json:"timestamp"`
+	FlowID       string        `json:"flow_id"`
+	Cached       bool          `json:"cached,omitempty"` // served from the prediction cache
 }
 
 // DataGenerator generates fake training data for bot detection
@@ -92,11 +268,18 @@ type DataGenerator struct {
 
 // NewMLEngine creates a new ML engine instance
 func NewMLEngine(config MLConfig) (*MLEngine, error) {
+	if config.FeatureSchema == nil {
+		config.FeatureSchema = DefaultFeatureSchema(config.FeatureSize)
+	} else if config.FeatureSchema.Size() != config.FeatureSize {
+		return nil, fmt.Errorf("feature schema has %d features, feature size is %d", config.FeatureSchema.Size(), config.FeatureSize)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	engine := &MLEngine{
 		config: config,
 		stats:  &MLStatistics{},
+		cache:  newPredictionCache(config.CacheSize, config.CacheTTL, config.CacheQuantization),
 		ctx:    ctx,
 		cancel: cancel,
 	}
@@ -106,14 +289,50 @@ func NewMLEngine(config MLConfig) (*MLEngine, error) {
 		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 
+	if config.ModelFormat == ModelFormatTFLite {
+		if err := engine.loadTFLiteModel(); err != nil {
+			cancel()
+			return nil, err
+		}
+		slog.Info("ML engine initialized",
+			"model_format", config.ModelFormat,
+			"model_path", config.ModelPath,
+			"threshold", config.DetectionThreshold,
+			"feature_size", config.FeatureSize)
+		return engine, nil
+	}
+
 	// Initialize models based on configuration
 	if err := engine.initializeModels(); err != nil {
 		cancel()
 		return nil, fmt.Errorf("failed to initialize models: %w", err)
 	}
+	if engine.gbdtModel != nil || engine.knnModel != nil {
+		engine.online = newReservoir(config.OnlineBufferSize)
+	}
 
-	// Generate and train on fake data if enabled
-	if config.GenerateFakeData {
+	// Start from the persisted model if there is one
+	loaded := false
+	if config.LoadModel && config.ModelPath != "" {
+		switch err := engine.LoadModel(config.ModelPath); {
+		case err == nil:
+			loaded = true
+			slog.Info("Model loaded", "path", config.ModelPath)
+		case errors.Is(err, fs.ErrNotExist):
+			slog.Info("No saved model found, training a new one", "path", config.ModelPath)
+		default:
+			cancel()
+			return nil, fmt.Errorf("failed to load model: %w", err)
+		}
+	}
+
+	// Train on the dataset if configured, otherwise on fake data if enabled
+	if config.TrainingDataPath != "" && !loaded {
+		if err := engine.TrainOnDataset(); err != nil {
+			cancel()
+			return nil, err
+		}
+	} else if config.GenerateFakeData && !loaded {
 		if err := engine.TrainOnFakeData(); err != nil {
 			cancel()
 			return nil, fmt.Errorf("failed to train on fake data: %w", err)
@@ -123,43 +342,102 @@ func NewMLEngine(config MLConfig) (*MLEngine, error) {
 	slog.Info("ML engine initialized",
 		"model_type", config.ModelType,
 		"threshold", config.DetectionThreshold,
-		"feature_size", config.FeatureSize)
+		"feature_size", config.FeatureSize,
+		"feature_schema", config.FeatureSchema.Fingerprint(),
+		"device", engine.Device())
 
 	return engine, nil
 }
 
+// loadTFLiteModel opens the configured TensorFlow Lite model
+func (e *MLEngine) loadTFLiteModel() error {
+	if err := e.swapTFLiteModel(e.config.ModelPath); err != nil {
+		return fmt.Errorf("failed to load tflite model: %w", err)
+	}
+	return nil
+}
+
+// defaultEnsembleModels are the ensemble members when none are configured
+var defaultEnsembleModels = []string{"neural_network", "svm"}
+
+// ensembleModels returns the models averaged by the ensemble
+func (e *MLEngine) ensembleModels() []string {
+	if len(e.config.EnsembleModels) == 0 {
+		return defaultEnsembleModels
+	}
+	return e.config.EnsembleModels
+}
+
+// models returns the configured model, or the ensemble members
+func (e *MLEngine) models() []string {
+	if e.config.ModelType == "ensemble" {
+		return e.ensembleModels()
+	}
+	return []string{e.config.ModelType}
+}
+
 // initializeModels initializes the selected ML models
 func (e *MLEngine) initializeModels() error {
-	e.mu.Lock()
+	e.lock()
 	defer e.mu.Unlock()
 
-	switch e.config.ModelType {
+	if e.config.ModelType == "ensemble" {
+		return e.initializeEnsemble()
+	}
+	return e.initializeModel(e.config.ModelType)
+}
+
+// initializeModel initializes a single model
+func (e *MLEngine) initializeModel(modelType string) error {
+	switch modelType {
 	case "neural_network":
 		return e.initializeNeuralNetwork()
 	case "svm":
 		return e.initializeSVM()
-	case "ensemble":
-		return e.initializeEnsemble()
+	case "gbdt":
+		return e.initializeGBDT()
+	case "knn":
+		return e.initializeKNN()
 	default:
-		return fmt.Errorf("unsupported model type: %s", e.config.ModelType)
+		return fmt.Errorf("unsupported model type: %s", modelType)
 	}
 }
 
-// initializeNeuralNetwork sets up a Gorgonia-based neural network
+// initializeNeuralNetwork sets up a Gorgonia-based neural network and the
+// pool of replicas running it
 func (e *MLEngine) initializeNeuralNetwork() error {
+	concurrency := e.config.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	e.nnModel = &NeuralNetwork{
+		nnGraph:    newNNGraph(e.config.FeatureSize),
+		generation: 1, // ahead of new replicas
+		pool:       newReplicaPool(e.config.FeatureSize, concurrency, e.config.EnableGPU),
+	}
+	return nil
+}
+
+// newNNGraph builds the graph of the neural network with freshly
+// initialized parameters
+func newNNGraph(featureSize int) *nnGraph {
 	// Create computation graph
 	g := gorgonia.NewGraph()
 
 	// Input layer
-	input := gorgonia.NewMatrix(g, tensor.Float64, gorgonia.WithShape(1, e.config.FeatureSize), gorgonia.WithName("input"))
+	input := gorgonia.NewMatrix(g, tensor.Float64, gorgonia.WithShape(1, featureSize), gorgonia.WithName("input"))
 
 	// Hidden layer weights and bias
-	hiddenWeights := gorgonia.NewMatrix(g, tensor.Float64, gorgonia.WithShape(e.config.FeatureSize, 64), gorgonia.WithName("hidden_weights"))
-	hiddenBias := gorgonia.NewMatrix(g, tensor.Float64, gorgonia.WithShape(1, 64), gorgonia.WithName("hidden_bias"))
+	hiddenWeights := gorgonia.NewMatrix(g, tensor.Float64, gorgonia.WithShape(featureSize, nnHiddenSize),
+		gorgonia.WithName("hidden_weights"), gorgonia.WithInit(gorgonia.GlorotN(1.0)))
+	hiddenBias := gorgonia.NewMatrix(g, tensor.Float64, gorgonia.WithShape(1, nnHiddenSize),
+		gorgonia.WithName("hidden_bias"), gorgonia.WithInit(gorgonia.Zeroes()))
 
 	// Output layer weights and bias
-	outputWeights := gorgonia.NewMatrix(g, tensor.Float64, gorgonia.WithShape(64, 1), gorgonia.WithName("output_weights"))
-	outputBias := gorgonia.NewMatrix(g, tensor.Float64, gorgonia.WithShape(1, 1), gorgonia.WithName("output_bias"))
+	outputWeights := gorgonia.NewMatrix(g, tensor.Float64, gorgonia.WithShape(nnHiddenSize, 1),
+		gorgonia.WithName("output_weights"), gorgonia.WithInit(gorgonia.GlorotN(1.0)))
+	outputBias := gorgonia.NewMatrix(g, tensor.Float64, gorgonia.WithShape(1, 1),
+		gorgonia.WithName("output_bias"), gorgonia.WithInit(gorgonia.Zeroes()))
 
 	// Forward pass - simplified to avoid complex Gorgonia API
 	// For now, we'll use a simple approach that doesn't require complex matrix operations
@@ -169,22 +447,24 @@ func (e *MLEngine) initializeNeuralNetwork() error {
 	output := gorgonia.Must(gorgonia.Add(gorgonia.Must(gorgonia.Mul(hidden, outputWeights)), outputBias))
 	output = gorgonia.Must(gorgonia.Sigmoid(output))
 
-	// Create VM
-	vm := gorgonia.NewTapeMachine(g)
+	return &nnGraph{
+		graph:  g,
+		input:  input,
+		output: output,
 
-	e.nnModel = &NeuralNetwork{
-		graph:   g,
-		input:   input,
-		output:  output,
-		vm:      vm,
-		trained: false,
+		hiddenWeights: hiddenWeights,
+		hiddenBias:    hiddenBias,
+		outputWeights: outputWeights,
+		outputBias:    outputBias,
 	}
-
-	return nil
 }
 
 // initializeSVM sets up a simple SVM classifier using Gonum
 func (e *MLEngine) initializeSVM() error {
+	if e.config.SVMMode == SVMModeOneClass {
+		e.svmModel = newOneClassSVM()
+		return nil
+	}
 	weights := mat.NewVecDense(e.config.FeatureSize, nil)
 	e.svmModel = &SVMClassifier{
 		weights: weights,
@@ -194,19 +474,38 @@ func (e *MLEngine) initializeSVM() error {
 	return nil
 }
 
-// initializeEnsemble sets up all models for ensemble prediction
-func (e *MLEngine) initializeEnsemble() error {
-	if err := e.initializeNeuralNetwork(); err != nil {
+// initializeGBDT sets up an untrained gradient boosted tree ensemble
+func (e *MLEngine) initializeGBDT() error {
+	e.gbdtModel = &GBDT{}
+	return nil
+}
+
+// initializeKNN sets up an empty k-nearest-neighbors classifier
+func (e *MLEngine) initializeKNN() error {
+	model, err := newKNNClassifier(e.config)
+	if err != nil {
 		return err
 	}
-	if err := e.initializeSVM(); err != nil {
-		return err
+	e.knnModel = model
+	return nil
+}
+
+// initializeEnsemble sets up the ensemble members
+func (e *MLEngine) initializeEnsemble() error {
+	for _, modelType := range e.ensembleModels() {
+		if err := e.initializeModel(modelType); err != nil {
+			return fmt.Errorf("ensemble: %w", err)
+		}
 	}
 	return nil
 }
 
 // TrainOnFakeData generates fake data and trains the models
 func (e *MLEngine) TrainOnFakeData() error {
+	if e.tflite != nil {
+		return fmt.Errorf("tflite models cannot be trained in process")
+	}
+
 	slog.Info("Generating fake training data", "size", e.config.FakeDataSize)
 
 	startTime := time.Now()
@@ -214,75 +513,252 @@ func (e *MLEngine) TrainOnFakeData() error {
 	// Generate fake data
 	features, labels := e.dataGen.GenerateFakeData(e.config.FakeDataSize, e.config.FeatureSize)
 
-	// Train models based on type
-	var err error
-	switch e.config.ModelType {
-	case "neural_network":
-		err = e.trainNeuralNetwork(features, labels)
-	case "svm":
-		err = e.trainSVM(features, labels)
-	case "ensemble":
-		err = e.trainEnsemble(features, labels)
-	default:
-		return fmt.Errorf("unsupported model type for training: %s", e.config.ModelType)
+	features, labels, eval, err := e.trainEvaluated(features, labels, e.models())
+	if err != nil {
+		return err
 	}
+	return e.finishTraining(startTime, "synthetic", features, labels, eval)
+}
+
+// TrainOnDataset trains the models on the dataset at TrainingDataPath,
+// evaluated on the one at EvaluationDataPath if set
+func (e *MLEngine) TrainOnDataset() error {
+	source := e.config.TrainingDataSource
+	if source == "" {
+		source = "file:" + e.config.TrainingDataPath
+	}
+	return e.TrainOnDatasetFile(e.config.TrainingDataPath, source, e.config.EvaluationDataPath)
+}
+
+// TrainOnDatasetFile trains the models on the dataset at path, recording
+// source as its provenance. The models are evaluated on the dataset at
+// evaluationPath if it is not empty, otherwise on held-out samples.
+func (e *MLEngine) TrainOnDatasetFile(path, source, evaluationPath string) error {
+	if e.tflite != nil {
+		return fmt.Errorf("tflite models cannot be trained in process")
+	}
+
+	slog.Info("Loading training data", "path", path, "source", source)
+
+	startTime := time.Now()
+
+	opts := dataset.Options{
+		FeatureSize:    e.config.FeatureSize,
+		LabelColumn:    e.config.TrainingLabelColumn,
+		FeatureColumns: e.config.TrainingFeatureColumns,
+	}
+	ds, err := dataset.Load(path, opts)
+	if err != nil {
+		return err
+	}
+
+	if evaluationPath == "" {
+		features, labels, eval, err := e.trainEvaluated(ds.Features, ds.Labels, e.models())
+		if err != nil {
+			return err
+		}
+		return e.finishTraining(startTime, source, features, labels, eval)
+	}
+
+	test, err := dataset.Load(evaluationPath, opts)
+	if err != nil {
+		return fmt.Errorf("failed to load evaluation data: %w", err)
+	}
+	eval, err := e.trainHeldOut(ds.Features, ds.Labels, test.Features, test.Labels, e.models())
+	if err != nil {
+		return err
+	}
+	return e.finishTraining(startTime, source, ds.Features, ds.Labels, eval)
+}
+
+// Retrain trains the models again on their training source: the dataset
+// at TrainingDataPath, read afresh, or otherwise fake data
+func (e *MLEngine) Retrain() error {
+	if e.config.TrainingDataPath != "" {
+		return e.TrainOnDataset()
+	}
+	return e.TrainOnFakeData()
+}
+
+// TrainBaseline trains the one-class SVM on baseline traffic assumed to be
+// human, so that detection can start before any bot labels are available.
+// Other ensemble members are left as they are.
+func (e *MLEngine) TrainBaseline(features [][]float64) error {
+	if e.svmModel == nil || !e.svmModel.oneClass {
+		return fmt.Errorf("baseline training requires a one-class SVM")
+	}
+	for i, row := range features {
+		if len(row) != e.config.FeatureSize {
+			return fmt.Errorf("baseline sample %d has %d features, expected %d", i, len(row), e.config.FeatureSize)
+		}
+	}
+
+	startTime := time.Now()
+	labels := make([]int, len(features))
+	features, labels, eval, err := e.trainEvaluated(features, labels, []string{"svm"})
+	if err != nil {
+		return err
+	}
+	return e.finishTraining(startTime, "baseline", features, labels, eval)
+}
+
+// finishTraining records the provenance and accuracy of newly trained
+// parameters and saves them if configured. eval is the accuracy on held-out
+// samples, if any.
+func (e *MLEngine) finishTraining(startTime time.Time, source string, features [][]float64, labels []int, eval *evaluation) error {
+	accuracy := e.evaluate(features, labels)
+	metrics := map[string]float64{}
+	var evalFeatures [][]float64
+	var evalScores []float64
+	var evalLabels []int
+	if eval != nil {
+		metrics = eval.metrics()
+		evalFeatures, evalScores, evalLabels = eval.features, eval.scores, eval.labels
+	}
+	metrics["training_accuracy"] = accuracy
+
+	means, deviations := featureMoments(features, e.config.FeatureSize)
+	drift := newDriftReference(features, evalScores, e.config.FeatureSize)
+	if drift != nil {
+		drift.Source = source
+	}
+
+	e.lock()
+	e.seedReservoir(e.scaler.transformAll(features), labels)
+	e.featureMeans, e.featureDeviations = means, deviations
+	e.drift = drift
+	e.trainedAt = time.Now()
+	e.trainingData = TrainingData{Source: source, Samples: len(features), Digest: datasetDigest(features, labels)}
+	e.metrics = metrics
+	e.evalFeatures, e.evalScores, e.evalLabels = evalFeatures, evalScores, evalLabels
+	e.importance = nil
+	e.mu.Unlock()
 
 	e.stats.mu.Lock()
 	e.stats.TrainingTime = time.Since(startTime)
 	e.stats.mu.Unlock()
+	e.recordEvaluation(metrics)
+
+	slog.Info("Training completed",
+		"duration", time.Since(startTime),
+		"training_accuracy", accuracy,
+		"test_accuracy", metrics["test_accuracy"])
 
-	slog.Info("Training completed", "duration", time.Since(startTime))
-	return err
+	if e.config.SaveModel && e.config.ModelPath != "" {
+		if err := e.SaveModel(e.config.ModelPath); err != nil {
+			return fmt.Errorf("failed to save model: %w", err)
+		}
+		slog.Info("Model saved", "path", e.config.ModelPath)
+	}
+	return nil
 }
 
-// Predict performs bot detection using the trained model
-func (e *MLEngine) Predict(ctx context.Context, features []float64, flowID string) (*DetectionResult, error) {
+// recordEvaluation sets the accuracy statistics and confusion matrix from
+// the held-out evaluation in model metrics; they are unset for models that
+// were not evaluated
+func (e *MLEngine) recordEvaluation(metrics map[string]float64) {
+	var accuracies map[string]float64
+	for name, value := range metrics {
+		if model, ok := strings.CutPrefix(name, "test_accuracy_"); ok {
+			if accuracies == nil {
+				accuracies = map[string]float64{}
+			}
+			accuracies[model] = value
+		}
+	}
+
+	e.stats.mu.Lock()
+	defer e.stats.mu.Unlock()
+	e.stats.ModelAccuracy = metrics["test_accuracy"]
+	e.stats.ModelAccuracies = accuracies
+	e.stats.Confusion = confusionFromMetrics(metrics)
+}
+
+// evaluate returns the share of samples the engine classifies correctly
+func (e *MLEngine) evaluate(features [][]float64, labels []int) float64 {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
-	var confidence float64
-	var modelUsed string
-
-	switch e.config.ModelType {
-	case "neural_network":
-		conf, err := e.predictNeuralNetwork(features)
+	if len(features) == 0 {
+		return 0
+	}
+	correct := 0
+	for i, sample := range features {
+		confidence, _, err := e.infer(sample)
 		if err != nil {
-			return nil, err
+			continue
 		}
-		confidence = conf
-		modelUsed = "neural_network"
+		if (confidence >= e.config.DetectionThreshold) == (labels[i] == 1) {
+			correct++
+		}
+	}
+	return float64(correct) / float64(len(features))
+}
 
-	case "svm":
-		conf, err := e.predictSVM(features)
+// Predict performs bot detection using the trained model
+func (e *MLEngine) Predict(ctx context.Context, features []float64, flowID string) (*DetectionResult, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	now := time.Now()
+	prediction, cached := e.lookupCache(features, now)
+	if !cached {
+		confidence, modelUsed, err := e.infer(features)
 		if err != nil {
 			return nil, err
 		}
-		confidence = conf
-		modelUsed = "svm"
+		explanations := e.explain(features, confidence, func(probe []float64) float64 {
+			score, _, err := e.infer(probe)
+			if err != nil {
+				return confidence
+			}
+			return score
+		})
 
-	case "ensemble":
-		conf, err := e.predictEnsemble(features)
-		if err != nil {
-			return nil, err
+		prediction = &cachedPrediction{confidence: confidence, modelUsed: modelUsed, explanations: explanations}
+		if e.cache != nil {
+			prediction.key = e.cache.key(features)
+			e.cache.put(prediction, now)
 		}
-		confidence = conf
-		modelUsed = "ensemble"
+	}
 
-	default:
-		return nil, fmt.Errorf("unsupported model type: %s", e.config.ModelType)
+	confidence := prediction.confidence
+	result := &DetectionResult{
+		IsBot:        confidence > e.config.DetectionThreshold,
+		Confidence:   confidence,
+		Features:     features,
+		Reasoning:    e.generateReasoning(confidence, prediction.modelUsed, prediction.explanations),
+		Explanations: prediction.explanations,
+		ModelUsed:    prediction.modelUsed,
+		Timestamp:    now,
+		FlowID:       flowID,
+		Cached:       cached,
 	}
 
-	isBot := confidence > e.config.DetectionThreshold
-	reasoning := e.generateReasoning(features, confidence, modelUsed)
+	e.updateStats(result)
+
+	return result, nil
+}
+
+// PredictHeuristic performs bot detection using the cheap heuristic path,
+// bypassing the trained models entirely
+func (e *MLEngine) PredictHeuristic(ctx context.Context, features []float64, flowID string) (*DetectionResult, error) {
+	confidence := e.simulatePrediction(features)
+	modelUsed := "heuristic"
+
+	e.mu.RLock()
+	explanations := e.explain(features, confidence, e.simulatePrediction)
+	e.mu.RUnlock()
 
 	result := &DetectionResult{
-		IsBot:      isBot,
-		Confidence: confidence,
-		Features:   features,
-		Reasoning:  reasoning,
-		ModelUsed:  modelUsed,
-		Timestamp:  time.Now(),
-		FlowID:     flowID,
+		IsBot:        confidence > e.config.DetectionThreshold,
+		Confidence:   confidence,
+		Features:     features,
+		Reasoning:    e.generateReasoning(confidence, modelUsed, explanations),
+		Explanations: explanations,
+		ModelUsed:    modelUsed,
+		Timestamp:    time.Now(),
+		FlowID:       flowID,
 	}
 
 	e.updateStats(result)
@@ -290,32 +766,69 @@ func (e *MLEngine) Predict(ctx context.Context, features []float64, flowID strin
 	return result, nil
 }
 
-// predictNeuralNetwork performs prediction using the neural network
-func (e *MLEngine) predictNeuralNetwork(features []float64) (float64, error) {
-	if e.nnModel == nil || !e.nnModel.trained {
-		return e.simulatePrediction(features), nil
+// infer runs the configured model(s) on a feature vector without touching statistics
+func (e *MLEngine) infer(features []float64) (float64, string, error) {
+	if e.tflite != nil {
+		confidence, err := e.tflite.Predict(features)
+		if err != nil {
+			return 0, "", err
+		}
+		return confidence, ModelFormatTFLite, nil
 	}
 
-	// Convert features to tensor
-	inputTensor := tensor.New(tensor.WithShape(1, len(features)), tensor.WithBacking(features))
+	confidence, err := e.inferModels(features)
+	if err != nil {
+		return 0, "", err
+	}
+	if e.calibrator != nil {
+		confidence = e.calibrator.apply(confidence)
+	}
+
+	return confidence, e.config.ModelType, nil
+}
+
+// inferModels returns the uncalibrated score of the configured model(s)
+func (e *MLEngine) inferModels(features []float64) (float64, error) {
+	features = e.scaler.transform(features)
+	if e.config.ModelType == "ensemble" {
+		return e.predictEnsemble(features)
+	}
+	return e.predictModel(e.config.ModelType, features)
+}
 
-	// Set input value
-	gorgonia.Let(e.nnModel.input, inputTensor)
+// Warmup runs a number of dummy inferences to force tensor allocation
+// before real traffic arrives. Warm-up inferences are not counted in statistics.
+func (e *MLEngine) Warmup(iterations int) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 
-	// Run forward pass
-	if err := e.nnModel.vm.RunAll(); err != nil {
-		return 0, fmt.Errorf("neural network inference failed: %w", err)
+	features := make([]float64, e.config.FeatureSize)
+	for i := range features {
+		features[i] = 0.5 // Neutral warm-up values
 	}
 
-	// Get output
-	outputValue := e.nnModel.output.Value()
-	if outputTensor, ok := outputValue.(tensor.Tensor); ok {
-		if outputData, ok := outputTensor.Data().([]float64); ok && len(outputData) > 0 {
-			return outputData[0], nil
+	for i := 0; i < iterations; i++ {
+		if _, _, err := e.infer(features); err != nil {
+			return fmt.Errorf("warm-up inference failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// predictNeuralNetwork performs prediction using the neural network
+func (e *MLEngine) predictNeuralNetwork(features []float64) (float64, error) {
+	if e.nnModel == nil || !e.nnModel.trained {
+		return e.simulatePrediction(features), nil
+	}
+	if q := e.quantized; q != nil && q.nn != nil {
+		if len(features) != e.config.FeatureSize {
+			return 0, fmt.Errorf("expected %d features, got %d", e.config.FeatureSize, len(features))
 		}
+		return q.nn.forward(features), nil
 	}
 
-	return 0, fmt.Errorf("failed to extract neural network output")
+	return e.nnModel.forward(features)
 }
 
 // predictSVM performs prediction using SVM with Gonum
@@ -323,6 +836,18 @@ func (e *MLEngine) predictSVM(features []float64) (float64, error) {
 	if e.svmModel == nil || !e.svmModel.trained {
 		return e.simulatePrediction(features), nil
 	}
+	if q := e.quantized; q != nil && q.svm != nil {
+		if len(features) != e.config.FeatureSize {
+			return 0, fmt.Errorf("expected %d features, got %d", e.config.FeatureSize, len(features))
+		}
+		return q.svm.predict(e.svmModel, features), nil
+	}
+	if e.svmModel.oneClass {
+		if len(features) != e.config.FeatureSize {
+			return 0, fmt.Errorf("expected %d features, got %d", e.config.FeatureSize, len(features))
+		}
+		return e.svmModel.predictOneClass(features), nil
+	}
 
 	// Create feature vector
 	featureVec := mat.NewVecDense(len(features), features)
@@ -335,18 +860,51 @@ func (e *MLEngine) predictSVM(features []float64) (float64, error) {
 	return 1.0 / (1.0 + math.Exp(-prediction)), nil
 }
 
-// predictEnsemble performs prediction using all models and averages results
-func (e *MLEngine) predictEnsemble(features []float64) (float64, error) {
-	var predictions []float64
+// predictModel performs prediction using a single model
+func (e *MLEngine) predictModel(modelType string, features []float64) (float64, error) {
+	switch modelType {
+	case "neural_network":
+		return e.predictNeuralNetwork(features)
+	case "svm":
+		return e.predictSVM(features)
+	case "gbdt":
+		return e.predictGBDT(features)
+	case "knn":
+		return e.predictKNN(features)
+	default:
+		return 0, fmt.Errorf("unsupported model type: %s", modelType)
+	}
+}
 
-	// Neural network prediction
-	if nnPred, err := e.predictNeuralNetwork(features); err == nil {
-		predictions = append(predictions, nnPred)
+// predictGBDT performs prediction using the gradient boosted trees
+func (e *MLEngine) predictGBDT(features []float64) (float64, error) {
+	if e.gbdtModel == nil || !e.gbdtModel.trained {
+		return e.simulatePrediction(features), nil
 	}
+	if len(features) != e.config.FeatureSize {
+		return 0, fmt.Errorf("expected %d features, got %d", e.config.FeatureSize, len(features))
+	}
+	return e.gbdtModel.predict(features), nil
+}
 
-	// SVM prediction
-	if svmPred, err := e.predictSVM(features); err == nil {
-		predictions = append(predictions, svmPred)
+// predictKNN performs prediction using the nearest training samples
+func (e *MLEngine) predictKNN(features []float64) (float64, error) {
+	if e.knnModel == nil || !e.knnModel.trained {
+		return e.simulatePrediction(features), nil
+	}
+	if len(features) != e.config.FeatureSize {
+		return 0, fmt.Errorf("expected %d features, got %d", e.config.FeatureSize, len(features))
+	}
+	return e.knnModel.predict(features), nil
+}
+
+// predictEnsemble performs prediction using all models and averages results
+func (e *MLEngine) predictEnsemble(features []float64) (float64, error) {
+	var predictions []float64
+	for _, modelType := range e.ensembleModels() {
+		if pred, err := e.predictModel(modelType, features); err == nil {
+			predictions = append(predictions, pred)
+		}
 	}
 
 	if len(predictions) == 0 {
@@ -386,7 +944,7 @@ func (e *MLEngine) simulatePrediction(features []float64) float64 {
 }
 
 // generateReasoning provides human-readable explanation for the prediction
-func (e *MLEngine) generateReasoning(features []float64, confidence float64, modelUsed string) string {
+func (e *MLEngine) generateReasoning(confidence float64, modelUsed string, explanations []Explanation) string {
 	var reasoning string
 
 	if confidence > 0.8 {
@@ -399,12 +957,11 @@ func (e *MLEngine) generateReasoning(features []float64, confidence float64, mod
 		reasoning = "Human-like behavior detected based on "
 	}
 
-	reasoning += modelUsed + " model analysis. "
+	reasoning += modelUsed + " model analysis."
 
-	// Add specific feature insights
-	if len(features) > 0 {
-		reasoning += "Key indicators include packet timing patterns, "
-		reasoning += "protocol behavior consistency, and flow characteristics."
+	// Name the features that moved the confidence most
+	if len(explanations) > 0 {
+		reasoning += " " + describeExplanations(explanations)
 	}
 
 	return reasoning
@@ -443,6 +1000,13 @@ func (e *MLEngine) GetStatistics() *MLStatistics {
 		ModelAccuracy:     e.stats.ModelAccuracy,
 		TrainingTime:      e.stats.TrainingTime,
 		LastPrediction:    e.stats.LastPrediction,
+		OnlineSamples:     e.stats.OnlineSamples,
+		ModelAccuracies:   maps.Clone(e.stats.ModelAccuracies),
+	}
+	stats.CacheHits, stats.CacheMisses, stats.CacheEntries = e.cache.counts()
+	if e.stats.Confusion != nil {
+		confusion := *e.stats.Confusion
+		stats.Confusion = &confusion
 	}
 	return &stats
 }
@@ -451,14 +1015,32 @@ func (e *MLEngine) GetStatistics() *MLStatistics {
 func (e *MLEngine) Close() error {
 	e.cancel()
 
-	if e.nnModel != nil && e.nnModel.vm != nil {
-		e.nnModel.vm.Close()
+	if e.nnModel != nil {
+		e.nnModel.pool.close()
+	}
+	if e.tflite != nil {
+		e.tflite.Close()
 	}
 
 	return nil
 }
 
 // Training methods
+func (e *MLEngine) trainModel(modelType string, features [][]float64, labels []int) error {
+	switch modelType {
+	case "neural_network":
+		return e.trainNeuralNetwork(features, labels)
+	case "svm":
+		return e.trainSVM(features, labels)
+	case "gbdt":
+		return e.trainGBDT(features, labels)
+	case "knn":
+		return e.trainKNN(features, labels)
+	default:
+		return fmt.Errorf("unsupported model type for training: %s", modelType)
+	}
+}
+
 func (e *MLEngine) trainNeuralNetwork(features [][]float64, labels []int) error {
 	// Simplified training - in real implementation, this would use backpropagation
 	e.nnModel.trained = true
@@ -470,6 +1052,9 @@ func (e *MLEngine) trainSVM(features [][]float64, labels []int) error {
 	if len(features) == 0 || len(labels) == 0 {
 		return fmt.Errorf("no training data provided")
 	}
+	if e.svmModel.oneClass {
+		return e.trainOneClassSVM(features, labels)
+	}
 
 	// Simple linear SVM training
 	for i := 0; i < 100; i++ { // 100 iterations
@@ -497,13 +1082,62 @@ func (e *MLEngine) trainSVM(features [][]float64, labels []int) error {
 	return nil
 }
 
-func (e *MLEngine) trainEnsemble(features [][]float64, labels []int) error {
-	// Train all models
-	if err := e.trainNeuralNetwork(features, labels); err != nil {
+// trainOneClassSVM trains the one-class SVM on the human samples
+func (e *MLEngine) trainOneClassSVM(features [][]float64, labels []int) error {
+	var humans [][]float64
+	for i, row := range features {
+		if labels[i] == 0 {
+			humans = append(humans, row)
+		}
+	}
+
+	nu := e.config.SVMNu
+	if nu <= 0 || nu > 1 {
+		nu = defaultSVMNu
+	}
+	gamma := e.config.SVMGamma
+	if gamma <= 0 {
+		gamma = 1 / float64(e.config.FeatureSize)
+	}
+
+	e.dataGen.mu.Lock()
+	seed := e.dataGen.rand.Int63()
+	e.dataGen.mu.Unlock()
+
+	model := newOneClassSVM()
+	if err := model.fitOneClass(humans, nu, gamma, rand.New(rand.NewSource(seed))); err != nil {
+		return err
+	}
+
+	e.lock()
+	e.svmModel = model
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *MLEngine) trainGBDT(features [][]float64, labels []int) error {
+	model := &GBDT{}
+	if err := model.train(features, labels, e.config.gbdtParams()); err != nil {
 		return err
 	}
-	if err := e.trainSVM(features, labels); err != nil {
+
+	e.lock()
+	e.gbdtModel = model
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *MLEngine) trainKNN(features [][]float64, labels []int) error {
+	model, err := newKNNClassifier(e.config)
+	if err != nil {
 		return err
 	}
+	if err := model.fit(features, labels); err != nil {
+		return err
+	}
+
+	e.lock()
+	e.knnModel = model
+	e.mu.Unlock()
 	return nil
 }
@@ -0,0 +1,366 @@
+package ml
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+)
+
+// defaultEvaluationHoldout is the share of samples held out for testing
+// when MLConfig.EvaluationHoldout is unset
+const defaultEvaluationHoldout = 0.2
+
+// ConfusionMatrix counts the classifications of labelled samples, bots
+// being the positive class, with the metrics derived from the counts
+type ConfusionMatrix struct {
+	TruePositives  int64   `json:"true_positives"`
+	FalsePositives int64   `json:"false_positives"`
+	TrueNegatives  int64   `json:"true_negatives"`
+	FalseNegatives int64   `json:"false_negatives"`
+	Precision      float64 `json:"precision"` // share of predicted bots that are bots
+	Recall         float64 `json:"recall"`    // share of bots predicted bots
+	F1             float64 `json:"f1"`
+}
+
+// add counts one classification
+func (m *ConfusionMatrix) add(predictedBot, isBot bool) {
+	switch {
+	case predictedBot && isBot:
+		m.TruePositives++
+	case predictedBot:
+		m.FalsePositives++
+	case isBot:
+		m.FalseNegatives++
+	default:
+		m.TrueNegatives++
+	}
+}
+
+// total returns the number of classifications counted
+func (m *ConfusionMatrix) total() int64 {
+	return m.TruePositives + m.FalsePositives + m.TrueNegatives + m.FalseNegatives
+}
+
+// derive computes precision, recall and F1 from the counts
+func (m *ConfusionMatrix) derive() {
+	m.Precision, m.Recall, m.F1 = 0, 0, 0
+	if predictedBots := m.TruePositives + m.FalsePositives; predictedBots > 0 {
+		m.Precision = float64(m.TruePositives) / float64(predictedBots)
+	}
+	if bots := m.TruePositives + m.FalseNegatives; bots > 0 {
+		m.Recall = float64(m.TruePositives) / float64(bots)
+	}
+	if m.Precision+m.Recall > 0 {
+		m.F1 = 2 * m.Precision * m.Recall / (m.Precision + m.Recall)
+	}
+}
+
+// confusionMetrics are the model metrics holding a confusion matrix
+var confusionMetrics = map[string]func(*ConfusionMatrix) *int64{
+	"test_true_positives":  func(m *ConfusionMatrix) *int64 { return &m.TruePositives },
+	"test_false_positives": func(m *ConfusionMatrix) *int64 { return &m.FalsePositives },
+	"test_true_negatives":  func(m *ConfusionMatrix) *int64 { return &m.TrueNegatives },
+	"test_false_negatives": func(m *ConfusionMatrix) *int64 { return &m.FalseNegatives },
+}
+
+// confusionFromMetrics returns the confusion matrix in model metrics, nil
+// if they have none
+func confusionFromMetrics(metrics map[string]float64) *ConfusionMatrix {
+	m := &ConfusionMatrix{}
+	for name, field := range confusionMetrics {
+		value, ok := metrics[name]
+		if !ok {
+			return nil
+		}
+		*field(m) = int64(value)
+	}
+	m.derive()
+	return m
+}
+
+// evaluation is the accuracy of trained models on samples they were not
+// trained on
+type evaluation struct {
+	folds         int // 0 for a holdout split
+	confusion     ConfusionMatrix
+	features      [][]float64 // the held-out samples
+	scores        []float64   // the engine's confidence per held-out sample
+	labels        []int
+	accuracy      float64
+	modelAccuracy map[string]float64 // per trained model
+}
+
+// metrics returns the evaluation as model metrics
+func (v *evaluation) metrics() map[string]float64 {
+	metrics := map[string]float64{
+		"test_accuracy":  v.accuracy,
+		"test_samples":   float64(v.confusion.total()),
+		"test_precision": v.confusion.Precision,
+		"test_recall":    v.confusion.Recall,
+		"test_f1":        v.confusion.F1,
+	}
+	for name, field := range confusionMetrics {
+		metrics[name] = float64(*field(&v.confusion))
+	}
+	if v.folds > 0 {
+		metrics["cv_folds"] = float64(v.folds)
+	}
+	if curves, err := computeCurves(v.scores, v.labels); err == nil {
+		metrics["test_roc_auc"] = curves.ROCAUC
+		metrics["test_average_precision"] = curves.AveragePrecision
+		metrics["test_brier_score"] = curves.BrierScore
+	}
+	for model, accuracy := range v.modelAccuracy {
+		metrics["test_accuracy_"+model] = accuracy
+	}
+	return metrics
+}
+
+// stratifiedFolds deals sample indices into k folds so that every fold has
+// about the same share of bots as the whole set
+func stratifiedFolds(labels []int, k int, rng *rand.Rand) [][]int {
+	byLabel := map[int][]int{}
+	for i, label := range labels {
+		byLabel[label] = append(byLabel[label], i)
+	}
+
+	folds := make([][]int, k)
+	next := 0
+	for _, label := range []int{0, 1} {
+		indices := byLabel[label]
+		rng.Shuffle(len(indices), func(i, j int) { indices[i], indices[j] = indices[j], indices[i] })
+		for _, i := range indices {
+			folds[next] = append(folds[next], i)
+			next = (next + 1) % k
+		}
+	}
+	return folds
+}
+
+// stratifiedSplit holds out a share of the samples of each label for
+// testing
+func stratifiedSplit(labels []int, holdout float64, rng *rand.Rand) (train, test []int) {
+	byLabel := map[int][]int{}
+	for i, label := range labels {
+		byLabel[label] = append(byLabel[label], i)
+	}
+
+	for _, label := range []int{0, 1} {
+		indices := byLabel[label]
+		rng.Shuffle(len(indices), func(i, j int) { indices[i], indices[j] = indices[j], indices[i] })
+		n := int(math.Round(holdout * float64(len(indices))))
+		test = append(test, indices[:n]...)
+		train = append(train, indices[n:]...)
+	}
+	return train, test
+}
+
+// subset returns the samples at the given indices
+func subset(features [][]float64, labels []int, indices []int) ([][]float64, []int) {
+	subFeatures := make([][]float64, len(indices))
+	subLabels := make([]int, len(indices))
+	for j, i := range indices {
+		subFeatures[j] = features[i]
+		subLabels[j] = labels[i]
+	}
+	return subFeatures, subLabels
+}
+
+// trainEvaluated trains models and measures their accuracy on held-out
+// samples. With EvaluationFolds of two or more, the accuracy is that of
+// stratified k-fold cross-validation on scratch engines and the models are
+// then trained on all samples. Otherwise a stratified share of
+// EvaluationHoldout samples is held out and the models are trained on the
+// rest. It returns the samples the models were trained on and the
+// evaluation, which is nil if there were too few samples to hold any out.
+func (e *MLEngine) trainEvaluated(features [][]float64, labels []int, models []string) ([][]float64, []int, *evaluation, error) {
+	e.dataGen.mu.Lock()
+	rng := rand.New(rand.NewSource(e.dataGen.rand.Int63()))
+	e.dataGen.mu.Unlock()
+
+	if k := e.config.EvaluationFolds; k >= 2 {
+		eval, err := e.crossValidate(features, labels, models, k, rng)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := e.fit(models, features, labels, rng); err != nil {
+			return nil, nil, nil, err
+		}
+		return features, labels, eval, nil
+	}
+
+	holdout := e.config.EvaluationHoldout
+	if holdout <= 0 || holdout >= 1 {
+		holdout = defaultEvaluationHoldout
+	}
+	train, test := stratifiedSplit(labels, holdout, rng)
+	if len(test) == 0 || len(train) == 0 {
+		slog.Warn("Too few samples to hold out for evaluation, training on all", "samples", len(features))
+		if err := e.fit(models, features, labels, rng); err != nil {
+			return nil, nil, nil, err
+		}
+		return features, labels, nil, nil
+	}
+
+	trainFeatures, trainLabels := subset(features, labels, train)
+	if err := e.fit(models, trainFeatures, trainLabels, rng); err != nil {
+		return nil, nil, nil, err
+	}
+	testFeatures, testLabels := subset(features, labels, test)
+	confusion, scores, modelCorrect := e.score(testFeatures, testLabels, models)
+	eval := newEvaluation(0, confusion, modelCorrect)
+	eval.features, eval.scores, eval.labels = testFeatures, scores, testLabels
+	return trainFeatures, trainLabels, eval, nil
+}
+
+// trainHeldOut trains models on all samples and measures their accuracy
+// on a separate labelled test set
+func (e *MLEngine) trainHeldOut(features [][]float64, labels []int, testFeatures [][]float64, testLabels []int, models []string) (*evaluation, error) {
+	e.dataGen.mu.Lock()
+	rng := rand.New(rand.NewSource(e.dataGen.rand.Int63()))
+	e.dataGen.mu.Unlock()
+
+	if err := e.fit(models, features, labels, rng); err != nil {
+		return nil, err
+	}
+	confusion, scores, modelCorrect := e.score(testFeatures, testLabels, models)
+	eval := newEvaluation(0, confusion, modelCorrect)
+	eval.features, eval.scores, eval.labels = testFeatures, scores, testLabels
+	return eval, nil
+}
+
+// crossValidate trains models on k-1 folds of the samples and tests them on
+// the remaining one, for each of k stratified folds
+func (e *MLEngine) crossValidate(features [][]float64, labels []int, models []string, k int, rng *rand.Rand) (*evaluation, error) {
+	if len(features) < k {
+		return nil, fmt.Errorf("cross-validation needs at least %d samples, got %d", k, len(features))
+	}
+
+	folds := stratifiedFolds(labels, k, rng)
+	var confusion ConfusionMatrix
+	var tested [][]float64
+	var scores []float64
+	var scoreLabels []int
+	modelCorrect := map[string]int{}
+	for f, test := range folds {
+		var train []int
+		for g, fold := range folds {
+			if g != f {
+				train = append(train, fold...)
+			}
+		}
+
+		scratch, err := e.scratchEngine()
+		if err != nil {
+			return nil, err
+		}
+		trainFeatures, trainLabels := subset(features, labels, train)
+		if err := scratch.fit(models, trainFeatures, trainLabels, rng); err != nil {
+			scratch.Close()
+			return nil, fmt.Errorf("fold %d: %w", f+1, err)
+		}
+		testFeatures, testLabels := subset(features, labels, test)
+		foldConfusion, foldScores, foldModelCorrect := scratch.score(testFeatures, testLabels, models)
+		scratch.Close()
+
+		tested = append(tested, testFeatures...)
+		scores = append(scores, foldScores...)
+		scoreLabels = append(scoreLabels, testLabels...)
+		confusion.TruePositives += foldConfusion.TruePositives
+		confusion.FalsePositives += foldConfusion.FalsePositives
+		confusion.TrueNegatives += foldConfusion.TrueNegatives
+		confusion.FalseNegatives += foldConfusion.FalseNegatives
+		for model, n := range foldModelCorrect {
+			modelCorrect[model] += n
+		}
+	}
+	eval := newEvaluation(k, confusion, modelCorrect)
+	eval.features, eval.scores, eval.labels = tested, scores, scoreLabels
+	return eval, nil
+}
+
+// scratchEngine returns an untrained engine with the same configuration
+func (e *MLEngine) scratchEngine() (*MLEngine, error) {
+	config := e.config
+	config.GenerateFakeData = false
+	config.TrainingDataPath = ""
+	config.LoadModel = false
+	config.SaveModel = false
+	return NewMLEngine(config)
+}
+
+// trainModels trains each of the given models. With feature scaling
+// configured, the scaler is fitted to the samples first when all the
+// engine's models are trained; training some of them, as TrainBaseline
+// does, keeps the scaler the others were trained with.
+func (e *MLEngine) trainModels(models []string, features [][]float64, labels []int) error {
+	if method := e.config.Scaling; method != "" && method != ScalingNone {
+		e.mu.RLock()
+		scaler := e.scaler
+		e.mu.RUnlock()
+		if scaler == nil || len(models) == len(e.models()) {
+			var err error
+			if scaler, err = fitScaler(method, features, e.config.FeatureSize); err != nil {
+				return err
+			}
+			e.lock()
+			e.scaler = scaler
+			e.mu.Unlock()
+		}
+		features = scaler.transformAll(features)
+	}
+	for _, modelType := range models {
+		if err := e.trainModel(modelType, features, labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// score classifies labelled samples, returning the engine's confusion
+// matrix and confidences and the number of samples each of the given
+// models on its own classifies correctly
+func (e *MLEngine) score(features [][]float64, labels []int, models []string) (ConfusionMatrix, []float64, map[string]int) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var confusion ConfusionMatrix
+	scores := make([]float64, len(features))
+	modelCorrect := make(map[string]int, len(models))
+	for _, modelType := range models {
+		modelCorrect[modelType] = 0
+	}
+	for i, sample := range features {
+		isBot := labels[i] == 1
+		confidence, _, err := e.infer(sample)
+		if err != nil {
+			confidence = 0
+		}
+		scores[i] = confidence
+		confusion.add(confidence >= e.config.DetectionThreshold, isBot)
+		scaled := e.scaler.transform(sample)
+		for _, modelType := range models {
+			if confidence, err := e.predictModel(modelType, scaled); err == nil && (confidence >= e.config.DetectionThreshold) == isBot {
+				modelCorrect[modelType]++
+			}
+		}
+	}
+	return confusion, scores, modelCorrect
+}
+
+// newEvaluation turns counts of classifications into accuracies
+func newEvaluation(folds int, confusion ConfusionMatrix, modelCorrect map[string]int) *evaluation {
+	confusion.derive()
+	tested := confusion.total()
+	eval := &evaluation{
+		folds:         folds,
+		confusion:     confusion,
+		accuracy:      float64(confusion.TruePositives+confusion.TrueNegatives) / float64(tested),
+		modelAccuracy: make(map[string]float64, len(modelCorrect)),
+	}
+	for model, n := range modelCorrect {
+		eval.modelAccuracy[model] = float64(n) / float64(tested)
+	}
+	return eval
+}
@@ -0,0 +1,144 @@
+package ml
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStratifiedFolds(t *testing.T) {
+	labels := make([]int, 100)
+	for i := 0; i < 20; i++ {
+		labels[i] = 1
+	}
+
+	folds := stratifiedFolds(labels, 5, rand.New(rand.NewSource(1)))
+	seen := map[int]bool{}
+	for _, fold := range folds {
+		bots := 0
+		for _, i := range fold {
+			assert.False(t, seen[i])
+			seen[i] = true
+			bots += labels[i]
+		}
+		assert.Len(t, fold, 20)
+		assert.Equal(t, 4, bots)
+	}
+	assert.Len(t, seen, 100)
+
+	train, test := stratifiedSplit(labels, 0.25, rand.New(rand.NewSource(1)))
+	assert.Len(t, train, 75)
+	assert.Len(t, test, 25)
+	bots := 0
+	for _, i := range test {
+		bots += labels[i]
+	}
+	assert.Equal(t, 5, bots)
+}
+
+func TestConfusionMatrix(t *testing.T) {
+	var m ConfusionMatrix
+	for _, c := range []struct{ predicted, actual bool }{
+		{true, true}, {true, true}, {true, true}, {true, false},
+		{false, true}, {false, false}, {false, false},
+	} {
+		m.add(c.predicted, c.actual)
+	}
+	m.derive()
+	assert.Equal(t, ConfusionMatrix{
+		TruePositives:  3,
+		FalsePositives: 1,
+		TrueNegatives:  2,
+		FalseNegatives: 1,
+		Precision:      0.75,
+		Recall:         0.75,
+		F1:             0.75,
+	}, m)
+
+	// Metrics survive a round trip through model metrics
+	eval := newEvaluation(0, m, nil)
+	assert.Equal(t, &m, confusionFromMetrics(eval.metrics()))
+	assert.InDelta(t, 5.0/7, eval.accuracy, 1e-9)
+	assert.Nil(t, confusionFromMetrics(map[string]float64{"training_accuracy": 1}))
+
+	// Without predicted bots there is no precision to speak of
+	m = ConfusionMatrix{TrueNegatives: 4, FalseNegatives: 2}
+	m.derive()
+	assert.Zero(t, m.Precision)
+	assert.Zero(t, m.Recall)
+	assert.Zero(t, m.F1)
+}
+
+func TestCrossValidation(t *testing.T) {
+	cfg := MLConfig{
+		ModelType:          "gbdt",
+		DetectionThreshold: 0.6,
+		FeatureSize:        8,
+		GenerateFakeData:   true,
+		FakeDataSize:       200,
+		GBDTTrees:          10,
+		EvaluationFolds:    4,
+	}
+	engine, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	// The final model is trained on every sample after cross-validation
+	snapshot := engine.Snapshot()
+	assert.Equal(t, 200, snapshot.TrainingData.Samples)
+	assert.Equal(t, 4.0, snapshot.Metrics["cv_folds"])
+	assert.Equal(t, 200.0, snapshot.Metrics["test_samples"])
+
+	stats := engine.GetStatistics()
+	assert.Greater(t, stats.ModelAccuracy, 0.9)
+	assert.Equal(t, map[string]float64{"gbdt": stats.ModelAccuracy}, stats.ModelAccuracies)
+	require.NotNil(t, stats.Confusion)
+	assert.EqualValues(t, 200, stats.Confusion.total())
+	assert.Equal(t, snapshot.Metrics["test_f1"], stats.Confusion.F1)
+	assert.Greater(t, stats.Confusion.Recall, 0.9)
+
+	// Accuracy statistics come with restored models
+	cfg.GenerateFakeData = false
+	restored, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer restored.Close()
+	require.NoError(t, restored.Restore(snapshot))
+	assert.Equal(t, stats.ModelAccuracies, restored.GetStatistics().ModelAccuracies)
+	assert.Equal(t, stats.Confusion, restored.GetStatistics().Confusion)
+}
+
+func TestHoldoutEvaluationPerModel(t *testing.T) {
+	engine, err := NewMLEngine(MLConfig{
+		ModelType:          "ensemble",
+		EnsembleModels:     []string{"svm", "knn"},
+		DetectionThreshold: 0.6,
+		FeatureSize:        8,
+		GenerateFakeData:   true,
+		FakeDataSize:       100,
+		EvaluationHoldout:  0.3,
+	})
+	require.NoError(t, err)
+	defer engine.Close()
+
+	snapshot := engine.Snapshot()
+	assert.Equal(t, 70, snapshot.TrainingData.Samples)
+	assert.Equal(t, 30.0, snapshot.Metrics["test_samples"])
+	assert.NotContains(t, snapshot.Metrics, "cv_folds")
+
+	stats := engine.GetStatistics()
+	assert.Equal(t, snapshot.Metrics["test_accuracy"], stats.ModelAccuracy)
+	assert.Len(t, stats.ModelAccuracies, 2)
+	assert.Contains(t, stats.ModelAccuracies, "svm")
+	assert.Contains(t, stats.ModelAccuracies, "knn")
+}
+
+func TestCrossValidationTooFewSamples(t *testing.T) {
+	engine, err := NewMLEngine(MLConfig{ModelType: "knn", FeatureSize: 2, EvaluationFolds: 5})
+	require.NoError(t, err)
+	defer engine.Close()
+
+	_, _, _, err = engine.trainEvaluated([][]float64{{0, 0}, {1, 1}}, []int{0, 1}, []string{"knn"})
+	assert.ErrorContains(t, err, "at least 5 samples")
+}
@@ -0,0 +1,108 @@
+package ml
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// explainCandidates bounds the features re-scored to explain a prediction:
+// those furthest from their training mean, in standard deviations
+const explainCandidates = 16
+
+// Explanation is the contribution of one feature to a prediction: how much
+// the confidence changes when the feature takes the flow's value instead of
+// its mean over the training flows
+type Explanation struct {
+	Feature      int     `json:"feature"`
+	Name         string  `json:"name"`
+	Value        float64 `json:"value"`
+	Baseline     float64 `json:"baseline"`
+	Contribution float64 `json:"contribution"` // positive towards bot, negative towards human
+}
+
+// featureMoments returns the mean and standard deviation of each feature of
+// samples
+func featureMoments(samples [][]float64, featureSize int) (means, deviations []float64) {
+	if len(samples) == 0 {
+		return nil, nil
+	}
+	means = make([]float64, featureSize)
+	deviations = make([]float64, featureSize)
+	n := float64(len(samples))
+	for i := 0; i < featureSize; i++ {
+		var sum, sumSquares float64
+		for _, row := range samples {
+			sum += row[i]
+			sumSquares += row[i] * row[i]
+		}
+		means[i] = sum / n
+		deviations[i] = math.Sqrt(math.Max(sumSquares/n-means[i]*means[i], 0))
+	}
+	return means, deviations
+}
+
+// explain attributes a prediction to the Explanations features contributing
+// most to it. Each of the features furthest from its training mean is set
+// back to the mean in turn and the flow re-scored. The caller holds e.mu.
+func (e *MLEngine) explain(features []float64, confidence float64, score func([]float64) float64) []Explanation {
+	limit := e.config.Explanations
+	if limit <= 0 || len(e.featureMeans) != len(features) {
+		return nil
+	}
+
+	type candidate struct {
+		index    int
+		distance float64
+	}
+	var candidates []candidate
+	for i, x := range features {
+		if x == e.featureMeans[i] {
+			continue
+		}
+		distance := math.Inf(1)
+		if d := e.featureDeviations[i]; d > 0 {
+			distance = math.Abs(x-e.featureMeans[i]) / d
+		}
+		candidates = append(candidates, candidate{i, distance})
+	}
+	sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].distance > candidates[b].distance })
+	if len(candidates) > explainCandidates {
+		candidates = candidates[:explainCandidates]
+	}
+
+	probe := append([]float64(nil), features...)
+	var explanations []Explanation
+	for _, c := range candidates {
+		probe[c.index] = e.featureMeans[c.index]
+		contribution := confidence - score(probe)
+		probe[c.index] = features[c.index]
+		if contribution == 0 {
+			continue
+		}
+		explanations = append(explanations, Explanation{
+			Feature:      c.index,
+			Name:         e.config.FeatureSchema.Features[c.index].Name,
+			Value:        features[c.index],
+			Baseline:     e.featureMeans[c.index],
+			Contribution: contribution,
+		})
+	}
+	sort.SliceStable(explanations, func(a, b int) bool {
+		return math.Abs(explanations[a].Contribution) > math.Abs(explanations[b].Contribution)
+	})
+	if len(explanations) > limit {
+		explanations = explanations[:limit]
+	}
+	return explanations
+}
+
+// describeExplanations renders explanations as a sentence
+func describeExplanations(explanations []Explanation) string {
+	factors := make([]string, len(explanations))
+	for i, x := range explanations {
+		factors[i] = fmt.Sprintf("%s %.4g against a typical %.4g (%+.2f)", x.Name, x.Value, x.Baseline, x.Contribution)
+	}
+	return "Main factors: " + strings.Join(factors, ", ") + "."
+}
@@ -0,0 +1,93 @@
+package ml
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureMoments(t *testing.T) {
+	means, deviations := featureMoments([][]float64{{1, 5}, {3, 5}}, 2)
+	assert.Equal(t, []float64{2, 5}, means)
+	assert.Equal(t, []float64{1, 0}, deviations)
+
+	means, deviations = featureMoments(nil, 2)
+	assert.Nil(t, means)
+	assert.Nil(t, deviations)
+}
+
+func TestPredictExplanations(t *testing.T) {
+	cfg := MLConfig{
+		ModelType:          "gbdt",
+		DetectionThreshold: 0.5,
+		FeatureSize:        4,
+		GBDTTrees:          20,
+		GBDTMaxDepth:       3,
+		GBDTLearningRate:   0.1,
+		Explanations:       2,
+	}
+	engine, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	// Only feature 2 separates bots from humans
+	r := rand.New(rand.NewSource(1))
+	features := make([][]float64, 400)
+	labels := make([]int, len(features))
+	for i := range features {
+		labels[i] = i % 2
+		features[i] = []float64{r.Float64(), r.Float64(), float64(labels[i]) + 0.3*r.Float64(), r.Float64()}
+	}
+	trained, trainedLabels, eval, err := engine.trainEvaluated(features, labels, engine.models())
+	require.NoError(t, err)
+	require.NoError(t, engine.finishTraining(time.Now(), "test", trained, trainedLabels, eval))
+
+	bot := []float64{0.5, 0.5, 1.2, 0.5}
+	result, err := engine.Predict(context.Background(), bot, "a-b")
+	require.NoError(t, err)
+	require.True(t, result.IsBot)
+	require.NotEmpty(t, result.Explanations)
+	assert.LessOrEqual(t, len(result.Explanations), 2)
+
+	top := result.Explanations[0]
+	assert.Equal(t, 2, top.Feature)
+	assert.Equal(t, "ssh_automation_client", top.Name)
+	assert.Equal(t, 1.2, top.Value)
+	assert.InDelta(t, 0.65, top.Baseline, 0.1)
+	assert.Greater(t, top.Contribution, 0.2)
+	assert.Contains(t, result.Reasoning, "Main factors: ssh_automation_client 1.2 against a typical")
+
+	human := []float64{0.5, 0.5, 0.1, 0.5}
+	result, err = engine.Predict(context.Background(), human, "a-b")
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Explanations)
+	assert.Equal(t, 2, result.Explanations[0].Feature)
+	assert.Negative(t, result.Explanations[0].Contribution)
+
+	// The heuristic path explains its own score
+	result, err = engine.PredictHeuristic(context.Background(), []float64{0.9, 0.9, 0.9, 0.9}, "a-b")
+	require.NoError(t, err)
+	for _, x := range result.Explanations {
+		assert.NotZero(t, x.Contribution)
+	}
+
+	// The training means are part of the persisted model
+	cfg.Explanations = 0
+	restored, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer restored.Close()
+	require.NoError(t, restored.Restore(engine.Snapshot()))
+	assert.Equal(t, engine.featureMeans, restored.featureMeans)
+	result, err = restored.Predict(context.Background(), bot, "a-b")
+	require.NoError(t, err)
+	assert.Empty(t, result.Explanations)
+	assert.NotContains(t, result.Reasoning, "Main factors")
+
+	snapshot := engine.Snapshot()
+	snapshot.FeatureDeviations = snapshot.FeatureDeviations[1:]
+	assert.ErrorContains(t, restored.Restore(snapshot), "feature means")
+}
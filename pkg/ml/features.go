@@ -0,0 +1,146 @@
+package ml
+
+import (
+	"fmt"
+	"math"
+)
+
+// flowFeatures describes the slots of the flow feature vectors argus
+// extracts; the others are filled with a fixed pattern and carry no signal.
+// Changing a slot changes the layout models are trained on and must come
+// with a new FeatureSchemaVersion.
+var flowFeatures = map[int]FeatureSpec{
+	0:   {Name: "avg_packet_size", Min: 0, Max: 65535},
+	1:   {Name: "ssh", Min: 0, Max: 1},
+	2:   {Name: "ssh_automation_client", Min: 0, Max: 1},
+	3:   {Name: "ssh_kex_algorithm_count", Min: 0, Max: math.Inf(1)},
+	4:   {Name: "grpc", Min: 0, Max: 1},
+	5:   {Name: "rdp", Min: 0, Max: 1},
+	6:   {Name: "rdp_standard_security", Min: 0, Max: 1},
+	7:   {Name: "vnc", Min: 0, Max: 1},
+	8:   {Name: "vnc_no_authentication", Min: 0, Max: 1},
+	9:   {Name: "unexpected_encrypted_dns", Min: 0, Max: 1},
+	10:  {Name: "inter_arrival_variance", Min: 0, Max: math.Inf(1)},
+	11:  {Name: "dns_queries", Min: 0, Max: math.Inf(1)},
+	12:  {Name: "dns_qname_length_mean", Min: 0, Max: 253},
+	13:  {Name: "dns_label_entropy_max", Min: 0, Max: 8},
+	14:  {Name: "dns_nxdomain_ratio", Min: 0, Max: 1},
+	15:  {Name: "dns_client_nxdomain_ratio", Min: 0, Max: 1},
+	16:  {Name: "dns_qtype_share_address", Min: 0, Max: 1},
+	17:  {Name: "dns_qtype_share_txt_null", Min: 0, Max: 1},
+	18:  {Name: "dns_qtype_share_other", Min: 0, Max: 1},
+	19:  {Name: "encrypted_dns", Min: 0, Max: 1},
+	20:  {Name: "packet_count", Min: 0, Max: math.Inf(1)},
+	21:  {Name: "flow_duration", Min: 0, Max: math.Inf(1)},
+	22:  {Name: "crosses_border", Min: 0, Max: 1},
+	23:  {Name: "same_asn", Min: 0, Max: 1},
+	24:  {Name: "ipv6", Min: 0, Max: 1},
+	25:  {Name: "ipv6_unlabelled_ratio", Min: 0, Max: 1},
+	26:  {Name: "ipv6_flow_label_changes", Min: 0, Max: math.Inf(1)},
+	27:  {Name: "cert_validity_days", Min: 0, Max: math.Inf(1)},
+	28:  {Name: "cert_san_count", Min: 0, Max: math.Inf(1)},
+	29:  {Name: "cert_self_signed", Min: 0, Max: 1},
+	30:  {Name: "packet_size_share_64", Min: 0, Max: 1},
+	31:  {Name: "packet_size_share_128", Min: 0, Max: 1},
+	32:  {Name: "packet_size_share_256", Min: 0, Max: 1},
+	33:  {Name: "packet_size_share_512", Min: 0, Max: 1},
+	34:  {Name: "packet_size_share_1024", Min: 0, Max: 1},
+	35:  {Name: "packet_size_share_1500", Min: 0, Max: 1},
+	36:  {Name: "packet_size_share_jumbo", Min: 0, Max: 1},
+	37:  {Name: "cert_chain_length", Min: 0, Max: math.Inf(1)},
+	38:  {Name: "quic", Min: 0, Max: 1},
+	39:  {Name: "quic_spin_flip_ratio", Min: 0, Max: 1},
+	40:  {Name: "http_transactions", Min: 0, Max: math.Inf(1)},
+	41:  {Name: "http_ttfb_mean", Min: 0, Max: math.Inf(1)},
+	42:  {Name: "http_ttfb_max", Min: 0, Max: math.Inf(1)},
+	43:  {Name: "http_error_ratio", Min: 0, Max: 1},
+	44:  {Name: "http_response_bytes_mean", Min: 0, Max: math.Inf(1)},
+	45:  {Name: "ua_browser", Min: 0, Max: 1},
+	46:  {Name: "ua_automation", Min: 0, Max: 1},
+	47:  {Name: "ua_tls_mismatch", Min: 0, Max: 1},
+	48:  {Name: "ua_http_mismatch", Min: 0, Max: 1},
+	49:  {Name: "header_order_fingerprint", Min: 0, Max: 1},
+	50:  {Name: "header_count", Min: 0, Max: math.Inf(1)},
+	51:  {Name: "tls_record_count", Min: 0, Max: 32},
+	52:  {Name: "tls_record_mean_size", Min: 0, Max: 18432},
+	53:  {Name: "tls_burst_mean_length", Min: 0, Max: 32},
+	54:  {Name: "tls_burst_max_length", Min: 0, Max: 32},
+	55:  {Name: "tls_record_bigram_0", Min: 0, Max: 1},
+	56:  {Name: "tls_record_bigram_1", Min: 0, Max: 1},
+	57:  {Name: "tls_record_bigram_2", Min: 0, Max: 1},
+	58:  {Name: "tls_record_bigram_3", Min: 0, Max: 1},
+	59:  {Name: "tls_record_bigram_4", Min: 0, Max: 1},
+	60:  {Name: "tls_record_bigram_5", Min: 0, Max: 1},
+	61:  {Name: "tls_record_bigram_6", Min: 0, Max: 1},
+	62:  {Name: "tls_record_bigram_7", Min: 0, Max: 1},
+	63:  {Name: "splt_out_length_0", Min: 0, Max: 65535},
+	64:  {Name: "splt_out_length_1", Min: 0, Max: 65535},
+	65:  {Name: "splt_out_length_2", Min: 0, Max: 65535},
+	66:  {Name: "splt_out_length_3", Min: 0, Max: 65535},
+	67:  {Name: "splt_out_length_4", Min: 0, Max: 65535},
+	68:  {Name: "splt_out_length_5", Min: 0, Max: 65535},
+	69:  {Name: "splt_out_length_6", Min: 0, Max: 65535},
+	70:  {Name: "splt_out_length_7", Min: 0, Max: 65535},
+	71:  {Name: "splt_in_length_0", Min: 0, Max: 65535},
+	72:  {Name: "splt_in_length_1", Min: 0, Max: 65535},
+	73:  {Name: "splt_in_length_2", Min: 0, Max: 65535},
+	74:  {Name: "splt_in_length_3", Min: 0, Max: 65535},
+	75:  {Name: "splt_in_length_4", Min: 0, Max: 65535},
+	76:  {Name: "splt_in_length_5", Min: 0, Max: 65535},
+	77:  {Name: "splt_in_length_6", Min: 0, Max: 65535},
+	78:  {Name: "splt_in_length_7", Min: 0, Max: 65535},
+	79:  {Name: "splt_out_time_0", Min: 0, Max: math.Inf(1)},
+	80:  {Name: "splt_out_time_1", Min: 0, Max: math.Inf(1)},
+	81:  {Name: "splt_out_time_2", Min: 0, Max: math.Inf(1)},
+	82:  {Name: "splt_out_time_3", Min: 0, Max: math.Inf(1)},
+	83:  {Name: "splt_out_time_4", Min: 0, Max: math.Inf(1)},
+	84:  {Name: "splt_out_time_5", Min: 0, Max: math.Inf(1)},
+	85:  {Name: "splt_out_time_6", Min: 0, Max: math.Inf(1)},
+	86:  {Name: "splt_out_time_7", Min: 0, Max: math.Inf(1)},
+	87:  {Name: "splt_in_time_0", Min: 0, Max: math.Inf(1)},
+	88:  {Name: "splt_in_time_1", Min: 0, Max: math.Inf(1)},
+	89:  {Name: "splt_in_time_2", Min: 0, Max: math.Inf(1)},
+	90:  {Name: "splt_in_time_3", Min: 0, Max: math.Inf(1)},
+	91:  {Name: "splt_in_time_4", Min: 0, Max: math.Inf(1)},
+	92:  {Name: "splt_in_time_5", Min: 0, Max: math.Inf(1)},
+	93:  {Name: "splt_in_time_6", Min: 0, Max: math.Inf(1)},
+	94:  {Name: "splt_in_time_7", Min: 0, Max: math.Inf(1)},
+	95:  {Name: "http_requests_after_close", Min: 0, Max: math.Inf(1)},
+	96:  {Name: "http_unsolicited_responses", Min: 0, Max: math.Inf(1)},
+	97:  {Name: "tls_alerts", Min: 0, Max: 32},
+	98:  {Name: "tls_out_of_order", Min: 0, Max: 32},
+	99:  {Name: "tcp_data_after_close", Min: 0, Max: math.Inf(1)},
+	100: {Name: "payload_out_entropy", Min: 0, Max: 8},
+	101: {Name: "payload_out_byte_mean", Min: 0, Max: 255},
+	102: {Name: "payload_out_bytes_00_1f", Min: 0, Max: 1},
+	103: {Name: "payload_out_bytes_20_3f", Min: 0, Max: 1},
+	104: {Name: "payload_out_bytes_40_5f", Min: 0, Max: 1},
+	105: {Name: "payload_out_bytes_60_7f", Min: 0, Max: 1},
+	106: {Name: "payload_out_bytes_80_9f", Min: 0, Max: 1},
+	107: {Name: "payload_out_bytes_a0_bf", Min: 0, Max: 1},
+	108: {Name: "payload_out_bytes_c0_df", Min: 0, Max: 1},
+	109: {Name: "payload_out_bytes_e0_ff", Min: 0, Max: 1},
+	110: {Name: "payload_in_entropy", Min: 0, Max: 8},
+	111: {Name: "payload_in_byte_mean", Min: 0, Max: 255},
+	112: {Name: "payload_in_bytes_00_1f", Min: 0, Max: 1},
+	113: {Name: "payload_in_bytes_20_3f", Min: 0, Max: 1},
+	114: {Name: "payload_in_bytes_40_5f", Min: 0, Max: 1},
+	115: {Name: "payload_in_bytes_60_7f", Min: 0, Max: 1},
+	116: {Name: "payload_in_bytes_80_9f", Min: 0, Max: 1},
+	117: {Name: "payload_in_bytes_a0_bf", Min: 0, Max: 1},
+	118: {Name: "payload_in_bytes_c0_df", Min: 0, Max: 1},
+	119: {Name: "payload_in_bytes_e0_ff", Min: 0, Max: 1},
+	120: {Name: "http_bot_keywords", Min: 0, Max: 1},
+	121: {Name: "http_user_agent_length", Min: 0, Max: math.Inf(1)},
+	122: {Name: "http_path_length", Min: 0, Max: math.Inf(1)},
+	123: {Name: "http_header_decode_error", Min: 0, Max: 1},
+}
+
+// FeatureName returns the name of a feature vector slot, "unused_<index>"
+// for slots without a feature
+func FeatureName(index int) string {
+	if spec, ok := flowFeatures[index]; ok {
+		return spec.Name
+	}
+	return fmt.Sprintf("unused_%d", index)
+}
@@ -0,0 +1,320 @@
+package ml
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Default GBDT hyperparameters, used for zero values in MLConfig
+const (
+	defaultGBDTTrees          = 100
+	defaultGBDTMaxDepth       = 4
+	defaultGBDTLearningRate   = 0.1
+	defaultGBDTBins           = 32
+	defaultGBDTMinSamplesLeaf = 10
+
+	// gbdtLambda is the L2 regularization of leaf values
+	gbdtLambda = 1.0
+)
+
+// GBDTNode is a node of a regression tree. Leaves have no children;
+// other nodes send samples with Feature <= Threshold to Left.
+type GBDTNode struct {
+	Feature   int
+	Threshold float64
+	Left      int
+	Right     int
+	Value     float64 // leaf value, scaled by the learning rate
+	Leaf      bool
+}
+
+// GBDTTree is a regression tree stored as a flat node list, rooted at the
+// first node
+type GBDTTree struct {
+	Nodes []GBDTNode
+}
+
+// validate checks that every node refers to an existing feature and that
+// children follow their parent, so that prediction terminates
+func (t *GBDTTree) validate(featureSize int) error {
+	if len(t.Nodes) == 0 {
+		return fmt.Errorf("tree has no nodes")
+	}
+	for i, n := range t.Nodes {
+		if n.Leaf {
+			continue
+		}
+		if n.Feature < 0 || n.Feature >= featureSize {
+			return fmt.Errorf("node %d splits on feature %d of %d", i, n.Feature, featureSize)
+		}
+		if n.Left <= i || n.Left >= len(t.Nodes) || n.Right <= i || n.Right >= len(t.Nodes) {
+			return fmt.Errorf("node %d has invalid children %d and %d", i, n.Left, n.Right)
+		}
+	}
+	return nil
+}
+
+// cloneTrees returns a deep copy of trees
+func cloneTrees(trees []GBDTTree) []GBDTTree {
+	if trees == nil {
+		return nil
+	}
+	cloned := make([]GBDTTree, len(trees))
+	for i, t := range trees {
+		cloned[i] = GBDTTree{Nodes: append([]GBDTNode(nil), t.Nodes...)}
+	}
+	return cloned
+}
+
+// predict returns the value of the leaf a sample falls into
+func (t *GBDTTree) predict(features []float64) float64 {
+	n := &t.Nodes[0]
+	for !n.Leaf {
+		if features[n.Feature] <= n.Threshold {
+			n = &t.Nodes[n.Left]
+		} else {
+			n = &t.Nodes[n.Right]
+		}
+	}
+	return n.Value
+}
+
+// GBDT is a gradient boosted decision tree classifier trained on the
+// logistic loss. Features are bucketed into quantile histograms during
+// training so split finding is linear in the number of samples.
+type GBDT struct {
+	base    float64 // initial log-odds
+	trees   []GBDTTree
+	trained bool
+}
+
+// gbdtParams are the training hyperparameters
+type gbdtParams struct {
+	trees          int
+	maxDepth       int
+	learningRate   float64
+	bins           int
+	minSamplesLeaf int
+}
+
+// gbdtParams returns the configured hyperparameters, with defaults for
+// unset ones
+func (c MLConfig) gbdtParams() gbdtParams {
+	p := gbdtParams{
+		trees:          c.GBDTTrees,
+		maxDepth:       c.GBDTMaxDepth,
+		learningRate:   c.GBDTLearningRate,
+		bins:           c.GBDTBins,
+		minSamplesLeaf: c.GBDTMinSamplesLeaf,
+	}
+	if p.trees <= 0 {
+		p.trees = defaultGBDTTrees
+	}
+	if p.maxDepth <= 0 {
+		p.maxDepth = defaultGBDTMaxDepth
+	}
+	if p.learningRate <= 0 {
+		p.learningRate = defaultGBDTLearningRate
+	}
+	if p.bins < 2 || p.bins > 256 {
+		p.bins = defaultGBDTBins
+	}
+	if p.minSamplesLeaf <= 0 {
+		p.minSamplesLeaf = defaultGBDTMinSamplesLeaf
+	}
+	return p
+}
+
+// predict returns the bot probability of a sample
+func (m *GBDT) predict(features []float64) float64 {
+	score := m.base
+	for i := range m.trees {
+		score += m.trees[i].predict(features)
+	}
+	return sigmoid(score)
+}
+
+func sigmoid(x float64) float64 {
+	return 1.0 / (1.0 + math.Exp(-x))
+}
+
+// gbdtTrainer holds the binned training set
+type gbdtTrainer struct {
+	params gbdtParams
+	bins   [][]uint8   // bin of each sample, by feature
+	edges  [][]float64 // upper bound of each bin but the last, by feature
+	grad   []float64
+	hess   []float64
+}
+
+// train fits the classifier to labelled samples, replacing its trees
+func (m *GBDT) train(features [][]float64, labels []int, params gbdtParams) error {
+	if len(features) == 0 || len(features) != len(labels) {
+		return fmt.Errorf("invalid training set: %d samples, %d labels", len(features), len(labels))
+	}
+
+	n := len(features)
+	t := &gbdtTrainer{
+		params: params,
+		grad:   make([]float64, n),
+		hess:   make([]float64, n),
+	}
+	t.binFeatures(features)
+
+	// Start from the log-odds of the bot rate
+	positives := 0
+	for _, y := range labels {
+		positives += y
+	}
+	rate := math.Min(math.Max(float64(positives)/float64(n), 1e-6), 1-1e-6)
+	base := math.Log(rate / (1 - rate))
+
+	scores := make([]float64, n)
+	for i := range scores {
+		scores[i] = base
+	}
+	samples := make([]int, n)
+	trees := make([]GBDTTree, 0, params.trees)
+	for len(trees) < params.trees {
+		for i, y := range labels {
+			p := sigmoid(scores[i])
+			t.grad[i] = p - float64(y)
+			t.hess[i] = math.Max(p*(1-p), 1e-12)
+		}
+
+		for i := range samples {
+			samples[i] = i
+		}
+		tree := GBDTTree{}
+		t.grow(&tree, samples, 0)
+		for i, row := range features {
+			scores[i] += tree.predict(row)
+		}
+		trees = append(trees, tree)
+	}
+
+	m.base = base
+	m.trees = trees
+	m.trained = true
+	return nil
+}
+
+// binFeatures buckets every feature into up to params.bins quantile bins
+func (t *gbdtTrainer) binFeatures(features [][]float64) {
+	n, width := len(features), len(features[0])
+	t.bins = make([][]uint8, width)
+	t.edges = make([][]float64, width)
+
+	values := make([]float64, n)
+	for f := 0; f < width; f++ {
+		for i, row := range features {
+			values[i] = row[f]
+		}
+		sort.Float64s(values)
+
+		var edges []float64
+		for b := 1; b < t.params.bins; b++ {
+			edge := values[b*n/t.params.bins]
+			if edge == values[n-1] {
+				break
+			}
+			if len(edges) == 0 || edge > edges[len(edges)-1] {
+				edges = append(edges, edge)
+			}
+		}
+		t.edges[f] = edges
+
+		bins := make([]uint8, n)
+		for i, row := range features {
+			bins[i] = uint8(sort.SearchFloat64s(edges, row[f]))
+		}
+		t.bins[f] = bins
+	}
+}
+
+// grow adds the subtree fitted to samples to tree and returns its index
+func (t *gbdtTrainer) grow(tree *GBDTTree, samples []int, depth int) int {
+	var g, h float64
+	for _, i := range samples {
+		g += t.grad[i]
+		h += t.hess[i]
+	}
+
+	index := len(tree.Nodes)
+	tree.Nodes = append(tree.Nodes, GBDTNode{Leaf: true, Value: -g / (h + gbdtLambda) * t.params.learningRate})
+	if depth >= t.params.maxDepth || len(samples) < 2*t.params.minSamplesLeaf {
+		return index
+	}
+
+	feature, bin, ok := t.bestSplit(samples, g, h)
+	if !ok {
+		return index
+	}
+
+	// Partition samples in place
+	bins := t.bins[feature]
+	split := 0
+	for i, s := range samples {
+		if int(bins[s]) <= bin {
+			samples[i], samples[split] = samples[split], samples[i]
+			split++
+		}
+	}
+
+	left := t.grow(tree, samples[:split], depth+1)
+	right := t.grow(tree, samples[split:], depth+1)
+	tree.Nodes[index] = GBDTNode{
+		Feature:   feature,
+		Threshold: t.edges[feature][bin],
+		Left:      left,
+		Right:     right,
+	}
+	return index
+}
+
+// bestSplit finds the feature and bin with the largest loss reduction,
+// splitting samples into bins up to and including bin and the rest
+func (t *gbdtTrainer) bestSplit(samples []int, g, h float64) (int, int, bool) {
+	bestGain, bestFeature, bestBin := 0.0, -1, -1
+	parent := g * g / (h + gbdtLambda)
+
+	histGrad := make([]float64, t.params.bins)
+	histHess := make([]float64, t.params.bins)
+	histCount := make([]int, t.params.bins)
+	for f, bins := range t.bins {
+		edges := len(t.edges[f])
+		if edges == 0 {
+			continue
+		}
+		clear(histGrad)
+		clear(histHess)
+		clear(histCount)
+		for _, s := range samples {
+			b := bins[s]
+			histGrad[b] += t.grad[s]
+			histHess[b] += t.hess[s]
+			histCount[b]++
+		}
+
+		var gl, hl float64
+		var nl int
+		for b := 0; b < edges; b++ {
+			gl += histGrad[b]
+			hl += histHess[b]
+			nl += histCount[b]
+			if nl < t.params.minSamplesLeaf {
+				continue
+			}
+			if len(samples)-nl < t.params.minSamplesLeaf {
+				break
+			}
+			gr, hr := g-gl, h-hl
+			gain := gl*gl/(hl+gbdtLambda) + gr*gr/(hr+gbdtLambda) - parent
+			if gain > bestGain {
+				bestGain, bestFeature, bestBin = gain, f, b
+			}
+		}
+	}
+	return bestFeature, bestBin, bestFeature >= 0
+}
@@ -0,0 +1,94 @@
+package ml
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// thresholdData returns samples labelled bots when both of their first two
+// features are high, which no single linear split separates
+func thresholdData(n, width int, seed int64) ([][]float64, []int) {
+	r := rand.New(rand.NewSource(seed))
+	features := make([][]float64, n)
+	labels := make([]int, n)
+	for i := range features {
+		row := make([]float64, width)
+		for j := range row {
+			row[j] = r.Float64()
+		}
+		features[i] = row
+		if row[0] > 0.5 && row[1] > 0.5 {
+			labels[i] = 1
+		}
+	}
+	return features, labels
+}
+
+func TestGBDTLearnsInteraction(t *testing.T) {
+	features, labels := thresholdData(600, 6, 1)
+
+	model := &GBDT{}
+	require.NoError(t, model.train(features, labels, MLConfig{GBDTTrees: 50}.gbdtParams()))
+	assert.Len(t, model.trees, 50)
+
+	test, want := thresholdData(200, 6, 2)
+	correct := 0
+	for i, row := range test {
+		if (model.predict(row) > 0.5) == (want[i] == 1) {
+			correct++
+		}
+	}
+	assert.Greater(t, float64(correct)/float64(len(test)), 0.9)
+}
+
+func TestGBDTTrainErrors(t *testing.T) {
+	model := &GBDT{}
+	assert.Error(t, model.train(nil, nil, MLConfig{}.gbdtParams()))
+	assert.Error(t, model.train([][]float64{{1}}, []int{1, 0}, MLConfig{}.gbdtParams()))
+	assert.False(t, model.trained)
+}
+
+func TestGBDTEngineSnapshot(t *testing.T) {
+	cfg := MLConfig{
+		ModelType:          "gbdt",
+		DetectionThreshold: 0.6,
+		FeatureSize:        8,
+		GenerateFakeData:   true,
+		FakeDataSize:       200,
+		GBDTTrees:          10,
+	}
+	trained, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer trained.Close()
+
+	features := []float64{0.1, 0.9, 0.3, 0.5, 0.2, 0.8, 0.4, 0.6}
+	want, err := trained.Predict(context.Background(), features, "a-b")
+	require.NoError(t, err)
+	assert.Equal(t, "gbdt", want.ModelUsed)
+
+	snapshot := trained.Snapshot()
+	assert.True(t, snapshot.GBDTTrained)
+	assert.Len(t, snapshot.GBDTTrees, 10)
+
+	cfg.GenerateFakeData = false
+	restored, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer restored.Close()
+	require.NoError(t, restored.Restore(snapshot))
+	assert.Equal(t, snapshot.Hash(), restored.Snapshot().Hash())
+
+	got, err := restored.Predict(context.Background(), features, "a-b")
+	require.NoError(t, err)
+	assert.Equal(t, want.Confidence, got.Confidence)
+
+	// Trees splitting on features the engine does not have are rejected
+	snapshot.GBDTTrees[0] = GBDTTree{Nodes: []GBDTNode{{Feature: 8, Left: 1, Right: 2}, {Leaf: true}, {Leaf: true}}}
+	assert.ErrorContains(t, restored.Restore(snapshot), "splits on feature 8")
+
+	snapshot.GBDTTrees[0] = GBDTTree{Nodes: []GBDTNode{{Feature: 0, Left: 0, Right: 1}, {Leaf: true}}}
+	assert.ErrorContains(t, restored.Restore(snapshot), "invalid children")
+}
@@ -0,0 +1,67 @@
+package ml
+
+import (
+	"fmt"
+	"math"
+
+	"gorgonia.org/gorgonia"
+)
+
+// Devices the neural network runs on
+const (
+	DeviceCPU  = "cpu"
+	DeviceCUDA = "cuda"
+)
+
+// newGPUMachine returns a machine running the graph on the first CUDA
+// device, and the name of the device
+func newGPUMachine(g *gorgonia.ExprGraph) (vm gorgonia.VM, name string, err error) {
+	if name, err = cudaDevice(); err != nil {
+		return nil, "", err
+	}
+
+	// Builds with the cuda tag initialize CUDA with the machine and panic
+	// when that fails
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to initialize CUDA: %v", r)
+		}
+	}()
+	return gorgonia.NewTapeMachine(g), name, nil
+}
+
+// newCPUMachine returns a machine running the graph on the CPU. Builds
+// with the cuda tag compile every graph for CUDA, so there the forward
+// pass runs in Go instead and the machine is nil.
+func newCPUMachine(g *gorgonia.ExprGraph) gorgonia.VM {
+	if cudaBuild {
+		return nil
+	}
+	return gorgonia.NewTapeMachine(g)
+}
+
+// forwardCPU computes the forward pass of the graph from the parameters
+// bound to it
+func (g *nnGraph) forwardCPU(features []float64) float64 {
+	hiddenWeights, hiddenBias := nodeData(g.hiddenWeights), nodeData(g.hiddenBias)
+	outputWeights, outputBias := nodeData(g.outputWeights), nodeData(g.outputBias)
+
+	output := outputBias[0]
+	for j := 0; j < nnHiddenSize; j++ {
+		hidden := hiddenBias[j]
+		for i, x := range features {
+			hidden += x * hiddenWeights[i*nnHiddenSize+j]
+		}
+		output += math.Max(hidden, 0) * outputWeights[j]
+	}
+	return 1 / (1 + math.Exp(-output))
+}
+
+// Device returns the device the neural network runs on, empty without a
+// neural network
+func (e *MLEngine) Device() string {
+	if e.nnModel == nil {
+		return ""
+	}
+	return e.nnModel.pool.device()
+}
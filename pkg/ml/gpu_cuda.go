@@ -0,0 +1,33 @@
+//go:build cuda
+
+package ml
+
+import (
+	"fmt"
+
+	"gorgonia.org/cu"
+)
+
+// cudaBuild reports whether graphs are compiled for CUDA
+const cudaBuild = true
+
+// cudaDevice returns the name of the CUDA device the neural network runs
+// on, the first one
+func cudaDevice() (string, error) {
+	devices, err := cu.NumDevices()
+	if err != nil {
+		return "", fmt.Errorf("failed to count CUDA devices: %w", err)
+	}
+	if devices == 0 {
+		return "", fmt.Errorf("no CUDA devices found")
+	}
+	device, err := cu.GetDevice(0)
+	if err != nil {
+		return "", fmt.Errorf("failed to get CUDA device: %w", err)
+	}
+	name, err := device.Name()
+	if err != nil {
+		return "", fmt.Errorf("failed to get CUDA device name: %w", err)
+	}
+	return name, nil
+}
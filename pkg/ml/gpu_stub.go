@@ -0,0 +1,13 @@
+//go:build !cuda
+
+package ml
+
+import "fmt"
+
+// cudaBuild reports whether graphs are compiled for CUDA
+const cudaBuild = false
+
+// cudaDevice reports that CUDA support was not built in
+func cudaDevice() (string, error) {
+	return "", fmt.Errorf("GPU support requires a build with the cuda tag and the CUDA toolkit")
+}
@@ -0,0 +1,37 @@
+//go:build !cuda
+
+package ml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNeuralNetworkDevice(t *testing.T) {
+	// Without the cuda tag, enabling the GPU falls back to the CPU
+	engine, err := NewMLEngine(MLConfig{
+		ModelType:          "neural_network",
+		DetectionThreshold: 0.5,
+		FeatureSize:        8,
+		GenerateFakeData:   true,
+		FakeDataSize:       100,
+		EvaluationHoldout:  0.2,
+		EnableGPU:          true,
+	})
+	require.NoError(t, err)
+	defer engine.Close()
+	assert.Equal(t, DeviceCPU, engine.Device())
+
+	// The forward pass in Go matches the graph
+	features := []float64{0.1, 0.5, 2, 0, 1, 3, 0.2, 0.7}
+	confidence, err := engine.predictNeuralNetwork(features)
+	require.NoError(t, err)
+	assert.InDelta(t, confidence, engine.nnModel.forwardCPU(features), 1e-9)
+
+	svm, err := NewMLEngine(MLConfig{ModelType: "svm", DetectionThreshold: 0.5, FeatureSize: 8})
+	require.NoError(t, err)
+	defer svm.Close()
+	assert.Empty(t, svm.Device())
+}
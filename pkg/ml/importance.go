@@ -0,0 +1,111 @@
+package ml
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	// importanceSamples bounds the held-out samples permutation importance
+	// is measured on
+	importanceSamples = 250
+
+	// importanceRepeats is the number of shuffles averaged per feature
+	importanceRepeats = 2
+)
+
+// FeatureImportance is how much the engine relies on one feature
+type FeatureImportance struct {
+	Index int `json:"index"`
+
+	// Drop in the engine's accuracy on held-out samples when the
+	// feature's values are shuffled between them; nil for models without
+	// held-out samples
+	Permutation *float64 `json:"permutation,omitempty"`
+
+	// Magnitude of the linear SVM weight of the scaled feature; nil
+	// without a trained linear SVM
+	Weight *float64 `json:"weight,omitempty"`
+}
+
+// importanceCache holds the permutation importance of the parameters
+// trained at one time
+type importanceCache struct {
+	trainedAt   time.Time
+	permutation []float64
+}
+
+// FeatureImportance returns the importance of each feature, by index.
+// Permutation importance is measured on the samples held out when the
+// model was trained; with cross-validation those are the test folds, which
+// the final model was also trained on. It is computed on first use after
+// each training and cached. ErrNotEvaluated is returned when neither
+// measure is available.
+func (e *MLEngine) FeatureImportance() ([]FeatureImportance, error) {
+	e.mu.RLock()
+	trainedAt := e.trainedAt
+	samples, labels := e.evalFeatures, e.evalLabels
+	var permutation []float64
+	if e.importance != nil && e.importance.trainedAt.Equal(trainedAt) {
+		permutation = e.importance.permutation
+	}
+	var weights []float64
+	if e.svmModel != nil && !e.svmModel.oneClass && e.svmModel.trained {
+		weights = append([]float64(nil), e.svmModel.weights.RawVector().Data...)
+	}
+	e.mu.RUnlock()
+
+	if permutation == nil && len(samples) > 0 {
+		permutation = e.permutationImportance(samples, labels)
+		e.mu.Lock()
+		if e.trainedAt.Equal(trainedAt) {
+			e.importance = &importanceCache{trainedAt: trainedAt, permutation: permutation}
+		}
+		e.mu.Unlock()
+	}
+	if permutation == nil && weights == nil {
+		return nil, ErrNotEvaluated
+	}
+
+	importance := make([]FeatureImportance, e.config.FeatureSize)
+	for i := range importance {
+		importance[i].Index = i
+		if permutation != nil {
+			importance[i].Permutation = &permutation[i]
+		}
+		if i < len(weights) {
+			w := math.Abs(weights[i])
+			importance[i].Weight = &w
+		}
+	}
+	return importance, nil
+}
+
+// permutationImportance returns, per feature, the mean drop in the
+// engine's accuracy on labelled samples when the feature's values are
+// shuffled between the samples
+func (e *MLEngine) permutationImportance(samples [][]float64, labels []int) []float64 {
+	rng := rand.New(rand.NewSource(1))
+	if len(samples) > importanceSamples {
+		keep, _ := stratifiedSplit(labels, 1-float64(importanceSamples)/float64(len(samples)), rng)
+		samples, labels = subset(samples, labels, keep)
+	}
+
+	baseline := e.evaluate(samples, labels)
+	shuffled := cloneSamples(samples)
+	importance := make([]float64, e.config.FeatureSize)
+	for j := range importance {
+		for r := 0; r < importanceRepeats; r++ {
+			for i, k := range rng.Perm(len(samples)) {
+				shuffled[i][j] = samples[k][j]
+			}
+			importance[j] += baseline - e.evaluate(shuffled, labels)
+		}
+		importance[j] /= importanceRepeats
+		for i := range shuffled {
+			shuffled[i][j] = samples[i][j]
+		}
+	}
+	return importance
+}
@@ -0,0 +1,93 @@
+package ml
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureImportance(t *testing.T) {
+	cfg := MLConfig{
+		ModelType:          "gbdt",
+		DetectionThreshold: 0.5,
+		FeatureSize:        4,
+		GBDTTrees:          20,
+		GBDTMaxDepth:       3,
+		GBDTLearningRate:   0.1,
+	}
+	engine, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	_, err = engine.FeatureImportance()
+	assert.ErrorIs(t, err, ErrNotEvaluated)
+
+	// Only feature 2 separates bots from humans
+	r := rand.New(rand.NewSource(1))
+	features := make([][]float64, 400)
+	labels := make([]int, len(features))
+	for i := range features {
+		labels[i] = i % 2
+		features[i] = []float64{r.Float64(), r.Float64(), float64(labels[i]) + 0.3*r.Float64(), r.Float64()}
+	}
+	trained, trainedLabels, eval, err := engine.trainEvaluated(features, labels, engine.models())
+	require.NoError(t, err)
+	require.NoError(t, engine.finishTraining(time.Now(), "test", trained, trainedLabels, eval))
+
+	importance, err := engine.FeatureImportance()
+	require.NoError(t, err)
+	require.Len(t, importance, 4)
+	for i, f := range importance {
+		assert.Equal(t, i, f.Index)
+		require.NotNil(t, f.Permutation)
+		assert.Nil(t, f.Weight)
+		if i != 2 {
+			assert.Less(t, *f.Permutation, *importance[2].Permutation)
+		}
+	}
+	assert.Greater(t, *importance[2].Permutation, 0.3)
+
+	// The measure is cached until the model changes
+	require.NotNil(t, engine.importance)
+	again, err := engine.FeatureImportance()
+	require.NoError(t, err)
+	assert.Same(t, importance[2].Permutation, again[2].Permutation)
+
+	// Held-out flows are persisted, so restored models can be measured
+	restored, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer restored.Close()
+	require.NoError(t, restored.Restore(engine.Snapshot()))
+	got, err := restored.FeatureImportance()
+	require.NoError(t, err)
+	assert.Equal(t, *importance[2].Permutation, *got[2].Permutation)
+
+	snapshot := engine.Snapshot()
+	snapshot.EvaluationFeatures = snapshot.EvaluationFeatures[1:]
+	assert.ErrorContains(t, restored.Restore(snapshot), "evaluation samples")
+}
+
+func TestFeatureImportanceLinearWeights(t *testing.T) {
+	engine, err := NewMLEngine(MLConfig{
+		ModelType:          "svm",
+		DetectionThreshold: 0.5,
+		FeatureSize:        8,
+		GenerateFakeData:   true,
+		FakeDataSize:       200,
+	})
+	require.NoError(t, err)
+	defer engine.Close()
+
+	importance, err := engine.FeatureImportance()
+	require.NoError(t, err)
+	weights := engine.svmModel.weights.RawVector().Data
+	for i, f := range importance {
+		require.NotNil(t, f.Permutation)
+		require.NotNil(t, f.Weight)
+		assert.GreaterOrEqual(t, *f.Weight, 0.0)
+		assert.InDelta(t, weights[i]*weights[i], *f.Weight**f.Weight, 1e-9)
+	}
+}
@@ -0,0 +1,228 @@
+package ml
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// KNN distance metrics
+const (
+	DistanceEuclidean = "euclidean"
+	DistanceManhattan = "manhattan"
+	DistanceChebyshev = "chebyshev"
+)
+
+// defaultKNNNeighbors is used when MLConfig.KNNNeighbors is unset
+const defaultKNNNeighbors = 5
+
+// distanceMetric measures distances between feature vectors. axis returns
+// the distance contribution of a difference along one axis, which is a
+// lower bound of the distance to every point across a splitting plane.
+type distanceMetric struct {
+	distance func(a, b []float64, bound float64) float64
+	axis     func(diff float64) float64
+}
+
+// distanceMetrics are the supported metrics by name. Euclidean distances
+// are compared squared. Distance functions may stop summing once bound is
+// exceeded.
+var distanceMetrics = map[string]distanceMetric{
+	DistanceEuclidean: {
+		distance: func(a, b []float64, bound float64) float64 {
+			var sum float64
+			for i := range a {
+				d := a[i] - b[i]
+				if sum += d * d; sum > bound {
+					break
+				}
+			}
+			return sum
+		},
+		axis: func(diff float64) float64 { return diff * diff },
+	},
+	DistanceManhattan: {
+		distance: func(a, b []float64, bound float64) float64 {
+			var sum float64
+			for i := range a {
+				if sum += math.Abs(a[i] - b[i]); sum > bound {
+					break
+				}
+			}
+			return sum
+		},
+		axis: math.Abs,
+	},
+	DistanceChebyshev: {
+		distance: func(a, b []float64, bound float64) float64 {
+			var largest float64
+			for i := range a {
+				largest = math.Max(largest, math.Abs(a[i]-b[i]))
+			}
+			return largest
+		},
+		axis: math.Abs,
+	},
+}
+
+// KNNClassifier is a k-nearest-neighbors classifier. Training samples are
+// indexed in a KD-tree so that neighbors are found without comparing every
+// sample.
+type KNNClassifier struct {
+	k       int
+	metric  distanceMetric
+	samples [][]float64
+	labels  []int
+	nodes   []kdNode // KD-tree, rooted at the first node
+	trained bool
+}
+
+// kdNode is a KD-tree node holding one sample. Samples in the left subtree
+// are at most the node's sample along the axis, those in the right subtree
+// at least.
+type kdNode struct {
+	sample int
+	axis   int
+	left   int // -1 if absent
+	right  int
+}
+
+// newKNNClassifier returns an untrained classifier for the configured k and
+// metric
+func newKNNClassifier(config MLConfig) (*KNNClassifier, error) {
+	k := config.KNNNeighbors
+	if k <= 0 {
+		k = defaultKNNNeighbors
+	}
+	name := config.KNNDistance
+	if name == "" {
+		name = DistanceEuclidean
+	}
+	metric, ok := distanceMetrics[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported knn distance: %s", name)
+	}
+	return &KNNClassifier{k: k, metric: metric}, nil
+}
+
+// fit indexes labelled samples, replacing the current ones. The classifier
+// keeps the slices.
+func (c *KNNClassifier) fit(samples [][]float64, labels []int) error {
+	if len(samples) == 0 || len(samples) != len(labels) {
+		return fmt.Errorf("invalid training set: %d samples, %d labels", len(samples), len(labels))
+	}
+
+	order := make([]int, len(samples))
+	for i := range order {
+		order[i] = i
+	}
+	c.samples = samples
+	c.labels = labels
+	c.nodes = make([]kdNode, 0, len(samples))
+	c.build(order)
+	c.trained = true
+	return nil
+}
+
+// build adds the subtree over the given samples and returns its index
+func (c *KNNClassifier) build(order []int) int {
+	if len(order) == 0 {
+		return -1
+	}
+
+	// Split along the axis with the largest spread
+	axis, spread := 0, -1.0
+	for a := range c.samples[order[0]] {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, i := range order {
+			lo = math.Min(lo, c.samples[i][a])
+			hi = math.Max(hi, c.samples[i][a])
+		}
+		if hi-lo > spread {
+			axis, spread = a, hi-lo
+		}
+	}
+
+	sort.Slice(order, func(i, j int) bool { return c.samples[order[i]][axis] < c.samples[order[j]][axis] })
+	median := len(order) / 2
+
+	index := len(c.nodes)
+	c.nodes = append(c.nodes, kdNode{sample: order[median], axis: axis})
+	left := c.build(order[:median])
+	right := c.build(order[median+1:])
+	c.nodes[index].left = left
+	c.nodes[index].right = right
+	return index
+}
+
+// neighbor is a sample found during a search
+type neighbor struct {
+	sample   int
+	distance float64
+}
+
+// neighborHeap is a max-heap of the nearest samples found so far
+type neighborHeap []neighbor
+
+func (h neighborHeap) Len() int           { return len(h) }
+func (h neighborHeap) Less(i, j int) bool { return h[i].distance > h[j].distance }
+func (h neighborHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *neighborHeap) Push(x any)        { *h = append(*h, x.(neighbor)) }
+func (h *neighborHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// neighbors returns the k samples nearest to a query, in no particular order
+func (c *KNNClassifier) neighbors(query []float64) []neighbor {
+	k := min(c.k, len(c.samples))
+	found := make(neighborHeap, 0, k)
+	c.search(0, query, k, &found)
+	return found
+}
+
+// search visits the subtree at index, descending first into the side of
+// the splitting plane the query lies on
+func (c *KNNClassifier) search(index int, query []float64, k int, found *neighborHeap) {
+	if index < 0 {
+		return
+	}
+	node := c.nodes[index]
+
+	bound := math.Inf(1)
+	if found.Len() == k {
+		bound = (*found)[0].distance
+	}
+	if d := c.metric.distance(query, c.samples[node.sample], bound); d < bound {
+		if found.Len() == k {
+			heap.Pop(found)
+		}
+		heap.Push(found, neighbor{sample: node.sample, distance: d})
+	}
+
+	diff := query[node.axis] - c.samples[node.sample][node.axis]
+	near, far := node.left, node.right
+	if diff > 0 {
+		near, far = far, near
+	}
+	c.search(near, query, k, found)
+	if found.Len() < k || c.metric.axis(diff) < (*found)[0].distance {
+		c.search(far, query, k, found)
+	}
+}
+
+// predict returns the share of bots among the nearest neighbors
+func (c *KNNClassifier) predict(features []float64) float64 {
+	neighbors := c.neighbors(features)
+	if len(neighbors) == 0 {
+		return 0
+	}
+	bots := 0
+	for _, n := range neighbors {
+		bots += c.labels[n.sample]
+	}
+	return float64(bots) / float64(len(neighbors))
+}
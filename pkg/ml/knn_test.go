@@ -0,0 +1,101 @@
+package ml
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKNNNeighborsMatchBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	samples := make([][]float64, 300)
+	labels := make([]int, len(samples))
+	for i := range samples {
+		samples[i] = []float64{r.Float64(), r.Float64(), r.Float64(), r.Float64()}
+		labels[i] = i % 2
+	}
+
+	for _, distance := range []string{DistanceEuclidean, DistanceManhattan, DistanceChebyshev} {
+		t.Run(distance, func(t *testing.T) {
+			knn, err := newKNNClassifier(MLConfig{KNNNeighbors: 7, KNNDistance: distance})
+			require.NoError(t, err)
+			require.NoError(t, knn.fit(samples, labels))
+
+			for q := 0; q < 20; q++ {
+				query := []float64{r.Float64(), r.Float64(), r.Float64(), r.Float64()}
+
+				var got []float64
+				for _, n := range knn.neighbors(query) {
+					got = append(got, n.distance)
+				}
+				sort.Float64s(got)
+
+				var want []float64
+				for _, s := range samples {
+					want = append(want, knn.metric.distance(query, s, 1e9))
+				}
+				sort.Float64s(want)
+				assert.InDeltaSlice(t, want[:7], got, 1e-12)
+			}
+		})
+	}
+}
+
+func TestKNNPredict(t *testing.T) {
+	knn, err := newKNNClassifier(MLConfig{KNNNeighbors: 3})
+	require.NoError(t, err)
+	require.NoError(t, knn.fit(
+		[][]float64{{0, 0}, {0, 1}, {1, 0}, {5, 5}, {5, 6}, {6, 5}},
+		[]int{0, 0, 0, 1, 1, 1},
+	))
+	assert.Equal(t, 0.0, knn.predict([]float64{0.2, 0.2}))
+	assert.Equal(t, 1.0, knn.predict([]float64{5.5, 5.5}))
+
+	// k larger than the training set uses every sample
+	knn.k = 10
+	assert.Equal(t, 0.5, knn.predict([]float64{3, 3}))
+
+	_, err = newKNNClassifier(MLConfig{KNNDistance: "cosine"})
+	assert.ErrorContains(t, err, "unsupported knn distance")
+}
+
+func TestKNNEnsembleSnapshot(t *testing.T) {
+	cfg := MLConfig{
+		ModelType:          "ensemble",
+		EnsembleModels:     []string{"svm", "knn"},
+		DetectionThreshold: 0.6,
+		FeatureSize:        8,
+		GenerateFakeData:   true,
+		FakeDataSize:       100,
+	}
+	trained, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer trained.Close()
+
+	snapshot := trained.Snapshot()
+	assert.False(t, snapshot.NNTrained)
+	assert.True(t, snapshot.KNNTrained)
+	assert.Len(t, snapshot.KNNSamples, 80) // a fifth is held out for evaluation
+
+	features := []float64{0.1, 0.9, 0.3, 0.5, 0.2, 0.8, 0.4, 0.6}
+	want, err := trained.Predict(context.Background(), features, "a-b")
+	require.NoError(t, err)
+
+	cfg.GenerateFakeData = false
+	restored, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer restored.Close()
+	require.NoError(t, restored.Restore(snapshot))
+	assert.Equal(t, snapshot.Hash(), restored.Snapshot().Hash())
+
+	got, err := restored.Predict(context.Background(), features, "a-b")
+	require.NoError(t, err)
+	assert.Equal(t, want.Confidence, got.Confidence)
+
+	snapshot.KNNLabels = snapshot.KNNLabels[1:]
+	assert.ErrorContains(t, restored.Restore(snapshot), "knn samples")
+}
@@ -0,0 +1,606 @@
+package ml
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+	"gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// Model files start with a magic string and a format version, followed by
+// a gob-encoded ModelSnapshot
+const (
+	modelFileMagic   = "ARGUSMDL"
+	modelFileVersion = 1
+)
+
+// ErrNotModelFile is returned when reading a file that is not a model file
+var ErrNotModelFile = errors.New("not a model file")
+
+// TrainingData identifies the data set a model was trained on
+type TrainingData struct {
+	Source  string `json:"source"` // "synthetic" for generated fake data, "file:" and the path or TrainingDataSource for datasets
+	Samples int    `json:"samples"`
+	Digest  string `json:"digest"` // SHA-256 of the features and labels
+}
+
+// ModelSnapshot holds the trained parameters of an MLEngine
+type ModelSnapshot struct {
+	ModelType   string
+	FeatureSize int
+	CreatedAt   time.Time
+
+	// Layout of the feature vectors the model was trained on, nil in files
+	// written before it was recorded
+	FeatureSchema *FeatureSchema
+
+	// Provenance, absent in files written before it was recorded
+	TrainingData TrainingData
+	Metrics      map[string]float64
+
+	// Confidences and labels of the samples held out from training, from
+	// which ROC and precision-recall curves are computed, and the samples
+	// themselves, from which feature importance is measured. The samples
+	// are absent in files written before they were persisted.
+	EvaluationScores   []float64
+	EvaluationLabels   []int
+	EvaluationFeatures [][]float64
+
+	// Calibration of the model scores, nil if they are used as they are
+	Calibration *Calibrator
+
+	// Scaling of the features, nil if the models take them as they are.
+	// KNN samples are stored scaled.
+	Scaler *Scaler
+
+	// Mean and standard deviation of each feature over the training
+	// samples, against which predictions are explained; absent in files
+	// written before they were persisted
+	FeatureMeans      []float64
+	FeatureDeviations []float64
+
+	// Distribution of the training samples and held-out scores for drift
+	// detection, nil in files written before it was persisted
+	Drift *DriftReference
+
+	// Neural network; the parameters are row-major and absent in files
+	// written before they were persisted
+	NNTrained       bool
+	NNHiddenWeights []float64
+	NNHiddenBias    []float64
+	NNOutputWeights []float64
+	NNOutputBias    []float64
+
+	// SVM; one-class SVMs also have the random Fourier feature mapping,
+	// with the projection row-major
+	SVMTrained    bool
+	SVMWeights    []float64
+	SVMBias       float64
+	SVMOneClass   bool
+	SVMProjection []float64
+	SVMPhase      []float64
+	SVMScale      float64
+
+	// Gradient boosted decision trees
+	GBDTTrained bool
+	GBDTBase    float64
+	GBDTTrees   []GBDTTree
+
+	// k-nearest neighbors; the KD-tree is rebuilt from the samples
+	KNNTrained bool
+	KNNSamples [][]float64
+	KNNLabels  []int
+
+	// Precision of the neural network and SVM parameters, full when empty.
+	// The parameters of quantized snapshots hold the rounded values; model
+	// files store them in QuantizedParameters instead, by name.
+	Precision           string
+	QuantizedParameters map[string]QuantizedTensor
+}
+
+// WriteModelFile writes a snapshot to path. The file is replaced atomically
+// so readers never see a partially written model.
+func WriteModelFile(path string, snapshot *ModelSnapshot) error {
+	var buf bytes.Buffer
+	buf.WriteString(modelFileMagic)
+	binary.Write(&buf, binary.BigEndian, uint16(modelFileVersion))
+	if snapshot.Precision != "" && snapshot.Precision != PrecisionFull {
+		snapshot = snapshot.packed()
+	}
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return fmt.Errorf("failed to encode model: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".model-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadModelFile reads a snapshot written by WriteModelFile
+func ReadModelFile(path string) (*ModelSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, len(modelFileMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(modelFileMagic)]) != modelFileMagic {
+		return nil, ErrNotModelFile
+	}
+	if version := binary.BigEndian.Uint16(header[len(modelFileMagic):]); version != modelFileVersion {
+		return nil, fmt.Errorf("unsupported model file version %d", version)
+	}
+
+	var snapshot ModelSnapshot
+	if err := gob.NewDecoder(r).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode model: %w", err)
+	}
+	if err := snapshot.unpack(); err != nil {
+		return nil, fmt.Errorf("failed to decode model: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// Snapshot returns a copy of the engine's trained parameters
+func (e *MLEngine) Snapshot() *ModelSnapshot {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	snapshot := &ModelSnapshot{
+		ModelType:    e.config.ModelType,
+		FeatureSize:  e.config.FeatureSize,
+		CreatedAt:    e.trainedAt,
+		TrainingData: e.trainingData,
+
+		FeatureSchema: e.config.FeatureSchema.clone(),
+		Metrics:       maps.Clone(e.metrics),
+
+		EvaluationScores:   append([]float64(nil), e.evalScores...),
+		EvaluationLabels:   append([]int(nil), e.evalLabels...),
+		EvaluationFeatures: cloneSamples(e.evalFeatures),
+		Calibration:        e.calibrator.clone(),
+		Scaler:             e.scaler.clone(),
+		FeatureMeans:       append([]float64(nil), e.featureMeans...),
+		FeatureDeviations:  append([]float64(nil), e.featureDeviations...),
+		Drift:              e.drift.clone(),
+	}
+	if snapshot.CreatedAt.IsZero() {
+		snapshot.CreatedAt = time.Now()
+	}
+	if e.quantized != nil {
+		snapshot.Precision = e.quantized.precision
+	}
+	if e.nnModel != nil {
+		snapshot.NNTrained = e.nnModel.trained
+		snapshot.NNHiddenWeights = nodeData(e.nnModel.hiddenWeights)
+		snapshot.NNHiddenBias = nodeData(e.nnModel.hiddenBias)
+		snapshot.NNOutputWeights = nodeData(e.nnModel.outputWeights)
+		snapshot.NNOutputBias = nodeData(e.nnModel.outputBias)
+	}
+	if e.svmModel != nil {
+		snapshot.SVMTrained = e.svmModel.trained
+		snapshot.SVMWeights = append([]float64(nil), e.svmModel.weights.RawVector().Data...)
+		snapshot.SVMBias = e.svmModel.bias
+		snapshot.SVMOneClass = e.svmModel.oneClass
+		if e.svmModel.projection != nil {
+			snapshot.SVMProjection = append([]float64(nil), e.svmModel.projection.RawMatrix().Data...)
+			snapshot.SVMPhase = append([]float64(nil), e.svmModel.phase...)
+			snapshot.SVMScale = e.svmModel.scale
+		}
+	}
+	if e.gbdtModel != nil {
+		snapshot.GBDTTrained = e.gbdtModel.trained
+		snapshot.GBDTBase = e.gbdtModel.base
+		snapshot.GBDTTrees = cloneTrees(e.gbdtModel.trees)
+	}
+	if e.knnModel != nil {
+		snapshot.KNNTrained = e.knnModel.trained
+		snapshot.KNNSamples = cloneSamples(e.knnModel.samples)
+		snapshot.KNNLabels = append([]int(nil), e.knnModel.labels...)
+	}
+	return snapshot
+}
+
+// CheckCompatible reports whether a snapshot can be restored into the engine
+func (e *MLEngine) CheckCompatible(snapshot *ModelSnapshot) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.checkCompatible(snapshot)
+}
+
+func (e *MLEngine) checkCompatible(snapshot *ModelSnapshot) error {
+	if e.tflite != nil {
+		return fmt.Errorf("snapshots cannot be restored into a tflite model")
+	}
+	if snapshot.ModelType != e.config.ModelType {
+		return fmt.Errorf("model type %s does not match engine model type %s", snapshot.ModelType, e.config.ModelType)
+	}
+	if snapshot.FeatureSize != e.config.FeatureSize {
+		return fmt.Errorf("model feature size %d does not match engine feature size %d", snapshot.FeatureSize, e.config.FeatureSize)
+	}
+	if snapshot.FeatureSchema != nil {
+		if err := e.config.FeatureSchema.Check(snapshot.FeatureSchema); err != nil {
+			return err
+		}
+	}
+	if !validPrecision(snapshot.Precision) {
+		return fmt.Errorf("unsupported model precision: %s", snapshot.Precision)
+	}
+	if len(snapshot.EvaluationScores) != len(snapshot.EvaluationLabels) {
+		return fmt.Errorf("model has %d evaluation scores and %d labels", len(snapshot.EvaluationScores), len(snapshot.EvaluationLabels))
+	}
+	if n := len(snapshot.EvaluationFeatures); n > 0 {
+		if n != len(snapshot.EvaluationLabels) {
+			return fmt.Errorf("model has %d evaluation samples and %d labels", n, len(snapshot.EvaluationLabels))
+		}
+		for i, sample := range snapshot.EvaluationFeatures {
+			if len(sample) != e.config.FeatureSize {
+				return fmt.Errorf("evaluation sample %d has %d features, expected %d", i, len(sample), e.config.FeatureSize)
+			}
+		}
+	}
+	if snapshot.Calibration != nil {
+		if err := snapshot.Calibration.validate(); err != nil {
+			return err
+		}
+	}
+	if snapshot.Scaler != nil {
+		if err := snapshot.Scaler.validate(e.config.FeatureSize); err != nil {
+			return err
+		}
+	}
+	if n := len(snapshot.FeatureMeans); n > 0 && (n != e.config.FeatureSize || len(snapshot.FeatureDeviations) != n) {
+		return fmt.Errorf("model has %d feature means and %d deviations, expected %d", n, len(snapshot.FeatureDeviations), e.config.FeatureSize)
+	}
+	if snapshot.Drift != nil {
+		if err := snapshot.Drift.validate(e.config.FeatureSize); err != nil {
+			return err
+		}
+	}
+	if e.svmModel != nil {
+		if snapshot.SVMOneClass != e.svmModel.oneClass {
+			return fmt.Errorf("model SVM mode %s does not match engine SVM mode %s", svmMode(snapshot.SVMOneClass), svmMode(e.svmModel.oneClass))
+		}
+		if snapshot.SVMTrained {
+			if err := checkSVMSnapshot(snapshot, e.config.FeatureSize); err != nil {
+				return err
+			}
+		}
+	}
+	if e.gbdtModel != nil && snapshot.GBDTTrained {
+		if len(snapshot.GBDTTrees) == 0 {
+			return fmt.Errorf("model has no gradient boosted trees")
+		}
+		for i := range snapshot.GBDTTrees {
+			if err := snapshot.GBDTTrees[i].validate(e.config.FeatureSize); err != nil {
+				return fmt.Errorf("gradient boosted tree %d: %w", i, err)
+			}
+		}
+	}
+	if e.knnModel != nil && snapshot.KNNTrained {
+		if len(snapshot.KNNSamples) == 0 || len(snapshot.KNNSamples) != len(snapshot.KNNLabels) {
+			return fmt.Errorf("model has %d knn samples and %d labels", len(snapshot.KNNSamples), len(snapshot.KNNLabels))
+		}
+		for i, sample := range snapshot.KNNSamples {
+			if len(sample) != e.config.FeatureSize {
+				return fmt.Errorf("knn sample %d has %d features, expected %d", i, len(sample), e.config.FeatureSize)
+			}
+			if label := snapshot.KNNLabels[i]; label != 0 && label != 1 {
+				return fmt.Errorf("knn sample %d has invalid label %d", i, label)
+			}
+		}
+	}
+	if e.nnModel != nil && len(snapshot.NNHiddenWeights) > 0 {
+		for _, p := range []struct {
+			name string
+			data []float64
+			node *gorgonia.Node
+		}{
+			{"hidden weights", snapshot.NNHiddenWeights, e.nnModel.hiddenWeights},
+			{"hidden bias", snapshot.NNHiddenBias, e.nnModel.hiddenBias},
+			{"output weights", snapshot.NNOutputWeights, e.nnModel.outputWeights},
+			{"output bias", snapshot.NNOutputBias, e.nnModel.outputBias},
+		} {
+			if want := p.node.Shape().TotalSize(); len(p.data) != want {
+				return fmt.Errorf("model has %d neural network %s, expected %d", len(p.data), p.name, want)
+			}
+		}
+	}
+	return nil
+}
+
+// Restore replaces the engine's trained parameters with a snapshot
+func (e *MLEngine) Restore(snapshot *ModelSnapshot) error {
+	e.lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkCompatible(snapshot); err != nil {
+		return err
+	}
+
+	// Index the KNN samples before changing anything, as it may fail
+	var knn *KNNClassifier
+	if e.knnModel != nil {
+		var err error
+		if knn, err = newKNNClassifier(e.config); err != nil {
+			return err
+		}
+		if snapshot.KNNTrained {
+			if err := knn.fit(cloneSamples(snapshot.KNNSamples), append([]int(nil), snapshot.KNNLabels...)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if e.nnModel != nil {
+		if len(snapshot.NNHiddenWeights) > 0 {
+			for node, data := range map[*gorgonia.Node][]float64{
+				e.nnModel.hiddenWeights: snapshot.NNHiddenWeights,
+				e.nnModel.hiddenBias:    snapshot.NNHiddenBias,
+				e.nnModel.outputWeights: snapshot.NNOutputWeights,
+				e.nnModel.outputBias:    snapshot.NNOutputBias,
+			} {
+				value := tensor.New(tensor.WithShape(node.Shape()...), tensor.WithBacking(append([]float64(nil), data...)))
+				if err := gorgonia.Let(node, value); err != nil {
+					return fmt.Errorf("failed to restore %s: %w", node.Name(), err)
+				}
+			}
+			e.nnModel.generation++
+		}
+		e.nnModel.trained = snapshot.NNTrained
+	}
+	e.trainedAt = snapshot.CreatedAt
+	e.trainingData = snapshot.TrainingData
+	e.metrics = maps.Clone(snapshot.Metrics)
+	e.evalScores = append([]float64(nil), snapshot.EvaluationScores...)
+	e.evalLabels = append([]int(nil), snapshot.EvaluationLabels...)
+	e.evalFeatures = cloneSamples(snapshot.EvaluationFeatures)
+	e.importance = nil
+	e.calibrator = snapshot.Calibration.clone()
+	e.scaler = snapshot.Scaler.clone()
+	e.featureMeans = append([]float64(nil), snapshot.FeatureMeans...)
+	e.featureDeviations = append([]float64(nil), snapshot.FeatureDeviations...)
+	e.drift = snapshot.Drift.clone()
+	e.recordEvaluation(snapshot.Metrics)
+	if e.svmModel != nil {
+		if e.svmModel.oneClass {
+			model := newOneClassSVM()
+			if snapshot.SVMTrained {
+				model.weights = mat.NewVecDense(svmFourierFeatures, append([]float64(nil), snapshot.SVMWeights...))
+				model.bias = snapshot.SVMBias
+				model.projection = mat.NewDense(svmFourierFeatures, e.config.FeatureSize, append([]float64(nil), snapshot.SVMProjection...))
+				model.phase = append([]float64(nil), snapshot.SVMPhase...)
+				model.scale = snapshot.SVMScale
+				model.trained = true
+			}
+			e.svmModel = model
+		} else {
+			weights := mat.NewVecDense(e.config.FeatureSize, nil)
+			if snapshot.SVMTrained {
+				weights = mat.NewVecDense(e.config.FeatureSize, append([]float64(nil), snapshot.SVMWeights...))
+			}
+			e.svmModel.weights = weights
+			e.svmModel.bias = snapshot.SVMBias
+			e.svmModel.trained = snapshot.SVMTrained
+		}
+	}
+	if knn != nil {
+		e.knnModel = knn
+	}
+	e.quantized = newQuantizedModels(snapshot)
+
+	// Online learning continues from the restored samples; tree models
+	// are refit from new flows only
+	if e.online != nil {
+		if knn != nil && knn.trained {
+			e.seedReservoir(knn.samples, knn.labels)
+		} else {
+			e.online.reset()
+		}
+	}
+	if e.gbdtModel != nil {
+		e.gbdtModel = &GBDT{
+			base:    snapshot.GBDTBase,
+			trees:   cloneTrees(snapshot.GBDTTrees),
+			trained: snapshot.GBDTTrained,
+		}
+	}
+	return nil
+}
+
+// SaveModel writes the engine's trained parameters to a model file,
+// creating its directory if needed
+func (e *MLEngine) SaveModel(path string) error {
+	if e.tflite != nil {
+		return fmt.Errorf("tflite models cannot be saved")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return WriteModelFile(path, e.Snapshot())
+}
+
+// LoadModel restores the engine's trained parameters from a model file.
+// Engines running a tflite model open the file as a new TFLite model and
+// swap it in. The file is validated first; on failure the current model
+// stays in use.
+func (e *MLEngine) LoadModel(path string) error {
+	if e.config.ModelFormat == ModelFormatTFLite {
+		return e.swapTFLiteModel(path)
+	}
+
+	snapshot, err := ReadModelFile(path)
+	if err != nil {
+		return err
+	}
+	return e.Restore(snapshot)
+}
+
+// checkSVMSnapshot checks the sizes of trained SVM parameters
+func checkSVMSnapshot(snapshot *ModelSnapshot, featureSize int) error {
+	if !snapshot.SVMOneClass {
+		if len(snapshot.SVMWeights) != featureSize {
+			return fmt.Errorf("model has %d SVM weights, expected %d", len(snapshot.SVMWeights), featureSize)
+		}
+		return nil
+	}
+
+	if len(snapshot.SVMWeights) != svmFourierFeatures {
+		return fmt.Errorf("model has %d one-class SVM weights, expected %d", len(snapshot.SVMWeights), svmFourierFeatures)
+	}
+	if len(snapshot.SVMProjection) != svmFourierFeatures*featureSize || len(snapshot.SVMPhase) != svmFourierFeatures {
+		return fmt.Errorf("model has an invalid one-class SVM feature mapping")
+	}
+	if snapshot.SVMScale <= 0 {
+		return fmt.Errorf("model has invalid one-class SVM scale %g", snapshot.SVMScale)
+	}
+	return nil
+}
+
+// svmMode names the SVM mode
+func svmMode(oneClass bool) string {
+	if oneClass {
+		return SVMModeOneClass
+	}
+	return SVMModeBinary
+}
+
+// cloneSamples returns a deep copy of feature vectors
+func cloneSamples(samples [][]float64) [][]float64 {
+	if samples == nil {
+		return nil
+	}
+	cloned := make([][]float64, len(samples))
+	for i, sample := range samples {
+		cloned[i] = append([]float64(nil), sample...)
+	}
+	return cloned
+}
+
+// nodeData returns a copy of the values bound to a node
+func nodeData(n *gorgonia.Node) []float64 {
+	if n.Value() == nil {
+		return nil
+	}
+	data, _ := n.Value().Data().([]float64)
+	return append([]float64(nil), data...)
+}
+
+// swapTFLiteModel replaces the engine's TFLite model with the one at path
+func (e *MLEngine) swapTFLiteModel(path string) error {
+	model, err := OpenTFLiteModel(path, e.config.TFLiteThreads)
+	if err != nil {
+		return err
+	}
+	if model.InputSize() != e.config.FeatureSize {
+		model.Close()
+		return fmt.Errorf("tflite model input size %d does not match feature size %d", model.InputSize(), e.config.FeatureSize)
+	}
+
+	e.lock()
+	previous := e.tflite
+	e.tflite = model
+	e.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// Hash returns the SHA-256 of the snapshot's model type, feature size and
+// parameters, identifying a model version independent of its metadata
+func (s *ModelSnapshot) Hash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%t\x00%t\x00", s.ModelType, s.FeatureSize, s.NNTrained, s.SVMTrained)
+	for _, params := range [][]float64{s.NNHiddenWeights, s.NNHiddenBias, s.NNOutputWeights, s.NNOutputBias, s.SVMWeights, {s.SVMBias}} {
+		binary.Write(h, binary.BigEndian, uint64(len(params)))
+		binary.Write(h, binary.BigEndian, params)
+	}
+	if s.SVMOneClass {
+		fmt.Fprintf(h, "one_class\x00")
+		for _, params := range [][]float64{s.SVMProjection, s.SVMPhase, {s.SVMScale}} {
+			binary.Write(h, binary.BigEndian, uint64(len(params)))
+			binary.Write(h, binary.BigEndian, params)
+		}
+	}
+	// Samples, calibration and trees are only hashed when present so that
+	// the IDs of other model types are unchanged
+	if len(s.KNNSamples) > 0 {
+		fmt.Fprintf(h, "%t\x00", s.KNNTrained)
+		binary.Write(h, binary.BigEndian, uint64(len(s.KNNSamples)))
+		for i, sample := range s.KNNSamples {
+			binary.Write(h, binary.BigEndian, sample)
+			binary.Write(h, binary.BigEndian, int64(s.KNNLabels[i]))
+		}
+	}
+	if c := s.Calibration; c != nil {
+		fmt.Fprintf(h, "%s\x00", c.Method)
+		for _, params := range [][]float64{{c.A, c.B}, c.Thresholds, c.Values} {
+			binary.Write(h, binary.BigEndian, uint64(len(params)))
+			binary.Write(h, binary.BigEndian, params)
+		}
+	}
+	if sc := s.Scaler; sc != nil {
+		fmt.Fprintf(h, "%s\x00", sc.Method)
+		for _, params := range [][]float64{sc.Offsets, sc.Factors} {
+			binary.Write(h, binary.BigEndian, uint64(len(params)))
+			binary.Write(h, binary.BigEndian, params)
+		}
+	}
+	if len(s.GBDTTrees) > 0 {
+		fmt.Fprintf(h, "%t\x00", s.GBDTTrained)
+		binary.Write(h, binary.BigEndian, s.GBDTBase)
+		for _, tree := range s.GBDTTrees {
+			binary.Write(h, binary.BigEndian, uint64(len(tree.Nodes)))
+			for _, n := range tree.Nodes {
+				binary.Write(h, binary.BigEndian, []int64{int64(n.Feature), int64(n.Left), int64(n.Right)})
+				binary.Write(h, binary.BigEndian, []float64{n.Threshold, n.Value})
+				binary.Write(h, binary.BigEndian, n.Leaf)
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// datasetDigest returns the SHA-256 of a data set's features and labels
+func datasetDigest(features [][]float64, labels []int) string {
+	h := sha256.New()
+	var buf [8]byte
+	for i, row := range features {
+		for _, v := range row {
+			binary.BigEndian.PutUint64(buf[:], math.Float64bits(v))
+			h.Write(buf[:])
+		}
+		binary.BigEndian.PutUint64(buf[:], uint64(labels[i]))
+		h.Write(buf[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,132 @@
+package ml
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModelPersistence(t *testing.T) {
+	cfg := MLConfig{
+		ModelType:          "ensemble",
+		DetectionThreshold: 0.6,
+		FeatureSize:        8,
+		GenerateFakeData:   true,
+		FakeDataSize:       50,
+		ModelPath:          filepath.Join(t.TempDir(), "models", "bot_detection_model"),
+		SaveModel:          true,
+		LoadModel:          true,
+	}
+
+	// Without a saved model the engine trains and saves one
+	trained, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer trained.Close()
+	require.FileExists(t, cfg.ModelPath)
+
+	features := []float64{0.1, 0.9, 0.3, 0.5, 0.2, 0.8, 0.4, 0.6}
+	want, err := trained.Predict(context.Background(), features, "a-b")
+	require.NoError(t, err)
+
+	// The next engine starts from the saved parameters without training
+	loaded, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer loaded.Close()
+	assert.Zero(t, loaded.GetStatistics().TrainingTime)
+
+	got, err := loaded.Predict(context.Background(), features, "a-b")
+	require.NoError(t, err)
+	assert.Equal(t, want.Confidence, got.Confidence)
+
+	snapshot := loaded.Snapshot()
+	assert.True(t, snapshot.NNTrained)
+	assert.Len(t, snapshot.NNHiddenWeights, 8*nnHiddenSize)
+	assert.Equal(t, trained.Snapshot().NNOutputWeights, snapshot.NNOutputWeights)
+	assert.Equal(t, trained.Snapshot().SVMWeights, snapshot.SVMWeights)
+}
+
+func TestLoadModelErrors(t *testing.T) {
+	dir := t.TempDir()
+	cfg := MLConfig{ModelType: "neural_network", FeatureSize: 8, ModelPath: filepath.Join(dir, "model"), LoadModel: true}
+
+	require.NoError(t, os.WriteFile(cfg.ModelPath, []byte("garbage"), 0o644))
+	_, err := NewMLEngine(cfg)
+	assert.ErrorIs(t, err, ErrNotModelFile)
+
+	snapshot := &ModelSnapshot{ModelType: "neural_network", FeatureSize: 8, NNTrained: true, NNHiddenWeights: []float64{1}}
+	require.NoError(t, WriteModelFile(cfg.ModelPath, snapshot))
+	_, err = NewMLEngine(cfg)
+	assert.ErrorContains(t, err, "neural network hidden weights")
+}
+
+func TestTrainOnDataset(t *testing.T) {
+	// Feature 1 separates bots from humans; the file lists it first
+	var b strings.Builder
+	b.WriteString("separating,noise,verdict\n")
+	for i := 0; i < 100; i++ {
+		label := "human"
+		if i%2 == 1 {
+			label = "bot"
+		}
+		fmt.Fprintf(&b, "%d,%v,%s\n", i%2, float64(i%7)/7, label)
+	}
+	path := filepath.Join(t.TempDir(), "train.csv")
+	require.NoError(t, os.WriteFile(path, []byte(b.String()), 0o644))
+
+	cfg := MLConfig{
+		ModelType:              "gbdt",
+		DetectionThreshold:     0.5,
+		FeatureSize:            2,
+		GBDTTrees:              10,
+		GBDTMaxDepth:           2,
+		GBDTLearningRate:       0.3,
+		GenerateFakeData:       true,
+		FakeDataSize:           50,
+		TrainingDataPath:       path,
+		TrainingLabelColumn:    "verdict",
+		TrainingFeatureColumns: []string{"noise", "separating"},
+	}
+	engine, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	provenance := engine.Snapshot().TrainingData
+	assert.Equal(t, "file:"+path, provenance.Source)
+	assert.Equal(t, 80, provenance.Samples) // less the held-out fifth
+
+	result, err := engine.Predict(context.Background(), []float64{0.5, 1}, "a-b")
+	require.NoError(t, err)
+	assert.True(t, result.IsBot)
+
+	// Retraining reads the file again
+	require.NoError(t, os.WriteFile(path, []byte("separating,noise,verdict\n1,0,bot\n0,0,human\n1,1,bot\n0,1,human\n"), 0o644))
+	require.NoError(t, engine.Retrain())
+	assert.Equal(t, 4, engine.Snapshot().TrainingData.Samples)
+
+	cfg.TrainingFeatureColumns = []string{"noise"}
+	_, err = NewMLEngine(cfg)
+	assert.ErrorContains(t, err, "1 feature columns, expected 2")
+
+	// A separate evaluation dataset: trained on all samples, evaluated on
+	// the other file, under the configured source name
+	test := filepath.Join(t.TempDir(), "test.csv")
+	require.NoError(t, os.WriteFile(test, []byte("separating,noise,verdict\n1,0.5,bot\n0,0.5,human\n"), 0o644))
+	require.NoError(t, os.WriteFile(path, []byte(b.String()), 0o644))
+	cfg.TrainingFeatureColumns = []string{"noise", "separating"}
+	cfg.TrainingDataSource = "dataset:flows@v2"
+	cfg.EvaluationDataPath = test
+	engine, err = NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer engine.Close()
+	snapshot := engine.Snapshot()
+	assert.Equal(t, "dataset:flows@v2", snapshot.TrainingData.Source)
+	assert.Equal(t, 100, snapshot.TrainingData.Samples)
+	assert.Equal(t, 1.0, snapshot.Metrics["test_accuracy"])
+	assert.Equal(t, "dataset:flows@v2", engine.DriftReference().Source)
+}
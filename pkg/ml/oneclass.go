@@ -0,0 +1,127 @@
+package ml
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// SVM modes
+const (
+	SVMModeBinary   = "binary"    // trained on bot and human samples
+	SVMModeOneClass = "one_class" // trained on human samples only
+)
+
+const (
+	// svmFourierFeatures is the number of random Fourier features the
+	// one-class SVM maps inputs to
+	svmFourierFeatures = 256
+
+	// oneClassEpochs is the number of passes over the training samples
+	oneClassEpochs = 20
+
+	// defaultSVMNu is used when MLConfig.SVMNu is unset
+	defaultSVMNu = 0.05
+)
+
+// newOneClassSVM returns an untrained one-class SVM
+func newOneClassSVM() *SVMClassifier {
+	return &SVMClassifier{
+		weights:  mat.NewVecDense(svmFourierFeatures, nil),
+		oneClass: true,
+	}
+}
+
+// fitOneClass learns the region of feature space occupied by samples. The
+// RBF kernel exp(-gamma*|x-y|^2) is approximated with random Fourier
+// features so that training is a linear problem, solved with stochastic
+// subgradient descent. nu is the share of samples left outside the region.
+func (c *SVMClassifier) fitOneClass(samples [][]float64, nu, gamma float64, rng *rand.Rand) error {
+	if len(samples) == 0 {
+		return fmt.Errorf("no training data provided")
+	}
+	width := len(samples[0])
+
+	projection := mat.NewDense(svmFourierFeatures, width, nil)
+	phase := make([]float64, svmFourierFeatures)
+	std := math.Sqrt(2 * gamma)
+	for i := range phase {
+		for j := 0; j < width; j++ {
+			projection.Set(i, j, rng.NormFloat64()*std)
+		}
+		phase[i] = rng.Float64() * 2 * math.Pi
+	}
+	c.projection = projection
+	c.phase = phase
+
+	mapped := make([]*mat.VecDense, len(samples))
+	for i, s := range samples {
+		mapped[i] = c.fourierFeatures(s)
+	}
+
+	// Minimize |w|^2/2 - rho + mean(max(0, rho - w.z))/nu
+	weights := mat.NewVecDense(svmFourierFeatures, nil)
+	var rho float64
+	order := rng.Perm(len(mapped))
+	step := 0
+	for epoch := 0; epoch < oneClassEpochs; epoch++ {
+		rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		for _, i := range order {
+			step++
+			eta := 1 / float64(step+1)
+			violated := mat.Dot(weights, mapped[i]) < rho
+
+			weights.ScaleVec(1-eta, weights)
+			rho += eta
+			if violated {
+				weights.AddScaledVec(weights, eta/nu, mapped[i])
+				rho -= eta / nu
+			}
+		}
+	}
+
+	// Place the boundary so that a share nu of the samples is outside it,
+	// and scale confidences by the spread of the training scores
+	scores := make([]float64, len(mapped))
+	var mean float64
+	for i, z := range mapped {
+		scores[i] = mat.Dot(weights, z)
+		mean += scores[i]
+	}
+	mean /= float64(len(scores))
+	var variance float64
+	for _, s := range scores {
+		variance += (s - mean) * (s - mean)
+	}
+	sort.Float64s(scores)
+
+	c.weights = weights
+	c.bias = scores[min(int(nu*float64(len(scores))), len(scores)-1)]
+	c.scale = math.Sqrt(variance / float64(len(scores)))
+	if c.scale == 0 {
+		c.scale = 1
+	}
+	c.trained = true
+	return nil
+}
+
+// fourierFeatures maps a sample to the random Fourier feature space
+func (c *SVMClassifier) fourierFeatures(features []float64) *mat.VecDense {
+	z := mat.NewVecDense(len(c.phase), nil)
+	z.MulVec(c.projection, mat.NewVecDense(len(features), features))
+	norm := math.Sqrt(2 / float64(len(c.phase)))
+	for i, p := range c.phase {
+		z.SetVec(i, norm*math.Cos(z.AtVec(i)+p))
+	}
+	return z
+}
+
+// predictOneClass returns the bot probability of a sample, which is above
+// 0.5 outside the learned region of human traffic
+func (c *SVMClassifier) predictOneClass(features []float64) float64 {
+	decision := mat.Dot(c.weights, c.fourierFeatures(features)) - c.bias
+	return sigmoid(-decision / c.scale)
+}
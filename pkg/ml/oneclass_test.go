@@ -0,0 +1,91 @@
+package ml
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// baselineTraffic returns samples clustered around 0.3 in every feature
+func baselineTraffic(n, width int, r *rand.Rand) [][]float64 {
+	samples := make([][]float64, n)
+	for i := range samples {
+		samples[i] = make([]float64, width)
+		for j := range samples[i] {
+			samples[i][j] = 0.3 + r.NormFloat64()*0.05
+		}
+	}
+	return samples
+}
+
+func TestOneClassSVMNovelty(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	cfg := MLConfig{
+		ModelType:          "svm",
+		SVMMode:            SVMModeOneClass,
+		DetectionThreshold: 0.6,
+		FeatureSize:        8,
+	}
+	engine, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.TrainBaseline(baselineTraffic(400, 8, r)))
+	snapshot := engine.Snapshot()
+	assert.Equal(t, "baseline", snapshot.TrainingData.Source)
+	assert.InDelta(t, 0.95, snapshot.Metrics["training_accuracy"], 0.05)
+
+	// Fresh baseline traffic is mostly human, traffic far from it is not
+	humans := 0
+	for _, sample := range baselineTraffic(100, 8, r) {
+		result, err := engine.Predict(context.Background(), sample, "a-b")
+		require.NoError(t, err)
+		if !result.IsBot {
+			humans++
+		}
+	}
+	assert.GreaterOrEqual(t, humans, 85)
+
+	outlier := []float64{0.9, 0.1, 0.9, 0.1, 0.9, 0.1, 0.9, 0.1}
+	result, err := engine.Predict(context.Background(), outlier, "a-b")
+	require.NoError(t, err)
+	assert.True(t, result.IsBot)
+
+	// The feature mapping is persisted with the weights
+	restored, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer restored.Close()
+	require.NoError(t, restored.Restore(snapshot))
+	got, err := restored.Predict(context.Background(), outlier, "a-b")
+	require.NoError(t, err)
+	assert.Equal(t, result.Confidence, got.Confidence)
+
+	// Binary and one-class parameters are not interchangeable
+	binary, err := NewMLEngine(MLConfig{ModelType: "svm", FeatureSize: 8})
+	require.NoError(t, err)
+	defer binary.Close()
+	assert.ErrorContains(t, binary.Restore(snapshot), "SVM mode one_class")
+	assert.ErrorContains(t, binary.TrainBaseline(baselineTraffic(10, 8, r)), "requires a one-class SVM")
+}
+
+func TestOneClassSVMTrainsOnHumans(t *testing.T) {
+	engine, err := NewMLEngine(MLConfig{
+		ModelType:        "svm",
+		SVMMode:          SVMModeOneClass,
+		FeatureSize:      8,
+		GenerateFakeData: true,
+		FakeDataSize:     200,
+	})
+	require.NoError(t, err)
+	defer engine.Close()
+
+	snapshot := engine.Snapshot()
+	assert.True(t, snapshot.SVMTrained)
+	assert.True(t, snapshot.SVMOneClass)
+	assert.Len(t, snapshot.SVMWeights, svmFourierFeatures)
+
+	assert.ErrorContains(t, engine.TrainBaseline([][]float64{{1, 2}}), "has 2 features")
+}
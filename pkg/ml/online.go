@@ -0,0 +1,220 @@
+package ml
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// defaultOnlineLearningRate is the SGD step size used when
+	// MLConfig.LearningRate is unset
+	defaultOnlineLearningRate = 0.01
+
+	// svmRegularization is the L2 penalty of the binary SVM's SGD updates
+	svmRegularization = 1e-4
+
+	// Defaults for unset MLConfig online learning options
+	defaultOnlineBufferSize   = 5000
+	defaultOnlineRefitSamples = 200
+)
+
+// reservoir keeps a uniform sample of all labelled flows a model has been
+// trained on, so that models without incremental updates can be refit on
+// old and new data together
+type reservoir struct {
+	capacity int
+	seen     int64
+	pending  int // samples added since the last refit
+	features [][]float64
+	labels   []int
+	rand     *rand.Rand
+}
+
+func newReservoir(capacity int) *reservoir {
+	if capacity <= 0 {
+		capacity = defaultOnlineBufferSize
+	}
+	return &reservoir{
+		capacity: capacity,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// add offers a sample to the reservoir
+func (r *reservoir) add(features []float64, label int) {
+	r.seen++
+	r.pending++
+	if len(r.features) < r.capacity {
+		r.features = append(r.features, features)
+		r.labels = append(r.labels, label)
+		return
+	}
+	if i := r.rand.Int63n(r.seen); i < int64(r.capacity) {
+		r.features[i] = features
+		r.labels[i] = label
+	}
+}
+
+// reset empties the reservoir
+func (r *reservoir) reset() {
+	r.seen = 0
+	r.pending = 0
+	r.features = nil
+	r.labels = nil
+}
+
+// contents returns copies of the sampled features and labels
+func (r *reservoir) contents() ([][]float64, []int) {
+	return cloneSamples(r.features), append([]int(nil), r.labels...)
+}
+
+// partialFit takes a stochastic gradient step on one labelled sample. One-
+// class SVMs only learn from human samples.
+func (c *SVMClassifier) partialFit(features []float64, label int, rate, nu float64) {
+	if c.oneClass {
+		if label != 0 {
+			return
+		}
+		z := c.fourierFeatures(features)
+		violated := mat.Dot(c.weights, z) < c.bias
+
+		// Scale the step so that the offset moves by at most rate
+		eta := rate * nu
+		c.weights.ScaleVec(1-eta, c.weights)
+		c.bias += eta
+		if violated {
+			c.weights.AddScaledVec(c.weights, eta/nu, z)
+			c.bias -= eta / nu
+		}
+		return
+	}
+
+	y := 1.0
+	if label == 0 {
+		y = -1
+	}
+	x := mat.NewVecDense(len(features), features)
+	margin := y * (mat.Dot(c.weights, x) + c.bias)
+	c.weights.ScaleVec(1-rate*svmRegularization, c.weights)
+	if margin < 1 {
+		c.weights.AddScaledVec(c.weights, rate*y, x)
+		c.bias += rate * y
+	}
+	c.trained = true
+}
+
+// PartialFit updates the models incrementally with newly labelled flows,
+// labelled 1 for bots and 0 for humans. The SVM takes a stochastic
+// gradient step per flow. Gradient boosted trees and k-nearest neighbors
+// keep a reservoir sample of the flows they were trained on and are refit
+// from it once OnlineRefitSamples new flows have arrived. The neural
+// network and the feature scaling are not updated.
+func (e *MLEngine) PartialFit(features [][]float64, labels []int) error {
+	if e.tflite != nil {
+		return fmt.Errorf("tflite models cannot be trained in process")
+	}
+	if len(features) != len(labels) {
+		return fmt.Errorf("got %d samples and %d labels", len(features), len(labels))
+	}
+	for i, row := range features {
+		if len(row) != e.config.FeatureSize {
+			return fmt.Errorf("sample %d has %d features, expected %d", i, len(row), e.config.FeatureSize)
+		}
+		if labels[i] != 0 && labels[i] != 1 {
+			return fmt.Errorf("sample %d has invalid label %d", i, labels[i])
+		}
+	}
+	if len(features) == 0 {
+		return nil
+	}
+	features = cloneSamples(features)
+
+	rate := e.config.LearningRate
+	if rate <= 0 {
+		rate = defaultOnlineLearningRate
+	}
+	nu := e.config.SVMNu
+	if nu <= 0 || nu > 1 {
+		nu = defaultSVMNu
+	}
+	refitSamples := e.config.OnlineRefitSamples
+	if refitSamples <= 0 {
+		refitSamples = defaultOnlineRefitSamples
+	}
+
+	// Refits happen outside the engine lock so that inference continues;
+	// fitMu keeps them from overlapping
+	e.fitMu.Lock()
+	defer e.fitMu.Unlock()
+
+	e.lock()
+	scaled := e.scaler.transformAll(features)
+	if e.svmModel != nil && (e.svmModel.trained || !e.svmModel.oneClass) {
+		for i, row := range scaled {
+			e.svmModel.partialFit(row, labels[i], rate, nu)
+		}
+	}
+	var refitFeatures [][]float64
+	var refitLabels []int
+	if e.online != nil {
+		for i, row := range scaled {
+			e.online.add(row, labels[i])
+		}
+		if e.online.pending >= refitSamples {
+			e.online.pending = 0
+			refitFeatures, refitLabels = e.online.contents()
+		}
+	}
+	e.trainedAt = time.Now()
+	e.trainingData = TrainingData{
+		Source:  "online",
+		Samples: e.trainingData.Samples + len(features),
+		Digest:  chainDigest(e.trainingData.Digest, datasetDigest(features, labels)),
+	}
+	e.mu.Unlock()
+
+	if refitFeatures != nil {
+		if e.gbdtModel != nil {
+			if err := e.trainGBDT(refitFeatures, refitLabels); err != nil {
+				return fmt.Errorf("failed to refit gradient boosted trees: %w", err)
+			}
+		}
+		if e.knnModel != nil {
+			if err := e.trainKNN(refitFeatures, refitLabels); err != nil {
+				return fmt.Errorf("failed to refit knn: %w", err)
+			}
+		}
+	}
+
+	e.stats.mu.Lock()
+	e.stats.OnlineSamples += int64(len(features))
+	e.stats.mu.Unlock()
+	return nil
+}
+
+// seedReservoir replaces the reservoir contents with the samples a model
+// was trained on
+func (e *MLEngine) seedReservoir(features [][]float64, labels []int) {
+	if e.online == nil {
+		return
+	}
+	e.online.reset()
+	for i, row := range features {
+		e.online.add(row, labels[i])
+	}
+	e.online.pending = 0
+}
+
+// chainDigest combines the digest of a data set with that of data added to
+// it
+func chainDigest(previous, added string) string {
+	h := sha256.New()
+	h.Write([]byte(previous))
+	h.Write([]byte(added))
+	return hex.EncodeToString(h.Sum(nil))
+}
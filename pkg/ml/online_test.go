@@ -0,0 +1,93 @@
+package ml
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReservoir(t *testing.T) {
+	r := newReservoir(10)
+	for i := 0; i < 100; i++ {
+		r.add([]float64{float64(i)}, i%2)
+	}
+	features, labels := r.contents()
+	assert.Len(t, features, 10)
+	assert.Len(t, labels, 10)
+	assert.EqualValues(t, 100, r.seen)
+	assert.Equal(t, 100, r.pending)
+	for i, row := range features {
+		assert.Equal(t, int(row[0])%2, labels[i])
+	}
+
+	r.reset()
+	features, _ = r.contents()
+	assert.Empty(t, features)
+}
+
+func TestPartialFitSVM(t *testing.T) {
+	engine, err := NewMLEngine(MLConfig{ModelType: "svm", DetectionThreshold: 0.6, FeatureSize: 4, LearningRate: 0.1})
+	require.NoError(t, err)
+	defer engine.Close()
+
+	bot := []float64{0.9, 0.9, 0.1, 0.1}
+	human := []float64{0.1, 0.1, 0.9, 0.9}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.PartialFit([][]float64{bot, human}, []int{1, 0}))
+	}
+
+	result, err := engine.Predict(context.Background(), bot, "a-b")
+	require.NoError(t, err)
+	assert.True(t, result.IsBot)
+	result, err = engine.Predict(context.Background(), human, "a-b")
+	require.NoError(t, err)
+	assert.False(t, result.IsBot)
+
+	assert.EqualValues(t, 40, engine.GetStatistics().OnlineSamples)
+	snapshot := engine.Snapshot()
+	assert.Equal(t, "online", snapshot.TrainingData.Source)
+	assert.Equal(t, 40, snapshot.TrainingData.Samples)
+}
+
+func TestPartialFitRefitsTrees(t *testing.T) {
+	engine, err := NewMLEngine(MLConfig{
+		ModelType:          "gbdt",
+		DetectionThreshold: 0.6,
+		FeatureSize:        4,
+		GenerateFakeData:   true,
+		FakeDataSize:       100,
+		GBDTTrees:          5,
+		OnlineRefitSamples: 30,
+	})
+	require.NoError(t, err)
+	defer engine.Close()
+
+	hash := engine.Snapshot().Hash()
+	features := make([][]float64, 20)
+	labels := make([]int, 20)
+	for i := range features {
+		features[i] = []float64{0.5, 0.5, 0.5, float64(i%2) * 0.9}
+		labels[i] = i % 2
+	}
+
+	// The trees are kept until enough new flows have arrived
+	require.NoError(t, engine.PartialFit(features, labels))
+	assert.Equal(t, hash, engine.Snapshot().Hash())
+
+	require.NoError(t, engine.PartialFit(features, labels))
+	assert.NotEqual(t, hash, engine.Snapshot().Hash())
+	assert.Equal(t, 120, engine.Snapshot().TrainingData.Samples) // 80 after the evaluation holdout
+}
+
+func TestPartialFitErrors(t *testing.T) {
+	engine, err := NewMLEngine(MLConfig{ModelType: "svm", FeatureSize: 2})
+	require.NoError(t, err)
+	defer engine.Close()
+
+	assert.ErrorContains(t, engine.PartialFit([][]float64{{1, 2}}, nil), "1 samples and 0 labels")
+	assert.ErrorContains(t, engine.PartialFit([][]float64{{1}}, []int{0}), "has 1 features")
+	assert.ErrorContains(t, engine.PartialFit([][]float64{{1, 2}}, []int{2}), "invalid label 2")
+	assert.NoError(t, engine.PartialFit(nil, nil))
+}
@@ -0,0 +1,200 @@
+package ml
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// nnReplica is a copy of the neural network graph with the machine
+// running it, used by one prediction at a time
+type nnReplica struct {
+	*nnGraph
+	vm         gorgonia.VM // nil when the forward pass runs in Go
+	device     string
+	generation uint64 // of the parameters copied into the graph
+}
+
+// replicaPool hands out neural network replicas, so that up to size
+// predictions run at the same time; further ones wait for a replica to be
+// returned. Replicas are created on first use. Once the GPU fails, all
+// replicas move to the CPU.
+type replicaPool struct {
+	featureSize int
+	slots       chan *nnReplica // nil for replicas not created yet
+
+	mu       sync.Mutex
+	replicas []*nnReplica
+	useGPU   bool
+}
+
+// newReplicaPool creates a pool of up to size replicas, and the first one
+// so that the device is known from the start
+func newReplicaPool(featureSize, size int, enableGPU bool) *replicaPool {
+	p := &replicaPool{
+		featureSize: featureSize,
+		slots:       make(chan *nnReplica, size),
+		useGPU:      enableGPU,
+	}
+	first, name := p.newReplica()
+	p.slots <- first
+	for i := 1; i < size; i++ {
+		p.slots <- nil
+	}
+
+	if first.device == DeviceCUDA {
+		slog.Info("Running the neural network on the GPU", "device", name, "replicas", size)
+	}
+	return p
+}
+
+// newReplica creates a replica on the GPU if in use, otherwise on the
+// CPU, and returns it with the name of its GPU
+func (p *replicaPool) newReplica() (*nnReplica, string) {
+	r := &nnReplica{nnGraph: newNNGraph(p.featureSize), device: DeviceCPU}
+
+	var name string
+	if p.gpu() {
+		vm, deviceName, err := newGPUMachine(r.graph)
+		if err == nil {
+			r.vm, r.device, name = vm, DeviceCUDA, deviceName
+		} else {
+			p.fallBack(fmt.Errorf("GPU unavailable: %w", err))
+		}
+	}
+	if r.device == DeviceCPU {
+		r.vm = newCPUMachine(r.graph)
+	}
+
+	p.mu.Lock()
+	p.replicas = append(p.replicas, r)
+	p.mu.Unlock()
+	return r, name
+}
+
+// gpu reports whether replicas run on the GPU
+func (p *replicaPool) gpu() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.useGPU
+}
+
+// fallBack moves the neural network to the CPU for good
+func (p *replicaPool) fallBack(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.useGPU {
+		p.useGPU = false
+		slog.Warn("Running the neural network on the CPU", "error", err)
+	}
+}
+
+// device returns the device predictions run on
+func (p *replicaPool) device() string {
+	if p.gpu() {
+		return DeviceCUDA
+	}
+	return DeviceCPU
+}
+
+// acquire takes a replica, waiting for one when all are in use
+func (p *replicaPool) acquire() *nnReplica {
+	r := <-p.slots
+	if r == nil {
+		r, _ = p.newReplica()
+	}
+	return r
+}
+
+// release returns a replica to the pool
+func (p *replicaPool) release(r *nnReplica) {
+	p.slots <- r
+}
+
+// close releases the machines of the replicas
+func (p *replicaPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, r := range p.replicas {
+		if r.vm != nil {
+			r.vm.Close()
+		}
+	}
+}
+
+// forward runs the neural network on a feature vector on a replica, with
+// the engine's lock held for reading
+func (nn *NeuralNetwork) forward(features []float64) (float64, error) {
+	r := nn.pool.acquire()
+	defer nn.pool.release(r)
+
+	if r.generation != nn.generation {
+		if err := r.copyParameters(nn.nnGraph); err != nil {
+			return 0, err
+		}
+		r.generation = nn.generation
+	}
+	if r.device == DeviceCUDA && !nn.pool.gpu() {
+		r.moveToCPU()
+	}
+
+	if r.vm == nil {
+		return r.forwardCPU(features), nil
+	}
+	confidence, err := r.run(features)
+	if err != nil && r.device == DeviceCUDA {
+		nn.pool.fallBack(fmt.Errorf("neural network failed on the GPU: %w", err))
+		r.moveToCPU()
+		if r.vm == nil {
+			return r.forwardCPU(features), nil
+		}
+		return r.run(features)
+	}
+	return confidence, err
+}
+
+// copyParameters binds copies of the parameters of g to the replica
+func (r *nnReplica) copyParameters(g *nnGraph) error {
+	for _, p := range [][2]*gorgonia.Node{
+		{r.hiddenWeights, g.hiddenWeights},
+		{r.hiddenBias, g.hiddenBias},
+		{r.outputWeights, g.outputWeights},
+		{r.outputBias, g.outputBias},
+	} {
+		value := tensor.New(tensor.WithShape(p[1].Shape()...), tensor.WithBacking(nodeData(p[1])))
+		if err := gorgonia.Let(p[0], value); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", p[1].Name(), err)
+		}
+	}
+	return nil
+}
+
+// moveToCPU replaces the replica's GPU machine with one on the CPU
+func (r *nnReplica) moveToCPU() {
+	r.vm.Close()
+	r.vm, r.device = newCPUMachine(r.graph), DeviceCPU
+}
+
+// run runs the graph on the replica's machine
+func (r *nnReplica) run(features []float64) (float64, error) {
+	inputTensor := tensor.New(tensor.WithShape(1, len(features)), tensor.WithBacking(features))
+	if err := gorgonia.Let(r.input, inputTensor); err != nil {
+		return 0, fmt.Errorf("neural network inference failed: %w", err)
+	}
+
+	// The tape must be reset for the next run to read the new input
+	defer r.vm.Reset()
+	if err := r.vm.RunAll(); err != nil {
+		return 0, fmt.Errorf("neural network inference failed: %w", err)
+	}
+
+	if outputTensor, ok := r.output.Value().(tensor.Tensor); ok {
+		if outputData, ok := outputTensor.Data().([]float64); ok && len(outputData) > 0 {
+			return outputData[0], nil
+		}
+	}
+	return 0, fmt.Errorf("failed to extract neural network output")
+}
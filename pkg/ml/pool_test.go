@@ -0,0 +1,62 @@
+package ml
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNeuralNetworkReplicas(t *testing.T) {
+	config := MLConfig{
+		ModelType:          "neural_network",
+		DetectionThreshold: 0.5,
+		FeatureSize:        8,
+		GenerateFakeData:   true,
+		FakeDataSize:       100,
+		EvaluationHoldout:  0.2,
+		MaxConcurrency:     3,
+	}
+	engine, err := NewMLEngine(config)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	samples, _ := engine.dataGen.GenerateFakeData(20, config.FeatureSize)
+	expected := make([]float64, len(samples))
+	for i, sample := range samples {
+		expected[i] = engine.nnModel.forwardCPU(sample)
+	}
+
+	// Concurrent predictions run on up to MaxConcurrency replicas, all
+	// with the parameters of the network
+	var wg sync.WaitGroup
+	confidences := make([][]float64, 8)
+	for w := range confidences {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, sample := range samples {
+				engine.mu.RLock()
+				confidence, err := engine.predictNeuralNetwork(sample)
+				engine.mu.RUnlock()
+				assert.NoError(t, err)
+				confidences[w] = append(confidences[w], confidence)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, got := range confidences {
+		assert.InDeltaSlice(t, expected, got, 1e-9)
+	}
+	assert.LessOrEqual(t, len(engine.nnModel.pool.replicas), config.MaxConcurrency)
+
+	// Replicas pick up restored parameters
+	other, err := NewMLEngine(config)
+	require.NoError(t, err)
+	defer other.Close()
+	require.NoError(t, engine.Restore(other.Snapshot()))
+	confidence, err := engine.predictNeuralNetwork(samples[0])
+	require.NoError(t, err)
+	assert.InDelta(t, other.nnModel.forwardCPU(samples[0]), confidence, 1e-9)
+}
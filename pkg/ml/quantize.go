@@ -0,0 +1,508 @@
+package ml
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+)
+
+// Precisions of model parameters. Quantization reduces the neural network
+// and SVM parameters to float16 or int8; tree and neighbor models keep full
+// precision.
+const (
+	PrecisionFull    = "float64"
+	PrecisionFloat16 = "float16"
+	PrecisionInt8    = "int8"
+)
+
+// ErrNothingToQuantize is returned when quantizing a model without a
+// trained neural network or SVM
+var ErrNothingToQuantize = errors.New("model has no neural network or SVM parameters to quantize")
+
+// QuantizedTensor holds parameters in reduced precision: int8 values are
+// multiples of Scale, float16 values are IEEE 754 half-precision bits
+type QuantizedTensor struct {
+	Scale   float64
+	Int8    []int8
+	Float16 []uint16
+}
+
+// QuantizationReport compares a quantized model with the full-precision
+// one on the held-out samples of the model
+type QuantizationReport struct {
+	Precision         string   `json:"precision"`
+	Models            []string `json:"models"` // the models running at reduced precision
+	Samples           int      `json:"samples"`
+	Accuracy          float64  `json:"accuracy"`
+	QuantizedAccuracy float64  `json:"quantized_accuracy"`
+	AccuracyDelta     float64  `json:"accuracy_delta"` // quantized minus full precision
+	F1                float64  `json:"f1"`
+	QuantizedF1       float64  `json:"quantized_f1"`
+	Agreement         float64  `json:"agreement"`        // share of samples classified the same
+	MeanScoreDelta    float64  `json:"mean_score_delta"` // mean absolute change in confidence
+	MaxScoreDelta     float64  `json:"max_score_delta"`
+
+	// Size of the quantized parameters before and after quantization
+	ParameterBytes          int `json:"parameter_bytes"`
+	QuantizedParameterBytes int `json:"quantized_parameter_bytes"`
+}
+
+// quantizable returns the parameters of the snapshot held in reduced
+// precision by quantized snapshots, by name
+func (s *ModelSnapshot) quantizable() map[string]*[]float64 {
+	return map[string]*[]float64{
+		"nn_hidden_weights": &s.NNHiddenWeights,
+		"nn_hidden_bias":    &s.NNHiddenBias,
+		"nn_output_weights": &s.NNOutputWeights,
+		"nn_output_bias":    &s.NNOutputBias,
+		"svm_weights":       &s.SVMWeights,
+		"svm_projection":    &s.SVMProjection,
+	}
+}
+
+// quantizedModels names the trained models of the snapshot quantization
+// applies to
+func (s *ModelSnapshot) quantizedModels() []string {
+	var models []string
+	if s.NNTrained && len(s.NNHiddenWeights) > 0 {
+		models = append(models, "neural_network")
+	}
+	if s.SVMTrained {
+		models = append(models, "svm")
+	}
+	return models
+}
+
+// Quantize returns a copy of the snapshot with the neural network and SVM
+// parameters rounded to precision. The copy shares the other parameters
+// with s.
+func (s *ModelSnapshot) Quantize(precision string) (*ModelSnapshot, error) {
+	if !validPrecision(precision) || precision == PrecisionFull {
+		return nil, fmt.Errorf("unsupported quantization precision: %s", precision)
+	}
+	if len(s.quantizedModels()) == 0 {
+		return nil, ErrNothingToQuantize
+	}
+
+	quantized := *s
+	quantized.Precision = precision
+	quantized.Metrics = maps.Clone(s.Metrics)
+	for _, params := range quantized.quantizable() {
+		if *params != nil {
+			*params = quantizeTensor(precision, *params).values()
+		}
+	}
+	return &quantized, nil
+}
+
+// QuantizeModel quantizes a snapshot to precision and reports the change in
+// accuracy on its held-out samples, scored by engines with config as if
+// the snapshot were restored into them. The quantized snapshot keeps the
+// evaluation of the full-precision model, with the accuracy delta added to
+// its metrics.
+func QuantizeModel(snapshot *ModelSnapshot, config MLConfig, precision string) (*ModelSnapshot, *QuantizationReport, error) {
+	if len(snapshot.EvaluationFeatures) == 0 {
+		return nil, nil, ErrNotEvaluated
+	}
+	quantized, err := snapshot.Quantize(precision)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config.GenerateFakeData = false
+	config.TrainingDataPath = ""
+	config.LoadModel = false
+	config.SaveModel = false
+	config.CacheSize = 0
+	full, err := scoreSnapshot(snapshot, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	reduced, err := scoreSnapshot(quantized, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report := &QuantizationReport{
+		Precision:         precision,
+		Models:            quantized.quantizedModels(),
+		Samples:           len(full.scores),
+		Accuracy:          full.accuracy,
+		QuantizedAccuracy: reduced.accuracy,
+		AccuracyDelta:     reduced.accuracy - full.accuracy,
+		F1:                full.confusion.F1,
+		QuantizedF1:       reduced.confusion.F1,
+	}
+	agree := 0
+	for i, score := range full.scores {
+		delta := math.Abs(reduced.scores[i] - score)
+		report.MeanScoreDelta += delta / float64(report.Samples)
+		report.MaxScoreDelta = math.Max(report.MaxScoreDelta, delta)
+		if (score >= config.DetectionThreshold) == (reduced.scores[i] >= config.DetectionThreshold) {
+			agree++
+		}
+	}
+	report.Agreement = float64(agree) / float64(report.Samples)
+	for _, params := range snapshot.quantizable() {
+		if *params != nil {
+			report.ParameterBytes += 8 * len(*params)
+			report.QuantizedParameterBytes += quantizeTensor(precision, *params).size()
+		}
+	}
+
+	if quantized.Metrics == nil {
+		quantized.Metrics = map[string]float64{}
+	}
+	quantized.Metrics["quantization_accuracy_delta"] = report.AccuracyDelta
+	quantized.Metrics["quantization_score_delta"] = report.MeanScoreDelta
+	return quantized, report, nil
+}
+
+// scoreSnapshot scores the held-out samples of a snapshot with an engine
+// the snapshot is restored into
+func scoreSnapshot(snapshot *ModelSnapshot, config MLConfig) (*evaluation, error) {
+	engine, err := NewMLEngine(config)
+	if err != nil {
+		return nil, err
+	}
+	defer engine.Close()
+	if err := engine.Restore(snapshot); err != nil {
+		return nil, err
+	}
+
+	confusion, scores, modelCorrect := engine.score(snapshot.EvaluationFeatures, snapshot.EvaluationLabels, engine.models())
+	eval := newEvaluation(0, confusion, modelCorrect)
+	eval.scores, eval.labels = scores, snapshot.EvaluationLabels
+	return eval, nil
+}
+
+// Quantize runs the neural network and SVM at reduced precision until they
+// are trained again, and reports the change in held-out accuracy. Saved
+// models keep the reduced precision.
+func (e *MLEngine) Quantize(precision string) (*QuantizationReport, error) {
+	if e.tflite != nil {
+		return nil, fmt.Errorf("tflite models are quantized when they are converted")
+	}
+	quantized, report, err := QuantizeModel(e.Snapshot(), e.config, precision)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.Restore(quantized); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Precision returns the precision the neural network and SVM run at
+func (e *MLEngine) Precision() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.quantized == nil {
+		return PrecisionFull
+	}
+	return e.quantized.precision
+}
+
+// validPrecision reports whether a snapshot precision is known; empty
+// means full precision
+func validPrecision(precision string) bool {
+	switch precision {
+	case "", PrecisionFull, PrecisionFloat16, PrecisionInt8:
+		return true
+	}
+	return false
+}
+
+// quantizeTensor reduces values to precision, int8 symmetrically with a
+// scale mapping the largest magnitude to 127
+func quantizeTensor(precision string, values []float64) QuantizedTensor {
+	if precision == PrecisionFloat16 {
+		bits := make([]uint16, len(values))
+		for i, v := range values {
+			bits[i] = float16Bits(v)
+		}
+		return QuantizedTensor{Float16: bits}
+	}
+	q, scale := quantizeInt8(values)
+	return QuantizedTensor{Scale: scale, Int8: q}
+}
+
+// values returns the parameters the tensor holds
+func (t QuantizedTensor) values() []float64 {
+	if t.Int8 != nil {
+		values := make([]float64, len(t.Int8))
+		for i, q := range t.Int8 {
+			values[i] = float64(q) * t.Scale
+		}
+		return values
+	}
+	values := make([]float64, len(t.Float16))
+	for i, bits := range t.Float16 {
+		values[i] = float64(float16Value(bits))
+	}
+	return values
+}
+
+// size returns the bytes the tensor's parameters take
+func (t QuantizedTensor) size() int {
+	if t.Int8 != nil {
+		return len(t.Int8) + 8
+	}
+	return 2 * len(t.Float16)
+}
+
+// quantizeInt8 maps values to int8 multiples of a scale
+func quantizeInt8[T float32 | float64](values []T) ([]int8, T) {
+	var largest T
+	for _, v := range values {
+		largest = max(largest, T(math.Abs(float64(v))))
+	}
+	q := make([]int8, len(values))
+	if largest == 0 {
+		return q, 0
+	}
+	scale := largest / 127
+	for i, v := range values {
+		q[i] = int8(max(-127, min(127, math.Round(float64(v/scale)))))
+	}
+	return q, scale
+}
+
+// float16Bits rounds a value to the nearest IEEE 754 half-precision
+// number, ties to even, and returns its bits
+func float16Bits(v float64) uint16 {
+	b := math.Float32bits(float32(v))
+	sign := uint16(b>>16) & 0x8000
+	exp := int(b>>23&0xff) - 127 + 15
+	mant := b & 0x7fffff
+
+	switch {
+	case b>>23&0xff == 0xff: // infinity or NaN
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	case exp >= 0x1f:
+		return sign | 0x7c00
+	case exp <= 0: // subnormal or zero
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint(14 - exp)
+		half := mant >> shift
+		rest, halfway := mant&(1<<shift-1), uint32(1)<<(shift-1)
+		if rest > halfway || rest == halfway && half&1 == 1 {
+			half++
+		}
+		return sign | uint16(half)
+	}
+
+	// Rounding up may carry into the exponent, up to infinity
+	half := uint32(exp)<<10 | mant>>13
+	if rest := mant & 0x1fff; rest > 0x1000 || rest == 0x1000 && half&1 == 1 {
+		half++
+	}
+	return sign | uint16(half)
+}
+
+// float16Value returns the half-precision number with the given bits
+func float16Value(bits uint16) float32 {
+	sign := uint32(bits&0x8000) << 16
+	exp := uint32(bits>>10) & 0x1f
+	mant := uint32(bits & 0x3ff)
+	switch exp {
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case 0:
+		v := float32(mant) / (1 << 24)
+		if sign != 0 {
+			return -v
+		}
+		return v
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | mant<<13)
+}
+
+// quantizedModels runs the neural network and SVM of a quantized model at
+// reduced precision
+type quantizedModels struct {
+	precision string
+	nn        *quantizedNN  // nil without a trained neural network
+	svm       *quantizedSVM // nil without a trained SVM
+}
+
+// quantizedNN is the neural network with reduced-precision layers
+type quantizedNN struct {
+	hidden, output *quantizedLayer
+}
+
+// quantizedSVM is the SVM with reduced-precision weights; one-class SVMs
+// also map features to random Fourier features through a projection
+type quantizedSVM struct {
+	projection *quantizedLayer // nil for binary SVMs
+	weights    *quantizedLayer
+}
+
+// quantizedLayer is a dense layer with weights in reduced precision,
+// stored by output. int8 layers quantize their input to int8 and
+// accumulate in int32, float16 layers compute in float32.
+type quantizedLayer struct {
+	inputs  int
+	scale   float32 // of int8 weights
+	int8    []int8
+	float16 []float32 // float16 weights widened to float32
+	bias    []float32 // one per output, none when nil
+}
+
+// newQuantizedModels builds the reduced-precision models of a quantized
+// snapshot; it returns nil for snapshots at full precision
+func newQuantizedModels(s *ModelSnapshot) *quantizedModels {
+	if s.Precision == "" || s.Precision == PrecisionFull {
+		return nil
+	}
+
+	q := &quantizedModels{precision: s.Precision}
+	if s.NNTrained && len(s.NNHiddenWeights) > 0 {
+		q.nn = &quantizedNN{
+			hidden: newQuantizedLayer(s.Precision, transpose(s.NNHiddenWeights, s.FeatureSize, nnHiddenSize), s.FeatureSize, s.NNHiddenBias),
+			output: newQuantizedLayer(s.Precision, s.NNOutputWeights, nnHiddenSize, s.NNOutputBias),
+		}
+	}
+	if s.SVMTrained {
+		if s.SVMOneClass {
+			q.svm = &quantizedSVM{
+				projection: newQuantizedLayer(s.Precision, s.SVMProjection, s.FeatureSize, nil),
+				weights:    newQuantizedLayer(s.Precision, s.SVMWeights, svmFourierFeatures, nil),
+			}
+		} else {
+			q.svm = &quantizedSVM{weights: newQuantizedLayer(s.Precision, s.SVMWeights, s.FeatureSize, []float64{s.SVMBias})}
+		}
+	}
+	return q
+}
+
+// newQuantizedLayer quantizes a layer of weights, stored by output
+func newQuantizedLayer(precision string, weights []float64, inputs int, bias []float64) *quantizedLayer {
+	l := &quantizedLayer{inputs: inputs}
+	t := quantizeTensor(precision, weights)
+	if t.Int8 != nil {
+		l.int8, l.scale = t.Int8, float32(t.Scale)
+	} else {
+		l.float16 = make([]float32, len(t.Float16))
+		for i, bits := range t.Float16 {
+			l.float16[i] = float16Value(bits)
+		}
+	}
+	if bias != nil {
+		l.bias = toFloat32(bias)
+	}
+	return l
+}
+
+// apply returns the outputs of the layer for an input
+func (l *quantizedLayer) apply(x []float32) []float32 {
+	outputs := (len(l.int8) + len(l.float16)) / l.inputs
+	y := make([]float32, outputs)
+	copy(y, l.bias)
+
+	if l.int8 == nil {
+		for j := range y {
+			var sum float32
+			for i, w := range l.float16[j*l.inputs : (j+1)*l.inputs] {
+				sum += w * x[i]
+			}
+			y[j] += sum
+		}
+		return y
+	}
+
+	xq, scale := quantizeInt8(x)
+	if scale == 0 {
+		return y
+	}
+	for j := range y {
+		var acc int32
+		for i, w := range l.int8[j*l.inputs : (j+1)*l.inputs] {
+			acc += int32(w) * int32(xq[i])
+		}
+		y[j] += float32(acc) * scale * l.scale
+	}
+	return y
+}
+
+// forward computes the neural network's confidence for a feature vector
+func (nn *quantizedNN) forward(features []float64) float64 {
+	hidden := nn.hidden.apply(toFloat32(features))
+	for j, h := range hidden {
+		hidden[j] = max(h, 0)
+	}
+	return sigmoid(float64(nn.output.apply(hidden)[0]))
+}
+
+// predict computes the SVM's confidence for a feature vector, with the
+// full-precision phase, bias and scale of one-class SVMs from c
+func (s *quantizedSVM) predict(c *SVMClassifier, features []float64) float64 {
+	x := toFloat32(features)
+	if s.projection == nil {
+		return sigmoid(float64(s.weights.apply(x)[0]))
+	}
+
+	z := s.projection.apply(x)
+	norm := math.Sqrt(2 / float64(len(c.phase)))
+	for i, p := range c.phase {
+		z[i] = float32(norm * math.Cos(float64(z[i])+p))
+	}
+	decision := float64(s.weights.apply(z)[0]) - c.bias
+	return sigmoid(-decision / c.scale)
+}
+
+// toFloat32 narrows values to float32
+func toFloat32(values []float64) []float32 {
+	narrowed := make([]float32, len(values))
+	for i, v := range values {
+		narrowed[i] = float32(v)
+	}
+	return narrowed
+}
+
+// transpose returns a row-major rows x cols matrix in column-major order
+func transpose(m []float64, rows, cols int) []float64 {
+	t := make([]float64, len(m))
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			t[j*rows+i] = m[i*cols+j]
+		}
+	}
+	return t
+}
+
+// packed returns a copy of a quantized snapshot for writing to a file,
+// with the reduced-precision parameters in QuantizedParameters
+func (s *ModelSnapshot) packed() *ModelSnapshot {
+	packed := *s
+	packed.QuantizedParameters = map[string]QuantizedTensor{}
+	for name, params := range packed.quantizable() {
+		if *params != nil {
+			packed.QuantizedParameters[name] = quantizeTensor(s.Precision, *params)
+			*params = nil
+		}
+	}
+	return &packed
+}
+
+// unpack restores the parameters of a snapshot read from a file from
+// QuantizedParameters
+func (s *ModelSnapshot) unpack() error {
+	params := s.quantizable()
+	for name, t := range s.QuantizedParameters {
+		p, ok := params[name]
+		if !ok {
+			return fmt.Errorf("unknown quantized parameters %s", name)
+		}
+		*p = t.values()
+	}
+	s.QuantizedParameters = nil
+	return nil
+}
@@ -0,0 +1,127 @@
+package ml
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFloat16(t *testing.T) {
+	for v, bits := range map[float64]uint16{
+		1:                      0x3c00,
+		-2:                     0xc000,
+		65504:                  0x7bff,
+		1e6:                    0x7c00,
+		math.Ldexp(1, -24):     0x0001,
+		math.Ldexp(1, -26):     0x0000,
+		1 + math.Ldexp(1, -11): 0x3c00, // ties to even
+	} {
+		assert.Equal(t, bits, float16Bits(v), "%g", v)
+	}
+	for b := 0; b < 1<<16; b++ {
+		if v := float16Value(uint16(b)); !math.IsNaN(float64(v)) {
+			require.Equal(t, uint16(b), float16Bits(float64(v)), "%#04x", b)
+		}
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	samples := [][]float64{{0.5, 0.2, 0.9, 0.1, 0.4, 0.7}, {0.1, 0.8, 0.3, 0.6, 0.2, 0.9}}
+	for _, tc := range []struct {
+		name      string
+		config    MLConfig
+		precision string
+	}{
+		{"neural network int8", MLConfig{ModelType: "neural_network"}, PrecisionInt8},
+		{"svm float16", MLConfig{ModelType: "svm"}, PrecisionFloat16},
+		{"one-class svm int8", MLConfig{ModelType: "svm", SVMMode: SVMModeOneClass}, PrecisionInt8},
+		{"ensemble int8", MLConfig{ModelType: "ensemble", EnsembleModels: []string{"neural_network", "svm", "gbdt"}}, PrecisionInt8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := tc.config
+			config.DetectionThreshold = 0.5
+			config.FeatureSize = 6
+			config.GenerateFakeData = true
+			config.FakeDataSize = 300
+			config.EvaluationHoldout = 0.2
+			engine, err := NewMLEngine(config)
+			require.NoError(t, err)
+			defer engine.Close()
+
+			var full []float64
+			for _, sample := range samples {
+				result, err := engine.Predict(context.Background(), sample, "flow")
+				require.NoError(t, err)
+				full = append(full, result.Confidence)
+			}
+			dir := t.TempDir()
+			require.NoError(t, engine.SaveModel(filepath.Join(dir, "full")))
+
+			report, err := engine.Quantize(tc.precision)
+			require.NoError(t, err)
+			assert.Equal(t, tc.precision, engine.Precision())
+			assert.Equal(t, 60, report.Samples)
+			assert.InDelta(t, report.QuantizedAccuracy-report.Accuracy, report.AccuracyDelta, 1e-12)
+			assert.GreaterOrEqual(t, report.Agreement, 0.9)
+			assert.Less(t, report.MeanScoreDelta, 0.05)
+			assert.Less(t, report.QuantizedParameterBytes, report.ParameterBytes/3)
+
+			// Predictions run at reduced precision, close to full precision
+			var quantized []float64
+			for i, sample := range samples {
+				result, err := engine.Predict(context.Background(), sample, "flow")
+				require.NoError(t, err)
+				assert.InDelta(t, full[i], result.Confidence, 0.05)
+				quantized = append(quantized, result.Confidence)
+			}
+
+			// Saved models keep the precision, in less space for models
+			// with more parameters than the file has metadata
+			path := filepath.Join(dir, "quantized")
+			require.NoError(t, engine.SaveModel(path))
+			if slices.Contains(report.Models, "neural_network") {
+				fullInfo, err := os.Stat(filepath.Join(dir, "full"))
+				require.NoError(t, err)
+				quantizedInfo, err := os.Stat(path)
+				require.NoError(t, err)
+				assert.Less(t, quantizedInfo.Size(), fullInfo.Size())
+			}
+
+			loaded, err := NewMLEngine(MLConfig{
+				ModelType:          config.ModelType,
+				SVMMode:            config.SVMMode,
+				EnsembleModels:     config.EnsembleModels,
+				DetectionThreshold: 0.5,
+				FeatureSize:        6,
+			})
+			require.NoError(t, err)
+			defer loaded.Close()
+			require.NoError(t, loaded.LoadModel(path))
+			assert.Equal(t, tc.precision, loaded.Precision())
+			assert.Contains(t, loaded.Snapshot().Metrics, "quantization_accuracy_delta")
+			for i, sample := range samples {
+				result, err := loaded.Predict(context.Background(), sample, "flow")
+				require.NoError(t, err)
+				assert.InDelta(t, quantized[i], result.Confidence, 1e-6)
+			}
+
+			// Training again returns to full precision
+			require.NoError(t, engine.TrainOnFakeData())
+			assert.Equal(t, PrecisionFull, engine.Precision())
+		})
+	}
+
+	engine, err := NewMLEngine(MLConfig{ModelType: "gbdt", DetectionThreshold: 0.5, FeatureSize: 6, GenerateFakeData: true, FakeDataSize: 100, EvaluationHoldout: 0.2})
+	require.NoError(t, err)
+	defer engine.Close()
+	_, err = engine.Quantize(PrecisionInt8)
+	assert.ErrorIs(t, err, ErrNothingToQuantize)
+	_, err = engine.Snapshot().Quantize("int4")
+	assert.Error(t, err)
+}
@@ -0,0 +1,117 @@
+package ml
+
+import (
+	"fmt"
+	"math"
+)
+
+// Feature scaling methods
+const (
+	ScalingNone     = "none"
+	ScalingStandard = "standard" // zero mean and unit variance
+	ScalingMinMax   = "minmax"   // the training range mapped to [0, 1]
+)
+
+// Scaler maps raw features to the scale the models were trained on. Each
+// feature becomes (x - Offsets[i]) * Factors[i].
+type Scaler struct {
+	Method  string
+	Offsets []float64
+	Factors []float64
+}
+
+// fitScaler fits a scaler to training samples. Features that do not vary
+// are only shifted.
+func fitScaler(method string, features [][]float64, featureSize int) (*Scaler, error) {
+	if method != ScalingStandard && method != ScalingMinMax {
+		return nil, fmt.Errorf("unsupported feature scaling method: %s", method)
+	}
+	if len(features) == 0 {
+		return nil, fmt.Errorf("no samples to fit feature scaling on")
+	}
+
+	s := &Scaler{
+		Method:  method,
+		Offsets: make([]float64, featureSize),
+		Factors: make([]float64, featureSize),
+	}
+	for i := 0; i < featureSize; i++ {
+		var spread float64
+		if method == ScalingStandard {
+			var sum, sumSquares float64
+			for _, row := range features {
+				sum += row[i]
+				sumSquares += row[i] * row[i]
+			}
+			n := float64(len(features))
+			s.Offsets[i] = sum / n
+			spread = math.Sqrt(math.Max(sumSquares/n-s.Offsets[i]*s.Offsets[i], 0))
+		} else {
+			lo, hi := math.Inf(1), math.Inf(-1)
+			for _, row := range features {
+				lo = math.Min(lo, row[i])
+				hi = math.Max(hi, row[i])
+			}
+			s.Offsets[i] = lo
+			spread = hi - lo
+		}
+		s.Factors[i] = 1
+		if spread > 1e-12 {
+			s.Factors[i] = 1 / spread
+		}
+	}
+	return s, nil
+}
+
+// transform returns a scaled copy of a sample, the sample itself for a nil
+// scaler
+func (s *Scaler) transform(features []float64) []float64 {
+	if s == nil {
+		return features
+	}
+	scaled := make([]float64, len(features))
+	for i, x := range features {
+		scaled[i] = (x - s.Offsets[i]) * s.Factors[i]
+	}
+	return scaled
+}
+
+// transformAll returns scaled copies of samples
+func (s *Scaler) transformAll(features [][]float64) [][]float64 {
+	if s == nil {
+		return features
+	}
+	scaled := make([][]float64, len(features))
+	for i, row := range features {
+		scaled[i] = s.transform(row)
+	}
+	return scaled
+}
+
+// validate checks a scaler read from a snapshot
+func (s *Scaler) validate(featureSize int) error {
+	if s.Method != ScalingStandard && s.Method != ScalingMinMax {
+		return fmt.Errorf("unsupported feature scaling method: %s", s.Method)
+	}
+	if len(s.Offsets) != featureSize || len(s.Factors) != featureSize {
+		return fmt.Errorf("feature scaling has %d offsets and %d factors, expected %d", len(s.Offsets), len(s.Factors), featureSize)
+	}
+	for i := range s.Offsets {
+		if math.IsNaN(s.Offsets[i]) || math.IsInf(s.Offsets[i], 0) || math.IsNaN(s.Factors[i]) || math.IsInf(s.Factors[i], 0) {
+			return fmt.Errorf("feature scaling of feature %d is invalid", i)
+		}
+	}
+	return nil
+}
+
+// clone returns a copy of the scaler, nil for nil
+func (s *Scaler) clone() *Scaler {
+	if s == nil {
+		return nil
+	}
+	return &Scaler{
+		Method:  s.Method,
+		Offsets: append([]float64(nil), s.Offsets...),
+		Factors: append([]float64(nil), s.Factors...),
+	}
+}
@@ -0,0 +1,110 @@
+package ml
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFitScaler(t *testing.T) {
+	features := [][]float64{
+		{100, 0.1, 5},
+		{300, 0.3, 5},
+		{500, 0.2, 5},
+	}
+
+	standard, err := fitScaler(ScalingStandard, features, 3)
+	require.NoError(t, err)
+	scaled := standard.transformAll(features)
+	for i := 0; i < 2; i++ {
+		var sum, sumSquares float64
+		for _, row := range scaled {
+			sum += row[i]
+			sumSquares += row[i] * row[i]
+		}
+		assert.InDelta(t, 0, sum/3, 1e-9)
+		assert.InDelta(t, 1, sumSquares/3, 1e-9)
+	}
+	// Constant features are only shifted
+	assert.Equal(t, 0.0, scaled[0][2])
+
+	minmax, err := fitScaler(ScalingMinMax, features, 3)
+	require.NoError(t, err)
+	assert.InDeltaSlice(t, []float64{0, 0, 0}, minmax.transform(features[0]), 1e-9)
+	assert.InDeltaSlice(t, []float64{1, 0.5, 0}, minmax.transform(features[2]), 1e-9)
+	assert.InDeltaSlice(t, []float64{1.5, 1, 0}, minmax.transform([]float64{700, 0.3, 5}), 1e-9)
+
+	// The input is not modified
+	assert.Equal(t, []float64{100, 0.1, 5}, features[0])
+
+	var none *Scaler
+	assert.Equal(t, features[0], none.transform(features[0]))
+
+	_, err = fitScaler("robust", features, 3)
+	assert.ErrorContains(t, err, "unsupported feature scaling method")
+	_, err = fitScaler(ScalingStandard, nil, 3)
+	assert.Error(t, err)
+
+	assert.NoError(t, minmax.validate(3))
+	assert.Error(t, minmax.validate(4))
+	assert.Error(t, (&Scaler{Method: ScalingMinMax, Offsets: []float64{0}, Factors: []float64{math.Inf(1)}}).validate(1))
+}
+
+func TestScaledEngine(t *testing.T) {
+	cfg := MLConfig{
+		ModelType:          "ensemble",
+		EnsembleModels:     []string{"knn", "svm"},
+		SVMMode:            "one_class",
+		DetectionThreshold: 0.5,
+		FeatureSize:        8,
+		GenerateFakeData:   true,
+		FakeDataSize:       300,
+		KNNNeighbors:       5,
+		Scaling:            ScalingStandard,
+	}
+	engine, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	snapshot := engine.Snapshot()
+	require.NotNil(t, snapshot.Scaler)
+	assert.Equal(t, ScalingStandard, snapshot.Scaler.Method)
+	assert.Len(t, snapshot.Scaler.Offsets, 8)
+
+	// Training a subset of the ensemble keeps the scaling of the others
+	baseline, _ := engine.dataGen.GenerateFakeData(50, 8)
+	require.NoError(t, engine.TrainBaseline(baseline))
+	assert.Equal(t, snapshot.Scaler, engine.Snapshot().Scaler)
+
+	// Online learning stores flows scaled and leaves the scaling alone
+	flow := []float64{0.1, 0.9, 0.3, 0.5, 0.2, 0.8, 0.4, 0.6}
+	require.NoError(t, engine.PartialFit([][]float64{flow}, []int{1}))
+	samples, _ := engine.online.contents()
+	assert.Contains(t, samples, snapshot.Scaler.transform(flow))
+	assert.Equal(t, snapshot.Scaler, engine.Snapshot().Scaler)
+
+	// The scaling is part of the persisted model
+	snapshot = engine.Snapshot()
+	want, err := engine.Predict(context.Background(), flow, "a-b")
+	require.NoError(t, err)
+
+	cfg.GenerateFakeData = false
+	restored, err := NewMLEngine(cfg)
+	require.NoError(t, err)
+	defer restored.Close()
+	require.NoError(t, restored.Restore(snapshot))
+	assert.Equal(t, snapshot.Hash(), restored.Snapshot().Hash())
+	got, err := restored.Predict(context.Background(), flow, "a-b")
+	require.NoError(t, err)
+	assert.Equal(t, want.Confidence, got.Confidence)
+
+	unscaled := *snapshot
+	unscaled.Scaler = nil
+	assert.NotEqual(t, snapshot.Hash(), unscaled.Hash())
+
+	snapshot.Scaler = &Scaler{Method: ScalingMinMax}
+	assert.ErrorContains(t, restored.Restore(snapshot), "feature scaling has 0 offsets")
+}
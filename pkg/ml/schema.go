@@ -0,0 +1,122 @@
+package ml
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// FeatureSchemaVersion is the version of the built-in flow feature layout,
+// raised whenever a slot of it changes meaning
+const FeatureSchemaVersion = 16
+
+// ErrFeatureSchemaMismatch is returned when a model was trained on feature
+// vectors laid out differently from those the engine is given
+var ErrFeatureSchemaMismatch = errors.New("feature schema mismatch")
+
+// FeatureSpec describes one slot of a feature vector
+type FeatureSpec struct {
+	Name string
+	Min  float64
+	Max  float64 // +Inf for unbounded features
+}
+
+// FeatureSchema describes the layout of feature vectors: the features in
+// order, and so their number. Models record the schema they were trained
+// on and are only restored into engines using the same one.
+type FeatureSchema struct {
+	Version  int
+	Features []FeatureSpec
+}
+
+// DefaultFeatureSchema returns the schema of the built-in flow features
+// over vectors of size features
+func DefaultFeatureSchema(size int) *FeatureSchema {
+	schema := &FeatureSchema{Version: FeatureSchemaVersion, Features: make([]FeatureSpec, size)}
+	for i := range schema.Features {
+		spec, ok := flowFeatures[i]
+		if !ok {
+			spec = FeatureSpec{Name: FeatureName(i), Min: 0, Max: 1}
+		}
+		schema.Features[i] = spec
+	}
+	return schema
+}
+
+// Size returns the number of features
+func (s *FeatureSchema) Size() int {
+	return len(s.Features)
+}
+
+// Names returns the feature names in order
+func (s *FeatureSchema) Names() []string {
+	names := make([]string, len(s.Features))
+	for i, spec := range s.Features {
+		names[i] = spec.Name
+	}
+	return names
+}
+
+// Fingerprint returns a short digest of the version and features,
+// identifying the layout in logs and model information
+func (s *FeatureSchema) Fingerprint() string {
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, int64(s.Version))
+	for _, spec := range s.Features {
+		fmt.Fprintf(h, "%s\x00", spec.Name)
+		binary.Write(h, binary.BigEndian, math.Float64bits(spec.Min))
+		binary.Write(h, binary.BigEndian, math.Float64bits(spec.Max))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Check returns an error wrapping ErrFeatureSchemaMismatch describing the
+// first difference between the schema and that of a model
+func (s *FeatureSchema) Check(model *FeatureSchema) error {
+	if model.Version != s.Version {
+		return fmt.Errorf("%w: model uses version %d, engine version %d", ErrFeatureSchemaMismatch, model.Version, s.Version)
+	}
+	if model.Size() != s.Size() {
+		return fmt.Errorf("%w: model has %d features, engine %d", ErrFeatureSchemaMismatch, model.Size(), s.Size())
+	}
+	for i, spec := range s.Features {
+		if model.Features[i] != spec {
+			return fmt.Errorf("%w: feature %d is %s in [%g, %g] for the model, %s in [%g, %g] for the engine",
+				ErrFeatureSchemaMismatch, i, model.Features[i].Name, model.Features[i].Min, model.Features[i].Max,
+				spec.Name, spec.Min, spec.Max)
+		}
+	}
+	return nil
+}
+
+// Validate checks a feature vector against the schema, returning an error
+// naming the first feature that is missing, not a number or out of range
+func (s *FeatureSchema) Validate(features []float64) error {
+	if len(features) != s.Size() {
+		return fmt.Errorf("expected %d features, got %d", s.Size(), len(features))
+	}
+	for i, x := range features {
+		spec := s.Features[i]
+		if math.IsNaN(x) || x < spec.Min || x > spec.Max {
+			return fmt.Errorf("feature %d (%s) is %g, outside [%g, %g]", i, spec.Name, x, spec.Min, spec.Max)
+		}
+	}
+	return nil
+}
+
+// FeatureSchema returns the layout of the feature vectors the engine
+// predicts on
+func (e *MLEngine) FeatureSchema() *FeatureSchema {
+	return e.config.FeatureSchema
+}
+
+// clone returns a deep copy of the schema
+func (s *FeatureSchema) clone() *FeatureSchema {
+	if s == nil {
+		return nil
+	}
+	return &FeatureSchema{Version: s.Version, Features: append([]FeatureSpec(nil), s.Features...)}
+}
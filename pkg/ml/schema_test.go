@@ -0,0 +1,77 @@
+package ml
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureSchema(t *testing.T) {
+	schema := DefaultFeatureSchema(128)
+	assert.Equal(t, 128, schema.Size())
+	assert.Equal(t, FeatureSchemaVersion, schema.Version)
+	for i, name := range schema.Names() {
+		assert.Equal(t, FeatureName(i), name)
+	}
+	assert.Equal(t, schema.Fingerprint(), DefaultFeatureSchema(128).Fingerprint())
+	require.NoError(t, schema.Check(DefaultFeatureSchema(128)))
+
+	// Any change to the layout is a mismatch
+	for name, change := range map[string]func(*FeatureSchema){
+		"version": func(s *FeatureSchema) { s.Version++ },
+		"size":    func(s *FeatureSchema) { s.Features = s.Features[:64] },
+		"name":    func(s *FeatureSchema) { s.Features[40].Name = "tls_version" },
+		"range":   func(s *FeatureSchema) { s.Features[0].Max = 1500 },
+	} {
+		other := schema.clone()
+		change(other)
+		assert.ErrorIs(t, schema.Check(other), ErrFeatureSchemaMismatch, name)
+		assert.NotEqual(t, schema.Fingerprint(), other.Fingerprint(), name)
+	}
+
+	features := make([]float64, 128)
+	require.NoError(t, schema.Validate(features))
+	features[20] = 1e9
+	require.NoError(t, schema.Validate(features))
+	features[22] = 2
+	assert.ErrorContains(t, schema.Validate(features), "crosses_border")
+	features[22] = math.NaN()
+	assert.Error(t, schema.Validate(features))
+	assert.Error(t, schema.Validate(features[:10]))
+}
+
+func TestModelFeatureSchema(t *testing.T) {
+	config := MLConfig{ModelType: "svm", DetectionThreshold: 0.5, FeatureSize: 6, GenerateFakeData: true, FakeDataSize: 50}
+	_, err := NewMLEngine(MLConfig{ModelType: "svm", FeatureSize: 6, FeatureSchema: DefaultFeatureSchema(8)})
+	assert.Error(t, err)
+
+	// Models record the layout they were trained on
+	extended := DefaultFeatureSchema(6)
+	extended.Features[5] = FeatureSpec{Name: "tls_version", Min: 0, Max: 4}
+	config.FeatureSchema = extended
+	engine, err := NewMLEngine(config)
+	require.NoError(t, err)
+	defer engine.Close()
+	path := filepath.Join(t.TempDir(), "model")
+	require.NoError(t, engine.SaveModel(path))
+	snapshot, err := ReadModelFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, extended, snapshot.FeatureSchema)
+
+	// and are rejected by engines fed another layout
+	_, err = NewMLEngine(MLConfig{ModelType: "svm", DetectionThreshold: 0.5, FeatureSize: 6, ModelPath: path, LoadModel: true})
+	assert.ErrorIs(t, err, ErrFeatureSchemaMismatch)
+	loaded, err := NewMLEngine(MLConfig{ModelType: "svm", DetectionThreshold: 0.5, FeatureSize: 6, FeatureSchema: extended.clone(), ModelPath: path, LoadModel: true})
+	require.NoError(t, err)
+	loaded.Close()
+
+	// Models saved before layouts were recorded are taken as they are
+	snapshot.FeatureSchema = nil
+	fresh, err := NewMLEngine(MLConfig{ModelType: "svm", DetectionThreshold: 0.5, FeatureSize: 6})
+	require.NoError(t, err)
+	defer fresh.Close()
+	assert.NoError(t, fresh.Restore(snapshot))
+}
@@ -0,0 +1,155 @@
+package ml
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Model formats
+const (
+	// ModelFormatNative selects the built-in Gorgonia and Gonum models
+	ModelFormatNative = "native"
+	// ModelFormatTFLite selects a TensorFlow Lite model file, run by the
+	// TFLite C library in builds with the tflite tag
+	ModelFormatTFLite = "tflite"
+)
+
+// tensorType is the element type of a TFLite tensor
+type tensorType int
+
+const (
+	tensorFloat32 tensorType = iota
+	tensorUInt8
+	tensorInt8
+)
+
+// tensorInfo describes the input or output tensor of a TFLite model.
+// Quantized tensors map real values to integers as
+// real = scale * (q - zeroPoint).
+type tensorInfo struct {
+	dtype     tensorType
+	elements  int
+	scale     float32
+	zeroPoint int32
+}
+
+// byteSize returns the size of the tensor data
+func (t tensorInfo) byteSize() int {
+	if t.dtype == tensorFloat32 {
+		return 4 * t.elements
+	}
+	return t.elements
+}
+
+// encode converts feature values into tensor data, quantizing them for
+// integer tensors
+func (t tensorInfo) encode(values []float64) []byte {
+	data := make([]byte, t.byteSize())
+	for i, v := range values {
+		switch t.dtype {
+		case tensorFloat32:
+			binary.NativeEndian.PutUint32(data[4*i:], math.Float32bits(float32(v)))
+		case tensorUInt8:
+			data[i] = byte(t.quantize(v, 0, math.MaxUint8))
+		case tensorInt8:
+			data[i] = byte(int8(t.quantize(v, math.MinInt8, math.MaxInt8)))
+		}
+	}
+	return data
+}
+
+// quantize maps a real value to the integer range [lo, hi]
+func (t tensorInfo) quantize(v float64, lo, hi int32) int32 {
+	if t.scale == 0 {
+		return t.zeroPoint
+	}
+	q := int32(math.Round(v/float64(t.scale))) + t.zeroPoint
+	return max(lo, min(hi, q))
+}
+
+// decode converts tensor data into real values, dequantizing integer
+// tensors
+func (t tensorInfo) decode(data []byte) []float64 {
+	values := make([]float64, t.elements)
+	for i := range values {
+		switch t.dtype {
+		case tensorFloat32:
+			values[i] = float64(math.Float32frombits(binary.NativeEndian.Uint32(data[4*i:])))
+		case tensorUInt8:
+			values[i] = float64(t.scale) * float64(int32(data[i])-t.zeroPoint)
+		case tensorInt8:
+			values[i] = float64(t.scale) * float64(int32(int8(data[i]))-t.zeroPoint)
+		}
+	}
+	return values
+}
+
+// tfliteRuntime runs a loaded TFLite model with one input and one output
+// tensor
+type tfliteRuntime interface {
+	input() tensorInfo
+	output() tensorInfo
+	invoke(input []byte) ([]byte, error)
+	close()
+}
+
+// TFLiteModel is a TensorFlow Lite bot detection model. Its input is the
+// feature vector; its output is either the bot probability or the
+// probabilities of the human and bot classes. Float32 and quantized uint8
+// and int8 tensors are supported.
+type TFLiteModel struct {
+	mu      sync.Mutex // interpreters are not safe for concurrent use
+	runtime tfliteRuntime
+	in, out tensorInfo
+}
+
+// OpenTFLiteModel loads a TensorFlow Lite model file, running inference on
+// the given number of threads
+func OpenTFLiteModel(path string, threads int) (*TFLiteModel, error) {
+	runtime, err := openTFLiteRuntime(path, max(threads, 1))
+	if err != nil {
+		return nil, err
+	}
+	return newTFLiteModel(runtime)
+}
+
+func newTFLiteModel(runtime tfliteRuntime) (*TFLiteModel, error) {
+	m := &TFLiteModel{runtime: runtime, in: runtime.input(), out: runtime.output()}
+	if m.out.elements != 1 && m.out.elements != 2 {
+		runtime.close()
+		return nil, fmt.Errorf("tflite model has %d outputs, expected 1 or 2", m.out.elements)
+	}
+	return m, nil
+}
+
+// InputSize returns the number of features the model expects
+func (m *TFLiteModel) InputSize() int {
+	return m.in.elements
+}
+
+// Predict returns the bot probability of a feature vector
+func (m *TFLiteModel) Predict(features []float64) (float64, error) {
+	if len(features) != m.in.elements {
+		return 0, fmt.Errorf("invalid feature vector size: got %d, expected %d", len(features), m.in.elements)
+	}
+
+	m.mu.Lock()
+	data, err := m.runtime.invoke(m.in.encode(features))
+	m.mu.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("tflite inference failed: %w", err)
+	}
+
+	outputs := m.out.decode(data)
+	return math.Max(0, math.Min(1, outputs[len(outputs)-1])), nil
+}
+
+// Close releases the interpreter
+func (m *TFLiteModel) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runtime.close()
+	return nil
+}
@@ -0,0 +1,126 @@
+//go:build tflite
+
+package ml
+
+/*
+#cgo LDFLAGS: -ltensorflowlite_c
+#include <stdlib.h>
+#include <tensorflow/lite/c/c_api.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// cTFLite runs a model with the TensorFlow Lite C library
+type cTFLite struct {
+	model       *C.TfLiteModel
+	options     *C.TfLiteInterpreterOptions
+	interpreter *C.TfLiteInterpreter
+	in, out     tensorInfo
+}
+
+// openTFLiteRuntime loads a model file and allocates its tensors
+func openTFLiteRuntime(path string, threads int) (tfliteRuntime, error) {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	r := &cTFLite{model: C.TfLiteModelCreateFromFile(cpath)}
+	if r.model == nil {
+		return nil, fmt.Errorf("failed to load tflite model %s", path)
+	}
+	r.options = C.TfLiteInterpreterOptionsCreate()
+	C.TfLiteInterpreterOptionsSetNumThreads(r.options, C.int32_t(threads))
+
+	r.interpreter = C.TfLiteInterpreterCreate(r.model, r.options)
+	if r.interpreter == nil {
+		r.close()
+		return nil, fmt.Errorf("failed to create tflite interpreter for %s", path)
+	}
+	if C.TfLiteInterpreterAllocateTensors(r.interpreter) != C.kTfLiteOk {
+		r.close()
+		return nil, fmt.Errorf("failed to allocate tflite tensors for %s", path)
+	}
+	if n := C.TfLiteInterpreterGetInputTensorCount(r.interpreter); n != 1 {
+		r.close()
+		return nil, fmt.Errorf("tflite model has %d input tensors, expected 1", int(n))
+	}
+	if n := C.TfLiteInterpreterGetOutputTensorCount(r.interpreter); n != 1 {
+		r.close()
+		return nil, fmt.Errorf("tflite model has %d output tensors, expected 1", int(n))
+	}
+
+	var err error
+	if r.in, err = tensorInfoOf(C.TfLiteInterpreterGetInputTensor(r.interpreter, 0)); err != nil {
+		r.close()
+		return nil, fmt.Errorf("unsupported tflite input: %w", err)
+	}
+	if r.out, err = tensorInfoOf(C.TfLiteInterpreterGetOutputTensor(r.interpreter, 0)); err != nil {
+		r.close()
+		return nil, fmt.Errorf("unsupported tflite output: %w", err)
+	}
+	return r, nil
+}
+
+// tensorInfoOf describes a tensor
+func tensorInfoOf(tensor *C.TfLiteTensor) (tensorInfo, error) {
+	var info tensorInfo
+	switch C.TfLiteTensorType(tensor) {
+	case C.kTfLiteFloat32:
+		info.dtype = tensorFloat32
+	case C.kTfLiteUInt8:
+		info.dtype = tensorUInt8
+	case C.kTfLiteInt8:
+		info.dtype = tensorInt8
+	default:
+		return info, fmt.Errorf("tensor type %d", int(C.TfLiteTensorType(tensor)))
+	}
+
+	info.elements = 1
+	for i := C.int32_t(0); i < C.TfLiteTensorNumDims(tensor); i++ {
+		info.elements *= int(C.TfLiteTensorDim(tensor, i))
+	}
+	params := C.TfLiteTensorQuantizationParams(tensor)
+	info.scale, info.zeroPoint = float32(params.scale), int32(params.zero_point)
+	return info, nil
+}
+
+func (r *cTFLite) input() tensorInfo  { return r.in }
+func (r *cTFLite) output() tensorInfo { return r.out }
+
+// invoke copies the input into the input tensor, runs the model and copies
+// the output tensor out
+func (r *cTFLite) invoke(input []byte) ([]byte, error) {
+	in := C.TfLiteInterpreterGetInputTensor(r.interpreter, 0)
+	if C.TfLiteTensorCopyFromBuffer(in, unsafe.Pointer(&input[0]), C.size_t(len(input))) != C.kTfLiteOk {
+		return nil, fmt.Errorf("failed to set input tensor")
+	}
+	if C.TfLiteInterpreterInvoke(r.interpreter) != C.kTfLiteOk {
+		return nil, fmt.Errorf("failed to invoke interpreter")
+	}
+
+	out := C.TfLiteInterpreterGetOutputTensor(r.interpreter, 0)
+	output := make([]byte, r.out.byteSize())
+	if C.TfLiteTensorCopyToBuffer(out, unsafe.Pointer(&output[0]), C.size_t(len(output))) != C.kTfLiteOk {
+		return nil, fmt.Errorf("failed to read output tensor")
+	}
+	return output, nil
+}
+
+// close releases the interpreter, its options and the model
+func (r *cTFLite) close() {
+	if r.interpreter != nil {
+		C.TfLiteInterpreterDelete(r.interpreter)
+		r.interpreter = nil
+	}
+	if r.options != nil {
+		C.TfLiteInterpreterOptionsDelete(r.options)
+		r.options = nil
+	}
+	if r.model != nil {
+		C.TfLiteModelDelete(r.model)
+		r.model = nil
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !tflite
+
+package ml
+
+import "fmt"
+
+// openTFLiteRuntime reports that TFLite support was not built in
+func openTFLiteRuntime(path string, threads int) (tfliteRuntime, error) {
+	return nil, fmt.Errorf("tflite model format requires a build with the tflite tag and libtensorflowlite_c")
+}
@@ -0,0 +1,93 @@
+package ml
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRuntime records the input tensor data and returns a fixed output
+type fakeRuntime struct {
+	in, out tensorInfo
+	last    []byte
+	result  []byte
+	err     error
+	closed  bool
+}
+
+func (r *fakeRuntime) input() tensorInfo  { return r.in }
+func (r *fakeRuntime) output() tensorInfo { return r.out }
+func (r *fakeRuntime) close()             { r.closed = true }
+
+func (r *fakeRuntime) invoke(input []byte) ([]byte, error) {
+	r.last = input
+	return r.result, r.err
+}
+
+func TestTFLiteModelFloat32(t *testing.T) {
+	result := make([]byte, 8)
+	binary.NativeEndian.PutUint32(result, math.Float32bits(0.25))
+	binary.NativeEndian.PutUint32(result[4:], math.Float32bits(0.75))
+	runtime := &fakeRuntime{
+		in:     tensorInfo{dtype: tensorFloat32, elements: 2},
+		out:    tensorInfo{dtype: tensorFloat32, elements: 2},
+		result: result,
+	}
+	model, err := newTFLiteModel(runtime)
+	require.NoError(t, err)
+	assert.Equal(t, 2, model.InputSize())
+
+	score, err := model.Predict([]float64{1.5, -2})
+	require.NoError(t, err)
+	assert.InDelta(t, 0.75, score, 1e-6, "the bot class is the second output")
+	assert.Equal(t, float32(1.5), math.Float32frombits(binary.NativeEndian.Uint32(runtime.last)))
+	assert.Equal(t, float32(-2), math.Float32frombits(binary.NativeEndian.Uint32(runtime.last[4:])))
+
+	_, err = model.Predict([]float64{1})
+	assert.ErrorContains(t, err, "invalid feature vector size")
+
+	require.NoError(t, model.Close())
+	assert.True(t, runtime.closed)
+}
+
+func TestTFLiteModelQuantized(t *testing.T) {
+	runtime := &fakeRuntime{
+		in:     tensorInfo{dtype: tensorInt8, elements: 3, scale: 0.5, zeroPoint: -10},
+		out:    tensorInfo{dtype: tensorUInt8, elements: 1, scale: 1.0 / 256, zeroPoint: 0},
+		result: []byte{192},
+	}
+	model, err := newTFLiteModel(runtime)
+	require.NoError(t, err)
+
+	score, err := model.Predict([]float64{1, 100, -1000})
+	require.NoError(t, err)
+	assert.InDelta(t, 0.75, score, 1e-9)
+	assert.Equal(t, []int8{-8, 127, -128}, []int8{int8(runtime.last[0]), int8(runtime.last[1]), int8(runtime.last[2])},
+		"values are quantized and clamped to the int8 range")
+
+	runtime.err = errors.New("boom")
+	_, err = model.Predict([]float64{0, 0, 0})
+	assert.ErrorContains(t, err, "tflite inference failed")
+}
+
+func TestTFLiteModelOutputs(t *testing.T) {
+	runtime := &fakeRuntime{
+		in:  tensorInfo{dtype: tensorFloat32, elements: 4},
+		out: tensorInfo{dtype: tensorFloat32, elements: 10},
+	}
+	_, err := newTFLiteModel(runtime)
+	assert.ErrorContains(t, err, "expected 1 or 2")
+	assert.True(t, runtime.closed)
+}
+
+func TestOpenTFLiteModelWithoutSupport(t *testing.T) {
+	if _, err := openTFLiteRuntime("", 1); err == nil {
+		t.Skip("built with tflite support")
+	}
+	_, err := NewMLEngine(MLConfig{ModelFormat: ModelFormatTFLite, ModelPath: "model.tflite", FeatureSize: 128})
+	assert.ErrorContains(t, err, "failed to load tflite model")
+}
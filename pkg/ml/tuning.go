@@ -0,0 +1,315 @@
+package ml
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Tuning strategies
+const (
+	TuningGrid   = "grid"   // every combination, in order
+	TuningRandom = "random" // combinations drawn at random without repeats
+)
+
+// Tuning objectives, measured by cross-validation
+const (
+	ObjectiveF1       = "f1"
+	ObjectiveAccuracy = "accuracy"
+	ObjectiveROCAUC   = "roc_auc"
+)
+
+const (
+	defaultTuningTrials = 20
+	defaultTuningFolds  = 3
+)
+
+// SearchSpace lists the values tried for each hyperparameter, keyed like
+// MLConfig. Parameters without values keep their configured value, and
+// parameters of models the engine does not use are not searched. The
+// neural network is not trained in process, so its hidden size and
+// learning rate cannot be tuned.
+type SearchSpace struct {
+	GBDTTrees          []int     `yaml:"gbdt_trees"`
+	GBDTMaxDepth       []int     `yaml:"gbdt_max_depth"`
+	GBDTLearningRate   []float64 `yaml:"gbdt_learning_rate"`
+	GBDTMinSamplesLeaf []int     `yaml:"gbdt_min_samples_leaf"`
+	KNNNeighbors       []int     `yaml:"knn_neighbors"`
+	KNNDistance        []string  `yaml:"knn_distance"`
+	SVMNu              []float64 `yaml:"svm_nu"`    // one-class SVM only
+	SVMGamma           []float64 `yaml:"svm_gamma"` // one-class SVM only
+}
+
+// DefaultSearchSpace returns the space searched when none is given
+func DefaultSearchSpace() SearchSpace {
+	return SearchSpace{
+		GBDTTrees:          []int{50, 100, 200},
+		GBDTMaxDepth:       []int{3, 4, 6},
+		GBDTLearningRate:   []float64{0.05, 0.1, 0.2},
+		GBDTMinSamplesLeaf: []int{5, 10, 20},
+		KNNNeighbors:       []int{3, 5, 9, 15},
+		KNNDistance:        []string{"euclidean", "manhattan", "chebyshev"},
+		SVMNu:              []float64{0.01, 0.05, 0.1, 0.2},
+		SVMGamma:           []float64{0.01, 0.03, 0.1, 0.3},
+	}
+}
+
+// TuningOptions configure a hyperparameter search. The search stops after
+// MaxTrials trials or, if set, once MaxDuration has passed, whichever comes
+// first. At least one trial runs, and a trial that has started is always
+// finished.
+type TuningOptions struct {
+	Strategy    string        // "grid" (default) or "random"
+	Objective   string        // "f1" (default), "accuracy" or "roc_auc"
+	MaxTrials   int           // 20 if unset
+	MaxDuration time.Duration // no time limit if unset
+	Folds       int           // cross-validation folds, 3 if unset
+	Seed        int64         // seeds the random strategy and the folds
+	Space       *SearchSpace  // DefaultSearchSpace if nil
+}
+
+// Trial is the cross-validated score of one combination of parameters
+type Trial struct {
+	Params   map[string]any `json:"params" yaml:"params"`
+	Score    float64        `json:"score" yaml:"score"` // of the objective
+	Accuracy float64        `json:"accuracy" yaml:"accuracy"`
+	Duration time.Duration  `json:"duration" yaml:"duration"`
+	Error    string         `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// TuningResult is the outcome of a hyperparameter search
+type TuningResult struct {
+	Strategy     string        `json:"strategy"`
+	Objective    string        `json:"objective"`
+	Combinations int           `json:"combinations"` // size of the search space
+	Trials       []Trial       `json:"trials"`
+	Best         Trial         `json:"best"`
+	Config       MLConfig      `json:"-"` // the engine's configuration with the best parameters
+	Elapsed      time.Duration `json:"elapsed"`
+}
+
+// dimension is one searched hyperparameter
+type dimension struct {
+	name   string // MLConfig YAML key
+	values []any
+	set    func(config *MLConfig, i int)
+}
+
+// newDimension returns the dimension of an MLConfig field
+func newDimension[T any](name string, values []T, field func(*MLConfig) *T) dimension {
+	d := dimension{name: name, set: func(config *MLConfig, i int) { *field(config) = values[i] }}
+	for _, v := range values {
+		d.values = append(d.values, v)
+	}
+	return d
+}
+
+// dimensions returns the non-empty dimensions of the space that apply to
+// the given models
+func (s *SearchSpace) dimensions(config MLConfig, models []string) []dimension {
+	uses := map[string]bool{}
+	for _, model := range models {
+		uses[model] = true
+	}
+	oneClass := uses["svm"] && config.SVMMode == "one_class"
+
+	var dims []dimension
+	add := func(ok bool, d dimension) {
+		if ok && len(d.values) > 0 {
+			dims = append(dims, d)
+		}
+	}
+	add(uses["gbdt"], newDimension("gbdt_trees", s.GBDTTrees, func(c *MLConfig) *int { return &c.GBDTTrees }))
+	add(uses["gbdt"], newDimension("gbdt_max_depth", s.GBDTMaxDepth, func(c *MLConfig) *int { return &c.GBDTMaxDepth }))
+	add(uses["gbdt"], newDimension("gbdt_learning_rate", s.GBDTLearningRate, func(c *MLConfig) *float64 { return &c.GBDTLearningRate }))
+	add(uses["gbdt"], newDimension("gbdt_min_samples_leaf", s.GBDTMinSamplesLeaf, func(c *MLConfig) *int { return &c.GBDTMinSamplesLeaf }))
+	add(uses["knn"], newDimension("knn_neighbors", s.KNNNeighbors, func(c *MLConfig) *int { return &c.KNNNeighbors }))
+	add(uses["knn"], newDimension("knn_distance", s.KNNDistance, func(c *MLConfig) *string { return &c.KNNDistance }))
+	add(oneClass, newDimension("svm_nu", s.SVMNu, func(c *MLConfig) *float64 { return &c.SVMNu }))
+	add(oneClass, newDimension("svm_gamma", s.SVMGamma, func(c *MLConfig) *float64 { return &c.SVMGamma }))
+	return dims
+}
+
+// combinations returns the number of combinations of the dimensions
+func combinations(dims []dimension) int {
+	n := 1
+	for _, d := range dims {
+		n *= len(d.values)
+	}
+	return n
+}
+
+// combination returns the value index of each dimension for combination n,
+// the last dimension varying fastest
+func combination(dims []dimension, n int) []int {
+	indices := make([]int, len(dims))
+	for i := len(dims) - 1; i >= 0; i-- {
+		indices[i] = n % len(dims[i].values)
+		n /= len(dims[i].values)
+	}
+	return indices
+}
+
+// Tune searches hyperparameters of the engine's models, scoring each
+// combination by stratified k-fold cross-validation on scratch engines.
+// All trials use the same folds. Without samples, FakeDataSize synthetic
+// samples are generated as for training. The engine itself is left
+// untouched; the best configuration is returned in the result.
+func (e *MLEngine) Tune(ctx context.Context, features [][]float64, labels []int, opts TuningOptions) (*TuningResult, error) {
+	if e.tflite != nil {
+		return nil, fmt.Errorf("tflite models cannot be tuned in process")
+	}
+	if opts.Strategy == "" {
+		opts.Strategy = TuningGrid
+	}
+	if opts.Strategy != TuningGrid && opts.Strategy != TuningRandom {
+		return nil, fmt.Errorf("unsupported tuning strategy: %s", opts.Strategy)
+	}
+	if opts.Objective == "" {
+		opts.Objective = ObjectiveF1
+	}
+	if opts.Objective != ObjectiveF1 && opts.Objective != ObjectiveAccuracy && opts.Objective != ObjectiveROCAUC {
+		return nil, fmt.Errorf("unsupported tuning objective: %s", opts.Objective)
+	}
+	if opts.MaxTrials <= 0 {
+		opts.MaxTrials = defaultTuningTrials
+	}
+	if opts.Folds == 0 {
+		opts.Folds = defaultTuningFolds
+	}
+	if opts.Folds < 2 {
+		return nil, fmt.Errorf("tuning needs at least 2 cross-validation folds, got %d", opts.Folds)
+	}
+	space := DefaultSearchSpace()
+	if opts.Space != nil {
+		space = *opts.Space
+	}
+
+	models := e.models()
+	dims := space.dimensions(e.config, models)
+	if len(dims) == 0 {
+		return nil, fmt.Errorf("no tunable parameters for models %s", strings.Join(models, ", "))
+	}
+
+	if features == nil {
+		features, labels = e.dataGen.GenerateFakeData(e.config.FakeDataSize, e.config.FeatureSize)
+	}
+	if len(features) != len(labels) {
+		return nil, fmt.Errorf("got %d samples and %d labels", len(features), len(labels))
+	}
+
+	// Trials draw their combinations in order, or shuffled for a random
+	// search, so that neither repeats one
+	total := combinations(dims)
+	order := make([]int, total)
+	for i := range order {
+		order[i] = i
+	}
+	if opts.Strategy == TuningRandom {
+		rand.New(rand.NewSource(opts.Seed)).Shuffle(total, func(i, j int) { order[i], order[j] = order[j], order[i] })
+	}
+
+	result := &TuningResult{
+		Strategy:     opts.Strategy,
+		Objective:    opts.Objective,
+		Combinations: total,
+	}
+	start := time.Now()
+	best := -1
+	for _, n := range order {
+		if len(result.Trials) == opts.MaxTrials {
+			break
+		}
+		if opts.MaxDuration > 0 && len(result.Trials) > 0 && time.Since(start) >= opts.MaxDuration {
+			slog.Info("Tuning time budget exhausted", "trials", len(result.Trials), "budget", opts.MaxDuration)
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		config := e.config
+		trial := Trial{Params: make(map[string]any, len(dims))}
+		for d, i := range combination(dims, n) {
+			dims[d].set(&config, i)
+			trial.Params[dims[d].name] = dims[d].values[i]
+		}
+
+		trialStart := time.Now()
+		if err := e.runTrial(config, features, labels, models, opts, &trial); err != nil {
+			trial.Error = err.Error()
+			slog.Warn("Tuning trial failed", "params", trial.Params, "error", err)
+		}
+		trial.Duration = time.Since(trialStart)
+		result.Trials = append(result.Trials, trial)
+
+		if trial.Error == "" && (best < 0 || trial.Score > result.Trials[best].Score) {
+			best = len(result.Trials) - 1
+			result.Config = config
+		}
+	}
+	result.Elapsed = time.Since(start)
+
+	if best < 0 {
+		return nil, fmt.Errorf("all %d tuning trials failed, last: %s", len(result.Trials), result.Trials[len(result.Trials)-1].Error)
+	}
+	result.Best = result.Trials[best]
+	slog.Info("Tuning completed", "trials", len(result.Trials), "combinations", total,
+		"objective", opts.Objective, "score", result.Best.Score, "params", result.Best.Params)
+	return result, nil
+}
+
+// runTrial cross-validates a candidate configuration on a scratch engine
+func (e *MLEngine) runTrial(config MLConfig, features [][]float64, labels []int, models []string, opts TuningOptions, trial *Trial) error {
+	config.GenerateFakeData = false
+	config.TrainingDataPath = ""
+	config.LoadModel = false
+	config.SaveModel = false
+	candidate, err := NewMLEngine(config)
+	if err != nil {
+		return err
+	}
+	defer candidate.Close()
+
+	eval, err := candidate.crossValidate(features, labels, models, opts.Folds, rand.New(rand.NewSource(opts.Seed)))
+	if err != nil {
+		return err
+	}
+	trial.Accuracy = eval.accuracy
+	switch opts.Objective {
+	case ObjectiveAccuracy:
+		trial.Score = eval.accuracy
+	case ObjectiveROCAUC:
+		curves, err := computeCurves(eval.scores, eval.labels)
+		if err != nil {
+			return err
+		}
+		trial.Score = curves.ROCAUC
+	default:
+		trial.Score = eval.confusion.F1
+	}
+	return nil
+}
+
+// WriteConfig writes the best parameters as an ml section of config.yml
+func (r *TuningResult) WriteConfig(path string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Tuned by %s search of %d of %d combinations: cross-validated %s %.4f\n",
+		r.Strategy, len(r.Trials), r.Combinations, r.Objective, r.Best.Score)
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(map[string]map[string]any{"ml": r.Best.Params}); err != nil {
+		return fmt.Errorf("failed to encode tuned configuration: %w", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write tuned configuration: %w", err)
+	}
+	return nil
+}
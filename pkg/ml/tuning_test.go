@@ -0,0 +1,124 @@
+package ml
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func newTuningEngine(t *testing.T, modelType string) *MLEngine {
+	engine, err := NewMLEngine(MLConfig{
+		ModelType:          modelType,
+		DetectionThreshold: 0.5,
+		FeatureSize:        8,
+		FakeDataSize:       200,
+		KNNNeighbors:       5,
+		GBDTTrees:          20,
+		GBDTMaxDepth:       3,
+		GBDTLearningRate:   0.1,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { engine.Close() })
+	return engine
+}
+
+func TestTuneGrid(t *testing.T) {
+	engine := newTuningEngine(t, "knn")
+	space := &SearchSpace{
+		KNNNeighbors: []int{1, 5},
+		KNNDistance:  []string{"euclidean", "manhattan"},
+		GBDTTrees:    []int{10, 20}, // not used by the engine
+	}
+	result, err := engine.Tune(context.Background(), nil, nil, TuningOptions{Space: space, Objective: ObjectiveAccuracy})
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, result.Combinations)
+	require.Len(t, result.Trials, 4)
+	assert.Equal(t, map[string]any{"knn_neighbors": 1, "knn_distance": "euclidean"}, result.Trials[0].Params)
+	assert.Equal(t, map[string]any{"knn_neighbors": 5, "knn_distance": "manhattan"}, result.Trials[3].Params)
+	for _, trial := range result.Trials {
+		assert.Empty(t, trial.Error)
+		assert.Equal(t, trial.Accuracy, trial.Score)
+		assert.LessOrEqual(t, trial.Score, result.Best.Score)
+	}
+	assert.Equal(t, result.Best.Params["knn_neighbors"], result.Config.KNNNeighbors)
+	assert.Equal(t, result.Best.Params["knn_distance"], result.Config.KNNDistance)
+
+	// The engine keeps its configuration
+	assert.Equal(t, 5, engine.config.KNNNeighbors)
+}
+
+func TestTuneBudget(t *testing.T) {
+	engine := newTuningEngine(t, "gbdt")
+	space := &SearchSpace{GBDTTrees: []int{5, 10, 20}, GBDTMaxDepth: []int{2, 3}}
+
+	result, err := engine.Tune(context.Background(), nil, nil, TuningOptions{
+		Strategy:  TuningRandom,
+		MaxTrials: 4,
+		Space:     space,
+		Seed:      7,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 6, result.Combinations)
+	require.Len(t, result.Trials, 4)
+
+	// Random trials do not repeat combinations
+	seen := map[[2]any]bool{}
+	for _, trial := range result.Trials {
+		key := [2]any{trial.Params["gbdt_trees"], trial.Params["gbdt_max_depth"]}
+		assert.False(t, seen[key], "repeated %v", key)
+		seen[key] = true
+	}
+
+	// A time budget stops the search after the trial that exhausts it
+	result, err = engine.Tune(context.Background(), nil, nil, TuningOptions{MaxDuration: 1, Space: space})
+	require.NoError(t, err)
+	assert.Len(t, result.Trials, 1)
+}
+
+func TestTuneErrors(t *testing.T) {
+	engine := newTuningEngine(t, "knn")
+	ctx := context.Background()
+
+	_, err := engine.Tune(ctx, nil, nil, TuningOptions{Strategy: "bayesian"})
+	assert.ErrorContains(t, err, "unsupported tuning strategy")
+	_, err = engine.Tune(ctx, nil, nil, TuningOptions{Objective: "recall"})
+	assert.ErrorContains(t, err, "unsupported tuning objective")
+	_, err = engine.Tune(ctx, nil, nil, TuningOptions{Folds: 1})
+	assert.ErrorContains(t, err, "at least 2 cross-validation folds")
+	_, err = engine.Tune(ctx, nil, nil, TuningOptions{Space: &SearchSpace{GBDTTrees: []int{10}}})
+	assert.ErrorContains(t, err, "no tunable parameters for models knn")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = engine.Tune(cancelled, nil, nil, TuningOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestTuningResultWriteConfig(t *testing.T) {
+	result := &TuningResult{
+		Strategy:     TuningGrid,
+		Objective:    ObjectiveF1,
+		Combinations: 4,
+		Trials:       make([]Trial, 4),
+		Best:         Trial{Params: map[string]any{"knn_neighbors": 9, "knn_distance": "manhattan"}, Score: 0.93},
+	}
+	path := filepath.Join(t.TempDir(), "tuned.yml")
+	require.NoError(t, result.WriteConfig(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "# Tuned by grid search of 4 of 4 combinations: cross-validated f1 0.9300")
+
+	var written struct {
+		ML MLConfig `yaml:"ml"`
+	}
+	require.NoError(t, yaml.Unmarshal(data, &written))
+	assert.Equal(t, 9, written.ML.KNNNeighbors)
+	assert.Equal(t, "manhattan", written.ML.KNNDistance)
+}
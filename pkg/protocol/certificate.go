@@ -0,0 +1,141 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// ServerHello holds the parameters a TLS server chose
+type ServerHello struct {
+	Version     uint16   `json:"version"` // negotiated, from supported_versions in TLS 1.3
+	CipherSuite uint16   `json:"cipher_suite"`
+	Extensions  []uint16 `json:"extensions"` // extension types
+}
+
+// Certificate describes the certificate a TLS server presented, as sent
+// in the clear before TLS 1.3 or seen in decrypted mirrors. Short-lived,
+// self-signed certificates without alternative names are common on
+// command and control servers and rare on legitimate ones.
+type Certificate struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	SANCount    int       `json:"san_count"` // DNS names, addresses, emails and URIs
+	SelfSigned  bool      `json:"self_signed"`
+	ChainLength int       `json:"chain_length"` // certificates sent, the server's first
+}
+
+// ValidityDays returns the length of the validity window in days, 0 for
+// windows ending before they start
+func (c *Certificate) ValidityDays() float64 {
+	days := c.NotAfter.Sub(c.NotBefore).Hours() / 24
+	if days < 0 {
+		return 0
+	}
+	return days
+}
+
+// parseServerHello parses the body of a ServerHello handshake message.
+// A malformed supported_versions extension or trailing data leave the
+// hello returned with the error.
+func parseServerHello(body []byte) (*ServerHello, error) {
+	r := &reader{data: body}
+	hello := &ServerHello{Version: r.uint16()}
+	r.bytes(32) // random
+	r.vector8() // legacy session ID
+	hello.CipherSuite = r.uint16()
+	r.uint8() // legacy compression method
+	if r.err != nil {
+		return nil, fmt.Errorf("tls server hello: %w", ErrTruncated)
+	}
+
+	if r.empty() {
+		return hello, nil
+	}
+	var malformed error
+	extensions := r.vector16()
+	for !extensions.empty() {
+		extType := extensions.uint16()
+		ext := extensions.vector16()
+		if extensions.err != nil {
+			break
+		}
+		hello.Extensions = append(hello.Extensions, extType)
+		if extType == tlsExtSupportedVersions {
+			if version := ext.uint16(); ext.err == nil {
+				hello.Version = version
+			}
+			if err := ext.check(); err != nil && malformed == nil {
+				malformed = fmt.Errorf("tls server hello extension %d: %w", extType, err)
+			}
+		}
+	}
+	if r.err != nil || extensions.err != nil {
+		return nil, fmt.Errorf("tls server hello extensions: %w", ErrTruncated)
+	}
+	if malformed == nil && !r.empty() {
+		malformed = fmt.Errorf("tls server hello: %w", ErrTrailingData)
+	}
+
+	return hello, malformed
+}
+
+// parseCertificateMessage parses the body of a Certificate handshake
+// message, in the TLS 1.3 layout when tls13 is set, and describes the
+// first certificate of the chain. Trailing data leaves the certificate
+// returned with the error.
+func parseCertificateMessage(body []byte, tls13 bool) (*Certificate, error) {
+	r := &reader{data: body}
+	if tls13 {
+		r.vector8() // certificate request context
+	}
+	list := r.vector24()
+	if r.err != nil {
+		return nil, fmt.Errorf("tls certificate: %w", ErrTruncated)
+	}
+
+	var chain [][]byte
+	for !list.empty() {
+		der := list.vector24()
+		if tls13 {
+			list.vector16() // certificate extensions
+		}
+		if list.err != nil {
+			return nil, fmt.Errorf("tls certificate list: %w", ErrTruncated)
+		}
+		chain = append(chain, der.data)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("tls certificate message carries no certificate: %w", ErrMalformed)
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("tls server certificate: %w: %w", ErrMalformed, err)
+	}
+	if !r.empty() {
+		err = fmt.Errorf("tls certificate: %w", ErrTrailingData)
+	}
+	return &Certificate{
+		Subject:   leaf.Subject.String(),
+		Issuer:    leaf.Issuer.String(),
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+		SANCount:  len(leaf.DNSNames) + len(leaf.IPAddresses) + len(leaf.EmailAddresses) + len(leaf.URIs),
+		SelfSigned: bytes.Equal(leaf.RawIssuer, leaf.RawSubject) &&
+			leaf.CheckSignature(leaf.SignatureAlgorithm, leaf.RawTBSCertificate, leaf.Signature) == nil,
+		ChainLength: len(chain),
+	}, err
+}
+
+// certificateFeatures summarizes a server certificate as protocol features
+func certificateFeatures(cert *Certificate, features map[string]interface{}) {
+	features["cert_issuer"] = cert.Issuer
+	features["cert_validity_days"] = cert.ValidityDays()
+	features["cert_san_count"] = cert.SANCount
+	features["cert_self_signed"] = cert.SelfSigned
+	features["cert_chain_length"] = cert.ChainLength
+}
@@ -0,0 +1,141 @@
+package protocol
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfSignedCertificate returns a DER certificate valid for a week
+func selfSignedCertificate(t testing.TB) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "c2.example"},
+		NotBefore:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC),
+		DNSNames:     []string{"c2.example"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return der
+}
+
+// handshakeRecord wraps handshake messages in a TLS record
+func handshakeRecord(messages ...[]byte) []byte {
+	var fragment []byte
+	for _, m := range messages {
+		fragment = append(fragment, m...)
+	}
+	return append([]byte{tlsRecordHandshake, 0x03, 0x03, byte(len(fragment) >> 8), byte(len(fragment))}, fragment...)
+}
+
+// handshakeMessage prefixes a body with its handshake header
+func handshakeMessage(msgType uint8, body []byte) []byte {
+	return append([]byte{msgType, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+// uint24Vector prefixes data with its three-byte length
+func uint24Vector(data []byte) []byte {
+	return append([]byte{byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+// serverHello returns a ServerHello body, negotiating TLS 1.3 through
+// supported_versions if tls13 is set
+func serverHello(tls13 bool) []byte {
+	body := []byte{0x03, 0x03}
+	body = append(body, make([]byte, 32)...)
+	body = append(body, 0, 0x13, 0x01, 0)
+	if tls13 {
+		body = append(body, 0, 6, 0, 43, 0, 2, 0x03, 0x04)
+	}
+	return body
+}
+
+func TestParseServerCertificate(t *testing.T) {
+	der := selfSignedCertificate(t)
+
+	// TLS 1.2 sends the chain in the clear after the ServerHello
+	hello := handshakeRecord(handshakeMessage(tlsServerHello, serverHello(false)))
+	certificate := handshakeRecord(
+		handshakeMessage(tlsCertificate, uint24Vector(uint24Vector(der))),
+		handshakeMessage(tlsServerHelloDone, nil))
+	assert.False(t, TLSHandshakeComplete(hello))
+	assert.False(t, TLSHandshakeComplete(append(hello, certificate[:100]...)))
+	data := append(hello, certificate...)
+	assert.True(t, TLSHandshakeComplete(data))
+
+	info, err := NewParser().ParsePacket(data)
+	require.NoError(t, err)
+	require.NotNil(t, info.ServerHello)
+	assert.Equal(t, uint16(0x0303), info.ServerHello.Version)
+	assert.Equal(t, uint16(0x1301), info.ServerHello.CipherSuite)
+	cert := info.Certificate
+	require.NotNil(t, cert)
+	assert.Equal(t, "CN=c2.example", cert.Subject)
+	assert.Equal(t, "CN=c2.example", cert.Issuer)
+	assert.Equal(t, 7.0, cert.ValidityDays())
+	assert.Equal(t, 1, cert.SANCount)
+	assert.True(t, cert.SelfSigned)
+	assert.Equal(t, 1, cert.ChainLength)
+	assert.Equal(t, true, info.Features["cert_self_signed"])
+	assert.Equal(t, 7.0, info.Features["cert_validity_days"])
+
+	// TLS 1.3 encrypts the certificate, leaving the ServerHello
+	hello = handshakeRecord(handshakeMessage(tlsServerHello, serverHello(true)))
+	assert.False(t, TLSHandshakeComplete(hello))
+	encrypted := append(hello, 0x14, 0x03, 0x03, 0x00, 0x01, 0x01)
+	assert.True(t, TLSHandshakeComplete(encrypted))
+	info, err = NewParser().ParsePacket(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tlsVersion13), info.ServerHello.Version)
+	assert.Nil(t, info.Certificate)
+
+	// unless the stream is a decrypted mirror
+	entry := append(uint24Vector(der), 0, 0)
+	mirror := append(hello, handshakeRecord(handshakeMessage(tlsCertificate, append([]byte{0}, uint24Vector(entry)...)))...)
+	assert.True(t, TLSHandshakeComplete(mirror))
+	info, err = NewParser().ParsePacket(mirror)
+	require.NoError(t, err)
+	require.NotNil(t, info.Certificate)
+	assert.True(t, info.Certificate.SelfSigned)
+
+	_, err = parseCertificateMessage(uint24Vector(uint24Vector(der[:50])), false)
+	assert.Error(t, err)
+}
+
+func FuzzParseServerHello(f *testing.F) {
+	f.Add(serverHello(false))
+	f.Add(serverHello(true))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		hello, err := parseServerHello(body)
+		assertTypedError(t, err)
+		if err == nil && hello == nil {
+			t.Fatal("no hello and no error")
+		}
+	})
+}
+
+func FuzzParseCertificateMessage(f *testing.F) {
+	der := selfSignedCertificate(f)
+	f.Add(uint24Vector(uint24Vector(der)), false)
+	f.Add(append([]byte{0}, uint24Vector(append(uint24Vector(der), 0, 0))...), true)
+
+	f.Fuzz(func(t *testing.T, body []byte, tls13 bool) {
+		cert, err := parseCertificateMessage(body, tls13)
+		assertTypedError(t, err)
+		if err == nil && cert == nil {
+			t.Fatal("no certificate and no error")
+		}
+	})
+}
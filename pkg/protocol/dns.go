@@ -0,0 +1,251 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// DNS header flags, response codes and record types read by the parser
+const (
+	dnsFlagResponse  = 0x8000
+	dnsFlagTruncated = 0x0200
+
+	DNSRCodeNXDomain = 3
+
+	// dnsMaxPointers bounds the compression pointers followed for a name,
+	// so that pointer loops end
+	dnsMaxPointers = 16
+	// dnsMaxNameLength is the longest name, in wire format, DNS allows
+	dnsMaxNameLength = 255
+)
+
+// dnsTypeNames names the record types worth telling apart; TXT and NULL
+// carry most tunnelled data, A and AAAA most legitimate lookups
+var dnsTypeNames = map[uint16]string{
+	1:   "A",
+	2:   "NS",
+	5:   "CNAME",
+	6:   "SOA",
+	10:  "NULL",
+	12:  "PTR",
+	15:  "MX",
+	16:  "TXT",
+	28:  "AAAA",
+	33:  "SRV",
+	64:  "SVCB",
+	65:  "HTTPS",
+	255: "ANY",
+}
+
+// DNSTypeName names a DNS record type, e.g. "AAAA" or "TYPE99"
+func DNSTypeName(t uint16) string {
+	if name, ok := dnsTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+// DNSQuestion is an entry of the question section of a DNS message
+type DNSQuestion struct {
+	Name  string `json:"name"`
+	Type  uint16 `json:"type"`
+	Class uint16 `json:"class"`
+}
+
+// DNSMessage holds the header and questions of a DNS message. Answers are
+// only counted: bots give themselves away by what they ask and how often
+// the answer is that the name does not exist.
+type DNSMessage struct {
+	ID          uint16        `json:"id"`
+	Response    bool          `json:"response"`
+	Opcode      uint8         `json:"opcode"`
+	RCode       uint8         `json:"rcode"`
+	Truncated   bool          `json:"truncated,omitempty"`
+	Questions   []DNSQuestion `json:"questions"`
+	Answers     int           `json:"answers"`
+	Authorities int           `json:"authorities"`
+	Additionals int           `json:"additionals"`
+}
+
+// ParseDNSMessage parses a DNS message as carried in a UDP datagram. The
+// resource records after the questions are only walked over; one running
+// past the message or data after the last leave the message returned with
+// the error.
+func ParseDNSMessage(data []byte) (*DNSMessage, error) {
+	r := &reader{data: data}
+	msg := &DNSMessage{ID: r.uint16()}
+	flags := r.uint16()
+	questions := int(r.uint16())
+	msg.Answers = int(r.uint16())
+	msg.Authorities = int(r.uint16())
+	msg.Additionals = int(r.uint16())
+	if r.err != nil {
+		return nil, fmt.Errorf("dns header: %w", r.err)
+	}
+	msg.Response = flags&dnsFlagResponse != 0
+	msg.Opcode = uint8(flags>>11) & 0xf
+	msg.RCode = uint8(flags & 0xf)
+	msg.Truncated = flags&dnsFlagTruncated != 0
+
+	// Every question takes at least five bytes, which bounds the count a
+	// forged header can make the parser allocate for
+	if questions*5 > len(r.data) {
+		return nil, fmt.Errorf("dns message declares %d questions: %w", questions, ErrTruncated)
+	}
+	for i := 0; i < questions; i++ {
+		name, err := dnsName(data, r)
+		if err != nil {
+			return nil, err
+		}
+		question := DNSQuestion{Name: name, Type: r.uint16(), Class: r.uint16()}
+		if r.err != nil {
+			return nil, fmt.Errorf("dns question: %w", r.err)
+		}
+		msg.Questions = append(msg.Questions, question)
+	}
+
+	for i := 0; i < msg.Answers+msg.Authorities+msg.Additionals; i++ {
+		if _, err := dnsName(data, r); err != nil {
+			return msg, fmt.Errorf("dns resource record: %w", err)
+		}
+		r.bytes(8) // type, class and TTL
+		r.vector16()
+		if r.err != nil {
+			return msg, fmt.Errorf("dns resource record: %w", r.err)
+		}
+	}
+	if !r.empty() {
+		return msg, fmt.Errorf("dns message: %w", ErrTrailingData)
+	}
+	return msg, nil
+}
+
+// dnsName reads a possibly compressed name at r from message, returning it
+// in dotted form without the trailing dot
+func dnsName(message []byte, r *reader) (string, error) {
+	var labels []string
+	length, pointers := 0, 0
+	current := r
+	for {
+		size := current.uint8()
+		if current.err != nil {
+			return "", fmt.Errorf("dns name: %w", ErrTruncated)
+		}
+		switch size & 0xc0 {
+		case 0x00:
+			if size == 0 {
+				return strings.Join(labels, "."), nil
+			}
+			label := current.bytes(int(size))
+			if current.err != nil {
+				return "", fmt.Errorf("dns name: %w", ErrTruncated)
+			}
+			if length += int(size) + 1; length > dnsMaxNameLength {
+				return "", fmt.Errorf("dns name longer than %d bytes: %w", dnsMaxNameLength, ErrMalformed)
+			}
+			labels = append(labels, string(label))
+		case 0xc0:
+			offset := int(size&0x3f)<<8 | int(current.uint8())
+			if current.err != nil || offset >= len(message) {
+				return "", fmt.Errorf("dns name pointer: %w", ErrTruncated)
+			}
+			if pointers++; pointers > dnsMaxPointers {
+				return "", fmt.Errorf("dns name follows more than %d pointers: %w", dnsMaxPointers, ErrMalformed)
+			}
+			current = &reader{data: message[offset:]}
+		default:
+			return "", fmt.Errorf("dns name uses reserved label type %#x: %w", size&0xc0, ErrMalformed)
+		}
+	}
+}
+
+// DNSNameStats describes the shape of a queried name. Generated domains
+// and tunnelled data make for long, random-looking labels.
+type DNSNameStats struct {
+	Length         int     // characters, dots included
+	Labels         int     // labels, the top-level domain included
+	MaxLabelLength int     // longest label
+	Entropy        float64 // Shannon entropy in bits per character of the labels below the top-level domain
+}
+
+// MeasureDNSName measures a name in dotted form
+func MeasureDNSName(name string) DNSNameStats {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	stats := DNSNameStats{Length: len(name)}
+	if name == "" {
+		return stats
+	}
+
+	labels := strings.Split(name, ".")
+	stats.Labels = len(labels)
+	for _, label := range labels {
+		if len(label) > stats.MaxLabelLength {
+			stats.MaxLabelLength = len(label)
+		}
+	}
+	if len(labels) > 1 {
+		labels = labels[:len(labels)-1]
+	}
+	stats.Entropy = shannonEntropy(strings.Join(labels, ""))
+	return stats
+}
+
+// shannonEntropy returns the entropy of the characters of s in bits per
+// character
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	var entropy float64
+	for _, n := range counts {
+		if n > 0 {
+			p := float64(n) / float64(len(s))
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// ParseDNS parses a DNS message carried in a UDP datagram. Its questions,
+// the first of which fill in the name features, and response code are
+// recorded in the protocol information.
+func (p *Parser) ParseDNS(data []byte) (*ProtocolInfo, error) {
+	info := &ProtocolInfo{
+		Protocol: "DNS",
+		Version:  "DNS",
+		RawData:  data,
+		Features: make(map[string]interface{}),
+	}
+
+	msg, err := ParseDNSMessage(data)
+	if msg == nil {
+		return info, p.reject("DNS", err)
+	}
+	info.DNS = msg
+
+	info.Features["response"] = msg.Response
+	info.Features["opcode"] = msg.Opcode
+	info.Features["question_count"] = len(msg.Questions)
+	if msg.Response {
+		info.Features["rcode"] = msg.RCode
+		info.Features["nxdomain"] = msg.RCode == DNSRCodeNXDomain
+		info.Features["answer_count"] = msg.Answers
+	}
+	if len(msg.Questions) > 0 {
+		question := msg.Questions[0]
+		stats := MeasureDNSName(question.Name)
+		info.Features["qname"] = question.Name
+		info.Features["qtype"] = DNSTypeName(question.Type)
+		info.Features["qname_length"] = stats.Length
+		info.Features["label_count"] = stats.Labels
+		info.Features["max_label_length"] = stats.MaxLabelLength
+		info.Features["label_entropy"] = stats.Entropy
+	}
+
+	return info, p.reject("DNS", p.partial(err))
+}
@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dnsMessage returns a DNS message with a header of flags and one question
+// for name and qtype, followed by extra
+func dnsMessage(flags uint16, name string, qtype uint16, extra ...byte) []byte {
+	msg := []byte{0x12, 0x34, byte(flags >> 8), byte(flags), 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, byte(qtype>>8), byte(qtype), 0, 1)
+	return append(msg, extra...)
+}
+
+func TestParseDNS(t *testing.T) {
+	parser := NewParser()
+	info, err := parser.ParseDNS(dnsMessage(0x0100, "www.example.com", 28))
+	require.NoError(t, err)
+	assert.Equal(t, "DNS", info.Protocol)
+	require.NotNil(t, info.DNS)
+	assert.Equal(t, uint16(0x1234), info.DNS.ID)
+	assert.False(t, info.DNS.Response)
+	assert.Equal(t, []DNSQuestion{{Name: "www.example.com", Type: 28, Class: 1}}, info.DNS.Questions)
+	assert.Equal(t, "AAAA", info.Features["qtype"])
+	assert.Equal(t, 15, info.Features["qname_length"])
+	assert.Equal(t, 3, info.Features["label_count"])
+	assert.Nil(t, info.Features["nxdomain"])
+
+	info, err = parser.ParseDNS(dnsMessage(0x8183, "kq3vz8xj.example.com", 16))
+	require.NoError(t, err)
+	assert.True(t, info.DNS.Response)
+	assert.Equal(t, uint8(DNSRCodeNXDomain), info.DNS.RCode)
+	assert.Equal(t, true, info.Features["nxdomain"])
+	assert.Equal(t, "TXT", info.Features["qtype"])
+}
+
+func TestParseDNSCompression(t *testing.T) {
+	// A second question pointing back into the first
+	msg := dnsMessage(0x8180, "mail.example.com", 1, 3, 'w', 'w', 'w', 0xc0, 17, 0, 1, 0, 1)
+	msg[5] = 2
+	parsed, err := ParseDNSMessage(msg)
+	require.NoError(t, err)
+	require.Len(t, parsed.Questions, 2)
+	assert.Equal(t, "www.example.com", parsed.Questions[1].Name)
+
+	// Pointer loops end
+	loop := dnsMessage(0x0100, "a", 1)
+	loop = append(loop[:12], 0xc0, 12, 0, 1, 0, 1)
+	_, err = ParseDNSMessage(loop)
+	assert.Error(t, err)
+}
+
+func TestParseDNSRejectsMalformed(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"short header":       {0x12, 0x34, 0x01},
+		"too many questions": append([]byte{0, 0, 0, 0, 0xff, 0xff, 0, 0, 0, 0, 0, 0}, make([]byte, 20)...),
+		"truncated question": dnsMessage(0x0100, "example.com", 1)[:20],
+		"reserved label":     append([]byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}, 0x40, 0, 0, 1, 0, 1),
+	} {
+		_, err := ParseDNSMessage(data)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestMeasureDNSName(t *testing.T) {
+	stats := MeasureDNSName("WWW.Example.com.")
+	assert.Equal(t, 15, stats.Length)
+	assert.Equal(t, 3, stats.Labels)
+	assert.Equal(t, 7, stats.MaxLabelLength)
+
+	// Random labels carry more entropy than words
+	assert.Greater(t, MeasureDNSName("x7k2qv9zj4w8.com").Entropy, MeasureDNSName("google.com").Entropy)
+	assert.Equal(t, 0.0, MeasureDNSName("aaaa.com").Entropy)
+	assert.Equal(t, DNSNameStats{}, MeasureDNSName(""))
+}
+
+func FuzzParseDNSMessage(f *testing.F) {
+	f.Add(dnsMessage(0x0100, "www.example.com", 28))
+	f.Add(dnsMessage(0x8180, "example.com", 1, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 93, 184, 216, 34))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := ParseDNSMessage(data)
+		assertTypedError(t, err)
+		if err == nil && msg == nil {
+			t.Fatal("no message and no error")
+		}
+		_, err = NewStrictParser().ParseDNS(data)
+		assertTypedError(t, err)
+	})
+}
@@ -0,0 +1,39 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The ways a message can be rejected. Parser errors wrap one of them, so
+// callers can tell hostile or broken input apart with errors.Is.
+var (
+	// ErrTruncated is returned when a message ends before a field it
+	// declares
+	ErrTruncated = errors.New("message truncated")
+	// ErrTrailingData is returned when data is left over after a message
+	// or a field of declared length
+	ErrTrailingData = errors.New("trailing data")
+	// ErrMalformed is returned when a field holds a value its protocol
+	// does not allow
+	ErrMalformed = errors.New("malformed message")
+	// ErrUnsupported is returned for versions and message types the
+	// parser does not read
+	ErrUnsupported = errors.New("unsupported message")
+)
+
+// ParseError is returned by a strict parser for a message it rejects
+type ParseError struct {
+	Protocol string
+	Err      error
+}
+
+// Error implements the error interface
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Protocol, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"bytes"
+	"strings"
+)
+
+// grpcContentType is the media type of gRPC requests and responses, which
+// may carry a suffix naming the message encoding, as in
+// "application/grpc+proto"
+const grpcContentType = "application/grpc"
+
+// grpcPrefixSize is the size of the prefix of a length-prefixed message:
+// a compressed flag and a four-byte length
+const grpcPrefixSize = 5
+
+// GRPCCall describes a gRPC call seen in one direction of an HTTP/2
+// connection. Requests name the service and method in their path;
+// responses only reveal gRPC by their content type and framing.
+type GRPCCall struct {
+	Service     string `json:"service,omitempty"` // fully qualified, e.g. "helloworld.Greeter"
+	Method      string `json:"method,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Encoding    string `json:"encoding,omitempty"` // grpc-encoding, the message compression
+	Framed      bool   `json:"framed"`             // a DATA frame carried a length-prefixed message
+	Compressed  bool   `json:"compressed,omitempty"`
+}
+
+// ParseGRPCPath splits a gRPC request path, "/service/method", into the
+// service and method it names
+func ParseGRPCPath(path string) (service, method string, ok bool) {
+	service, method, ok = strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !ok || !strings.HasPrefix(path, "/") || service == "" || method == "" ||
+		strings.ContainsAny(method, "/?") || strings.ContainsAny(service, "?") {
+		return "", "", false
+	}
+	return service, method, true
+}
+
+// grpcCall recognizes a gRPC call in an HTTP/2 message: by its content
+// type, or by a /service/method path whose body is framed as gRPC
+// messages are. data holds the frames of the message's direction.
+func grpcCall(info *ProtocolInfo, stream uint32, data []byte) *GRPCCall {
+	call := &GRPCCall{
+		ContentType: info.Headers["content-type"],
+		Encoding:    info.Headers["grpc-encoding"],
+	}
+	if body := http2StreamData(data, stream); len(body) >= grpcPrefixSize {
+		length := int(body[1])<<24 | int(body[2])<<16 | int(body[3])<<8 | int(body[4])
+		if body[0] <= 1 && length <= len(body)-grpcPrefixSize {
+			call.Framed = true
+			call.Compressed = body[0] == 1
+		}
+	}
+	service, method, pathOK := ParseGRPCPath(info.Path)
+	if pathOK {
+		call.Service, call.Method = service, method
+	}
+
+	if !strings.HasPrefix(call.ContentType, grpcContentType) &&
+		!(pathOK && call.Framed && info.Headers["te"] == "trailers") {
+		return nil
+	}
+	return call
+}
+
+// http2StreamData returns the payload of the first DATA frame of a stream
+// in data, the start of one direction of a connection
+func http2StreamData(data []byte, stream uint32) []byte {
+	d := &HTTP2Decoder{pending: bytes.TrimPrefix(data, []byte(HTTP2Preface))}
+	for {
+		frame, ok := d.nextFrame()
+		if !ok {
+			return nil
+		}
+		if frame.frameType != http2FrameData || frame.streamID != stream {
+			continue
+		}
+		r := &reader{data: frame.payload}
+		padding := 0
+		if frame.flags&http2FlagPadded != 0 {
+			padding = int(r.uint8())
+		}
+		return r.bytes(len(r.data) - padding)
+	}
+}
+
+// grpcFeatures summarizes a gRPC call as protocol features
+func grpcFeatures(call *GRPCCall, features map[string]interface{}) {
+	features["grpc"] = true
+	features["grpc_framed"] = call.Framed
+	if call.Service != "" {
+		features["grpc_service"] = call.Service
+		features["grpc_method"] = call.Method
+	}
+}
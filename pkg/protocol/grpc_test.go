@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// grpcMessage frames a message as gRPC length-prefixed messages are
+func grpcMessage(compressed bool, message []byte) []byte {
+	flag := byte(0)
+	if compressed {
+		flag = 1
+	}
+	n := len(message)
+	return append([]byte{flag, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}, message...)
+}
+
+func TestParseGRPCPath(t *testing.T) {
+	service, method, ok := ParseGRPCPath("/helloworld.Greeter/SayHello")
+	require.True(t, ok)
+	assert.Equal(t, "helloworld.Greeter", service)
+	assert.Equal(t, "SayHello", method)
+
+	for _, path := range []string{"/", "/index.html", "/a/b/c", "helloworld.Greeter/SayHello", "/svc/m?x=1", ""} {
+		_, _, ok := ParseGRPCPath(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestParseGRPC(t *testing.T) {
+	client := newHTTP2Connection(true)
+	client.headers(t, 1, false,
+		":method", "POST", ":scheme", "http", ":authority", "api.internal:50051",
+		":path", "/inventory.v1.Stock/Reserve", "content-type", "application/grpc+proto",
+		"te", "trailers", "grpc-encoding", "gzip", "user-agent", "grpc-go/1.60.0")
+	require.NoError(t, client.framer.WriteData(1, true, grpcMessage(true, []byte("compressed"))))
+
+	info, err := NewParser().ParsePacket(client.buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/2", info.Protocol)
+	require.NotNil(t, info.GRPC)
+	assert.Equal(t, &GRPCCall{
+		Service:     "inventory.v1.Stock",
+		Method:      "Reserve",
+		ContentType: "application/grpc+proto",
+		Encoding:    "gzip",
+		Framed:      true,
+		Compressed:  true,
+	}, info.GRPC)
+	assert.Equal(t, true, info.Features["grpc"])
+	assert.Equal(t, "inventory.v1.Stock", info.Features["grpc_service"])
+	assert.Equal(t, "Reserve", info.Features["grpc_method"])
+
+	// Responses reveal gRPC by their content type alone
+	server := newHTTP2Connection(false)
+	server.headers(t, 1, false, ":status", "200", "content-type", "application/grpc")
+	info, err = NewParser().ParsePacket(server.buf.Bytes())
+	require.NoError(t, err)
+	require.NotNil(t, info.GRPC)
+	assert.Empty(t, info.GRPC.Service)
+	assert.False(t, info.GRPC.Framed)
+}
+
+func TestParseGRPCWithoutContentType(t *testing.T) {
+	// A gRPC path with framed messages and trailers is taken for gRPC
+	client := newHTTP2Connection(true)
+	client.headers(t, 1, false,
+		":method", "POST", ":scheme", "https", ":authority", "example.com",
+		":path", "/svc.Bots/Register", "te", "trailers")
+	require.NoError(t, client.framer.WriteData(1, true, grpcMessage(false, []byte{0x0a, 0x02, 'h', 'i'})))
+	info, err := NewParser().ParsePacket(client.buf.Bytes())
+	require.NoError(t, err)
+	require.NotNil(t, info.GRPC)
+	assert.Equal(t, "svc.Bots", info.GRPC.Service)
+
+	// Plain HTTP/2 with a two-segment path is not
+	client = newHTTP2Connection(true)
+	client.headers(t, 1, false,
+		":method", "POST", ":scheme", "https", ":authority", "example.com",
+		":path", "/users/login", "content-type", "application/json")
+	require.NoError(t, client.framer.WriteData(1, true, []byte(`{"user":"a"}`)))
+	info, err = NewParser().ParsePacket(client.buf.Bytes())
+	require.NoError(t, err)
+	assert.Nil(t, info.GRPC)
+	assert.Nil(t, info.Features["grpc"])
+}
+
+func FuzzParseGRPCPath(f *testing.F) {
+	f.Add("/helloworld.Greeter/SayHello")
+	f.Add("/a/b/c")
+
+	f.Fuzz(func(t *testing.T, path string) {
+		service, method, ok := ParseGRPCPath(path)
+		if ok && "/"+service+"/"+method != path {
+			t.Fatalf("%q split into %q and %q", path, service, method)
+		}
+	})
+}
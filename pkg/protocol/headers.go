@@ -0,0 +1,18 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// HeaderFingerprint hashes the names of a message's header fields in the
+// order and case they were sent: browsers and HTTP libraries each write
+// their headers in an order of their own, which the header map loses.
+// HTTP/2 and HTTP/3 names are lower case, with the pseudo-header fields,
+// whose order differs between clients too, in front. The fingerprint is
+// the first 12 hex digits of the SHA-256 of the comma-joined names.
+func HeaderFingerprint(names []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(names, ",")))
+	return hex.EncodeToString(sum[:6])
+}
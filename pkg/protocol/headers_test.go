@@ -0,0 +1,54 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderOrder(t *testing.T) {
+	parser := NewParser()
+	browser, err := parser.ParsePacket([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nUser-Agent: Mozilla/5.0\r\n" +
+		"Accept: text/html\r\nAccept-Language: en-US\r\nAccept-Encoding: gzip\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Host", "User-Agent", "Accept", "Accept-Language", "Accept-Encoding"}, browser.HeaderOrder)
+	assert.Len(t, browser.HeaderFingerprint, 12)
+	assert.Equal(t, browser.HeaderFingerprint, browser.Features["header_fingerprint"])
+
+	// The same headers in another order or case fingerprint differently
+	reordered, err := parser.ParsePacket([]byte("GET / HTTP/1.1\r\nUser-Agent: Mozilla/5.0\r\nHost: example.com\r\n" +
+		"Accept: text/html\r\nAccept-Language: en-US\r\nAccept-Encoding: gzip\r\n\r\n"))
+	require.NoError(t, err)
+	assert.NotEqual(t, browser.HeaderFingerprint, reordered.HeaderFingerprint)
+	lower, err := parser.ParsePacket([]byte("GET / HTTP/1.1\r\nhost: example.com\r\nuser-agent: Mozilla/5.0\r\n" +
+		"accept: text/html\r\naccept-language: en-US\r\naccept-encoding: gzip\r\n\r\n"))
+	require.NoError(t, err)
+	assert.NotEqual(t, browser.HeaderFingerprint, lower.HeaderFingerprint)
+
+	again, err := parser.ParsePacket([]byte("GET /other HTTP/1.1\r\nHost: example.org\r\nUser-Agent: curl/8.0\r\n" +
+		"Accept: */*\r\nAccept-Language: de\r\nAccept-Encoding: br\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, browser.HeaderFingerprint, again.HeaderFingerprint)
+}
+
+func TestHTTP2HeaderOrder(t *testing.T) {
+	// Clients put the pseudo-header fields in an order of their own
+	chrome := newHTTP2Connection(true)
+	chrome.headers(t, 1, true, ":method", "GET", ":authority", "example.com", ":scheme", "https", ":path", "/")
+	firefox := newHTTP2Connection(true)
+	firefox.headers(t, 1, true, ":method", "GET", ":path", "/", ":authority", "example.com", ":scheme", "https")
+
+	parser := NewParser()
+	first, err := parser.ParsePacket(chrome.buf.Bytes())
+	require.NoError(t, err)
+	second, err := parser.ParsePacket(firefox.buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, []string{":method", ":authority", ":scheme", ":path"}, first.HeaderOrder)
+	assert.NotEqual(t, first.HeaderFingerprint, second.HeaderFingerprint)
+}
+
+func TestHeaderFingerprint(t *testing.T) {
+	assert.Equal(t, HeaderFingerprint([]string{"Host", "Accept"}), HeaderFingerprint([]string{"Host", "Accept"}))
+	assert.NotEqual(t, HeaderFingerprint([]string{"Host", "Accept"}), HeaderFingerprint([]string{"Accept", "Host"}))
+}
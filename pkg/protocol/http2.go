@@ -0,0 +1,237 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// HTTP2Preface is the connection preface HTTP/2 clients start with
+const HTTP2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// HTTP/2 frame types and flags read by the parser
+const (
+	http2FrameHeaderSize = 9
+
+	http2FrameData         = 0x0
+	http2FrameHeaders      = 0x1
+	http2FrameSettings     = 0x4
+	http2FrameContinuation = 0x9
+
+	http2FlagEndHeaders = 0x4
+	http2FlagPadded     = 0x8
+	http2FlagPriority   = 0x20
+)
+
+// http2MaxTableSize bounds the HPACK dynamic table an endpoint may ask
+// for. The limit the peer advertised in its SETTINGS is not seen by a
+// decoder of one direction, so a passive observer accepts any sensible
+// size.
+const http2MaxTableSize = 64 * 1024
+
+// http2MaxHeaderBlock bounds the header block a decoder buffers across
+// CONTINUATION frames, so a peer that never ends one cannot grow it
+// without limit
+const http2MaxHeaderBlock = 256 * 1024
+
+// http2Frame is the header and payload of an HTTP/2 frame
+type http2Frame struct {
+	frameType uint8
+	flags     uint8
+	streamID  uint32
+	payload   []byte
+}
+
+// HTTP2HeaderBlock is a decoded header block and the stream it opened or
+// answered
+type HTTP2HeaderBlock struct {
+	StreamID uint32
+	Fields   []hpack.HeaderField
+}
+
+// HTTP2Decoder decodes the header blocks of one direction of an HTTP/2
+// connection. HPACK compresses each block against a dynamic table built
+// from the blocks before it, so blocks only decode in order, with the
+// state the earlier ones left: a decoder serves one direction of one
+// connection for its lifetime.
+type HTTP2Decoder struct {
+	hpack    *hpack.Decoder
+	pending  []byte // unread data, ending inside a frame
+	block    []byte // header block fragments awaiting their CONTINUATION
+	stream   uint32 // stream of block
+	started  bool   // the client preface, if any, was skipped
+	maxBlock int    // longest header block buffered
+}
+
+// NewHTTP2Decoder creates a decoder for one direction of a connection
+func NewHTTP2Decoder() *HTTP2Decoder {
+	return newHTTP2Decoder(http2MaxHeaderBlock)
+}
+
+// newHTTP2Decoder creates a decoder buffering header blocks of at most
+// maxBlock bytes
+func newHTTP2Decoder(maxBlock int) *HTTP2Decoder {
+	decoder := hpack.NewDecoder(4096, nil)
+	decoder.SetAllowedMaxDynamicTableSize(http2MaxTableSize)
+	return &HTTP2Decoder{hpack: decoder, maxBlock: maxBlock}
+}
+
+// Decode reads the frames in data, following those of earlier calls, and
+// returns the header blocks they complete. Frames split across calls are
+// completed by the next call.
+func (d *HTTP2Decoder) Decode(data []byte) ([]HTTP2HeaderBlock, error) {
+	d.pending = append(d.pending, data...)
+	if !d.started {
+		if len(d.pending) < len(HTTP2Preface) && bytes.HasPrefix([]byte(HTTP2Preface), d.pending) {
+			return nil, nil
+		}
+		d.pending = bytes.TrimPrefix(d.pending, []byte(HTTP2Preface))
+		d.started = true
+	}
+
+	var blocks []HTTP2HeaderBlock
+	for {
+		frame, ok := d.nextFrame()
+		if !ok {
+			return blocks, nil
+		}
+		block, err := d.frame(frame)
+		if err != nil {
+			return blocks, err
+		}
+		if block != nil {
+			blocks = append(blocks, *block)
+		}
+	}
+}
+
+// nextFrame takes the next complete frame from the pending data
+func (d *HTTP2Decoder) nextFrame() (http2Frame, bool) {
+	r := &reader{data: d.pending}
+	length := r.uint24()
+	frame := http2Frame{frameType: r.uint8(), flags: r.uint8()}
+	frame.streamID = uint32(r.uint16())<<16 | uint32(r.uint16())
+	frame.streamID &^= 1 << 31
+	frame.payload = r.bytes(length)
+	if r.err != nil {
+		return frame, false
+	}
+	d.pending = r.data
+	return frame, true
+}
+
+// frame handles a frame, returning the header block it completes
+func (d *HTTP2Decoder) frame(frame http2Frame) (*HTTP2HeaderBlock, error) {
+	switch frame.frameType {
+	case http2FrameHeaders:
+		if frame.streamID == 0 {
+			return nil, fmt.Errorf("http2 headers frame on stream 0: %w", ErrMalformed)
+		}
+		r := &reader{data: frame.payload}
+		padding := 0
+		if frame.flags&http2FlagPadded != 0 {
+			padding = int(r.uint8())
+		}
+		if frame.flags&http2FlagPriority != 0 {
+			r.bytes(5) // stream dependency and weight
+		}
+		fragment := r.bytes(len(r.data) - padding)
+		if r.err != nil {
+			return nil, fmt.Errorf("http2 headers frame on stream %d: %w", frame.streamID, ErrTruncated)
+		}
+		d.block = append(d.block[:0], fragment...)
+		d.stream = frame.streamID
+	case http2FrameContinuation:
+		if frame.streamID != d.stream || d.block == nil {
+			return nil, fmt.Errorf("http2 continuation frame on stream %d without headers: %w", frame.streamID, ErrMalformed)
+		}
+		d.block = append(d.block, frame.payload...)
+	default:
+		return nil, nil
+	}
+	if len(d.block) > d.maxBlock {
+		d.block = nil
+		return nil, fmt.Errorf("http2 header block on stream %d exceeds %d bytes: %w", d.stream, d.maxBlock, ErrMalformed)
+	}
+	if frame.flags&http2FlagEndHeaders == 0 {
+		return nil, nil
+	}
+
+	fields, err := d.hpack.DecodeFull(d.block)
+	d.block = nil
+	if err != nil {
+		return nil, fmt.Errorf("http2 header block on stream %d: %w: %w", d.stream, ErrMalformed, err)
+	}
+	return &HTTP2HeaderBlock{StreamID: d.stream, Fields: fields}, nil
+}
+
+// HTTP2HeadersComplete reports whether data, the start of one direction of
+// an HTTP/2 connection, holds a complete header block
+func HTTP2HeadersComplete(data []byte) bool {
+	d := &HTTP2Decoder{}
+	if !bytes.HasPrefix(data, []byte(HTTP2Preface)) && bytes.HasPrefix([]byte(HTTP2Preface), data) {
+		return false
+	}
+	d.pending = bytes.TrimPrefix(data, []byte(HTTP2Preface))
+	for {
+		frame, ok := d.nextFrame()
+		if !ok {
+			return false
+		}
+		if (frame.frameType == http2FrameHeaders || frame.frameType == http2FrameContinuation) &&
+			frame.flags&http2FlagEndHeaders != 0 {
+			return true
+		}
+	}
+}
+
+// IsHTTP2 reports whether data starts like one direction of an HTTP/2
+// connection: with the client preface, or part of it, or with the SETTINGS
+// frame servers open with
+func IsHTTP2(data []byte) bool {
+	if len(data) < len(HTTP2Preface) {
+		if bytes.HasPrefix([]byte(HTTP2Preface), data) {
+			return len(data) > 0
+		}
+	} else if bytes.HasPrefix(data, []byte(HTTP2Preface)) {
+		return true
+	}
+
+	// A SETTINGS frame on stream 0 holding whole 6-byte settings
+	if len(data) < http2FrameHeaderSize {
+		return false
+	}
+	length := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+	return data[3] == http2FrameSettings && data[4]&^1 == 0 && length%6 == 0 &&
+		length <= 16384 && bytes.Equal(data[5:9], []byte{0, 0, 0, 0})
+}
+
+// http2Message fills info from a header block: the request of a client or
+// the response of a server
+func http2Message(info *ProtocolInfo, block HTTP2HeaderBlock) {
+	info.Headers = make(map[string]string)
+	for _, field := range block.Fields {
+		info.HeaderOrder = append(info.HeaderOrder, field.Name)
+		switch field.Name {
+		case ":method":
+			info.Method = field.Value
+		case ":path":
+			info.Path = field.Value
+		case ":status":
+			info.StatusCode, _ = strconv.Atoi(field.Value)
+		case ":authority":
+			if _, ok := info.Headers["host"]; !ok {
+				info.Headers["host"] = field.Value
+			}
+		case "user-agent":
+			info.UserAgent = field.Value
+			info.Headers[field.Name] = field.Value
+		default:
+			if !field.IsPseudo() {
+				info.Headers[field.Name] = field.Value
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// http2Connection writes HTTP/2 frames with one HPACK encoder, as an
+// endpoint does
+type http2Connection struct {
+	buf     bytes.Buffer
+	framer  *http2.Framer
+	encoder *hpack.Encoder
+	block   bytes.Buffer
+}
+
+func newHTTP2Connection(client bool) *http2Connection {
+	c := &http2Connection{}
+	if client {
+		c.buf.WriteString(HTTP2Preface)
+	}
+	c.framer = http2.NewFramer(&c.buf, nil)
+	c.encoder = hpack.NewEncoder(&c.block)
+	c.framer.WriteSettings(http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 100})
+	return c
+}
+
+// headers encodes fields and writes them as a HEADERS frame, followed by
+// a CONTINUATION frame when split is set
+func (c *http2Connection) headers(t testing.TB, stream uint32, split bool, fields ...string) {
+	c.block.Reset()
+	for i := 0; i < len(fields); i += 2 {
+		require.NoError(t, c.encoder.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]}))
+	}
+	block := c.block.Bytes()
+	if !split {
+		require.NoError(t, c.framer.WriteHeaders(http2.HeadersFrameParam{
+			StreamID: stream, BlockFragment: block, EndHeaders: true, PadLength: 3,
+			Priority: http2.PriorityParam{StreamDep: 0, Weight: 15},
+		}))
+		return
+	}
+	require.NoError(t, c.framer.WriteHeaders(http2.HeadersFrameParam{StreamID: stream, BlockFragment: block[:4]}))
+	require.NoError(t, c.framer.WriteContinuation(stream, true, block[4:]))
+}
+
+func TestParseHTTP2(t *testing.T) {
+	client := newHTTP2Connection(true)
+	client.headers(t, 1, true,
+		":method", "GET", ":scheme", "https", ":authority", "example.com", ":path", "/index.html",
+		"user-agent", "python-httpx/0.27", "accept", "*/*")
+	first := client.buf.Len()
+	client.headers(t, 3, false,
+		":method", "POST", ":scheme", "https", ":authority", "example.com", ":path", "/api",
+		"user-agent", "python-httpx/0.27")
+	data := client.buf.Bytes()
+
+	info, err := NewParser().ParsePacket(data)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/2", info.Protocol)
+	assert.Equal(t, "GET", info.Method)
+	assert.Equal(t, "/index.html", info.Path)
+	assert.Equal(t, "python-httpx/0.27", info.UserAgent)
+	assert.Equal(t, "example.com", info.Headers["host"])
+	assert.Equal(t, "*/*", info.Headers["accept"])
+	assert.Equal(t, 2, info.Features["header_blocks"])
+	assert.Equal(t, true, info.Features["is_get"])
+	assert.Equal(t, uint8(http2FrameSettings), info.Features["frame_type"])
+
+	// The second request is encoded against the dynamic table the first
+	// one filled, so only a decoder that saw the first can read it
+	decoder := NewHTTP2Decoder()
+	var blocks []HTTP2HeaderBlock
+	for i := 0; i < len(data); i += 7 {
+		decoded, err := decoder.Decode(data[i:min(i+7, len(data))])
+		require.NoError(t, err)
+		blocks = append(blocks, decoded...)
+	}
+	require.Len(t, blocks, 2)
+	assert.Equal(t, uint32(3), blocks[1].StreamID)
+	assert.Contains(t, blocks[1].Fields, hpack.HeaderField{Name: "user-agent", Value: "python-httpx/0.27"})
+
+	_, err = NewHTTP2Decoder().Decode(data[first:])
+	assert.Error(t, err)
+
+	// Servers start with SETTINGS and answer with a status
+	server := newHTTP2Connection(false)
+	server.headers(t, 1, false, ":status", "404", "server", "nginx")
+	info, err = NewParser().ParsePacket(server.buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/2", info.Protocol)
+	assert.Equal(t, 404, info.StatusCode)
+	assert.Equal(t, "nginx", info.Headers["server"])
+}
+
+func TestHTTP2HeadersComplete(t *testing.T) {
+	client := newHTTP2Connection(true)
+	client.headers(t, 1, true, ":method", "GET", ":path", "/", "user-agent", "curl/8.0")
+	data := client.buf.Bytes()
+
+	assert.True(t, IsHTTP2(data[:10]))
+	assert.False(t, HTTP2HeadersComplete(data[:10]))
+	assert.False(t, HTTP2HeadersComplete(data[:len(HTTP2Preface)+9]))
+	assert.False(t, HTTP2HeadersComplete(data[:len(data)-1]))
+	assert.True(t, HTTP2HeadersComplete(data))
+
+	assert.False(t, IsHTTP2([]byte("GET / HTTP/1.1\r\n")))
+	assert.False(t, IsHTTP2([]byte{0, 0, 5, 4, 0, 0, 0, 0, 0}))
+}
+
+func TestHTTP2HeaderBlockLimit(t *testing.T) {
+	client := newHTTP2Connection(true)
+	require.NoError(t, client.framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: []byte{0x82}}))
+	decoder := NewHTTP2Decoder()
+	_, err := decoder.Decode(client.buf.Bytes())
+	require.NoError(t, err)
+
+	// A header block that never ends is dropped once it outgrows the limit
+	fragment := make([]byte, http2DefaultMaxFrameSize)
+	for i := 0; err == nil; i++ {
+		require.Less(t, i, http2MaxHeaderBlock/len(fragment)+1)
+		client.buf.Reset()
+		require.NoError(t, client.framer.WriteContinuation(1, false, fragment))
+		_, err = decoder.Decode(client.buf.Bytes())
+	}
+	assert.ErrorIs(t, err, ErrMalformed)
+	assert.Nil(t, decoder.block)
+}
+
+func FuzzHTTP2Decoder(f *testing.F) {
+	client := newHTTP2Connection(true)
+	client.headers(f, 1, true, ":method", "GET", ":path", "/", "user-agent", "curl/8.0")
+	f.Add(client.buf.Bytes(), 7)
+
+	f.Fuzz(func(t *testing.T, data []byte, split int) {
+		// Frames split across calls decode as they would in one
+		split = max(0, min(split, len(data)))
+		first, err := NewHTTP2Decoder().Decode(data)
+		assertTypedError(t, err)
+
+		decoder := NewHTTP2Decoder()
+		blocks, splitErr := decoder.Decode(data[:split])
+		if splitErr == nil {
+			var more []HTTP2HeaderBlock
+			more, splitErr = decoder.Decode(data[split:])
+			blocks = append(blocks, more...)
+		}
+		if (err == nil) != (splitErr == nil) || len(blocks) != len(first) {
+			t.Fatalf("split at %d: %d blocks and error %v, whole: %d blocks and error %v",
+				split, len(blocks), splitErr, len(first), err)
+		}
+		HTTP2HeadersComplete(data)
+	})
+}
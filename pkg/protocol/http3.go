@@ -0,0 +1,319 @@
+package protocol
+
+import (
+	"fmt"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// HTTP/3 unidirectional stream types (RFC 9114, RFC 9204)
+const (
+	http3StreamControl      = 0x00
+	http3StreamPush         = 0x01
+	http3StreamQPACKEncoder = 0x02
+	http3StreamQPACKDecoder = 0x03
+)
+
+// HTTP/3 frame types and settings read by the parser
+const (
+	http3FrameData     = 0x00
+	http3FrameHeaders  = 0x01
+	http3FrameSettings = 0x04
+
+	http3SettingQPACKMaxTableCapacity = 0x01
+	http3SettingMaxFieldSectionSize   = 0x06
+	http3SettingQPACKBlockedStreams   = 0x07
+)
+
+// errQPACKDynamic is returned for field sections that refer to the QPACK
+// dynamic table. Its entries arrive on the encoder stream, which is not
+// followed, so only sections encoded with the static table and literals
+// decode.
+var errQPACKDynamic = fmt.Errorf("qpack field section refers to the dynamic table: %w", ErrUnsupported)
+
+// http3StreamTypeName names a unidirectional stream type
+func http3StreamTypeName(streamType uint64) string {
+	switch streamType {
+	case http3StreamControl:
+		return "control"
+	case http3StreamPush:
+		return "push"
+	case http3StreamQPACKEncoder:
+		return "qpack_encoder"
+	case http3StreamQPACKDecoder:
+		return "qpack_decoder"
+	}
+	// Reserved types exercise the requirement to ignore unknown streams
+	if streamType >= 0x21 && (streamType-0x21)%0x1f == 0 {
+		return "reserved"
+	}
+	return "unknown"
+}
+
+// ParseHTTP3Stream parses the start of an HTTP/3 stream, as carried in the
+// QUIC STREAM frames of a decrypted connection. Unidirectional streams
+// start with their type; bidirectional streams carry a request and its
+// response, whose first field section fills in the method, path, status
+// and headers like an HTTP/1.1 message would.
+func (p *Parser) ParseHTTP3Stream(data []byte, unidirectional bool) (*ProtocolInfo, error) {
+	info := &ProtocolInfo{
+		Protocol: "HTTP/3",
+		Version:  "HTTP/3",
+		RawData:  data,
+		Features: make(map[string]interface{}),
+	}
+
+	r := &reader{data: data}
+	streamType := "request"
+	if unidirectional {
+		streamType = http3StreamTypeName(r.varint())
+	}
+	info.Features["stream_type"] = streamType
+	if r.err != nil {
+		return info, p.reject("HTTP/3", fmt.Errorf("http3 stream type: %w", r.err))
+	}
+	if streamType != "request" && streamType != "control" {
+		// Push streams need the push ID mapping, QPACK streams carry
+		// instructions rather than frames
+		return info, nil
+	}
+
+	var malformed error
+	frames := 0
+	for !r.empty() {
+		frameType := r.varint()
+		payload := r.sub(int(r.varint()))
+		if r.err != nil {
+			// The stream continues past the data at hand
+			malformed = fmt.Errorf("http3 frame: %w", r.err)
+			break
+		}
+		frames++
+
+		switch {
+		case frameType == http3FrameSettings && streamType == "control":
+			http3Settings(payload, info.Features)
+		case frameType == http3FrameHeaders && streamType == "request" && info.Headers == nil:
+			fields, err := decodeQPACK(payload.data)
+			if err != nil {
+				info.Features["qpack_error"] = err.Error()
+				if malformed == nil {
+					malformed = err
+				}
+				continue
+			}
+			http2Message(info, HTTP2HeaderBlock{Fields: fields})
+			for name, value := range p.extractHTTP11Features(info) {
+				info.Features[name] = value
+			}
+		case frameType == http3FrameData && streamType == "request":
+			info.Features["has_body"] = true
+		}
+	}
+	info.Features["frame_count"] = frames
+
+	return info, p.reject("HTTP/3", p.partial(malformed))
+}
+
+// http3Settings records the settings of a SETTINGS frame as features
+func http3Settings(r *reader, features map[string]interface{}) {
+	count := 0
+	for !r.empty() {
+		id, value := r.varint(), r.varint()
+		if r.err != nil {
+			return
+		}
+		count++
+		switch id {
+		case http3SettingQPACKMaxTableCapacity:
+			features["qpack_max_table_capacity"] = value
+		case http3SettingMaxFieldSectionSize:
+			features["max_field_section_size"] = value
+		case http3SettingQPACKBlockedStreams:
+			features["qpack_blocked_streams"] = value
+		}
+	}
+	features["settings_count"] = count
+}
+
+// decodeQPACK decodes a QPACK field section encoded without the dynamic
+// table
+func decodeQPACK(section []byte) ([]hpack.HeaderField, error) {
+	r := &reader{data: section}
+	if b := r.uint8(); r.prefixInt(b, 8) != 0 {
+		return nil, errQPACKDynamic
+	}
+	b := r.uint8()
+	r.prefixInt(b, 7) // delta base, meaningless without dynamic entries
+
+	var fields []hpack.HeaderField
+	for !r.empty() {
+		b := r.uint8()
+		var field hpack.HeaderField
+		switch {
+		case b&0x80 != 0: // indexed field line
+			if b&0x40 == 0 {
+				return nil, errQPACKDynamic
+			}
+			entry, err := qpackStatic(r.prefixInt(b, 6))
+			if err != nil {
+				return nil, err
+			}
+			field = entry
+		case b&0x40 != 0: // literal field line with name reference
+			if b&0x10 == 0 {
+				return nil, errQPACKDynamic
+			}
+			entry, err := qpackStatic(r.prefixInt(b, 4))
+			if err != nil {
+				return nil, err
+			}
+			field.Name = entry.Name
+			field.Value = r.qpackString(r.uint8(), 7)
+		case b&0x20 != 0: // literal field line with literal name
+			field.Name = r.qpackString(b, 3)
+			field.Value = r.qpackString(r.uint8(), 7)
+		default: // post-base references
+			return nil, errQPACKDynamic
+		}
+		if r.err != nil {
+			return nil, fmt.Errorf("qpack field section: %w", r.err)
+		}
+		fields = append(fields, field)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("qpack field section: %w", r.err)
+	}
+	return fields, nil
+}
+
+// qpackString reads a string literal whose length has an n-bit prefix in
+// first, Huffman-coded when the bit above the prefix is set
+func (r *reader) qpackString(first byte, n uint) string {
+	huffman := first&(1<<n) != 0
+	s := r.bytes(int(r.prefixInt(first, n)))
+	if r.err != nil {
+		return ""
+	}
+	if !huffman {
+		return string(s)
+	}
+	decoded, err := hpack.HuffmanDecodeToString(s)
+	if err != nil {
+		r.fail()
+		return ""
+	}
+	return decoded
+}
+
+// qpackStatic returns an entry of the QPACK static table
+func qpackStatic(index uint64) (hpack.HeaderField, error) {
+	if index >= uint64(len(qpackStaticTable)) {
+		return hpack.HeaderField{}, fmt.Errorf("qpack static table has no entry %d: %w", index, ErrMalformed)
+	}
+	entry := qpackStaticTable[index]
+	return hpack.HeaderField{Name: entry[0], Value: entry[1]}, nil
+}
+
+// qpackStaticTable is the QPACK static table (RFC 9204, Appendix A)
+var qpackStaticTable = [...][2]string{
+	{":authority", ""},
+	{":path", "/"},
+	{"age", "0"},
+	{"content-disposition", ""},
+	{"content-length", "0"},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"referer", ""},
+	{"set-cookie", ""},
+	{":method", "CONNECT"},
+	{":method", "DELETE"},
+	{":method", "GET"},
+	{":method", "HEAD"},
+	{":method", "OPTIONS"},
+	{":method", "POST"},
+	{":method", "PUT"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "103"},
+	{":status", "200"},
+	{":status", "304"},
+	{":status", "404"},
+	{":status", "503"},
+	{"accept", "*/*"},
+	{"accept", "application/dns-message"},
+	{"accept-encoding", "gzip, deflate, br"},
+	{"accept-ranges", "bytes"},
+	{"access-control-allow-headers", "cache-control"},
+	{"access-control-allow-headers", "content-type"},
+	{"access-control-allow-origin", "*"},
+	{"cache-control", "max-age=0"},
+	{"cache-control", "max-age=2592000"},
+	{"cache-control", "max-age=604800"},
+	{"cache-control", "no-cache"},
+	{"cache-control", "no-store"},
+	{"cache-control", "public, max-age=31536000"},
+	{"content-encoding", "br"},
+	{"content-encoding", "gzip"},
+	{"content-type", "application/dns-message"},
+	{"content-type", "application/javascript"},
+	{"content-type", "application/json"},
+	{"content-type", "application/x-www-form-urlencoded"},
+	{"content-type", "image/gif"},
+	{"content-type", "image/jpeg"},
+	{"content-type", "image/png"},
+	{"content-type", "text/css"},
+	{"content-type", "text/html; charset=utf-8"},
+	{"content-type", "text/plain"},
+	{"content-type", "text/plain;charset=utf-8"},
+	{"range", "bytes=0-"},
+	{"strict-transport-security", "max-age=31536000"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains; preload"},
+	{"vary", "accept-encoding"},
+	{"vary", "origin"},
+	{"x-content-type-options", "nosniff"},
+	{"x-xss-protection", "1; mode=block"},
+	{":status", "100"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "302"},
+	{":status", "400"},
+	{":status", "403"},
+	{":status", "421"},
+	{":status", "425"},
+	{":status", "500"},
+	{"accept-language", ""},
+	{"access-control-allow-credentials", "FALSE"},
+	{"access-control-allow-credentials", "TRUE"},
+	{"access-control-allow-headers", "*"},
+	{"access-control-allow-methods", "get"},
+	{"access-control-allow-methods", "get, post, options"},
+	{"access-control-allow-methods", "options"},
+	{"access-control-expose-headers", "content-length"},
+	{"access-control-request-headers", "content-type"},
+	{"access-control-request-method", "get"},
+	{"access-control-request-method", "post"},
+	{"alt-svc", "clear"},
+	{"authorization", ""},
+	{"content-security-policy", "script-src 'none'; object-src 'none'; base-uri 'none'"},
+	{"early-data", "1"},
+	{"expect-ct", ""},
+	{"forwarded", ""},
+	{"if-range", ""},
+	{"origin", ""},
+	{"purpose", "prefetch"},
+	{"server", ""},
+	{"timing-allow-origin", "*"},
+	{"upgrade-insecure-requests", "1"},
+	{"user-agent", ""},
+	{"x-forwarded-for", ""},
+	{"x-frame-options", "deny"},
+	{"x-frame-options", "sameorigin"},
+}
@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2/hpack"
+)
+
+// http3Frame returns an HTTP/3 frame with one-byte type and length
+func http3Frame(frameType byte, payload []byte) []byte {
+	return append([]byte{frameType, byte(len(payload))}, payload...)
+}
+
+func TestParseHTTP3Stream(t *testing.T) {
+	section := []byte{0x00, 0x00}         // no dynamic table references
+	section = append(section, 0xc0|17)    // :method GET
+	section = append(section, 0x50|1, 11) // :path with a literal value
+	section = append(section, "/index.html"...)
+	section = append(section, 0x5f, 95-15) // user-agent, name index past the prefix
+	ua := hpack.AppendHuffmanString(nil, "Go-http-client/3")
+	section = append(section, 0x80|byte(len(ua)))
+	section = append(section, ua...)
+	section = append(section, 0x20|5, 'x', '-', 'b', 'o', 't', 1, '1') // literal name
+	section = append(section, 0xc0|23)                                 // :scheme https
+	stream := append(http3Frame(http3FrameHeaders, section), http3Frame(http3FrameData, []byte("{}"))...)
+
+	parser := NewParser()
+	info, err := parser.ParseHTTP3Stream(stream, false)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/3", info.Protocol)
+	assert.Nil(t, info.Features["qpack_error"])
+	assert.Equal(t, "GET", info.Method)
+	assert.Equal(t, "/index.html", info.Path)
+	assert.Equal(t, "Go-http-client/3", info.UserAgent)
+	assert.Equal(t, "1", info.Headers["x-bot"])
+	assert.Equal(t, "request", info.Features["stream_type"])
+	assert.Equal(t, 2, info.Features["frame_count"])
+	assert.Equal(t, true, info.Features["has_body"])
+
+	// Responses carry their status from the static table
+	info, err = parser.ParseHTTP3Stream(http3Frame(http3FrameHeaders, []byte{0, 0, 0xc0 | 27}), false)
+	require.NoError(t, err)
+	assert.Equal(t, 404, info.StatusCode)
+
+	// Control streams open with SETTINGS
+	control := append([]byte{http3StreamControl}, http3Frame(http3FrameSettings, []byte{
+		http3SettingQPACKMaxTableCapacity, 0x40, 0x64, // 100 as a two-byte varint
+		http3SettingQPACKBlockedStreams, 16,
+		0x21, 0, // reserved setting
+	})...)
+	info, err = parser.ParseHTTP3Stream(control, true)
+	require.NoError(t, err)
+	assert.Equal(t, "control", info.Features["stream_type"])
+	assert.Equal(t, uint64(100), info.Features["qpack_max_table_capacity"])
+	assert.Equal(t, uint64(16), info.Features["qpack_blocked_streams"])
+	assert.Equal(t, 3, info.Features["settings_count"])
+
+	info, err = parser.ParseHTTP3Stream([]byte{0x40, 0x21}, true)
+	require.NoError(t, err)
+	assert.Equal(t, "reserved", info.Features["stream_type"])
+
+	// Sections using the dynamic table are reported rather than guessed
+	info, err = parser.ParseHTTP3Stream(http3Frame(http3FrameHeaders, []byte{0x02, 0x00, 0x80}), false)
+	require.NoError(t, err)
+	assert.Equal(t, errQPACKDynamic.Error(), info.Features["qpack_error"])
+	assert.Empty(t, info.Method)
+
+	_, err = decodeQPACK([]byte{0, 0, 0xc0 | 63, 50})
+	assert.Error(t, err)
+}
+
+func FuzzParseHTTP3Stream(f *testing.F) {
+	f.Add(http3Frame(http3FrameHeaders, []byte{0, 0, 0xc0 | 17, 0xc0 | 23}), false)
+	f.Add(append([]byte{0x00}, http3Frame(http3FrameSettings, []byte{0x01, 0x10, 0x07, 0x00})...), true)
+
+	f.Fuzz(func(t *testing.T, data []byte, unidirectional bool) {
+		_, err := NewParser().ParseHTTP3Stream(data, unidirectional)
+		assertTypedError(t, err)
+		_, err = NewStrictParser().ParseHTTP3Stream(data, unidirectional)
+		assertTypedError(t, err)
+	})
+}
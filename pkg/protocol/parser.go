@@ -3,16 +3,21 @@ package protocol
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
 // Parser represents a protocol parser
 type Parser struct {
 	supportedProtocols map[string]bool
+	strict             bool
 }
 
-// NewParser creates a new protocol parser
+// NewParser creates a new protocol parser. It takes what it can from
+// malformed messages: fields read before a bad length, a handshake without
+// the extension that failed to parse.
 func NewParser() *Parser {
 	return &Parser{
 		supportedProtocols: map[string]bool{
@@ -25,10 +30,40 @@ func NewParser() *Parser {
 	}
 }
 
+// NewStrictParser creates a parser that rejects messages not well-formed
+// throughout: every declared length and offset must fit the data, with
+// nothing left over, and every field hold a value its protocol allows.
+// Rejections are returned as a *ParseError wrapping ErrTruncated,
+// ErrTrailingData, ErrMalformed or ErrUnsupported.
+func NewStrictParser() *Parser {
+	p := NewParser()
+	p.strict = true
+	return p
+}
+
+// partial returns the error of a message parsed in part, which only a
+// strict parser reports
+func (p *Parser) partial(err error) error {
+	if !p.strict {
+		return nil
+	}
+	return err
+}
+
+// reject wraps the error a strict parser returns for a message
+func (p *Parser) reject(protocol string, err error) error {
+	if !p.strict || err == nil {
+		return err
+	}
+	return &ParseError{Protocol: protocol, Err: err}
+}
+
 // ParsePacket attempts to parse a packet and extract protocol information
 func (p *Parser) ParsePacket(data []byte) (*ProtocolInfo, error) {
-	if len(data) < 20 {
-		return nil, fmt.Errorf("packet too small to parse")
+	// Remote access handshakes open with shorter messages than anything
+	// else worth parsing
+	if len(data) < 20 && !IsTPKT(data) && !IsRFB(data) {
+		return nil, fmt.Errorf("packet too small to parse: %w", ErrTruncated)
 	}
 
 	info := &ProtocolInfo{
@@ -46,18 +81,23 @@ func (p *Parser) ParsePacket(data []byte) (*ProtocolInfo, error) {
 	// Parse based on protocol type
 	switch protocol {
 	case "HTTP/1.1":
-		return p.parseHTTP11(data, info)
+		info, err = p.parseHTTP11(data, info)
 	case "HTTP/2":
-		return p.parseHTTP2(data, info)
+		info, err = p.parseHTTP2(data, info)
 	case "HTTP/3":
-		return p.parseHTTP3(data, info)
+		info, err = p.parseHTTP3(data, info)
 	case "QUIC":
-		return p.parseQUIC(data, info)
+		info, err = p.parseQUIC(data, info)
 	case "TLS":
-		return p.parseTLS(data, info)
-	default:
-		return info, nil
+		info, err = p.parseTLS(data, info)
+	case "SSH":
+		info, err = p.parseSSH(data, info)
+	case "RDP":
+		info, err = p.parseRDP(data, info)
+	case "VNC":
+		info, err = p.parseVNC(data, info)
 	}
+	return info, p.reject(protocol, err)
 }
 
 // ProtocolInfo contains parsed protocol information
@@ -68,9 +108,39 @@ type ProtocolInfo struct {
 	Method     string                 `json:"method,omitempty"`
 	Path       string                 `json:"path,omitempty"`
 	StatusCode int                    `json:"status_code,omitempty"`
+	StreamID   uint32                 `json:"stream_id,omitempty"` // HTTP/2 stream of the message
 	UserAgent  string                 `json:"user_agent,omitempty"`
+	Agent      *UserAgent             `json:"agent,omitempty"` // client the user agent claims to be
 	RawData    []byte                 `json:"-"`
 	Features   map[string]interface{} `json:"features"`
+
+	// HeaderOrder holds the names of HTTP header fields in the order and
+	// case they were sent, and HeaderFingerprint their HeaderFingerprint
+	HeaderOrder       []string `json:"header_order,omitempty"`
+	HeaderFingerprint string   `json:"header_fingerprint,omitempty"`
+
+	// ClientHello, ServerHello and Certificate are set for TLS connections
+	// whose handshake messages were parsed
+	ClientHello *ClientHello `json:"client_hello,omitempty"`
+	ServerHello *ServerHello `json:"server_hello,omitempty"`
+	Certificate *Certificate `json:"certificate,omitempty"`
+
+	// QUIC is set for QUIC packets whose header was parsed
+	QUIC *QUICHeader `json:"quic,omitempty"`
+
+	// DNS is set for DNS messages
+	DNS *DNSMessage `json:"dns,omitempty"`
+
+	// SSH is set for SSH connections whose identification string was read
+	SSH *SSHHandshake `json:"ssh,omitempty"`
+
+	// GRPC is set for HTTP/2 messages recognized as gRPC
+	GRPC *GRPCCall `json:"grpc,omitempty"`
+
+	// RDP and VNC are set for the connection setup of remote access
+	// sessions
+	RDP *RDPNegotiation `json:"rdp,omitempty"`
+	VNC *RFBHandshake   `json:"vnc,omitempty"`
 }
 
 // identifyProtocol attempts to identify the protocol from packet data
@@ -87,20 +157,29 @@ func (p *Parser) identifyProtocol(data []byte) (string, error) {
 		return "HTTP/1.1", nil
 	}
 
-	// Check for HTTP/2 preface
-	if bytes.HasPrefix(data, []byte("PRI * HTTP/2.0")) {
-		return "HTTP/2", nil
+	// Check for an SSH identification string
+	if bytes.HasPrefix(data, []byte("SSH-")) {
+		return "SSH", nil
 	}
 
-	// Check for QUIC (simplified)
-	if len(data) >= 4 && (data[0]&0xC0) == 0x40 {
-		return "QUIC", nil
+	// Check for the remote access handshakes of RDP and VNC
+	if IsTPKT(data) {
+		return "RDP", nil
+	}
+	if IsRFB(data) {
+		return "VNC", nil
 	}
 
-	// Check for HTTP/3 (over QUIC)
-	if len(data) >= 8 && (data[0]&0xC0) == 0x40 {
-		// This is a simplified check - real HTTP/3 detection is more complex
-		return "HTTP/3", nil
+	// Check for the HTTP/2 client preface or server SETTINGS
+	if IsHTTP2(data) {
+		return "HTTP/2", nil
+	}
+
+	// Check for a QUIC long header. Short headers are too loosely
+	// structured to tell from other data without the connection's state;
+	// HTTP/3 is only seen decrypted, through ParseHTTP3Stream.
+	if header, err := ParseQUICHeader(data); err == nil && header.Long {
+		return "QUIC", nil
 	}
 
 	return "Unknown", nil
@@ -110,22 +189,20 @@ func (p *Parser) identifyProtocol(data []byte) (string, error) {
 func (p *Parser) parseHTTP11(data []byte, info *ProtocolInfo) (*ProtocolInfo, error) {
 	lines := strings.Split(string(data), "\r\n")
 	if len(lines) < 2 {
-		return info, fmt.Errorf("invalid HTTP/1.1 format")
+		return info, fmt.Errorf("invalid HTTP/1.1 format: %w", ErrMalformed)
 	}
 
 	// Parse first line
 	firstLine := lines[0]
 	parts := strings.Fields(firstLine)
 	if len(parts) < 2 {
-		return info, fmt.Errorf("invalid HTTP/1.1 first line")
+		return info, fmt.Errorf("invalid HTTP/1.1 first line: %w", ErrMalformed)
 	}
 
 	if strings.HasPrefix(firstLine, "HTTP/") {
 		// Response
-		if len(parts) >= 2 {
-			info.StatusCode = 200 // Simplified
-			info.Version = parts[0]
-		}
+		info.Version = parts[0]
+		info.StatusCode, _ = strconv.Atoi(parts[1])
 	} else {
 		// Request
 		info.Method = parts[0]
@@ -148,6 +225,7 @@ func (p *Parser) parseHTTP11(data []byte, info *ProtocolInfo) (*ProtocolInfo, er
 			key := strings.TrimSpace(line[:idx])
 			value := strings.TrimSpace(line[idx+1:])
 			info.Headers[key] = value
+			info.HeaderOrder = append(info.HeaderOrder, key)
 
 			if strings.EqualFold(key, "User-Agent") {
 				info.UserAgent = value
@@ -158,57 +236,191 @@ func (p *Parser) parseHTTP11(data []byte, info *ProtocolInfo) (*ProtocolInfo, er
 	// Extract features
 	info.Features = p.extractHTTP11Features(info)
 
+	if p.strict {
+		return info, checkHTTP11(data)
+	}
 	return info, nil
 }
 
-// parseHTTP2 parses HTTP/2 packets
+// checkHTTP11 validates the start line and header fields of an HTTP/1.x
+// message, rejecting what proxies and servers may read differently:
+// folded or nameless fields, and conflicting body lengths, as request
+// smuggling relies on
+func checkHTTP11(data []byte) error {
+	header, _, ok := strings.Cut(string(data), "\r\n\r\n")
+	if !ok {
+		return fmt.Errorf("http header: %w", ErrTruncated)
+	}
+	lines := strings.Split(header, "\r\n")
+	parts := strings.Split(lines[0], " ")
+	if strings.HasPrefix(lines[0], "HTTP/") {
+		if len(parts) < 2 || !httpVersion(parts[0]) || len(parts[1]) != 3 || strings.Trim(parts[1], "0123456789") != "" {
+			return fmt.Errorf("http status line %q: %w", lines[0], ErrMalformed)
+		}
+	} else if len(parts) != 3 || !httpToken(parts[0]) || parts[1] == "" || !httpVersion(parts[2]) {
+		return fmt.Errorf("http request line %q: %w", lines[0], ErrMalformed)
+	}
+
+	lengths := make(map[string]bool)
+	chunked := false
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !httpToken(name) {
+			return fmt.Errorf("http header line %q: %w", line, ErrMalformed)
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.EqualFold(name, "Content-Length"):
+			if value == "" || strings.Trim(value, "0123456789") != "" {
+				return fmt.Errorf("http content length %q: %w", value, ErrMalformed)
+			}
+			lengths[value] = true
+		case strings.EqualFold(name, "Transfer-Encoding"):
+			chunked = true
+		}
+	}
+	if len(lengths) > 1 || (len(lengths) > 0 && chunked) {
+		return fmt.Errorf("http message declares conflicting body lengths: %w", ErrMalformed)
+	}
+	return nil
+}
+
+// httpVersion reports whether s names an HTTP/1.x version
+func httpVersion(s string) bool {
+	return s == "HTTP/1.0" || s == "HTTP/1.1"
+}
+
+// httpToken reports whether s is a non-empty token, as methods and field
+// names are
+func httpToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseHTTP2 parses HTTP/2 packets. The header blocks are decoded in order
+// with one HPACK dynamic table, as the endpoint did, and the first one
+// fills in the request or response like an HTTP/1.1 message would.
 func (p *Parser) parseHTTP2(data []byte, info *ProtocolInfo) (*ProtocolInfo, error) {
 	info.Version = "HTTP/2"
 
-	// HTTP/2 parsing is complex - this is a simplified version
-	if len(data) >= 9 {
-		frameType := data[3]
-		flags := data[4]
+	info.Features = make(map[string]interface{})
+	if frames := bytes.TrimPrefix(data, []byte(HTTP2Preface)); len(frames) >= 9 {
+		info.Features["frame_type"] = frames[3]
+		info.Features["flags"] = frames[4]
+		info.Features["stream_id"] = binary.BigEndian.Uint32(frames[5:9]) &^ (1 << 31)
+	}
 
-		info.Features = map[string]interface{}{
-			"frame_type": frameType,
-			"flags":      flags,
-			"stream_id":  binary.BigEndian.Uint32(data[5:9]),
+	decoder := NewHTTP2Decoder()
+	blocks, err := decoder.Decode(data)
+	if err == nil && (len(decoder.pending) > 0 || !decoder.started) {
+		err = fmt.Errorf("http2 frame: %w", ErrTruncated)
+	}
+	if len(blocks) == 0 {
+		if err != nil {
+			info.Features["hpack_error"] = err.Error()
 		}
+		return info, p.partial(err)
 	}
+	p.http2Block(info, blocks[0], data)
+	info.Features["header_blocks"] = len(blocks)
 
-	return info, nil
+	return info, p.partial(err)
 }
 
-// parseHTTP3 parses HTTP/3 packets
-func (p *Parser) parseHTTP3(data []byte, info *ProtocolInfo) (*ProtocolInfo, error) {
-	info.Version = "HTTP/3"
-
-	// HTTP/3 parsing is very complex - this is a simplified version
-	info.Features = map[string]interface{}{
-		"quic_version": "unknown",
-		"stream_type":  "unknown",
+// http2Block fills info, whose features are set, from a header block.
+// data holds the frames of the block's direction, to look for the gRPC
+// framing of its body in.
+func (p *Parser) http2Block(info *ProtocolInfo, block HTTP2HeaderBlock, data []byte) {
+	http2Message(info, block)
+	info.StreamID = block.StreamID
+	for name, value := range p.extractHTTP11Features(info) {
+		info.Features[name] = value
+	}
+	if call := grpcCall(info, block.StreamID, data); call != nil {
+		info.GRPC = call
+		grpcFeatures(call, info.Features)
 	}
+}
 
-	return info, nil
+// parseHTTP3 parses HTTP/3 packets as the start of a request stream
+func (p *Parser) parseHTTP3(data []byte, info *ProtocolInfo) (*ProtocolInfo, error) {
+	return p.ParseHTTP3Stream(data, false)
 }
 
-// parseQUIC parses QUIC packets
+// parseQUIC parses the header of QUIC packets
 func (p *Parser) parseQUIC(data []byte, info *ProtocolInfo) (*ProtocolInfo, error) {
 	info.Version = "QUIC"
+	info.Features = make(map[string]interface{})
 
-	if len(data) >= 1 {
-		headerForm := (data[0] & 0x80) >> 7
-		info.Features = map[string]interface{}{
-			"header_form": headerForm,
-			"packet_type": data[0] & 0x7F,
-		}
+	header, err := ParseQUICHeader(data)
+	if err != nil {
+		return info, err
 	}
+	info.QUIC = header
+	quicFeatures(header, info.Features)
 
 	return info, nil
 }
 
-// parseTLS parses TLS packets
+// parseSSH parses the identification string and KEXINIT of SSH connections
+func (p *Parser) parseSSH(data []byte, info *ProtocolInfo) (*ProtocolInfo, error) {
+	info.Features = make(map[string]interface{})
+
+	handshake, err := ParseSSHHandshake(data)
+	if handshake == nil {
+		return info, err
+	}
+	// A malformed KEXINIT leaves the identification string to go on
+	info.Version = "SSH-" + handshake.ProtoVersion
+	info.SSH = handshake
+	sshFeatures(handshake, info.Features)
+
+	return info, p.partial(err)
+}
+
+// parseRDP parses the X.224 connection request or confirm of RDP
+// connections
+func (p *Parser) parseRDP(data []byte, info *ProtocolInfo) (*ProtocolInfo, error) {
+	info.Version = "RDP"
+	info.Features = make(map[string]interface{})
+
+	negotiation, err := ParseRDPNegotiation(data)
+	if negotiation == nil {
+		return info, err
+	}
+	// An unknown negotiation structure leaves the connection PDU to go on
+	info.RDP = negotiation
+	rdpFeatures(negotiation, info.Features)
+
+	return info, p.partial(err)
+}
+
+// parseVNC parses the protocol version and security types of VNC
+// connections
+func (p *Parser) parseVNC(data []byte, info *ProtocolInfo) (*ProtocolInfo, error) {
+	info.Features = make(map[string]interface{})
+
+	handshake, err := ParseRFBHandshake(data)
+	if handshake == nil {
+		return info, err
+	}
+	info.Version = fmt.Sprintf("RFB %d.%d", handshake.Major, handshake.Minor)
+	info.VNC = handshake
+	rfbFeatures(handshake, info.Features)
+
+	return info, p.partial(err)
+}
+
+// parseTLS parses TLS packets. The record header is always read; the
+// ClientHello, ServerHello and server certificate are parsed in full once
+// their records are complete.
 func (p *Parser) parseTLS(data []byte, info *ProtocolInfo) (*ProtocolInfo, error) {
 	info.Version = "TLS"
 
@@ -223,20 +435,68 @@ func (p *Parser) parseTLS(data []byte, info *ProtocolInfo) (*ProtocolInfo, error
 		}
 	}
 
-	return info, nil
+	messages, _, err := tlsMessages(data)
+	errs := []error{err}
+	if len(messages) == 0 {
+		// The record header is all there is to go on
+		return info, p.partial(err)
+	}
+	info.Features["handshake_type"] = messages[0].msgType
+	for _, message := range messages {
+		switch message.msgType {
+		case tlsClientHello:
+			hello, err := parseClientHello(message.body)
+			if hello != nil {
+				info.ClientHello = hello
+				clientHelloFeatures(hello, info.Features)
+			}
+			errs = append(errs, err)
+		case tlsServerHello:
+			hello, err := parseServerHello(message.body)
+			if hello != nil {
+				info.ServerHello = hello
+				info.Features["negotiated_version"] = hello.Version
+				info.Features["cipher_suite"] = hello.CipherSuite
+			}
+			errs = append(errs, err)
+		case tlsCertificate:
+			// Without a ServerHello the layout is not known; TLS 1.3
+			// lists start with a context, empty for server certificates
+			tls13 := info.ServerHello != nil && info.ServerHello.Version >= tlsVersion13
+			cert, err := parseCertificateMessage(message.body, tls13)
+			if cert == nil && info.ServerHello == nil {
+				cert, err = parseCertificateMessage(message.body, true)
+			}
+			if cert != nil {
+				info.Certificate = cert
+				certificateFeatures(cert, info.Features)
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	return info, p.partial(errors.Join(errs...))
 }
 
 // extractHTTP11Features extracts behavioral features from HTTP/1.1 traffic
 func (p *Parser) extractHTTP11Features(info *ProtocolInfo) map[string]interface{} {
 	features := make(map[string]interface{})
 
-	// Header count
+	// Header count and order
 	features["header_count"] = len(info.Headers)
+	if len(info.HeaderOrder) > 0 {
+		info.HeaderFingerprint = HeaderFingerprint(info.HeaderOrder)
+		features["header_fingerprint"] = info.HeaderFingerprint
+	}
 
 	// User agent analysis
 	if info.UserAgent != "" {
 		features["user_agent_length"] = len(info.UserAgent)
 		features["has_bot_keywords"] = p.hasBotKeywords(info.UserAgent)
+
+		info.Agent = ParseUserAgent(info.UserAgent)
+		features["ua_family"] = info.Agent.Family
+		features["ua_browser"] = info.Agent.Browser()
 	}
 
 	// Method analysis
@@ -0,0 +1,134 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertTypedError fails the test for an error wrapping none of the
+// errors callers tell malformed input apart by
+func assertTypedError(t testing.TB, err error) {
+	t.Helper()
+	if err == nil {
+		return
+	}
+	for _, target := range []error{ErrTruncated, ErrTrailingData, ErrMalformed, ErrUnsupported} {
+		if errors.Is(err, target) {
+			return
+		}
+	}
+	t.Errorf("error %q is not typed", err)
+}
+
+func TestStrictParser(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{
+			name: "http header cut short",
+			data: []byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\n"),
+			err:  ErrTruncated,
+		},
+		{
+			name: "http conflicting body lengths",
+			data: []byte("POST /login HTTP/1.1\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n"),
+			err:  ErrMalformed,
+		},
+		{
+			name: "http folded header",
+			data: []byte("GET / HTTP/1.1\r\nHost: example.com\r\n folded\r\n\r\n"),
+			err:  ErrMalformed,
+		},
+		{
+			name: "tls record cut short",
+			data: handshakeRecord(handshakeMessage(tlsServerHello, serverHello(false)))[:30],
+			err:  ErrTruncated,
+		},
+		{
+			name: "tls server hello with trailing data",
+			data: handshakeRecord(handshakeMessage(tlsServerHello, append(serverHello(true), 0))),
+			err:  ErrTrailingData,
+		},
+		{
+			name: "ssh packet other than kexinit",
+			data: append([]byte("SSH-2.0-OpenSSH_9.6\r\n"), 0, 0, 0, 12, 10, 21, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0),
+			err:  ErrMalformed,
+		},
+		{
+			name: "rdp negotiation of unknown type",
+			data: x224(0xe0, rdpNeg(9, 0)),
+			err:  ErrUnsupported,
+		},
+		{
+			name: "rfb security types cut short",
+			data: append([]byte("RFB 003.008\n"), 3, RFBSecurityNone),
+			err:  ErrTruncated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The default parser takes what it can
+			info, err := NewParser().ParsePacket(tt.data)
+			require.NoError(t, err)
+			require.NotNil(t, info)
+
+			info, err = NewStrictParser().ParsePacket(tt.data)
+			assert.ErrorIs(t, err, tt.err)
+			var parseErr *ParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, info.Protocol, parseErr.Protocol)
+		})
+	}
+}
+
+func TestStrictParserAccepts(t *testing.T) {
+	parser := NewStrictParser()
+	for _, data := range [][]byte{
+		[]byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\nContent-Length: 0\r\n\r\n"),
+		handshakeRecord(handshakeMessage(tlsServerHello, serverHello(true))),
+		append([]byte("SSH-2.0-Go\r\n"), sshKexInit(paramikoLists...)...),
+		x224(0xe0, rdpNeg(1, RDPProtocolHybrid)),
+		append([]byte("RFB 003.008\n"), 2, RFBSecurityNone, RFBSecurityVNC),
+		quicLongHeader(QUICVersion1, 0, []byte("token")),
+	} {
+		info, err := parser.ParsePacket(data)
+		require.NoError(t, err, "%q", data)
+		assert.NotEqual(t, "Unknown", info.Protocol)
+	}
+}
+
+// FuzzParsePacket checks both parsers survive any input, return typed
+// errors, and that the strict parser accepts nothing the default one
+// rejects
+func FuzzParsePacket(f *testing.F) {
+	f.Add([]byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	f.Add([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"))
+	f.Add(handshakeRecord(handshakeMessage(tlsServerHello, serverHello(true))))
+	f.Add(append([]byte("SSH-2.0-Go\r\n"), sshKexInit(paramikoLists...)...))
+	f.Add(x224(0xe0, rdpNeg(1, RDPProtocolSSL)))
+	f.Add(append([]byte("RFB 003.008\n"), 1, RFBSecurityNone))
+	f.Add(quicLongHeader(QUICVersion1, 0, []byte{1, 2, 3}))
+	f.Add([]byte(HTTP2Preface))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info, err := NewParser().ParsePacket(data)
+		assertTypedError(t, err)
+
+		strict, strictErr := NewStrictParser().ParsePacket(data)
+		assertTypedError(t, strictErr)
+		if strictErr == nil {
+			if err != nil {
+				t.Fatalf("strict parser accepted what the default one rejected: %v", err)
+			}
+			if strict.Protocol != info.Protocol {
+				t.Fatalf("strict parser identified %s, the default one %s", strict.Protocol, info.Protocol)
+			}
+		}
+	})
+}
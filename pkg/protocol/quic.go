@@ -0,0 +1,153 @@
+package protocol
+
+import "fmt"
+
+// QUIC versions whose long header packet types are known
+const (
+	QUICVersion1 = 0x00000001
+	QUICVersion2 = 0x6b3343cf
+)
+
+// quicMaxCIDLength is the longest connection ID QUIC versions 1 and 2
+// allow; only version negotiation packets may carry longer ones
+const quicMaxCIDLength = 20
+
+// QUICHeader holds the header fields of a QUIC packet that are sent in the
+// clear. Long headers carry the version and both connection ID lengths;
+// short headers only the spin bit, as the length of their destination
+// connection ID is known to the endpoints alone.
+type QUICHeader struct {
+	Long              bool     `json:"long"`
+	Version           uint32   `json:"version,omitempty"`
+	PacketType        string   `json:"packet_type"` // initial, 0rtt, handshake, retry, version_negotiation, 1rtt or unknown
+	DCIDLength        int      `json:"dcid_length"`
+	SCIDLength        int      `json:"scid_length"`
+	TokenLength       int      `json:"token_length,omitempty"` // Initial and Retry packets
+	SpinBit           bool     `json:"spin_bit,omitempty"`     // short headers
+	SupportedVersions []uint32 `json:"supported_versions,omitempty"`
+}
+
+// quicReservedVersion reports whether v is one of the versions reserved to
+// exercise version negotiation
+func quicReservedVersion(v uint32) bool {
+	return v&0x0f0f0f0f == 0x0a0a0a0a
+}
+
+// quicDraftVersion reports whether v is an IETF draft version, which share
+// the packet types of version 1
+func quicDraftVersion(v uint32) bool {
+	return v>>8 == 0xff0000
+}
+
+// quicPacketType names the type of a long header packet of a version
+func quicPacketType(version uint32, bits byte) string {
+	types := [4]string{"initial", "0rtt", "handshake", "retry"}
+	switch {
+	case version == QUICVersion2:
+		types = [4]string{"retry", "initial", "0rtt", "handshake"}
+	case version != QUICVersion1 && !quicDraftVersion(version):
+		return "unknown"
+	}
+	return types[bits&0x3]
+}
+
+// ParseQUICHeader parses the header of a QUIC packet, the first of a
+// datagram when several are coalesced. Long headers are checked against
+// the invariants and the layout of their version, so that other UDP
+// traffic is not taken for QUIC; short headers cannot be told apart from
+// random data and are only meaningful on flows known to be QUIC.
+func ParseQUICHeader(data []byte) (*QUICHeader, error) {
+	r := &reader{data: data}
+	first := r.uint8()
+	if r.err != nil {
+		return nil, fmt.Errorf("quic header: %w", r.err)
+	}
+
+	if first&0x80 == 0 {
+		if first&0x40 == 0 {
+			return nil, fmt.Errorf("quic short header without the fixed bit: %w", ErrMalformed)
+		}
+		return &QUICHeader{PacketType: "1rtt", SpinBit: first&0x20 != 0}, nil
+	}
+
+	header := &QUICHeader{Long: true}
+	version := r.bytes(4)
+	dcid := r.vector8()
+	scid := r.vector8()
+	if r.err != nil {
+		return nil, fmt.Errorf("quic long header: %w", r.err)
+	}
+	header.Version = uint32(version[0])<<24 | uint32(version[1])<<16 | uint32(version[2])<<8 | uint32(version[3])
+	header.DCIDLength, header.SCIDLength = len(dcid.data), len(scid.data)
+
+	if header.Version == 0 {
+		header.PacketType = "version_negotiation"
+		if len(r.data) == 0 || len(r.data)%4 != 0 {
+			return nil, fmt.Errorf("quic version negotiation lists no versions: %w", ErrMalformed)
+		}
+		for !r.empty() {
+			v := r.bytes(4)
+			header.SupportedVersions = append(header.SupportedVersions,
+				uint32(v[0])<<24|uint32(v[1])<<16|uint32(v[2])<<8|uint32(v[3]))
+		}
+		return header, nil
+	}
+
+	if header.Version != QUICVersion1 && header.Version != QUICVersion2 &&
+		!quicDraftVersion(header.Version) && !quicReservedVersion(header.Version) {
+		return nil, fmt.Errorf("quic version %#08x: %w", header.Version, ErrUnsupported)
+	}
+	if first&0x40 == 0 {
+		return nil, fmt.Errorf("quic long header without the fixed bit: %w", ErrMalformed)
+	}
+	if header.DCIDLength > quicMaxCIDLength || header.SCIDLength > quicMaxCIDLength {
+		return nil, fmt.Errorf("quic connection ID longer than %d bytes: %w", quicMaxCIDLength, ErrMalformed)
+	}
+	header.PacketType = quicPacketType(header.Version, first>>4)
+
+	switch header.PacketType {
+	case "retry":
+		// The token runs to the 16-byte integrity tag
+		if len(r.data) < 16 {
+			return nil, fmt.Errorf("quic retry: %w", ErrTruncated)
+		}
+		header.TokenLength = len(r.data) - 16
+		return header, nil
+	case "initial":
+		header.TokenLength = len(r.bytes(int(r.varint())))
+	case "unknown":
+		return header, nil
+	}
+	length := r.varint()
+	if r.err != nil || length > uint64(len(r.data)) {
+		return nil, fmt.Errorf("quic %s packet: %w", header.PacketType, ErrTruncated)
+	}
+
+	return header, nil
+}
+
+// quicFeatures summarizes a QUIC header as protocol features
+func quicFeatures(header *QUICHeader, features map[string]interface{}) {
+	features["header_form"] = boolInt(header.Long)
+	features["packet_type"] = header.PacketType
+	if header.Long {
+		features["version"] = header.Version
+		features["dcid_len"] = header.DCIDLength
+		features["scid_len"] = header.SCIDLength
+		features["has_token"] = header.TokenLength > 0
+		features["token_length"] = header.TokenLength
+	} else {
+		features["spin_bit"] = header.SpinBit
+	}
+	if header.SupportedVersions != nil {
+		features["supported_version_count"] = len(header.SupportedVersions)
+	}
+}
+
+// boolInt encodes a flag as 0 or 1
+func boolInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
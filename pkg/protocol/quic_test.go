@@ -0,0 +1,107 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// quicLongHeader returns a long header packet of a version and type bits,
+// with an 8-byte DCID, a 4-byte SCID and, for Initial packets, a token
+func quicLongHeader(version uint32, typeBits byte, token []byte) []byte {
+	packet := []byte{0xc0 | typeBits<<4, byte(version >> 24), byte(version >> 16), byte(version >> 8), byte(version)}
+	packet = append(packet, 8, 1, 2, 3, 4, 5, 6, 7, 8, 4, 9, 9, 9, 9)
+	if token != nil {
+		packet = append(packet, byte(len(token)))
+		packet = append(packet, token...)
+	}
+	payload := make([]byte, 40)
+	packet = append(packet, 0x40, byte(len(payload))) // two-byte length
+	return append(packet, payload...)
+}
+
+func TestParseQUICHeader(t *testing.T) {
+	header, err := ParseQUICHeader(quicLongHeader(QUICVersion1, 0, []byte("token")))
+	require.NoError(t, err)
+	assert.Equal(t, &QUICHeader{
+		Long:        true,
+		Version:     QUICVersion1,
+		PacketType:  "initial",
+		DCIDLength:  8,
+		SCIDLength:  4,
+		TokenLength: 5,
+	}, header)
+
+	// Version 2 numbers its packet types differently
+	header, err = ParseQUICHeader(quicLongHeader(QUICVersion2, 1, []byte{}))
+	require.NoError(t, err)
+	assert.Equal(t, "initial", header.PacketType)
+	assert.Zero(t, header.TokenLength)
+	header, err = ParseQUICHeader(quicLongHeader(QUICVersion2, 3, nil))
+	require.NoError(t, err)
+	assert.Equal(t, "handshake", header.PacketType)
+
+	// Version negotiation lists the server's versions
+	negotiation := []byte{0x80, 0, 0, 0, 0, 0, 4, 1, 2, 3, 4, 0, 0, 0, 1, 0x6b, 0x33, 0x43, 0xcf}
+	header, err = ParseQUICHeader(negotiation)
+	require.NoError(t, err)
+	assert.Equal(t, "version_negotiation", header.PacketType)
+	assert.Equal(t, []uint32{QUICVersion1, QUICVersion2}, header.SupportedVersions)
+
+	// Short headers only reveal the spin bit
+	header, err = ParseQUICHeader([]byte{0x60, 1, 2, 3})
+	require.NoError(t, err)
+	assert.Equal(t, &QUICHeader{PacketType: "1rtt", SpinBit: true}, header)
+}
+
+func TestParseQUICHeaderRejectsOtherUDP(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"unknown version":  quicLongHeader(0x12345678, 0, []byte{}),
+		"no fixed bit":     append([]byte{0x80}, quicLongHeader(QUICVersion1, 0, []byte{})[1:]...),
+		"long dcid":        append([]byte{0xc0, 0, 0, 0, 1, 21}, make([]byte, 40)...),
+		"truncated length": quicLongHeader(QUICVersion1, 2, nil)[:20],
+		"empty":            {},
+	} {
+		_, err := ParseQUICHeader(data)
+		assert.Error(t, err, name)
+	}
+
+	// Data that merely has the second bit set, as the old heuristic
+	// accepted, is not identified as QUIC
+	parser := NewParser()
+	info, err := parser.ParsePacket([]byte("EHLO mail.example.com\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "Unknown", info.Protocol)
+}
+
+func TestParsePacketQUIC(t *testing.T) {
+	parser := NewParser()
+	info, err := parser.ParsePacket(quicLongHeader(QUICVersion1, 0, []byte("token")))
+	require.NoError(t, err)
+	assert.Equal(t, "QUIC", info.Protocol)
+	require.NotNil(t, info.QUIC)
+	assert.Equal(t, 1, info.Features["header_form"])
+	assert.Equal(t, "initial", info.Features["packet_type"])
+	assert.Equal(t, uint32(QUICVersion1), info.Features["version"])
+	assert.Equal(t, 8, info.Features["dcid_len"])
+	assert.Equal(t, 4, info.Features["scid_len"])
+	assert.Equal(t, true, info.Features["has_token"])
+}
+
+func FuzzParseQUICHeader(f *testing.F) {
+	f.Add(quicLongHeader(QUICVersion1, 0, []byte("token")))
+	f.Add(quicLongHeader(QUICVersion2, 2, nil))
+	f.Add([]byte{0x40, 1, 2, 3, 4, 5, 6, 7, 8})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		header, err := ParseQUICHeader(data)
+		assertTypedError(t, err)
+		// Version negotiation follows the invariants, which allow longer
+		// connection IDs than the versions themselves
+		if err == nil && header.Version != 0 &&
+			(header.DCIDLength > quicMaxCIDLength || header.SCIDLength > quicMaxCIDLength) {
+			t.Fatalf("connection ID lengths %d and %d accepted", header.DCIDLength, header.SCIDLength)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// TPKT and X.224 framing of RDP connection setup (RFC 1006, ITU-T X.224)
+const (
+	tpktVersion    = 3
+	tpktHeaderSize = 4
+
+	x224ConnectionRequest = 0xe0
+	x224ConnectionConfirm = 0xd0
+
+	rdpNegRequest  = 0x01
+	rdpNegResponse = 0x02
+	rdpNegFailure  = 0x03
+	rdpNegSize     = 8
+)
+
+// RDP security protocols, requested by clients as flags and selected by
+// servers as one of them (MS-RDPBCGR 2.2.1.1.1)
+const (
+	RDPProtocolRDP      = 0x00 // standard RDP security, no TLS
+	RDPProtocolSSL      = 0x01
+	RDPProtocolHybrid   = 0x02 // CredSSP, network level authentication
+	RDPProtocolRDSTLS   = 0x04
+	RDPProtocolHybridEx = 0x08
+)
+
+// RDPNegotiation is the X.224 connection request of an RDP client or the
+// connection confirm of its server. Brute-force tools tend to announce a
+// fixed or truncated user name in the cookie and to ask for standard RDP
+// security, which lets them try passwords before authenticating with
+// CredSSP.
+type RDPNegotiation struct {
+	Request bool `json:"request"` // a client's connection request, else a server's confirm
+	// Cookie is the mstshash user name or routing token of a request
+	Cookie             string `json:"cookie,omitempty"`
+	Negotiated         bool   `json:"negotiated"` // the PDU carried a negotiation structure
+	RequestedProtocols uint32 `json:"requested_protocols,omitempty"`
+	SelectedProtocol   uint32 `json:"selected_protocol,omitempty"`
+	FailureCode        uint32 `json:"failure_code,omitempty"`
+}
+
+// StandardSecurity reports whether the connection is set up with standard
+// RDP security rather than TLS or CredSSP: requests asking for nothing
+// more and confirms selecting it
+func (n *RDPNegotiation) StandardSecurity() bool {
+	if n.Request {
+		return n.RequestedProtocols == RDPProtocolRDP
+	}
+	return n.FailureCode == 0 && n.SelectedProtocol == RDPProtocolRDP
+}
+
+// IsTPKT reports whether data starts with a TPKT header framing an X.224
+// connection request or confirm, as RDP connections open
+func IsTPKT(data []byte) bool {
+	if len(data) < tpktHeaderSize+2 || data[0] != tpktVersion || data[1] != 0 {
+		return false
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	code := data[5] & 0xf0
+	return int(data[4]) == length-tpktHeaderSize-1 &&
+		(code == x224ConnectionRequest || code == x224ConnectionConfirm)
+}
+
+// TPKTComplete reports whether data holds the whole TPKT packet it starts
+// with
+func TPKTComplete(data []byte) bool {
+	return len(data) >= tpktHeaderSize && len(data) >= int(binary.BigEndian.Uint16(data[2:4]))
+}
+
+// ParseRDPNegotiation parses the X.224 connection request or confirm that
+// starts an RDP connection. An unknown or malformed negotiation structure
+// and trailing data leave the negotiation returned with the error.
+func ParseRDPNegotiation(data []byte) (*RDPNegotiation, error) {
+	r := &reader{data: data}
+	if version := r.uint8(); version != tpktVersion {
+		return nil, fmt.Errorf("tpkt version %d: %w", version, ErrUnsupported)
+	}
+	r.uint8() // reserved
+	tpdu := r.sub(int(r.uint16()) - tpktHeaderSize)
+	header := tpdu.vector8()
+	code := header.uint8() & 0xf0
+	header.bytes(5) // destination and source references, class
+	if r.err != nil || tpdu.err != nil || header.err != nil {
+		return nil, fmt.Errorf("x224 tpdu: %w", ErrTruncated)
+	}
+
+	negotiation := &RDPNegotiation{}
+	switch code {
+	case x224ConnectionRequest:
+		negotiation.Request = true
+		// An optional cookie line precedes the negotiation request
+		if end := bytes.Index(header.data, []byte("\r\n")); end >= 0 && bytes.HasPrefix(header.data, []byte("Cookie: ")) {
+			cookie := string(header.data[len("Cookie: "):end])
+			negotiation.Cookie = strings.TrimPrefix(cookie, "mstshash=")
+			header.bytes(end + 2)
+		}
+	case x224ConnectionConfirm:
+	default:
+		return nil, fmt.Errorf("x224 tpdu code %#x is not a connection request or confirm: %w", code, ErrUnsupported)
+	}
+
+	if len(header.data) >= rdpNegSize {
+		// The negotiation structures are little-endian
+		neg := header.bytes(rdpNegSize)
+		value := binary.LittleEndian.Uint32(neg[4:8])
+		switch neg[0] {
+		case rdpNegRequest:
+			negotiation.RequestedProtocols = value
+		case rdpNegResponse:
+			negotiation.SelectedProtocol = value
+		case rdpNegFailure:
+			negotiation.FailureCode = value
+		default:
+			return negotiation, fmt.Errorf("rdp negotiation type %d: %w", neg[0], ErrUnsupported)
+		}
+		negotiation.Negotiated = true
+		if length := binary.LittleEndian.Uint16(neg[2:4]); length != rdpNegSize {
+			return negotiation, fmt.Errorf("rdp negotiation length %d: %w", length, ErrMalformed)
+		}
+	}
+	if err := checkAll(header, tpdu, r); err != nil {
+		return negotiation, fmt.Errorf("x224 tpdu: %w", err)
+	}
+	return negotiation, nil
+}
+
+// rdpFeatures summarizes an RDP negotiation as protocol features
+func rdpFeatures(negotiation *RDPNegotiation, features map[string]interface{}) {
+	features["x224_request"] = negotiation.Request
+	features["negotiated"] = negotiation.Negotiated
+	features["standard_security"] = negotiation.StandardSecurity()
+	if negotiation.Request {
+		features["has_cookie"] = negotiation.Cookie != ""
+		features["requested_protocols"] = negotiation.RequestedProtocols
+		features["nla_requested"] = negotiation.RequestedProtocols&(RDPProtocolHybrid|RDPProtocolHybridEx) != 0
+	} else {
+		features["selected_protocol"] = negotiation.SelectedProtocol
+		features["failure_code"] = negotiation.FailureCode
+	}
+}
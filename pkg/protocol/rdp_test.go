@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// x224 returns a TPKT packet holding an X.224 PDU of code with variable
+// data
+func x224(code byte, variable []byte) []byte {
+	pdu := append([]byte{code, 0, 0, 0x12, 0x34, 0}, variable...)
+	pdu = append([]byte{byte(len(pdu))}, pdu...)
+	length := tpktHeaderSize + len(pdu)
+	return append([]byte{tpktVersion, 0, byte(length >> 8), byte(length)}, pdu...)
+}
+
+// rdpNeg returns a negotiation structure of a type carrying value
+func rdpNeg(negType byte, value uint32) []byte {
+	return []byte{negType, 0, rdpNegSize, 0, byte(value), byte(value >> 8), byte(value >> 16), byte(value >> 24)}
+}
+
+func TestParseRDPNegotiation(t *testing.T) {
+	request := x224(x224ConnectionRequest, append([]byte("Cookie: mstshash=Administr\r\n"), rdpNeg(rdpNegRequest, RDPProtocolRDP)...))
+	require.True(t, IsTPKT(request))
+	require.True(t, TPKTComplete(request))
+	assert.False(t, TPKTComplete(request[:10]))
+
+	info, err := NewParser().ParsePacket(request)
+	require.NoError(t, err)
+	assert.Equal(t, "RDP", info.Protocol)
+	assert.Equal(t, &RDPNegotiation{Request: true, Cookie: "Administr", Negotiated: true}, info.RDP)
+	assert.True(t, info.RDP.StandardSecurity())
+	assert.Equal(t, true, info.Features["has_cookie"])
+	assert.Equal(t, false, info.Features["nla_requested"])
+
+	request = x224(x224ConnectionRequest, rdpNeg(rdpNegRequest, RDPProtocolSSL|RDPProtocolHybrid|RDPProtocolHybridEx))
+	negotiation, err := ParseRDPNegotiation(request)
+	require.NoError(t, err)
+	assert.Empty(t, negotiation.Cookie)
+	assert.False(t, negotiation.StandardSecurity())
+
+	// Confirms are shorter than the parser's usual minimum
+	confirm := x224(x224ConnectionConfirm, rdpNeg(rdpNegResponse, RDPProtocolHybrid))
+	info, err = NewParser().ParsePacket(confirm)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(RDPProtocolHybrid), info.RDP.SelectedProtocol)
+	assert.False(t, info.RDP.StandardSecurity())
+
+	negotiation, err = ParseRDPNegotiation(x224(x224ConnectionConfirm, rdpNeg(rdpNegFailure, 5)))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(5), negotiation.FailureCode)
+	assert.False(t, negotiation.StandardSecurity())
+
+	// Requests of old clients carry no negotiation structure
+	negotiation, err = ParseRDPNegotiation(x224(x224ConnectionRequest, []byte("Cookie: mstshash=hello\r\n")))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", negotiation.Cookie)
+	assert.False(t, negotiation.Negotiated)
+	assert.True(t, negotiation.StandardSecurity())
+}
+
+func TestParseRDPNegotiationRejectsOther(t *testing.T) {
+	assert.False(t, IsTPKT([]byte{3, 0, 0, 11, 6, 0xf0, 0x80}))
+	assert.False(t, IsTPKT([]byte{3, 0, 0}))
+
+	_, err := ParseRDPNegotiation(x224(0xf0, nil))
+	assert.Error(t, err)
+	_, err = ParseRDPNegotiation(x224(x224ConnectionRequest, nil)[:8])
+	assert.Error(t, err)
+}
+
+func FuzzParseRDPNegotiation(f *testing.F) {
+	f.Add(x224(x224ConnectionRequest, append([]byte("Cookie: mstshash=admin\r\n"), rdpNeg(rdpNegRequest, RDPProtocolSSL)...)))
+	f.Add(x224(x224ConnectionConfirm, rdpNeg(rdpNegFailure, 5)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		negotiation, err := ParseRDPNegotiation(data)
+		assertTypedError(t, err)
+		if err == nil && negotiation == nil {
+			t.Fatal("no negotiation and no error")
+		}
+		if IsTPKT(data) {
+			TPKTComplete(data)
+		}
+	})
+}
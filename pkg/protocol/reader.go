@@ -0,0 +1,163 @@
+package protocol
+
+// reader reads big-endian fields from a message, failing once a read runs
+// past the end. Reads after a failure return zero values, so a sequence of
+// reads needs a single check of err at the end.
+type reader struct {
+	data []byte
+	err  error
+}
+
+// fail records that the message ended too early and empties the reader
+func (r *reader) fail() {
+	r.data = nil
+	if r.err == nil {
+		r.err = ErrTruncated
+	}
+}
+
+// empty reports whether everything was read
+func (r *reader) empty() bool {
+	return len(r.data) == 0
+}
+
+// bytes reads the next n bytes
+func (r *reader) bytes(n int) []byte {
+	if n < 0 || n > len(r.data) {
+		r.fail()
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// uint8 reads a byte
+func (r *reader) uint8() uint8 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+// uint16 reads a 16-bit integer
+func (r *reader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+// uint24 reads a 24-bit integer
+func (r *reader) uint24() int {
+	b := r.bytes(3)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+}
+
+// uint32 reads a 32-bit integer
+func (r *reader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+// check returns the error of a reader expected to be read to its end: its
+// failure, or ErrTrailingData when data is left
+func (r *reader) check() error {
+	if r.err != nil {
+		return r.err
+	}
+	if !r.empty() {
+		return ErrTrailingData
+	}
+	return nil
+}
+
+// checkAll returns the first error of readers expected to be read to their
+// end
+func checkAll(readers ...*reader) error {
+	for _, r := range readers {
+		if err := r.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vector8 reads a vector with a one-byte length prefix as a reader of its
+// contents
+func (r *reader) vector8() *reader {
+	return r.sub(int(r.uint8()))
+}
+
+// vector16 reads a vector with a two-byte length prefix as a reader of its
+// contents
+func (r *reader) vector16() *reader {
+	return r.sub(int(r.uint16()))
+}
+
+// vector24 reads a vector with a three-byte length prefix as a reader of
+// its contents
+func (r *reader) vector24() *reader {
+	return r.sub(r.uint24())
+}
+
+// sub reads the next n bytes as a reader of their own. Failures of the sub
+// reader are not reported by r.
+func (r *reader) sub(n int) *reader {
+	if r.err != nil {
+		return &reader{err: r.err}
+	}
+	b := r.bytes(n)
+	if b == nil {
+		return &reader{err: r.err}
+	}
+	return &reader{data: b}
+}
+
+// varint reads a QUIC variable-length integer, whose two high bits give
+// its length
+func (r *reader) varint() uint64 {
+	if r.empty() {
+		r.fail()
+		return 0
+	}
+	b := r.bytes(1 << (r.data[0] >> 6))
+	if b == nil {
+		return 0
+	}
+	v := uint64(b[0] & 0x3f)
+	for _, c := range b[1:] {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// prefixInt reads an HPACK/QPACK integer whose first n bits are the low
+// bits of first, continuing in the bytes that follow when they are all set
+func (r *reader) prefixInt(first byte, n uint) uint64 {
+	max := uint64(1)<<n - 1
+	v := uint64(first) & max
+	if v < max {
+		return v
+	}
+	for shift := uint(0); shift < 63; shift += 7 {
+		b := r.uint8()
+		if r.err != nil {
+			return 0
+		}
+		v += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v
+		}
+	}
+	r.fail()
+	return 0
+}
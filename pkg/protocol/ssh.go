@@ -0,0 +1,208 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SSH message types and limits read by the parser
+const (
+	sshMsgKexInit = 20
+
+	// sshMaxBannerLines bounds the lines a server may send before its
+	// identification string
+	sshMaxBannerLines = 16
+	// sshMaxPacketLength is the largest packet implementations must accept
+	sshMaxPacketLength = 35000
+)
+
+// sshAutomationClients are the software prefixes of the SSH libraries and
+// scanners behind most automated logins; interactive sessions come from
+// OpenSSH, PuTTY and the like
+var sshAutomationClients = []string{
+	"libssh", "paramiko", "Go", "JSCH", "AsyncSSH", "Twisted", "russh",
+	"ZGrab", "sshlib", "Ganymed", "Renci.SshNet", "phpseclib", "NmapNSE",
+}
+
+// SSHHandshake holds the identification string and KEXINIT one side of an
+// SSH connection sends in the clear. The algorithm lists, in the order
+// sent, identify the implementation: HASSH hashes those of a client and
+// HASSHServer those of a server.
+type SSHHandshake struct {
+	ProtoVersion string `json:"proto_version"` // "2.0", or "1.99" for servers also speaking SSH 1
+	Software     string `json:"software"`
+	Comments     string `json:"comments,omitempty"`
+
+	// Algorithm lists of the KEXINIT, absent until it was seen
+	KexAlgorithms           []string `json:"kex_algorithms,omitempty"`
+	HostKeyAlgorithms       []string `json:"host_key_algorithms,omitempty"`
+	CiphersClientToServer   []string `json:"ciphers_client_to_server,omitempty"`
+	CiphersServerToClient   []string `json:"ciphers_server_to_client,omitempty"`
+	MACsClientToServer      []string `json:"macs_client_to_server,omitempty"`
+	MACsServerToClient      []string `json:"macs_server_to_client,omitempty"`
+	CompressionClientServer []string `json:"compression_client_to_server,omitempty"`
+	CompressionServerClient []string `json:"compression_server_to_client,omitempty"`
+	FirstKexFollows         bool     `json:"first_kex_follows,omitempty"`
+
+	HASSH       string `json:"hassh,omitempty"`
+	HASSHServer string `json:"hassh_server,omitempty"`
+}
+
+// AutomationClient reports whether the software is a library or scanner
+// rather than an interactive client
+func (h *SSHHandshake) AutomationClient() bool {
+	for _, prefix := range sshAutomationClients {
+		if strings.HasPrefix(h.Software, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hassh hashes the key exchange, cipher, MAC and compression lists of one
+// direction as the HASSH fingerprint does
+func hassh(kex, ciphers, macs, compression []string) string {
+	sum := md5.Sum([]byte(strings.Join([]string{
+		strings.Join(kex, ","),
+		strings.Join(ciphers, ","),
+		strings.Join(macs, ","),
+		strings.Join(compression, ","),
+	}, ";")))
+	return hex.EncodeToString(sum[:])
+}
+
+// sshBanner finds the identification string at the start of data, skipping
+// the lines servers may send before it. It returns the string, without its
+// line ending, and the data after it; ok is false while the string is not
+// complete.
+func sshBanner(data []byte) (banner string, rest []byte, ok bool) {
+	for i := 0; i < sshMaxBannerLines; i++ {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			return "", nil, false
+		}
+		line := strings.TrimRight(string(data[:end]), "\r")
+		data = data[end+1:]
+		if strings.HasPrefix(line, "SSH-") {
+			return line, data, true
+		}
+	}
+	return "", nil, false
+}
+
+// sshPacket reads the payload of the first binary packet in data, before
+// keys are exchanged and thus unencrypted and without a MAC
+func sshPacket(data []byte) ([]byte, error) {
+	r := &reader{data: data}
+	length := r.uint32()
+	if r.err == nil && (length < 5 || length > sshMaxPacketLength) {
+		return nil, fmt.Errorf("ssh packet length %d out of range: %w", length, ErrMalformed)
+	}
+	packet := r.sub(int(length))
+	padding := int(packet.uint8())
+	payload := packet.bytes(len(packet.data) - padding)
+	if r.err != nil || packet.err != nil {
+		return nil, fmt.Errorf("ssh packet: %w", ErrTruncated)
+	}
+	return payload, nil
+}
+
+// SSHHandshakeComplete reports whether data, the start of one direction of
+// an SSH connection, holds the identification string and a complete first
+// packet
+func SSHHandshakeComplete(data []byte) bool {
+	_, rest, ok := sshBanner(data)
+	if !ok {
+		return false
+	}
+	_, err := sshPacket(rest)
+	return !errors.Is(err, ErrTruncated)
+}
+
+// ParseSSHHandshake parses the identification string and, when it follows,
+// the KEXINIT of one direction of an SSH connection. A packet that is not
+// a well-formed KEXINIT leaves the handshake returned with the error.
+func ParseSSHHandshake(data []byte) (*SSHHandshake, error) {
+	banner, rest, ok := sshBanner(data)
+	if !ok {
+		return nil, fmt.Errorf("ssh identification string: %w", ErrTruncated)
+	}
+
+	// SSH-protoversion-softwareversion SP comments
+	parts := strings.SplitN(strings.TrimPrefix(banner, "SSH-"), "-", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("ssh identification string %q: %w", banner, ErrMalformed)
+	}
+	handshake := &SSHHandshake{ProtoVersion: parts[0]}
+	handshake.Software, handshake.Comments, _ = strings.Cut(parts[1], " ")
+
+	if len(rest) == 0 {
+		return handshake, nil
+	}
+	payload, err := sshPacket(rest)
+	if err != nil {
+		return handshake, err
+	}
+	if len(payload) == 0 || payload[0] != sshMsgKexInit {
+		return handshake, fmt.Errorf("ssh packet after identification string is not a kexinit: %w", ErrMalformed)
+	}
+	return handshake, parseKexInit(payload[1:], handshake)
+}
+
+// parseKexInit reads the algorithm lists of a KEXINIT payload, after its
+// message type
+func parseKexInit(payload []byte, handshake *SSHHandshake) error {
+	r := &reader{data: payload}
+	r.bytes(16) // cookie
+	lists := []*[]string{
+		&handshake.KexAlgorithms,
+		&handshake.HostKeyAlgorithms,
+		&handshake.CiphersClientToServer,
+		&handshake.CiphersServerToClient,
+		&handshake.MACsClientToServer,
+		&handshake.MACsServerToClient,
+		&handshake.CompressionClientServer,
+		&handshake.CompressionServerClient,
+		new([]string), // languages client to server
+		new([]string), // languages server to client
+	}
+	for _, list := range lists {
+		names := r.bytes(int(r.uint32()))
+		if len(names) > 0 {
+			*list = strings.Split(string(names), ",")
+		}
+	}
+	handshake.FirstKexFollows = r.uint8() != 0
+	if r.err != nil {
+		return fmt.Errorf("ssh kexinit: %w", r.err)
+	}
+
+	handshake.HASSH = hassh(handshake.KexAlgorithms, handshake.CiphersClientToServer,
+		handshake.MACsClientToServer, handshake.CompressionClientServer)
+	handshake.HASSHServer = hassh(handshake.KexAlgorithms, handshake.CiphersServerToClient,
+		handshake.MACsServerToClient, handshake.CompressionServerClient)
+
+	r.uint32() // reserved
+	if err := r.check(); err != nil {
+		return fmt.Errorf("ssh kexinit: %w", err)
+	}
+	return nil
+}
+
+// sshFeatures summarizes an SSH handshake as protocol features
+func sshFeatures(handshake *SSHHandshake, features map[string]interface{}) {
+	features["proto_version"] = handshake.ProtoVersion
+	features["software"] = handshake.Software
+	features["automation_client"] = handshake.AutomationClient()
+	features["has_kexinit"] = handshake.HASSH != ""
+	if handshake.HASSH != "" {
+		features["kex_count"] = len(handshake.KexAlgorithms)
+		features["cipher_count"] = len(handshake.CiphersClientToServer)
+		features["hassh"] = handshake.HASSH
+		features["hassh_server"] = handshake.HASSHServer
+	}
+}
@@ -0,0 +1,122 @@
+package protocol
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sshKexInit returns an unencrypted binary packet holding a KEXINIT with
+// the given name-lists, languages left empty
+func sshKexInit(lists ...string) []byte {
+	payload := append([]byte{sshMsgKexInit}, make([]byte, 16)...)
+	for _, list := range append(lists, "", "") {
+		n := len(list)
+		payload = append(payload, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		payload = append(payload, list...)
+	}
+	payload = append(payload, 0, 0, 0, 0, 0) // first_kex_packet_follows, reserved
+
+	padding := 8 - (len(payload)+5)%8
+	if padding < 4 {
+		padding += 8
+	}
+	length := len(payload) + padding + 1
+	packet := []byte{byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length), byte(padding)}
+	packet = append(packet, payload...)
+	return append(packet, make([]byte, padding)...)
+}
+
+var paramikoLists = []string{
+	"curve25519-sha256,diffie-hellman-group14-sha256",
+	"ssh-ed25519,rsa-sha2-512",
+	"aes128-ctr,aes256-ctr",
+	"aes128-ctr,aes256-ctr,aes128-cbc",
+	"hmac-sha2-256",
+	"hmac-sha2-256,hmac-sha1",
+	"none",
+	"none,zlib@openssh.com",
+}
+
+func TestParseSSHHandshake(t *testing.T) {
+	data := append([]byte("SSH-2.0-paramiko_3.4.0 extra\r\n"), sshKexInit(paramikoLists...)...)
+	require.True(t, SSHHandshakeComplete(data))
+
+	handshake, err := ParseSSHHandshake(data)
+	require.NoError(t, err)
+	assert.Equal(t, "2.0", handshake.ProtoVersion)
+	assert.Equal(t, "paramiko_3.4.0", handshake.Software)
+	assert.Equal(t, "extra", handshake.Comments)
+	assert.True(t, handshake.AutomationClient())
+	assert.Equal(t, []string{"curve25519-sha256", "diffie-hellman-group14-sha256"}, handshake.KexAlgorithms)
+	assert.Equal(t, []string{"aes128-ctr", "aes256-ctr", "aes128-cbc"}, handshake.CiphersServerToClient)
+	assert.Equal(t, []string{"none", "zlib@openssh.com"}, handshake.CompressionServerClient)
+
+	sum := md5.Sum([]byte("curve25519-sha256,diffie-hellman-group14-sha256;aes128-ctr,aes256-ctr;hmac-sha2-256;none"))
+	assert.Equal(t, hex.EncodeToString(sum[:]), handshake.HASSH)
+	assert.NotEqual(t, handshake.HASSH, handshake.HASSHServer)
+
+	// Protocol info carries the handshake and its features
+	info, err := NewParser().ParsePacket(data)
+	require.NoError(t, err)
+	assert.Equal(t, "SSH", info.Protocol)
+	assert.Equal(t, "SSH-2.0", info.Version)
+	assert.Equal(t, handshake, info.SSH)
+	assert.Equal(t, true, info.Features["automation_client"])
+	assert.Equal(t, 2, info.Features["kex_count"])
+	assert.Equal(t, handshake.HASSH, info.Features["hassh"])
+}
+
+func TestParseSSHBannerOnly(t *testing.T) {
+	// Servers may send lines before their identification string
+	data := []byte("Welcome\r\nSSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13\r\n\x00\x00")
+	assert.False(t, SSHHandshakeComplete(data))
+
+	// The packet cut short is reported with the identification string
+	handshake, err := ParseSSHHandshake(data)
+	assert.ErrorIs(t, err, ErrTruncated)
+	require.NotNil(t, handshake)
+	assert.Equal(t, "OpenSSH_9.6p1", handshake.Software)
+	assert.False(t, handshake.AutomationClient())
+	assert.Empty(t, handshake.HASSH)
+
+	_, err = ParseSSHHandshake([]byte("SSH-2.0"))
+	assert.Error(t, err)
+	_, err = ParseSSHHandshake([]byte("SSH-2.0\r\n"))
+	assert.Error(t, err)
+}
+
+func TestParseSSHMalformedKexInit(t *testing.T) {
+	// A name-list running past the packet
+	packet := sshKexInit(paramikoLists...)
+	packet[5+1+16+3] = 0xff
+	data := append([]byte("SSH-2.0-Go\r\n"), packet...)
+
+	handshake, err := ParseSSHHandshake(data)
+	assert.Error(t, err)
+	require.NotNil(t, handshake)
+	assert.Equal(t, "Go", handshake.Software)
+
+	// The parser keeps what the identification string tells
+	info, err := NewParser().ParsePacket(data)
+	require.NoError(t, err)
+	assert.Equal(t, false, info.Features["has_kexinit"])
+	assert.Equal(t, true, info.Features["automation_client"])
+}
+
+func FuzzParseSSHHandshake(f *testing.F) {
+	f.Add(append([]byte("SSH-2.0-paramiko_3.4.0\r\n"), sshKexInit(paramikoLists...)...))
+	f.Add([]byte("Welcome\r\nSSH-2.0-OpenSSH_9.6\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		handshake, err := ParseSSHHandshake(data)
+		assertTypedError(t, err)
+		if err == nil && handshake == nil {
+			t.Fatal("no handshake and no error")
+		}
+		SSHHandshakeComplete(data)
+	})
+}
@@ -0,0 +1,294 @@
+package protocol
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// http2DefaultMaxFrameSize is the largest frame payload an HTTP/2 peer
+// may send before SETTINGS raise it
+const http2DefaultMaxFrameSize = 16384
+
+// Direction tells the two directions of a connection apart
+type Direction int
+
+const (
+	// ClientToServer is the data sent by the side that opened the
+	// connection
+	ClientToServer Direction = iota
+	// ServerToClient is the data sent by the other side
+	ServerToClient
+)
+
+// String returns the direction's name
+func (d Direction) String() string {
+	if d == ServerToClient {
+		return "server"
+	}
+	return "client"
+}
+
+// Event is a message a Stream parsed
+type Event struct {
+	Direction Direction
+	Info      *ProtocolInfo
+}
+
+// Stream parses one connection as its data arrives, keeping the state of
+// each direction between calls: messages split across segments are
+// parsed once they are complete, and the messages after the first are
+// parsed too where they are in the clear, the requests and responses of
+// HTTP/1.x and the header blocks of HTTP/2, the latter with the HPACK
+// table the earlier blocks built. Encrypted protocols yield their
+// handshake only.
+//
+// A Stream is not safe for concurrent use.
+type Stream struct {
+	parser   *Parser
+	maxBytes int
+	dirs     [2]streamDirection
+}
+
+// streamDirection is the state of one direction of a Stream
+type streamDirection struct {
+	buf     []byte
+	started bool          // a message was parsed
+	skip    int           // body bytes of the last HTTP/1.x message still to come
+	http2   *HTTP2Decoder // set once the first HTTP/2 header block was parsed
+	done    bool          // nothing more is parsed
+}
+
+// NewStream creates a stream buffering at most maxBytes of a message; a
+// message growing past them is parsed as far as it got and ends the
+// direction
+func (p *Parser) NewStream(maxBytes int) *Stream {
+	return &Stream{parser: p, maxBytes: maxBytes}
+}
+
+// Feed passes the next data of a direction, in order, and returns the
+// messages it completes
+func (s *Stream) Feed(dir Direction, data []byte) []Event {
+	d := &s.dirs[dir]
+	var events []Event
+	for len(data) > 0 && !d.done {
+		if d.http2 != nil {
+			return append(events, s.feedHTTP2(dir, data)...)
+		}
+		if d.skip > 0 {
+			n := min(d.skip, len(data))
+			d.skip -= n
+			data = data[n:]
+			continue
+		}
+
+		n := min(s.maxBytes-len(d.buf), len(data))
+		d.buf = append(d.buf, data[:n]...)
+		data = data[n:]
+		events = append(events, s.drain(dir)...)
+		if len(d.buf) >= s.maxBytes {
+			events = append(events, s.End(dir)...)
+		}
+	}
+	return events
+}
+
+// drain parses the complete messages in a direction's buffer, leaving an
+// incomplete one buffered
+func (s *Stream) drain(dir Direction) []Event {
+	d := &s.dirs[dir]
+	var events []Event
+	for len(d.buf) > 0 && !d.done {
+		switch {
+		case d.skip > 0:
+			n := min(d.skip, len(d.buf))
+			d.skip -= n
+			d.buf = d.buf[n:]
+		case d.http2 != nil:
+			// An HTTP/1.1 connection upgraded to HTTP/2
+			data := d.buf
+			d.buf = nil
+			events = append(events, s.feedHTTP2(dir, data)...)
+		case messageComplete(d.buf):
+			events = append(events, s.next(dir)...)
+		default:
+			return events
+		}
+	}
+	return events
+}
+
+// Gap tells the stream data of a direction was lost. A message that was
+// being buffered is parsed as far as it got, and the direction ends, as
+// what follows cannot be told apart from the middle of a message. A gap
+// before any data, as when a capture starts mid-connection, is ignored.
+func (s *Stream) Gap(dir Direction) []Event {
+	d := &s.dirs[dir]
+	if len(d.buf) == 0 && !d.started {
+		return nil
+	}
+	return s.End(dir)
+}
+
+// End tells the stream a direction closed and returns the message it was
+// buffering, parsed as far as it got
+func (s *Stream) End(dir Direction) []Event {
+	d := &s.dirs[dir]
+	var events []Event
+	if !d.done && d.http2 == nil && d.skip == 0 && len(d.buf) > 0 {
+		if info := s.parse(d.buf); info != nil {
+			events = append(events, Event{Direction: dir, Info: info})
+		}
+	}
+	d.buf = nil
+	d.http2 = nil
+	d.done = true
+	return events
+}
+
+// next parses the complete message at the start of a direction's buffer
+// and prepares for the one after it
+func (s *Stream) next(dir Direction) []Event {
+	d := &s.dirs[dir]
+	data := d.buf
+	d.buf = nil
+	d.started = true
+	info := s.parse(data)
+	if info == nil {
+		d.done = true
+		return nil
+	}
+	events := []Event{{Direction: dir, Info: info}}
+
+	switch info.Protocol {
+	case "HTTP/1.1":
+		length, ok := http1BodyLength(info)
+		if !ok {
+			d.done = true
+			break
+		}
+		d.buf = data[bytes.Index(data, []byte("\r\n\r\n"))+4:]
+		d.skip = length
+	case "HTTP/2":
+		// The decoder reads the blocks from the start again to build the
+		// HPACK table; the first one was reported already. A header
+		// block is held to the size of a message.
+		d.http2 = newHTTP2Decoder(s.maxBytes)
+		blocks, err := d.http2.Decode(data)
+		if err != nil {
+			d.http2, d.done = nil, true
+		}
+		for i := 1; i < len(blocks); i++ {
+			events = append(events, s.http2Event(dir, blocks[i]))
+		}
+	default:
+		d.done = true
+	}
+	return events
+}
+
+// parse runs the parser over a message, returning nil for data it does
+// not recognize
+func (s *Stream) parse(data []byte) *ProtocolInfo {
+	info, err := s.parser.ParsePacket(data)
+	if err != nil || info.Protocol == "Unknown" {
+		return nil
+	}
+	// The stream's buffer is reused
+	info.RawData = nil
+	return info
+}
+
+// feedHTTP2 passes data to the HTTP/2 decoder of a direction
+func (s *Stream) feedHTTP2(dir Direction, data []byte) []Event {
+	d := &s.dirs[dir]
+	blocks, err := d.http2.Decode(data)
+	var events []Event
+	for _, block := range blocks {
+		events = append(events, s.http2Event(dir, block))
+	}
+	// The decoder holds one frame at most, unless the peer raised the
+	// frame size or the stream is not HTTP/2 after all
+	if err != nil || len(d.http2.pending) > s.maxBytes+http2FrameHeaderSize+http2DefaultMaxFrameSize {
+		d.http2, d.done = nil, true
+	}
+	return events
+}
+
+// http2Event makes the event of a header block after the first
+func (s *Stream) http2Event(dir Direction, block HTTP2HeaderBlock) Event {
+	info := &ProtocolInfo{
+		Protocol: "HTTP/2",
+		Version:  "HTTP/2",
+		Features: map[string]interface{}{"stream_id": block.StreamID},
+	}
+	s.parser.http2Block(info, block, nil)
+	return Event{Direction: dir, Info: info}
+}
+
+// http1BodyLength returns the length of the body following an HTTP/1.x
+// message's header, and false when it cannot be told without parsing the
+// body: chunked bodies, and responses read until the connection closes
+func http1BodyLength(info *ProtocolInfo) (int, bool) {
+	var length string
+	for name, value := range info.Headers {
+		switch {
+		case strings.EqualFold(name, "Transfer-Encoding") && !strings.EqualFold(value, "identity"):
+			return 0, false
+		case strings.EqualFold(name, "Content-Length"):
+			length = value
+		}
+	}
+	if length == "" {
+		// Requests without a length have no body
+		return 0, info.Method != ""
+	}
+	n, err := strconv.Atoi(length)
+	return n, err == nil && n >= 0
+}
+
+// messageComplete reports whether buf holds a complete first message for
+// the protocols the parser understands. Text protocols end their header
+// with an empty line, HTTP/2 with a header block flagged END_HEADERS, SSH
+// with the first binary packet after the identification string, RDP with
+// its TPKT packet, VNC with its version message, and TLS handshake
+// messages carry their length, over as many records as they span;
+// anything else is parsed once there is enough of it for the parser to
+// look at.
+func messageComplete(buf []byte) bool {
+	if len(buf) >= 5 && buf[0] == 0x16 {
+		return TLSHandshakeComplete(buf)
+	}
+	if IsTPKT(buf) {
+		return TPKTComplete(buf)
+	}
+	if IsRFB(buf) {
+		return len(buf) >= rfbBannerSize
+	}
+	if bytes.HasPrefix(buf, []byte("SSH-")) {
+		return SSHHandshakeComplete(buf)
+	}
+	if IsHTTP2(buf) {
+		return HTTP2HeadersComplete(buf)
+	}
+	if looksLikeText(buf) {
+		return bytes.Contains(buf, []byte("\r\n\r\n"))
+	}
+	return len(buf) >= 20
+}
+
+// looksLikeText reports whether buf starts like an HTTP/1.x or HTTP/2
+// preface line: an upper-case token followed by a space or slash
+func looksLikeText(buf []byte) bool {
+	for i, c := range buf {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			continue
+		case (c == ' ' || c == '/') && i > 0:
+			return true
+		}
+		return false
+	}
+	// Too short to tell yet
+	return true
+}
@@ -0,0 +1,193 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+// feedBytes feeds data to a direction of a stream a few bytes at a time,
+// as segments of a connection arrive
+func feedBytes(stream *Stream, dir Direction, data []byte, size int) []Event {
+	var events []Event
+	for i := 0; i < len(data); i += size {
+		events = append(events, stream.Feed(dir, data[i:min(i+size, len(data))])...)
+	}
+	return events
+}
+
+func TestStreamHTTP11(t *testing.T) {
+	stream := NewParser().NewStream(16 * 1024)
+	requests := "POST /login HTTP/1.1\r\nHost: example.com\r\nContent-Length: 11\r\n\r\nuser=alice&" +
+		"GET /index.html HTTP/1.1\r\nHost: example.com\r\nUser-Agent: curl/8.0\r\n\r\n"
+
+	events := feedBytes(stream, ClientToServer, []byte(requests), 5)
+	require.Len(t, events, 2)
+	assert.Equal(t, ClientToServer, events[0].Direction)
+	assert.Equal(t, "POST", events[0].Info.Method)
+	assert.Equal(t, "/login", events[0].Info.Path)
+	assert.Equal(t, "GET", events[1].Info.Method)
+	assert.Equal(t, "curl/8.0", events[1].Info.UserAgent)
+	assert.Nil(t, events[1].Info.RawData)
+
+	// The response's chunked body cannot be skipped, so it is the last
+	// message of its direction
+	responses := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n" +
+		"HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"
+	events = stream.Feed(ServerToClient, []byte(responses))
+	require.Len(t, events, 1)
+	assert.Equal(t, ServerToClient, events[0].Direction)
+	assert.Equal(t, "HTTP/1.1", events[0].Info.Version)
+	assert.Equal(t, 200, events[0].Info.StatusCode)
+	assert.Empty(t, stream.Feed(ServerToClient, []byte(responses)))
+}
+
+func TestStreamHTTP2(t *testing.T) {
+	client := newHTTP2Connection(true)
+	client.headers(t, 1, true,
+		":method", "GET", ":scheme", "https", ":authority", "example.com", ":path", "/index.html",
+		"user-agent", "python-httpx/0.27")
+	client.headers(t, 3, false,
+		":method", "POST", ":scheme", "https", ":authority", "example.com", ":path", "/api",
+		"user-agent", "python-httpx/0.27")
+	data := append([]byte(nil), client.buf.Bytes()...)
+
+	stream := NewParser().NewStream(16 * 1024)
+	events := feedBytes(stream, ClientToServer, data, 7)
+	require.Len(t, events, 2)
+	assert.Equal(t, "/index.html", events[0].Info.Path)
+	assert.Equal(t, 1, events[0].Info.Features["header_blocks"])
+	assert.Equal(t, uint32(1), events[0].Info.StreamID)
+
+	// Later blocks decode against the HPACK table the earlier ones built
+	client.buf.Reset()
+	client.headers(t, 5, false,
+		":method", "POST", ":scheme", "https", ":authority", "example.com", ":path", "/api",
+		"user-agent", "python-httpx/0.27")
+	events = append(events, stream.Feed(ClientToServer, client.buf.Bytes())...)
+	require.Len(t, events, 3)
+	for _, event := range events[1:] {
+		assert.Equal(t, "HTTP/2", event.Info.Protocol)
+		assert.Equal(t, "POST", event.Info.Method)
+		assert.Equal(t, "/api", event.Info.Path)
+		assert.Equal(t, "python-httpx/0.27", event.Info.UserAgent)
+	}
+	assert.Equal(t, uint32(5), events[2].Info.Features["stream_id"])
+	assert.Equal(t, uint32(5), events[2].Info.StreamID)
+}
+
+func TestStreamHTTP2HeaderBlockLimit(t *testing.T) {
+	client := newHTTP2Connection(true)
+	client.headers(t, 1, false, ":method", "GET", ":path", "/", "user-agent", "curl/8.0")
+	require.NoError(t, client.framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 3, BlockFragment: []byte{0x82}}))
+
+	stream := NewParser().NewStream(4096)
+	require.Len(t, stream.Feed(ClientToServer, client.buf.Bytes()), 1)
+
+	// Endless CONTINUATION frames end decoding once the block outgrows the
+	// stream's message size
+	fragment := make([]byte, 1024)
+	for i := 0; i < 8; i++ {
+		client.buf.Reset()
+		require.NoError(t, client.framer.WriteContinuation(3, false, fragment))
+		assert.Empty(t, stream.Feed(ClientToServer, client.buf.Bytes()))
+	}
+	assert.Nil(t, stream.dirs[ClientToServer].http2)
+	assert.True(t, stream.dirs[ClientToServer].done)
+}
+
+func TestStreamHandshake(t *testing.T) {
+	// An SSH identification string and KEXINIT split across segments
+	// are parsed once, when the packet is complete
+	stream := NewParser().NewStream(16 * 1024)
+	ssh := append([]byte("SSH-2.0-OpenSSH_9.6\r\n"), 0, 0, 0, 12, 4, 20)
+	ssh = append(ssh, make([]byte, 10)...)
+	assert.Empty(t, stream.Feed(ClientToServer, ssh[:10]))
+	assert.Empty(t, stream.Feed(ClientToServer, ssh[10:25]))
+	events := stream.Feed(ClientToServer, ssh[25:])
+	require.Len(t, events, 1)
+	assert.Equal(t, "SSH", events[0].Info.Protocol)
+
+	// Encrypted data after the handshake is not parsed
+	assert.Empty(t, stream.Feed(ClientToServer, []byte("GET / HTTP/1.1\r\n\r\n")))
+}
+
+func TestStreamEnd(t *testing.T) {
+	request := []byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\n")
+
+	// A direction ending mid-message is parsed as far as it got
+	stream := NewParser().NewStream(16 * 1024)
+	assert.Empty(t, stream.Feed(ClientToServer, request))
+	events := stream.End(ClientToServer)
+	require.Len(t, events, 1)
+	assert.Equal(t, "/index.html", events[0].Info.Path)
+	assert.Empty(t, stream.Feed(ClientToServer, []byte("\r\n")))
+
+	// So is one losing data, unless it had not started
+	stream = NewParser().NewStream(16 * 1024)
+	assert.Empty(t, stream.Gap(ClientToServer))
+	assert.Empty(t, stream.Feed(ClientToServer, request))
+	require.Len(t, stream.Gap(ClientToServer), 1)
+
+	// And one outgrowing the buffer
+	stream = NewParser().NewStream(32)
+	events = stream.Feed(ClientToServer, request)
+	require.Len(t, events, 1)
+	assert.Equal(t, "GET", events[0].Info.Method)
+	assert.Empty(t, stream.End(ClientToServer))
+}
+
+func TestMessageComplete(t *testing.T) {
+	assert.False(t, messageComplete([]byte("GET / HTTP/1.1\r\nHost: a\r\n")))
+	assert.True(t, messageComplete([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n")))
+
+	// HTTP/2 waits past the preface for the first header block
+	assert.False(t, messageComplete([]byte(HTTP2Preface)))
+	headers := []byte{0, 0, 1, 0x1, 0x4, 0, 0, 0, 1, 0x82}
+	assert.True(t, messageComplete(append([]byte(HTTP2Preface), headers...)))
+
+	// TLS handshake messages are complete once their declared length has
+	// arrived, over as many records as they span
+	record := []byte{0x16, 0x03, 0x01, 0x00, 0x04, 1, 0, 0, 2}
+	assert.False(t, messageComplete(record[:7]))
+	assert.False(t, messageComplete(record))
+	record = append(record, 0x16, 0x03, 0x01, 0x00, 0x02, 0xaa)
+	assert.False(t, messageComplete(record))
+	assert.True(t, messageComplete(append(record, 0xbb)))
+
+	// SSH waits past the identification string for the KEXINIT packet
+	ssh := []byte("SSH-2.0-OpenSSH_9.6\r\n")
+	assert.False(t, messageComplete(ssh))
+	ssh = append(ssh, 0, 0, 0, 12, 4, 20)
+	assert.False(t, messageComplete(ssh))
+	assert.True(t, messageComplete(append(ssh, make([]byte, 10)...)))
+
+	// RDP and VNC open with short messages
+	confirm := []byte{3, 0, 0, 19, 14, 0xd0, 0, 0, 0x12, 0x34, 0, 2, 0, 8, 0, 2, 0, 0, 0}
+	assert.False(t, messageComplete(confirm[:12]))
+	assert.True(t, messageComplete(confirm))
+	assert.False(t, messageComplete([]byte("RFB 003.0")))
+	assert.True(t, messageComplete([]byte("RFB 003.008\n")))
+
+	assert.False(t, messageComplete([]byte{0x00, 0x01}))
+	assert.True(t, messageComplete(make([]byte, 20)))
+}
+
+func FuzzStream(f *testing.F) {
+	f.Add([]byte("POST /a HTTP/1.1\r\nContent-Length: 2\r\n\r\nabGET /b HTTP/1.1\r\n\r\n"), []byte("HTTP/1.1 200 OK\r\n\r\n"), 3)
+	f.Add([]byte("SSH-2.0-Go\r\n"), []byte("SSH-2.0-OpenSSH_9.6\r\n"), 1)
+
+	f.Fuzz(func(t *testing.T, client, server []byte, size int) {
+		stream := NewParser().NewStream(256)
+		size = max(1, min(size, 64))
+		for _, event := range append(feedBytes(stream, ClientToServer, client, size), feedBytes(stream, ServerToClient, server, size)...) {
+			if event.Info == nil || event.Info.Protocol == "Unknown" {
+				t.Fatalf("event without a recognized message: %+v", event)
+			}
+		}
+		stream.Gap(ClientToServer)
+		stream.End(ServerToClient)
+	})
+}
@@ -0,0 +1,267 @@
+package protocol
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TLS record content and handshake message types
+const (
+	tlsRecordHandshake   = 0x16
+	tlsClientHello       = 1
+	tlsServerHello       = 2
+	tlsCertificate       = 11
+	tlsServerHelloDone   = 14
+	tlsRecordHeaderSize  = 5
+	tlsHandshakeHeadSize = 4
+)
+
+// TLS extension types read from ClientHellos
+const (
+	tlsExtServerName        = 0
+	tlsExtSupportedGroups   = 10
+	tlsExtECPointFormats    = 11
+	tlsExtALPN              = 16
+	tlsExtSupportedVersions = 43
+)
+
+// tlsVersion13 is the version TLS 1.3 negotiates in supported_versions
+const tlsVersion13 = 0x0304
+
+// ClientHello holds what a TLS client offers in its ClientHello, in the
+// order it offers it. Clients differ in these far more than in anything
+// later in the connection, which makes them a fingerprint of the library
+// behind a connection. GREASE values are kept as sent.
+type ClientHello struct {
+	Version           uint16   `json:"version"` // legacy_version, 0x0303 for TLS 1.2 and 1.3
+	CipherSuites      []uint16 `json:"cipher_suites"`
+	Extensions        []uint16 `json:"extensions"` // extension types
+	ServerName        string   `json:"server_name,omitempty"`
+	ALPN              []string `json:"alpn,omitempty"`
+	SupportedVersions []uint16 `json:"supported_versions,omitempty"`
+	SupportedGroups   []uint16 `json:"supported_groups,omitempty"`
+	PointFormats      []uint8  `json:"point_formats,omitempty"` // EC point formats
+}
+
+// JA3 returns the JA3 fingerprint of the hello: the MD5 hash, in hex, of
+// its version, cipher suites, extensions, groups and point formats in the
+// order offered, GREASE values left out. Threat intelligence feeds list
+// the fingerprints of malware and bot libraries in this form.
+func (h *ClientHello) JA3() string {
+	points := make([]uint16, len(h.PointFormats))
+	for i, format := range h.PointFormats {
+		points[i] = uint16(format)
+	}
+	fields := []string{strconv.Itoa(int(h.Version))}
+	for _, values := range [][]uint16{h.CipherSuites, h.Extensions, h.SupportedGroups, points} {
+		var list []string
+		for _, v := range values {
+			if !isGREASE(v) {
+				list = append(list, strconv.Itoa(int(v)))
+			}
+		}
+		fields = append(fields, strings.Join(list, "-"))
+	}
+	sum := md5.Sum([]byte(strings.Join(fields, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// MaxVersion returns the highest TLS version the client supports: the
+// highest supported version other than GREASE, or the legacy version when
+// the client sent none
+func (h *ClientHello) MaxVersion() uint16 {
+	max := uint16(0)
+	for _, v := range h.SupportedVersions {
+		if !isGREASE(v) && v > max {
+			max = v
+		}
+	}
+	if max == 0 {
+		return h.Version
+	}
+	return max
+}
+
+// HasGREASE reports whether the client sent GREASE cipher suites,
+// extensions or versions, as browsers do and most bot libraries do not
+func (h *ClientHello) HasGREASE() bool {
+	for _, values := range [][]uint16{h.CipherSuites, h.Extensions, h.SupportedVersions} {
+		for _, v := range values {
+			if isGREASE(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isGREASE reports whether v is one of the values RFC 8701 reserves for
+// clients to send at random
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// tlsMessage is a handshake message
+type tlsMessage struct {
+	msgType uint8
+	body    []byte
+}
+
+// tlsMessages returns the complete handshake messages at the start of a
+// TLS stream, joining fragments split over several records. end reports
+// whether a record other than a handshake record follows them, after which
+// no more plaintext handshake messages come; err whether a record or
+// message was cut short or carries a version other than 3.x.
+func tlsMessages(data []byte) (messages []tlsMessage, end bool, err error) {
+	var fragments []byte
+	r := &reader{data: data}
+	for !r.empty() {
+		if r.data[0] != tlsRecordHandshake {
+			end = true
+			break
+		}
+		r.uint8() // content type
+		if version := r.uint16(); version>>8 != 3 && err == nil {
+			err = fmt.Errorf("tls record version %#04x: %w", version, ErrMalformed)
+		}
+		record := r.vector16()
+		if r.err != nil {
+			err = fmt.Errorf("tls record: %w", r.err)
+			break
+		}
+		fragments = append(fragments, record.data...)
+	}
+
+	hr := &reader{data: fragments}
+	for len(hr.data) >= tlsHandshakeHeadSize {
+		msgType := hr.uint8()
+		body := hr.bytes(hr.uint24())
+		if body == nil {
+			break
+		}
+		messages = append(messages, tlsMessage{msgType, body})
+	}
+	if err == nil && (hr.err != nil || !hr.empty()) {
+		err = fmt.Errorf("tls handshake message: %w", ErrTruncated)
+	}
+	return messages, end, err
+}
+
+// TLSHandshakeComplete reports whether data, the start of a TLS stream,
+// holds the handshake messages the parser reads: the first one, and after
+// a ServerHello the messages up to the server certificate. Once records
+// other than handshake records arrive, as they do right after the
+// ServerHello in TLS 1.3 unless the stream is a decrypted mirror, there
+// is nothing more to wait for.
+func TLSHandshakeComplete(data []byte) bool {
+	messages, end, _ := tlsMessages(data)
+	if len(messages) == 0 || messages[0].msgType != tlsServerHello {
+		return len(messages) > 0 || end
+	}
+	for _, message := range messages[1:] {
+		if message.msgType == tlsCertificate || message.msgType == tlsServerHelloDone {
+			return true
+		}
+	}
+	return end
+}
+
+// parseClientHello parses the body of a ClientHello handshake message.
+// Malformed extensions and trailing data leave the hello returned with the
+// error.
+func parseClientHello(body []byte) (*ClientHello, error) {
+	r := &reader{data: body}
+	hello := &ClientHello{Version: r.uint16()}
+	r.bytes(32) // random
+	r.vector8() // legacy session ID
+	suites := r.vector16()
+	for !suites.empty() {
+		hello.CipherSuites = append(hello.CipherSuites, suites.uint16())
+	}
+	r.vector8() // legacy compression methods
+	if r.err != nil || suites.err != nil {
+		return nil, fmt.Errorf("tls client hello: %w", ErrTruncated)
+	}
+
+	// Extensions are optional in TLS 1.2 and before
+	if r.empty() {
+		return hello, nil
+	}
+	var malformed error
+	extensions := r.vector16()
+	for !extensions.empty() {
+		extType := extensions.uint16()
+		ext := extensions.vector16()
+		if extensions.err != nil {
+			break
+		}
+		hello.Extensions = append(hello.Extensions, extType)
+
+		var list *reader
+		switch extType {
+		case tlsExtServerName:
+			list = ext.vector16()
+			for !list.empty() {
+				nameType := list.uint8()
+				name := list.vector16()
+				if nameType == 0 && name.err == nil {
+					hello.ServerName = string(name.data)
+				}
+			}
+		case tlsExtSupportedGroups:
+			list = ext.vector16()
+			for !list.empty() {
+				hello.SupportedGroups = append(hello.SupportedGroups, list.uint16())
+			}
+		case tlsExtECPointFormats:
+			list = ext.vector8()
+			for !list.empty() {
+				hello.PointFormats = append(hello.PointFormats, list.uint8())
+			}
+		case tlsExtALPN:
+			list = ext.vector16()
+			for !list.empty() {
+				if proto := list.vector8(); proto.err == nil {
+					hello.ALPN = append(hello.ALPN, string(proto.data))
+				}
+			}
+		case tlsExtSupportedVersions:
+			list = ext.vector8()
+			for !list.empty() {
+				hello.SupportedVersions = append(hello.SupportedVersions, list.uint16())
+			}
+		default:
+			continue
+		}
+		if err := checkAll(list, ext); err != nil && malformed == nil {
+			malformed = fmt.Errorf("tls client hello extension %d: %w", extType, err)
+		}
+	}
+	if r.err != nil || extensions.err != nil {
+		return nil, fmt.Errorf("tls client hello extensions: %w", ErrTruncated)
+	}
+	if malformed == nil && !r.empty() {
+		malformed = fmt.Errorf("tls client hello: %w", ErrTrailingData)
+	}
+
+	return hello, malformed
+}
+
+// clientHelloFeatures summarizes a ClientHello as protocol features
+func clientHelloFeatures(hello *ClientHello, features map[string]interface{}) {
+	features["cipher_suite_count"] = len(hello.CipherSuites)
+	features["extension_count"] = len(hello.Extensions)
+	features["max_version"] = hello.MaxVersion()
+	features["has_grease"] = hello.HasGREASE()
+	features["has_sni"] = hello.ServerName != ""
+	features["ja3"] = hello.JA3()
+	if hello.ServerName != "" {
+		features["sni"] = hello.ServerName
+	}
+	if len(hello.ALPN) > 0 {
+		features["alpn"] = strings.Join(hello.ALPN, ",")
+	}
+}
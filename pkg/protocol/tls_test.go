@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordClientHello returns the first flight crypto/tls sends as a client
+func recordClientHello(t testing.TB, config *tls.Config) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, config).Handshake()
+		client.Close()
+	}()
+
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, 5)
+	_, err := io.ReadFull(server, header)
+	require.NoError(t, err)
+	body := make([]byte, int(header[3])<<8|int(header[4]))
+	_, err = io.ReadFull(server, body)
+	require.NoError(t, err)
+	return append(header, body...)
+}
+
+func TestParseClientHello(t *testing.T) {
+	data := recordClientHello(t, &tls.Config{
+		ServerName: "example.com",
+		NextProtos: []string{"h2", "http/1.1"},
+		MinVersion: tls.VersionTLS12,
+	})
+
+	info, err := NewParser().ParsePacket(data)
+	require.NoError(t, err)
+	assert.Equal(t, "TLS", info.Protocol)
+	hello := info.ClientHello
+	require.NotNil(t, hello)
+	assert.Equal(t, uint16(tls.VersionTLS12), hello.Version)
+	assert.Equal(t, "example.com", hello.ServerName)
+	assert.Equal(t, []string{"h2", "http/1.1"}, hello.ALPN)
+	assert.Equal(t, []uint16{tls.VersionTLS13, tls.VersionTLS12}, hello.SupportedVersions)
+	assert.Equal(t, uint16(tls.VersionTLS13), hello.MaxVersion())
+	assert.Contains(t, hello.CipherSuites, tls.TLS_AES_128_GCM_SHA256)
+	assert.Contains(t, hello.Extensions, uint16(tlsExtServerName))
+	assert.Contains(t, hello.Extensions, uint16(tlsExtALPN))
+	assert.False(t, hello.HasGREASE())
+
+	assert.Equal(t, uint8(tlsClientHello), info.Features["handshake_type"])
+	assert.Equal(t, "example.com", info.Features["sni"])
+	assert.Equal(t, "h2,http/1.1", info.Features["alpn"])
+	assert.Equal(t, len(hello.CipherSuites), info.Features["cipher_suite_count"])
+	assert.Equal(t, uint16(tls.VersionTLS13), info.Features["max_version"])
+
+	// A hello split over two records parses the same
+	fragment := data[5:40]
+	rest := data[40:]
+	split := append([]byte{0x16, 0x03, 0x01, 0x00, byte(len(fragment))}, fragment...)
+	split = append(split, 0x16, 0x03, 0x01, byte(len(rest)>>8), byte(len(rest)))
+	split = append(split, rest...)
+	assert.False(t, TLSHandshakeComplete(split[:len(split)-1]))
+	assert.True(t, TLSHandshakeComplete(split))
+	info, err = NewParser().ParsePacket(split)
+	require.NoError(t, err)
+	assert.Equal(t, hello, info.ClientHello)
+
+	// Truncated hellos leave the record features
+	info, err = NewParser().ParsePacket(data[:len(data)-10])
+	require.NoError(t, err)
+	assert.Nil(t, info.ClientHello)
+	assert.Equal(t, uint8(0x16), info.Features["content_type"])
+}
+
+func TestClientHelloGREASE(t *testing.T) {
+	body := []byte{0x03, 0x03}
+	body = append(body, make([]byte, 32)...)
+	body = append(body, 0)                                      // session ID
+	body = append(body, 0, 4, 0x1a, 0x1a, 0x13, 0x01)           // cipher suites
+	body = append(body, 1, 0)                                   // compression
+	body = append(body, 0, 15)                                  // extensions
+	body = append(body, 0x3a, 0x3a, 0, 0)                       // GREASE extension
+	body = append(body, 0, 43, 0, 7, 6, 0x2a, 0x2a, 3, 4, 3, 3) // supported versions
+
+	hello, err := parseClientHello(body)
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{0x1a1a, 0x1301}, hello.CipherSuites)
+	assert.Equal(t, []uint16{0x3a3a, 43}, hello.Extensions)
+	assert.True(t, hello.HasGREASE())
+	assert.Equal(t, uint16(0x0304), hello.MaxVersion())
+
+	// Extensions running past the message are rejected
+	body[len(body)-12]++
+	_, err = parseClientHello(body)
+	assert.ErrorIs(t, err, ErrTruncated)
+
+	assert.True(t, isGREASE(0xfafa))
+	assert.False(t, isGREASE(0x0a1a))
+}
+
+func TestClientHelloJA3(t *testing.T) {
+	body := []byte{0x03, 0x03}
+	body = append(body, make([]byte, 32)...)
+	body = append(body, 0)                                           // session ID
+	body = append(body, 0, 6, 0x0a, 0x0a, 0x13, 0x01, 0x13, 0x02)    // cipher suites
+	body = append(body, 1, 0)                                        // compression
+	body = append(body, 0, 22)                                       // extensions
+	body = append(body, 0x1a, 0x1a, 0, 0)                            // GREASE extension
+	body = append(body, 0, 10, 0, 8, 0, 6, 0x2a, 0x2a, 0, 29, 0, 23) // supported groups
+	body = append(body, 0, 11, 0, 2, 1, 0)                           // point formats
+
+	hello, err := parseClientHello(body)
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{0x2a2a, 29, 23}, hello.SupportedGroups)
+	assert.Equal(t, []uint8{0}, hello.PointFormats)
+	// MD5 of "771,4865-4866,10-11,29-23,0"
+	assert.Equal(t, "8150a3a1f3293b354572405efc20ad75", hello.JA3())
+
+	features := make(map[string]interface{})
+	clientHelloFeatures(hello, features)
+	assert.Equal(t, hello.JA3(), features["ja3"])
+}
+
+func FuzzParseClientHello(f *testing.F) {
+	record := recordClientHello(f, &tls.Config{ServerName: "example.com", NextProtos: []string{"h2"}})
+	f.Add(record[5+tlsHandshakeHeadSize:])
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		hello, err := parseClientHello(body)
+		assertTypedError(t, err)
+		if err == nil && hello == nil {
+			t.Fatal("no hello and no error")
+		}
+	})
+}
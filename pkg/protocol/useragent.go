@@ -0,0 +1,151 @@
+package protocol
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Browser engines, which decide what a browser's TLS and HTTP traffic
+// looks like whatever its brand
+const (
+	EngineBlink   = "Blink"   // Chrome, Edge, Opera, Samsung Internet
+	EngineGecko   = "Gecko"   // Firefox
+	EngineWebKit  = "WebKit"  // Safari, and every browser on iOS
+	EngineTrident = "Trident" // Internet Explorer
+)
+
+// UserAgent is the client a User-Agent header claims to be
+type UserAgent struct {
+	Family string `json:"family"` // "Chrome", "Firefox", "curl", ...
+	Major  int    `json:"major,omitempty"`
+	Engine string `json:"engine,omitempty"` // of browsers only
+	OS     string `json:"os,omitempty"`
+	Mobile bool   `json:"mobile,omitempty"`
+	// Automation marks HTTP libraries, command line tools, headless
+	// browsers and self-declared crawlers
+	Automation bool `json:"automation,omitempty"`
+}
+
+// Browser reports whether the User-Agent claims an interactive browser
+func (a *UserAgent) Browser() bool {
+	return a.Engine != "" && !a.Automation
+}
+
+// userAgentLibraries are the product tokens of HTTP libraries and tools,
+// by the family they are reported as
+var userAgentLibraries = []struct{ token, family string }{
+	{"curl/", "curl"},
+	{"wget/", "Wget"},
+	{"python-requests/", "python-requests"},
+	{"python-urllib", "urllib"},
+	{"python-httpx/", "httpx"},
+	{"aiohttp/", "aiohttp"},
+	{"scrapy/", "Scrapy"},
+	{"go-http-client/", "Go"},
+	{"okhttp/", "OkHttp"},
+	{"apache-httpclient/", "Apache HttpClient"},
+	{"java/", "Java"},
+	{"libwww-perl/", "libwww-perl"},
+	{"node-fetch", "node-fetch"},
+	{"axios/", "axios"},
+	{"undici", "undici"},
+	{"powershell/", "PowerShell"},
+}
+
+// userAgentBrowsers are the product tokens of browsers, most specific
+// first: Chromium derivatives name Chrome and Safari as well, Chrome
+// names Safari, and browsers on iOS use WebKit whatever their brand
+var userAgentBrowsers = []struct{ token, family, engine string }{
+	{"HeadlessChrome/", "HeadlessChrome", EngineBlink},
+	{"EdgiOS/", "Edge", EngineWebKit},
+	{"EdgA/", "Edge", EngineBlink},
+	{"Edg/", "Edge", EngineBlink},
+	{"OPR/", "Opera", EngineBlink},
+	{"SamsungBrowser/", "Samsung Internet", EngineBlink},
+	{"CriOS/", "Chrome", EngineWebKit},
+	{"FxiOS/", "Firefox", EngineWebKit},
+	{"Firefox/", "Firefox", EngineGecko},
+	{"Chromium/", "Chromium", EngineBlink},
+	{"Chrome/", "Chrome", EngineBlink},
+	{"Version/", "Safari", EngineWebKit},
+	{"MSIE ", "Internet Explorer", EngineTrident},
+	{"Trident/", "Internet Explorer", EngineTrident},
+}
+
+// userAgentSystems are the operating system tokens, iOS before macOS as
+// its browsers claim to be "like Mac OS X", Android before Linux
+var userAgentSystems = []struct{ token, os string }{
+	{"Windows", "Windows"},
+	{"iPhone", "iOS"},
+	{"iPad", "iOS"},
+	{"iPod", "iOS"},
+	{"Mac OS X", "macOS"},
+	{"Macintosh", "macOS"},
+	{"Android", "Android"},
+	{"CrOS", "ChromeOS"},
+	{"Linux", "Linux"},
+}
+
+// ParseUserAgent returns the client a User-Agent header value claims to
+// be, or nil for an empty one. Values naming no known client are reported
+// by their first product token.
+func ParseUserAgent(value string) *UserAgent {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	agent := &UserAgent{}
+	lower := strings.ToLower(value)
+
+	for _, library := range userAgentLibraries {
+		if i := strings.Index(lower, library.token); i >= 0 {
+			agent.Family = library.family
+			agent.Major = majorVersion(value[i+len(library.token):])
+			agent.Automation = true
+			return agent
+		}
+	}
+
+	for _, browser := range userAgentBrowsers {
+		if browser.family == "Safari" && !strings.Contains(value, "Safari/") {
+			continue
+		}
+		if i := strings.Index(value, browser.token); i >= 0 {
+			agent.Family, agent.Engine = browser.family, browser.engine
+			agent.Major = majorVersion(value[i+len(browser.token):])
+			break
+		}
+	}
+	if agent.Family == "" {
+		product, _, _ := strings.Cut(value, " ")
+		name, version, _ := strings.Cut(product, "/")
+		agent.Family, agent.Major = name, majorVersion(version)
+	}
+	if agent.Family == "Internet Explorer" && strings.Contains(value, "Trident/7.0") {
+		// IE 11 dropped the MSIE token
+		agent.Major = 11
+	}
+
+	for _, system := range userAgentSystems {
+		if strings.Contains(value, system.token) {
+			agent.OS = system.os
+			break
+		}
+	}
+	agent.Mobile = strings.Contains(value, "Mobile") || (agent.OS == "iOS" && !strings.Contains(value, "iPad"))
+
+	agent.Automation = agent.Family == "HeadlessChrome" ||
+		strings.Contains(lower, "bot/") || strings.Contains(lower, "crawler") || strings.Contains(lower, "spider")
+	return agent
+}
+
+// majorVersion returns the leading number of a version string, 0 when
+// there is none
+func majorVersion(version string) int {
+	end := 0
+	for end < len(version) && version[end] >= '0' && version[end] <= '9' {
+		end++
+	}
+	major, _ := strconv.Atoi(version[:end])
+	return major
+}
@@ -0,0 +1,79 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		value string
+		want  UserAgent
+	}{
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36",
+			UserAgent{Family: "Chrome", Major: 126, Engine: EngineBlink, OS: "Windows"},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.2592.87",
+			UserAgent{Family: "Edge", Major: 126, Engine: EngineBlink, OS: "Windows"},
+		},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.5; rv:127.0) Gecko/20100101 Firefox/127.0",
+			UserAgent{Family: "Firefox", Major: 127, Engine: EngineGecko, OS: "macOS"},
+		},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/605.1.15",
+			UserAgent{Family: "Safari", Major: 17, Engine: EngineWebKit, OS: "macOS"},
+		},
+		{
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/126.0.6478.54 Mobile/15E148 Safari/604.1",
+			UserAgent{Family: "Chrome", Major: 126, Engine: EngineWebKit, OS: "iOS", Mobile: true},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.6478.71 Mobile Safari/537.36",
+			UserAgent{Family: "Chrome", Major: 126, Engine: EngineBlink, OS: "Android", Mobile: true},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 6.1; WOW64; Trident/7.0; rv:11.0) like Gecko",
+			UserAgent{Family: "Internet Explorer", Major: 11, Engine: EngineTrident, OS: "Windows"},
+		},
+		{
+			"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/126.0.0.0 Safari/537.36",
+			UserAgent{Family: "HeadlessChrome", Major: 126, Engine: EngineBlink, OS: "Linux", Automation: true},
+		},
+		{
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			UserAgent{Family: "Mozilla", Major: 5, Automation: true},
+		},
+		{"curl/8.4.0", UserAgent{Family: "curl", Major: 8, Automation: true}},
+		{"python-requests/2.31.0", UserAgent{Family: "python-requests", Major: 2, Automation: true}},
+		{"Go-http-client/2.0", UserAgent{Family: "Go", Major: 2, Automation: true}},
+		{"MyApp/3.1 (build 42)", UserAgent{Family: "MyApp", Major: 3}},
+	}
+
+	for _, tt := range tests {
+		agent := ParseUserAgent(tt.value)
+		require.NotNil(t, agent, tt.value)
+		assert.Equal(t, tt.want, *agent, tt.value)
+	}
+
+	assert.Nil(t, ParseUserAgent(" "))
+}
+
+func TestUserAgentBrowser(t *testing.T) {
+	assert.True(t, ParseUserAgent("Mozilla/5.0 (X11; Linux x86_64; rv:127.0) Gecko/20100101 Firefox/127.0").Browser())
+	assert.False(t, ParseUserAgent("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/126.0.0.0 Safari/537.36").Browser())
+	assert.False(t, ParseUserAgent("curl/8.4.0").Browser())
+}
+
+func TestParseHTTPUserAgent(t *testing.T) {
+	info, err := NewParser().ParsePacket([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nUser-Agent: curl/8.4.0\r\n\r\n"))
+	require.NoError(t, err)
+	require.NotNil(t, info.Agent)
+	assert.Equal(t, "curl", info.Agent.Family)
+	assert.Equal(t, "curl", info.Features["ua_family"])
+	assert.Equal(t, false, info.Features["ua_browser"])
+}
@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// RFB handshake constants (RFC 6143)
+const (
+	rfbBannerSize = 12
+
+	RFBSecurityNone = 1
+	RFBSecurityVNC  = 2
+)
+
+// rfbPrefix starts the ProtocolVersion message both sides of a VNC
+// connection open with
+var rfbPrefix = []byte("RFB ")
+
+// RFBHandshake is the ProtocolVersion message of one side of a VNC
+// connection and, when the server's list follows it, the security types
+// offered. Servers offering no authentication are what scanners look for.
+type RFBHandshake struct {
+	Major         int     `json:"major"`
+	Minor         int     `json:"minor"`
+	SecurityTypes []uint8 `json:"security_types,omitempty"`
+}
+
+// NoAuthentication reports whether the server offered the None security
+// type
+func (h *RFBHandshake) NoAuthentication() bool {
+	for _, t := range h.SecurityTypes {
+		if t == RFBSecurityNone {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRFB reports whether data starts like the ProtocolVersion message of a
+// VNC connection
+func IsRFB(data []byte) bool {
+	return bytes.HasPrefix(data, rfbPrefix)
+}
+
+// ParseRFBHandshake parses the ProtocolVersion message, "RFB xxx.yyy\n",
+// and the security types a server may send right after it. A truncated
+// list or trailing data leave the handshake returned with the error.
+func ParseRFBHandshake(data []byte) (*RFBHandshake, error) {
+	if len(data) < rfbBannerSize {
+		return nil, fmt.Errorf("rfb protocol version: %w", ErrTruncated)
+	}
+	banner := data[:rfbBannerSize]
+	major, errMajor := strconv.Atoi(string(banner[4:7]))
+	minor, errMinor := strconv.Atoi(string(banner[8:11]))
+	if !IsRFB(banner) || banner[7] != '.' || banner[11] != '\n' || errMajor != nil || errMinor != nil {
+		return nil, fmt.Errorf("rfb protocol version %q: %w", banner, ErrMalformed)
+	}
+	handshake := &RFBHandshake{Major: major, Minor: minor}
+
+	// From 3.7 servers list the security types, or refuse the connection
+	// with an empty list and the reason; a client's choice is a single byte
+	// and too short to be taken for a list
+	r := &reader{data: data[rfbBannerSize:]}
+	if len(r.data) <= 1 || (major == 3 && minor < 7) {
+		return handshake, nil
+	}
+	if count := int(r.uint8()); count > 0 {
+		types := r.bytes(count)
+		if r.err == nil {
+			handshake.SecurityTypes = append([]uint8(nil), types...)
+		}
+	} else {
+		r.bytes(int(r.uint32())) // reason
+	}
+	if err := r.check(); err != nil {
+		return handshake, fmt.Errorf("rfb security types: %w", err)
+	}
+	return handshake, nil
+}
+
+// rfbFeatures summarizes an RFB handshake as protocol features
+func rfbFeatures(handshake *RFBHandshake, features map[string]interface{}) {
+	features["rfb_version"] = fmt.Sprintf("%d.%d", handshake.Major, handshake.Minor)
+	if handshake.SecurityTypes != nil {
+		features["security_type_count"] = len(handshake.SecurityTypes)
+		features["no_authentication"] = handshake.NoAuthentication()
+	}
+}
@@ -0,0 +1,52 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRFBHandshake(t *testing.T) {
+	// The banner alone is shorter than the parser's usual minimum
+	info, err := NewParser().ParsePacket([]byte("RFB 003.008\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "VNC", info.Protocol)
+	assert.Equal(t, "RFB 3.8", info.Version)
+	assert.Equal(t, &RFBHandshake{Major: 3, Minor: 8}, info.VNC)
+	assert.Nil(t, info.Features["no_authentication"])
+
+	// Servers list their security types after the banner
+	handshake, err := ParseRFBHandshake(append([]byte("RFB 003.008\n"), 2, RFBSecurityNone, RFBSecurityVNC))
+	require.NoError(t, err)
+	assert.Equal(t, []uint8{RFBSecurityNone, RFBSecurityVNC}, handshake.SecurityTypes)
+	assert.True(t, handshake.NoAuthentication())
+
+	// A client's single-byte choice is not a list
+	handshake, err = ParseRFBHandshake(append([]byte("RFB 003.008\n"), RFBSecurityVNC))
+	require.NoError(t, err)
+	assert.Nil(t, handshake.SecurityTypes)
+
+	// Version 3.3 servers decide the security type themselves
+	handshake, err = ParseRFBHandshake(append([]byte("RFB 003.003\n"), 0, 0, 0, 2))
+	require.NoError(t, err)
+	assert.Nil(t, handshake.SecurityTypes)
+
+	for _, data := range []string{"RFB 003.00", "RFB 003-008\n", "RFB abc.def\n"} {
+		_, err := ParseRFBHandshake([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
+
+func FuzzParseRFBHandshake(f *testing.F) {
+	f.Add(append([]byte("RFB 003.008\n"), 2, RFBSecurityNone, RFBSecurityVNC))
+	f.Add(append([]byte("RFB 003.007\n"), 0, 0, 0, 0, 2, 'n', 'o'))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		handshake, err := ParseRFBHandshake(data)
+		assertTypedError(t, err)
+		if err == nil && handshake == nil {
+			t.Fatal("no handshake and no error")
+		}
+	})
+}
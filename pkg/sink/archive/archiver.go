@@ -0,0 +1,438 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Name identifies the archiver in sink health checks
+const Name = "archive"
+
+// Object types, used as metric labels
+const (
+	typeDetections = "detections"
+	typePcap       = "pcap"
+)
+
+// maxBackoff caps the delay between retries of an upload
+const maxBackoff = 10 * time.Second
+
+// dumpPattern matches the detection dumps in the spool directory
+const dumpPattern = "detections-*.ndjson.gz"
+
+var (
+	uploadsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_archive_uploads_total",
+			Help: "Total number of objects uploaded to the archive",
+		},
+		[]string{"type"},
+	)
+	uploadedBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_archive_uploaded_bytes_total",
+			Help: "Total number of bytes uploaded to the archive",
+		},
+		[]string{"type"},
+	)
+	uploadErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_archive_upload_errors_total",
+			Help: "Total number of failed uploads to the archive",
+		},
+		[]string{"type"},
+	)
+	droppedDetections = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_archive_dropped_detections_total",
+			Help: "Total number of detections dropped because the archive queue was full",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(uploadsTotal, uploadedBytes, uploadErrors, droppedDetections)
+}
+
+// dump is the gzip-compressed NDJSON detection dump of one UTC day
+type dump struct {
+	day  string // YYYYMMDD
+	file *os.File
+	gz   *gzip.Writer
+}
+
+// Archiver uploads daily detection dumps and pcapng exports of flagged
+// flows to object storage. Detections are appended to a dump of the current
+// UTC day in the spool directory, which is uploaded once the day is over;
+// a dump left behind on shutdown is resumed on the next start. The export
+// directory is scanned for new pcapng files at the configured interval.
+// Failed uploads are retried on the next scan.
+type Archiver struct {
+	config    config.ArchiveConfig
+	store     *objectStore
+	exportDir string
+	hostname  string
+	now       func() time.Time
+
+	queue     chan *cortex.DetectionResult
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// owned by the run goroutine
+	dump     *dump
+	archived map[string]time.Time // uploaded exports and their modification times
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// NewArchiver creates an archiver for the configured bucket and starts
+// archiving the detection dumps and the pcapng exports in exportDir, which
+// may be empty
+func NewArchiver(cfg config.ArchiveConfig, exportDir string) (*Archiver, error) {
+	return newArchiver(cfg, exportDir, time.Now)
+}
+
+func newArchiver(cfg config.ArchiveConfig, exportDir string, now func() time.Time) (*Archiver, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("no archive bucket configured")
+	}
+	switch cfg.Encryption {
+	case "", "AES256", "aws:kms":
+	default:
+		return nil, fmt.Errorf("unsupported archive encryption: %q", cfg.Encryption)
+	}
+	if cfg.KMSKeyID != "" && cfg.Encryption != "aws:kms" {
+		return nil, fmt.Errorf("archive kms_key_id requires aws:kms encryption")
+	}
+	if cfg.Interval < 1 || cfg.QueueSize < 1 || cfg.Timeout < 1 || cfg.MaxRetries < 0 {
+		return nil, fmt.Errorf("archive interval, queue size and timeout must be positive")
+	}
+
+	if cfg.AccessKey == "" {
+		cfg.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		if cfg.SessionToken == "" {
+			cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+		}
+	}
+	if cfg.SecretKey == "" {
+		cfg.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("no archive credentials configured")
+	}
+
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid archive endpoint: %q", cfg.Endpoint)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLSCA != "" {
+		pem, err := os.ReadFile(cfg.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCA)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	if err := os.MkdirAll(cfg.SpoolDir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create archive spool directory: %w", err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	a := &Archiver{
+		config: cfg,
+		store: &objectStore{
+			client:     &http.Client{Transport: transport, Timeout: time.Duration(cfg.Timeout) * time.Second},
+			endpoint:   endpoint,
+			bucket:     cfg.Bucket,
+			region:     cfg.Region,
+			accessKey:  cfg.AccessKey,
+			secretKey:  cfg.SecretKey,
+			token:      cfg.SessionToken,
+			pathStyle:  cfg.PathStyle,
+			encryption: cfg.Encryption,
+			kmsKeyID:   cfg.KMSKeyID,
+		},
+		exportDir: exportDir,
+		hostname:  hostname,
+		now:       now,
+		queue:     make(chan *cortex.DetectionResult, cfg.QueueSize),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+		archived:  make(map[string]time.Time),
+	}
+	go a.run()
+
+	slog.Info("Archiver started",
+		"endpoint", cfg.Endpoint,
+		"bucket", cfg.Bucket,
+		"export_dir", exportDir,
+		"spool_dir", cfg.SpoolDir)
+	return a, nil
+}
+
+// Name implements argus.Sink
+func (a *Archiver) Name() string {
+	return Name
+}
+
+// WriteDetection queues a detection result for the dump of the current
+// day, dropping it when the queue is full or the archiver is closed
+func (a *Archiver) WriteDetection(result *cortex.DetectionResult) {
+	select {
+	case <-a.closing:
+		droppedDetections.Inc()
+		return
+	default:
+	}
+	select {
+	case a.queue <- result:
+	default:
+		droppedDetections.Inc()
+	}
+}
+
+// WriteFlow implements argus.Sink. Flows are archived as pcapng exports.
+func (a *Archiver) WriteFlow(*events.FlowRecord) {}
+
+// Health returns nil while uploads succeed, or the last error
+func (a *Archiver) Health() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastErr
+}
+
+func (a *Archiver) setErr(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastErr = err
+}
+
+// Close writes the queued detections to the spooled dump and archives
+// the pending exports. The dump of the current day is uploaded after the
+// next start once the day is over.
+func (a *Archiver) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.closing)
+	})
+	<-a.done
+	return nil
+}
+
+// run archives until the archiver is closed
+func (a *Archiver) run() {
+	defer close(a.done)
+
+	a.rotate()
+	a.archiveExports()
+
+	ticker := time.NewTicker(time.Duration(a.config.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case result := <-a.queue:
+			a.writeDetection(result)
+		case <-ticker.C:
+			a.rotate()
+			if a.dump != nil {
+				a.dump.gz.Flush()
+			}
+			a.archiveExports()
+		case <-a.closing:
+			for len(a.queue) > 0 {
+				a.writeDetection(<-a.queue)
+			}
+			a.closeDump()
+			a.archiveExports()
+			return
+		}
+	}
+}
+
+// today returns the current UTC day as YYYYMMDD
+func (a *Archiver) today() string {
+	return a.now().UTC().Format("20060102")
+}
+
+// writeDetection appends a detection to the dump of the current day
+func (a *Archiver) writeDetection(result *cortex.DetectionResult) {
+	if day := a.today(); a.dump == nil || a.dump.day != day {
+		a.rotate()
+		if err := a.openDump(day); err != nil {
+			slog.Error("Failed to open detection dump", "error", err)
+			a.setErr(err)
+			droppedDetections.Inc()
+			return
+		}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		slog.Error("Failed to encode detection for the archive", "flow_id", result.FlowID, "error", err)
+		return
+	}
+	data = append(data, '\n')
+	if _, err := a.dump.gz.Write(data); err != nil {
+		slog.Error("Failed to write detection dump", "error", err)
+		a.setErr(err)
+	}
+}
+
+// openDump opens the spooled dump of a day, appending to it when an
+// earlier run left one behind. Concatenated gzip members decompress as one
+// stream.
+func (a *Archiver) openDump(day string) error {
+	path := filepath.Join(a.config.SpoolDir, "detections-"+day+".ndjson.gz")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	a.dump = &dump{day: day, file: file, gz: gzip.NewWriter(file)}
+	return nil
+}
+
+// closeDump completes the open dump, if any
+func (a *Archiver) closeDump() {
+	if a.dump == nil {
+		return
+	}
+	if err := a.dump.gz.Close(); err != nil {
+		slog.Error("Failed to complete detection dump", "error", err)
+	}
+	a.dump.file.Close()
+	a.dump = nil
+}
+
+// rotate closes the dump of a previous day and uploads every dump of a
+// previous day in the spool directory, removing those uploaded
+func (a *Archiver) rotate() {
+	today := a.today()
+	if a.dump != nil && a.dump.day != today {
+		a.closeDump()
+	}
+
+	matches, err := filepath.Glob(filepath.Join(a.config.SpoolDir, dumpPattern))
+	if err != nil {
+		return
+	}
+	for _, path := range matches {
+		day := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "detections-"), ".ndjson.gz")
+		if len(day) != 8 || day >= today {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("Failed to read detection dump", "path", path, "error", err)
+			continue
+		}
+		key := a.objectKey(a.config.DetectionPrefix, day, "detections-"+day+".ndjson.gz")
+		if a.upload(typeDetections, key, data, "application/gzip") == nil {
+			os.Remove(path)
+		}
+	}
+}
+
+// archiveExports uploads the pcapng exports not archived yet
+func (a *Archiver) archiveExports() {
+	if a.exportDir == "" {
+		return
+	}
+	matches, err := filepath.Glob(filepath.Join(a.exportDir, "*.pcapng"))
+	if err != nil {
+		return
+	}
+
+	present := make(map[string]bool, len(matches))
+	for _, path := range matches {
+		name := filepath.Base(path)
+		present[name] = true
+
+		info, err := os.Stat(path)
+		if err != nil || a.archived[name].Equal(info.ModTime()) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		key := a.objectKey(a.config.PcapPrefix, info.ModTime().UTC().Format("20060102"), name)
+		if a.upload(typePcap, key, data, "application/x-pcapng") != nil {
+			continue
+		}
+		if a.config.DeleteUploaded {
+			os.Remove(path)
+			continue
+		}
+		a.archived[name] = info.ModTime()
+	}
+
+	// Forget exports removed by the exporter's own limits
+	for name := range a.archived {
+		if !present[name] {
+			delete(a.archived, name)
+		}
+	}
+}
+
+// objectKey names an object <prefix>YYYY/MM/DD/<host>-<name>, so
+// lifecycle rules can match objects by type and date
+func (a *Archiver) objectKey(prefix, day, name string) string {
+	return fmt.Sprintf("%s%s/%s/%s/%s-%s", prefix, day[:4], day[4:6], day[6:], a.hostname, name)
+}
+
+// upload puts an object, retrying failed requests with exponential backoff
+func (a *Archiver) upload(kind, key string, data []byte, contentType string) error {
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		retriable, err := a.store.putObject(context.Background(), key, data, contentType)
+		if err == nil {
+			uploadsTotal.WithLabelValues(kind).Inc()
+			uploadedBytes.WithLabelValues(kind).Add(float64(len(data)))
+			a.setErr(nil)
+			return nil
+		}
+
+		if !retriable || attempt == a.config.MaxRetries {
+			uploadErrors.WithLabelValues(kind).Inc()
+			a.setErr(err)
+			slog.Warn("Failed to archive object", "key", key, "error", err)
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
@@ -0,0 +1,163 @@
+package clickhouse
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+)
+
+// identifierPattern matches the database and table names accepted
+// unquoted in queries
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// detectionColumns defines the detection table. Rows are ordered by time
+// so range scans over recent detections stay cheap.
+const detectionColumns = `
+	timestamp DateTime64(3, 'UTC'),
+	flow_id String,
+	is_bot Bool,
+	confidence Float64,
+	model_used LowCardinality(String),
+	reasoning String,
+	src_ip IPv6,
+	dst_ip IPv6,
+	src_port UInt16,
+	dst_port UInt16,
+	protocol LowCardinality(String),
+	src_country LowCardinality(String),
+	dst_country LowCardinality(String),
+	src_asn UInt32,
+	dst_asn UInt32,
+	features Array(Float64)`
+
+// flowColumns defines the flow table
+const flowColumns = `
+	start_time DateTime64(3, 'UTC'),
+	last_seen DateTime64(3, 'UTC'),
+	duration_ms UInt64,
+	flow_id String,
+	src_ip IPv6,
+	dst_ip IPv6,
+	src_port UInt16,
+	dst_port UInt16,
+	protocol LowCardinality(String),
+	packets UInt64,
+	bytes UInt64`
+
+// createTableQuery returns the DDL of a MergeTree table partitioned by
+// month of timeColumn, with an optional row TTL
+func createTableQuery(table, columns, timeColumn string, ttlDays int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CREATE TABLE IF NOT EXISTS %s (%s\n) ENGINE = MergeTree\n", table, columns)
+	fmt.Fprintf(&b, "PARTITION BY toYYYYMM(%s)\n", timeColumn)
+	fmt.Fprintf(&b, "ORDER BY (%s, flow_id)", timeColumn)
+	if ttlDays > 0 {
+		fmt.Fprintf(&b, "\nTTL toDateTime(%s) + INTERVAL %d DAY", timeColumn, ttlDays)
+	}
+	return b.String()
+}
+
+// detectionRow is a detection result in JSONEachRow format
+type detectionRow struct {
+	Timestamp  string    `json:"timestamp"`
+	FlowID     string    `json:"flow_id"`
+	IsBot      bool      `json:"is_bot"`
+	Confidence float64   `json:"confidence"`
+	ModelUsed  string    `json:"model_used"`
+	Reasoning  string    `json:"reasoning"`
+	SrcIP      string    `json:"src_ip"`
+	DstIP      string    `json:"dst_ip"`
+	SrcPort    uint16    `json:"src_port"`
+	DstPort    uint16    `json:"dst_port"`
+	Protocol   string    `json:"protocol"`
+	SrcCountry string    `json:"src_country"`
+	DstCountry string    `json:"dst_country"`
+	SrcASN     uint32    `json:"src_asn"`
+	DstASN     uint32    `json:"dst_asn"`
+	Features   []float64 `json:"features"`
+}
+
+func newDetectionRow(result *cortex.DetectionResult) *detectionRow {
+	row := &detectionRow{
+		Timestamp:  formatTime(result.Timestamp),
+		FlowID:     result.FlowID,
+		IsBot:      result.IsBot,
+		Confidence: result.Confidence,
+		ModelUsed:  result.ModelUsed,
+		Reasoning:  result.Reasoning,
+		SrcIP:      formatIP(result.SrcIP),
+		DstIP:      formatIP(result.DstIP),
+		SrcPort:    result.SrcPort,
+		DstPort:    result.DstPort,
+		Protocol:   result.Protocol,
+		Features:   result.Features,
+	}
+	if row.Features == nil {
+		row.Features = []float64{}
+	}
+	if geo := result.SrcGeo; geo != nil {
+		row.SrcCountry, row.SrcASN = geo.Country, geo.ASN
+	}
+	if geo := result.DstGeo; geo != nil {
+		row.DstCountry, row.DstASN = geo.Country, geo.ASN
+	}
+	return row
+}
+
+// flowRow is a completed flow record in JSONEachRow format
+type flowRow struct {
+	StartTime  string `json:"start_time"`
+	LastSeen   string `json:"last_seen"`
+	DurationMs uint64 `json:"duration_ms"`
+	FlowID     string `json:"flow_id"`
+	SrcIP      string `json:"src_ip"`
+	DstIP      string `json:"dst_ip"`
+	SrcPort    uint16 `json:"src_port"`
+	DstPort    uint16 `json:"dst_port"`
+	Protocol   string `json:"protocol"`
+	Packets    uint64 `json:"packets"`
+	Bytes      uint64 `json:"bytes"`
+}
+
+func newFlowRow(record *events.FlowRecord) *flowRow {
+	row := &flowRow{
+		StartTime: formatTime(record.StartTime),
+		LastSeen:  formatTime(record.LastSeen),
+		FlowID:    record.ID,
+		SrcIP:     formatIP(record.SrcIP),
+		DstIP:     formatIP(record.DstIP),
+		SrcPort:   record.SrcPort,
+		DstPort:   record.DstPort,
+		Protocol:  record.Protocol,
+		Packets:   record.Packets,
+		Bytes:     record.Bytes,
+	}
+	if d := record.LastSeen.Sub(record.StartTime); d > 0 {
+		row.DurationMs = uint64(d.Milliseconds())
+	}
+	return row
+}
+
+// formatTime formats a timestamp for a DateTime64(3, 'UTC') column
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UTC().Format("2006-01-02 15:04:05.000")
+}
+
+// formatIP formats an address for an IPv6 column, mapping IPv4 addresses
+func formatIP(ip net.IP) string {
+	if ip == nil {
+		return "::"
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return "::ffff:" + ip4.String()
+	}
+	return ip.String()
+}
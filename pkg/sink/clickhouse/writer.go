@@ -0,0 +1,345 @@
+package clickhouse
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Name identifies the writer in sink health checks
+const Name = "clickhouse"
+
+// maxBackoff caps the delay between retries of an insert
+const maxBackoff = 10 * time.Second
+
+// maxErrorSize bounds the error messages read from the server
+const maxErrorSize = 4096
+
+var (
+	rowsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_clickhouse_rows_total",
+			Help: "Total number of rows inserted into ClickHouse",
+		},
+		[]string{"table"},
+	)
+	deliveryErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_clickhouse_delivery_errors_total",
+			Help: "Total number of rows that could not be inserted into ClickHouse",
+		},
+		[]string{"table"},
+	)
+	droppedRows = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_clickhouse_dropped_rows_total",
+			Help: "Total number of rows dropped because the ClickHouse queue was full",
+		},
+		[]string{"table"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(rowsTotal, deliveryErrors, droppedRows)
+}
+
+// row is a JSON-encoded row queued for a table
+type row struct {
+	table string
+	data  []byte
+}
+
+// Writer inserts detection results and completed flow records into wide
+// ClickHouse tables over the HTTP interface. Rows are queued without
+// blocking and inserted in large batches, one insert per table, by a
+// background goroutine.
+type Writer struct {
+	config config.ClickHouseConfig
+	client *http.Client
+	url    string
+
+	queue     chan *row
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	tablesCreated bool // owned by the run goroutine
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// NewWriter creates a writer for the configured server and starts
+// inserting in the background
+func NewWriter(cfg config.ClickHouseConfig) (*Writer, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("no clickhouse url configured")
+	}
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return nil, fmt.Errorf("invalid clickhouse url: %w", err)
+	}
+	for _, name := range []string{cfg.Database, cfg.DetectionTable} {
+		if !identifierPattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid clickhouse identifier: %q", name)
+		}
+	}
+	if cfg.FlowTable != "" && !identifierPattern.MatchString(cfg.FlowTable) {
+		return nil, fmt.Errorf("invalid clickhouse identifier: %q", cfg.FlowTable)
+	}
+	if cfg.BatchSize < 1 || cfg.FlushInterval < 1 || cfg.QueueSize < 1 || cfg.Timeout < 1 || cfg.MaxRetries < 0 || cfg.TTLDays < 0 {
+		return nil, fmt.Errorf("clickhouse batch size, flush interval, queue size and timeout must be positive")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLSCA != "" {
+		pem, err := os.ReadFile(cfg.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read clickhouse CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCA)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	w := &Writer{
+		config:  cfg,
+		client:  &http.Client{Transport: transport, Timeout: time.Duration(cfg.Timeout) * time.Second},
+		url:     strings.TrimRight(cfg.URL, "/") + "/",
+		queue:   make(chan *row, cfg.QueueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+
+	slog.Info("ClickHouse writer started",
+		"url", cfg.URL,
+		"database", cfg.Database,
+		"detection_table", cfg.DetectionTable,
+		"flow_table", cfg.FlowTable)
+	return w, nil
+}
+
+// Name implements argus.Sink
+func (w *Writer) Name() string {
+	return Name
+}
+
+// WriteDetection queues a detection result for the detection table
+func (w *Writer) WriteDetection(result *cortex.DetectionResult) {
+	data, err := json.Marshal(newDetectionRow(result))
+	if err != nil {
+		slog.Error("Failed to encode detection for ClickHouse", "flow_id", result.FlowID, "error", err)
+		return
+	}
+	w.enqueue(&row{table: w.config.DetectionTable, data: data})
+}
+
+// WriteFlow queues a completed flow record for the flow table, if one is
+// configured
+func (w *Writer) WriteFlow(record *events.FlowRecord) {
+	if w.config.FlowTable == "" {
+		return
+	}
+	data, err := json.Marshal(newFlowRow(record))
+	if err != nil {
+		slog.Error("Failed to encode flow for ClickHouse", "flow_id", record.ID, "error", err)
+		return
+	}
+	w.enqueue(&row{table: w.config.FlowTable, data: data})
+}
+
+// enqueue queues a row, dropping it when the queue is full or the writer
+// is closed
+func (w *Writer) enqueue(r *row) {
+	select {
+	case <-w.closing:
+		droppedRows.WithLabelValues(r.table).Inc()
+		return
+	default:
+	}
+	select {
+	case w.queue <- r:
+	default:
+		droppedRows.WithLabelValues(r.table).Inc()
+	}
+}
+
+// Health returns nil while inserts succeed, or the last error
+func (w *Writer) Health() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastErr
+}
+
+func (w *Writer) setErr(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastErr = err
+}
+
+// Close inserts the queued rows
+func (w *Writer) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.closing)
+	})
+	<-w.done
+	return nil
+}
+
+// run batches queued rows until the writer is closed
+func (w *Writer) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(time.Duration(w.config.FlushInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	var batch []*row
+	for {
+		select {
+		case r := <-w.queue:
+			batch = append(batch, r)
+			if len(batch) >= w.config.BatchSize {
+				w.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = nil
+			}
+		case <-w.closing:
+			for len(w.queue) > 0 {
+				batch = append(batch, <-w.queue)
+				if len(batch) >= w.config.BatchSize {
+					w.flush(batch)
+					batch = nil
+				}
+			}
+			if len(batch) > 0 {
+				w.flush(batch)
+			}
+			return
+		}
+	}
+}
+
+// flush inserts a batch, one insert per table
+func (w *Writer) flush(batch []*row) {
+	tables := make(map[string][]*row)
+	var order []string
+	for _, r := range batch {
+		if _, ok := tables[r.table]; !ok {
+			order = append(order, r.table)
+		}
+		tables[r.table] = append(tables[r.table], r)
+	}
+
+	if w.config.CreateTables && !w.tablesCreated {
+		if err := w.createTables(); err != nil {
+			slog.Warn("Failed to create ClickHouse tables", "error", err)
+			w.setErr(err)
+		} else {
+			w.tablesCreated = true
+		}
+	}
+
+	for _, table := range order {
+		w.insert(table, tables[table])
+	}
+}
+
+// insert inserts rows into a table, retrying failed requests with
+// exponential backoff. Inserts are atomic, so a failed insert is retried
+// as a whole.
+func (w *Writer) insert(table string, rows []*row) {
+	var body bytes.Buffer
+	for _, r := range rows {
+		body.Write(r.data)
+		body.WriteByte('\n')
+	}
+	query := fmt.Sprintf("INSERT INTO %s.%s FORMAT JSONEachRow", w.config.Database, table)
+
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		retriable, err := w.exec(query, body.Bytes())
+		if err == nil {
+			rowsTotal.WithLabelValues(table).Add(float64(len(rows)))
+			w.setErr(nil)
+			return
+		}
+
+		if !retriable || attempt == w.config.MaxRetries {
+			deliveryErrors.WithLabelValues(table).Add(float64(len(rows)))
+			w.setErr(err)
+			slog.Warn("Failed to insert into ClickHouse", "table", table, "rows", len(rows), "error", err)
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// createTables creates the configured tables if they do not exist
+func (w *Writer) createTables() error {
+	queries := []string{
+		createTableQuery(w.config.Database+"."+w.config.DetectionTable, detectionColumns, "timestamp", w.config.TTLDays),
+	}
+	if w.config.FlowTable != "" {
+		queries = append(queries,
+			createTableQuery(w.config.Database+"."+w.config.FlowTable, flowColumns, "start_time", w.config.TTLDays))
+	}
+
+	for _, query := range queries {
+		if _, err := w.exec(query, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exec runs a query with data as its input and reports whether a failure
+// may succeed when retried
+func (w *Writer) exec(query string, data []byte) (bool, error) {
+	params := url.Values{"query": {query}, "database": {w.config.Database}}
+	req, err := http.NewRequest(http.MethodPost, w.url+"?"+params.Encode(), bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if w.config.Username != "" {
+		req.Header.Set("X-ClickHouse-User", w.config.Username)
+		req.Header.Set("X-ClickHouse-Key", w.config.Password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorSize))
+	err = fmt.Errorf("clickhouse query failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
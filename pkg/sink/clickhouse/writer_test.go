@@ -0,0 +1,180 @@
+package clickhouse
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/enrich"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// query is a query received by the fake server
+type query struct {
+	sql  string
+	rows []string
+}
+
+// fakeServer is a ClickHouse HTTP interface failing the first failures
+// requests with status
+type fakeServer struct {
+	mu       sync.Mutex
+	queries  []query
+	failures int
+	status   int
+	user     string
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.user = r.Header.Get("X-ClickHouse-User")
+	if s.failures > 0 {
+		s.failures--
+		http.Error(w, "Code: 241. DB::Exception: Memory limit exceeded", s.status)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	q := query{sql: r.URL.Query().Get("query")}
+	if len(body) > 0 {
+		q.rows = strings.Split(strings.TrimSpace(string(body)), "\n")
+	}
+	s.queries = append(s.queries, q)
+}
+
+func newFakeServer(t *testing.T) (*fakeServer, *httptest.Server) {
+	s := &fakeServer{}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func testConfig(url string) config.ClickHouseConfig {
+	return config.ClickHouseConfig{
+		URL:            url,
+		Database:       "analytics",
+		Username:       "argus",
+		DetectionTable: "argus_detections",
+		FlowTable:      "argus_flows",
+		BatchSize:      100,
+		FlushInterval:  10,
+		QueueSize:      100,
+		MaxRetries:     2,
+		Timeout:        5,
+	}
+}
+
+func TestWriter(t *testing.T) {
+	fake, server := newFakeServer(t)
+	cfg := testConfig(server.URL)
+	cfg.CreateTables = true
+	cfg.TTLDays = 90
+
+	writer, err := NewWriter(cfg)
+	require.NoError(t, err)
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 123e6, time.UTC)
+	writer.WriteDetection(&cortex.DetectionResult{
+		FlowID:     "a-b",
+		IsBot:      true,
+		Confidence: 0.91,
+		Timestamp:  now,
+		SrcIP:      net.ParseIP("10.0.0.1"),
+		DstIP:      net.ParseIP("2001:db8::1"),
+		DstPort:    443,
+		Protocol:   "TCP",
+		DstGeo:     &enrich.GeoInfo{Country: "SE", ASN: 64500},
+	})
+	writer.WriteFlow(&events.FlowRecord{ID: "a-b", Packets: 12, Bytes: 4096, StartTime: now.Add(-2 * time.Second), LastSeen: now})
+	writer.WriteDetection(&cortex.DetectionResult{FlowID: "c-d", Timestamp: now})
+	require.NoError(t, writer.Close())
+	assert.NoError(t, writer.Health())
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	require.Len(t, fake.queries, 4)
+	assert.Equal(t, "argus", fake.user)
+
+	assert.True(t, strings.HasPrefix(fake.queries[0].sql, "CREATE TABLE IF NOT EXISTS analytics.argus_detections ("))
+	assert.Contains(t, fake.queries[0].sql, "TTL toDateTime(timestamp) + INTERVAL 90 DAY")
+	assert.True(t, strings.HasPrefix(fake.queries[1].sql, "CREATE TABLE IF NOT EXISTS analytics.argus_flows ("))
+
+	detections := fake.queries[2]
+	assert.Equal(t, "INSERT INTO analytics.argus_detections FORMAT JSONEachRow", detections.sql)
+	require.Len(t, detections.rows, 2)
+	var row map[string]any
+	require.NoError(t, json.Unmarshal([]byte(detections.rows[0]), &row))
+	assert.Equal(t, "2024-03-01 12:00:00.123", row["timestamp"])
+	assert.Equal(t, "::ffff:10.0.0.1", row["src_ip"])
+	assert.Equal(t, "2001:db8::1", row["dst_ip"])
+	assert.Equal(t, "SE", row["dst_country"])
+	assert.Equal(t, float64(64500), row["dst_asn"])
+	assert.Equal(t, []any{}, row["features"])
+
+	flows := fake.queries[3]
+	assert.Equal(t, "INSERT INTO analytics.argus_flows FORMAT JSONEachRow", flows.sql)
+	require.Len(t, flows.rows, 1)
+	require.NoError(t, json.Unmarshal([]byte(flows.rows[0]), &row))
+	assert.Equal(t, float64(2000), row["duration_ms"])
+	assert.Equal(t, float64(4096), row["bytes"])
+}
+
+func TestWriterRetries(t *testing.T) {
+	fake, server := newFakeServer(t)
+	fake.failures, fake.status = 2, http.StatusServiceUnavailable
+
+	cfg := testConfig(server.URL)
+	cfg.FlowTable = ""
+	writer, err := NewWriter(cfg)
+	require.NoError(t, err)
+
+	writer.WriteDetection(&cortex.DetectionResult{FlowID: "a-b"})
+	writer.WriteFlow(&events.FlowRecord{ID: "a-b"})
+	require.NoError(t, writer.Close())
+	assert.NoError(t, writer.Health())
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	require.Len(t, fake.queries, 1)
+	assert.Len(t, fake.queries[0].rows, 1)
+}
+
+func TestWriterPermanentFailure(t *testing.T) {
+	fake, server := newFakeServer(t)
+	fake.failures, fake.status = 1, http.StatusBadRequest
+
+	writer, err := NewWriter(testConfig(server.URL))
+	require.NoError(t, err)
+
+	writer.WriteDetection(&cortex.DetectionResult{FlowID: "a-b"})
+	require.NoError(t, writer.Close())
+	assert.ErrorContains(t, writer.Health(), "Memory limit exceeded")
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Empty(t, fake.queries, "client errors are not retried")
+}
+
+func TestNewWriterValidation(t *testing.T) {
+	_, err := NewWriter(testConfig(""))
+	assert.Error(t, err)
+
+	for _, table := range []string{"", "argus-detections", "x; DROP TABLE y"} {
+		cfg := testConfig("http://localhost:8123")
+		cfg.DetectionTable = table
+		_, err := NewWriter(cfg)
+		assert.Error(t, err, table)
+	}
+}
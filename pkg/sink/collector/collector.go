@@ -0,0 +1,369 @@
+// Package collector collects training data from live traffic: the feature
+// vectors of detections, with their flow metadata and analyst labels, are
+// appended to rotating dataset files for offline training.
+package collector
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/dataset"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/ml"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Name identifies the collector in sink health checks
+const Name = "collector"
+
+// partialSuffix marks the file being written; it is renamed without the
+// suffix once complete
+const partialSuffix = ".partial"
+
+// filePrefix starts the names of collected files, followed by the UTC time
+// the file was started
+const filePrefix = "dataset-"
+
+var (
+	recordsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_collector_records_total",
+			Help: "Total number of records collected into training datasets",
+		},
+		[]string{"labelled"},
+	)
+	filesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_collector_files_total",
+			Help: "Total number of completed training dataset files",
+		},
+	)
+	writeErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_collector_write_errors_total",
+			Help: "Total number of records that could not be written to a training dataset",
+		},
+	)
+	droppedRecords = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_collector_dropped_records_total",
+			Help: "Total number of records dropped because the collector queue was full",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(recordsTotal, filesTotal, writeErrors, droppedRecords)
+}
+
+// labelSource is the label source of records labelled by analyst feedback
+const labelSource = "feedback"
+
+// entry is a queued detection, with its label when labelled by feedback
+type entry struct {
+	result *cortex.DetectionResult
+	label  *int
+}
+
+// Collector appends detections to dataset files in the configured
+// directory. Unlabelled detections are sampled by verdict; detections
+// labelled by analyst feedback are always appended, with their label, so
+// the files train on the flows analysts have judged. A file is written
+// under a .partial name and renamed once complete, after the configured
+// number of rows or seconds. Records are queued without blocking and
+// written by a background goroutine.
+type Collector struct {
+	config config.CollectorConfig
+	ext    string
+	now    func() time.Time
+	random func() float64
+
+	queue     chan entry
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// Owned by the run goroutine
+	file     *os.File
+	writer   *dataset.Writer
+	path     string // of the completed file
+	features int
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// NewCollector creates a collector writing to the configured directory and
+// starts writing in the background
+func NewCollector(cfg config.CollectorConfig) (*Collector, error) {
+	return newCollector(cfg, time.Now, rand.Float64)
+}
+
+func newCollector(cfg config.CollectorConfig, now func() time.Time, random func() float64) (*Collector, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("no collector directory configured")
+	}
+	switch cfg.Format {
+	case dataset.FormatCSV, dataset.FormatJSONL, dataset.FormatParquet:
+	default:
+		return nil, fmt.Errorf("unsupported collector format: %s", cfg.Format)
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 || cfg.BotSampleRate < 0 || cfg.BotSampleRate > 1 {
+		return nil, fmt.Errorf("collector sample rates must be between 0 and 1")
+	}
+	if cfg.MaxRows < 1 || cfg.RotateInterval < 1 || cfg.QueueSize < 1 || cfg.MaxFiles < 0 {
+		return nil, fmt.Errorf("collector max rows, rotate interval and queue size must be positive")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create collector directory: %w", err)
+	}
+	if partial, _ := filepath.Glob(filepath.Join(cfg.Dir, filePrefix+"*"+partialSuffix)); len(partial) > 0 {
+		slog.Warn("Incomplete training datasets left by an earlier run", "files", partial)
+	}
+
+	c := &Collector{
+		config:  cfg,
+		ext:     "." + cfg.Format,
+		now:     now,
+		random:  random,
+		queue:   make(chan entry, cfg.QueueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go c.run()
+
+	slog.Info("Training data collector started", "dir", cfg.Dir, "format", cfg.Format)
+	return c, nil
+}
+
+// Name implements argus.Sink
+func (c *Collector) Name() string {
+	return Name
+}
+
+// WriteDetection queues a sample of detection results, unlabelled
+func (c *Collector) WriteDetection(result *cortex.DetectionResult) {
+	if c.config.LabelledOnly || len(result.Features) == 0 {
+		return
+	}
+	rate := c.config.SampleRate
+	if result.IsBot && c.config.BotSampleRate > 0 {
+		rate = c.config.BotSampleRate
+	}
+	if c.random() >= rate {
+		return
+	}
+	c.enqueue(entry{result: result})
+}
+
+// WriteLabel queues a detection labelled by an analyst; it implements
+// api.LabelSink
+func (c *Collector) WriteLabel(result *cortex.DetectionResult, isBot bool) {
+	if len(result.Features) == 0 {
+		return
+	}
+	label := 0
+	if isBot {
+		label = 1
+	}
+	c.enqueue(entry{result: result, label: &label})
+}
+
+func (c *Collector) enqueue(e entry) {
+	select {
+	case <-c.closing:
+		droppedRecords.Inc()
+		return
+	default:
+	}
+	select {
+	case c.queue <- e:
+	default:
+		droppedRecords.Inc()
+	}
+}
+
+// WriteFlow implements argus.Sink; flows are collected through their
+// detections
+func (c *Collector) WriteFlow(*events.FlowRecord) {}
+
+// Health returns nil while writing succeeds, or the last error
+func (c *Collector) Health() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastErr
+}
+
+func (c *Collector) setErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastErr = err
+}
+
+// Close writes the queued records and completes the open file
+func (c *Collector) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closing)
+	})
+	<-c.done
+	return nil
+}
+
+// run writes queued records until the collector is closed
+func (c *Collector) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(time.Duration(c.config.RotateInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-c.queue:
+			c.write(e)
+		case <-ticker.C:
+			c.complete()
+		case <-c.closing:
+			for len(c.queue) > 0 {
+				c.write(<-c.queue)
+			}
+			c.complete()
+			return
+		}
+	}
+}
+
+// write appends a record to the open file, starting one when none is open
+// or the number of features changed
+func (c *Collector) write(e entry) {
+	if c.writer != nil && len(e.result.Features) != c.features {
+		c.complete()
+	}
+	if c.writer == nil {
+		if err := c.open(len(e.result.Features)); err != nil {
+			slog.Error("Failed to start training dataset", "error", err)
+			c.setErr(err)
+			writeErrors.Inc()
+			return
+		}
+	}
+
+	r := e.result
+	record := &dataset.Record{
+		Timestamp:    r.Timestamp,
+		FlowID:       r.FlowID,
+		SrcIP:        ipString(r.SrcIP),
+		DstIP:        ipString(r.DstIP),
+		SrcPort:      int(r.SrcPort),
+		DstPort:      int(r.DstPort),
+		Protocol:     r.Protocol,
+		PredictedBot: r.IsBot,
+		Confidence:   r.Confidence,
+		ModelVersion: r.ModelVersion,
+		Features:     r.Features,
+		Label:        e.label,
+	}
+	if e.label != nil {
+		record.LabelSource = labelSource
+	}
+	if err := c.writer.Write(record); err != nil {
+		slog.Warn("Failed to write training record", "flow_id", r.FlowID, "error", err)
+		c.setErr(err)
+		writeErrors.Inc()
+		return
+	}
+	recordsTotal.WithLabelValues(fmt.Sprint(e.label != nil)).Inc()
+
+	if c.writer.Rows() >= c.config.MaxRows {
+		c.complete()
+	}
+}
+
+func ipString(ip net.IP) string {
+	if len(ip) == 0 {
+		return ""
+	}
+	return ip.String()
+}
+
+// open starts a file for records of the given number of features
+func (c *Collector) open(features int) error {
+	names := make([]string, features)
+	for i := range names {
+		names[i] = ml.FeatureName(i)
+	}
+
+	c.path = filepath.Join(c.config.Dir, filePrefix+c.now().UTC().Format("20060102T150405.000Z")+c.ext)
+	file, err := os.OpenFile(c.path+partialSuffix, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	writer, err := dataset.NewWriter(file, c.config.Format, names)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	c.file, c.writer, c.features = file, writer, features
+	return nil
+}
+
+// complete finishes the open file, if any, and renames it to its final
+// name. Files without records are removed.
+func (c *Collector) complete() {
+	if c.writer == nil {
+		return
+	}
+	rows := c.writer.Rows()
+	err := c.writer.Close()
+	if closeErr := c.file.Close(); err == nil {
+		err = closeErr
+	}
+	partial := c.file.Name()
+	c.file, c.writer = nil, nil
+
+	switch {
+	case err != nil:
+		slog.Error("Failed to complete training dataset", "path", partial, "error", err)
+		c.setErr(err)
+		return
+	case rows == 0:
+		os.Remove(partial)
+		return
+	}
+	if err := os.Rename(partial, c.path); err != nil {
+		slog.Error("Failed to complete training dataset", "path", partial, "error", err)
+		c.setErr(err)
+		return
+	}
+	filesTotal.Inc()
+	c.setErr(nil)
+	slog.Info("Training dataset completed", "path", c.path, "rows", rows)
+	c.prune()
+}
+
+// prune removes the oldest completed files beyond the configured maximum
+func (c *Collector) prune() {
+	if c.config.MaxFiles == 0 {
+		return
+	}
+	matches, err := filepath.Glob(filepath.Join(c.config.Dir, filePrefix+"*"+c.ext))
+	if err != nil {
+		return
+	}
+	// Names sort by the time the files were started
+	sort.Strings(matches)
+	for _, path := range matches[:max(len(matches)-c.config.MaxFiles, 0)] {
+		if err := os.Remove(path); err != nil {
+			slog.Warn("Failed to remove training dataset", "path", path, "error", err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package collector
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/dataset"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig(t *testing.T) config.CollectorConfig {
+	return config.CollectorConfig{
+		Dir:            t.TempDir(),
+		Format:         dataset.FormatCSV,
+		SampleRate:     1,
+		MaxRows:        100,
+		RotateInterval: 3600,
+		QueueSize:      100,
+	}
+}
+
+// ticker is a clock advancing a millisecond per reading, so every file
+// gets its own name
+type ticker struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *ticker) get() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(time.Millisecond)
+	return c.now
+}
+
+func detection(flowID string, isBot bool, features ...float64) *cortex.DetectionResult {
+	return &cortex.DetectionResult{
+		FlowID:     flowID,
+		IsBot:      isBot,
+		Confidence: 0.9,
+		Features:   features,
+		Timestamp:  time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		SrcIP:      net.ParseIP("10.0.0.1"),
+		DstIP:      net.ParseIP("192.0.2.7"),
+		SrcPort:    40000,
+		DstPort:    443,
+		Protocol:   "TLS",
+	}
+}
+
+func files(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestCollectorLabelsAndRotation(t *testing.T) {
+	for _, format := range []string{dataset.FormatCSV, dataset.FormatJSONL, dataset.FormatParquet} {
+		t.Run(format, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.Format = format
+			cfg.MaxRows = 3
+			clock := &ticker{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+			c, err := newCollector(cfg, clock.get, func() float64 { return 0 })
+			require.NoError(t, err)
+
+			c.WriteDetection(detection("a", true, 1, 2))
+			c.WriteLabel(detection("b", true, 3, 4), false)
+			c.WriteLabel(detection("c", false, 5, 6), true)
+			c.WriteDetection(detection("d", false, 7, 8))
+			c.WriteDetection(detection("e", false)) // no features
+			require.NoError(t, c.Close())
+			require.NoError(t, c.Health())
+
+			names := files(t, cfg.Dir)
+			require.Equal(t, []string{
+				"dataset-20240301T120000.001Z." + format,
+				"dataset-20240301T120000.002Z." + format,
+			}, names, "rotated after three rows")
+
+			ds, err := dataset.Load(filepath.Join(cfg.Dir, names[0]), dataset.Options{FeatureSize: 2})
+			require.NoError(t, err)
+			assert.Equal(t, [][]float64{{3, 4}, {5, 6}}, ds.Features)
+			assert.Equal(t, []int{0, 1}, ds.Labels)
+
+			_, err = dataset.Load(filepath.Join(cfg.Dir, names[1]), dataset.Options{})
+			assert.ErrorContains(t, err, "no labelled samples")
+		})
+	}
+}
+
+func TestCollectorSampling(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.SampleRate = 0.1
+	cfg.BotSampleRate = 0.5
+	c, err := newCollector(cfg, time.Now, func() float64 { return 0.3 })
+	require.NoError(t, err)
+	c.WriteDetection(detection("human", false, 1))
+	c.WriteDetection(detection("bot", true, 1))
+	require.NoError(t, c.Close())
+
+	names := files(t, cfg.Dir)
+	require.Len(t, names, 1)
+	data, err := os.ReadFile(filepath.Join(cfg.Dir, names[0]))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), ",bot,")
+	assert.NotContains(t, string(data), ",human,")
+
+	// Labelled only: unlabelled detections are not collected, and a file
+	// without records is not kept
+	cfg = testConfig(t)
+	cfg.LabelledOnly = true
+	c, err = newCollector(cfg, time.Now, func() float64 { return 0 })
+	require.NoError(t, err)
+	c.WriteDetection(detection("a", true, 1))
+	require.NoError(t, c.Close())
+	assert.Empty(t, files(t, cfg.Dir))
+}
+
+func TestCollectorFeatureSizeChangeAndPruning(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MaxFiles = 2
+	clock := &ticker{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	c, err := newCollector(cfg, clock.get, func() float64 { return 0 })
+	require.NoError(t, err)
+	c.WriteDetection(detection("a", true, 1))
+	c.WriteDetection(detection("b", true, 1, 2))
+	c.WriteDetection(detection("c", true, 1, 2, 3))
+	require.NoError(t, c.Close())
+
+	assert.Equal(t, []string{
+		"dataset-20240301T120000.002Z.csv",
+		"dataset-20240301T120000.003Z.csv",
+	}, files(t, cfg.Dir), "a file per feature size, the oldest pruned")
+
+	// Records queued after closing are dropped
+	c.WriteDetection(detection("d", true, 1))
+}
+
+func TestNewCollectorErrors(t *testing.T) {
+	for name, modify := range map[string]func(*config.CollectorConfig){
+		"no dir":      func(c *config.CollectorConfig) { c.Dir = "" },
+		"format":      func(c *config.CollectorConfig) { c.Format = "xlsx" },
+		"sample rate": func(c *config.CollectorConfig) { c.SampleRate = 1.5 },
+		"bot rate":    func(c *config.CollectorConfig) { c.BotSampleRate = -1 },
+		"max rows":    func(c *config.CollectorConfig) { c.MaxRows = 0 },
+		"max files":   func(c *config.CollectorConfig) { c.MaxFiles = -1 },
+	} {
+		cfg := testConfig(t)
+		modify(&cfg)
+		_, err := NewCollector(cfg)
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,391 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Name identifies the indexer in sink health checks
+const Name = "elasticsearch"
+
+// Document types, used as metric labels
+const (
+	typeDetection = "detection"
+	typeFlow      = "flow"
+)
+
+// maxBackoff caps the delay between retries of a batch
+const maxBackoff = 10 * time.Second
+
+var (
+	documentsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_elasticsearch_documents_total",
+			Help: "Total number of documents indexed in Elasticsearch",
+		},
+		[]string{"type"},
+	)
+	deliveryErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_elasticsearch_delivery_errors_total",
+			Help: "Total number of documents that could not be indexed in Elasticsearch",
+		},
+		[]string{"type"},
+	)
+	droppedDocuments = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_elasticsearch_dropped_documents_total",
+			Help: "Total number of documents dropped because the Elasticsearch queue was full",
+		},
+		[]string{"type"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(documentsTotal, deliveryErrors, droppedDocuments)
+}
+
+// document is a JSON document queued for an index
+type document struct {
+	kind  string
+	index string
+	body  []byte
+}
+
+// Indexer bulk-indexes detection results and completed flow records into
+// Elasticsearch or OpenSearch, in dated indices Kibana and OpenSearch
+// Dashboards can query directly. Documents are queued without blocking
+// and indexed by a background goroutine, which retries batches the
+// cluster rejects with growing delays; while it backs off, documents
+// beyond the queue size are dropped.
+type Indexer struct {
+	config  config.ElasticsearchConfig
+	client  *http.Client
+	urls    []string
+	nextURL int // owned by the run goroutine
+
+	queue     chan *document
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// NewIndexer creates an indexer for the configured cluster and starts
+// indexing in the background
+func NewIndexer(cfg config.ElasticsearchConfig) (*Indexer, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, fmt.Errorf("no elasticsearch urls configured")
+	}
+	for _, pattern := range []string{cfg.DetectionIndex, cfg.FlowIndex} {
+		if pattern != strings.ToLower(pattern) || strings.ContainsAny(pattern, ` "*\<|,>/?#:`) {
+			return nil, fmt.Errorf("invalid elasticsearch index name: %q", pattern)
+		}
+	}
+	if cfg.DetectionIndex == "" {
+		return nil, fmt.Errorf("no elasticsearch detection index configured")
+	}
+	if cfg.BatchSize < 1 || cfg.FlushInterval < 1 || cfg.QueueSize < 1 || cfg.Timeout < 1 || cfg.MaxRetries < 0 {
+		return nil, fmt.Errorf("elasticsearch batch size, flush interval, queue size and timeout must be positive")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLSCA != "" {
+		pem, err := os.ReadFile(cfg.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read elasticsearch CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCA)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	urls := make([]string, len(cfg.URLs))
+	for i, url := range cfg.URLs {
+		urls[i] = strings.TrimRight(url, "/")
+	}
+
+	ix := &Indexer{
+		config:  cfg,
+		client:  &http.Client{Transport: transport, Timeout: time.Duration(cfg.Timeout) * time.Second},
+		urls:    urls,
+		queue:   make(chan *document, cfg.QueueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go ix.run()
+
+	slog.Info("Elasticsearch indexer started",
+		"urls", urls,
+		"detection_index", cfg.DetectionIndex,
+		"flow_index", cfg.FlowIndex)
+	return ix, nil
+}
+
+// Name implements argus.Sink
+func (ix *Indexer) Name() string {
+	return Name
+}
+
+// WriteDetection queues a detection result for the detection index
+func (ix *Indexer) WriteDetection(result *cortex.DetectionResult) {
+	body, err := json.Marshal(result)
+	if err != nil {
+		slog.Error("Failed to encode detection for Elasticsearch", "flow_id", result.FlowID, "error", err)
+		return
+	}
+	ix.enqueue(&document{
+		kind:  typeDetection,
+		index: indexName(ix.config.DetectionIndex, ix.config.IndexDateFormat, result.Timestamp),
+		body:  body,
+	})
+}
+
+// WriteFlow queues a completed flow record for the flow index, if one is
+// configured
+func (ix *Indexer) WriteFlow(record *events.FlowRecord) {
+	if ix.config.FlowIndex == "" {
+		return
+	}
+	body, err := json.Marshal(record)
+	if err != nil {
+		slog.Error("Failed to encode flow for Elasticsearch", "flow_id", record.ID, "error", err)
+		return
+	}
+	ix.enqueue(&document{
+		kind:  typeFlow,
+		index: indexName(ix.config.FlowIndex, ix.config.IndexDateFormat, record.LastSeen),
+		body:  body,
+	})
+}
+
+// indexName expands the {date} placeholder of an index pattern
+func indexName(pattern, layout string, t time.Time) string {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return strings.ReplaceAll(pattern, "{date}", t.UTC().Format(layout))
+}
+
+// enqueue queues a document, dropping it when the queue is full or the
+// indexer is closed
+func (ix *Indexer) enqueue(doc *document) {
+	select {
+	case <-ix.closing:
+		droppedDocuments.WithLabelValues(doc.kind).Inc()
+		return
+	default:
+	}
+	select {
+	case ix.queue <- doc:
+	default:
+		droppedDocuments.WithLabelValues(doc.kind).Inc()
+	}
+}
+
+// Health returns nil while indexing succeeds, or the last error
+func (ix *Indexer) Health() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return ix.lastErr
+}
+
+func (ix *Indexer) setErr(err error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.lastErr = err
+}
+
+// Close indexes the queued documents
+func (ix *Indexer) Close() error {
+	ix.closeOnce.Do(func() {
+		close(ix.closing)
+	})
+	<-ix.done
+	return nil
+}
+
+// run batches queued documents until the indexer is closed
+func (ix *Indexer) run() {
+	defer close(ix.done)
+
+	ticker := time.NewTicker(time.Duration(ix.config.FlushInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	var retention <-chan time.Time
+	if ix.config.RetentionDays > 0 {
+		ix.deleteExpiredIndices(time.Now())
+		retentionTicker := time.NewTicker(time.Hour)
+		defer retentionTicker.Stop()
+		retention = retentionTicker.C
+	}
+
+	var batch []*document
+	for {
+		select {
+		case doc := <-ix.queue:
+			batch = append(batch, doc)
+			if len(batch) >= ix.config.BatchSize {
+				ix.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				ix.flush(batch)
+				batch = nil
+			}
+		case now := <-retention:
+			ix.deleteExpiredIndices(now)
+		case <-ix.closing:
+			for len(ix.queue) > 0 {
+				batch = append(batch, <-ix.queue)
+				if len(batch) >= ix.config.BatchSize {
+					ix.flush(batch)
+					batch = nil
+				}
+			}
+			if len(batch) > 0 {
+				ix.flush(batch)
+			}
+			return
+		}
+	}
+}
+
+// flush indexes a batch, retrying the documents the cluster rejected
+// temporarily with exponential backoff
+func (ix *Indexer) flush(batch []*document) {
+	pending := batch
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		var err error
+		pending, err = ix.bulk(pending)
+		if len(pending) == 0 {
+			return
+		}
+
+		if attempt == ix.config.MaxRetries {
+			for _, doc := range pending {
+				deliveryErrors.WithLabelValues(doc.kind).Inc()
+			}
+			ix.setErr(err)
+			slog.Warn("Failed to index documents in Elasticsearch", "documents", len(pending), "error", err)
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// bulkResponse is the part of a _bulk response needed to find failed items
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// bulk sends documents in one _bulk request and returns those to retry.
+// Documents failing permanently are counted as lost.
+func (ix *Indexer) bulk(docs []*document) ([]*document, error) {
+	var body bytes.Buffer
+	for _, doc := range docs {
+		fmt.Fprintf(&body, "{\"index\":{\"_index\":%q}}\n", doc.index)
+		body.Write(doc.body)
+		body.WriteByte('\n')
+	}
+
+	resp, err := ix.do(http.MethodPost, "/_bulk", &body, "application/x-ndjson")
+	if err != nil {
+		return docs, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		io.Copy(io.Discard, resp.Body)
+		return docs, fmt.Errorf("elasticsearch bulk request failed: %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("elasticsearch bulk request failed: %s", resp.Status)
+		for _, doc := range docs {
+			deliveryErrors.WithLabelValues(doc.kind).Inc()
+		}
+		ix.setErr(err)
+		return nil, err
+	}
+
+	var result bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return docs, fmt.Errorf("invalid elasticsearch bulk response: %w", err)
+	}
+	if len(result.Items) != len(docs) {
+		return docs, fmt.Errorf("elasticsearch bulk response has %d items for %d documents", len(result.Items), len(docs))
+	}
+
+	var retry []*document
+	var lastErr error
+	for i, item := range result.Items {
+		doc := docs[i]
+		for _, status := range item {
+			switch {
+			case status.Status < 300:
+				documentsTotal.WithLabelValues(doc.kind).Inc()
+			case status.Status == http.StatusTooManyRequests || status.Status >= 500:
+				lastErr = fmt.Errorf("elasticsearch rejected document: status %d", status.Status)
+				retry = append(retry, doc)
+			default:
+				lastErr = fmt.Errorf("elasticsearch failed to index document: status %d", status.Status)
+				if status.Error != nil {
+					lastErr = fmt.Errorf("elasticsearch failed to index document: %s: %s", status.Error.Type, status.Error.Reason)
+				}
+				deliveryErrors.WithLabelValues(doc.kind).Inc()
+			}
+		}
+	}
+	ix.setErr(lastErr)
+	return retry, lastErr
+}
+
+// do sends a request to the next node
+func (ix *Indexer) do(method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	url := ix.urls[ix.nextURL%len(ix.urls)] + path
+	ix.nextURL++
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if ix.config.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+ix.config.APIKey)
+	} else if ix.config.Username != "" {
+		req.SetBasicAuth(ix.config.Username, ix.config.Password)
+	}
+	return ix.client.Do(req)
+}
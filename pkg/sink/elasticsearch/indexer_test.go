@@ -0,0 +1,186 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// indexed is a document received by the fake cluster
+type indexed struct {
+	index string
+	doc   map[string]any
+}
+
+// fakeCluster serves _bulk, _cat/indices and index deletion. Documents
+// with a flow_id listed in reject get the given item status once.
+type fakeCluster struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	docs     []indexed
+	reject   map[string]int
+	indices  []string
+	deleted  []string
+	authUser string
+}
+
+func newFakeCluster(t *testing.T) (*fakeCluster, *httptest.Server) {
+	c := &fakeCluster{t: t, reject: make(map[string]int)}
+	server := httptest.NewServer(c)
+	t.Cleanup(server.Close)
+	return c, server
+}
+
+func (c *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/_bulk":
+		c.authUser, _, _ = r.BasicAuth()
+		assert.Equal(c.t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		c.bulk(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/_cat/indices/"):
+		var rows []map[string]string
+		for _, index := range c.indices {
+			rows = append(rows, map[string]string{"index": index})
+		}
+		json.NewEncoder(w).Encode(rows)
+	case r.Method == http.MethodDelete:
+		c.deleted = append(c.deleted, strings.TrimPrefix(r.URL.Path, "/"))
+		fmt.Fprint(w, `{"acknowledged":true}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (c *fakeCluster) bulk(w http.ResponseWriter, r *http.Request) {
+	var items []map[string]any
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var action struct {
+			Index struct {
+				Index string `json:"_index"`
+			} `json:"index"`
+		}
+		require.NoError(c.t, json.Unmarshal(scanner.Bytes(), &action))
+		require.True(c.t, scanner.Scan())
+		var doc map[string]any
+		require.NoError(c.t, json.Unmarshal(scanner.Bytes(), &doc))
+
+		status := http.StatusCreated
+		id, _ := doc["flow_id"].(string)
+		if code, ok := c.reject[id]; ok {
+			status = code
+			delete(c.reject, id)
+		} else {
+			c.docs = append(c.docs, indexed{index: action.Index.Index, doc: doc})
+		}
+		item := map[string]any{"status": status}
+		if status >= 300 {
+			item["error"] = map[string]string{"type": "mapper_parsing_exception", "reason": "failed to parse"}
+		}
+		items = append(items, map[string]any{"index": item})
+	}
+	json.NewEncoder(w).Encode(map[string]any{"errors": true, "items": items})
+}
+
+func testConfig(url string) config.ElasticsearchConfig {
+	return config.ElasticsearchConfig{
+		URLs:            []string{url},
+		Username:        "argus",
+		Password:        "secret",
+		DetectionIndex:  "argus-detections-{date}",
+		FlowIndex:       "argus-flows-{date}",
+		IndexDateFormat: "2006.01.02",
+		BatchSize:       10,
+		FlushInterval:   10,
+		QueueSize:       100,
+		MaxRetries:      2,
+		Timeout:         5,
+	}
+}
+
+func TestIndexer(t *testing.T) {
+	cluster, server := newFakeCluster(t)
+	cluster.reject["busy"] = http.StatusTooManyRequests
+	cluster.reject["broken"] = http.StatusBadRequest
+
+	ix, err := NewIndexer(testConfig(server.URL))
+	require.NoError(t, err)
+	failed := testutil.ToFloat64(deliveryErrors.WithLabelValues(typeDetection))
+
+	day := time.Date(2024, 3, 1, 23, 30, 0, 0, time.UTC)
+	ix.WriteDetection(&cortex.DetectionResult{FlowID: "a-b", IsBot: true, Timestamp: day})
+	ix.WriteDetection(&cortex.DetectionResult{FlowID: "busy", Timestamp: day.Add(time.Hour)})
+	ix.WriteDetection(&cortex.DetectionResult{FlowID: "broken", Timestamp: day})
+	ix.WriteFlow(&events.FlowRecord{ID: "a-b", Packets: 4, LastSeen: day})
+	require.NoError(t, ix.Close())
+
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+
+	indices := make(map[string]string)
+	for _, doc := range cluster.docs {
+		id, _ := doc.doc["flow_id"].(string)
+		if id == "" {
+			id = "flow:" + doc.doc["id"].(string)
+		}
+		indices[id] = doc.index
+	}
+	assert.Equal(t, map[string]string{
+		"a-b":      "argus-detections-2024.03.01",
+		"busy":     "argus-detections-2024.03.02", // retried after the 429
+		"flow:a-b": "argus-flows-2024.03.01",
+	}, indices)
+	assert.Equal(t, "argus", cluster.authUser)
+	assert.Equal(t, failed+1, testutil.ToFloat64(deliveryErrors.WithLabelValues(typeDetection)), "the rejected document is not retried")
+	assert.NoError(t, ix.Health(), "the last bulk request succeeded")
+}
+
+func TestIndexerRetention(t *testing.T) {
+	cluster, server := newFakeCluster(t)
+	expired := indexName("argus-detections-{date}", "2006.01.02", time.Now().AddDate(0, 0, -31))
+	recent := indexName("argus-detections-{date}", "2006.01.02", time.Now().AddDate(0, 0, -29))
+	cluster.indices = []string{expired, recent, "argus-detections-custom"}
+
+	cfg := testConfig(server.URL)
+	cfg.FlowIndex = ""
+	cfg.RetentionDays = 30
+	ix, err := NewIndexer(cfg)
+	require.NoError(t, err)
+	require.NoError(t, ix.Close()) // expired indices are deleted on start
+
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	assert.Equal(t, []string{expired}, cluster.deleted)
+}
+
+func TestNewIndexerValidation(t *testing.T) {
+	cfg := testConfig("http://localhost:9200")
+	cfg.URLs = nil
+	_, err := NewIndexer(cfg)
+	assert.Error(t, err)
+
+	for _, index := range []string{"", "Argus-{date}", "argus detections", "argus:{date}"} {
+		cfg := testConfig("http://localhost:9200")
+		cfg.DetectionIndex = index
+		_, err := NewIndexer(cfg)
+		assert.Error(t, err, index)
+	}
+}
@@ -0,0 +1,91 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// deleteExpiredIndices deletes the dated indices of the configured
+// patterns whose date is more than RetentionDays before now. Undated
+// patterns are left alone. The _cat and delete index APIs are shared by
+// Elasticsearch and OpenSearch, unlike their lifecycle policy APIs.
+func (ix *Indexer) deleteExpiredIndices(now time.Time) {
+	cutoff := now.UTC().AddDate(0, 0, -ix.config.RetentionDays)
+
+	for _, pattern := range []string{ix.config.DetectionIndex, ix.config.FlowIndex} {
+		prefix, suffix, dated := strings.Cut(pattern, "{date}")
+		if !dated {
+			continue
+		}
+
+		indices, err := ix.listIndices(prefix + "*" + suffix)
+		if err != nil {
+			slog.Warn("Failed to list Elasticsearch indices", "pattern", pattern, "error", err)
+			continue
+		}
+		for _, index := range indices {
+			date, ok := strings.CutPrefix(index, prefix)
+			if !ok {
+				continue
+			}
+			if date, ok = strings.CutSuffix(date, suffix); !ok {
+				continue
+			}
+			day, err := time.Parse(ix.config.IndexDateFormat, date)
+			if err != nil || !day.Before(cutoff) {
+				continue
+			}
+
+			if err := ix.deleteIndex(index); err != nil {
+				slog.Warn("Failed to delete expired Elasticsearch index", "index", index, "error", err)
+				continue
+			}
+			slog.Info("Deleted expired Elasticsearch index", "index", index)
+		}
+	}
+}
+
+// listIndices returns the names of the indices matching a wildcard
+// expression
+func (ix *Indexer) listIndices(expr string) ([]string, error) {
+	resp, err := ix.do(http.MethodGet, "/_cat/indices/"+url.PathEscape(expr)+"?format=json&h=index", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("listing indices failed: %s", resp.Status)
+	}
+
+	var rows []struct {
+		Index string `json:"index"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, err
+	}
+	indices := make([]string, len(rows))
+	for i, row := range rows {
+		indices[i] = row.Index
+	}
+	return indices, nil
+}
+
+func (ix *Indexer) deleteIndex(index string) error {
+	resp, err := ix.do(http.MethodDelete, "/"+url.PathEscape(index), nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("deleting index failed: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package sink
+
+import (
+	"encoding/json"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+)
+
+// Message encodings supported by the sinks
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf" // events.Detection and events.FlowRecord
+)
+
+// ValidEncoding reports whether encoding is supported
+func ValidEncoding(encoding string) bool {
+	return encoding == EncodingJSON || encoding == EncodingProtobuf
+}
+
+// EncodeDetection encodes a detection result for delivery
+func EncodeDetection(result *cortex.DetectionResult, encoding string) ([]byte, error) {
+	if encoding == EncodingProtobuf {
+		return result.Event().Marshal(), nil
+	}
+	return json.Marshal(result)
+}
+
+// EncodeFlow encodes the record of a completed flow for delivery
+func EncodeFlow(record *events.FlowRecord, encoding string) ([]byte, error) {
+	if encoding == EncodingProtobuf {
+		return record.Marshal(), nil
+	}
+	return json.Marshal(record)
+}
@@ -0,0 +1,515 @@
+package kafka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Name identifies the producer in sink health checks
+const Name = "kafka"
+
+// maxAttempts bounds the produce attempts of a batch; each retry follows a
+// metadata refresh
+const maxAttempts = 3
+
+// maxResponseSize bounds the responses read from brokers
+const maxResponseSize = 64 * 1024 * 1024
+
+var (
+	messagesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_kafka_messages_total",
+			Help: "Total number of messages delivered to Kafka",
+		},
+		[]string{"topic"},
+	)
+	deliveryErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_kafka_delivery_errors_total",
+			Help: "Total number of messages that could not be delivered to Kafka",
+		},
+		[]string{"topic"},
+	)
+	droppedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_kafka_dropped_messages_total",
+			Help: "Total number of messages dropped because the Kafka queue was full",
+		},
+		[]string{"topic"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(messagesTotal, deliveryErrors, droppedMessages)
+}
+
+// message is a record queued for a topic
+type message struct {
+	topic     string
+	key       []byte
+	value     []byte
+	timestamp time.Time
+}
+
+// Producer publishes detection results and completed flow records to Kafka
+// topics, keyed by flow ID so the records of a flow share a partition.
+// Messages are queued without blocking and sent in batches by a background
+// goroutine.
+type Producer struct {
+	config  config.KafkaConfig
+	timeout time.Duration
+
+	queue     chan *message
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// Owned by the run goroutine
+	brokers       map[int32]string       // node ID to address
+	conns         map[string]*brokerConn // by address
+	topics        map[string]topicMetadata
+	correlationID int32
+	roundRobin    int
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// NewProducer creates a producer for the configured brokers and starts
+// delivering in the background. Brokers are first contacted when a batch
+// is sent.
+func NewProducer(cfg config.KafkaConfig) (*Producer, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, fmt.Errorf("no kafka brokers configured")
+	}
+	if cfg.DetectionTopic == "" {
+		return nil, fmt.Errorf("no kafka detection topic configured")
+	}
+	if !sink.ValidEncoding(cfg.Encoding) {
+		return nil, fmt.Errorf("unsupported kafka encoding: %s", cfg.Encoding)
+	}
+	if cfg.RequiredAcks != -1 && cfg.RequiredAcks != 1 {
+		return nil, fmt.Errorf("kafka required_acks must be -1 or 1")
+	}
+	if cfg.BatchSize < 1 || cfg.BatchTimeout < 1 || cfg.QueueSize < 1 || cfg.Timeout < 1 {
+		return nil, fmt.Errorf("kafka batch size, batch timeout, queue size and timeout must be positive")
+	}
+
+	p := &Producer{
+		config:  cfg,
+		timeout: time.Duration(cfg.Timeout) * time.Second,
+		queue:   make(chan *message, cfg.QueueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		brokers: make(map[int32]string),
+		conns:   make(map[string]*brokerConn),
+		topics:  make(map[string]topicMetadata),
+	}
+	go p.run()
+
+	slog.Info("Kafka producer started",
+		"brokers", cfg.Brokers,
+		"detection_topic", cfg.DetectionTopic,
+		"flow_topic", cfg.FlowTopic)
+	return p, nil
+}
+
+// Name implements argus.Sink
+func (p *Producer) Name() string {
+	return Name
+}
+
+// WriteDetection queues a detection result for the detection topic
+func (p *Producer) WriteDetection(result *cortex.DetectionResult) {
+	value, err := sink.EncodeDetection(result, p.config.Encoding)
+	if err != nil {
+		slog.Error("Failed to encode detection for Kafka", "flow_id", result.FlowID, "error", err)
+		return
+	}
+	p.enqueue(p.config.DetectionTopic, result.FlowID, value, result.Timestamp)
+}
+
+// WriteFlow queues a completed flow record for the flow topic, if one is
+// configured
+func (p *Producer) WriteFlow(record *events.FlowRecord) {
+	if p.config.FlowTopic == "" {
+		return
+	}
+
+	value, err := sink.EncodeFlow(record, p.config.Encoding)
+	if err != nil {
+		slog.Error("Failed to encode flow for Kafka", "flow_id", record.ID, "error", err)
+		return
+	}
+	p.enqueue(p.config.FlowTopic, record.ID, value, record.LastSeen)
+}
+
+// enqueue queues a message, dropping it when the queue is full or the
+// producer is closed
+func (p *Producer) enqueue(topic, key string, value []byte, timestamp time.Time) {
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	m := &message{topic: topic, value: value, timestamp: timestamp}
+	if key != "" {
+		m.key = []byte(key)
+	}
+
+	select {
+	case <-p.closing:
+		droppedMessages.WithLabelValues(topic).Inc()
+		return
+	default:
+	}
+	select {
+	case p.queue <- m:
+	default:
+		droppedMessages.WithLabelValues(topic).Inc()
+	}
+}
+
+// Health returns nil while deliveries succeed, or the last delivery error
+func (p *Producer) Health() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastErr
+}
+
+func (p *Producer) setErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastErr = err
+}
+
+// Close sends the queued messages and closes the broker connections
+func (p *Producer) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closing)
+	})
+	<-p.done
+	return nil
+}
+
+// run batches queued messages until the producer is closed
+func (p *Producer) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(time.Duration(p.config.BatchTimeout) * time.Millisecond)
+	defer ticker.Stop()
+
+	var batch []*message
+	for {
+		select {
+		case m := <-p.queue:
+			batch = append(batch, m)
+			if len(batch) >= p.config.BatchSize {
+				p.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				p.flush(batch)
+				batch = nil
+			}
+		case <-p.closing:
+			for len(p.queue) > 0 {
+				batch = append(batch, <-p.queue)
+				if len(batch) >= p.config.BatchSize {
+					p.flush(batch)
+					batch = nil
+				}
+			}
+			if len(batch) > 0 {
+				p.flush(batch)
+			}
+			for addr, conn := range p.conns {
+				conn.close()
+				delete(p.conns, addr)
+			}
+			return
+		}
+	}
+}
+
+// flush sends a batch, refreshing metadata and retrying the messages that
+// failed with retriable errors
+func (p *Producer) flush(batch []*message) {
+	pending := batch
+	var err error
+	for attempt := 0; attempt < maxAttempts && len(pending) > 0; attempt++ {
+		if attempt > 0 || p.needsMetadata(pending) {
+			if err = p.refreshMetadata(); err != nil {
+				continue
+			}
+		}
+		pending, err = p.send(pending)
+	}
+
+	if len(pending) == 0 {
+		return
+	}
+	for _, m := range pending {
+		deliveryErrors.WithLabelValues(m.topic).Inc()
+	}
+	p.setErr(err)
+	slog.Warn("Failed to deliver messages to Kafka", "messages", len(pending), "error", err)
+}
+
+// needsMetadata reports whether the partitions of a topic in the batch are
+// unknown
+func (p *Producer) needsMetadata(batch []*message) bool {
+	for _, m := range batch {
+		if _, ok := p.topics[m.topic]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// send produces the messages to the leaders of their partitions and returns
+// the messages to retry
+func (p *Producer) send(batch []*message) ([]*message, error) {
+	var retry []*message
+	var lastErr error
+
+	sets := make(map[int32]produceSet)
+	for _, m := range batch {
+		topic, ok := p.topics[m.topic]
+		if !ok || len(topic.leaders) == 0 {
+			lastErr = fmt.Errorf("no partitions known for kafka topic %s", m.topic)
+			retry = append(retry, m)
+			continue
+		}
+
+		partition := p.partition(m.key, len(topic.leaders))
+		leader := topic.leaders[partition]
+		if leader < 0 {
+			lastErr = fmt.Errorf("no leader for kafka partition %s/%d", m.topic, partition)
+			retry = append(retry, m)
+			continue
+		}
+		set, ok := sets[leader]
+		if !ok {
+			set = make(produceSet)
+			sets[leader] = set
+		}
+		if set[m.topic] == nil {
+			set[m.topic] = make(map[int32][]*message)
+		}
+		set[m.topic][partition] = append(set[m.topic][partition], m)
+	}
+
+	for leader, set := range sets {
+		failed, err := p.produce(leader, set)
+		if err != nil {
+			lastErr = err
+		}
+		retry = append(retry, failed...)
+	}
+	return retry, lastErr
+}
+
+// partition picks the partition of a key, round-robin for messages without
+// one
+func (p *Producer) partition(key []byte, partitions int) int32 {
+	if key == nil {
+		p.roundRobin++
+		return int32(p.roundRobin % partitions)
+	}
+	return partitionFor(key, partitions)
+}
+
+// produce sends one produce request to a broker and returns the messages
+// to retry. Messages failing with other errors are counted as lost.
+func (p *Producer) produce(leader int32, set produceSet) ([]*message, error) {
+	all := func() []*message {
+		var messages []*message
+		for _, partitions := range set {
+			for _, ms := range partitions {
+				messages = append(messages, ms...)
+			}
+		}
+		return messages
+	}
+
+	addr, ok := p.brokers[leader]
+	if !ok {
+		return all(), fmt.Errorf("unknown kafka broker %d", leader)
+	}
+	resp, err := p.roundTrip(addr, apiProduce, produceVersion,
+		encodeProduceRequest(set, int16(p.config.RequiredAcks), p.timeout))
+	if err != nil {
+		return all(), err
+	}
+	results, err := decodeProduceResponse(resp)
+	if err != nil {
+		p.dropConn(addr)
+		return all(), err
+	}
+
+	var retry []*message
+	var lastErr error
+	for _, result := range results {
+		messages := set[result.topic][result.partition]
+		delete(set[result.topic], result.partition)
+
+		switch {
+		case result.err == errNone:
+			messagesTotal.WithLabelValues(result.topic).Add(float64(len(messages)))
+			p.setErr(nil)
+		case retriable(result.err):
+			lastErr = fmt.Errorf("kafka error %d producing to %s/%d", result.err, result.topic, result.partition)
+			retry = append(retry, messages...)
+		default:
+			lastErr = fmt.Errorf("kafka error %d producing to %s/%d", result.err, result.topic, result.partition)
+			deliveryErrors.WithLabelValues(result.topic).Add(float64(len(messages)))
+			p.setErr(lastErr)
+		}
+	}
+
+	// Partitions missing from the response are retried
+	retry = append(retry, all()...)
+	return retry, lastErr
+}
+
+// refreshMetadata fetches the brokers and partition leaders of the
+// configured topics from the first reachable broker. Topics the broker
+// reports errors for are left out until the next refresh.
+func (p *Producer) refreshMetadata() error {
+	topics := []string{p.config.DetectionTopic}
+	if p.config.FlowTopic != "" {
+		topics = append(topics, p.config.FlowTopic)
+	}
+	request := encodeMetadataRequest(topics)
+
+	addrs := append([]string(nil), p.config.Brokers...)
+	for _, addr := range p.brokers {
+		addrs = append(addrs, addr)
+	}
+
+	var err error
+	for _, addr := range addrs {
+		var resp []byte
+		if resp, err = p.roundTrip(addr, apiMetadata, metadataVersion, request); err != nil {
+			continue
+		}
+
+		var brokers []brokerInfo
+		var metadata map[string]topicMetadata
+		if brokers, metadata, err = decodeMetadataResponse(resp); err != nil {
+			p.dropConn(addr)
+			continue
+		}
+
+		p.brokers = make(map[int32]string, len(brokers))
+		for _, broker := range brokers {
+			p.brokers[broker.nodeID] = broker.addr()
+		}
+		p.topics = make(map[string]topicMetadata, len(metadata))
+		for name, topic := range metadata {
+			if topic.err == errNone {
+				p.topics[name] = topic
+			}
+		}
+		return nil
+	}
+
+	p.setErr(err)
+	return err
+}
+
+// roundTrip sends a request to a broker and returns the response body
+// after the correlation ID, reconnecting once if a cached connection fails
+func (p *Producer) roundTrip(addr string, apiKey, version int16, body []byte) ([]byte, error) {
+	conn, cached := p.conns[addr]
+	if !cached {
+		var err error
+		if conn, err = dialBroker(addr, p.timeout); err != nil {
+			return nil, err
+		}
+		p.conns[addr] = conn
+	}
+
+	p.correlationID++
+	request := encodeRequest(apiKey, version, p.correlationID, p.config.ClientID, body)
+	resp, err := conn.roundTrip(request, p.correlationID, p.timeout)
+	if err != nil && cached {
+		// The broker may have closed an idle connection
+		p.dropConn(addr)
+		if conn, err = dialBroker(addr, p.timeout); err != nil {
+			return nil, err
+		}
+		p.conns[addr] = conn
+		resp, err = conn.roundTrip(request, p.correlationID, p.timeout)
+	}
+	if err != nil {
+		p.dropConn(addr)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (p *Producer) dropConn(addr string) {
+	if conn, ok := p.conns[addr]; ok {
+		conn.close()
+		delete(p.conns, addr)
+	}
+}
+
+// brokerConn is a connection to a broker carrying one request at a time
+type brokerConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialBroker(addr string, timeout time.Duration) (*brokerConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to kafka broker %s: %w", addr, err)
+	}
+	return &brokerConn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (c *brokerConn) roundTrip(request []byte, correlationID int32, timeout time.Duration) ([]byte, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(header[:4]))
+	if size < 4 || size > maxResponseSize {
+		return nil, errMalformed
+	}
+	if int32(binary.BigEndian.Uint32(header[4:])) != correlationID {
+		return nil, errors.New("kafka response correlation ID mismatch")
+	}
+
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(c.reader, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *brokerConn) close() {
+	c.conn.Close()
+}
@@ -0,0 +1,363 @@
+package kafka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// record is a record received by the fake broker
+type record struct {
+	topic     string
+	partition int32
+	key       string
+	value     []byte
+}
+
+// fakeBroker is a single-node Kafka cluster serving metadata and produce
+// requests
+type fakeBroker struct {
+	t          *testing.T
+	listener   net.Listener
+	partitions int
+
+	mu            sync.Mutex
+	records       []record
+	metadataCalls int
+	notLeaderOnce bool // reject the first produce with NOT_LEADER_FOR_PARTITION
+	clientIDs     map[string]bool
+}
+
+func newFakeBroker(t *testing.T, partitions int) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	b := &fakeBroker{t: t, listener: listener, partitions: partitions, clientIDs: make(map[string]bool)}
+	go b.serve()
+	t.Cleanup(func() { listener.Close() })
+	return b
+}
+
+func (b *fakeBroker) addr() string {
+	return b.listener.Addr().String()
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(reader, size[:]); err != nil {
+			return
+		}
+		request := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(reader, request); err != nil {
+			return
+		}
+
+		d := decoder{b: request}
+		apiKey := d.int16()
+		d.int16() // version
+		correlationID := d.int32()
+		clientID := d.string()
+
+		var resp encoder
+		resp.int32(0)
+		resp.int32(correlationID)
+		switch apiKey {
+		case apiMetadata:
+			b.metadata(&d, &resp)
+		case apiProduce:
+			b.produce(&d, &resp)
+		default:
+			b.t.Errorf("unexpected api key %d", apiKey)
+			return
+		}
+		binary.BigEndian.PutUint32(resp.b, uint32(len(resp.b)-4))
+
+		b.mu.Lock()
+		b.clientIDs[clientID] = true
+		b.mu.Unlock()
+
+		if _, err := conn.Write(resp.b); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) metadata(d *decoder, resp *encoder) {
+	b.mu.Lock()
+	b.metadataCalls++
+	b.mu.Unlock()
+
+	host, portStr, _ := net.SplitHostPort(b.addr())
+	port, _ := strconv.Atoi(portStr)
+
+	resp.int32(1) // brokers
+	resp.int32(1)
+	resp.string(host)
+	resp.int32(int32(port))
+	resp.int16(-1) // rack
+	resp.int32(1)  // controller
+
+	topics := d.arrayLen()
+	resp.int32(int32(topics))
+	for i := 0; i < topics; i++ {
+		resp.int16(errNone)
+		resp.string(d.string())
+		resp.int8(0)
+		resp.int32(int32(b.partitions))
+		for p := 0; p < b.partitions; p++ {
+			resp.int16(errNone)
+			resp.int32(int32(p))
+			resp.int32(1) // leader
+			resp.int32(1) // replicas
+			resp.int32(1)
+			resp.int32(1) // isr
+			resp.int32(1)
+		}
+	}
+}
+
+func (b *fakeBroker) produce(d *decoder, resp *encoder) {
+	d.string() // transactional ID
+	assert.Equal(b.t, int16(-1), d.int16())
+	d.int32() // timeout
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	reject := b.notLeaderOnce
+	b.notLeaderOnce = false
+
+	topics := d.arrayLen()
+	resp.int32(int32(topics))
+	for i := 0; i < topics; i++ {
+		topic := d.string()
+		partitions := d.arrayLen()
+		resp.string(topic)
+		resp.int32(int32(partitions))
+		for j := 0; j < partitions; j++ {
+			partition := d.int32()
+			batch := d.take(int(d.int32()))
+			code := int16(errNone)
+			if reject {
+				code = errNotLeaderForPartition
+			} else {
+				b.records = append(b.records, b.decodeBatch(topic, partition, batch)...)
+			}
+			resp.int32(partition)
+			resp.int16(code)
+			resp.int64(0)
+			resp.int64(-1)
+		}
+	}
+	resp.int32(0) // throttle time
+	require.NoError(b.t, d.err)
+}
+
+func (b *fakeBroker) decodeBatch(topic string, partition int32, batch []byte) []record {
+	d := decoder{b: batch}
+	d.int64() // base offset
+	length := d.int32()
+	assert.Equal(b.t, int(length), len(d.b))
+	d.int32() // leader epoch
+	assert.Equal(b.t, int8(2), d.int8())
+	crc := uint32(d.int32())
+	assert.Equal(b.t, crc32.Checksum(d.b, crc32.MakeTable(crc32.Castagnoli)), crc, "record batch CRC")
+
+	d.int16() // attributes
+	d.int32() // last offset delta
+	d.int64() // first timestamp
+	d.int64() // max timestamp
+	d.int64() // producer ID
+	d.int16() // producer epoch
+	d.int32() // base sequence
+	count := d.int32()
+	require.NoError(b.t, d.err)
+
+	varint := func() int64 {
+		v, n := binary.Varint(d.b)
+		require.Positive(b.t, n)
+		d.b = d.b[n:]
+		return v
+	}
+
+	var records []record
+	for i := int32(0); i < count; i++ {
+		varint() // length
+		d.int8() // attributes
+		varint() // timestamp delta
+		varint() // offset delta
+		r := record{topic: topic, partition: partition}
+		if n := varint(); n >= 0 {
+			r.key = string(d.take(int(n)))
+		}
+		r.value = d.take(int(varint()))
+		varint() // headers
+		records = append(records, r)
+	}
+	require.NoError(b.t, d.err)
+	return records
+}
+
+func (b *fakeBroker) received() []record {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]record(nil), b.records...)
+}
+
+func testConfig(broker string) config.KafkaConfig {
+	return config.KafkaConfig{
+		Brokers:        []string{broker},
+		DetectionTopic: "detections",
+		FlowTopic:      "flows",
+		ClientID:       "argus-test",
+		Encoding:       "json",
+		BatchSize:      10,
+		BatchTimeout:   10,
+		QueueSize:      100,
+		RequiredAcks:   -1,
+		Timeout:        5,
+	}
+}
+
+func TestMurmur2(t *testing.T) {
+	// Reference values of the Java client's Utils.murmur2
+	cases := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for key, want := range cases {
+		assert.Equal(t, want, int32(murmur2([]byte(key))), key)
+	}
+}
+
+func TestProducer(t *testing.T) {
+	broker := newFakeBroker(t, 3)
+	producer, err := NewProducer(testConfig(broker.addr()))
+	require.NoError(t, err)
+
+	now := time.Now()
+	for i := 0; i < 25; i++ {
+		producer.WriteDetection(&cortex.DetectionResult{
+			FlowID:     "flow-" + strconv.Itoa(i),
+			IsBot:      i%2 == 0,
+			Confidence: 0.9,
+			Timestamp:  now,
+		})
+	}
+	producer.WriteFlow(&events.FlowRecord{ID: "flow-0", Packets: 12, LastSeen: now})
+	require.NoError(t, producer.Close())
+	assert.NoError(t, producer.Health())
+
+	records := broker.received()
+	require.Len(t, records, 26)
+	for _, r := range records {
+		assert.Equal(t, partitionFor([]byte(r.key), 3), r.partition, r.key)
+		switch r.topic {
+		case "detections":
+			var result cortex.DetectionResult
+			require.NoError(t, json.Unmarshal(r.value, &result))
+			assert.Equal(t, r.key, result.FlowID)
+		case "flows":
+			var flow events.FlowRecord
+			require.NoError(t, json.Unmarshal(r.value, &flow))
+			assert.Equal(t, "flow-0", flow.ID)
+			assert.Equal(t, uint64(12), flow.Packets)
+		default:
+			t.Errorf("unexpected topic %s", r.topic)
+		}
+	}
+	broker.mu.Lock()
+	assert.True(t, broker.clientIDs["argus-test"])
+	broker.mu.Unlock()
+
+	// Writes after Close are dropped
+	producer.WriteDetection(&cortex.DetectionResult{FlowID: "late"})
+	assert.Len(t, broker.received(), 26)
+}
+
+func TestProducerRetriesAfterLeaderChange(t *testing.T) {
+	broker := newFakeBroker(t, 2)
+	broker.notLeaderOnce = true
+
+	cfg := testConfig(broker.addr())
+	cfg.Encoding = "protobuf"
+	cfg.FlowTopic = ""
+	producer, err := NewProducer(cfg)
+	require.NoError(t, err)
+
+	producer.WriteDetection(&cortex.DetectionResult{FlowID: "a-b", IsBot: true, Confidence: 0.95})
+	producer.WriteFlow(&events.FlowRecord{ID: "a-b"})
+	require.NoError(t, producer.Close())
+
+	records := broker.received()
+	require.Len(t, records, 1)
+	var detection events.Detection
+	require.NoError(t, detection.Unmarshal(records[0].value))
+	assert.Equal(t, "a-b", detection.FlowID)
+	assert.True(t, detection.IsBot)
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	assert.Equal(t, 2, broker.metadataCalls)
+}
+
+func TestProducerUnreachableBroker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	cfg := testConfig(addr)
+	cfg.Timeout = 1
+	producer, err := NewProducer(cfg)
+	require.NoError(t, err)
+
+	producer.WriteDetection(&cortex.DetectionResult{FlowID: "a-b"})
+	require.NoError(t, producer.Close())
+	assert.Error(t, producer.Health())
+}
+
+func TestNewProducerValidation(t *testing.T) {
+	cfg := testConfig("127.0.0.1:9092")
+	cfg.Brokers = nil
+	_, err := NewProducer(cfg)
+	assert.Error(t, err)
+
+	cfg = testConfig("127.0.0.1:9092")
+	cfg.Encoding = "avro"
+	_, err = NewProducer(cfg)
+	assert.Error(t, err)
+
+	cfg = testConfig("127.0.0.1:9092")
+	cfg.RequiredAcks = 0
+	_, err = NewProducer(cfg)
+	assert.Error(t, err)
+}
@@ -0,0 +1,369 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+// API keys and the versions of the requests the producer sends. Produce v3
+// is the first version carrying v2 record batches; both are understood by
+// every broker since Kafka 0.11.
+const (
+	apiProduce      = 0
+	apiMetadata     = 3
+	produceVersion  = 3
+	metadataVersion = 1
+)
+
+// Error codes the producer handles specifically
+const (
+	errNone                    = 0
+	errUnknownTopicOrPartition = 3
+	errLeaderNotAvailable      = 5
+	errNotLeaderForPartition   = 6
+	errRequestTimedOut         = 7
+)
+
+// retriable reports whether a produce error code may succeed after a
+// metadata refresh
+func retriable(code int16) bool {
+	switch code {
+	case errUnknownTopicOrPartition, errLeaderNotAvailable, errNotLeaderForPartition, errRequestTimedOut:
+		return true
+	}
+	return false
+}
+
+var errMalformed = errors.New("malformed kafka response")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// encoder appends big-endian protocol primitives
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) int8(v int8)   { e.b = append(e.b, byte(v)) }
+func (e *encoder) int16(v int16) { e.b = binary.BigEndian.AppendUint16(e.b, uint16(v)) }
+func (e *encoder) int32(v int32) { e.b = binary.BigEndian.AppendUint32(e.b, uint32(v)) }
+func (e *encoder) int64(v int64) { e.b = binary.BigEndian.AppendUint64(e.b, uint64(v)) }
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// nullableString encodes an empty string as null
+func (e *encoder) nullableString(s string) {
+	if s == "" {
+		e.int16(-1)
+		return
+	}
+	e.string(s)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// decoder reads big-endian protocol primitives, remembering the first
+// short read
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil || n < 0 || len(d.b) < n {
+		d.err = errMalformed
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+// arrayLen reads an array length, bounded by the remaining bytes so
+// corrupt lengths cannot cause huge allocations
+func (d *decoder) arrayLen() int {
+	n := int(d.int32())
+	if n < 0 {
+		return 0
+	}
+	if n > len(d.b) {
+		d.err = errMalformed
+		return 0
+	}
+	return n
+}
+
+// encodeRequest frames a request with its size and a v1 request header
+func encodeRequest(apiKey, version int16, correlationID int32, clientID string, body []byte) []byte {
+	e := encoder{b: make([]byte, 4, 4+14+len(clientID)+len(body))}
+	e.int16(apiKey)
+	e.int16(version)
+	e.int32(correlationID)
+	e.nullableString(clientID)
+	e.b = append(e.b, body...)
+	binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+	return e.b
+}
+
+// brokerInfo is a broker listed in a metadata response
+type brokerInfo struct {
+	nodeID int32
+	host   string
+	port   int32
+}
+
+func (b brokerInfo) addr() string {
+	return fmt.Sprintf("%s:%d", b.host, b.port)
+}
+
+// topicMetadata lists the leader of each partition of a topic, -1 while
+// a partition has none
+type topicMetadata struct {
+	err     int16
+	leaders []int32
+}
+
+// encodeMetadataRequest asks for the metadata of topics
+func encodeMetadataRequest(topics []string) []byte {
+	var e encoder
+	e.int32(int32(len(topics)))
+	for _, topic := range topics {
+		e.string(topic)
+	}
+	return e.b
+}
+
+// decodeMetadataResponse parses a v1 metadata response
+func decodeMetadataResponse(b []byte) ([]brokerInfo, map[string]topicMetadata, error) {
+	d := decoder{b: b}
+
+	brokers := make([]brokerInfo, d.arrayLen())
+	for i := range brokers {
+		brokers[i].nodeID = d.int32()
+		brokers[i].host = d.string()
+		brokers[i].port = d.int32()
+		d.string() // rack
+	}
+	d.int32() // controller ID
+
+	topics := make(map[string]topicMetadata)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		code := d.int16()
+		name := d.string()
+		d.int8() // is_internal
+
+		partitions := d.arrayLen()
+		leaders := make([]int32, partitions)
+		for j := range leaders {
+			leaders[j] = -1
+		}
+		for j := 0; j < partitions; j++ {
+			partitionErr := d.int16()
+			index := d.int32()
+			leader := d.int32()
+			for k, replicas := 0, d.arrayLen(); k < replicas; k++ {
+				d.int32()
+			}
+			for k, isr := 0, d.arrayLen(); k < isr; k++ {
+				d.int32()
+			}
+			if index >= 0 && int(index) < partitions && partitionErr == errNone {
+				leaders[index] = leader
+			}
+		}
+		topics[name] = topicMetadata{err: code, leaders: leaders}
+	}
+
+	return brokers, topics, d.err
+}
+
+// produceSet holds the records to produce to one broker, by topic and
+// partition
+type produceSet map[string]map[int32][]*message
+
+// encodeProduceRequest builds a v3 produce request with one record batch
+// per partition
+func encodeProduceRequest(set produceSet, acks int16, timeout time.Duration) []byte {
+	var e encoder
+	e.nullableString("") // transactional ID
+	e.int16(acks)
+	e.int32(int32(timeout.Milliseconds()))
+	e.int32(int32(len(set)))
+	for topic, partitions := range set {
+		e.string(topic)
+		e.int32(int32(len(partitions)))
+		for partition, messages := range partitions {
+			e.int32(partition)
+			e.bytes(encodeRecordBatch(messages))
+		}
+	}
+	return e.b
+}
+
+// partitionResult is the outcome of producing to one partition
+type partitionResult struct {
+	topic     string
+	partition int32
+	err       int16
+}
+
+// decodeProduceResponse parses a v3 produce response
+func decodeProduceResponse(b []byte) ([]partitionResult, error) {
+	d := decoder{b: b}
+
+	var results []partitionResult
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		topic := d.string()
+		for j, partitions := 0, d.arrayLen(); j < partitions; j++ {
+			result := partitionResult{topic: topic}
+			result.partition = d.int32()
+			result.err = d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			results = append(results, result)
+		}
+	}
+	d.int32() // throttle time
+
+	return results, d.err
+}
+
+// encodeRecordBatch encodes messages as an uncompressed v2 record batch
+func encodeRecordBatch(messages []*message) []byte {
+	first := messages[0].timestamp.UnixMilli()
+	maxTimestamp := first
+
+	var records []byte
+	for i, m := range messages {
+		ts := m.timestamp.UnixMilli()
+		maxTimestamp = max(maxTimestamp, ts)
+
+		record := []byte{0} // attributes
+		record = binary.AppendVarint(record, ts-first)
+		record = binary.AppendVarint(record, int64(i))
+		record = appendVarBytes(record, m.key)
+		record = appendVarBytes(record, m.value)
+		record = binary.AppendVarint(record, 0) // headers
+
+		records = binary.AppendVarint(records, int64(len(record)))
+		records = append(records, record...)
+	}
+
+	// The CRC covers everything from the attributes on
+	var body encoder
+	body.int16(0) // attributes: no compression, create time
+	body.int32(int32(len(messages) - 1))
+	body.int64(first)
+	body.int64(maxTimestamp)
+	body.int64(-1) // producer ID
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(int32(len(messages)))
+	body.b = append(body.b, records...)
+
+	var e encoder
+	e.int64(0)                              // base offset, assigned by the broker
+	e.int32(int32(4 + 1 + 4 + len(body.b))) // batch length after this field
+	e.int32(-1)                             // partition leader epoch
+	e.int8(2)                               // magic
+	e.b = binary.BigEndian.AppendUint32(e.b, crc32.Checksum(body.b, castagnoli))
+	e.b = append(e.b, body.b...)
+	return e.b
+}
+
+// appendVarBytes appends a varint length-prefixed byte string, -1 for nil
+func appendVarBytes(b, v []byte) []byte {
+	if v == nil {
+		return binary.AppendVarint(b, -1)
+	}
+	b = binary.AppendVarint(b, int64(len(v)))
+	return append(b, v...)
+}
+
+// murmur2 is the hash of the Java client's default partitioner, so keys
+// land on the same partitions as with other Kafka producers
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// partitionFor maps a key to a partition like the Java client does
+func partitionFor(key []byte, partitions int) int32 {
+	return int32(int(murmur2(key)&0x7fffffff) % partitions)
+}
@@ -0,0 +1,647 @@
+package nats
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/sink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Name identifies the publisher in sink health checks
+const Name = "nats"
+
+// reconnectDelay is the minimum time between connection attempts; messages
+// published in between count as delivery errors
+const reconnectDelay = time.Second
+
+// maxLineSize bounds the protocol lines read from the server
+const maxLineSize = 64 * 1024
+
+var (
+	messagesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_nats_messages_total",
+			Help: "Total number of messages published to NATS",
+		},
+		[]string{"subject"},
+	)
+	deliveryErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_nats_delivery_errors_total",
+			Help: "Total number of messages that could not be published to NATS",
+		},
+		[]string{"subject"},
+	)
+	droppedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_nats_dropped_messages_total",
+			Help: "Total number of messages dropped because the NATS queue was full",
+		},
+		[]string{"subject"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(messagesTotal, deliveryErrors, droppedMessages)
+}
+
+var errReconnecting = errors.New("waiting to reconnect to nats server")
+
+// message is a payload queued for a subject
+type message struct {
+	subject   string
+	payload   []byte
+	jetStream bool
+}
+
+// pendingAck is a JetStream publish waiting for the stream's
+// acknowledgement
+type pendingAck struct {
+	subject string
+	sent    time.Time
+}
+
+// pubAck is the reply of a JetStream stream to a publish
+type pubAck struct {
+	Stream string `json:"stream"`
+	Seq    uint64 `json:"seq"`
+	Error  *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// Publisher publishes detection results and completed flow records to NATS
+// subjects. Core NATS messages are delivered at most once; subjects with
+// JetStream enabled wait for the stream to acknowledge persisting each
+// message. Messages are queued without blocking and written by a
+// background goroutine.
+type Publisher struct {
+	config   config.NATSConfig
+	addr     string
+	user     string
+	password string
+	timeout  time.Duration
+
+	queue     chan *message
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// Owned by the run goroutine
+	conn     *conn
+	lastDial time.Time
+	nextSeq  uint64
+
+	mu      sync.Mutex
+	lastErr error
+	pending map[uint64]pendingAck // by reply sequence number
+}
+
+// NewPublisher creates a publisher for the configured server and starts
+// publishing in the background. The server is first contacted when a
+// message is published.
+func NewPublisher(cfg config.NATSConfig) (*Publisher, error) {
+	addr, user, password, err := parseURL(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if !validSubject(cfg.DetectionSubject) {
+		return nil, fmt.Errorf("invalid nats detection subject: %q", cfg.DetectionSubject)
+	}
+	if cfg.FlowSubject != "" && !validSubject(cfg.FlowSubject) {
+		return nil, fmt.Errorf("invalid nats flow subject: %q", cfg.FlowSubject)
+	}
+	if !sink.ValidEncoding(cfg.Encoding) {
+		return nil, fmt.Errorf("unsupported nats encoding: %s", cfg.Encoding)
+	}
+	if cfg.QueueSize < 1 || cfg.Timeout < 1 {
+		return nil, fmt.Errorf("nats queue size and timeout must be positive")
+	}
+
+	p := &Publisher{
+		config:   cfg,
+		addr:     addr,
+		user:     user,
+		password: password,
+		timeout:  time.Duration(cfg.Timeout) * time.Second,
+		queue:    make(chan *message, cfg.QueueSize),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+		pending:  make(map[uint64]pendingAck),
+	}
+	go p.run()
+
+	slog.Info("NATS publisher started",
+		"server", addr,
+		"detection_subject", cfg.DetectionSubject,
+		"flow_subject", cfg.FlowSubject)
+	return p, nil
+}
+
+// parseURL splits a nats://[user:password@]host[:port] URL into the server
+// address and credentials
+func parseURL(raw string) (addr, user, password string, err error) {
+	if raw == "" {
+		return "", "", "", fmt.Errorf("no nats server configured")
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "nats://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid nats url: %w", err)
+	}
+	if u.Scheme != "nats" {
+		return "", "", "", fmt.Errorf("unsupported nats url scheme: %s", u.Scheme)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "4222"
+	}
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	return net.JoinHostPort(u.Hostname(), port), user, password, nil
+}
+
+// validSubject reports whether s is a subject messages can be published
+// to: dot-separated tokens without whitespace or wildcards
+func validSubject(s string) bool {
+	if s == "" || strings.ContainsAny(s, " \t\r\n*>") {
+		return false
+	}
+	for _, token := range strings.Split(s, ".") {
+		if token == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Name implements argus.Sink
+func (p *Publisher) Name() string {
+	return Name
+}
+
+// WriteDetection queues a detection result for the detection subject
+func (p *Publisher) WriteDetection(result *cortex.DetectionResult) {
+	payload, err := sink.EncodeDetection(result, p.config.Encoding)
+	if err != nil {
+		slog.Error("Failed to encode detection for NATS", "flow_id", result.FlowID, "error", err)
+		return
+	}
+	p.enqueue(&message{subject: p.config.DetectionSubject, payload: payload, jetStream: p.config.DetectionJetStream})
+}
+
+// WriteFlow queues a completed flow record for the flow subject, if one is
+// configured
+func (p *Publisher) WriteFlow(record *events.FlowRecord) {
+	if p.config.FlowSubject == "" {
+		return
+	}
+	payload, err := sink.EncodeFlow(record, p.config.Encoding)
+	if err != nil {
+		slog.Error("Failed to encode flow for NATS", "flow_id", record.ID, "error", err)
+		return
+	}
+	p.enqueue(&message{subject: p.config.FlowSubject, payload: payload, jetStream: p.config.FlowJetStream})
+}
+
+// enqueue queues a message, dropping it when the queue is full or the
+// publisher is closed
+func (p *Publisher) enqueue(m *message) {
+	select {
+	case <-p.closing:
+		droppedMessages.WithLabelValues(m.subject).Inc()
+		return
+	default:
+	}
+	select {
+	case p.queue <- m:
+	default:
+		droppedMessages.WithLabelValues(m.subject).Inc()
+	}
+}
+
+// Health returns nil while publishing succeeds, or the last error
+func (p *Publisher) Health() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastErr
+}
+
+func (p *Publisher) setErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastErr = err
+}
+
+// Close publishes the queued messages, waits for outstanding JetStream
+// acknowledgements and closes the connection
+func (p *Publisher) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closing)
+	})
+	<-p.done
+	return nil
+}
+
+// run publishes queued messages until the publisher is closed
+func (p *Publisher) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m := <-p.queue:
+			p.publish(m)
+			if len(p.queue) == 0 {
+				p.flush()
+			}
+		case now := <-ticker.C:
+			p.expireAcks(now.Add(-p.timeout))
+		case <-p.closing:
+			for len(p.queue) > 0 {
+				p.publish(<-p.queue)
+			}
+			p.flush()
+			p.waitForAcks()
+			if p.conn != nil {
+				p.conn.close()
+				p.conn = nil
+			}
+			return
+		}
+	}
+}
+
+// publish writes a message to the connection's buffer
+func (p *Publisher) publish(m *message) {
+	c, err := p.connection()
+	if err != nil {
+		deliveryErrors.WithLabelValues(m.subject).Inc()
+		return
+	}
+	if c.maxPayload > 0 && int64(len(m.payload)) > c.maxPayload {
+		deliveryErrors.WithLabelValues(m.subject).Inc()
+		p.setErr(fmt.Errorf("nats message of %d bytes exceeds the server's maximum payload", len(m.payload)))
+		return
+	}
+
+	var reply string
+	var seq uint64
+	if m.jetStream {
+		p.nextSeq++
+		seq = p.nextSeq
+		reply = c.inbox + "." + strconv.FormatUint(seq, 10)
+
+		p.mu.Lock()
+		p.pending[seq] = pendingAck{subject: m.subject, sent: time.Now()}
+		p.mu.Unlock()
+	}
+
+	if err := c.publish(m.subject, reply, m.payload, p.timeout); err != nil {
+		if m.jetStream {
+			p.mu.Lock()
+			delete(p.pending, seq)
+			p.mu.Unlock()
+		}
+		deliveryErrors.WithLabelValues(m.subject).Inc()
+		p.dropConn(err)
+		return
+	}
+	if !m.jetStream {
+		messagesTotal.WithLabelValues(m.subject).Inc()
+	}
+}
+
+// flush sends the buffered messages to the server
+func (p *Publisher) flush() {
+	if p.conn == nil {
+		return
+	}
+	if err := p.conn.flush(p.timeout); err != nil {
+		p.dropConn(err)
+	}
+}
+
+// dropConn closes a failed connection; the next publish reconnects
+func (p *Publisher) dropConn(err error) {
+	slog.Warn("NATS connection failed", "server", p.addr, "error", err)
+	p.setErr(err)
+	p.conn.close()
+	p.conn = nil
+}
+
+// connection returns the open connection, connecting at most once per
+// reconnectDelay
+func (p *Publisher) connection() (*conn, error) {
+	if p.conn != nil {
+		select {
+		case <-p.conn.dead:
+			p.conn.close()
+			p.conn = nil
+		default:
+			return p.conn, nil
+		}
+	}
+
+	if time.Since(p.lastDial) < reconnectDelay {
+		return nil, errReconnecting
+	}
+	p.lastDial = time.Now()
+
+	c, err := p.connect()
+	if err != nil {
+		slog.Warn("Failed to connect to NATS", "server", p.addr, "error", err)
+		p.setErr(err)
+		return nil, err
+	}
+	p.conn = c
+	p.setErr(nil)
+	return c, nil
+}
+
+// connect opens a connection, authenticates and subscribes to the inbox
+// receiving JetStream acknowledgements
+func (p *Publisher) connect() (*conn, error) {
+	nc, err := net.DialTimeout("tcp", p.addr, p.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats server %s: %w", p.addr, err)
+	}
+	if err := nc.SetDeadline(time.Now().Add(p.timeout)); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	r := bufio.NewReaderSize(nc, maxLineSize)
+
+	line, err := readLine(r)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		nc.Close()
+		return nil, fmt.Errorf("unexpected nats greeting: %q", line)
+	}
+	var info struct {
+		MaxPayload int64 `json:"max_payload"`
+	}
+	if err := json.Unmarshal([]byte(line[len("INFO "):]), &info); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("invalid nats server info: %w", err)
+	}
+
+	connect, err := json.Marshal(struct {
+		Verbose   bool   `json:"verbose"`
+		Pedantic  bool   `json:"pedantic"`
+		Name      string `json:"name,omitempty"`
+		Lang      string `json:"lang"`
+		Version   string `json:"version"`
+		Protocol  int    `json:"protocol"`
+		User      string `json:"user,omitempty"`
+		Pass      string `json:"pass,omitempty"`
+		AuthToken string `json:"auth_token,omitempty"`
+	}{
+		Name:      p.config.Name,
+		Lang:      "go",
+		Version:   "1.0.0",
+		Protocol:  1,
+		User:      p.user,
+		Pass:      p.password,
+		AuthToken: p.config.Token,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	c := &conn{
+		nc:         nc,
+		w:          bufio.NewWriter(nc),
+		inbox:      newInbox(),
+		maxPayload: info.MaxPayload,
+		dead:       make(chan struct{}),
+	}
+	fmt.Fprintf(c.w, "CONNECT %s\r\n", connect)
+	fmt.Fprintf(c.w, "SUB %s.* 1\r\n", c.inbox)
+	c.w.WriteString("PING\r\n")
+	if err := c.w.Flush(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	// The server answers the PING once CONNECT and SUB are processed
+	if line, err = readLine(r); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if line != "PONG" {
+		nc.Close()
+		return nil, fmt.Errorf("nats server rejected connection: %s", strings.TrimPrefix(line, "-ERR "))
+	}
+	if err := nc.SetDeadline(time.Time{}); err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	go p.readLoop(c, r)
+	slog.Info("Connected to NATS", "server", p.addr)
+	return c, nil
+}
+
+// readLoop answers server pings and handles JetStream acknowledgements
+// until the connection fails
+func (p *Publisher) readLoop(c *conn, r *bufio.Reader) {
+	defer close(c.dead)
+
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+
+		switch {
+		case line == "PING":
+			if err := c.pong(p.timeout); err != nil {
+				return
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			err := fmt.Errorf("nats server error: %s", strings.Trim(strings.TrimPrefix(line, "-ERR"), " '"))
+			slog.Warn("NATS server reported an error", "server", p.addr, "error", err)
+			p.setErr(err)
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(line)
+			if len(fields) < 4 {
+				return
+			}
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || size < 0 || size > maxLineSize {
+				return
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			p.handleAck(strings.TrimPrefix(fields[1], c.inbox+"."), payload[:size])
+		}
+	}
+}
+
+// handleAck records the acknowledgement of the JetStream publish with the
+// reply sequence number seq
+func (p *Publisher) handleAck(seq string, payload []byte) {
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending, ok := p.pending[n]
+	if !ok {
+		return
+	}
+	delete(p.pending, n)
+
+	var ack pubAck
+	switch {
+	case json.Unmarshal(payload, &ack) != nil:
+		deliveryErrors.WithLabelValues(pending.subject).Inc()
+		p.lastErr = fmt.Errorf("invalid jetstream acknowledgement: %q", payload)
+	case ack.Error != nil:
+		deliveryErrors.WithLabelValues(pending.subject).Inc()
+		p.lastErr = fmt.Errorf("jetstream error %d: %s", ack.Error.Code, ack.Error.Description)
+	default:
+		messagesTotal.WithLabelValues(pending.subject).Inc()
+		p.lastErr = nil
+	}
+}
+
+// expireAcks counts the JetStream publishes sent before cutoff that are
+// still unacknowledged as failed
+func (p *Publisher) expireAcks(cutoff time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	expired := 0
+	for seq, pending := range p.pending {
+		if pending.sent.Before(cutoff) {
+			deliveryErrors.WithLabelValues(pending.subject).Inc()
+			delete(p.pending, seq)
+			expired++
+		}
+	}
+	if expired > 0 {
+		p.lastErr = fmt.Errorf("%d jetstream acknowledgements timed out", expired)
+	}
+}
+
+// waitForAcks waits up to the timeout for outstanding JetStream
+// acknowledgements, then counts the missing ones as failed
+func (p *Publisher) waitForAcks() {
+	deadline := time.Now().Add(p.timeout)
+	for time.Now().Before(deadline) {
+		p.mu.Lock()
+		outstanding := len(p.pending)
+		p.mu.Unlock()
+		if outstanding == 0 || p.conn == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.expireAcks(time.Now().Add(time.Hour))
+}
+
+// conn is a connection to a NATS server. Writes come from the run
+// goroutine and from the read loop answering pings.
+type conn struct {
+	nc         net.Conn
+	inbox      string // reply subject prefix of JetStream publishes
+	maxPayload int64
+	dead       chan struct{} // closed when the read loop ends
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+}
+
+func (c *conn) publish(subject, reply string, payload []byte, timeout time.Duration) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if err := c.nc.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if reply != "" {
+		fmt.Fprintf(c.w, "PUB %s %s %d\r\n", subject, reply, len(payload))
+	} else {
+		fmt.Fprintf(c.w, "PUB %s %d\r\n", subject, len(payload))
+	}
+	c.w.Write(payload)
+	_, err := c.w.WriteString("\r\n")
+	return err
+}
+
+func (c *conn) flush(timeout time.Duration) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if err := c.nc.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (c *conn) pong(timeout time.Duration) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if err := c.nc.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	c.w.WriteString("PONG\r\n")
+	return c.w.Flush()
+}
+
+func (c *conn) close() {
+	c.nc.Close()
+}
+
+// readLine reads a CRLF-terminated protocol line
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// newInbox returns a unique reply subject prefix
+func newInbox() string {
+	var b [12]byte
+	rand.Read(b[:])
+	return "_INBOX." + hex.EncodeToString(b[:])
+}
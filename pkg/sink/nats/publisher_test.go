@@ -0,0 +1,240 @@
+package nats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// published is a message received by the fake server
+type published struct {
+	subject string
+	reply   string
+	payload []byte
+}
+
+// fakeServer is a NATS server acknowledging publishes to reply subjects
+// like a JetStream stream
+type fakeServer struct {
+	t        *testing.T
+	listener net.Listener
+	token    string
+	ackError string // error description of JetStream acknowledgements
+
+	mu       sync.Mutex
+	messages []published
+	connects []map[string]any
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeServer{t: t, listener: listener}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *fakeServer) url() string {
+	return "nats://" + s.listener.Addr().String()
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+
+	r := bufio.NewReader(conn)
+	subscriptions := make(map[string]string) // subject prefix to sid
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "CONNECT":
+			var options map[string]any
+			require.NoError(s.t, json.Unmarshal([]byte(strings.TrimPrefix(strings.TrimSpace(line), "CONNECT ")), &options))
+			s.mu.Lock()
+			s.connects = append(s.connects, options)
+			s.mu.Unlock()
+			if s.token != "" && options["auth_token"] != s.token {
+				fmt.Fprintf(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "SUB":
+			subscriptions[strings.TrimSuffix(fields[1], "*")] = fields[2]
+		case "PING":
+			fmt.Fprintf(conn, "PONG\r\n")
+		case "PUB":
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			require.NoError(s.t, err)
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			m := published{subject: fields[1], payload: payload[:size]}
+			if len(fields) == 4 {
+				m.reply = fields[2]
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, m)
+			s.mu.Unlock()
+
+			if m.reply != "" {
+				ack := `{"stream":"ARGUS","seq":1}`
+				if s.ackError != "" {
+					ack = fmt.Sprintf(`{"error":{"code":503,"description":%q}}`, s.ackError)
+				}
+				for prefix, sid := range subscriptions {
+					if strings.HasPrefix(m.reply, prefix) {
+						fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", m.reply, sid, len(ack), ack)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (s *fakeServer) received() []published {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]published(nil), s.messages...)
+}
+
+func testConfig(url string) config.NATSConfig {
+	return config.NATSConfig{
+		URL:              url,
+		Name:             "argus-test",
+		Encoding:         "json",
+		DetectionSubject: "argus.detections",
+		FlowSubject:      "argus.flows",
+		QueueSize:        100,
+		Timeout:          2,
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	server := newFakeServer(t)
+	server.token = "secret"
+
+	cfg := testConfig(server.url())
+	cfg.Token = "secret"
+	cfg.DetectionJetStream = true
+	publisher, err := NewPublisher(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		publisher.WriteDetection(&cortex.DetectionResult{FlowID: "flow-" + strconv.Itoa(i), IsBot: true})
+	}
+	publisher.WriteFlow(&events.FlowRecord{ID: "flow-0", Packets: 7})
+	require.NoError(t, publisher.Close())
+	assert.NoError(t, publisher.Health())
+
+	messages := server.received()
+	require.Len(t, messages, 6)
+	for i, m := range messages[:5] {
+		assert.Equal(t, "argus.detections", m.subject)
+		assert.NotEmpty(t, m.reply, "detections wait for JetStream acknowledgements")
+		var result cortex.DetectionResult
+		require.NoError(t, json.Unmarshal(m.payload, &result))
+		assert.Equal(t, "flow-"+strconv.Itoa(i), result.FlowID)
+	}
+	assert.Equal(t, "argus.flows", messages[5].subject)
+	assert.Empty(t, messages[5].reply)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	require.Len(t, server.connects, 1)
+	assert.Equal(t, "argus-test", server.connects[0]["name"])
+}
+
+func TestPublisherJetStreamError(t *testing.T) {
+	server := newFakeServer(t)
+	server.ackError = "no stream for subject"
+
+	cfg := testConfig(server.url())
+	cfg.Encoding = "protobuf"
+	cfg.FlowSubject = ""
+	cfg.DetectionJetStream = true
+	publisher, err := NewPublisher(cfg)
+	require.NoError(t, err)
+
+	publisher.WriteDetection(&cortex.DetectionResult{FlowID: "a-b"})
+	publisher.WriteFlow(&events.FlowRecord{ID: "a-b"})
+	require.NoError(t, publisher.Close())
+	assert.ErrorContains(t, publisher.Health(), "no stream for subject")
+
+	messages := server.received()
+	require.Len(t, messages, 1)
+	var detection events.Detection
+	require.NoError(t, detection.Unmarshal(messages[0].payload))
+	assert.Equal(t, "a-b", detection.FlowID)
+}
+
+func TestPublisherAuthorizationFailure(t *testing.T) {
+	server := newFakeServer(t)
+	server.token = "secret"
+
+	publisher, err := NewPublisher(testConfig(server.url()))
+	require.NoError(t, err)
+
+	publisher.WriteDetection(&cortex.DetectionResult{FlowID: "a-b"})
+	require.NoError(t, publisher.Close())
+	assert.ErrorContains(t, publisher.Health(), "Authorization Violation")
+	assert.Empty(t, server.received())
+}
+
+func TestParseURL(t *testing.T) {
+	addr, user, password, err := parseURL("nats://argus:pw@nats.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "nats.example.com:4222", addr)
+	assert.Equal(t, "argus", user)
+	assert.Equal(t, "pw", password)
+
+	addr, _, _, err = parseURL("localhost:4223")
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:4223", addr)
+
+	_, _, _, err = parseURL("tls://localhost:4222")
+	assert.Error(t, err)
+}
+
+func TestNewPublisherValidation(t *testing.T) {
+	for _, subject := range []string{"", "argus.>", "argus..detections", "argus detections"} {
+		cfg := testConfig("nats://127.0.0.1:4222")
+		cfg.DetectionSubject = subject
+		_, err := NewPublisher(cfg)
+		assert.Error(t, err, subject)
+	}
+
+	cfg := testConfig("")
+	_, err := NewPublisher(cfg)
+	assert.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package syslog
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+)
+
+// CEF header fields identifying the device
+const (
+	cefVendor  = "Protocol Argus"
+	cefProduct = "Cortex"
+	cefVersion = "1.0.0"
+)
+
+// facilities maps syslog facility names to their codes
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Syslog severities of bot and human verdicts
+const (
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// formatCEF renders a detection result as a CEF event. Bot verdicts get a
+// CEF severity of their confidence scaled to 0-10, human verdicts 0.
+func formatCEF(result *cortex.DetectionResult) string {
+	signature, name, severity := "human", "Human traffic", 0
+	if result.IsBot {
+		signature, name = "bot", "Bot traffic detected"
+		severity = int(math.Round(result.Confidence * 10))
+		severity = max(0, min(10, severity))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|%s|%s|%s|%s|%s|%d|",
+		cefHeader(cefVendor), cefHeader(cefProduct), cefHeader(cefVersion),
+		signature, name, severity)
+
+	ext := extensionWriter{b: &b}
+	if result.SrcIP != nil {
+		ext.field("src", result.SrcIP.String())
+	}
+	if result.DstIP != nil {
+		ext.field("dst", result.DstIP.String())
+	}
+	if result.SrcPort != 0 {
+		ext.field("spt", strconv.Itoa(int(result.SrcPort)))
+	}
+	if result.DstPort != 0 {
+		ext.field("dpt", strconv.Itoa(int(result.DstPort)))
+	}
+	if result.Protocol != "" {
+		ext.field("proto", result.Protocol)
+	}
+	timestamp := result.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	ext.field("rt", strconv.FormatInt(timestamp.UnixMilli(), 10))
+	ext.field("cat", signature)
+	ext.field("externalId", result.FlowID)
+	ext.field("cfp1", strconv.FormatFloat(result.Confidence, 'f', 4, 64))
+	ext.field("cfp1Label", "confidence")
+	if result.ModelUsed != "" {
+		ext.field("cs1", result.ModelUsed)
+		ext.field("cs1Label", "model")
+	}
+	if result.SrcGeo != nil && result.SrcGeo.Country != "" {
+		ext.field("cs2", result.SrcGeo.Country)
+		ext.field("cs2Label", "srcCountry")
+	}
+	if result.DstGeo != nil && result.DstGeo.Country != "" {
+		ext.field("cs3", result.DstGeo.Country)
+		ext.field("cs3Label", "dstCountry")
+	}
+	if result.Reasoning != "" {
+		ext.field("msg", result.Reasoning)
+	}
+	if a := result.Aggregate; a != nil {
+		ext.field("cnt", strconv.Itoa(a.Count))
+		ext.field("start", strconv.FormatInt(a.FirstSeen.UnixMilli(), 10))
+		ext.field("end", strconv.FormatInt(a.LastSeen.UnixMilli(), 10))
+		ext.field("cn1", strconv.Itoa(a.Flows))
+		ext.field("cn1Label", "flows")
+	}
+	return b.String()
+}
+
+// cefHeader escapes a CEF header field
+func cefHeader(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+// extensionEscaper escapes CEF extension values
+var extensionEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, "\r\n", `\n`, "\n", `\n`, "\r", `\r`)
+
+// extensionWriter appends space-separated key=value extension fields
+type extensionWriter struct {
+	b     *strings.Builder
+	count int
+}
+
+func (w *extensionWriter) field(key, value string) {
+	if w.count > 0 {
+		w.b.WriteByte(' ')
+	}
+	w.count++
+	w.b.WriteString(key)
+	w.b.WriteByte('=')
+	w.b.WriteString(extensionEscaper.Replace(value))
+}
+
+// formatSyslog wraps a message in an RFC 5424 header
+func formatSyslog(facility, severity int, timestamp time.Time, hostname, msg string) string {
+	if hostname == "" {
+		hostname = "-"
+	}
+	return fmt.Sprintf("<%d>1 %s %s protocol-argus-cortex - detection - %s",
+		facility*8+severity, timestamp.UTC().Format(time.RFC3339Nano), hostname, msg)
+}
@@ -0,0 +1,56 @@
+package syslog
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/enrich"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatCEF(t *testing.T) {
+	timestamp := time.UnixMilli(1700000000123)
+	result := &cortex.DetectionResult{
+		IsBot:      true,
+		Confidence: 0.87,
+		Reasoning:  "uniform timing=automated\nheadless",
+		Timestamp:  timestamp,
+		FlowID:     "10.0.0.1:51000-93.184.216.34:443",
+		ModelUsed:  "svm",
+		SrcIP:      net.ParseIP("10.0.0.1"),
+		DstIP:      net.ParseIP("93.184.216.34"),
+		SrcPort:    51000,
+		DstPort:    443,
+		Protocol:   "TCP",
+		DstGeo:     &enrich.GeoInfo{Country: "US"},
+	}
+
+	assert.Equal(t,
+		"CEF:0|Protocol Argus|Cortex|1.0.0|bot|Bot traffic detected|9|"+
+			"src=10.0.0.1 dst=93.184.216.34 spt=51000 dpt=443 proto=TCP rt=1700000000123 cat=bot "+
+			"externalId=10.0.0.1:51000-93.184.216.34:443 cfp1=0.8700 cfp1Label=confidence "+
+			`cs1=svm cs1Label=model cs3=US cs3Label=dstCountry msg=uniform timing\=automated\nheadless`,
+		formatCEF(result))
+
+	human := formatCEF(&cortex.DetectionResult{Confidence: 0.2, Timestamp: timestamp, FlowID: "a|b"})
+	assert.Equal(t, "CEF:0|Protocol Argus|Cortex|1.0.0|human|Human traffic|0|"+
+		"rt=1700000000123 cat=human externalId=a|b cfp1=0.2000 cfp1Label=confidence", human)
+
+	// Rolled-up detections carry their count and span
+	rolled := formatCEF(&cortex.DetectionResult{Confidence: 0.2, Timestamp: timestamp, FlowID: "a", Aggregate: &cortex.AlertAggregate{
+		Count: 5, Flows: 3, FirstSeen: timestamp.Add(-time.Minute), LastSeen: timestamp,
+	}})
+	assert.Equal(t, "CEF:0|Protocol Argus|Cortex|1.0.0|human|Human traffic|0|"+
+		"rt=1700000000123 cat=human externalId=a cfp1=0.2000 cfp1Label=confidence "+
+		"cnt=5 start=1699999940123 end=1700000000123 cn1=3 cn1Label=flows", rolled)
+}
+
+func TestFormatSyslog(t *testing.T) {
+	timestamp := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "<132>1 2024-03-01T12:00:00Z sensor-1 protocol-argus-cortex - detection - CEF:0|x",
+		formatSyslog(facilities["local0"], severityWarning, timestamp, "sensor-1", "CEF:0|x"))
+	assert.Equal(t, "<14>1 2024-03-01T12:00:00Z - protocol-argus-cortex - detection - m",
+		formatSyslog(facilities["user"], severityInfo, timestamp, "", "m"))
+}
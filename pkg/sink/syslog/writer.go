@@ -0,0 +1,261 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/events"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Name identifies the writer in sink health checks
+const Name = "syslog"
+
+// reconnectDelay is the minimum time between connection attempts; messages
+// written in between count as delivery errors
+const reconnectDelay = time.Second
+
+var (
+	messagesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_syslog_messages_total",
+			Help: "Total number of CEF messages sent to syslog",
+		},
+	)
+	deliveryErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_syslog_delivery_errors_total",
+			Help: "Total number of CEF messages that could not be sent to syslog",
+		},
+	)
+	droppedMessages = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "argus_cortex_syslog_dropped_messages_total",
+			Help: "Total number of CEF messages dropped because the syslog queue was full",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(messagesTotal, deliveryErrors, droppedMessages)
+}
+
+var errReconnecting = errors.New("waiting to reconnect to syslog receiver")
+
+// Writer sends detection results as CEF events to a syslog receiver over
+// UDP, TCP or TLS, so SIEMs can ingest them without a custom parser. TCP
+// and TLS messages are framed by octet counting (RFC 6587). Messages are
+// queued without blocking and sent by a background goroutine.
+type Writer struct {
+	config    config.SyslogConfig
+	facility  int
+	hostname  string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+
+	queue     chan string
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// Owned by the run goroutine
+	conn     net.Conn
+	lastDial time.Time
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// NewWriter creates a writer for the configured receiver and starts
+// sending in the background
+func NewWriter(cfg config.SyslogConfig) (*Writer, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("no syslog address configured")
+	}
+	facility, ok := facilities[cfg.Facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility: %s", cfg.Facility)
+	}
+	if cfg.QueueSize < 1 || cfg.Timeout < 1 {
+		return nil, fmt.Errorf("syslog queue size and timeout must be positive")
+	}
+
+	w := &Writer{
+		config:   cfg,
+		facility: facility,
+		hostname: cfg.Hostname,
+		timeout:  time.Duration(cfg.Timeout) * time.Second,
+		queue:    make(chan string, cfg.QueueSize),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if w.hostname == "" {
+		w.hostname, _ = os.Hostname()
+	}
+
+	switch cfg.Network {
+	case "udp", "tcp":
+	case "tls":
+		host, _, err := net.SplitHostPort(cfg.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid syslog address: %w", err)
+		}
+		w.tlsConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+		if cfg.TLSCA != "" {
+			pem, err := os.ReadFile(cfg.TLSCA)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read syslog CA bundle: %w", err)
+			}
+			w.tlsConfig.RootCAs = x509.NewCertPool()
+			if !w.tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCA)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported syslog network: %s", cfg.Network)
+	}
+
+	go w.run()
+
+	slog.Info("Syslog CEF output started", "address", cfg.Address, "network", cfg.Network)
+	return w, nil
+}
+
+// Name implements argus.Sink
+func (w *Writer) Name() string {
+	return Name
+}
+
+// WriteDetection queues a detection result as a CEF event
+func (w *Writer) WriteDetection(result *cortex.DetectionResult) {
+	severity := severityInfo
+	if result.IsBot {
+		severity = severityWarning
+	}
+	msg := formatSyslog(w.facility, severity, time.Now(), w.hostname, formatCEF(result))
+
+	select {
+	case <-w.closing:
+		droppedMessages.Inc()
+		return
+	default:
+	}
+	select {
+	case w.queue <- msg:
+	default:
+		droppedMessages.Inc()
+	}
+}
+
+// WriteFlow implements argus.Sink; completed flows are not sent to syslog
+func (w *Writer) WriteFlow(*events.FlowRecord) {}
+
+// Health returns nil while sending succeeds, or the last error
+func (w *Writer) Health() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastErr
+}
+
+func (w *Writer) setErr(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastErr = err
+}
+
+// Close sends the queued messages and closes the connection
+func (w *Writer) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.closing)
+	})
+	<-w.done
+	return nil
+}
+
+// run sends queued messages until the writer is closed
+func (w *Writer) run() {
+	defer close(w.done)
+
+	for {
+		select {
+		case msg := <-w.queue:
+			w.send(msg)
+		case <-w.closing:
+			for len(w.queue) > 0 {
+				w.send(<-w.queue)
+			}
+			if w.conn != nil {
+				w.conn.Close()
+				w.conn = nil
+			}
+			return
+		}
+	}
+}
+
+// send writes one message, reconnecting after failures
+func (w *Writer) send(msg string) {
+	conn, err := w.connection()
+	if err != nil {
+		deliveryErrors.Inc()
+		return
+	}
+
+	frame := msg
+	if w.config.Network != "udp" {
+		frame = strconv.Itoa(len(msg)) + " " + msg
+	}
+	if err := conn.SetWriteDeadline(time.Now().Add(w.timeout)); err == nil {
+		_, err = conn.Write([]byte(frame))
+	}
+	if err != nil {
+		deliveryErrors.Inc()
+		slog.Warn("Failed to send to syslog", "address", w.config.Address, "error", err)
+		w.setErr(err)
+		conn.Close()
+		w.conn = nil
+		return
+	}
+	messagesTotal.Inc()
+}
+
+// connection returns the open connection, connecting at most once per
+// reconnectDelay
+func (w *Writer) connection() (net.Conn, error) {
+	if w.conn != nil {
+		return w.conn, nil
+	}
+	if time.Since(w.lastDial) < reconnectDelay {
+		return nil, errReconnecting
+	}
+	w.lastDial = time.Now()
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: w.timeout}
+	if w.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", w.config.Address, w.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(w.config.Network, w.config.Address)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to connect to syslog receiver %s: %w", w.config.Address, err)
+		slog.Warn("Failed to connect to syslog", "error", err)
+		w.setErr(err)
+		return nil, err
+	}
+
+	w.conn = conn
+	w.setErr(nil)
+	return conn, nil
+}
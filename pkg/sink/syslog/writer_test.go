@@ -0,0 +1,120 @@
+package syslog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arvid-berndtsson/protocol-argus-cortex/internal/cortex"
+	"github.com/arvid-berndtsson/protocol-argus-cortex/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig(network, address string) config.SyslogConfig {
+	return config.SyslogConfig{
+		Address:   address,
+		Network:   network,
+		Facility:  "local0",
+		Hostname:  "sensor-1",
+		QueueSize: 10,
+		Timeout:   2,
+	}
+}
+
+func TestWriterUDP(t *testing.T) {
+	receiver, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer receiver.Close()
+
+	writer, err := NewWriter(testConfig("udp", receiver.LocalAddr().String()))
+	require.NoError(t, err)
+	writer.WriteDetection(&cortex.DetectionResult{IsBot: true, Confidence: 0.9, FlowID: "a-b"})
+	require.NoError(t, writer.Close())
+	assert.NoError(t, writer.Health())
+
+	buf := make([]byte, 2048)
+	require.NoError(t, receiver.SetReadDeadline(time.Now().Add(2*time.Second)))
+	n, _, err := receiver.ReadFrom(buf)
+	require.NoError(t, err)
+	msg := string(buf[:n])
+	assert.True(t, strings.HasPrefix(msg, "<132>1 "), msg)
+	assert.Contains(t, msg, " sensor-1 protocol-argus-cortex - detection - CEF:0|Protocol Argus|Cortex|1.0.0|bot|")
+	assert.Contains(t, msg, "externalId=a-b")
+}
+
+func TestWriterTCPOctetCounting(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var messages []string
+		r := bufio.NewReader(conn)
+		for {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				break
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(length))
+			if err != nil {
+				break
+			}
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				break
+			}
+			messages = append(messages, string(msg))
+		}
+		received <- messages
+	}()
+
+	writer, err := NewWriter(testConfig("tcp", listener.Addr().String()))
+	require.NoError(t, err)
+	writer.WriteDetection(&cortex.DetectionResult{IsBot: true, Confidence: 0.9, FlowID: "a-b"})
+	writer.WriteDetection(&cortex.DetectionResult{Confidence: 0.1, FlowID: "c-d"})
+	require.NoError(t, writer.Close())
+
+	messages := <-received
+	require.Len(t, messages, 2)
+	assert.Contains(t, messages[0], "|bot|")
+	assert.True(t, strings.HasPrefix(messages[1], "<134>1 "), messages[1])
+	assert.Contains(t, messages[1], "externalId=c-d")
+}
+
+func TestWriterUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	writer, err := NewWriter(testConfig("tcp", addr))
+	require.NoError(t, err)
+	writer.WriteDetection(&cortex.DetectionResult{FlowID: "a-b"})
+	require.NoError(t, writer.Close())
+	assert.Error(t, writer.Health())
+}
+
+func TestNewWriterValidation(t *testing.T) {
+	_, err := NewWriter(testConfig("udp", ""))
+	assert.Error(t, err)
+
+	_, err = NewWriter(testConfig("sctp", "127.0.0.1:514"))
+	assert.Error(t, err)
+
+	cfg := testConfig("udp", "127.0.0.1:514")
+	cfg.Facility = "local9"
+	_, err = NewWriter(cfg)
+	assert.Error(t, err)
+}